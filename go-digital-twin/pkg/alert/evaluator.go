@@ -0,0 +1,232 @@
+// pkg/alert/evaluator.go
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/scheduler"
+)
+
+// Evaluator metrics
+var (
+	triggeredTotal = metrics.NewCounter("alerts_triggered_total", "Alerts that started firing.")
+	resolvedTotal  = metrics.NewCounter("alerts_resolved_total", "Firing alerts that resolved.")
+	failedTotal    = metrics.NewCounter("alert_evaluation_failures_total", "Alert evaluation runs that failed.")
+)
+
+// Evaluator checks the enabled alert rules against the latest telemetry and records each
+// transition in the rule's per-twin state; firing and resolving write an alert.triggered or
+// alert.resolved outbox event in the same transaction, so each is announced once (and reaches
+// webhooks through the relay). Every transition is saved over the state it was read as (see
+// persistence.AlertStore.SaveAlertState), so evaluators running on several replicas record and
+// announce it once: the others find the state already changed and skip it. A condition must
+// hold on consecutive runs for the rule's duration, so the duration is only as precise as the
+// evaluation interval.
+type Evaluator struct {
+	Store persistence.Store
+	now   func() time.Time
+}
+
+// NewEvaluator creates an evaluator over store.
+func NewEvaluator(store persistence.Store) *Evaluator {
+	return &Evaluator{Store: store, now: func() time.Time { return time.Now().UTC() }}
+}
+
+// Run evaluates every enabled rule once. Returns how many transitions were recorded; a rule
+// that fails is logged and skipped, and the first failure is returned after the others ran.
+func (e *Evaluator) Run(ctx context.Context) (int, error) {
+	rules, err := e.Store.ListAlertRules(ctx)
+	if err != nil {
+		return 0, err
+	}
+	transitions := 0
+	var firstErr error
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		n, err := e.evaluate(ctx, rule)
+		transitions += n
+		if err != nil {
+			if ctx.Err() != nil {
+				return transitions, ctx.Err()
+			}
+			log.Printf("WARN: Failed to evaluate alert rule '%s': %v", rule.ID, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("alert rule '%s': %w", rule.ID, err)
+			}
+		}
+	}
+	return transitions, firstErr
+}
+
+// evaluate checks one rule against every twin in its scope.
+func (e *Evaluator) evaluate(ctx context.Context, rule *persistence.AlertRule) (int, error) {
+	var hold time.Duration
+	if rule.Duration != "" {
+		d, err := time.ParseDuration(rule.Duration)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %w", rule.Duration, err)
+		}
+		hold = d
+	}
+	twins, err := e.scope(ctx, rule)
+	if err != nil {
+		return 0, err
+	}
+	states, err := e.Store.ListAlertStates(ctx, rule.ID)
+	if err != nil {
+		return 0, err
+	}
+	byTwin := make(map[string]*persistence.AlertState, len(states))
+	for _, st := range states {
+		byTwin[st.TwinID] = st
+	}
+
+	transitions := 0
+	now := e.now()
+	for twinID, modelID := range twins {
+		latest, err := e.Store.QueryLatestTelemetry(ctx, twinID, []string{rule.Metric})
+		if err != nil {
+			return transitions, err
+		}
+		holds, value := false, 0.0
+		if rec := latest[rule.Metric]; rec != nil && rec.NumericValue != nil {
+			value = *rec.NumericValue
+			holds = rule.Holds(value)
+		}
+		changed, err := e.step(ctx, rule, hold, twinID, modelID, byTwin[twinID], holds, value, now)
+		if err != nil {
+			return transitions, err
+		}
+		if changed {
+			transitions++
+		}
+		delete(byTwin, twinID)
+	}
+
+	// Twins that left the scope (deleted, or the rule changed) resolve like a condition that
+	// stopped holding; their resolved states are dropped
+	for twinID, st := range byTwin {
+		if st.State == persistence.AlertResolved {
+			if _, err := e.Store.DeleteAlertState(ctx, st); err != nil {
+				return transitions, err
+			}
+			continue
+		}
+		changed, err := e.step(ctx, rule, hold, twinID, rule.ModelID, st, false, st.Value, now)
+		if err != nil {
+			return transitions, err
+		}
+		if changed {
+			transitions++
+		}
+	}
+	return transitions, nil
+}
+
+// scope returns the IDs of the twins the rule applies to, with their model IDs. They are read
+// before any telemetry is queried, as a store may not serve queries while a stream is open.
+func (e *Evaluator) scope(ctx context.Context, rule *persistence.AlertRule) (map[string]string, error) {
+	twins := map[string]string{}
+	if rule.TwinID != "" {
+		twin, err := e.Store.FindTwinByID(ctx, rule.TwinID)
+		if err != nil {
+			if errors.Is(err, persistence.ErrNotFound) {
+				return twins, nil
+			}
+			return nil, err
+		}
+		if rule.ModelID == "" || twin.ModelID == rule.ModelID {
+			twins[twin.ID] = twin.ModelID
+		}
+		return twins, nil
+	}
+	err := e.Store.StreamTwinFields(ctx, []string{"id", "modelId"}, nil, rule.ModelID, func(twin *model.TwinInstance) error {
+		twins[twin.ID] = twin.ModelID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return twins, nil
+}
+
+// step applies one observation to a twin's state st (nil: none) and reports whether it changed
+// the stored state; false also means another evaluator changed it first.
+func (e *Evaluator) step(ctx context.Context, rule *persistence.AlertRule, hold time.Duration, twinID, modelID string,
+	st *persistence.AlertState, holds bool, value float64, now time.Time) (bool, error) {
+	event := func(eventType string) *persistence.OutboxEvent {
+		return &persistence.OutboxEvent{Type: eventType, SubjectID: twinID, ModelID: modelID, RuleID: rule.ID}
+	}
+	state := func(name string, since time.Time) *persistence.AlertState {
+		return &persistence.AlertState{RuleID: rule.ID, TwinID: twinID, State: name, Since: since, Value: value}
+	}
+
+	switch {
+	case holds && (st == nil || st.State == persistence.AlertResolved):
+		if hold > 0 {
+			return e.Store.SaveAlertState(ctx, st, state(persistence.AlertPending, now), nil)
+		}
+		return e.fire(ctx, rule, st, state(persistence.AlertFiring, now), event(persistence.EventAlertTriggered))
+	case holds && st.State == persistence.AlertPending:
+		if now.Sub(st.Since) < hold {
+			return false, nil
+		}
+		return e.fire(ctx, rule, st, state(persistence.AlertFiring, now), event(persistence.EventAlertTriggered))
+	case holds:
+		return false, nil // Already firing
+	case st == nil || st.State == persistence.AlertResolved:
+		return false, nil
+	case st.State == persistence.AlertPending:
+		return e.Store.DeleteAlertState(ctx, st)
+	default: // Firing
+		saved, err := e.Store.SaveAlertState(ctx, st, state(persistence.AlertResolved, now), event(persistence.EventAlertResolved))
+		if err != nil || !saved {
+			return false, err
+		}
+		resolvedTotal.Inc()
+		log.Printf("INFO: Alert rule '%s' resolved for twin '%s' (%s = %g)", rule.ID, twinID, rule.Metric, value)
+		return true, nil
+	}
+}
+
+// fire records a firing state over prev with its alert.triggered event and reports whether it
+// was recorded.
+func (e *Evaluator) fire(ctx context.Context, rule *persistence.AlertRule, prev, st *persistence.AlertState, event *persistence.OutboxEvent) (bool, error) {
+	saved, err := e.Store.SaveAlertState(ctx, prev, st, event)
+	if err != nil || !saved {
+		return false, err
+	}
+	triggeredTotal.Inc()
+	log.Printf("INFO: Alert rule '%s' triggered for twin '%s' (%s = %g %s %g)", rule.ID, st.TwinID, rule.Metric, st.Value, rule.Operator, rule.Value)
+	return true, nil
+}
+
+// Register schedules the evaluator on s as the job "alert_rules" every interval (non-positive
+// disables it). Every replica can run it (see Evaluator).
+func Register(s *scheduler.Scheduler, e *Evaluator, interval time.Duration) {
+	s.Register("alert_rules", interval, func(ctx context.Context) error {
+		transitions, err := e.Run(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				failedTotal.Inc()
+			}
+			return fmt.Errorf("recorded %d alert transitions before failing: %w", transitions, err)
+		}
+		if transitions > 0 {
+			log.Printf("DEBUG: Alert evaluation recorded %d transitions", transitions)
+		}
+		return nil
+	})
+	if interval > 0 {
+		log.Printf("INFO: Evaluating alert rules every %s", interval)
+	}
+}
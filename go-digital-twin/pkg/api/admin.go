@@ -0,0 +1,94 @@
+// pkg/api/admin.go
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// In-process caches dropped by POST /admin/refresh
+const (
+	cacheModels              = "models"              // ListModels/GetModel results
+	cacheTelemetrySummaries  = "telemetrySummaries"  // GET /models/{modelId}/telemetry/summary
+	cacheTelemetryAllowlists = "telemetryAllowlists" // allowedTelemetryNames, name mappings and telemetry enums
+	cacheTelemetryNameSets   = "telemetryNameSets"   // Per-twin names counted by the telemetry name cap
+)
+
+// refreshRequest is the body of POST /admin/refresh; every field is optional.
+type refreshRequest struct {
+	ModelID string     `json:"modelId"`
+	Start   *time.Time `json:"start"`
+	End     *time.Time `json:"end"`
+}
+
+// refreshResponse reports what POST /admin/refresh refreshed.
+type refreshResponse struct {
+	ModelID              string     `json:"modelId,omitempty"`
+	Start                *time.Time `json:"start,omitempty"`
+	End                  *time.Time `json:"end,omitempty"`
+	ContinuousAggregates []string   `json:"continuousAggregates"` // Refreshed for the range; empty without any
+	Caches               []string   `json:"caches"`               // In-process caches dropped
+}
+
+// RefreshCaches handles POST requests to /admin/refresh
+// An operational recovery tool for when cached aggregates drift from the stored data, e.g. after
+// a bulk backfill or manual changes to the database: it refreshes the TimescaleDB continuous
+// aggregates over telemetry for the range [start, end) (RFC 3339; either may be omitted for an
+// open range) and drops this replica's in-process caches, so they are reloaded from the store.
+// With "modelId", only that model's telemetry summaries and telemetry allowlists are dropped;
+// the model cache and the per-twin name sets are always dropped entirely (they reload lazily),
+// and continuous aggregates cover every twin. The body may be empty. Other replicas keep their
+// caches until they expire. Requires an unrestricted API key.
+func (a *API) RefreshCaches(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	req.ModelID = strings.TrimSpace(req.ModelID)
+	if req.Start != nil && req.End != nil && !req.Start.Before(*req.End) {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "start must be before end")
+		return
+	}
+
+	response := refreshResponse{ModelID: req.ModelID, Start: req.Start, End: req.End, ContinuousAggregates: []string{}}
+	if refresher, ok := persistence.Underlying(a.Store).(persistence.AggregateRefresher); ok {
+		refreshed, err := refresher.RefreshContinuousAggregates(r.Context(), req.Start, req.End)
+		if err != nil {
+			log.Printf("ERROR: Failed to refresh continuous aggregates (%d refreshed before): %v", len(refreshed), err)
+			writeStoreError(w, err, resourceTelemetry, "Failed to refresh continuous aggregates")
+			return
+		}
+		response.ContinuousAggregates = refreshed
+	}
+
+	a.models.invalidate()
+	a.summaries.invalidate(req.ModelID)
+	if req.ModelID != "" {
+		a.TelemetryAllowlist.Invalidate(req.ModelID)
+	} else {
+		a.TelemetryAllowlist.InvalidateAll()
+	}
+	a.NameLimit.ForgetAll()
+	response.Caches = []string{cacheModels, cacheTelemetrySummaries, cacheTelemetryAllowlists, cacheTelemetryNameSets}
+
+	log.Printf("INFO: Refreshed %d continuous aggregates and dropped cached %s (model %q) for %s",
+		len(response.ContinuousAggregates), strings.Join(response.Caches, ", "), req.ModelID, describeActor(r.Context()))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("ERROR: Failed to encode refresh response: %v", err)
+	}
+}
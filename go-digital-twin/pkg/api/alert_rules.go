@@ -0,0 +1,252 @@
+// pkg/api/alert_rules.go
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// alertRuleRequest is the body of POST /alert-rules and PUT /alert-rules/{ruleId}.
+type alertRuleRequest struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description"`
+	ModelID     string   `json:"modelId"`
+	TwinID      string   `json:"twinId"`
+	Metric      string   `json:"metric"`
+	Operator    string   `json:"operator"`
+	Value       *float64 `json:"value"`
+	Duration    string   `json:"duration"`
+	Enabled     *bool    `json:"enabled"` // Absent enables the rule
+}
+
+// decodeAlertRuleRequest reads and validates a rule body, including that the twin and model it
+// references exist. On error it writes the response and returns false.
+func (a *API) decodeAlertRuleRequest(w http.ResponseWriter, r *http.Request) (*alertRuleRequest, bool) {
+	var req alertRuleRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
+		return nil, false
+	}
+	defer r.Body.Close()
+
+	req.ModelID = strings.TrimSpace(req.ModelID)
+	req.TwinID = strings.TrimSpace(req.TwinID)
+	req.Metric = strings.TrimSpace(req.Metric)
+	req.Duration = strings.TrimSpace(req.Duration)
+	switch {
+	case req.ModelID == "" && req.TwinID == "":
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "At least one of twinId and modelId is required")
+		return nil, false
+	case req.Metric == "":
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Missing required field: metric")
+		return nil, false
+	case len(req.Metric) > model.MaxTelemetryNameLength:
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("metric must be at most %d characters", model.MaxTelemetryNameLength))
+		return nil, false
+	case !isAlertOperator(req.Operator):
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("Invalid operator %q (allowed: %s)", req.Operator, strings.Join(persistence.AlertOperators, ", ")))
+		return nil, false
+	case req.Value == nil:
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Missing required field: value")
+		return nil, false
+	case math.IsNaN(*req.Value) || math.IsInf(*req.Value, 0):
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "value must be a finite number")
+		return nil, false
+	}
+	if req.Duration != "" {
+		if d, err := time.ParseDuration(req.Duration); err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("Invalid duration %q: use a duration such as \"30s\" or \"5m\"", req.Duration))
+			return nil, false
+		}
+	}
+
+	ctx := r.Context()
+	if req.TwinID != "" {
+		twin, err := a.Store.FindTwinByID(ctx, req.TwinID)
+		if err != nil {
+			if errors.Is(err, persistence.ErrNotFound) {
+				writeError(w, http.StatusUnprocessableEntity, CodeTwinReferenceInvalid, fmt.Sprintf("Referenced twinId '%s' not found", req.TwinID))
+			} else {
+				log.Printf("ERROR: Failed to check twin existence: %v", err)
+				writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to validate twinId")
+			}
+			return nil, false
+		}
+		if req.ModelID != "" && twin.ModelID != req.ModelID {
+			writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("Twin '%s' is not of model '%s'", req.TwinID, req.ModelID))
+			return nil, false
+		}
+	} else if _, err := a.Store.FindModelByID(ctx, req.ModelID); err != nil {
+		if errors.Is(err, persistence.ErrNotFound) {
+			writeError(w, http.StatusUnprocessableEntity, CodeModelReferenceInvalid, fmt.Sprintf("Referenced modelId '%s' not found", req.ModelID))
+		} else {
+			log.Printf("ERROR: Failed to check model existence: %v", err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to validate modelId")
+		}
+		return nil, false
+	}
+	return &req, true
+}
+
+// isAlertOperator reports whether op is one of persistence.AlertOperators.
+func isAlertOperator(op string) bool {
+	for _, o := range persistence.AlertOperators {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// apply copies the request's fields onto rule.
+func (req *alertRuleRequest) apply(rule *persistence.AlertRule) {
+	rule.Description = req.Description
+	rule.ModelID = req.ModelID
+	rule.TwinID = req.TwinID
+	rule.Metric = req.Metric
+	rule.Operator = req.Operator
+	rule.Value = *req.Value
+	rule.Duration = req.Duration
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+}
+
+// writeAlertJSON writes v as a JSON response; what names the response in the error log.
+func writeAlertJSON(w http.ResponseWriter, status int, v interface{}, what string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("ERROR: Failed to encode %s response: %v", what, err)
+	}
+}
+
+// CreateAlertRule handles POST requests to /alert-rules
+// Adds a threshold rule: the latest numeric "metric" of the twin "twinId", or of every twin of
+// "modelId", is compared as value "operator" "value" on every evaluation, and once that held for
+// "duration" (a Go duration; absent fires at once) an alert.triggered outbox event is written
+// for the twin, then alert.resolved once it no longer holds. Events reach webhook subscriptions
+// like any other. Requires an unrestricted API key.
+func (a *API) CreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	req, ok := a.decodeAlertRuleRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.ID == "" {
+		req.ID = "rule-" + uuid.NewString()
+	}
+	now := time.Now().UTC()
+	rule := &persistence.AlertRule{ID: req.ID, CreatedAt: now, UpdatedAt: now}
+	req.apply(rule)
+
+	if err := a.Store.CreateAlertRule(r.Context(), rule); err != nil {
+		log.Printf("ERROR: Failed to create alert rule: %v", err)
+		writeStoreError(w, err, resourceAlertRule, "Failed to create alert rule")
+		return
+	}
+
+	log.Printf("INFO: Created alert rule: ID=%s, %s %s %g for %s", rule.ID, rule.Metric, rule.Operator, rule.Value, describeActor(r.Context()))
+	a.setLocation(w, "alert-rules", rule.ID)
+	writeAlertJSON(w, http.StatusCreated, rule, "create alert rule")
+}
+
+// GetAlertRule handles GET requests to /alert-rules/{ruleId}
+func (a *API) GetAlertRule(w http.ResponseWriter, r *http.Request) {
+	ruleID := chi.URLParam(r, "ruleId")
+	rule, err := a.Store.FindAlertRuleByID(r.Context(), ruleID)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find alert rule '%s': %v", ruleID, err)
+		writeStoreError(w, err, resourceAlertRule, "Failed to retrieve alert rule")
+		return
+	}
+	writeAlertJSON(w, http.StatusOK, rule, "get alert rule")
+}
+
+// ListAlertRules handles GET requests to /alert-rules
+func (a *API) ListAlertRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := a.Store.ListAlertRules(r.Context())
+	if err != nil {
+		log.Printf("ERROR: Failed to list alert rules: %v", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve alert rules")
+		return
+	}
+	writeAlertJSON(w, http.StatusOK, rules, "list alert rules")
+}
+
+// UpdateAlertRule handles PUT requests to /alert-rules/{ruleId} (full replacement)
+// The rule's states are kept and the next evaluation applies the new condition to them: a
+// firing alert that no longer holds resolves, one for a twin out of the new scope too.
+func (a *API) UpdateAlertRule(w http.ResponseWriter, r *http.Request) {
+	ruleID := chi.URLParam(r, "ruleId")
+	req, ok := a.decodeAlertRuleRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.ID != "" && req.ID != ruleID {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Alert rule ID in payload does not match ID in URL")
+		return
+	}
+
+	ctx := r.Context()
+	rule := &persistence.AlertRule{ID: ruleID}
+	req.apply(rule)
+	if err := a.Store.UpdateAlertRule(ctx, rule); err != nil {
+		log.Printf("DEBUG: Failed to update alert rule '%s': %v", ruleID, err)
+		writeStoreError(w, err, resourceAlertRule, "Failed to update alert rule")
+		return
+	}
+
+	// Re-fetch to return the stored state (including createdAt and the new updatedAt)
+	updated, err := a.Store.FindAlertRuleByID(ctx, ruleID)
+	if err != nil {
+		log.Printf("ERROR: Failed to retrieve updated alert rule '%s' after update: %v", ruleID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve alert rule after update")
+		return
+	}
+
+	log.Printf("INFO: Updated alert rule: ID=%s for %s", ruleID, describeActor(ctx))
+	writeAlertJSON(w, http.StatusOK, updated, "update alert rule")
+}
+
+// DeleteAlertRule handles DELETE requests to /alert-rules/{ruleId}
+// Its states are deleted with it; firing alerts are not resolved.
+func (a *API) DeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	ruleID := chi.URLParam(r, "ruleId")
+	if err := a.Store.DeleteAlertRule(r.Context(), ruleID); err != nil {
+		log.Printf("DEBUG: Failed to delete alert rule '%s': %v", ruleID, err)
+		writeStoreError(w, err, resourceAlertRule, "Failed to delete alert rule")
+		return
+	}
+	log.Printf("INFO: Deleted alert rule: ID=%s for %s", ruleID, describeActor(r.Context()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListAlertStates handles GET requests to /alert-rules/{ruleId}/states
+// Lists the twins the rule is pending, firing or resolved for, ordered by twin ID.
+func (a *API) ListAlertStates(w http.ResponseWriter, r *http.Request) {
+	ruleID := chi.URLParam(r, "ruleId")
+	ctx := r.Context()
+	if _, err := a.Store.FindAlertRuleByID(ctx, ruleID); err != nil {
+		log.Printf("DEBUG: Failed to find alert rule '%s': %v", ruleID, err)
+		writeStoreError(w, err, resourceAlertRule, "Failed to retrieve alert rule")
+		return
+	}
+	states, err := a.Store.ListAlertStates(ctx, ruleID)
+	if err != nil {
+		log.Printf("ERROR: Failed to list states of alert rule '%s': %v", ruleID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve alert states")
+		return
+	}
+	writeAlertJSON(w, http.StatusOK, states, "list alert states")
+}
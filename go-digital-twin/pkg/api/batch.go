@@ -0,0 +1,100 @@
+// pkg/api/batch.go
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// DefaultMaxBatchSize is how many items one batch request may carry when Options.MaxBatchSize
+// is unset.
+const DefaultMaxBatchSize = 1000
+
+// errBatchTooLarge is returned by decodeTelemetryBatch when the array is longer than allowed.
+var errBatchTooLarge = errors.New("batch too large")
+
+// maxBatchSize is the cap on the items of one batch request (twins, telemetry records, ...).
+func (a *API) maxBatchSize() int {
+	if a.MaxBatchSize > 0 {
+		return a.MaxBatchSize
+	}
+	return DefaultMaxBatchSize
+}
+
+// checkBatchSize rejects a batch of n items beyond maxBatchSize with 400 BATCH_TOO_LARGE
+// (the error response is written and false returned). items names them in the message ("twins").
+func (a *API) checkBatchSize(w http.ResponseWriter, n int, items string) bool {
+	if limit := a.maxBatchSize(); n > limit {
+		writeError(w, http.StatusBadRequest, CodeBatchTooLarge, fmt.Sprintf("Too many %s: %d in the batch, at most %d per request", items, n, limit))
+		return false
+	}
+	return true
+}
+
+// writeBatchTooLarge is checkBatchSize's response for a batch whose length isn't known because
+// decoding stopped at the cap.
+func (a *API) writeBatchTooLarge(w http.ResponseWriter, items string) {
+	limit := a.maxBatchSize()
+	writeError(w, http.StatusBadRequest, CodeBatchTooLarge, fmt.Sprintf("Too many %s: more than %d in the batch, at most %d per request", items, limit, limit))
+}
+
+// decodeTelemetryBatch decodes a JSON array of telemetry points, failing with errBatchTooLarge
+// as soon as it has more than limit: an oversized batch is rejected without being read into
+// memory first. null decodes to no points.
+func decodeTelemetryBatch(decoder *json.Decoder, limit int) ([]telemetryPoint, error) {
+	var points []telemetryPoint
+	err := decodeBatchArray(decoder, limit, func() error {
+		var p telemetryPoint
+		if err := decoder.Decode(&p); err != nil {
+			return err
+		}
+		points = append(points, p)
+		return nil
+	})
+	return points, err
+}
+
+// startsWithArray reports whether the JSON body br holds is an array, for requests that take
+// either one item or an array of them. Only leading whitespace is peeked at; nothing is consumed.
+func startsWithArray(br *bufio.Reader) bool {
+	for n := 1; ; n++ {
+		b, err := br.Peek(n)
+		if err != nil {
+			return false // Empty body or endless whitespace: the decoder reports it
+		}
+		switch b[n-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b[n-1] == '['
+	}
+}
+
+// decodeBatchArray reads a JSON array calling decodeItem for each element, failing with
+// errBatchTooLarge once it has more than limit (see decodeTelemetryBatch). null is an empty array.
+func decodeBatchArray(decoder *json.Decoder, limit int, decodeItem func() error) error {
+	tok, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected a JSON array, got %v", tok)
+	}
+
+	for n := 0; decoder.More(); n++ {
+		if n == limit {
+			return errBatchTooLarge
+		}
+		if err := decodeItem(); err != nil {
+			return fmt.Errorf("record at index %d: %w", n, err)
+		}
+	}
+	_, err = decoder.Token() // The closing ']'
+	return err
+}
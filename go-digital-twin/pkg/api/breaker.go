@@ -0,0 +1,298 @@
+// pkg/api/breaker.go
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+)
+
+// Defaults of the BreakerPolicy fields left zero
+const (
+	DefaultBreakerWindow         = 10 * time.Second
+	DefaultBreakerMinRequests    = 20
+	DefaultBreakerOpenDuration   = 5 * time.Second
+	DefaultBreakerHalfOpenProbes = 3
+)
+
+// breakerWindowBuckets is the resolution of the breaker's rolling window.
+const breakerWindowBuckets = 10
+
+// bulkTelemetryQueryPath is POST /telemetry/query, a read despite its method: the write breaker
+// neither sheds nor measures it (its long timeout would make every slow report count against the
+// writes).
+const bulkTelemetryQueryPath = "/api/v1/telemetry/query"
+
+// Breaker states, as reported by /readyz and the write_breaker_state metric
+const (
+	breakerClosed   = "closed"    // Writes pass; their outcomes are measured
+	breakerHalfOpen = "half_open" // A few trial writes pass to test whether the store recovered
+	breakerOpen     = "open"      // Writes are rejected with 503 until the open duration ends
+)
+
+// Write breaker metrics
+var (
+	writeBreakerState    = metrics.NewGauge("write_breaker_state", "State of the write circuit breaker: 0 closed, 1 half-open, 2 open.")
+	writeBreakerTrips    = metrics.NewCounter("write_breaker_trips_total", "Times the write circuit breaker opened.")
+	writeBreakerRejected = metrics.NewCounter("write_breaker_rejected_total", "Write requests rejected with 503 SERVICE_UNAVAILABLE while the write circuit breaker was open.")
+)
+
+// BreakerPolicy configures the write circuit breaker, which sheds writes while the store is
+// degraded instead of piling more work onto it. Write requests (every method but GET, HEAD and
+// OPTIONS, except POST /telemetry/query) are measured: a failure is a 5xx response or, with
+// SlowThreshold, a response slower than it. Once FailureRatio of at least MinRequests writes in
+// Window failed, the breaker opens and writes get 503 SERVICE_UNAVAILABLE with Retry-After for
+// OpenDuration; reads are still served. It then turns half-open: HalfOpenProbes trial writes
+// pass, and if all succeed it closes again, while any failure reopens it. The zero value
+// disables the breaker.
+type BreakerPolicy struct {
+	FailureRatio   float64       // Share of failed writes that opens the breaker, e.g. 0.5; zero disables it
+	MinRequests    int           // Writes in Window before the ratio is judged (default 20)
+	Window         time.Duration // Rolling window the ratio is computed over (default 10s)
+	SlowThreshold  time.Duration // Writes slower than this count as failures; zero only counts 5xx
+	OpenDuration   time.Duration // How long writes are rejected before trial writes (default 5s)
+	HalfOpenProbes int           // Trial writes that must succeed to close (default 3)
+}
+
+// breakerBucket counts the writes of one slice of the rolling window.
+type breakerBucket struct {
+	slot     int64 // Which slice (time / width) the counts belong to
+	total    int
+	failures int
+}
+
+// writeBreaker is the circuit breaker behind BreakerPolicy.
+type writeBreaker struct {
+	policy BreakerPolicy
+	width  time.Duration // Of one bucket
+
+	mu        sync.Mutex
+	state     string
+	since     time.Time // Of the current state
+	buckets   [breakerWindowBuckets]breakerBucket
+	openUntil time.Time
+	trials    int // Trial writes admitted in the current half-open period
+	successes int // Of those, the ones that succeeded
+}
+
+// newWriteBreaker creates the breaker for policy, or returns nil when policy disables it.
+func newWriteBreaker(policy BreakerPolicy) *writeBreaker {
+	if policy.FailureRatio <= 0 {
+		return nil
+	}
+	if policy.MinRequests <= 0 {
+		policy.MinRequests = DefaultBreakerMinRequests
+	}
+	if policy.Window <= 0 {
+		policy.Window = DefaultBreakerWindow
+	}
+	if policy.OpenDuration <= 0 {
+		policy.OpenDuration = DefaultBreakerOpenDuration
+	}
+	if policy.HalfOpenProbes <= 0 {
+		policy.HalfOpenProbes = DefaultBreakerHalfOpenProbes
+	}
+	log.Printf("INFO: Write circuit breaker enabled: opens at %.0f%% failed writes (of at least %d in %s, slower than %s counts as failed), for %s",
+		policy.FailureRatio*100, policy.MinRequests, policy.Window, policy.SlowThreshold, policy.OpenDuration)
+	return &writeBreaker{
+		policy: policy,
+		width:  policy.Window / breakerWindowBuckets,
+		state:  breakerClosed,
+		since:  time.Now(),
+	}
+}
+
+// allow reports whether a write may proceed at now and, if not, how long until writes are tried
+// again. trial is true for the half-open trial writes.
+func (b *writeBreaker) allow(now time.Time) (ok, trial bool, retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen && !now.Before(b.openUntil) {
+		b.setStateLocked(breakerHalfOpen, now)
+		b.trials, b.successes = 0, 0
+	}
+	switch b.state {
+	case breakerOpen:
+		return false, false, b.openUntil.Sub(now)
+	case breakerHalfOpen:
+		if b.trials >= b.policy.HalfOpenProbes {
+			return false, false, b.policy.OpenDuration // Trials are under way; their outcome decides
+		}
+		b.trials++
+		return true, true, 0
+	default:
+		return true, false, 0
+	}
+}
+
+// record notes the outcome of a write admitted at the given state (trial or not) and moves the
+// breaker on when it decides the state.
+func (b *writeBreaker) record(now time.Time, trial, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		if b.state != breakerHalfOpen {
+			return // The breaker moved on while the trial ran
+		}
+		if failed {
+			b.openLocked(now, "a trial write failed")
+			return
+		}
+		if b.successes++; b.successes >= b.policy.HalfOpenProbes {
+			b.setStateLocked(breakerClosed, now)
+			b.buckets = [breakerWindowBuckets]breakerBucket{}
+		}
+		return
+	}
+	if b.state != breakerClosed {
+		return // Admitted before the breaker opened; the window restarts on closing anyway
+	}
+
+	slot := now.UnixNano() / int64(b.width)
+	bucket := &b.buckets[slot%breakerWindowBuckets]
+	if bucket.slot != slot {
+		*bucket = breakerBucket{slot: slot}
+	}
+	bucket.total++
+	if failed {
+		bucket.failures++
+	}
+
+	total, failures := b.countsLocked(slot)
+	if total >= b.policy.MinRequests && float64(failures) >= b.policy.FailureRatio*float64(total) {
+		b.openLocked(now, fmt.Sprintf("%d of the last %d writes failed", failures, total))
+	}
+}
+
+// countsLocked sums the buckets of the window ending in slot; b.mu must be held.
+func (b *writeBreaker) countsLocked(slot int64) (total, failures int) {
+	for _, bucket := range b.buckets {
+		if bucket.slot > slot-breakerWindowBuckets {
+			total += bucket.total
+			failures += bucket.failures
+		}
+	}
+	return total, failures
+}
+
+// openLocked opens the breaker for the open duration; b.mu must be held.
+func (b *writeBreaker) openLocked(now time.Time, reason string) {
+	b.setStateLocked(breakerOpen, now)
+	b.openUntil = now.Add(b.policy.OpenDuration)
+	writeBreakerTrips.Inc()
+	log.Printf("WARN: Write circuit breaker opened (%s); rejecting writes for %s", reason, b.policy.OpenDuration)
+}
+
+// setStateLocked switches the state and its metric; b.mu must be held.
+func (b *writeBreaker) setStateLocked(state string, now time.Time) {
+	if state == breakerClosed && b.state != breakerClosed {
+		log.Printf("INFO: Write circuit breaker closed after %d successful trial writes", b.successes)
+	} else if state == breakerHalfOpen {
+		log.Printf("INFO: Write circuit breaker half-open; letting %d trial writes through", b.policy.HalfOpenProbes)
+	}
+	b.state, b.since = state, now
+	switch state {
+	case breakerOpen:
+		writeBreakerState.Set(2)
+	case breakerHalfOpen:
+		writeBreakerState.Set(1)
+	default:
+		writeBreakerState.Set(0)
+	}
+}
+
+// status describes the breaker for /readyz.
+func (b *writeBreaker) status(now time.Time) map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.state
+	if state == breakerOpen && !now.Before(b.openUntil) {
+		state = breakerHalfOpen // Becomes so with the next write
+	}
+	total, failures := b.countsLocked(now.UnixNano() / int64(b.width))
+	status := map[string]interface{}{
+		"state":          state,
+		"since":          b.since.UTC().Format(time.RFC3339),
+		"recentWrites":   total,
+		"recentFailures": failures,
+	}
+	if state == breakerOpen {
+		status["retryAfterSeconds"] = retryAfterSeconds(b.openUntil.Sub(now))
+	}
+	return status
+}
+
+// middleware sheds and measures write requests. A nil breaker passes everything through.
+func (b *writeBreaker) middleware(next http.Handler) http.Handler {
+	if b == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isReadMethod(r.Method) || strings.HasSuffix(r.URL.Path, bulkTelemetryQueryPath) || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		started := time.Now()
+		ok, trial, retryAfter := b.allow(started)
+		if !ok {
+			writeBreakerRejected.Inc()
+			w.Header().Set("Retry-After", fmt.Sprint(retryAfterSeconds(retryAfter)))
+			writeError(w, http.StatusServiceUnavailable, CodeServiceUnavailable, "The database is degraded; writes are paused, retry later")
+			return
+		}
+
+		// A panicking handler counts as failed (and a trial write always reports back)
+		failed := true
+		defer func() { b.record(time.Now(), trial, failed) }()
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		elapsed := time.Since(started)
+		failed = sw.status >= 500 || (b.policy.SlowThreshold > 0 && elapsed > b.policy.SlowThreshold)
+	})
+}
+
+// retryAfterSeconds rounds d up to whole seconds for a Retry-After header (at least 1).
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Max(1, math.Ceil(d.Seconds())))
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush passes through to the underlying writer (needed for streamed responses).
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
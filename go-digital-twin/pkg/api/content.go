@@ -0,0 +1,133 @@
+// pkg/api/content.go
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Media types used for content negotiation. JSON is always the default.
+const (
+	mediaTypeJSON   = "application/json"
+	mediaTypeYAML   = "application/yaml"
+	mediaTypeNDJSON = "application/x-ndjson" // Streamed lists: one JSON document per line
+)
+
+// isYAMLMediaType accepts the registered YAML type plus the common unofficial spellings.
+func isYAMLMediaType(mt string) bool {
+	switch mt {
+	case mediaTypeYAML, "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	}
+	return false
+}
+
+// requestIsYAML reports whether the request body is declared as YAML via Content-Type.
+func requestIsYAML(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && isYAMLMediaType(mt)
+}
+
+// isNDJSONMediaType accepts the de facto NDJSON type and its unprefixed spelling.
+func isNDJSONMediaType(mt string) bool {
+	return mt == mediaTypeNDJSON || mt == "application/ndjson"
+}
+
+// prefersYAML reports whether the Accept header ranks a YAML type above JSON.
+// Ties, wildcards and missing/unparseable headers all resolve to JSON.
+func prefersYAML(r *http.Request) bool {
+	return prefersOverJSON(r, isYAMLMediaType)
+}
+
+// prefersNDJSON reports whether the Accept header ranks an NDJSON type above JSON, with the same
+// tie-breaking as prefersYAML. Only list endpoints offer NDJSON.
+func prefersNDJSON(r *http.Request) bool {
+	return prefersOverJSON(r, isNDJSONMediaType)
+}
+
+// prefersOverJSON reports whether the Accept header ranks a media type matching alt above JSON.
+func prefersOverJSON(r *http.Request, alt func(mt string) bool) bool {
+	type candidate struct {
+		mediaType string
+		q         float64
+		pos       int
+	}
+	var candidates []candidate
+	for i, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(qs, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{mediaType: mt, q: q, pos: i})
+		}
+	}
+	// Highest q first; earlier entries win ties
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	for _, c := range candidates {
+		switch {
+		case alt(c.mediaType):
+			return true
+		case c.mediaType == mediaTypeJSON || c.mediaType == "*/*" || c.mediaType == "application/*":
+			return false
+		}
+	}
+	return false
+}
+
+// decodeBody decodes a JSON (default) or YAML (Content-Type: application/yaml) request body into v.
+// Unknown fields are rejected in both formats.
+func decodeBody(r *http.Request, v interface{}) error {
+	if requestIsYAML(r) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		return yaml.UnmarshalStrict(data, v)
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// writeNegotiated writes v as YAML when the client's Accept header prefers it, otherwise as JSON.
+// Error responses are not negotiated; they always use the JSON error envelope.
+func writeNegotiated(w http.ResponseWriter, r *http.Request, status int, v interface{}, what string) {
+	w.Header().Add("Vary", "Accept")
+	if prefersYAML(r) {
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			log.Printf("ERROR: Failed to encode %s response as YAML: %v", what, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response as YAML")
+			return
+		}
+		w.Header().Set("Content-Type", mediaTypeYAML)
+		w.WriteHeader(status)
+		if _, err := w.Write(data); err != nil {
+			log.Printf("ERROR: Failed to write %s response: %v", what, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaTypeJSON)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("ERROR: Failed to encode %s response: %v", what, err)
+	}
+}
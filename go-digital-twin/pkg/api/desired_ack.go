@@ -0,0 +1,83 @@
+// pkg/api/desired_ack.go
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// Limits for ?waitForAck= on desired property writes
+const (
+	maxDesiredAckWait      = 5 * time.Minute        // Longest accepted wait (the route timeout may cut it shorter)
+	desiredAckPollInterval = 250 * time.Millisecond // How often the twin is re-read while waiting
+	desiredAckDeadlineRoom = time.Second            // Kept free before the request deadline to send the 202
+)
+
+// parseWaitForAck parses ?waitForAck= (a duration such as 30s); zero means don't wait.
+// Writes 400 and returns false when it is invalid.
+func parseWaitForAck(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	raw := r.URL.Query().Get("waitForAck")
+	if raw == "" {
+		return 0, true
+	}
+	wait, err := time.ParseDuration(raw)
+	if err != nil || wait <= 0 || wait > maxDesiredAckWait {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid waitForAck parameter: must be a positive duration of at most %s (e.g., 30s)", maxDesiredAckWait))
+		return 0, false
+	}
+	return wait, true
+}
+
+// pendingDesired lists (sorted) the keys of desired whose reported value differs.
+func pendingDesired(twin *model.TwinInstance, desired map[string]interface{}) []string {
+	pending := []string{}
+	for key, want := range desired {
+		got, ok := twin.ReportedProperties[key]
+		if !ok || !reflect.DeepEqual(got, want) {
+			pending = append(pending, key)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// waitForDesiredAck re-reads the twin until every key of desired is reported with the same value,
+// wait elapses or ctx ends. Devices report through the store (possibly via another replica or
+// service), so polling is what sees every write. Returns the last twin read and the keys still
+// pending (empty once converged).
+func (a *API) waitForDesiredAck(ctx context.Context, twin *model.TwinInstance, desired map[string]interface{}, wait time.Duration) (*model.TwinInstance, []string, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if room := time.Until(deadline) - desiredAckDeadlineRoom; room < wait {
+			wait = room // Answer 202 before the route timeout answers 504
+		}
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(desiredAckPollInterval)
+	defer ticker.Stop()
+
+	pending := pendingDesired(twin, desired)
+	for len(pending) > 0 {
+		select {
+		case <-timer.C:
+			return twin, pending, nil
+		case <-ctx.Done():
+			return twin, pending, ctx.Err()
+		case <-ticker.C:
+		}
+
+		latest, err := a.Store.FindTwinByID(ctx, twin.ID)
+		if err != nil {
+			return twin, pending, err
+		}
+		twin = latest
+		pending = pendingDesired(twin, desired)
+	}
+	return twin, pending, nil
+}
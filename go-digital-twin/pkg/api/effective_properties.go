@@ -0,0 +1,71 @@
+// pkg/api/effective_properties.go
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// effectivePropertiesResponse is the response of GET /twins/{twinId}/properties/effective.
+type effectivePropertiesResponse struct {
+	TwinID     string                             `json:"twinId"`
+	ModelID    string                             `json:"modelId"`
+	Properties map[string]model.EffectiveProperty `json:"properties"`
+}
+
+// GetTwinEffectiveProperties handles GET requests to /twins/{twinId}/properties/effective
+// The twin's current effective state in one map, each value with its source: the model's
+// defaults, overridden by reported values, overridden by desired values the device hasn't
+// reported back yet (see model.EffectiveProperties). Like GET /twins/{twinId}, the ETag is the
+// twin's and Last-Modified the later of the twin's and the model's updates. A twin whose model
+// is gone gets its reported and desired values without defaults.
+func (a *API) GetTwinEffectiveProperties(w http.ResponseWriter, r *http.Request) {
+	twinID := chi.URLParam(r, "twinId")
+	if twinID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
+		return
+	}
+
+	ctx := r.Context()
+	twin, err := a.Store.FindTwinByID(ctx, twinID)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find twin '%s': %v", twinID, err)
+		writeStoreError(w, err, resourceTwin, "Failed to retrieve twin")
+		return
+	}
+
+	twinModel := &model.TwinModel{ID: twin.ModelID}
+	found, err := a.models.get("model:"+twin.ModelID, func() (interface{}, error) {
+		return a.Store.FindModelByID(ctx, twin.ModelID)
+	})
+	if err != nil {
+		log.Printf("WARN: Failed to load model '%s' for effective properties of twin '%s': %v", twin.ModelID, twinID, err)
+	} else {
+		twinModel = found.(*model.TwinModel)
+	}
+
+	setTwinETag(w, twin)
+	lastModified := twin.UpdatedAt
+	if twinModel.UpdatedAt.After(lastModified) {
+		lastModified = twinModel.UpdatedAt
+	}
+	if setLastModified(w, r, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(effectivePropertiesResponse{
+		TwinID:     twin.ID,
+		ModelID:    twin.ModelID,
+		Properties: twinModel.EffectiveProperties(twin),
+	}); err != nil {
+		log.Printf("ERROR: Failed to encode effective properties of twin '%s': %v", twinID, err)
+	}
+}
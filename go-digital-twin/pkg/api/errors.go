@@ -0,0 +1,211 @@
+// pkg/api/errors.go
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// ErrorCode is a stable, machine-readable identifier for an error response.
+// Clients should branch on the code rather than string-matching the human message.
+//
+// Statuses follow one scheme across handlers:
+//
+//	400  The request is wrong on its own: malformed URL/query parameters, a body that isn't valid
+//	     JSON or has the wrong shape, missing required fields or field values that are invalid
+//	     whatever the stored state (bad ID format, too long, ...)
+//	404  The resource in the URL does not exist
+//	409  The write collides with existing state: duplicate IDs, deleting a model twins still use
+//	412  A precondition (If-Match) failed
+//	422  The request is well-formed but semantically invalid against what it references: a
+//	     modelId that doesn't exist, values the referenced model's definitions or the server's
+//	     policies (name caps, timestamp bounds) don't allow
+//
+// A code always comes with the same status.
+//
+// Code catalog:
+//
+//	BAD_REQUEST                400  Malformed URL/query parameters (e.g., bad limit, invalid time range)
+//	INVALID_PAYLOAD            400  Request body is not valid JSON or has the wrong shape/unknown fields
+//	VALIDATION_FAILED          400  Body is well-formed but a field fails validation (missing/too long/...)
+//	BATCH_TOO_LARGE            400  A batch request carries more items than the server's batch size cap
+//	UNAUTHORIZED               401  Missing or unknown API key, or an invalid, expired or revoked device token (when authentication is enabled)
+//	FORBIDDEN                  403  The API key's tag scope or the device token's twin/model scope does not allow this operation
+//	MODEL_NOT_FOUND            404  The model in the URL does not exist
+//	TWIN_NOT_FOUND             404  The twin in the URL does not exist
+//	TEMPLATE_NOT_FOUND         404  The template in the URL does not exist
+//	MODEL_VERSION_NOT_FOUND    404  The model has no recorded version with that number
+//	WEBHOOK_NOT_FOUND          404  The webhook subscription in the URL does not exist
+//	ALERT_RULE_NOT_FOUND       404  The alert rule in the URL does not exist
+//	FEATURE_DISABLED           404  The route belongs to an optional feature disabled on this server (see GET /features)
+//	NOT_FOUND                  404  Any other missing resource
+//	MODEL_CONFLICT             409  A model with the same ID already exists
+//	TWIN_CONFLICT              409  A twin with the same ID already exists
+//	TEMPLATE_CONFLICT          409  A template with the same ID already exists
+//	WEBHOOK_CONFLICT           409  A webhook subscription with the same ID already exists
+//	ALERT_RULE_CONFLICT        409  An alert rule with the same ID already exists
+//	TELEMETRY_CONFLICT         409  A telemetry point with the same (twin, name, ts) already exists
+//	CONFLICT                   409  Any other conflict
+//	PRECONDITION_FAILED        412  If-Match did not match the resource's current ETag
+//	MODEL_REFERENCE_INVALID    422  A twin, template, device token or alert rule references a modelId that does not exist
+//	TWIN_REFERENCE_INVALID     422  A device token or alert rule request references a twinId that does not exist
+//	PROPERTY_NOT_WRITABLE      422  Desired properties include keys the model marks as read-only (writable=false)
+//	VALUE_NOT_IN_ENUM          422  A property or telemetry value is not in the enum of the model's definition
+//	UNKNOWN_PROPERTY           422  Properties include keys the model doesn't define, under strict validation of their kind (desired or reported)
+//	PROPERTY_TYPE_MISMATCH     422  A desired value doesn't fit the schema of the model's definition, under strict desired validation
+//	TELEMETRY_NAME_LIMIT       422  The write introduces a telemetry name beyond the twin's distinct-name cap
+//	TELEMETRY_NAME_NOT_ALLOWED 422  The write uses a telemetry name missing from the model's allowedTelemetryNames
+//	MIGRATION_INVALID          422  The twin's properties don't fit the target model of a migration (the body lists the violations)
+//	TAG_SCHEMA_VIOLATION       422  Twin tags miss a tag the model's tagSchema requires or use a value it doesn't allow
+//	REPORTED_TYPE_CHANGED      422  A reported property update changes the property's type under the model's strictReportedTypes
+//	TIMESTAMP_OUT_OF_RANGE     422  A telemetry timestamp is further from server time than the server's timestamp policy allows
+//	INGEST_QUEUE_FULL          429  The async ingestion queue is full; retry after the Retry-After delay
+//	RATE_LIMITED               429  The API key (or client address) ran out of request budget; see the X-RateLimit-* headers
+//	TIMEOUT                    504  The request exceeded its route's timeout, or the database aborted a statement past its statement timeout
+//	SERVICE_UNAVAILABLE        503  The server is shutting down or a dependency is unavailable
+//	OVERLOADED                 503  Too many requests are in flight; retry after the Retry-After delay
+//	INTERNAL_ERROR             500  Unexpected server-side failure
+type ErrorCode string
+
+const (
+	CodeBadRequest              ErrorCode = "BAD_REQUEST"
+	CodeInvalidPayload          ErrorCode = "INVALID_PAYLOAD"
+	CodeValidationFailed        ErrorCode = "VALIDATION_FAILED"
+	CodeBatchTooLarge           ErrorCode = "BATCH_TOO_LARGE"
+	CodeModelReferenceInvalid   ErrorCode = "MODEL_REFERENCE_INVALID"
+	CodeTwinReferenceInvalid    ErrorCode = "TWIN_REFERENCE_INVALID"
+	CodePropertyNotWritable     ErrorCode = "PROPERTY_NOT_WRITABLE"
+	CodeValueNotInEnum          ErrorCode = "VALUE_NOT_IN_ENUM"
+	CodeUnknownProperty         ErrorCode = "UNKNOWN_PROPERTY"
+	CodePropertyTypeMismatch    ErrorCode = "PROPERTY_TYPE_MISMATCH"
+	CodeUnauthorized            ErrorCode = "UNAUTHORIZED"
+	CodeForbidden               ErrorCode = "FORBIDDEN"
+	CodeModelNotFound           ErrorCode = "MODEL_NOT_FOUND"
+	CodeTwinNotFound            ErrorCode = "TWIN_NOT_FOUND"
+	CodeTemplateNotFound        ErrorCode = "TEMPLATE_NOT_FOUND"
+	CodeModelVersionNotFound    ErrorCode = "MODEL_VERSION_NOT_FOUND"
+	CodeWebhookNotFound         ErrorCode = "WEBHOOK_NOT_FOUND"
+	CodeAlertRuleNotFound       ErrorCode = "ALERT_RULE_NOT_FOUND"
+	CodeFeatureDisabled         ErrorCode = "FEATURE_DISABLED"
+	CodeNotFound                ErrorCode = "NOT_FOUND"
+	CodeModelConflict           ErrorCode = "MODEL_CONFLICT"
+	CodeTwinConflict            ErrorCode = "TWIN_CONFLICT"
+	CodeTemplateConflict        ErrorCode = "TEMPLATE_CONFLICT"
+	CodeWebhookConflict         ErrorCode = "WEBHOOK_CONFLICT"
+	CodeAlertRuleConflict       ErrorCode = "ALERT_RULE_CONFLICT"
+	CodeTelemetryConflict       ErrorCode = "TELEMETRY_CONFLICT"
+	CodeConflict                ErrorCode = "CONFLICT"
+	CodePreconditionFailed      ErrorCode = "PRECONDITION_FAILED"
+	CodeTelemetryNameLimit      ErrorCode = "TELEMETRY_NAME_LIMIT"
+	CodeTelemetryNameNotAllowed ErrorCode = "TELEMETRY_NAME_NOT_ALLOWED"
+	CodeMigrationInvalid        ErrorCode = "MIGRATION_INVALID"
+	CodeTagSchemaViolation      ErrorCode = "TAG_SCHEMA_VIOLATION"
+	CodeReportedTypeChanged     ErrorCode = "REPORTED_TYPE_CHANGED"
+	CodeTimestampOutOfRange     ErrorCode = "TIMESTAMP_OUT_OF_RANGE"
+	CodeIngestQueueFull         ErrorCode = "INGEST_QUEUE_FULL"
+	CodeRateLimited             ErrorCode = "RATE_LIMITED"
+	CodeServiceUnavailable      ErrorCode = "SERVICE_UNAVAILABLE"
+	CodeOverloaded              ErrorCode = "OVERLOADED"
+	CodeTimeout                 ErrorCode = "TIMEOUT"
+	CodeInternal                ErrorCode = "INTERNAL_ERROR"
+)
+
+// ErrorResponse is the JSON envelope returned for every error.
+type ErrorResponse struct {
+	Code    ErrorCode `json:"code"`    // Machine-readable code from the catalog above
+	Message string    `json:"message"` // Human-readable description
+
+	RequestID string `json:"requestId,omitempty"` // The X-Request-ID of the response, for support requests
+}
+
+// writeError writes a JSON error envelope with the given status and code, and the request ID
+// the requestID middleware set on the response.
+func writeError(w http.ResponseWriter, status int, code ErrorCode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	body := ErrorResponse{Code: code, Message: message, RequestID: w.Header().Get(RequestIDHeader)}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("ERROR: Failed to encode error response: %v", err)
+	}
+}
+
+// resourceKind selects the resource-specific codes used when mapping store errors.
+type resourceKind int
+
+const (
+	resourceModel resourceKind = iota
+	resourceTwin
+	resourceTelemetry
+	resourceTemplate
+	resourceModelVersion
+	resourceWebhook
+	resourceAlertRule
+)
+
+// notFound returns the status message and code for a missing resource of this kind.
+func (k resourceKind) notFound() (string, ErrorCode) {
+	switch k {
+	case resourceModel:
+		return "Model not found", CodeModelNotFound
+	case resourceTwin:
+		return "Twin not found", CodeTwinNotFound
+	case resourceTemplate:
+		return "Template not found", CodeTemplateNotFound
+	case resourceModelVersion:
+		return "Model version not found", CodeModelVersionNotFound
+	case resourceWebhook:
+		return "Webhook not found", CodeWebhookNotFound
+	case resourceAlertRule:
+		return "Alert rule not found", CodeAlertRuleNotFound
+	default:
+		return "Resource not found", CodeNotFound
+	}
+}
+
+// conflictCode returns the conflict code for this kind.
+func (k resourceKind) conflictCode() ErrorCode {
+	switch k {
+	case resourceModel:
+		return CodeModelConflict
+	case resourceTwin:
+		return CodeTwinConflict
+	case resourceTelemetry:
+		return CodeTelemetryConflict
+	case resourceTemplate:
+		return CodeTemplateConflict
+	case resourceWebhook:
+		return CodeWebhookConflict
+	case resourceAlertRule:
+		return CodeAlertRuleConflict
+	default:
+		return CodeConflict
+	}
+}
+
+// writeStoreError maps a persistence error onto the error envelope using the sentinel errors,
+// so every handler reports the same status/code for the same underlying condition.
+// fallbackMessage is used for unexpected errors (the raw error is only logged, never returned).
+func writeStoreError(w http.ResponseWriter, err error, kind resourceKind, fallbackMessage string) {
+	switch {
+	case errors.Is(err, persistence.ErrNotFound):
+		msg, code := kind.notFound()
+		writeError(w, http.StatusNotFound, code, msg)
+	case errors.Is(err, persistence.ErrConflict):
+		writeError(w, http.StatusConflict, kind.conflictCode(), err.Error())
+	case errors.Is(err, persistence.ErrValidation):
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, err.Error())
+	case errors.Is(err, persistence.ErrInvalidReference):
+		writeError(w, http.StatusUnprocessableEntity, CodeModelReferenceInvalid, err.Error())
+	case errors.Is(err, persistence.ErrPreconditionFailed):
+		writeError(w, http.StatusPreconditionFailed, CodePreconditionFailed, err.Error())
+	case persistence.IsStatementTimeout(err):
+		writeError(w, http.StatusGatewayTimeout, CodeTimeout, "The database aborted the query: it ran longer than the statement timeout")
+	default:
+		writeError(w, http.StatusInternalServerError, CodeInternal, fallbackMessage)
+	}
+}
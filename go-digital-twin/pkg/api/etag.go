@@ -0,0 +1,95 @@
+// pkg/api/etag.go
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// twinETag returns a weak ETag for the twin's current state.
+// The twin's updated_at (microsecond precision in Postgres) changes on every write,
+// so it doubles as a version number until twins carry an explicit version field.
+func twinETag(t *model.TwinInstance) string {
+	return `W/"` + strconv.FormatInt(t.UpdatedAt.UnixMicro(), 36) + `"`
+}
+
+// setTwinETag sets the ETag response header for the twin.
+func setTwinETag(w http.ResponseWriter, t *model.TwinInstance) {
+	w.Header().Set("ETag", twinETag(t))
+}
+
+// setLastModified sets the Last-Modified header of a GET response for a resource last changed at
+// updatedAt and reports whether the request's If-Modified-Since shows the client already has it,
+// in which case the caller responds 304 (with the ETag/Vary headers a 200 would carry).
+//
+// HTTP dates have one-second precision, so the date is updatedAt truncated to the second. While
+// that second is still running the header is omitted: a second write within it would get the same
+// date, and a client revalidating with it after that write would wrongly get 304. As required by
+// RFC 9110, If-Modified-Since is ignored when If-None-Match is present.
+func setLastModified(w http.ResponseWriter, r *http.Request, updatedAt time.Time) (notModified bool) {
+	modified := updatedAt.UTC().Truncate(time.Second)
+	if !time.Now().Truncate(time.Second).After(modified) {
+		return false
+	}
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false // Absent or not an HTTP date: serve the full response
+	}
+	return !modified.After(since)
+}
+
+// ifMatchSatisfied reports whether the If-Match header (if any) matches currentETag.
+// Comparison is weak (the W/ prefix is ignored on both sides), and "*" matches any existing resource.
+// The bool `present` tells the caller whether a precondition was supplied at all.
+func ifMatchSatisfied(r *http.Request, currentETag string) (satisfied bool, present bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return true, false
+	}
+	if header == "*" {
+		return true, true
+	}
+
+	current := strings.TrimPrefix(currentETag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == current {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// checkTwinPrecondition evaluates If-Match for a twin write.
+// When no If-Match header is present it returns (nil, true) and the caller performs an unconditional write.
+// When present it loads the twin and returns it (so the caller can issue a conditional write against
+// its UpdatedAt), or writes 404/412 and returns false.
+func (a *API) checkTwinPrecondition(w http.ResponseWriter, r *http.Request, twinID string) (*model.TwinInstance, bool) {
+	if r.Header.Get("If-Match") == "" {
+		return nil, true
+	}
+
+	current, err := a.Store.FindTwinByID(r.Context(), twinID)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find twin '%s' for precondition check: %v", twinID, err)
+		writeStoreError(w, err, resourceTwin, "Failed to retrieve twin")
+		return nil, false
+	}
+
+	if ok, _ := ifMatchSatisfied(r, twinETag(current)); !ok {
+		setTwinETag(w, current) // Let the client see the current version
+		writeError(w, http.StatusPreconditionFailed, CodePreconditionFailed, "Precondition failed: twin has been modified (If-Match does not match current ETag)")
+		return nil, false
+	}
+	return current, true
+}
@@ -0,0 +1,112 @@
+// pkg/api/features.go
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Optional features a deployment can disable (Options.Features). Their routes answer 404
+// FEATURE_DISABLED and their background workers don't start.
+const (
+	FeaturePresence           = "presence"           // Presence WebSocket and GET /twins/{twinId}/presence
+	FeatureTemplates          = "templates"          // /templates and POST /twins/fromTemplate/{templateId}
+	FeatureDeviceTokens       = "deviceTokens"       // /tokens, device token authentication and the revocation sync
+	FeatureAdmin              = "admin"              // /admin operational tools
+	FeatureBulkTelemetryQuery = "bulkTelemetryQuery" // POST /telemetry/query
+	FeatureAsyncIngest        = "asyncIngest"        // "Prefer: respond-async" telemetry writes and their worker pool
+	FeatureRetention          = "retention"          // The telemetry retention worker
+	FeatureWebhooks           = "webhooks"           // /webhooks and the webhook delivery worker
+	FeatureAlertRules         = "alertRules"         // /alert-rules and the alert evaluator
+)
+
+// KnownFeatures lists every feature name, sorted.
+var KnownFeatures = []string{
+	FeatureAdmin, FeatureAlertRules, FeatureAsyncIngest, FeatureBulkTelemetryQuery, FeatureDeviceTokens,
+	FeaturePresence, FeatureRetention, FeatureTemplates, FeatureWebhooks,
+}
+
+// Features is the set of features a deployment disabled. The zero value enables every feature.
+type Features struct {
+	disabled map[string]bool
+}
+
+// NewFeatures disables the named features (case-sensitive, see KnownFeatures). Unknown names are
+// logged and ignored, so a typo doesn't keep the server from starting.
+func NewFeatures(disabled []string) Features {
+	f := Features{disabled: make(map[string]bool, len(disabled))}
+	for _, name := range disabled {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !isKnownFeature(name) {
+			log.Printf("WARN: Ignoring unknown feature %q (known: %s)", name, strings.Join(KnownFeatures, ", "))
+			continue
+		}
+		f.disabled[name] = true
+	}
+	return f
+}
+
+// isKnownFeature reports whether name is one of KnownFeatures.
+func isKnownFeature(name string) bool {
+	i := sort.SearchStrings(KnownFeatures, name)
+	return i < len(KnownFeatures) && KnownFeatures[i] == name
+}
+
+// Enabled reports whether the feature is enabled.
+func (f Features) Enabled(name string) bool {
+	return !f.disabled[name]
+}
+
+// Disabled returns the disabled features, sorted.
+func (f Features) Disabled() []string {
+	names := make([]string, 0, len(f.disabled))
+	for name := range f.disabled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// gate returns middleware for the routes of feature name: a pass-through when it is enabled,
+// otherwise a handler answering 404 FEATURE_DISABLED, so disabled routes are told apart from
+// mistyped ones.
+func (f Features) gate(name string) func(http.Handler) http.Handler {
+	if f.Enabled(name) {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusNotFound, CodeFeatureDisabled, fmt.Sprintf("Feature '%s' is disabled on this server (see GET /api/v1/features)", name))
+		})
+	}
+}
+
+// featuresResponse is the response of GET /features.
+type featuresResponse struct {
+	Features map[string]bool `json:"features"` // Every known feature, true when usable
+}
+
+// ListFeatures handles GET requests to /features
+// Lists every optional feature and whether clients can use it on this server: not disabled by
+// configuration and, for deviceTokens and asyncIngest, set up (a token secret, ingest workers).
+func (a *API) ListFeatures(w http.ResponseWriter, r *http.Request) {
+	response := featuresResponse{Features: make(map[string]bool, len(KnownFeatures))}
+	for _, name := range KnownFeatures {
+		response.Features[name] = a.Features.Enabled(name)
+	}
+	response.Features[FeatureDeviceTokens] = response.Features[FeatureDeviceTokens] && a.Tokens != nil
+	response.Features[FeatureAsyncIngest] = response.Features[FeatureAsyncIngest] && a.Ingest != nil
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("ERROR: Failed to encode features response: %v", err)
+	}
+}
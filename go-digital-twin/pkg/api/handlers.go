@@ -2,53 +2,143 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http" // For parsing query parameters
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/auth"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/cardinality"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/ingest"
 	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
 	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/presence"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
 // --- API Struct (Accepts combined Store interface) ---
 type API struct {
-	Store persistence.Store // Use the combined Store interface
+	Store  persistence.Store // Use the combined Store interface
+	Ingest *ingest.Pool      // Optional async telemetry writer; nil means all writes are synchronous
+
+	Downsampler *ingest.Downsampler // Buffers live points of downsampled telemetry names; nil stores them as sent
+
+	Presence  *presence.Tracker    // In-memory device connectivity (WebSocket presence)
+	NameLimit *cardinality.Limiter // Optional cap on distinct telemetry names per twin; nil = unlimited
+
+	TelemetryAllowlist *cardinality.Allowlists // Cached per-model allowedTelemetryNames, telemetryNameMappings and telemetry enums; nil = none applied
+
+	models *modelCache // Cached ListModels/GetModel results; nil = every request reads the store
+
+	summaries summaryCache // Recent model telemetry summaries (see GetModelTelemetrySummary)
+
+	// DefaultTelemetryDescending is the history order used when ?order= is absent (false = oldest first).
+	DefaultTelemetryDescending bool
+
+	// TelemetryRetention is the server-wide retention for telemetry names without a model-defined one (0 = forever).
+	TelemetryRetention time.Duration
+
+	// UnsetMaps is the default unset-maps policy of twin responses ("" = UnsetMapsOmitEmpty).
+	UnsetMaps string
+
+	// TelemetryTimestamps bounds the timestamps of telemetry writes (the zero value accepts any).
+	TelemetryTimestamps TimestampPolicy
+
+	// MaxBatchSize caps the items of one batch request (twins, telemetry records); zero means
+	// DefaultMaxBatchSize. See Options.MaxBatchSize.
+	MaxBatchSize int
+
+	// PropertyValidation sets the strictness of desired and reported property writes (the zero
+	// value is lenient for both; see PropertyValidation).
+	PropertyValidation PropertyValidation
+
+	// writeBreaker sheds writes while the store is degraded (nil when disabled; see BreakerPolicy).
+	writeBreaker *writeBreaker
+
+	// Tokens issues and verifies device tokens (nil when they are disabled; see Options.Tokens).
+	Tokens *auth.Tokens
+
+	// Features is the set of enabled optional features (see Options.Features).
+	Features Features
+
+	// BasePath prefixes the URLs the API hands out, e.g. in Location headers ("" = none; see
+	// Options.BasePath). It is normalized: "/segment[/segment...]" without a trailing slash.
+	BasePath string
 }
 
 // NewAPI creates a new API handler structure.
 func NewAPI(store persistence.Store) *API { // Accept combined Store interface
 	return &API{
-		Store: store,
+		Store:    store,
+		Presence: presence.NewTracker(),
 	}
 }
 
+// setLocation points the Location header of a 201 response at the created resource, under
+// /api/v1 and the base path: setLocation(w, "twins", id) gives /api/v1/twins/{id}.
+func (a *API) setLocation(w http.ResponseWriter, collection, id string) {
+	w.Header().Set("Location", a.BasePath+"/api/v1/"+collection+"/"+url.PathEscape(id))
+}
+
 // --- Model Handlers ---
 
 // CreateModel handles POST requests to /models
+// The body may be JSON (default) or YAML (Content-Type: application/yaml).
 func (a *API) CreateModel(w http.ResponseWriter, r *http.Request) {
 	var newModel model.TwinModel // Note: We are creating the struct here
 
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
-	if err := decoder.Decode(&newModel); err != nil {
-		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+	if err := decodeBody(r, &newModel); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
 		return
 	}
 	defer r.Body.Close()
 
 	if newModel.ID == "" {
 		newModel.ID = "model-" + uuid.NewString()
+	} else if err := model.ValidateModelID(newModel.ID); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid id: "+err.Error())
+		return
 	}
 	if newModel.DisplayName == "" {
-		http.Error(w, "Missing required field: displayName", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Missing required field: displayName")
+		return
+	}
+	if utf8.RuneCountInString(newModel.Category) > model.MaxCategoryLength {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("Invalid category: must be at most %d characters", model.MaxCategoryLength))
+		return
+	}
+	if err := newModel.ValidateProperties(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid property definitions: "+err.Error())
+		return
+	}
+	if err := newModel.ValidateTelemetryNames(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid allowedTelemetryNames: "+err.Error())
+		return
+	}
+	if err := newModel.ValidateTelemetryNameMappings(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid telemetryNameMappings: "+err.Error())
+		return
+	}
+	if err := newModel.ValidateTelemetry(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid telemetry: "+err.Error())
+		return
+	}
+	if err := newModel.ValidateDerivedProperties(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid derivedProperties: "+err.Error())
+		return
+	}
+	if err := newModel.ValidateTagSchema(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid tagSchema: "+err.Error())
 		return
 	}
 
@@ -59,76 +149,138 @@ func (a *API) CreateModel(w http.ResponseWriter, r *http.Request) {
 
 	// --- Store the model using the interface ---
 	// Use request context, potentially add timeout
-	ctx := r.Context()                         // Get context from request
+	ctx := withChangedBy(r)                    // Request context, attributing the model history entry
 	err := a.Store.CreateModel(ctx, &newModel) // Pass pointer
 	if err != nil {
 		log.Printf("ERROR: Failed to create model in store: %v", err)
 		// Check for specific persistence errors
-		if errors.Is(err, persistence.ErrConflict) {
-			http.Error(w, err.Error(), http.StatusConflict)
-		} else {
-			http.Error(w, "Failed to create model", http.StatusInternalServerError)
-		}
+		writeStoreError(w, err, resourceModel, "Failed to create model")
 		return
 	}
 	// --- End Store ---
 
+	a.models.invalidate()
 	log.Printf("INFO: Created model: ID=%s, Name=%s", newModel.ID, newModel.DisplayName)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(newModel); err != nil {
-		log.Printf("ERROR: Failed to encode create model response: %v", err)
-	}
+	a.setLocation(w, "models", newModel.ID)
+	writeNegotiated(w, r, http.StatusCreated, newModel, "create model")
 }
 
 // GetModel handles GET requests to /models/{modelId}
+// Responds with YAML when the Accept header prefers application/yaml.
 func (a *API) GetModel(w http.ResponseWriter, r *http.Request) {
 	modelID := chi.URLParam(r, "modelId")
 	if modelID == "" {
-		http.Error(w, "Missing modelId in URL path", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing modelId in URL path")
 		return
 	}
 
 	// --- Retrieve the model using the interface ---
 	ctx := r.Context()
-	foundModel, err := a.Store.FindModelByID(ctx, modelID)
+	foundModel, err := a.models.get("model:"+modelID, func() (interface{}, error) {
+		return a.Store.FindModelByID(ctx, modelID)
+	})
 	if err != nil {
 		log.Printf("DEBUG: Failed to find model '%s': %v", modelID, err) // Use DEBUG/INFO level
-		if errors.Is(err, persistence.ErrNotFound) {
-			http.Error(w, "Model not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Failed to retrieve model", http.StatusInternalServerError)
-		}
+		writeStoreError(w, err, resourceModel, "Failed to retrieve model")
 		return
 	}
 	// --- End Retrieve ---
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(foundModel); err != nil {
-		log.Printf("ERROR: Failed to encode get model response: %v", err)
+	a.models.setCacheControl(w, r)
+	if setLastModified(w, r, foundModel.(*model.TwinModel).UpdatedAt) {
+		w.Header().Add("Vary", "Accept")
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
+	writeNegotiated(w, r, http.StatusOK, foundModel, "get model")
 }
 
-// ListModels handles GET requests to /models
+// ListModels handles GET requests to /models (?category=... filters case-insensitively)
+// Responds with YAML when the Accept header prefers application/yaml, and streams one model per
+// line with Accept: application/x-ndjson.
 func (a *API) ListModels(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	modelsList, err := a.Store.ListAllModels(ctx)
+
+	categoryQuery := strings.TrimSpace(r.URL.Query().Get("category"))
+	if utf8.RuneCountInString(categoryQuery) > model.MaxCategoryLength {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("Invalid category: must be at most %d characters", model.MaxCategoryLength))
+		return
+	}
+
+	if prefersNDJSON(r) {
+		a.streamModels(w, r, categoryQuery)
+		return
+	}
+
+	// The category match ignores case, so the cache key does too
+	modelsList, err := a.models.get("list:"+strings.ToLower(categoryQuery), func() (interface{}, error) {
+		var modelsList []*model.TwinModel
+		var err error
+		if categoryQuery != "" {
+			modelsList, err = a.Store.ListModelsByCategory(ctx, categoryQuery)
+		} else {
+			modelsList, err = a.Store.ListAllModels(ctx)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Optional: Sorting can still happen here if desired, DB usually handles it though.
+		// Sorted before caching: cached lists are shared and must not be modified.
+		sort.Slice(modelsList, func(i, j int) bool {
+			return modelsList[i].ID < modelsList[j].ID
+		})
+		return modelsList, nil
+	})
 	if err != nil {
 		log.Printf("ERROR: Failed to list models: %v", err)
-		http.Error(w, "Failed to retrieve models", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve models")
 		return
 	}
 
-	// Optional: Sorting can still happen here if desired, DB usually handles it though
-	sort.Slice(modelsList, func(i, j int) bool {
-		return modelsList[i].ID < modelsList[j].ID
+	a.models.setCacheControl(w, r)
+	writeNegotiated(w, r, http.StatusOK, modelsList, "list models")
+}
+
+// streamModels writes ListModels as NDJSON straight from the store cursor, bypassing the model
+// cache: streaming is for lists too large to want held in memory.
+func (a *API) streamModels(w http.ResponseWriter, r *http.Request, category string) {
+	stream := newNegotiatedStream(w, r)
+	err := a.Store.StreamModels(r.Context(), category, func(m *model.TwinModel) error {
+		return stream.Write(m)
 	})
+	if err == nil {
+		err = stream.Close()
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to stream models: %v", err)
+		if !stream.Started() {
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve models")
+			return
+		}
+		panic(http.ErrAbortHandler) // Truncate visibly rather than end the stream cleanly
+	}
+}
+
+// ListModelCategories handles GET requests to /models/categories
+func (a *API) ListModelCategories(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	categories, err := a.Store.ListModelCategories(ctx)
+	if err != nil {
+		log.Printf("ERROR: Failed to list model categories: %v", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve model categories")
+		return
+	}
+
+	// Ensure non-nil slice is returned even if empty
+	if categories == nil {
+		categories = make([]*persistence.ModelCategory, 0)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(modelsList); err != nil {
-		log.Printf("ERROR: Failed to encode list models response: %v", err)
+	if err := json.NewEncoder(w).Encode(categories); err != nil {
+		log.Printf("ERROR: Failed to encode list model categories response: %v", err)
 	}
 }
 
@@ -136,85 +288,106 @@ func (a *API) ListModels(w http.ResponseWriter, r *http.Request) {
 func (a *API) DeleteModel(w http.ResponseWriter, r *http.Request) {
 	modelID := chi.URLParam(r, "modelId")
 	if modelID == "" {
-		http.Error(w, "Missing modelId in URL path", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing modelId in URL path")
 		return
 	}
 
-	ctx := r.Context()
+	ctx := withChangedBy(r)
 	err := a.Store.DeleteModel(ctx, modelID)
 	if err != nil {
 		log.Printf("DEBUG: Failed to delete model '%s': %v", modelID, err)
-		if errors.Is(err, persistence.ErrNotFound) {
-			http.Error(w, "Model not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Failed to delete model", http.StatusInternalServerError)
-		}
+		writeStoreError(w, err, resourceModel, "Failed to delete model")
 		return
 	}
 
+	a.TelemetryAllowlist.Invalidate(modelID)
+	a.models.invalidate()
 	log.Printf("INFO: Deleted model: ID=%s", modelID)
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // UpdateModel handles PUT requests to /models/{modelId}
+// The body may be JSON (default) or YAML (Content-Type: application/yaml).
 func (a *API) UpdateModel(w http.ResponseWriter, r *http.Request) {
 	modelID := chi.URLParam(r, "modelId")
 	if modelID == "" {
-		http.Error(w, "Missing modelId in URL path", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing modelId in URL path")
 		return
 	}
 
 	var updatedModelData model.TwinModel
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
-	if err := decoder.Decode(&updatedModelData); err != nil {
-		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+	if err := decodeBody(r, &updatedModelData); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
 		return
 	}
 	defer r.Body.Close()
 
 	if updatedModelData.DisplayName == "" {
-		http.Error(w, "Missing required field: displayName", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Missing required field: displayName")
+		return
+	}
+	if utf8.RuneCountInString(updatedModelData.Category) > model.MaxCategoryLength {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("Invalid category: must be at most %d characters", model.MaxCategoryLength))
+		return
+	}
+	if err := updatedModelData.ValidateProperties(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid property definitions: "+err.Error())
+		return
+	}
+	if err := updatedModelData.ValidateTelemetryNames(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid allowedTelemetryNames: "+err.Error())
+		return
+	}
+	if err := updatedModelData.ValidateTelemetryNameMappings(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid telemetryNameMappings: "+err.Error())
+		return
+	}
+	if err := updatedModelData.ValidateTelemetry(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid telemetry: "+err.Error())
+		return
+	}
+	if err := updatedModelData.ValidateDerivedProperties(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid derivedProperties: "+err.Error())
+		return
+	}
+	if err := updatedModelData.ValidateTagSchema(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid tagSchema: "+err.Error())
 		return
 	}
 
 	// Ensure the ID in the payload matches the URL path ID (optional but good practice)
 	if updatedModelData.ID != "" && updatedModelData.ID != modelID {
-		http.Error(w, "Model ID in payload does not match ID in URL", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Model ID in payload does not match ID in URL")
 		return
 	}
 	updatedModelData.ID = modelID // Ensure the correct ID is set for the update operation
 
-	// We set UpdatedAt here, but the DB trigger will overwrite it on successful update.
+	// Only stored when the application owns updated_at (see persistence.Config.UpdatedAtSource);
+	// the re-fetch below returns the value actually stored either way
 	updatedModelData.UpdatedAt = time.Now().UTC()
 
-	ctx := r.Context()
+	ctx := withChangedBy(r)
 	err := a.Store.UpdateModel(ctx, &updatedModelData) // Pass pointer
 	if err != nil {
 		log.Printf("DEBUG: Failed to update model '%s': %v", modelID, err)
-		if errors.Is(err, persistence.ErrNotFound) {
-			http.Error(w, "Model not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Failed to update model", http.StatusInternalServerError)
-		}
+		writeStoreError(w, err, resourceModel, "Failed to update model")
 		return
 	}
 
+	a.TelemetryAllowlist.Invalidate(modelID)
+	a.models.invalidate()
+
 	// Since UpdateModel doesn't return the updated object, we need to fetch it again
 	// to return the latest state (including potentially DB-generated timestamps)
 	updatedModel, findErr := a.Store.FindModelByID(ctx, modelID)
 	if findErr != nil {
 		log.Printf("ERROR: Failed to retrieve updated model '%s' after update: %v", modelID, findErr)
-		http.Error(w, "Failed to retrieve model after update", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve model after update")
 		return
 	}
 
 	log.Printf("INFO: Updated model: ID=%s", updatedModel.ID)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(updatedModel); err != nil { // Return the re-fetched model
-		log.Printf("ERROR: Failed to encode update model response: %v", err)
-	}
+	writeNegotiated(w, r, http.StatusOK, updatedModel, "update model") // Return the re-fetched model
 }
 
 // --- Twin Instance Handlers ---
@@ -224,37 +397,57 @@ func (a *API) CreateTwin(w http.ResponseWriter, r *http.Request) {
 	var reqBody struct { // Use a temporary struct for the request body
 		ID           string                 `json:"id"` // Allow client to suggest ID, but generate if empty
 		ModelID      string                 `json:"modelId"`
+		DisplayName  string                 `json:"displayName"` // Human-readable label; optional and not unique
+		Description  string                 `json:"description"`
 		DesiredProps map[string]interface{} `json:"desiredProperties"`
 		Tags         map[string]string      `json:"tags"`
+		Metadata     map[string]interface{} `json:"metadata"` // Arbitrary typed JSON; not filterable like tags
+		Location     *model.GeoPoint        `json:"location"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
 	if err := decoder.Decode(&reqBody); err != nil {
-		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
 		return
 	}
 	defer r.Body.Close()
 
 	// --- Validation ---
 	if reqBody.ModelID == "" {
-		http.Error(w, "Missing required field: modelId", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Missing required field: modelId")
 		return
 	}
+	// A malformed ID can't name a model (CreateModel enforces the format): no need to look it up
+	if err := model.ValidateModelID(reqBody.ModelID); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid modelId: "+err.Error())
+		return
+	}
+	if !authorizeTwinTags(w, r, reqBody.Tags) {
+		return
+	}
+	if reqBody.Location != nil {
+		if err := reqBody.Location.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid location: "+err.Error())
+			return
+		}
+	}
 
 	// Check if the specified Model exists
 	ctx := r.Context()
-	_, err := a.Store.FindModelByID(ctx, reqBody.ModelID)
+	twinModel, err := a.Store.FindModelByID(ctx, reqBody.ModelID)
 	if err != nil {
 		if errors.Is(err, persistence.ErrNotFound) {
-			// Use BadRequest because the client provided an invalid reference
-			http.Error(w, fmt.Sprintf("Referenced modelId '%s' not found", reqBody.ModelID), http.StatusBadRequest)
+			writeError(w, http.StatusUnprocessableEntity, CodeModelReferenceInvalid, fmt.Sprintf("Referenced modelId '%s' not found", reqBody.ModelID))
 		} else {
 			log.Printf("ERROR: Failed to check model existence: %v", err)
-			http.Error(w, "Failed to validate modelId", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to validate modelId")
 		}
 		return
 	}
+	if !a.checkDesiredProperties(w, twinModel, reqBody.DesiredProps) || !checkTwinTags(w, twinModel, reqBody.Tags) {
+		return
+	}
 
 	// Generate ID if not provided
 	twinID := reqBody.ID
@@ -266,17 +459,18 @@ func (a *API) CreateTwin(w http.ResponseWriter, r *http.Request) {
 	newTwin := &model.TwinInstance{
 		ID:                 twinID,
 		ModelID:            reqBody.ModelID,
-		ReportedProperties: make(map[string]interface{}), // Initialize as empty
-		DesiredProperties:  reqBody.DesiredProps,         // Use provided desired props
-		Tags:               reqBody.Tags,                 // Use provided tags
+		DisplayName:        reqBody.DisplayName,
+		Description:        reqBody.Description,
+		ReportedProperties: nil,                  // Unset until the device reports
+		DesiredProperties:  reqBody.DesiredProps, // Use provided desired props (unset when absent)
+		Tags:               reqBody.Tags,         // Use provided tags
+		Metadata:           reqBody.Metadata,
+		Location:           reqBody.Location,
 		CreatedAt:          now,
 		UpdatedAt:          now,
 	}
 
-	// Ensure maps are non-nil before passing to store (store also handles this, but good practice)
-	if newTwin.DesiredProperties == nil {
-		newTwin.DesiredProperties = make(map[string]interface{})
-	}
+	// Tags are always set; property maps stay nil (unset) unless provided
 	if newTwin.Tags == nil {
 		newTwin.Tags = make(map[string]string)
 	}
@@ -285,29 +479,38 @@ func (a *API) CreateTwin(w http.ResponseWriter, r *http.Request) {
 	err = a.Store.CreateTwin(ctx, newTwin)
 	if err != nil {
 		log.Printf("ERROR: Failed to create twin: %v", err)
-		if errors.Is(err, persistence.ErrConflict) {
-			http.Error(w, err.Error(), http.StatusConflict)
-		} else {
-			// Don't need to re-check FK error here as we validated modelId above
-			http.Error(w, "Failed to create twin", http.StatusInternalServerError)
-		}
+		writeStoreError(w, err, resourceTwin, "Failed to create twin")
 		return
 	}
 	// --- End Store ---
 
 	log.Printf("INFO: Created twin: ID=%s, ModelID=%s", newTwin.ID, newTwin.ModelID)
+	a.setLocation(w, "twins", newTwin.ID)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(newTwin); err != nil {
+	if err := json.NewEncoder(w).Encode(newTwinView(newTwin, a.unsetMapsFor(w, r))); err != nil {
 		log.Printf("ERROR: Failed to encode create twin response: %v", err)
 	}
 }
 
+// twinWithComputed is a twin as returned by GetTwin: its stored state plus the model's derived
+// properties evaluated against it.
+type twinWithComputed struct {
+	twinView
+	ComputedProperties map[string]interface{} `json:"computedProperties,omitempty"`
+	ModelExists        bool                   `json:"modelExists"` // False for orphaned twins (see ListTwins ?orphaned=true)
+}
+
 // GetTwin handles GET requests to /twins/{twinId}
+// When the twin's model declares derivedProperties they are evaluated here and returned under
+// computedProperties (null where inputs are missing or evaluation fails). They aren't part of the
+// twin's state, so the ETag doesn't change when only the model's expressions do.
+// modelExists is false when the twin's model is gone, which the foreign key only prevents for
+// rows written since it was added.
 func (a *API) GetTwin(w http.ResponseWriter, r *http.Request) {
 	twinID := chi.URLParam(r, "twinId")
 	if twinID == "" {
-		http.Error(w, "Missing twinId in URL path", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
 		return
 	}
 
@@ -315,63 +518,250 @@ func (a *API) GetTwin(w http.ResponseWriter, r *http.Request) {
 	twin, err := a.Store.FindTwinByID(ctx, twinID)
 	if err != nil {
 		log.Printf("DEBUG: Failed to find twin '%s': %v", twinID, err)
-		if errors.Is(err, persistence.ErrNotFound) {
-			http.Error(w, "Twin not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Failed to retrieve twin", http.StatusInternalServerError)
-		}
+		writeStoreError(w, err, resourceTwin, "Failed to retrieve twin")
+		return
+	}
+
+	response := twinWithComputed{twinView: newTwinView(twin, a.unsetMapsFor(w, r)), ModelExists: true}
+	twinModel, err := a.Store.FindModelByID(ctx, twin.ModelID)
+	if err != nil {
+		// The twin itself is still worth returning
+		log.Printf("WARN: Failed to load model '%s' for derived properties of twin '%s': %v", twin.ModelID, twinID, err)
+		response.ModelExists = !errors.Is(err, persistence.ErrNotFound) // Unknown on other errors; don't report an orphan
+	}
+
+	// computedProperties also change with the model's derivedProperties, so the response is as
+	// recent as the later of the two
+	setTwinETag(w, twin)
+	lastModified := twin.UpdatedAt
+	if twinModel != nil && twinModel.UpdatedAt.After(lastModified) {
+		lastModified = twinModel.UpdatedAt
+	}
+	if setLastModified(w, r, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
+	if twinModel != nil {
+		var errs map[string]error
+		response.ComputedProperties, errs = twinModel.ComputeProperties(twin)
+		for name, err := range errs {
+			log.Printf("DEBUG: Derived property '%s' of twin '%s' evaluated to null: %v", name, twinID, err)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(twin); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Printf("ERROR: Failed to encode get twin response: %v", err)
 	}
 }
 
+// Page size limits of ListTwins
+const (
+	defaultTwinListLimit = 100
+	maxTwinListLimit     = 1000
+)
+
+// twinPage is one page of GET /twins.
+type twinPage struct {
+	Twins      interface{} `json:"twins"`                // twinViews, or projected ones with ?fields=
+	NextCursor string      `json:"nextCursor,omitempty"` // Pass as ?cursor= for the next page; absent on the last page
+}
+
 // ListTwins handles GET requests to /twins
+// Supports ?modelId=, tag filters (?tag=site:warehouse-3&tag=status:active or
+// ?tag.site=warehouse-3; twins must carry all of them) and ?online=true|false (presence as seen
+// by this API instance).
+// ?orphaned=true lists only twins whose model no longer exists, to find integrity issues.
+// Twins are listed in ID order, ?limit= (default 100, max 1000) per page; follow nextCursor
+// with ?cursor= until it is absent:
+//
+//	{"twins": [{"id": "pump-1", ...}], "nextCursor": "pump-1"}
+//
+// Pages are read by keyset on the ID, so deep pages are as cheap as the first. With ?online=
+// the presence filter applies to each page, so a page may hold fewer twins than the limit.
+// With Accept: application/x-ndjson every twin is streamed from the store one per line instead,
+// without paging, so neither side holds the whole list in memory.
+// ?fields=id,modelId,tags returns only those fields of each twin (id is always included), and
+// property maps that aren't asked for are not read from the store at all.
+// ?search= keeps the twins whose display name contains it (case-insensitive), and
+// ?sort=displayName pages by display name (byte order, twins without one first), then ID,
+// instead; its cursors are opaque. NDJSON streams are always in ID order.
 func (a *API) ListTwins(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	// Basic Filtering (Example: by modelId)
 	modelIdQuery := r.URL.Query().Get("modelId") // Get "?modelId=..." query param
 
+	var onlineFilter *bool
+	if raw := r.URL.Query().Get("online"); raw != "" {
+		online, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid 'online' query parameter, expected true or false")
+			return
+		}
+		onlineFilter = &online
+	}
+
+	orphaned := false
+	if raw := r.URL.Query().Get("orphaned"); raw != "" {
+		var err error
+		if orphaned, err = strconv.ParseBool(raw); err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid 'orphaned' query parameter, expected true or false")
+			return
+		}
+	}
+
+	fields, ok := parseTwinFields(w, r)
+	if !ok {
+		return
+	}
+	byDisplayName, ok := parseTwinSort(w, r)
+	if !ok {
+		return
+	}
+	search := r.URL.Query().Get("search")
+
+	// Scoped API keys add their own tags, so they filter in the query rather than fetching everything
+	selector, ok := parseTagSelector(w, r.URL.Query())
+	if !ok {
+		return
+	}
+	selector, inScope := scopeTagSelector(ctx, selector)
+
+	if prefersNDJSON(r) && !orphaned {
+		if byDisplayName {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "NDJSON streams are in ID order: ?sort=displayName needs a paged (JSON) list")
+			return
+		}
+		a.streamTwins(w, r, modelIdQuery, selector, inScope, search, onlineFilter, fields)
+		return
+	}
+
+	limit := defaultTwinListLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > maxTwinListLimit {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid limit parameter: must be between 1 and %d", maxTwinListLimit))
+			return
+		}
+		limit = parsed
+	}
+	query := persistence.TwinPageQuery{
+		Tags:          selector,
+		ModelID:       modelIdQuery,
+		Limit:         limit + 1, // Fetch one extra twin to learn whether another page exists
+		Fields:        fields,
+		Search:        search,
+		ByDisplayName: byDisplayName,
+	}
+	cursor := r.URL.Query().Get("cursor")
+	if !applyTwinCursor(w, &query, cursor) {
+		return
+	}
+
 	var twinsList []*model.TwinInstance
 	var err error
 
-	if modelIdQuery != "" {
-		// Optional: Check if model actually exists first? Maybe not necessary for List.
-		twinsList, err = a.Store.ListTwinsByModel(ctx, modelIdQuery)
-		log.Printf("INFO: Listing twins for modelId: %s", modelIdQuery)
-	} else {
-		twinsList, err = a.Store.ListAllTwins(ctx)
-		log.Printf("INFO: Listing all twins")
+	switch {
+	case !inScope:
+		twinsList = []*model.TwinInstance{} // The filter asks for tags outside the key's scope
+	case orphaned:
+		// Usually empty or short, so no need for a dedicated scoped or paged query
+		p := auth.FromContext(ctx)
+		twinsList, err = a.Store.ListOrphanedTwins(ctx)
+		if byDisplayName {
+			sort.SliceStable(twinsList, func(i, j int) bool { return twinsList[i].DisplayName < twinsList[j].DisplayName })
+		}
+		filtered := make([]*model.TwinInstance, 0, len(twinsList))
+		for _, t := range twinsList {
+			if twinAfter(t, query) && (modelIdQuery == "" || t.ModelID == modelIdQuery) && p.CanAccess(t.Tags) && model.TagsContain(t.Tags, selector) &&
+				displayNameContains(t, search) && len(filtered) <= limit {
+				filtered = append(filtered, t)
+			}
+		}
+		twinsList = filtered
+		log.Printf("INFO: Listing orphaned twins (modelId: %q)", modelIdQuery)
+	default:
+		twinsList, err = a.Store.ListTwinsPage(ctx, query)
+		log.Printf("INFO: Listing twins for %s (modelId: %q, tags: %v, cursor: %q)", describeActor(ctx), modelIdQuery, selector, cursor)
 	}
 
 	if err != nil {
 		log.Printf("ERROR: Failed to list twins: %v", err)
-		http.Error(w, "Failed to retrieve twins", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twins")
 		return
 	}
 
-	// Ensure non-nil slice is returned even if empty
-	if twinsList == nil {
-		twinsList = make([]*model.TwinInstance, 0)
+	page := twinPage{}
+	if len(twinsList) > limit {
+		twinsList = twinsList[:limit]
+		page.NextCursor = twinCursor(twinsList[limit-1], byDisplayName) // Before the presence filter, so no twin is skipped
+	}
+
+	if onlineFilter != nil {
+		filtered := make([]*model.TwinInstance, 0, len(twinsList))
+		for _, t := range twinsList {
+			if a.Presence.IsOnline(t.ID) == *onlineFilter {
+				filtered = append(filtered, t)
+			}
+		}
+		twinsList = filtered
+	}
+
+	// newTwinViews returns a non-nil slice even if empty
+	views := newTwinViews(twinsList, a.unsetMapsFor(w, r))
+	page.Twins = views
+	if fields != nil {
+		page.Twins = projectTwinViews(views, fields)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(twinsList); err != nil {
+	if err := json.NewEncoder(w).Encode(page); err != nil {
 		log.Printf("ERROR: Failed to encode list twins response: %v", err)
 	}
 }
 
+// streamTwins writes ListTwins as NDJSON straight from the store cursor. tags is the scoped
+// selector; out of scope, the stream is empty.
+func (a *API) streamTwins(w http.ResponseWriter, r *http.Request, modelID string, tags map[string]string, inScope bool, search string, onlineFilter *bool, fields []string) {
+	ctx := r.Context()
+	log.Printf("INFO: Streaming twins (modelId: %q, tags: %v)", modelID, tags)
+
+	policy := a.unsetMapsFor(w, r)
+	stream := newNegotiatedStream(w, r)
+	var err error
+	if inScope {
+		err = a.Store.StreamTwinFields(ctx, fields, tags, modelID, func(t *model.TwinInstance) error {
+			if (onlineFilter != nil && a.Presence.IsOnline(t.ID) != *onlineFilter) || !displayNameContains(t, search) {
+				return nil
+			}
+			if fields != nil {
+				return stream.Write(projectTwinView(newTwinView(t, policy), fields))
+			}
+			return stream.Write(newTwinView(t, policy))
+		})
+	}
+	if err == nil {
+		err = stream.Close()
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to stream twins: %v", err)
+		if !stream.Started() {
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twins")
+			return
+		}
+		panic(http.ErrAbortHandler) // Truncate visibly rather than end the stream cleanly
+	}
+}
+
 // DeleteTwin handles DELETE requests to /twins/{twinId}
 func (a *API) DeleteTwin(w http.ResponseWriter, r *http.Request) {
 	twinID := chi.URLParam(r, "twinId")
 	if twinID == "" {
-		http.Error(w, "Missing twinId in URL path", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
 		return
 	}
 
@@ -379,25 +769,157 @@ func (a *API) DeleteTwin(w http.ResponseWriter, r *http.Request) {
 	err := a.Store.DeleteTwin(ctx, twinID)
 	if err != nil {
 		log.Printf("DEBUG: Failed to delete twin '%s': %v", twinID, err)
-		if errors.Is(err, persistence.ErrNotFound) {
-			http.Error(w, "Twin not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Failed to delete twin", http.StatusInternalServerError)
-		}
+		writeStoreError(w, err, resourceTwin, "Failed to delete twin")
 		return
 	}
+	a.Presence.Forget(twinID)
+	a.NameLimit.Forget(twinID)
 
 	log.Printf("INFO: Deleted twin: ID=%s", twinID)
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// parseTagSelector reads the tag.<key>=<value> and repeated tag=<key>:<value> query parameters
+// into a selector (empty when there are none). The latter split on the first colon only, so
+// values may contain colons. On an invalid parameter, or two values for one tag (which nothing
+// matches), it writes the error response and returns false.
+func parseTagSelector(w http.ResponseWriter, query url.Values) (map[string]string, bool) {
+	selector := make(map[string]string)
+	add := func(key, value string) bool {
+		if existing, ok := selector[key]; ok && existing != value {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Conflicting values for tag '%s': %q and %q", key, existing, value))
+			return false
+		}
+		selector[key] = value
+		return true
+	}
+	for _, value := range query["tag"] {
+		key, tagValue, found := strings.Cut(value, ":")
+		if !found || key == "" || tagValue == "" {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid 'tag' query parameter %q: expected <key>:<value>", value))
+			return nil, false
+		}
+		if !add(key, tagValue) {
+			return nil, false
+		}
+	}
+	for param, values := range query {
+		key := strings.TrimPrefix(param, "tag.")
+		if key == param {
+			continue
+		}
+		if key == "" || len(values) != 1 || values[0] == "" {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid '%s' query parameter: expected a tag name and a single non-empty value", param))
+			return nil, false
+		}
+		if !add(key, values[0]) {
+			return nil, false
+		}
+	}
+	return selector, true
+}
+
+// scopeTagSelector narrows selector to a scoped API key's tags. inScope is false when the
+// selector asks for a tag value outside the scope, which matches nothing.
+func scopeTagSelector(ctx context.Context, selector map[string]string) (scoped map[string]string, inScope bool) {
+	p := auth.FromContext(ctx)
+	if p.Unrestricted() {
+		return selector, true
+	}
+	scoped = make(map[string]string, len(selector)+len(p.Tags))
+	for k, v := range selector {
+		scoped[k] = v
+	}
+	inScope = true
+	for k, v := range p.Tags {
+		if existing, ok := scoped[k]; ok && existing != v {
+			inScope = false
+		}
+		scoped[k] = v
+	}
+	return scoped, inScope
+}
+
+// DeleteTwinsByTags handles DELETE requests to /twins?tag.<key>=<value>&confirm=true
+// Deletes every twin carrying all the given tags, with its telemetry, in one transaction and
+// returns {"selector", "dryRun", "count", "twinIds"}. At least one tag is required, so this can
+// never delete all twins, and confirm=true must be passed; dryRun=true instead only lists the
+// twins that would be deleted. Tag-scoped keys only delete twins within their own scope.
+func (a *API) DeleteTwinsByTags(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	selector, ok := parseTagSelector(w, query)
+	if !ok {
+		return
+	}
+	if len(selector) == 0 {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "A tag selector is required, e.g. ?tag.site=old-warehouse")
+		return
+	}
+
+	dryRun := false
+	if raw := query.Get("dryRun"); raw != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(raw); err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid 'dryRun' query parameter, expected true or false")
+			return
+		}
+	}
+	if !dryRun && query.Get("confirm") != "true" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Deleting twins by selector requires confirm=true (or dryRun=true to preview)")
+		return
+	}
+
+	ctx := r.Context()
+	storeSelector, inScope := scopeTagSelector(ctx, selector)
+
+	twinIDs := make([]string, 0)
+	if inScope && dryRun {
+		twins, err := a.Store.ListTwinsByTags(ctx, storeSelector, "")
+		if err != nil {
+			log.Printf("ERROR: Failed to list twins for selector %v: %v", selector, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twins")
+			return
+		}
+		for _, t := range twins {
+			twinIDs = append(twinIDs, t.ID)
+		}
+		sort.Strings(twinIDs)
+	} else if inScope {
+		deleted, err := a.Store.DeleteTwinsByTags(ctx, storeSelector)
+		if err != nil {
+			log.Printf("ERROR: Failed to delete twins for selector %v: %v", selector, err)
+			writeStoreError(w, err, resourceTwin, "Failed to delete twins")
+			return
+		}
+		for _, twinID := range deleted {
+			a.Presence.Forget(twinID)
+			a.NameLimit.Forget(twinID)
+		}
+		twinIDs = append(twinIDs, deleted...)
+		log.Printf("INFO: Deleted %d twins for selector %v", len(twinIDs), selector)
+	}
+
+	response := map[string]interface{}{
+		"selector": selector,
+		"dryRun":   dryRun,
+		"count":    len(twinIDs),
+		"twinIds":  twinIDs,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("ERROR: Failed to encode bulk delete response: %v", err)
+	}
+}
+
 // UpdateTwin handles PUT requests to /twins/{twinId}
-// This replaces ModelID, DesiredProperties, and Tags based on request body.
+// This replaces ModelID, DisplayName, Description, DesiredProperties, Tags, Metadata and Location
+// based on request body ("location": null removes the location, "displayName": "" the name).
 // Caution: ReportedProperties are NOT updated via this endpoint.
 func (a *API) UpdateTwin(w http.ResponseWriter, r *http.Request) {
 	twinID := chi.URLParam(r, "twinId")
 	if twinID == "" {
-		http.Error(w, "Missing twinId in URL path", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
 		return
 	}
 
@@ -406,24 +928,24 @@ func (a *API) UpdateTwin(w http.ResponseWriter, r *http.Request) {
 	existingTwin, err := a.Store.FindTwinByID(ctx, twinID)
 	if err != nil {
 		log.Printf("DEBUG: Failed to find twin '%s' for update: %v", twinID, err)
-		if errors.Is(err, persistence.ErrNotFound) {
-			http.Error(w, "Twin not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Failed to retrieve twin for update", http.StatusInternalServerError)
-		}
+		writeStoreError(w, err, resourceTwin, "Failed to retrieve twin for update")
 		return
 	}
 
 	// 2. Decode request body containing fields to update
 	var reqBody struct {
 		ModelID      *string                `json:"modelId"` // Use pointers to detect if field is present
+		DisplayName  *string                `json:"displayName"`
+		Description  *string                `json:"description"`
 		DesiredProps map[string]interface{} `json:"desiredProperties"`
 		Tags         map[string]string      `json:"tags"`
+		Metadata     map[string]interface{} `json:"metadata"`
+		Location     json.RawMessage        `json:"location"` // Raw to tell null (remove) from absent (keep)
 	}
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
 	if err := decoder.Decode(&reqBody); err != nil {
-		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
 		return
 	}
 	defer r.Body.Close()
@@ -432,47 +954,84 @@ func (a *API) UpdateTwin(w http.ResponseWriter, r *http.Request) {
 	updatedTwin := &model.TwinInstance{
 		ID:                 twinID,                          // Keep original ID
 		ModelID:            existingTwin.ModelID,            // Keep original model unless provided
+		DisplayName:        existingTwin.DisplayName,        // Keep existing display name unless provided
+		Description:        existingTwin.Description,        // Keep existing description unless provided
 		ReportedProperties: existingTwin.ReportedProperties, // IMPORTANT: Keep existing reported props
 		DesiredProperties:  existingTwin.DesiredProperties,  // Keep existing desired unless provided
 		Tags:               existingTwin.Tags,               // Keep existing tags unless provided
+		Metadata:           existingTwin.Metadata,           // Keep existing metadata unless provided
+		Location:           existingTwin.Location,           // Keep existing location unless provided
 		CreatedAt:          existingTwin.CreatedAt,          // Keep original CreatedAt
 		UpdatedAt:          time.Now().UTC(),                // Set update time
 	}
 
 	// Apply updates from request body if fields were provided
+	targetModelID := existingTwin.ModelID
 	if reqBody.ModelID != nil {
-		// Validate the new model ID if it's being changed
-		_, err := a.Store.FindModelByID(ctx, *reqBody.ModelID)
-		if err != nil {
+		targetModelID = *reqBody.ModelID
+	}
+	targetModel, err := a.Store.FindModelByID(ctx, targetModelID)
+	if err != nil {
+		if reqBody.ModelID != nil {
 			if errors.Is(err, persistence.ErrNotFound) {
-				http.Error(w, fmt.Sprintf("Referenced modelId '%s' not found", *reqBody.ModelID), http.StatusBadRequest)
+				writeError(w, http.StatusUnprocessableEntity, CodeModelReferenceInvalid, fmt.Sprintf("Referenced modelId '%s' not found", *reqBody.ModelID))
 			} else {
 				log.Printf("ERROR: Failed to check new model existence: %v", err)
-				http.Error(w, "Failed to validate new modelId", http.StatusInternalServerError)
+				writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to validate new modelId")
 			}
 			return
 		}
-		updatedTwin.ModelID = *reqBody.ModelID
+		log.Printf("ERROR: Failed to load model '%s' of twin '%s': %v", targetModelID, twinID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to load twin model")
+		return
+	}
+	updatedTwin.ModelID = targetModelID
+	if reqBody.DisplayName != nil {
+		updatedTwin.DisplayName = *reqBody.DisplayName
+	}
+	if reqBody.Description != nil {
+		updatedTwin.Description = *reqBody.Description
 	}
 	if reqBody.DesiredProps != nil { // Check if the key was present in JSON, even if value is null/empty
 		updatedTwin.DesiredProperties = reqBody.DesiredProps
 	}
+	// Re-check the resulting desired state: a model change can make existing keys read-only or their values invalid
+	if (reqBody.DesiredProps != nil || reqBody.ModelID != nil) && !a.checkDesiredProperties(w, targetModel, updatedTwin.DesiredProperties) {
+		return
+	}
 	if reqBody.Tags != nil {
 		updatedTwin.Tags = reqBody.Tags
+		if !authorizeTwinTags(w, r, updatedTwin.Tags) {
+			return
+		}
+	}
+	// Likewise the kept tags must fit a new model's tag schema
+	if (reqBody.Tags != nil || reqBody.ModelID != nil) && !checkTwinTags(w, targetModel, updatedTwin.Tags) {
+		return
+	}
+	if reqBody.Metadata != nil {
+		updatedTwin.Metadata = reqBody.Metadata
+	}
+	if len(reqBody.Location) > 0 {
+		var location *model.GeoPoint
+		if err := json.Unmarshal(reqBody.Location, &location); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid location: "+err.Error())
+			return
+		}
+		if location != nil {
+			if err := location.Validate(); err != nil {
+				writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid location: "+err.Error())
+				return
+			}
+		}
+		updatedTwin.Location = location
 	}
 
 	// 4. Store the updated twin using the general UpdateTwin method
 	err = a.Store.UpdateTwin(ctx, updatedTwin)
 	if err != nil {
 		log.Printf("ERROR: Failed to update twin '%s': %v", twinID, err)
-		if errors.Is(err, persistence.ErrNotFound) {
-			// Should not happen if FindTwinByID succeeded, but check anyway
-			http.Error(w, "Twin not found during update", http.StatusNotFound)
-		} else if errors.Is(err, persistence.ErrConflict) { // e.g., FK violation if modelId changed
-			http.Error(w, err.Error(), http.StatusBadRequest) // Or Conflict? Bad Request seems better for FK.
-		} else {
-			http.Error(w, "Failed to update twin", http.StatusInternalServerError)
-		}
+		writeStoreError(w, err, resourceTwin, "Failed to update twin") // The twin or its new model may have been deleted since
 		return
 	}
 
@@ -481,14 +1040,15 @@ func (a *API) UpdateTwin(w http.ResponseWriter, r *http.Request) {
 	finalTwin, findErr := a.Store.FindTwinByID(ctx, twinID)
 	if findErr != nil {
 		log.Printf("ERROR: Failed to retrieve updated twin '%s' after PUT: %v", twinID, findErr)
-		http.Error(w, "Failed to retrieve twin after update", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twin after update")
 		return
 	}
 
 	log.Printf("INFO: Updated twin (PUT): ID=%s", finalTwin.ID)
+	setTwinETag(w, finalTwin)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(finalTwin); err != nil {
+	if err := json.NewEncoder(w).Encode(newTwinView(finalTwin, a.unsetMapsFor(w, r))); err != nil {
 		log.Printf("ERROR: Failed to encode update twin response: %v", err)
 	}
 }
@@ -496,35 +1056,74 @@ func (a *API) UpdateTwin(w http.ResponseWriter, r *http.Request) {
 // --- Specific Update Handlers ---
 
 // UpdateTwinDesiredProperties handles PUT requests to /twins/{twinId}/properties/desired
+// The body replaces the desired properties; {} clears them. An empty body or null is taken for a
+// client mistake and rejected with 400 VALIDATION_FAILED instead of wiping them, unless
+// ?clearOnEmpty=true asks for exactly that. With ?waitForAck=30s the response waits until the device reports every written property with
+// the desired value (200 with the converged twin), or answers 202 {"status": "pending",
+// "pending": [keys], "twin"} when the wait (capped by the request timeout) runs out first.
 func (a *API) UpdateTwinDesiredProperties(w http.ResponseWriter, r *http.Request) {
 	twinID := chi.URLParam(r, "twinId")
 	if twinID == "" {
-		http.Error(w, "Missing twinId in URL path", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
 		return
 	}
+	waitForAck, ok := parseWaitForAck(w, r)
+	if !ok {
+		return
+	}
+	clearOnEmpty := false
+	if raw := r.URL.Query().Get("clearOnEmpty"); raw != "" {
+		var err error
+		if clearOnEmpty, err = strconv.ParseBool(raw); err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid 'clearOnEmpty' query parameter, expected true or false")
+			return
+		}
+	}
 
 	var props map[string]interface{}
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
-	if err := decoder.Decode(&props); err != nil {
-		http.Error(w, "Invalid request payload (expecting JSON object): "+err.Error(), http.StatusBadRequest)
+	if err := decoder.Decode(&props); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload (expecting JSON object): "+err.Error())
 		return
 	}
 	defer r.Body.Close()
 
+	// An empty body and null both decode to nil; only {} is an explicit clear
 	if props == nil {
-		props = make(map[string]interface{}) // Ensure non-nil map for update
+		if !clearOnEmpty {
+			writeError(w, http.StatusBadRequest, CodeValidationFailed, "Missing desired properties (empty body or null): send {} to clear them, or pass clearOnEmpty=true")
+			return
+		}
+		props = make(map[string]interface{})
 	}
 
+	// Optional optimistic concurrency via If-Match (see etag.go)
+	current, ok := a.checkTwinPrecondition(w, r, twinID)
+	if !ok {
+		return
+	}
+
+	// Only properties the model marks writable may be set here, with values in their enums (see
+	// checkDesiredProperties for strict validation)
 	ctx := r.Context()
-	err := a.Store.UpdateDesiredProperties(ctx, twinID, props)
+	twinModel, ok := a.modelForTwin(ctx, w, twinID, current)
+	if !ok {
+		return
+	}
+	if !a.checkDesiredProperties(w, twinModel, props) {
+		return
+	}
+
+	var err error
+	if current != nil {
+		err = a.Store.UpdateDesiredPropertiesIfUnmodified(ctx, twinID, props, current.UpdatedAt)
+	} else {
+		err = a.Store.UpdateDesiredProperties(ctx, twinID, props)
+	}
 	if err != nil {
 		log.Printf("ERROR: Failed to update desired properties for twin '%s': %v", twinID, err)
-		if errors.Is(err, persistence.ErrNotFound) {
-			http.Error(w, "Twin not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Failed to update desired properties", http.StatusInternalServerError)
-		}
+		writeStoreError(w, err, resourceTwin, "Failed to update desired properties")
 		return
 	}
 
@@ -532,23 +1131,51 @@ func (a *API) UpdateTwinDesiredProperties(w http.ResponseWriter, r *http.Request
 	updatedTwin, findErr := a.Store.FindTwinByID(ctx, twinID)
 	if findErr != nil {
 		log.Printf("ERROR: Failed to retrieve twin '%s' after desired prop update: %v", twinID, findErr)
-		http.Error(w, "Failed to retrieve twin after update", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twin after update")
 		return
 	}
 
 	log.Printf("INFO: Updated desired properties for twin: ID=%s", twinID)
+
+	status := http.StatusOK
+	policy := a.unsetMapsFor(w, r)
+	var response interface{} = newTwinView(updatedTwin, policy)
+	if waitForAck > 0 {
+		var pending []string
+		var err error
+		updatedTwin, pending, err = a.waitForDesiredAck(ctx, updatedTwin, props, waitForAck)
+		if err != nil {
+			log.Printf("DEBUG: Stopped waiting for twin '%s' to report desired properties: %v", twinID, err)
+			writeStoreError(w, err, resourceTwin, "Failed to retrieve twin while waiting for acknowledgment")
+			return
+		}
+		if len(pending) > 0 {
+			log.Printf("INFO: Twin '%s' has not reported desired properties %v within %s", twinID, pending, waitForAck)
+			status = http.StatusAccepted
+			response = map[string]interface{}{
+				"status":  "pending",
+				"pending": pending,
+				"twin":    newTwinView(updatedTwin, policy),
+			}
+		} else {
+			response = newTwinView(updatedTwin, policy)
+		}
+	}
+
+	setTwinETag(w, updatedTwin)
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(updatedTwin); err != nil {
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Printf("ERROR: Failed to encode update desired props response: %v", err)
 	}
 }
 
 // UpdateTwinTags handles PUT requests to /twins/{twinId}/tags
+// The tags replace the twin's and must fit its model's tag schema (422 TAG_SCHEMA_VIOLATION).
 func (a *API) UpdateTwinTags(w http.ResponseWriter, r *http.Request) {
 	twinID := chi.URLParam(r, "twinId")
 	if twinID == "" {
-		http.Error(w, "Missing twinId in URL path", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
 		return
 	}
 
@@ -556,7 +1183,7 @@ func (a *API) UpdateTwinTags(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
 	if err := decoder.Decode(&tags); err != nil {
-		http.Error(w, "Invalid request payload (expecting JSON object with string values): "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload (expecting JSON object with string values): "+err.Error())
 		return
 	}
 	defer r.Body.Close()
@@ -564,16 +1191,31 @@ func (a *API) UpdateTwinTags(w http.ResponseWriter, r *http.Request) {
 	if tags == nil {
 		tags = make(map[string]string) // Ensure non-nil map for update
 	}
+	if !authorizeTwinTags(w, r, tags) {
+		return
+	}
+
+	// Optional optimistic concurrency via If-Match (see etag.go)
+	current, ok := a.checkTwinPrecondition(w, r, twinID)
+	if !ok {
+		return
+	}
 
 	ctx := r.Context()
-	err := a.Store.UpdateTags(ctx, twinID, tags)
+	twinModel, ok := a.modelForTwin(ctx, w, twinID, current)
+	if !ok || !checkTwinTags(w, twinModel, tags) {
+		return
+	}
+
+	var err error
+	if current != nil {
+		err = a.Store.UpdateTagsIfUnmodified(ctx, twinID, tags, current.UpdatedAt)
+	} else {
+		err = a.Store.UpdateTags(ctx, twinID, tags)
+	}
 	if err != nil {
 		log.Printf("ERROR: Failed to update tags for twin '%s': %v", twinID, err)
-		if errors.Is(err, persistence.ErrNotFound) {
-			http.Error(w, "Twin not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Failed to update tags", http.StatusInternalServerError)
-		}
+		writeStoreError(w, err, resourceTwin, "Failed to update tags")
 		return
 	}
 
@@ -581,14 +1223,15 @@ func (a *API) UpdateTwinTags(w http.ResponseWriter, r *http.Request) {
 	updatedTwin, findErr := a.Store.FindTwinByID(ctx, twinID)
 	if findErr != nil {
 		log.Printf("ERROR: Failed to retrieve twin '%s' after tags update: %v", twinID, findErr)
-		http.Error(w, "Failed to retrieve twin after update", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twin after update")
 		return
 	}
 
 	log.Printf("INFO: Updated tags for twin: ID=%s", twinID)
+	setTwinETag(w, updatedTwin)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(updatedTwin); err != nil {
+	if err := json.NewEncoder(w).Encode(newTwinView(updatedTwin, a.unsetMapsFor(w, r))); err != nil {
 		log.Printf("ERROR: Failed to encode update tags response: %v", err)
 	}
 }
@@ -596,42 +1239,40 @@ func (a *API) UpdateTwinTags(w http.ResponseWriter, r *http.Request) {
 // --- Telemetry Handlers ---
 
 // GetTelemetryHistory handles GET requests to /twins/{twinId}/telemetry/{telemetryName}/history
+// Sort order: ?order=asc|desc. Without ?order= the deployment default (TELEMETRY_DEFAULT_ORDER,
+// ascending unless configured otherwise) applies. Use /recent for a newest-first view. An unknown
+// twin is 404; a twin without points in the range gets an empty array.
+// With ?bucket= the response is aggregated per time bucket instead (see getTelemetryAggregate).
+// Raw points are streamed as a JSON array, or as NDJSON with Accept: application/x-ndjson.
 func (a *API) GetTelemetryHistory(w http.ResponseWriter, r *http.Request) {
 	twinID := chi.URLParam(r, "twinId")
 	telemetryName := chi.URLParam(r, "telemetryName") // Get name from path
 
 	if twinID == "" || telemetryName == "" {
-		http.Error(w, "Missing twinId or telemetryName in URL path", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId or telemetryName in URL path")
 		return
 	}
 
 	// --- Parse Query Parameters ---
 	query := r.URL.Query()
 
-	// Default time range (e.g., last hour)
-	defaultEnd := time.Now().UTC()
-	defaultStart := defaultEnd.Add(-1 * time.Hour)
-
-	// Parse start time (RFC3339 format, e.g., 2023-10-27T10:00:00Z)
-	start, err := time.Parse(time.RFC3339, query.Get("start"))
-	if err != nil || query.Get("start") == "" {
-		start = defaultStart // Use default if missing or invalid
+	start, end, ok := parseTimeRange(w, r)
+	if !ok {
+		return
 	}
 
-	// Parse end time
-	end, err := time.Parse(time.RFC3339, query.Get("end"))
-	if err != nil || query.Get("end") == "" {
-		end = defaultEnd // Use default if missing or invalid
+	// Aggregated (downsampled) view
+	if query.Get("bucket") != "" {
+		a.getTelemetryAggregate(w, r, twinID, telemetryName, start, end)
+		return
 	}
-
-	// Ensure start is before end
-	if start.After(end) {
-		http.Error(w, "Invalid time range: start time must be before end time", http.StatusBadRequest)
+	if query.Get("agg") != "" || query.Get("tz") != "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "The agg and tz parameters require a bucket parameter")
 		return
 	}
 
-	// Parse order (desc or asc)
-	descending := strings.ToLower(query.Get("order")) == "desc"
+	// Parse order (desc or asc); absent means the deployment default
+	descending := parseOrder(r, a.DefaultTelemetryDescending)
 
 	// Parse limit (positive integer)
 	var limit uint = 0 // Default: no limit
@@ -641,41 +1282,50 @@ func (a *API) GetTelemetryHistory(w http.ResponseWriter, r *http.Request) {
 		if err == nil && parsedLimit > 0 {
 			limit = uint(parsedLimit)
 		} else {
-			http.Error(w, "Invalid limit parameter: must be a positive integer", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid limit parameter: must be a positive integer")
 			return
 		}
 	}
 
-	// --- Query the Store ---
-	ctx := r.Context()
-	records, err := a.Store.QueryTelemetryHistory(ctx, twinID, telemetryName, start, end, descending, limit)
-	if err != nil {
-		// Note: Don't return 404 if twin exists but has no telemetry in range.
-		// The store method doesn't distinguish "twin not found" from "no data found".
-		// We could add a separate check for twin existence if needed.
-		log.Printf("ERROR: Failed to query telemetry history for twin '%s', name '%s': %v", twinID, telemetryName, err)
-		http.Error(w, "Failed to retrieve telemetry history", http.StatusInternalServerError)
+	qualities, ok := parseQualityFilter(w, r)
+	if !ok {
 		return
 	}
 
-	// Ensure non-nil slice is returned even if empty
-	if records == nil {
-		records = make([]*persistence.TelemetryRecord, 0)
+	// --- Stream from the Store ---
+	// Records are encoded as they come off the DB cursor and flushed periodically, so the client
+	// starts receiving data immediately and memory stays flat however large the range is.
+	// Accept: application/x-ndjson gets one record per line instead of an array.
+	ctx := r.Context()
+	stream := newNegotiatedStream(w, r)
+	err := a.Store.StreamTelemetryHistory(ctx, twinID, telemetryName, start, end, descending, limit, qualities, func(rec *persistence.TelemetryRecord) error {
+		return stream.Write(rec)
+	})
+	if err == nil && !stream.Started() && !a.checkTwinExists(ctx, w, twinID) {
+		return // Nothing streamed yet, so the 404 can still be sent
 	}
-
-	// --- Respond ---
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(records); err != nil {
-		log.Printf("ERROR: Failed to encode telemetry history response: %v", err)
+	if err == nil {
+		err = stream.Close()
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to stream telemetry history for twin '%s', name '%s': %v", twinID, telemetryName, err)
+		if !stream.Started() {
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve telemetry history")
+			return
+		}
+		// The 200 and part of the array are already out; abort the connection so the client
+		// sees a truncated response instead of a well-formed but incomplete array.
+		panic(http.ErrAbortHandler)
 	}
 }
 
 // GetLatestTelemetry handles GET requests to /twins/{twinId}/telemetry/latest
+// Returns a map of name -> newest record, so sort order does not apply here. An unknown twin is
+// 404; a twin without telemetry gets an empty map.
 func (a *API) GetLatestTelemetry(w http.ResponseWriter, r *http.Request) {
 	twinID := chi.URLParam(r, "twinId")
 	if twinID == "" {
-		http.Error(w, "Missing twinId in URL path", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
 		return
 	}
 
@@ -687,9 +1337,11 @@ func (a *API) GetLatestTelemetry(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	latestValues, err := a.Store.QueryLatestTelemetry(ctx, twinID, namesFilter)
 	if err != nil {
-		// Again, don't assume 404, check twin existence separately if needed.
 		log.Printf("ERROR: Failed to query latest telemetry for twin '%s': %v", twinID, err)
-		http.Error(w, "Failed to retrieve latest telemetry", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve latest telemetry")
+		return
+	}
+	if len(latestValues) == 0 && !a.checkTwinExists(ctx, w, twinID) {
 		return
 	}
 
@@ -706,15 +1358,70 @@ func (a *API) GetLatestTelemetry(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// --- Readiness Handler ---
+
+// ReadinessHandler handles GET requests to /readyz.
+// Besides the status it reports a connection pool summary (when the store is pooled),
+// which helps tell whether the pool size is the bottleneck under load.
+func (a *API) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"status":    "ready",
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if provider, ok := persistence.Underlying(a.Store).(persistence.PoolStatsProvider); ok {
+		st := provider.PoolStats()
+		var avgAcquireWaitMs float64
+		if st.AcquireCount > 0 {
+			avgAcquireWaitMs = st.AcquireDuration.Seconds() * 1000 / float64(st.AcquireCount) // Sub-millisecond waits count too
+		}
+		response["pool"] = map[string]interface{}{
+			"totalConns":           st.TotalConns,
+			"idleConns":            st.IdleConns,
+			"acquiredConns":        st.AcquiredConns,
+			"maxConns":             st.MaxConns,
+			"saturated":            st.MaxConns > 0 && st.AcquiredConns >= st.MaxConns, // Every connection is checked out
+			"avgAcquireWaitMs":     avgAcquireWaitMs,
+			"emptyAcquireCount":    st.EmptyAcquireCount,
+			"canceledAcquireCount": st.CanceledAcquireCount,
+		}
+	}
+	if a.writeBreaker != nil {
+		response["writeBreaker"] = a.writeBreaker.status(time.Now())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("ERROR: Failed to encode readiness response: %v", err)
+	}
+}
+
 // --- Health Check Handler ---
-func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	// TODO: Enhance health check to ping DB via the store interface if needed
+
+// healthCheckTimeout bounds the database ping of GET /healthz, so a hung database fails the check
+// rather than the load balancer's probe timing out.
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheckHandler handles GET requests to /healthz.
+// It pings the database: 200 {"status":"ok"} when it answers, 503 {"status":"degraded",
+// "db":"unreachable"} when it doesn't within healthCheckTimeout.
+func (a *API) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	response := map[string]string{
 		"status":    "ok",
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
 	}
+	status := http.StatusOK
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	if err := a.Store.Ping(ctx); err != nil {
+		log.Printf("WARN: Health check failed to ping the database: %v", err)
+		response["status"] = "degraded"
+		response["db"] = "unreachable"
+		status = http.StatusServiceUnavailable
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Printf("ERROR: Failed to encode health check response: %v", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
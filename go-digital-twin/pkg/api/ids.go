@@ -0,0 +1,104 @@
+// pkg/api/ids.go
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Page size limits of the ID listings
+const (
+	defaultIDListLimit = 1000
+	maxIDListLimit     = 10000
+)
+
+// idPage is one page of GET /twins/ids or /models/ids.
+type idPage struct {
+	IDs        []string `json:"ids"`
+	NextCursor string   `json:"nextCursor,omitempty"` // Pass as ?cursor= for the next page; absent on the last page
+}
+
+// parseIDListLimit reads ?limit= for the ID listings. On an invalid value it writes the error
+// response and returns false.
+func parseIDListLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return defaultIDListLimit, true
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 || limit > maxIDListLimit {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid limit parameter: must be between 1 and %d", maxIDListLimit))
+		return 0, false
+	}
+	return limit, true
+}
+
+// writeIDPage trims ids, fetched with one extra entry, to limit and writes the page.
+func writeIDPage(w http.ResponseWriter, ids []string, limit int) {
+	page := idPage{IDs: ids}
+	if len(ids) > limit {
+		page.IDs = ids[:limit]
+		page.NextCursor = page.IDs[limit-1]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Printf("ERROR: Failed to encode ID list response: %v", err)
+	}
+}
+
+// ListTwinIDs handles GET requests to /twins/ids
+// Lists only twin IDs, ordered, for clients that sync or diff their twin set without fetching
+// the twins' properties. Optional ?modelId= and tag filters (tag=<key>:<value> or
+// tag.<key>=<value>) filter like ListTwins and the bulk delete; tag-scoped keys only see their
+// twins. ?limit= (default 1000, max 10000) IDs per page; follow nextCursor with ?cursor= until
+// it is absent:
+//
+//	{"ids": ["pump-1", "pump-2"], "nextCursor": "pump-2"}
+func (a *API) ListTwinIDs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, ok := parseIDListLimit(w, r)
+	if !ok {
+		return
+	}
+	selector, ok := parseTagSelector(w, query)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	selector, inScope := scopeTagSelector(ctx, selector)
+	if !inScope {
+		writeIDPage(w, []string{}, limit)
+		return
+	}
+
+	// Fetch one extra ID to learn whether another page exists
+	ids, err := a.Store.ListTwinIDs(ctx, selector, query.Get("modelId"), query.Get("cursor"), limit+1)
+	if err != nil {
+		log.Printf("ERROR: Failed to list twin IDs: %v", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twin IDs")
+		return
+	}
+	writeIDPage(w, ids, limit)
+}
+
+// ListModelIDs handles GET requests to /models/ids
+// Lists only model IDs, ordered and paged like ListTwinIDs.
+func (a *API) ListModelIDs(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseIDListLimit(w, r)
+	if !ok {
+		return
+	}
+
+	ids, err := a.Store.ListModelIDs(r.Context(), r.URL.Query().Get("cursor"), limit+1)
+	if err != nil {
+		log.Printf("ERROR: Failed to list model IDs: %v", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve model IDs")
+		return
+	}
+	writeIDPage(w, ids, limit)
+}
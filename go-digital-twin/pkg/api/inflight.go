@@ -0,0 +1,67 @@
+// pkg/api/inflight.go
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+)
+
+// inFlightPollInterval is how often Wait re-checks the in-flight count.
+const inFlightPollInterval = 50 * time.Millisecond
+
+// In-flight request metrics
+var inFlightGauge = metrics.NewGauge("http_requests_in_flight", "HTTP requests currently being handled (WebSocket upgrades excluded).")
+
+// InFlight counts the HTTP requests being handled, so shutdown can report them and wait for
+// them before closing the store. http.Server.Shutdown already waits for active connections, but
+// not past its deadline, and once it gives up handlers may still be running against the store.
+type InFlight struct {
+	count atomic.Int64
+}
+
+// NewInFlight creates an in-flight request counter; install it with Options.InFlight.
+func NewInFlight() *InFlight {
+	return &InFlight{}
+}
+
+// Middleware counts each request while its handler runs. WebSocket upgrades are not counted:
+// they stay open for the connection's lifetime and don't use the store once upgraded.
+func (f *InFlight) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		f.count.Add(1)
+		inFlightGauge.Inc()
+		defer func() {
+			f.count.Add(-1)
+			inFlightGauge.Dec()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Count returns the number of requests currently in flight.
+func (f *InFlight) Count() int {
+	return int(f.count.Load())
+}
+
+// Wait blocks until no requests are in flight or ctx is done (returning ctx.Err()).
+func (f *InFlight) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(inFlightPollInterval)
+	defer ticker.Stop()
+	for f.Count() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
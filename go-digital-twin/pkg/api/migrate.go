@@ -0,0 +1,243 @@
+// pkg/api/migrate.go
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// violationRenameConflict is reported when a rename target already holds a value that is not
+// itself renamed away (the rename would overwrite it).
+const violationRenameConflict = "RENAME_CONFLICT"
+
+// twinMigration is the body of POST /twins/{twinId}/migrate.
+type twinMigration struct {
+	ModelID          string            `json:"modelId"`
+	RenameProperties map[string]string `json:"renameProperties"` // Old key -> new key, in desired and reported properties
+}
+
+// propertyRename is one key renamed by a migration.
+type propertyRename struct {
+	Section string `json:"section"` // desired or reported
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+// migrationReport is the response of POST /twins/{twinId}/migrate. Code, Message and
+// RequestID are set (like the error envelope) when the migration was refused.
+type migrationReport struct {
+	Code      ErrorCode `json:"code,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+
+	TwinID      string                    `json:"twinId"`
+	FromModelID string                    `json:"fromModelId"`
+	ToModelID   string                    `json:"toModelId"`
+	DryRun      bool                      `json:"dryRun"`
+	Migrated    bool                      `json:"migrated"`
+	Renamed     []propertyRename          `json:"renamed"`
+	Violations  []model.PropertyViolation `json:"violations"`
+	Twin        twinView                  `json:"twin"` // After the migration (as it would be, on a dry run)
+}
+
+// validateRenames checks a rename map: keys and targets are non-empty, nothing is renamed to
+// itself and no two keys get the same target.
+func validateRenames(renames map[string]string) error {
+	targets := make(map[string]string, len(renames))
+	for _, from := range sortedKeys(renames) {
+		to := renames[from]
+		if from == "" || to == "" {
+			return errors.New("property names must not be empty")
+		}
+		if from == to {
+			return fmt.Errorf("'%s' is renamed to itself", from)
+		}
+		if other, dup := targets[to]; dup {
+			return fmt.Errorf("'%s' and '%s' are both renamed to '%s'", other, from, to)
+		}
+		targets[to] = from
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// renameProperties returns a copy of props with the renames applied. All renames apply at once,
+// so {"a": "b", "b": "a"} swaps two keys. A target that already holds a value which isn't renamed
+// away is a conflict: the value is kept and the conflict reported (the renamed value is dropped).
+func renameProperties(section string, props map[string]interface{}, renames map[string]string) (map[string]interface{}, []propertyRename, []model.PropertyViolation) {
+	renamed := []propertyRename{}
+	var conflicts []model.PropertyViolation
+	if props == nil {
+		return nil, renamed, nil // Unset stays unset
+	}
+	out := make(map[string]interface{}, len(props))
+	for key, v := range props {
+		if _, moved := renames[key]; !moved {
+			out[key] = v
+		}
+	}
+	for _, from := range sortedKeys(renames) {
+		v, ok := props[from]
+		if !ok {
+			continue
+		}
+		to := renames[from]
+		if _, taken := out[to]; taken {
+			conflicts = append(conflicts, model.PropertyViolation{Section: section, Property: to, Reason: violationRenameConflict,
+				Message: fmt.Sprintf("renaming '%s' to '%s' would overwrite the existing value of '%s'", from, to, to)})
+			continue
+		}
+		out[to] = v
+		renamed = append(renamed, propertyRename{Section: section, From: from, To: to})
+	}
+	return out, renamed, conflicts
+}
+
+// MigrateTwin handles POST requests to /twins/{twinId}/migrate
+// Moves the twin to another model, e.g. from "thermostat;1" to "thermostat;2":
+//
+//	{"modelId": "thermostat;2", "renameProperties": {"temp": "temperature"}}
+//
+// renameProperties (optional) renames keys of the desired and reported properties first.
+// The result is validated against the target model (see TwinModel.ValidateTwin). With violations
+// nothing changes and 422 MIGRATION_INVALID is returned with the report. Otherwise the model
+// reference and properties are written in one atomic update. The update only applies while the
+// twin is unchanged since it was read (412 if it changed in between or If-Match doesn't match).
+// ?dryRun=true only reports what the migration would do (200 with violations, if any).
+// The report is {"twinId", "fromModelId", "toModelId", "dryRun", "migrated", "renamed",
+// "violations", "twin"}. Stored telemetry names are not renamed.
+func (a *API) MigrateTwin(w http.ResponseWriter, r *http.Request) {
+	twinID := chi.URLParam(r, "twinId")
+	if twinID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
+		return
+	}
+	dryRun := false
+	if raw := r.URL.Query().Get("dryRun"); raw != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(raw); err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid 'dryRun' query parameter, expected true or false")
+			return
+		}
+	}
+
+	var req twinMigration
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	if req.ModelID == "" {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Missing required field: modelId")
+		return
+	}
+	if err := validateRenames(req.RenameProperties); err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid renameProperties: "+err.Error())
+		return
+	}
+
+	// Optional If-Match; either way the write is conditional on the version read here
+	ctx := r.Context()
+	current, ok := a.checkTwinPrecondition(w, r, twinID)
+	if !ok {
+		return
+	}
+	if current == nil {
+		var err error
+		if current, err = a.Store.FindTwinByID(ctx, twinID); err != nil {
+			log.Printf("DEBUG: Failed to find twin '%s' for migration: %v", twinID, err)
+			writeStoreError(w, err, resourceTwin, "Failed to retrieve twin")
+			return
+		}
+	}
+	if req.ModelID == current.ModelID && len(req.RenameProperties) == 0 {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("Twin '%s' already implements model '%s' and no properties are renamed", twinID, req.ModelID))
+		return
+	}
+
+	targetModel, err := a.Store.FindModelByID(ctx, req.ModelID)
+	if err != nil {
+		if errors.Is(err, persistence.ErrNotFound) {
+			writeError(w, http.StatusUnprocessableEntity, CodeModelReferenceInvalid, fmt.Sprintf("Referenced modelId '%s' not found", req.ModelID))
+		} else {
+			log.Printf("ERROR: Failed to load model '%s' for migrating twin '%s': %v", req.ModelID, twinID, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to load target model")
+		}
+		return
+	}
+
+	migrated := *current
+	migrated.ModelID = req.ModelID
+	migrated.UpdatedAt = time.Now().UTC()
+	desired, renamedDesired, desiredConflicts := renameProperties(model.SectionDesired, current.DesiredProperties, req.RenameProperties)
+	reported, renamedReported, reportedConflicts := renameProperties(model.SectionReported, current.ReportedProperties, req.RenameProperties)
+	migrated.DesiredProperties, migrated.ReportedProperties = desired, reported
+
+	report := migrationReport{
+		TwinID:      twinID,
+		FromModelID: current.ModelID,
+		ToModelID:   req.ModelID,
+		DryRun:      dryRun,
+		Renamed:     append(renamedDesired, renamedReported...),
+		Violations:  append(append(append([]model.PropertyViolation{}, desiredConflicts...), reportedConflicts...), targetModel.ValidateTwin(&migrated)...),
+	}
+	sort.SliceStable(report.Violations, func(i, j int) bool {
+		return report.Violations[i].Section < report.Violations[j].Section // desired before reported, as in ValidateTwin
+	})
+
+	policy := a.unsetMapsFor(w, r)
+	report.Twin = newTwinView(&migrated, policy)
+	status := http.StatusOK
+	switch {
+	case dryRun:
+	case len(report.Violations) > 0:
+		status = http.StatusUnprocessableEntity
+		report.Code = CodeMigrationInvalid
+		report.Message = fmt.Sprintf("Twin '%s' does not fit model '%s': %d violations", twinID, req.ModelID, len(report.Violations))
+		report.RequestID = w.Header().Get(RequestIDHeader)
+	default:
+		if err := a.Store.UpdateTwinIfUnmodified(ctx, &migrated, current.UpdatedAt); err != nil {
+			log.Printf("ERROR: Failed to migrate twin '%s' to model '%s': %v", twinID, req.ModelID, err)
+			writeStoreError(w, err, resourceTwin, "Failed to migrate twin")
+			return
+		}
+		final, err := a.Store.FindTwinByID(ctx, twinID)
+		if err != nil {
+			log.Printf("ERROR: Failed to retrieve twin '%s' after migration: %v", twinID, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twin after migration")
+			return
+		}
+		report.Migrated, report.Twin = true, newTwinView(final, policy)
+		setTwinETag(w, final)
+		log.Printf("INFO: Migrated twin '%s' from model '%s' to '%s' (%d properties renamed)", twinID, current.ModelID, req.ModelID, len(report.Renamed))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("ERROR: Failed to encode migration report: %v", err)
+	}
+}
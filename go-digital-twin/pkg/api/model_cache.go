@@ -0,0 +1,115 @@
+// pkg/api/model_cache.go
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/auth"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+)
+
+// maxModelCacheEntries bounds the cache; ?category= is client-controlled, so keys are not.
+const maxModelCacheEntries = 1024
+
+// Model cache metrics
+var (
+	modelCacheHits   = metrics.NewCounter("model_cache_hits_total", "ListModels/GetModel requests answered from the in-process model cache.")
+	modelCacheMisses = metrics.NewCounter("model_cache_misses_total", "ListModels/GetModel requests that had to read models from the store.")
+)
+
+// modelCache is a short-lived in-process cache of ListModels and GetModel results, keyed by the
+// query. Any model create/update/delete through this process clears it, so a client never reads
+// its own write stale; changes made through other replicas show up once entries expire.
+// A nil *modelCache caches nothing.
+type modelCache struct {
+	ttl time.Duration
+
+	mu         sync.Mutex
+	generation uint64 // Bumped by invalidate, so loads that started before it aren't cached
+	entries    map[string]modelCacheEntry
+}
+
+// modelCacheEntry is one cached result. Values are shared between requests and must not be modified.
+type modelCacheEntry struct {
+	value    interface{} // []*model.TwinModel (sorted by ID), *model.TwinModel or map[string]*model.TwinModel (see reportedCheckModels)
+	storedAt time.Time
+}
+
+// newModelCache creates a cache whose entries expire after ttl; ttl <= 0 disables caching (nil).
+func newModelCache(ttl time.Duration) *modelCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &modelCache{ttl: ttl, entries: make(map[string]modelCacheEntry)}
+}
+
+// get returns the cached value for key, or calls load and caches its result. Errors aren't cached.
+func (c *modelCache) get(key string, load func() (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		return load()
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	generation := c.generation
+	c.mu.Unlock()
+	if ok && time.Since(entry.storedAt) < c.ttl {
+		modelCacheHits.Inc()
+		return entry.value, nil
+	}
+
+	modelCacheMisses.Inc()
+	value, err := load() // Outside the lock; concurrent misses for a key may each load it
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return value, nil // A model changed while loading: the value may predate it
+	}
+	if len(c.entries) >= maxModelCacheEntries {
+		c.evictExpiredLocked()
+	}
+	if len(c.entries) < maxModelCacheEntries {
+		c.entries[key] = modelCacheEntry{value: value, storedAt: time.Now()}
+	}
+	return value, nil
+}
+
+// evictExpiredLocked drops the entries past their TTL. The caller holds c.mu.
+func (c *modelCache) evictExpiredLocked() {
+	for key, entry := range c.entries {
+		if time.Since(entry.storedAt) >= c.ttl {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// invalidate drops every entry; call it after any model write (lists depend on every model).
+func (c *modelCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries = make(map[string]modelCacheEntry)
+}
+
+// setCacheControl lets clients (and, for unauthenticated deployments, shared caches) reuse a
+// model response for as long as the server itself would. Nothing is set when caching is disabled.
+func (c *modelCache) setCacheControl(w http.ResponseWriter, r *http.Request) {
+	if c == nil {
+		return
+	}
+	scope := "public"
+	if auth.FromContext(r.Context()) != nil {
+		scope = "private" // The API needs a key; keep responses out of shared caches
+	}
+	w.Header().Set("Cache-Control", scope+", max-age="+strconv.Itoa(int(c.ttl/time.Second)))
+}
@@ -0,0 +1,244 @@
+// pkg/api/model_history.go
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/auth"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// withChangedBy attributes the model changes made with the request's context to its API key.
+func withChangedBy(r *http.Request) context.Context {
+	ctx := r.Context()
+	if p := auth.FromContext(ctx); p != nil {
+		return persistence.WithChangedBy(ctx, p.Actor())
+	}
+	return ctx
+}
+
+// ListModelHistory handles GET requests to /models/{modelId}/history
+// Lists the model's recorded versions oldest first (version, change, changedAt, changedBy);
+// fetch a definition with /history/{version}. A deleted model's history is still listed.
+// To revert, PUT the definition of an earlier version back to /models/{modelId}.
+func (a *API) ListModelHistory(w http.ResponseWriter, r *http.Request) {
+	modelID := chi.URLParam(r, "modelId")
+	if modelID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing modelId in URL path")
+		return
+	}
+
+	ctx := r.Context()
+	versions, err := a.Store.ListModelVersions(ctx, modelID)
+	if err != nil {
+		log.Printf("ERROR: Failed to list versions of model '%s': %v", modelID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve model history")
+		return
+	}
+	if len(versions) == 0 {
+		// Models that predate history tracking have none until their next change
+		if _, err := a.Store.FindModelByID(ctx, modelID); err != nil {
+			log.Printf("DEBUG: Failed to find model '%s' for its history: %v", modelID, err)
+			writeStoreError(w, err, resourceModel, "Failed to retrieve model")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(versions); err != nil {
+		log.Printf("ERROR: Failed to encode model history response: %v", err)
+	}
+}
+
+// parseModelVersion parses a version number (a positive integer) from the URL or query.
+func parseModelVersion(w http.ResponseWriter, name, v string) (int, bool) {
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid %s: must be a positive version number", name))
+		return 0, false
+	}
+	return version, true
+}
+
+// GetModelVersion handles GET requests to /models/{modelId}/history/{version}
+// Returns the version with the model definition as it was after that change (before it, for a deletion).
+func (a *API) GetModelVersion(w http.ResponseWriter, r *http.Request) {
+	modelID := chi.URLParam(r, "modelId")
+	if modelID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing modelId in URL path")
+		return
+	}
+	version, ok := parseModelVersion(w, "version", chi.URLParam(r, "version"))
+	if !ok {
+		return
+	}
+
+	v, err := a.Store.FindModelVersion(r.Context(), modelID, version)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find version %d of model '%s': %v", version, modelID, err)
+		writeStoreError(w, err, resourceModelVersion, "Failed to retrieve model version")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("ERROR: Failed to encode model version response: %v", err)
+	}
+}
+
+// Kinds of modelChange.
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeChanged = "changed"
+)
+
+// modelChange is one difference between two model definitions.
+type modelChange struct {
+	Path string      `json:"path"` // JSON Pointer into the definition, e.g. /properties/setpoint/unit
+	Kind string      `json:"kind"` // added, removed or changed
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// DiffModelVersions handles GET requests to /models/{modelId}/history/diff?from=&to=
+// Lists what changed in the definition between two versions. to defaults to the latest version
+// and from to the one before to. Objects are compared key by key; arrays (e.g.
+// allowedTelemetryNames) and other values as a whole. updatedAt is ignored.
+func (a *API) DiffModelVersions(w http.ResponseWriter, r *http.Request) {
+	modelID := chi.URLParam(r, "modelId")
+	if modelID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing modelId in URL path")
+		return
+	}
+
+	ctx := r.Context()
+	query := r.URL.Query()
+	var to int
+	if v := query.Get("to"); v != "" {
+		var ok bool
+		if to, ok = parseModelVersion(w, "to parameter", v); !ok {
+			return
+		}
+	} else {
+		versions, err := a.Store.ListModelVersions(ctx, modelID)
+		if err != nil {
+			log.Printf("ERROR: Failed to list versions of model '%s': %v", modelID, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve model history")
+			return
+		}
+		if len(versions) == 0 {
+			msg, code := resourceModelVersion.notFound()
+			writeError(w, http.StatusNotFound, code, msg)
+			return
+		}
+		to = versions[len(versions)-1].Version
+	}
+	from := to - 1
+	if v := query.Get("from"); v != "" {
+		var ok bool
+		if from, ok = parseModelVersion(w, "from parameter", v); !ok {
+			return
+		}
+	}
+	if from < 1 || from >= to {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid version range: from must be at least 1 and lower than to")
+		return
+	}
+
+	definitions := make([]map[string]interface{}, 0, 2)
+	for _, version := range []int{from, to} {
+		v, err := a.Store.FindModelVersion(ctx, modelID, version)
+		if err != nil {
+			log.Printf("DEBUG: Failed to find version %d of model '%s': %v", version, modelID, err)
+			writeStoreError(w, err, resourceModelVersion, "Failed to retrieve model version")
+			return
+		}
+		def, err := definitionObject(v)
+		if err != nil {
+			log.Printf("ERROR: Failed to decode version %d of model '%s': %v", version, modelID, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to compare model versions")
+			return
+		}
+		delete(def, "updatedAt")
+		definitions = append(definitions, def)
+	}
+
+	changes := []modelChange{}
+	diffJSON("", definitions[0], definitions[1], &changes)
+
+	response := map[string]interface{}{
+		"modelId": modelID,
+		"from":    from,
+		"to":      to,
+		"changes": changes,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("ERROR: Failed to encode model diff response: %v", err)
+	}
+}
+
+// definitionObject converts a version's definition to its generic JSON form for diffing.
+func definitionObject(v *persistence.ModelVersion) (map[string]interface{}, error) {
+	data, err := json.Marshal(v.Model)
+	if err != nil {
+		return nil, err
+	}
+	def := map[string]interface{}{}
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+// diffJSON appends the differences between two decoded JSON values to changes, recursing into
+// objects. Keys are visited in sorted order so the result is deterministic.
+func diffJSON(path string, from, to interface{}, changes *[]modelChange) {
+	fromObj, fromIsObj := from.(map[string]interface{})
+	toObj, toIsObj := to.(map[string]interface{})
+	if !fromIsObj || !toIsObj {
+		if !reflect.DeepEqual(from, to) {
+			*changes = append(*changes, modelChange{Path: path, Kind: changeChanged, From: from, To: to})
+		}
+		return
+	}
+
+	keys := make([]string, 0, len(fromObj)+len(toObj))
+	for k := range fromObj {
+		keys = append(keys, k)
+	}
+	for k := range toObj {
+		if _, ok := fromObj[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := path + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(k)
+		fv, inFrom := fromObj[k]
+		tv, inTo := toObj[k]
+		switch {
+		case !inFrom:
+			*changes = append(*changes, modelChange{Path: p, Kind: changeAdded, To: tv})
+		case !inTo:
+			*changes = append(*changes, modelChange{Path: p, Kind: changeRemoved, From: fv})
+		default:
+			diffJSON(p, fv, tv, changes)
+		}
+	}
+}
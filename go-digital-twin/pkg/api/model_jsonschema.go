@@ -0,0 +1,50 @@
+// pkg/api/model_jsonschema.go
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// mediaTypeJSONSchema is the content type of JSON Schema documents.
+const mediaTypeJSONSchema = "application/schema+json"
+
+// GetModelJSONSchema handles GET requests to /models/{modelId}/jsonschema
+// Responds with a JSON Schema (2020-12) document of the desired properties twins of the model
+// accept: the writable properties with their types and enums (see
+// model.TwinModel.DesiredPropertiesSchema). It is generated from the model definition on every
+// request, so it always matches the server's own validation.
+func (a *API) GetModelJSONSchema(w http.ResponseWriter, r *http.Request) {
+	modelID := chi.URLParam(r, "modelId")
+	if modelID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing modelId in URL path")
+		return
+	}
+
+	ctx := r.Context()
+	found, err := a.models.get("model:"+modelID, func() (interface{}, error) {
+		return a.Store.FindModelByID(ctx, modelID)
+	})
+	if err != nil {
+		log.Printf("DEBUG: Failed to find model '%s': %v", modelID, err)
+		writeStoreError(w, err, resourceModel, "Failed to retrieve model")
+		return
+	}
+	m := found.(*model.TwinModel)
+
+	a.models.setCacheControl(w, r)
+	if setLastModified(w, r, m.UpdatedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", mediaTypeJSONSchema)
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(m.DesiredPropertiesSchema()); err != nil {
+		log.Printf("ERROR: Failed to encode JSON Schema of model '%s': %v", modelID, err)
+	}
+}
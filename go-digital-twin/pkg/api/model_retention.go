@@ -0,0 +1,79 @@
+// pkg/api/model_retention.go
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// Where a telemetry name's effective retention comes from
+const (
+	retentionSourceModel   = "model"   // The model's telemetry definition
+	retentionSourceDefault = "default" // The server-wide TELEMETRY_RETENTION
+)
+
+// retentionPolicy is one effective retention. Retention is empty when data is kept forever.
+type retentionPolicy struct {
+	Retention        string `json:"retention,omitempty"` // e.g. "7d" (see model.ParseRetention)
+	RetentionSeconds int64  `json:"retentionSeconds,omitempty"`
+	Source           string `json:"source,omitempty"`
+}
+
+func newRetentionPolicy(d time.Duration, source string) retentionPolicy {
+	if d <= 0 {
+		return retentionPolicy{Source: source}
+	}
+	return retentionPolicy{Retention: model.FormatRetention(d), RetentionSeconds: int64(d / time.Second), Source: source}
+}
+
+// GetModelRetention handles GET requests to /models/{modelId}/retention
+// Reports how long the model's telemetry is kept: "default" is the server-wide policy, applied to
+// every name without a model-defined retention, and "telemetry" lists each name the model defines
+// or allows, with its effective retention and whether it comes from the model or the default.
+// Names outside that list follow the default too.
+func (a *API) GetModelRetention(w http.ResponseWriter, r *http.Request) {
+	modelID := chi.URLParam(r, "modelId")
+	if modelID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing modelId in URL path")
+		return
+	}
+
+	ctx := r.Context()
+	found, err := a.models.get("model:"+modelID, func() (interface{}, error) {
+		return a.Store.FindModelByID(ctx, modelID)
+	})
+	if err != nil {
+		log.Printf("DEBUG: Failed to find model '%s': %v", modelID, err)
+		writeStoreError(w, err, resourceModel, "Failed to retrieve model")
+		return
+	}
+	m := found.(*model.TwinModel)
+
+	defaultPolicy := newRetentionPolicy(a.TelemetryRetention, retentionSourceDefault)
+	telemetry := make(map[string]retentionPolicy, len(m.Telemetry)+len(m.AllowedTelemetryNames))
+	for _, name := range m.AllowedTelemetryNames {
+		telemetry[name] = defaultPolicy
+	}
+	for name := range m.Telemetry {
+		telemetry[name] = defaultPolicy
+	}
+	for name, d := range m.TelemetryRetentions() {
+		telemetry[name] = newRetentionPolicy(d, retentionSourceModel)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"modelId":   m.ID,
+		"default":   defaultPolicy,
+		"telemetry": telemetry,
+	}); err != nil {
+		log.Printf("ERROR: Failed to encode retention response for model '%s': %v", modelID, err)
+	}
+}
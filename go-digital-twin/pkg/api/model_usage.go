@@ -0,0 +1,73 @@
+// pkg/api/model_usage.go
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// Telemetry windows of GET /models/{modelId}/usage
+const (
+	defaultUsageWindow = 24 * time.Hour
+	maxUsageWindow     = 90 * 24 * time.Hour
+)
+
+// modelUsage is the response of GET /models/{modelId}/usage.
+type modelUsage struct {
+	*persistence.ModelUsage
+	TelemetryWindow string `json:"telemetryWindow"` // The window asked for, e.g. "24h" or "7d"
+}
+
+// GetModelUsage handles GET requests to /models/{modelId}/usage
+// The blast radius of changing or deleting the model: how many twins use it, when the oldest and
+// newest were created, and the telemetry points they stored in the last ?window= ("24h" or "7d";
+// default 24 hours, at most 90 days) and how many twins reported them. An unused model reports
+// zeros. Tag-scoped keys get the usage of their twins only.
+func (a *API) GetModelUsage(w http.ResponseWriter, r *http.Request) {
+	modelID := chi.URLParam(r, "modelId")
+	if modelID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing modelId in URL path")
+		return
+	}
+
+	window := defaultUsageWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		parsed, err := model.ParseRetention(v)
+		if err != nil || parsed <= 0 || parsed > maxUsageWindow {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid window %q: use a duration such as \"24h\" or \"7d\", at most %s", v, model.FormatRetention(maxUsageWindow)))
+			return
+		}
+		window = parsed
+	}
+
+	ctx := r.Context()
+	if _, err := a.models.get("model:"+modelID, func() (interface{}, error) {
+		return a.Store.FindModelByID(ctx, modelID)
+	}); err != nil {
+		log.Printf("DEBUG: Failed to find model '%s': %v", modelID, err)
+		writeStoreError(w, err, resourceModel, "Failed to retrieve model")
+		return
+	}
+
+	scope, _ := scopeTagSelector(ctx, nil) // No selector of its own, so always in scope
+	usage, err := a.Store.SummarizeModelUsage(ctx, modelID, scope, time.Now().Add(-window).Truncate(time.Second))
+	if err != nil {
+		log.Printf("ERROR: Failed to summarize usage of model '%s': %v", modelID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to summarize model usage")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(modelUsage{ModelUsage: usage, TelemetryWindow: model.FormatRetention(window)}); err != nil {
+		log.Printf("ERROR: Failed to encode usage of model '%s': %v", modelID, err)
+	}
+}
@@ -0,0 +1,131 @@
+// pkg/api/near.go
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/auth"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/geo"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// Limits of GET /twins/near
+const (
+	defaultNearLimit = 100
+	maxNearLimit     = 1000
+)
+
+// nearTwin is one result of GET /twins/near: the twin plus its distance from the center.
+type nearTwin struct {
+	twinView
+	DistanceMeters float64 `json:"distanceMeters"`
+}
+
+// parseCoordinate reads a required decimal-degree query parameter.
+func parseCoordinate(w http.ResponseWriter, r *http.Request, name string) (float64, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Missing required query parameter: %s", name))
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid '%s' query parameter, expected decimal degrees", name))
+		return 0, false
+	}
+	return v, true
+}
+
+// NearTwins handles GET requests to /twins/near
+// Lists the twins with a location within radius of a point, nearest first:
+//
+//	GET /twins/near?lat=43.2389&lng=76.8897&radius=5km
+//
+// radius accepts meters or kilometers ("500m", "5km"; a bare number is meters). Optional modelId
+// restricts the model and limit (default 100, max 1000) the number of twins returned.
+// Candidates are prefiltered by bounding box in the store and then measured exactly
+// (great-circle distance); twins without a location are never returned. Each twin carries
+// "distanceMeters"; ties are ordered by ID.
+func (a *API) NearTwins(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	lat, ok := parseCoordinate(w, r, "lat")
+	if !ok {
+		return
+	}
+	lng, ok := parseCoordinate(w, r, "lng")
+	if !ok {
+		return
+	}
+	center := model.GeoPoint{Lat: lat, Lng: lng}
+	if err := center.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid center: "+err.Error())
+		return
+	}
+
+	radiusStr := query.Get("radius")
+	if radiusStr == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing required query parameter: radius")
+		return
+	}
+	radius, err := geo.ParseDistance(radiusStr)
+	if err != nil || radius <= 0 || radius > geo.MaxRadius {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid 'radius' query parameter: must be a positive distance up to %.0fkm (e.g., 500m, 5km)", geo.MaxRadius/1000))
+		return
+	}
+
+	limit := defaultNearLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > maxNearLimit {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid limit parameter: must be between 1 and %d", maxNearLimit))
+			return
+		}
+		limit = parsed
+	}
+	modelID := query.Get("modelId")
+
+	// Scoped API keys only see their twins: their selector goes into the query
+	ctx := r.Context()
+	var selector map[string]string
+	if p := auth.FromContext(ctx); !p.Unrestricted() {
+		selector = p.Tags
+	}
+	candidates, err := a.Store.ListTwinsInBox(ctx, geo.BoundingBox(center, radius), selector, modelID)
+	if err != nil {
+		log.Printf("ERROR: Failed to list twins near %v: %v", center, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twins")
+		return
+	}
+
+	policy := a.unsetMapsFor(w, r)
+	twins := []nearTwin{}
+	for _, t := range candidates {
+		if d := geo.Distance(center, *t.Location); d <= radius {
+			twins = append(twins, nearTwin{twinView: newTwinView(t, policy), DistanceMeters: d})
+		}
+	}
+	sort.SliceStable(twins, func(i, j int) bool { // Candidates come ordered by ID
+		return twins[i].DistanceMeters < twins[j].DistanceMeters
+	})
+	truncated := len(twins) > limit
+	if truncated {
+		twins = twins[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"center":       center,
+		"radiusMeters": radius,
+		"count":        len(twins),
+		"truncated":    truncated,
+		"twins":        twins,
+	}); err != nil {
+		log.Printf("ERROR: Failed to encode nearby twins response: %v", err)
+	}
+}
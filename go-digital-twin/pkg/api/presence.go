@@ -0,0 +1,119 @@
+// pkg/api/presence.go
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// Presence keepalive timing. The server pings every presencePingInterval; a connection that
+// sends nothing (not even a pong) for presencePongWait is considered dead and closed.
+const (
+	presencePingInterval = 25 * time.Second
+	presencePongWait     = 60 * time.Second
+	presenceWriteWait    = 10 * time.Second
+)
+
+// presenceUpgrader upgrades device connections. The default origin check only rejects
+// cross-origin browser requests; devices normally send no Origin header at all.
+var presenceUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// ConnectPresence handles WebSocket upgrades on /twins/{twinId}/presence/connect
+// A device keeps this socket open to be reported online. Any message it sends (or any pong to
+// the server's pings) refreshes lastSeen; message contents are ignored. The twin goes offline
+// when its last socket closes or misses keepalives for presencePongWait.
+func (a *API) ConnectPresence(w http.ResponseWriter, r *http.Request) {
+	twinID := chi.URLParam(r, "twinId")
+	if twinID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
+		return
+	}
+
+	// Check the twin before upgrading so the device gets a normal 404
+	if _, err := a.Store.FindTwinByID(r.Context(), twinID); err != nil {
+		log.Printf("DEBUG: Failed to find twin '%s' for presence connection: %v", twinID, err)
+		writeStoreError(w, err, resourceTwin, "Failed to retrieve twin")
+		return
+	}
+
+	conn, err := presenceUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an HTTP error response
+		log.Printf("WARN: Presence upgrade failed for twin '%s': %v", twinID, err)
+		return
+	}
+	defer conn.Close()
+
+	a.Presence.Connect(twinID)
+	defer a.Presence.Disconnect(twinID)
+	log.Printf("INFO: Twin %s connected (presence) from %s", twinID, r.RemoteAddr)
+
+	// Any inbound traffic proves the device is alive
+	alive := func() {
+		a.Presence.Touch(twinID)
+		conn.SetReadDeadline(time.Now().Add(presencePongWait))
+	}
+	conn.SetReadDeadline(time.Now().Add(presencePongWait))
+	conn.SetPongHandler(func(string) error {
+		alive()
+		return nil
+	})
+
+	// Pinger; stops when the read loop below exits
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(presencePingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(presenceWriteWait)); err != nil {
+					return // The read loop will notice the broken connection
+				}
+			}
+		}
+	}()
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WARN: Presence connection for twin '%s' ended: %v", twinID, err)
+			}
+			break
+		}
+		alive()
+	}
+	log.Printf("INFO: Twin %s disconnected (presence)", twinID)
+}
+
+// GetTwinPresence handles GET requests to /twins/{twinId}/presence
+func (a *API) GetTwinPresence(w http.ResponseWriter, r *http.Request) {
+	twinID := chi.URLParam(r, "twinId")
+	if twinID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
+		return
+	}
+
+	if _, err := a.Store.FindTwinByID(r.Context(), twinID); err != nil {
+		log.Printf("DEBUG: Failed to find twin '%s' for presence: %v", twinID, err)
+		writeStoreError(w, err, resourceTwin, "Failed to retrieve twin")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(a.Presence.Status(twinID)); err != nil {
+		log.Printf("ERROR: Failed to encode presence response: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+// pkg/api/pretty.go
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// prettyIndent is the indentation of pretty-printed JSON responses.
+const prettyIndent = "  "
+
+// prettyJSON re-indents JSON responses when the request asks for ?pretty=true, for people
+// reading the API with curl; responses stay compact otherwise. It wraps every route, so handlers
+// keep encoding compact JSON and error envelopes are covered too. Other media types (YAML,
+// NDJSON, Prometheus text) are passed through, and so is a JSON response once the handler
+// flushes it: streamed exports keep streaming, compact.
+func prettyJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := r.URL.Query().Get("pretty")
+		if raw == "" || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pretty, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid 'pretty' query parameter, expected true or false")
+			return
+		}
+		if !pretty {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		pw := &prettyWriter{ResponseWriter: w}
+		next.ServeHTTP(pw, r)
+		pw.finish()
+	})
+}
+
+// prettyWriter buffers a JSON response body so finish can indent it.
+type prettyWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	buffering   bool // The response is JSON and hasn't been flushed yet
+	status      int
+	body        bytes.Buffer
+}
+
+// WriteHeader decides from the Content-Type whether the body is buffered. The status of a
+// buffered response is only sent by finish, once the body's final length is known.
+func (pw *prettyWriter) WriteHeader(status int) {
+	if pw.wroteHeader {
+		return
+	}
+	pw.wroteHeader = true
+	mt, _, _ := mime.ParseMediaType(pw.Header().Get("Content-Type"))
+	if mt == mediaTypeJSON || strings.HasSuffix(mt, "+json") {
+		pw.buffering, pw.status = true, status
+		pw.Header().Del("Content-Length")
+		return
+	}
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+// Write buffers JSON bodies and passes everything else through.
+func (pw *prettyWriter) Write(b []byte) (int, error) {
+	pw.WriteHeader(http.StatusOK)
+	if pw.buffering {
+		return pw.body.Write(b)
+	}
+	return pw.ResponseWriter.Write(b)
+}
+
+// Flush gives up on indenting: what was buffered is sent as is and the rest streams through.
+func (pw *prettyWriter) Flush() {
+	if pw.buffering {
+		pw.buffering = false
+		pw.ResponseWriter.WriteHeader(pw.status)
+		_, _ = pw.ResponseWriter.Write(pw.body.Bytes())
+		pw.body.Reset()
+	}
+	if f, ok := pw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes a buffered response, indented when it is valid JSON.
+func (pw *prettyWriter) finish() {
+	if !pw.buffering {
+		return
+	}
+	body := pw.body.Bytes()
+	var indented bytes.Buffer
+	if len(body) > 0 && json.Indent(&indented, body, "", prettyIndent) == nil {
+		body = indented.Bytes()
+	}
+	pw.ResponseWriter.WriteHeader(pw.status)
+	_, _ = pw.ResponseWriter.Write(body)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (pw *prettyWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
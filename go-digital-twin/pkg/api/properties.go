@@ -0,0 +1,107 @@
+// pkg/api/properties.go
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// Property validation modes (see PropertyValidation)
+const (
+	ValidationLenient = "lenient"
+	ValidationStrict  = "strict"
+)
+
+// PropertyValidation sets how strictly property writes are checked against the twin's model,
+// separately for desired properties (set by applications) and reported properties (sent by
+// devices, whose firmware may know properties the model doesn't yet). Read-only, enum and
+// strictReportedTypes checks apply in both modes; the modes differ on the rest:
+//
+//   - Desired: lenient (also "") accepts keys the model doesn't define and values of any type;
+//     strict rejects them with 422 UNKNOWN_PROPERTY or PROPERTY_TYPE_MISMATCH.
+//   - Reported: lenient (also "") stores keys the model doesn't define, logging a warning and
+//     listing them as unknownProperties in the batch results; strict rejects those twins of the
+//     batch with code UNKNOWN_PROPERTY, and twins whose values don't fit their schema with code
+//     PROPERTY_TYPE_MISMATCH (which a model's strictReportedTypes enforces in either mode).
+//
+// A model without property definitions accepts any key in either mode.
+type PropertyValidation struct {
+	Desired  string // ValidationLenient or ValidationStrict
+	Reported string // ValidationLenient or ValidationStrict
+}
+
+// checkDesiredProperties rejects desired properties that the model marks as read-only (422
+// PROPERTY_NOT_WRITABLE listing the offending keys) or whose value is outside the property's enum
+// (422 VALUE_NOT_IN_ENUM listing the allowed values). Under strict desired validation, keys the
+// model doesn't define (422 UNKNOWN_PROPERTY) and values that don't fit their schema (422
+// PROPERTY_TYPE_MISMATCH) are rejected too. Writes the error and returns false.
+func (a *API) checkDesiredProperties(w http.ResponseWriter, m *model.TwinModel, desired map[string]interface{}) bool {
+	if a.PropertyValidation.Desired == ValidationStrict {
+		if unknown := m.UnknownProperties(desired); len(unknown) > 0 {
+			writeError(w, http.StatusUnprocessableEntity, CodeUnknownProperty,
+				fmt.Sprintf("Desired properties include properties model '%s' doesn't define: %s", m.ID, strings.Join(unknown, ", ")))
+			return false
+		}
+		if mismatches := m.SchemaViolations(desired); len(mismatches) > 0 {
+			writeError(w, http.StatusUnprocessableEntity, CodePropertyTypeMismatch,
+				fmt.Sprintf("Desired properties don't fit model '%s': %s", m.ID, strings.Join(mismatches, "; ")))
+			return false
+		}
+	}
+	readOnly := m.ReadOnlyProperties(desired)
+	if len(readOnly) > 0 {
+		writeError(w, http.StatusUnprocessableEntity, CodePropertyNotWritable,
+			fmt.Sprintf("Desired properties include read-only properties of model '%s': %s", m.ID, strings.Join(readOnly, ", ")))
+		return false
+	}
+	if outside := m.EnumViolations(desired); len(outside) > 0 {
+		key := outside[0]
+		writeError(w, http.StatusUnprocessableEntity, CodeValueNotInEnum,
+			fmt.Sprintf("Desired property '%s' of model '%s' must be one of: %s (got %v)", key, m.ID, m.Properties[key].EnumList(), desired[key]))
+		return false
+	}
+	return true
+}
+
+// checkTwinTags rejects tags that break the model's tag schema (422 TAG_SCHEMA_VIOLATION listing
+// every missing required tag and disallowed value). Writes the error and returns false.
+func checkTwinTags(w http.ResponseWriter, m *model.TwinModel, tags map[string]string) bool {
+	violations := m.TagViolations(tags)
+	if len(violations) == 0 {
+		return true
+	}
+	messages := make([]string, len(violations))
+	for i, v := range violations {
+		messages[i] = v.Message
+	}
+	writeError(w, http.StatusUnprocessableEntity, CodeTagSchemaViolation,
+		fmt.Sprintf("Tags don't fit the tag schema of model '%s': %s", m.ID, strings.Join(messages, "; ")))
+	return false
+}
+
+// modelForTwin loads the model of an existing twin (reusing twin when the caller already has it).
+// Writes 404/500 and returns false on failure.
+func (a *API) modelForTwin(ctx context.Context, w http.ResponseWriter, twinID string, twin *model.TwinInstance) (*model.TwinModel, bool) {
+	if twin == nil {
+		var err error
+		if twin, err = a.Store.FindTwinByID(ctx, twinID); err != nil {
+			log.Printf("DEBUG: Failed to find twin '%s': %v", twinID, err)
+			writeStoreError(w, err, resourceTwin, "Failed to retrieve twin")
+			return nil, false
+		}
+	}
+
+	m, err := a.Store.FindModelByID(ctx, twin.ModelID)
+	if err != nil {
+		// The FK on model_id means this should only happen on a DB failure
+		log.Printf("ERROR: Failed to load model '%s' of twin '%s': %v", twin.ModelID, twinID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to load twin model")
+		return nil, false
+	}
+	return m, true
+}
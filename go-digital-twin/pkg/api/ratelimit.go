@@ -0,0 +1,148 @@
+// pkg/api/ratelimit.go
+package api
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/auth"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+)
+
+// rateLimitSweepInterval is how often buckets that have refilled completely are dropped (a full
+// bucket is the same as no bucket).
+const rateLimitSweepInterval = time.Minute
+
+var apiRateLimitedTotal = metrics.NewCounter("api_requests_rate_limited_total", "API requests rejected with 429 RATE_LIMITED because their key ran out of budget.")
+
+// tokenBucket is one key's budget: tokens refill continuously up to the burst.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds a token bucket per key. A single mutex guards every bucket, so the budget
+// reported to a request is exactly what its own take left, however many run concurrently.
+type rateLimiter struct {
+	rate  float64 // Tokens per second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// rateLimitState is a key's budget right after a take.
+type rateLimitState struct {
+	allowed    bool
+	remaining  int           // Whole requests left
+	reset      time.Duration // Until the bucket is full again
+	retryAfter time.Duration // Until the next request is allowed (when !allowed)
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &rateLimiter{rate: float64(perMinute) / 60, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// take spends one token of key's bucket if there is one.
+func (l *rateLimiter) take(key string, now time.Time) rateLimitState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		for k, b := range l.buckets {
+			if l.refill(b, now) >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens, b.last = l.refill(b, now), now
+
+	state := rateLimitState{allowed: b.tokens >= 1}
+	if state.allowed {
+		b.tokens--
+	} else {
+		state.retryAfter = l.duration(1 - b.tokens)
+	}
+	state.remaining = int(math.Floor(b.tokens))
+	state.reset = l.duration(l.burst - b.tokens)
+	return state
+}
+
+// refill returns b's tokens as of now.
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) float64 {
+	return math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
+
+// duration is how long refilling the given number of tokens takes.
+func (l *rateLimiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// rateLimitKey identifies whose budget a request spends: the API key's name (a device token's ID)
+// when authenticated, the client address (after RealIP) otherwise.
+func rateLimitKey(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p.Device() {
+		return "token:" + p.TokenID
+	} else if p != nil {
+		return "key:" + p.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// ceilSeconds rounds d up to whole seconds, as the rate limit headers carry.
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// rateLimit allows each key (see rateLimitKey) perMinute requests per minute with bursts of up
+// to burst (zero means perMinute). Every response carries the key's budget:
+//
+//	X-RateLimit-Limit: the bucket size (burst)
+//	X-RateLimit-Remaining: requests left right now
+//	X-RateLimit-Reset: seconds until the bucket is full again
+//
+// Requests beyond the budget get 429 RATE_LIMITED with Retry-After (seconds until the next one
+// is allowed). perMinute <= 0 disables the limiter and the headers.
+func rateLimit(perMinute, burst int) func(http.Handler) http.Handler {
+	if perMinute <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	limiter := newRateLimiter(perMinute, burst)
+	limit := strconv.Itoa(int(limiter.burst))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state := limiter.take(rateLimitKey(r), time.Now())
+			w.Header().Set("X-RateLimit-Limit", limit)
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(state.remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(ceilSeconds(state.reset), 10))
+			if !state.allowed {
+				apiRateLimitedTotal.Inc()
+				w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(state.retryAfter), 10))
+				writeError(w, http.StatusTooManyRequests, CodeRateLimited, fmt.Sprintf("Rate limit of %d requests per minute exceeded, retry later", perMinute))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
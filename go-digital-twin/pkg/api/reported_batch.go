@@ -0,0 +1,254 @@
+// pkg/api/reported_batch.go
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/auth"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// Per-twin outcomes of a reported properties batch
+const (
+	reportedBatchUpdated  = "updated"
+	reportedBatchNotFound = "not_found"
+	reportedBatchRejected = "rejected"
+)
+
+// reportedBatchEntry is one twin of the array form of the batch body.
+type reportedBatchEntry struct {
+	TwinID     string                 `json:"twinId"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// reportedBatchResult is one twin's outcome in the batch response.
+type reportedBatchResult struct {
+	TwinID    string     `json:"twinId"`
+	Status    string     `json:"status"`              // updated, not_found or rejected
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"` // The twin's new updatedAt (when updated)
+	Code      ErrorCode  `json:"code,omitempty"`      // Why the twin was rejected
+	Message   string     `json:"message,omitempty"`
+
+	// UnknownProperties are the keys stored although the twin's model doesn't define them
+	// (lenient reported validation; see PropertyValidation)
+	UnknownProperties []string `json:"unknownProperties,omitempty"`
+}
+
+// decodeReportedBatch reads either form of the batch body and returns the patches with their
+// twin IDs in response order: request order for the array form, sorted for the object form.
+func decodeReportedBatch(body []byte) (map[string]map[string]interface{}, []string, error) {
+	patches := make(map[string]map[string]interface{})
+	var ids []string
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		var entries []reportedBatchEntry
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&entries); err != nil {
+			return nil, nil, err
+		}
+		for i, e := range entries {
+			if e.TwinID == "" {
+				return nil, nil, fmt.Errorf("entry %d: missing twinId", i)
+			}
+			if _, dup := patches[e.TwinID]; dup {
+				return nil, nil, fmt.Errorf("twin '%s' is listed more than once", e.TwinID)
+			}
+			patches[e.TwinID] = e.Properties
+			ids = append(ids, e.TwinID)
+		}
+	} else {
+		if err := json.Unmarshal(body, &patches); err != nil {
+			return nil, nil, err
+		}
+		for id := range patches {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+
+	for _, id := range ids {
+		if id == "" {
+			return nil, nil, errors.New("twin IDs must not be empty")
+		}
+		if patches[id] == nil {
+			return nil, nil, fmt.Errorf("properties of twin '%s' must be an object", id)
+		}
+	}
+	return patches, ids, nil
+}
+
+// reportedCheckModels returns the models whose twins' reported updates are checked, by ID: those
+// that set StrictReportedTypes or define properties (unknown keys are flagged or rejected). Cached
+// with the other model reads; the map is shared and must not be modified.
+func (a *API) reportedCheckModels(ctx context.Context) (map[string]*model.TwinModel, error) {
+	found, err := a.models.get("reportedChecks:", func() (interface{}, error) {
+		models, err := a.Store.ListAllModels(ctx)
+		if err != nil {
+			return nil, err
+		}
+		checked := make(map[string]*model.TwinModel)
+		for _, m := range models {
+			if m.StrictReportedTypes || len(m.Properties) > 0 {
+				checked[m.ID] = m
+			}
+		}
+		return checked, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found.(map[string]*model.TwinModel), nil
+}
+
+// UpdateReportedPropertiesBatch handles POST requests to /twins/properties/reported/batch
+// Merges reported properties into many twins in one store transaction, e.g. for gateways that
+// push the state of all their devices at once. The body maps twin IDs to properties:
+//
+//	{"sensor-1": {"temperature": 21.5}, "sensor-2": {"temperature": 19.0, "door": "open"}}
+//
+// or lists them: [{"twinId": "sensor-1", "properties": {"temperature": 21.5}}, ...].
+// Each twin's properties are merged like JSONB ||: the keys sent replace the twin's, the others
+// are kept, and null is stored as a value. At most MaxBatchSize twins per request (400
+// BATCH_TOO_LARGE beyond). Twins that don't exist
+// (or, for tag-scoped keys, are outside the key's scope) don't fail the batch: the response lists
+// every twin with status "updated" (and its new updatedAt) or "not_found", in request order
+// (sorted by ID for the object form). Twins whose model sets strictReportedTypes are "rejected"
+// with code REPORTED_TYPE_CHANGED when their update changes a property's type (see
+// model.ReportedTypeConflicts); the rest of the batch is still applied. Types are checked against
+// the twins as read just before the batch. Keys the twin's model doesn't define are stored and
+// listed as the twin's unknownProperties, or, under strict reported validation, reject the twin
+// with code UNKNOWN_PROPERTY; strict validation also rejects values that don't fit their
+// property's schema with code PROPERTY_TYPE_MISMATCH (see PropertyValidation).
+func (a *API) UpdateReportedPropertiesBatch(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	patches, ids, err := decodeReportedBatch(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
+		return
+	}
+	if len(ids) == 0 {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "The batch must contain at least one twin")
+		return
+	}
+	if !a.checkBatchSize(w, len(ids), "twins") {
+		return
+	}
+
+	ctx := r.Context()
+	checked, err := a.reportedCheckModels(ctx)
+	if err != nil {
+		log.Printf("ERROR: Failed to list models for reported type checks: %v", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to load twin models")
+		return
+	}
+
+	// The twins are only read when needed: scoped API keys only write their twins (the others are
+	// reported as not found, as by twinPolicy), strict models check types against current state and
+	// models with definitions check the keys
+	rejected := make(map[string]reportedBatchResult)
+	unknown := make(map[string][]string)
+	strict := a.PropertyValidation.Reported == ValidationStrict
+	if p := auth.FromContext(ctx); !p.Unrestricted() || len(checked) > 0 {
+		for _, id := range ids {
+			twin, err := a.Store.FindTwinByID(ctx, id)
+			if err != nil && !errors.Is(err, persistence.ErrNotFound) {
+				log.Printf("ERROR: Failed to find twin '%s' for reported properties batch: %v", id, err)
+				writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twin")
+				return
+			}
+			if err != nil || !p.CanAccessTwin(twin) {
+				delete(patches, id)
+				continue
+			}
+			m, ok := checked[twin.ModelID]
+			if !ok {
+				continue
+			}
+			if keys := m.UnknownProperties(patches[id]); len(keys) > 0 {
+				if strict {
+					delete(patches, id)
+					rejected[id] = reportedBatchResult{TwinID: id, Status: reportedBatchRejected, Code: CodeUnknownProperty,
+						Message: fmt.Sprintf("Reported properties of twin '%s' include properties model '%s' doesn't define: %s", id, m.ID, strings.Join(keys, ", "))}
+					continue
+				}
+				unknown[id] = keys
+			}
+			if mismatches := m.SchemaViolations(patches[id]); strict && len(mismatches) > 0 {
+				delete(patches, id)
+				rejected[id] = reportedBatchResult{TwinID: id, Status: reportedBatchRejected, Code: CodePropertyTypeMismatch,
+					Message: fmt.Sprintf("Reported properties of twin '%s' don't fit model '%s': %s", id, m.ID, strings.Join(mismatches, "; "))}
+				continue
+			}
+			if conflicts := m.ReportedTypeConflicts(twin.ReportedProperties, patches[id]); len(conflicts) > 0 {
+				delete(patches, id)
+				rejected[id] = reportedBatchResult{TwinID: id, Status: reportedBatchRejected, Code: CodeReportedTypeChanged,
+					Message: fmt.Sprintf("Reported properties of twin '%s' change type under model '%s': %s", id, m.ID, strings.Join(conflicts, "; "))}
+			}
+		}
+	}
+
+	updated, err := a.Store.MergeReportedProperties(ctx, patches)
+	if err != nil {
+		log.Printf("ERROR: Failed to merge reported properties of %d twins: %v", len(patches), err)
+		writeStoreError(w, err, resourceTwin, "Failed to update reported properties")
+		return
+	}
+
+	results := make([]reportedBatchResult, 0, len(ids))
+	for _, id := range ids {
+		result, ok := rejected[id]
+		if !ok {
+			result = reportedBatchResult{TwinID: id, Status: reportedBatchNotFound}
+			if updatedAt, ok := updated[id]; ok {
+				result.Status, result.UpdatedAt = reportedBatchUpdated, &updatedAt
+				result.UnknownProperties = unknown[id]
+			}
+		}
+		results = append(results, result)
+	}
+	notFound := len(ids) - len(updated) - len(rejected)
+	if flagged := flaggedTwins(ids, updated, unknown); len(flagged) > 0 {
+		log.Printf("WARN: Reported properties batch stored properties their model doesn't define on %d twins (twin '%s': %s)",
+			len(flagged), flagged[0], strings.Join(unknown[flagged[0]], ", "))
+	}
+	log.Printf("INFO: Merged reported properties of %d twins (%d not found, %d rejected) for %s", len(updated), notFound, len(rejected), describeActor(ctx))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"updated":  len(updated),
+		"notFound": notFound,
+		"rejected": len(rejected),
+		"results":  results,
+	}); err != nil {
+		log.Printf("ERROR: Failed to encode reported properties batch response: %v", err)
+	}
+}
+
+// flaggedTwins returns the updated twins (in response order) that were sent keys their model
+// doesn't define.
+func flaggedTwins(ids []string, updated map[string]time.Time, unknown map[string][]string) []string {
+	var flagged []string
+	for _, id := range ids {
+		if _, ok := updated[id]; ok && len(unknown[id]) > 0 {
+			flagged = append(flagged, id)
+		}
+	}
+	return flagged
+}
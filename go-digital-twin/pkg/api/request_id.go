@@ -0,0 +1,78 @@
+// pkg/api/request_id.go
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader is the response header every response carries its request ID in, and the
+// default header a client's own ID is taken from.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds a client-supplied request ID; longer ones are replaced.
+const maxRequestIDLength = 128
+
+// requestID assigns every request an ID, stores it where chi's middleware.GetReqID (and so the
+// request log) finds it and echoes it in the X-Request-ID response header, which writeError
+// copies into error bodies. A client's ID is honored, for cross-service correlation, from header
+// (X-Request-ID when empty) or else from the trace ID of a W3C traceparent header, unless
+// ignoreClient is set; IDs that are too long or contain characters outside [A-Za-z0-9._:/+=@-]
+// are replaced by a generated UUID, so they can't forge log lines or response headers.
+func requestID(header string, ignoreClient bool) func(http.Handler) http.Handler {
+	if header == "" {
+		header = RequestIDHeader
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var id string
+			if !ignoreClient {
+				id = clientRequestID(r, header)
+			}
+			if id == "" {
+				id = uuid.NewString()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			ctx := context.WithValue(r.Context(), middleware.RequestIDKey, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// clientRequestID returns the request ID the client sent in header or its traceparent, or ""
+// when there is no usable one.
+func clientRequestID(r *http.Request, header string) string {
+	if id := strings.TrimSpace(r.Header.Get(header)); id != "" {
+		if validRequestID(id) {
+			return id
+		}
+		log.Printf("DEBUG: Ignoring invalid %s from %s (%d bytes)", header, r.RemoteAddr, len(id))
+	}
+	// traceparent: version-traceid-parentid-flags, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if len(parts) == 4 && len(parts[1]) == 32 && validRequestID(parts[1]) && parts[1] != strings.Repeat("0", 32) {
+		return parts[1]
+	}
+	return ""
+}
+
+// validRequestID reports whether id is short enough and made of safe characters only.
+func validRequestID(id string) bool {
+	if len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("._:/+=@-", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
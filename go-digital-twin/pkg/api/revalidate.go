@@ -0,0 +1,96 @@
+// pkg/api/revalidate.go
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// Page size limits for revalidation reports
+const (
+	defaultRevalidateLimit = 100
+	maxRevalidateLimit     = 1000
+)
+
+// twinViolations lists the violations found for one twin.
+type twinViolations struct {
+	TwinID     string                    `json:"twinId"`
+	Violations []model.PropertyViolation `json:"violations"`
+}
+
+// revalidationReport is one page of a model compliance report.
+type revalidationReport struct {
+	ModelID    string           `json:"modelId"`
+	Checked    int              `json:"checked"`              // Twins examined on this page
+	Violating  int              `json:"violating"`            // Twins on this page with at least one violation
+	Twins      []twinViolations `json:"twins"`                // Only the violating twins
+	NextCursor string           `json:"nextCursor,omitempty"` // Pass as ?cursor= for the next page; absent on the last page
+}
+
+// RevalidateModelTwins handles POST requests to /models/{modelId}/revalidate
+// It checks the model's twins against the model's current property definitions and tag schema
+// (see TwinModel.ValidateTwin) and reports the violations. Nothing is modified.
+// Twins are examined in ID order, ?limit= (default 100, max 1000) per page; follow nextCursor
+// with ?cursor= until it is absent to cover every twin.
+func (a *API) RevalidateModelTwins(w http.ResponseWriter, r *http.Request) {
+	modelID := chi.URLParam(r, "modelId")
+	if modelID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing modelId in URL path")
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultRevalidateLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > maxRevalidateLimit {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid limit parameter: must be between 1 and %d", maxRevalidateLimit))
+			return
+		}
+		limit = parsed
+	}
+	cursor := query.Get("cursor")
+
+	ctx := r.Context()
+	twinModel, err := a.Store.FindModelByID(ctx, modelID)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find model '%s' for revalidation: %v", modelID, err)
+		writeStoreError(w, err, resourceModel, "Failed to retrieve model")
+		return
+	}
+
+	// Fetch one extra twin to learn whether another page exists
+	twins, err := a.Store.ListTwinsByModelPage(ctx, modelID, cursor, limit+1)
+	if err != nil {
+		log.Printf("ERROR: Failed to list twins of model '%s' for revalidation: %v", modelID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twins")
+		return
+	}
+
+	report := revalidationReport{ModelID: modelID, Twins: []twinViolations{}}
+	if len(twins) > limit {
+		twins = twins[:limit]
+		report.NextCursor = twins[len(twins)-1].ID
+	}
+	for _, twin := range twins {
+		report.Checked++
+		if violations := twinModel.ValidateTwin(twin); len(violations) > 0 {
+			report.Violating++
+			report.Twins = append(report.Twins, twinViolations{TwinID: twin.ID, Violations: violations})
+		}
+	}
+
+	log.Printf("INFO: Revalidated %d twins of model %s: %d violating", report.Checked, modelID, report.Violating)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("ERROR: Failed to encode revalidation report: %v", err)
+	}
+}
@@ -0,0 +1,391 @@
+// pkg/api/router.go
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/auth"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/cardinality"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/ingest"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/presence"
+)
+
+// Route timeouts used when the corresponding Options field is zero.
+const (
+	DefaultRequestTimeout     = 60 * time.Second // CRUD and other short routes
+	DefaultLongRequestTimeout = 10 * time.Minute // Telemetry history/export
+)
+
+// Options configures the router built by NewRouter. The zero value is usable.
+type Options struct {
+	// Middlewares are added in order after the built-in stack (RequestID, RealIP, Logger,
+	// Recoverer), so they see the request ID and their panics are recovered. They run before the
+	// route-scoped timeouts.
+	Middlewares []func(http.Handler) http.Handler
+
+	// RequestTimeout bounds the context of ordinary (CRUD) requests; zero means DefaultRequestTimeout.
+	RequestTimeout time.Duration
+
+	// LongRequestTimeout bounds telemetry history/export requests; zero means DefaultLongRequestTimeout.
+	LongRequestTimeout time.Duration
+
+	// Ingest is the optional async telemetry pool used for "Prefer: respond-async" writes.
+	// The caller owns it (and must Shutdown it after the HTTP server stops).
+	Ingest *ingest.Pool
+
+	// Downsampler buffers live points of downsampled telemetry names (see
+	// model.TelemetryDefinition.Downsample); nil stores them as sent. The caller owns it (and must
+	// Shutdown it after the HTTP server stops).
+	Downsampler *ingest.Downsampler
+
+	// DefaultTelemetryDescending is the history order used when ?order= is absent.
+	DefaultTelemetryDescending bool
+
+	// Presence tracks device WebSocket connections; nil creates a fresh tracker.
+	Presence *presence.Tracker
+
+	// Authenticate, if set, guards the /api/v1 routes (probes and /metrics stay open). It should
+	// store an auth.Principal in the request context (see auth.Middleware); twin access is then
+	// limited by the principal's tag selector.
+	Authenticate func(http.Handler) http.Handler
+
+	// MaxTelemetryNamesPerTwin caps distinct telemetry names per twin; zero means unlimited.
+	// The server's default comes from config (TELEMETRY_MAX_NAMES_PER_TWIN).
+	MaxTelemetryNamesPerTwin int
+
+	// InFlight, if set, counts requests being handled so shutdown can wait for them (see InFlight).
+	InFlight *InFlight
+
+	// ModelCacheTTL is how long ListModels/GetModel results are cached in process (and the
+	// Cache-Control max-age they are served with); zero disables the cache. Model writes through
+	// this router clear it. The server's default comes from config (MODEL_CACHE_TTL).
+	ModelCacheTTL time.Duration
+
+	// TelemetryRetention is the retention reported for telemetry names whose model declares none
+	// (GET /models/{modelId}/retention); zero means kept forever. It only describes the policy: the
+	// caller runs the deletion (see retention.Register). The server's default comes from config
+	// (TELEMETRY_RETENTION).
+	TelemetryRetention time.Duration
+
+	// MaxConcurrentRequests caps the /api/v1 requests handled at once; beyond it requests get
+	// 503 OVERLOADED with Retry-After instead of queuing for the store. MaxConcurrentReads (GET,
+	// HEAD, OPTIONS) and MaxConcurrentWrites (other methods) cap each kind separately, so bursts of
+	// one can't starve the other. Zero disables a limit. Probes and /metrics are never limited. The
+	// server's defaults come from config (MAX_CONCURRENT_REQUESTS, _READS, _WRITES).
+	MaxConcurrentRequests int
+	MaxConcurrentReads    int
+	MaxConcurrentWrites   int
+
+	// RateLimit is the requests per minute each API key (or, without authentication, each client
+	// address) may make to /api/v1, with bursts of up to RateLimitBurst (zero means RateLimit).
+	// Responses carry X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset; requests over
+	// budget get 429 RATE_LIMITED. Budgets are per replica. Zero disables the limit. The server's
+	// defaults come from config (RATE_LIMIT, RATE_LIMIT_BURST).
+	RateLimit      int
+	RateLimitBurst int
+
+	// UnsetMaps is how twin responses render empty reportedProperties, desiredProperties and tags
+	// unless the client asks otherwise with "Prefer: unset-maps=<policy>": UnsetMapsOmitEmpty
+	// (omit them whether empty or never set; also used for ""), UnsetMapsNull (null when never
+	// set, {} when empty) or UnsetMapsOmit (omitted when never set, {} when empty). The server's
+	// default comes from config (TWIN_UNSET_MAPS).
+	UnsetMaps string
+
+	// TelemetryTimestamps bounds how far from the server time telemetry points may be dated, and
+	// whether out-of-range timestamps are rejected (422 TIMESTAMP_OUT_OF_RANGE) or clamped to the
+	// server time; see TimestampPolicy. The server's defaults come from config
+	// (TELEMETRY_MAX_FUTURE_SKEW, TELEMETRY_MAX_PAST_AGE, TELEMETRY_TIMESTAMP_POLICY).
+	TelemetryTimestamps TimestampPolicy
+
+	// MaxBatchSize caps how many items one batch request may carry, to bound memory use and
+	// transaction length: the twins of POST /twins/properties/reported/batch, the records of
+	// telemetry backfill and POST /telemetry/batch and the twins and names of POST
+	// /telemetry/query. Larger batches get 400 BATCH_TOO_LARGE. Zero means DefaultMaxBatchSize
+	// (1000); the server's default comes from config (MAX_BATCH_SIZE).
+	MaxBatchSize int
+
+	// PropertyValidation sets how strictly desired and reported property writes are checked
+	// against the twin's model: lenient accepts keys the model doesn't define (reported ones are
+	// flagged), strict rejects them. See PropertyValidation; the zero value is lenient for both.
+	// The server's defaults come from config (DESIRED_PROPERTY_VALIDATION,
+	// REPORTED_PROPERTY_VALIDATION).
+	PropertyValidation PropertyValidation
+
+	// WriteBreaker sheds write requests with 503 SERVICE_UNAVAILABLE while too many recent writes
+	// failed or were slow, giving a struggling database room to recover; reads are still served.
+	// Its state is reported by /readyz (it doesn't make the instance unready: every replica shares
+	// the database) and the write_breaker_state metric. See BreakerPolicy; the zero value
+	// disables it. The server's defaults come from config (WRITE_BREAKER_*).
+	WriteBreaker BreakerPolicy
+
+	// Tokens, if set, enables device tokens: unrestricted API keys issue them with POST /tokens and
+	// revoke them with DELETE /tokens/{tokenId}. A device token is scoped to one twin or to the
+	// twins of one model and may only write telemetry and reported properties (403 FORBIDDEN for
+	// anything else). Authenticate must accept them (see auth.Middleware), and the caller keeps
+	// the revocation list in sync across replicas (see RevocationSync). The server enables them
+	// when DEVICE_TOKEN_SECRET is set.
+	Tokens *auth.Tokens
+
+	// BasePath, if set (e.g. "/digital-twins"), prefixes every route, so the service can be mounted
+	// behind a gateway without a rewriting proxy: /digital-twins/api/v1/models and so on. Leading
+	// and trailing slashes are optional. Location headers of created resources include it. The
+	// server's default comes from config (API_BASE_PATH).
+	BasePath string
+
+	// ProbesAtRoot keeps /healthz, /readyz and /metrics at the root instead of under BasePath
+	// (e.g. for orchestrator probes that bypass the gateway). It has no effect without a BasePath.
+	ProbesAtRoot bool
+
+	// RequestIDHeader is the request header a client's own request ID is taken from; empty means
+	// X-Request-ID. Without one, the trace ID of a W3C traceparent header is used, and without
+	// that a UUID is generated. IgnoreClientRequestID always generates. Either way the ID is
+	// echoed in X-Request-ID and in error bodies (see requestID).
+	RequestIDHeader       string
+	IgnoreClientRequestID bool
+
+	// Features disables optional features (see KnownFeatures); the zero value enables them all.
+	// Routes of a disabled feature answer 404 FEATURE_DISABLED, deviceTokens ignores Tokens and
+	// asyncIngest ignores Ingest (writes are synchronous); the caller skips the background
+	// workers of disabled features. GET /api/v1/features lists what is usable. The server's
+	// default comes from config (FEATURES_DISABLED).
+	Features Features
+}
+
+// normalizeBasePath returns path as "/segment[/segment...]" without a trailing slash,
+// or "" for no prefix.
+func normalizeBasePath(path string) string {
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+// NewRouter builds the complete HTTP handler for the API on top of store.
+// Embedders can pass their own middleware (auth, tenancy, ...) via opts, and mount extra
+// routes on the returned router before serving it (its paths start at the root, not at opts.BasePath).
+func NewRouter(store persistence.Store, opts Options) chi.Router {
+	apiHandler := NewAPI(store)
+	features := opts.Features
+	apiHandler.Features = features
+	if features.Enabled(FeatureAsyncIngest) {
+		apiHandler.Ingest = opts.Ingest
+	}
+	apiHandler.Downsampler = opts.Downsampler
+	apiHandler.DefaultTelemetryDescending = opts.DefaultTelemetryDescending
+	apiHandler.TelemetryRetention = opts.TelemetryRetention
+	apiHandler.UnsetMaps = opts.UnsetMaps
+	apiHandler.TelemetryTimestamps = opts.TelemetryTimestamps
+	apiHandler.MaxBatchSize = opts.MaxBatchSize
+	apiHandler.PropertyValidation = opts.PropertyValidation
+	apiHandler.writeBreaker = newWriteBreaker(opts.WriteBreaker)
+	apiHandler.BasePath = normalizeBasePath(opts.BasePath)
+	if features.Enabled(FeatureDeviceTokens) {
+		apiHandler.Tokens = opts.Tokens
+	}
+	if opts.Presence != nil {
+		apiHandler.Presence = opts.Presence
+	}
+	apiHandler.NameLimit = cardinality.NewLimiter(store, opts.MaxTelemetryNamesPerTwin)
+	apiHandler.TelemetryAllowlist = cardinality.NewAllowlists(store, 0)
+	apiHandler.models = newModelCache(opts.ModelCacheTTL)
+
+	timeout := opts.RequestTimeout
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	longTimeout := opts.LongRequestTimeout
+	if longTimeout <= 0 {
+		longTimeout = DefaultLongRequestTimeout
+	}
+
+	r := chi.NewRouter()
+
+	// --- Middleware ---
+	if opts.InFlight != nil {
+		r.Use(opts.InFlight.Middleware) // Outermost, so a request counts until its response is logged
+	}
+	r.Use(requestID(opts.RequestIDHeader, opts.IgnoreClientRequestID))
+	r.Use(middleware.RealIP)
+	r.Use(middleware.Logger) // Consider a more structured logger later
+	r.Use(middleware.Recoverer)
+	r.Use(prettyJSON)          // ?pretty=true indents JSON responses
+	r.Use(opts.Middlewares...) // Caller-supplied, in order
+
+	// Timeouts are route-scoped: CRUD routes get the short default, history export the long one,
+	// and the presence WebSocket none (it is long-lived by design).
+	short := requestTimeout(timeout)
+	long := requestTimeout(longTimeout)
+
+	// Routes are registered on a sub-router mounted at the base path (when one is set); the
+	// middleware above still wraps everything, including probes kept at the root
+	routes := r
+	if basePath := normalizeBasePath(opts.BasePath); basePath != "" {
+		routes = chi.NewRouter()
+		r.Mount(basePath, routes)
+	}
+	probes := routes
+	if opts.ProbesAtRoot {
+		probes = r
+	}
+
+	// --- Register Routes ---
+	probes.With(short).Get("/healthz", apiHandler.HealthCheckHandler)
+	probes.With(short).Get("/readyz", apiHandler.ReadinessHandler)
+	probes.With(short).Handle("/metrics", metrics.Handler())
+
+	// Everything under /api/v1 is concurrency-limited, then authenticated when opts.Authenticate is
+	// set (load is shed first, so rejected requests cost as little as possible; device tokens are
+	// then confined to their routes), then rate-limited per principal; writes finally pass the
+	// write breaker, which only sees requests that would reach the store
+	v1Middlewares := []func(http.Handler) http.Handler{
+		concurrencyLimit(opts.MaxConcurrentRequests, opts.MaxConcurrentReads, opts.MaxConcurrentWrites),
+	}
+	if opts.Authenticate != nil {
+		v1Middlewares = append(v1Middlewares, opts.Authenticate, apiHandler.deviceGate)
+	}
+	v1Middlewares = append(v1Middlewares, rateLimit(opts.RateLimit, opts.RateLimitBurst), apiHandler.writeBreaker.middleware)
+	v1 := routes.With(v1Middlewares...)
+
+	// Model Routes (shared resources: writes need an unscoped key)
+	v1.Route("/api/v1/models", func(r chi.Router) {
+		r.Use(short)
+		r.Get("/", apiHandler.ListModels)
+		r.With(requireUnrestricted).Post("/", apiHandler.CreateModel)
+		r.Get("/categories", apiHandler.ListModelCategories) // GET /api/v1/models/categories
+		r.Get("/ids", apiHandler.ListModelIDs)               // GET /api/v1/models/ids?cursor=&limit= (IDs only)
+		r.Get("/{modelId}", apiHandler.GetModel)
+		r.With(requireUnrestricted).Put("/{modelId}", apiHandler.UpdateModel)
+		r.With(requireUnrestricted).Delete("/{modelId}", apiHandler.DeleteModel)
+		r.With(requireUnrestricted).Post("/{modelId}/revalidate", apiHandler.RevalidateModelTwins) // POST /api/v1/models/{modelId}/revalidate (read-only compliance report)
+		r.Get("/{modelId}/retention", apiHandler.GetModelRetention)                                // GET /api/v1/models/{modelId}/retention (effective telemetry retention)
+		r.Get("/{modelId}/jsonschema", apiHandler.GetModelJSONSchema)                              // GET /api/v1/models/{modelId}/jsonschema (desired properties as JSON Schema)
+		r.Get("/{modelId}/telemetry/summary", apiHandler.GetModelTelemetrySummary)                 // GET /api/v1/models/{modelId}/telemetry/summary (fleet health overview)
+		r.Get("/{modelId}/usage", apiHandler.GetModelUsage)                                        // GET /api/v1/models/{modelId}/usage?window= (twins and recent telemetry using the model)
+		r.Get("/{modelId}/history", apiHandler.ListModelHistory)                                   // GET /api/v1/models/{modelId}/history
+		r.Get("/{modelId}/history/diff", apiHandler.DiffModelVersions)                             // GET /api/v1/models/{modelId}/history/diff?from=&to=
+		r.Get("/{modelId}/history/{version}", apiHandler.GetModelVersion)                          // GET /api/v1/models/{modelId}/history/{version}
+	})
+
+	// Template Routes (shared resources: writes need an unscoped key)
+	v1.Route("/api/v1/templates", func(r chi.Router) {
+		r.Use(features.gate(FeatureTemplates), short)
+		r.Get("/", apiHandler.ListTemplates)
+		r.With(requireUnrestricted).Post("/", apiHandler.CreateTemplate)
+		r.Get("/{templateId}", apiHandler.GetTemplate)
+		r.With(requireUnrestricted).Put("/{templateId}", apiHandler.UpdateTemplate)
+		r.With(requireUnrestricted).Delete("/{templateId}", apiHandler.DeleteTemplate)
+	})
+
+	// Twin Instance Routes
+	v1.Route("/api/v1/twins", func(r chi.Router) {
+		r.With(short).Get("/", apiHandler.ListTwins)                                               // GET /api/v1/twins?modelId=&tag=<key>:<value>&cursor=&limit=
+		r.With(short).Post("/", apiHandler.CreateTwin)                                             // POST /api/v1/twins
+		r.With(short).Delete("/", apiHandler.DeleteTwinsByTags)                                    // DELETE /api/v1/twins?tag.<key>=...&confirm=true (or dryRun=true)
+		r.With(short).Get("/ids", apiHandler.ListTwinIDs)                                          // GET /api/v1/twins/ids?modelId=&tag.<key>=&cursor=&limit= (IDs only)
+		r.With(short).Get("/near", apiHandler.NearTwins)                                           // GET /api/v1/twins/near?lat=&lng=&radius=5km (nearest first)
+		r.With(short).Post("/properties/reported/batch", apiHandler.UpdateReportedPropertiesBatch) // POST /api/v1/twins/properties/reported/batch (merge into many twins at once)
+
+		// Template instantiation belongs to the templates feature
+		r.With(features.gate(FeatureTemplates), short).Post("/fromTemplate/{templateId}", apiHandler.CreateTwinFromTemplate) // POST /api/v1/twins/fromTemplate/{templateId}
+
+		// Routes specific to a twin instance
+		r.Route("/{twinId}", func(r chi.Router) {
+			r.Use(apiHandler.twinPolicy) // Tag-scoped API keys only reach matching twins
+
+			// The policy check above runs without a route timeout; it is a single indexed lookup
+			r.With(features.gate(FeaturePresence)).Get("/presence/connect", apiHandler.ConnectPresence) // GET /twins/{twinId}/presence/connect (WebSocket, no timeout)
+
+			r.Group(func(r chi.Router) {
+				r.Use(short)
+
+				r.Get("/", apiHandler.GetTwin)       // GET /api/v1/twins/{twinId}
+				r.Put("/", apiHandler.UpdateTwin)    // PUT /api/v1/twins/{twinId} (General update)
+				r.Delete("/", apiHandler.DeleteTwin) // DELETE /api/v1/twins/{twinId}
+
+				// Specific property/tag updates
+				r.Put("/properties/desired", apiHandler.UpdateTwinDesiredProperties) // PUT /api/v1/twins/{twinId}/properties/desired (?waitForAck= for the device to report back)
+				r.Put("/tags", apiHandler.UpdateTwinTags)                            // PUT /api/v1/twins/{twinId}/tags
+				r.Put("/metadata", apiHandler.UpdateTwinMetadata)                    // PUT /api/v1/twins/{twinId}/metadata (replace)
+				r.Patch("/metadata", apiHandler.PatchTwinMetadata)                   // PATCH /api/v1/twins/{twinId}/metadata (JSON merge patch)
+				r.Put("/attributes", apiHandler.UpdateTwinMetadata)                  // PUT /api/v1/twins/{twinId}/attributes (alias of /metadata)
+				r.Patch("/attributes", apiHandler.PatchTwinMetadata)                 // PATCH /api/v1/twins/{twinId}/attributes (alias of /metadata)
+				r.Post("/migrate", apiHandler.MigrateTwin)                           // POST /api/v1/twins/{twinId}/migrate (?dryRun=true to preview)
+
+				// Property views
+				r.Get("/properties/effective", apiHandler.GetTwinEffectiveProperties) // GET /api/v1/twins/{twinId}/properties/effective (defaults < reported < unacknowledged desired)
+
+				// Presence Routes
+				r.With(features.gate(FeaturePresence)).Get("/presence", apiHandler.GetTwinPresence) // GET /twins/{twinId}/presence
+			})
+
+			// Telemetry Routes
+			r.Route("/telemetry", func(r chi.Router) {
+				r.With(short).Get("/latest", apiHandler.GetLatestTelemetry)                  // GET /twins/{twinId}/telemetry/latest
+				r.With(short).Get("/metrics", apiHandler.GetTelemetryMetrics)                // GET /twins/{twinId}/telemetry/metrics (latest numeric values, Prometheus text format)
+				r.With(long).Get("/{telemetryName}/history", apiHandler.GetTelemetryHistory) // GET /twins/{twinId}/telemetry/{telemetryName}/history (?bucket=&agg=&tz= to aggregate; long timeout)
+				r.With(short).Post("/", apiHandler.IngestTelemetry)                          // POST /twins/{twinId}/telemetry (one record or an array; sync, or async via Prefer: respond-async)
+				r.With(short).Get("/{telemetryName}/count", apiHandler.GetTelemetryCount)    // GET /twins/{twinId}/telemetry/{telemetryName}/count
+				r.With(short).Get("/{telemetryName}/recent", apiHandler.GetRecentTelemetry)  // GET /twins/{twinId}/telemetry/{telemetryName}/recent (newest first)
+				r.With(long).Get("/{telemetryName}/gaps", apiHandler.GetTelemetryGaps)       // GET /twins/{twinId}/telemetry/{telemetryName}/gaps (?threshold=; scans the range, long timeout)
+				r.With(short).Post("/backfill", apiHandler.BackfillTelemetry)                // POST /twins/{twinId}/telemetry/backfill (idempotent)
+				r.With(long).Post("/import", apiHandler.ImportTelemetry)                     // POST /twins/{twinId}/telemetry/import (text/csv, streamed; long timeout)
+			})
+		})
+	})
+
+	// Device token administration (unscoped keys only); registered when disabled too, to answer
+	// FEATURE_DISABLED rather than a bare 404
+	if apiHandler.Tokens != nil || !features.Enabled(FeatureDeviceTokens) {
+		v1.Route("/api/v1/tokens", func(r chi.Router) {
+			r.Use(features.gate(FeatureDeviceTokens), short, requireUnrestricted)
+			r.Post("/", apiHandler.IssueDeviceToken)              // POST /api/v1/tokens (the token is only returned here)
+			r.Get("/revoked", apiHandler.ListRevokedDeviceTokens) // GET /api/v1/tokens/revoked
+			r.Delete("/{tokenId}", apiHandler.RevokeDeviceToken)  // DELETE /api/v1/tokens/{tokenId}
+		})
+	}
+
+	// Webhook subscriptions (unscoped keys only: they see every twin's events)
+	v1.Route("/api/v1/webhooks", func(r chi.Router) {
+		r.Use(features.gate(FeatureWebhooks), short, requireUnrestricted)
+		r.Get("/", apiHandler.ListWebhooks)
+		r.Post("/", apiHandler.CreateWebhook) // POST /api/v1/webhooks (the secret is never returned)
+		r.Get("/{webhookId}", apiHandler.GetWebhook)
+		r.Put("/{webhookId}", apiHandler.UpdateWebhook)
+		r.Delete("/{webhookId}", apiHandler.DeleteWebhook)
+		r.Get("/{webhookId}/deliveries", apiHandler.ListWebhookDeliveries) // GET /api/v1/webhooks/{webhookId}/deliveries?cursor=&limit= (newest first)
+	})
+
+	// Threshold alert rules (unscoped keys only: rules may cover every twin of a model)
+	v1.Route("/api/v1/alert-rules", func(r chi.Router) {
+		r.Use(features.gate(FeatureAlertRules), short, requireUnrestricted)
+		r.Get("/", apiHandler.ListAlertRules)
+		r.Post("/", apiHandler.CreateAlertRule) // POST /api/v1/alert-rules
+		r.Get("/{ruleId}", apiHandler.GetAlertRule)
+		r.Put("/{ruleId}", apiHandler.UpdateAlertRule)
+		r.Delete("/{ruleId}", apiHandler.DeleteAlertRule)
+		r.Get("/{ruleId}/states", apiHandler.ListAlertStates) // GET /api/v1/alert-rules/{ruleId}/states (pending, firing and resolved twins)
+	})
+
+	// Operational tools (unscoped keys only)
+	v1.Route("/api/v1/admin", func(r chi.Router) {
+		r.Use(features.gate(FeatureAdmin), long, requireUnrestricted)
+		r.Post("/refresh", apiHandler.RefreshCaches) // POST /api/v1/admin/refresh (continuous aggregates and in-process caches; long timeout)
+	})
+
+	// Capability discovery
+	v1.With(short).Get("/api/v1/features", apiHandler.ListFeatures) // GET /api/v1/features (optional features usable on this server)
+
+	// Cross-twin telemetry (scoped keys are checked against each requested twin)
+	v1.With(features.gate(FeatureBulkTelemetryQuery), long).Post(bulkTelemetryQueryPath, apiHandler.QueryTelemetryBulk) // POST /api/v1/telemetry/query (many twins and names; long timeout)
+	v1.With(short).Post(telemetryBatchPath, apiHandler.IngestTelemetryBatch)                                            // POST /api/v1/telemetry/batch (records of many twins, per-record results)
+
+	return r
+}
@@ -0,0 +1,123 @@
+// pkg/api/stream.go
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Streaming tuning: flush to the client every streamFlushEvery elements, and give each
+// flushed chunk streamWriteWait to be written (extending the server's WriteTimeout, which would
+// otherwise cut off long exports).
+const (
+	streamFlushEvery = 500
+	streamWriteWait  = 30 * time.Second
+)
+
+// jsonArrayStream writes a JSON array one element at a time, so large results are sent while
+// they are still being read and are never held in memory as a whole. In NDJSON mode it writes
+// one JSON document per line instead, without brackets or commas.
+// Nothing is written until the first element (or Close), so errors that happen before any
+// data is produced can still be reported as a normal error response.
+type jsonArrayStream struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	enc     *json.Encoder
+	ndjson  bool
+	count   int
+	started bool
+}
+
+// newJSONArrayStream prepares a streaming JSON array response on w.
+func newJSONArrayStream(w http.ResponseWriter) *jsonArrayStream {
+	return &jsonArrayStream{
+		w:   w,
+		rc:  http.NewResponseController(w),
+		enc: json.NewEncoder(w),
+	}
+}
+
+// newNegotiatedStream prepares a streaming response on w: NDJSON when the client's Accept
+// header prefers it (see prefersNDJSON), otherwise a JSON array.
+func newNegotiatedStream(w http.ResponseWriter, r *http.Request) *jsonArrayStream {
+	w.Header().Add("Vary", "Accept")
+	s := newJSONArrayStream(w)
+	s.ndjson = prefersNDJSON(r)
+	return s
+}
+
+// Started reports whether the response status and any data have been sent.
+func (s *jsonArrayStream) Started() bool {
+	return s.started
+}
+
+// start sends the headers and, for a JSON array, the opening bracket.
+func (s *jsonArrayStream) start() error {
+	s.started = true
+	if s.ndjson {
+		s.w.Header().Set("Content-Type", mediaTypeNDJSON)
+	} else {
+		s.w.Header().Set("Content-Type", mediaTypeJSON)
+	}
+	s.w.WriteHeader(http.StatusOK)
+	s.extendDeadline()
+	if s.ndjson {
+		return nil
+	}
+	_, err := s.w.Write([]byte("["))
+	return err
+}
+
+// Write appends one element to the array, flushing periodically.
+func (s *jsonArrayStream) Write(v interface{}) error {
+	if !s.started {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	if s.count > 0 && !s.ndjson {
+		if _, err := s.w.Write([]byte(",")); err != nil {
+			return err
+		}
+	}
+	if err := s.enc.Encode(v); err != nil {
+		return err
+	}
+	s.count++
+	if s.count%streamFlushEvery == 0 {
+		return s.flush()
+	}
+	return nil
+}
+
+// Close terminates the array (sending "[]" if nothing was written) and flushes. An empty NDJSON
+// stream is an empty body.
+func (s *jsonArrayStream) Close() error {
+	if !s.started {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	if !s.ndjson {
+		if _, err := s.w.Write([]byte("]\n")); err != nil {
+			return err
+		}
+	}
+	return s.flush()
+}
+
+// flush pushes buffered output to the client and renews the write deadline.
+func (s *jsonArrayStream) flush() error {
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	s.extendDeadline()
+	return nil
+}
+
+// extendDeadline renews the connection's write deadline; unsupported writers are ignored.
+func (s *jsonArrayStream) extendDeadline() {
+	_ = s.rc.SetWriteDeadline(time.Now().Add(streamWriteWait))
+}
@@ -0,0 +1,286 @@
+// pkg/api/telemetry_batch.go
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/auth"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/cardinality"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// telemetryBatchPath is POST /telemetry/batch, the cross-twin telemetry write.
+const telemetryBatchPath = "/api/v1/telemetry/batch"
+
+// Per-record outcomes of a telemetry batch
+const (
+	telemetryBatchWritten  = "written"
+	telemetryBatchBuffered = "buffered"
+	telemetryBatchNotFound = "not_found"
+	telemetryBatchRejected = "rejected"
+)
+
+// telemetryBatchPoint is one record of the batch body: a telemetry point and the twin it is for.
+type telemetryBatchPoint struct {
+	TwinID string `json:"twinId"`
+	telemetryPoint
+}
+
+// telemetryBatchResult is one record's outcome in the batch response.
+type telemetryBatchResult struct {
+	Index   int        `json:"index"` // Position of the record in the request
+	TwinID  string     `json:"twinId"`
+	Name    string     `json:"name"`         // As stored: the canonical name (see telemetryNameMappings)
+	Status  string     `json:"status"`       // written, buffered, not_found or rejected
+	Ts      *time.Time `json:"ts,omitempty"` // The stored timestamp (when written or buffered)
+	Code    ErrorCode  `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+// telemetryBatchEntry tracks one accepted record through the batch: its result and what is
+// stored for it.
+type telemetryBatchEntry struct {
+	result *telemetryBatchResult
+	rec    *persistence.TelemetryRecord
+	raw    *persistence.TelemetryRecord // Raw copy (definitions with a rawName)
+	twin   *model.TwinInstance
+	ds     *model.TelemetryDownsampling // Set when the record is buffered instead of written
+}
+
+// reject marks the record rejected with code; the message is the error response's.
+func (r *telemetryBatchResult) reject(code ErrorCode, format string, args ...interface{}) {
+	r.Status, r.Code, r.Message = telemetryBatchRejected, code, fmt.Sprintf(format, args...)
+}
+
+// IngestTelemetryBatch handles POST requests to /telemetry/batch
+// Writes the telemetry of many twins in one request, e.g. for gateways forwarding the readings
+// of all their devices. The body is an array of records, each with its twin:
+//
+//	[{"twinId": "sensor-1", "name": "temperature", "numValue": 21.5},
+//	 {"twinId": "sensor-2", "name": "door", "stringValue": "open", "ts": "2024-03-01T12:00:00Z"}]
+//
+// Records are those of IngestTelemetry (`ts` defaults to the server time, the TimestampPolicy's
+// live bounds apply, numValues are converted and raw copies kept) and are checked one by one: a
+// bad record doesn't fail the batch. The twins are looked up in one query; the accepted records
+// are then written in one store transaction (COPY on PostgreSQL), or buffered when their name is
+// downsampled. The response lists every record in request order with status "written",
+// "buffered", "not_found" (the twin doesn't exist or, for scoped keys, is outside the key's scope)
+// or "rejected" with the code and message the single-record write would have answered. At most
+// MaxBatchSize records per request (400 BATCH_TOO_LARGE beyond). A record duplicating a stored one
+// (same twin, name and ts), or another of the batch, fails the whole write with 409
+// TELEMETRY_CONFLICT and nothing is stored. Writes are always synchronous.
+func (a *API) IngestTelemetryBatch(w http.ResponseWriter, r *http.Request) {
+	var points []telemetryBatchPoint
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decodeBatchArray(decoder, a.maxBatchSize(), func() error {
+		var p telemetryBatchPoint
+		if err := decoder.Decode(&p); err != nil {
+			return err
+		}
+		points = append(points, p)
+		return nil
+	})
+	if errors.Is(err, errBatchTooLarge) {
+		a.writeBatchTooLarge(w, "telemetry records")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload (expecting JSON array of telemetry records): "+err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	if len(points) == 0 {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Request must contain at least one telemetry record")
+		return
+	}
+
+	// Records that are well-formed and in range go on to their twin's checks
+	ctx := r.Context()
+	p := auth.FromContext(ctx)
+	writtenBy := p.Actor()
+	now := time.Now().UTC()
+	results := make([]telemetryBatchResult, len(points))
+	var entries []*telemetryBatchEntry
+	var twinIDs []string
+	wanted := make(map[string]bool)
+	for i := range points {
+		result := &results[i]
+		*result = telemetryBatchResult{Index: i, TwinID: points[i].TwinID, Name: points[i].Name}
+		if result.TwinID == "" {
+			result.reject(CodeValidationFailed, "Invalid telemetry record: missing required field: twinId")
+			continue
+		}
+		rec, err := points[i].toRecord(false, writtenBy)
+		if err != nil {
+			result.reject(CodeValidationFailed, "Invalid telemetry record: %v", err)
+			continue
+		}
+		if reason := a.applyTimestampPolicy(result.TwinID, true, rec, now); reason != "" {
+			result.reject(CodeTimestampOutOfRange, "Timestamp of telemetry '%s' is out of range: %s", rec.Name, reason)
+			continue
+		}
+		rec.TwinID = result.TwinID
+		entries = append(entries, &telemetryBatchEntry{result: result, rec: rec})
+		if !wanted[rec.TwinID] {
+			wanted[rec.TwinID] = true
+			twinIDs = append(twinIDs, rec.TwinID)
+		}
+	}
+
+	// The twins must exist; telemetry has no FK so we check explicitly, for all of them at once
+	twins := make(map[string]*model.TwinInstance, len(twinIDs))
+	if len(twinIDs) > 0 {
+		found, err := a.Store.FindTwinsByIDs(ctx, twinIDs)
+		if err != nil {
+			log.Printf("ERROR: Failed to find the %d twins of a telemetry batch: %v", len(twinIDs), err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twins")
+			return
+		}
+		for _, twin := range found {
+			if p.CanAccessTwin(twin) {
+				twins[twin.ID] = twin
+			}
+		}
+	}
+
+	var records []*persistence.TelemetryRecord
+	var buffered []*telemetryBatchEntry
+	for _, e := range entries {
+		e.twin = twins[e.rec.TwinID]
+		if e.twin == nil {
+			_, code := resourceTwin.notFound()
+			e.result.Status, e.result.Code = telemetryBatchNotFound, code
+			e.result.Message = fmt.Sprintf("Twin '%s' not found", e.rec.TwinID)
+			continue
+		}
+		if err := a.checkBatchRecord(ctx, e); err != nil {
+			log.Printf("ERROR: Failed to check telemetry '%s' of twin '%s' in a batch: %v", e.rec.Name, e.rec.TwinID, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to check telemetry records")
+			return
+		}
+		switch {
+		case e.result.Status == telemetryBatchRejected:
+		case e.ds != nil:
+			buffered = append(buffered, e)
+		default:
+			records = append(records, e.rec)
+			if e.raw != nil {
+				records = append(records, e.raw)
+			}
+			e.result.Status = telemetryBatchWritten
+		}
+	}
+
+	if err := a.Store.WriteTelemetryCopy(ctx, records); err != nil {
+		log.Printf("ERROR: Failed to write telemetry batch of %d records: %v", len(records), err)
+		writeStoreError(w, err, resourceTelemetry, "Failed to write telemetry")
+		return
+	}
+
+	// Downsampled points are buffered once the batch is stored, so a failed write buffers none
+	for _, e := range buffered {
+		err := a.Downsampler.Add(e.twin.ID, e.rec, *e.ds)
+		if err == nil && e.raw != nil {
+			err = a.Downsampler.Add(e.twin.ID, e.raw, *e.ds)
+		}
+		if err != nil {
+			log.Printf("WARN: Rejecting downsampled telemetry for twin '%s': %v", e.twin.ID, err)
+			e.result.reject(CodeServiceUnavailable, "Ingestion is shutting down")
+			continue
+		}
+		e.result.Status = telemetryBatchBuffered
+	}
+
+	for _, e := range entries {
+		e.result.Name = e.rec.Name
+		if e.result.Status == telemetryBatchWritten || e.result.Status == telemetryBatchBuffered {
+			ts := e.rec.Timestamp
+			e.result.Ts = &ts
+		}
+	}
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	log.Printf("INFO: Wrote telemetry batch of %d twins: %d records written, %d buffered, %d not found, %d rejected, written by %s",
+		len(twins), counts[telemetryBatchWritten], counts[telemetryBatchBuffered], counts[telemetryBatchNotFound], counts[telemetryBatchRejected], describeActor(ctx))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"received": len(points),
+		"written":  counts[telemetryBatchWritten],
+		"buffered": counts[telemetryBatchBuffered],
+		"notFound": counts[telemetryBatchNotFound],
+		"rejected": counts[telemetryBatchRejected],
+		"results":  results,
+	}); err != nil {
+		log.Printf("ERROR: Failed to encode telemetry batch response: %v", err)
+	}
+}
+
+// checkBatchRecord applies the checks of IngestTelemetry to a record of the batch and its twin:
+// names are normalized, enums, the allowlist and the name cap enforced (a failing record is marked
+// rejected) and numValues converted, setting e.raw. It sets e.ds for downsampled names. The error
+// is a failure to load the twin's model, which fails the batch.
+func (a *API) checkBatchRecord(ctx context.Context, e *telemetryBatchEntry) error {
+	twin, rec := e.twin, e.rec
+	if err := a.TelemetryAllowlist.Normalize(ctx, twin.ID, twin.ModelID, &rec.Name); err != nil {
+		return err
+	}
+	if err := a.TelemetryAllowlist.CheckValue(ctx, twin.ModelID, rec.Name, rec.StringValue); err != nil {
+		if !errors.Is(err, cardinality.ErrValueNotInEnum) {
+			return err
+		}
+		e.result.reject(CodeValueNotInEnum, "%s", err.Error())
+		return nil
+	}
+	raw, rawName, err := a.TelemetryAllowlist.Transform(ctx, twin.ModelID, rec.Name, rec.NumericValue)
+	if err != nil {
+		return err
+	}
+	names := []string{rec.Name}
+	if rawName != "" {
+		rawRec := *rec
+		rawRec.Name = rawName
+		rawRec.NumericValue = raw
+		e.raw = &rawRec
+		names = append(names, rawName)
+	}
+
+	// Allowlist first, so disallowed names never count towards the cap
+	if err := a.TelemetryAllowlist.Check(ctx, twin.ModelID, names...); err != nil {
+		if !errors.Is(err, cardinality.ErrNameNotAllowed) {
+			return err
+		}
+		e.result.reject(CodeTelemetryNameNotAllowed, "%s", err.Error())
+		return nil
+	}
+	if err := a.NameLimit.Admit(ctx, twin.ID, names...); err != nil {
+		if !errors.Is(err, cardinality.ErrNameLimitExceeded) {
+			return err
+		}
+		e.result.reject(CodeTelemetryNameLimit, "%s", err.Error())
+		return nil
+	}
+
+	if a.Downsampler != nil && rec.NumericValue != nil {
+		ds, ok, err := a.TelemetryAllowlist.Downsampling(ctx, twin.ModelID, rec.Name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			e.ds = &ds
+		}
+	}
+	return nil
+}
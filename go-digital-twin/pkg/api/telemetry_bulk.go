@@ -0,0 +1,322 @@
+// pkg/api/telemetry_bulk.go
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/auth"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// Limits for bulk telemetry queries
+const (
+	maxBulkSeries = 1000                 // Caps len(twinIds) * len(names)
+	maxBulkRange  = 366 * 24 * time.Hour // Longest time range per query
+	maxBulkPoints = 1000000              // Caps the points (or buckets) returned across all series
+)
+
+// bulkTelemetryQuery is the body of POST /telemetry/query.
+type bulkTelemetryQuery struct {
+	TwinIDs []string   `json:"twinIds"`
+	Names   []string   `json:"names"`
+	Start   *time.Time `json:"start"` // Default: an hour before end
+	End     *time.Time `json:"end"`   // Default: now
+	Bucket  string     `json:"bucket"`
+	Fn      string     `json:"fn"`
+	Limit   uint       `json:"limit"`
+}
+
+// bulkSeriesPoints and bulkSeriesBuckets are one twin's metric in the bulk query response.
+type bulkSeriesPoints struct {
+	TwinID    string                         `json:"twinId"`
+	Name      string                         `json:"name"`
+	Points    []*persistence.TelemetryRecord `json:"points"`
+	Truncated bool                           `json:"truncated"` // More points exist in the range than were returned
+}
+
+type bulkSeriesBuckets struct {
+	TwinID  string                            `json:"twinId"`
+	Name    string                            `json:"name"`
+	Fn      string                            `json:"fn"` // The aggregation used (the request's fn or the model's default)
+	Buckets []*persistence.TelemetryAggregate `json:"buckets"`
+}
+
+// uniqueNonEmpty returns values without duplicates, in order; an empty list or value is an error.
+func uniqueNonEmpty(values []string) ([]string, error) {
+	seen := make(map[string]struct{}, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if v == "" {
+			return nil, errors.New("must not contain empty strings")
+		}
+		if _, dup := seen[v]; !dup {
+			seen[v] = struct{}{}
+			unique = append(unique, v)
+		}
+	}
+	if len(unique) == 0 {
+		return nil, errors.New("must contain at least one entry")
+	}
+	return unique, nil
+}
+
+// authorizeBulkTwins applies twinPolicy to every requested twin: scoped principals get a 404 for
+// twins outside their selector, exactly as for a missing twin. Writes the error and returns false.
+func (a *API) authorizeBulkTwins(w http.ResponseWriter, r *http.Request, twinIDs []string) bool {
+	p := auth.FromContext(r.Context())
+	if p.Unrestricted() {
+		return true
+	}
+	for _, twinID := range twinIDs {
+		twin, err := a.Store.FindTwinByID(r.Context(), twinID)
+		if err != nil && !errors.Is(err, persistence.ErrNotFound) {
+			log.Printf("ERROR: Failed to find twin '%s' for authorization: %v", twinID, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twin")
+			return false
+		}
+		if err != nil || !p.CanAccessTwin(twin) {
+			_, code := resourceTwin.notFound()
+			writeError(w, http.StatusNotFound, code, fmt.Sprintf("Twin '%s' not found", twinID))
+			return false
+		}
+	}
+	return true
+}
+
+// bulkAggregations returns the aggregation of each requested series: fn when the request names
+// one, else the default of the twin's model for the name. On a store failure it writes the error
+// response and returns false.
+func (a *API) bulkAggregations(w http.ResponseWriter, r *http.Request, twinIDs, names []string, fn string) (map[persistence.TelemetrySeriesKey]string, bool) {
+	fns := make(map[persistence.TelemetrySeriesKey]string, len(twinIDs)*len(names))
+	for _, twinID := range twinIDs {
+		var m *model.TwinModel
+		if fn == "" {
+			var err error
+			if m, err = a.aggregationModel(r.Context(), twinID); err != nil {
+				log.Printf("ERROR: Failed to load the model of twin '%s' for its default aggregation: %v", twinID, err)
+				writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to load twin model")
+				return nil, false
+			}
+		}
+		for _, name := range names {
+			key := persistence.TelemetrySeriesKey{TwinID: twinID, Name: name}
+			if fn != "" {
+				fns[key] = fn
+			} else {
+				fns[key] = defaultAggregation(m, name)
+			}
+		}
+	}
+	return fns, true
+}
+
+// bulkAggregationGroup is one store query of a bulk aggregation: twinIDs x names, all with fn.
+type bulkAggregationGroup struct {
+	fn      string
+	twinIDs []string
+	names   []string
+}
+
+// groupBulkAggregations splits the requested series into store queries with one aggregation
+// each. Twins needing the same aggregation for the same names (typically twins of one model)
+// share a query, so a request with an fn, or without one over twins of a single model, takes a
+// query per distinct aggregation.
+func groupBulkAggregations(twinIDs, names []string, fns map[persistence.TelemetrySeriesKey]string) []*bulkAggregationGroup {
+	var groups []*bulkAggregationGroup
+	byKey := make(map[string]*bulkAggregationGroup)
+	for _, twinID := range twinIDs {
+		// The names of this twin per aggregation, in request order
+		perFn := make(map[string][]string)
+		var order []string
+		for _, name := range names {
+			fn := fns[persistence.TelemetrySeriesKey{TwinID: twinID, Name: name}]
+			if _, ok := perFn[fn]; !ok {
+				order = append(order, fn)
+			}
+			perFn[fn] = append(perFn[fn], name)
+		}
+		for _, fn := range order {
+			key := fn + "\x00" + strings.Join(perFn[fn], "\x00")
+			group, ok := byKey[key]
+			if !ok {
+				group = &bulkAggregationGroup{fn: fn, names: perFn[fn]}
+				byKey[key] = group
+				groups = append(groups, group)
+			}
+			group.twinIDs = append(group.twinIDs, twinID)
+		}
+	}
+	return groups
+}
+
+// QueryTelemetryBulk handles POST requests to /telemetry/query
+// Reads several metrics of several twins in one store query, e.g. for reporting jobs:
+//
+//	{"twinIds": ["t1", "t2"], "names": ["temperature", "humidity"],
+//	 "start": "2024-03-01T00:00:00Z", "end": "2024-04-01T00:00:00Z", "bucket": "1h", "fn": "avg"}
+//
+// The response has one entry in "series" per twin and name, in request order (empty when a series
+// has no data). Raw series hold {"points", "truncated"}; with a bucket (see getTelemetryAggregate
+// for widths and fn, which is its agg) they hold "buckets" and their "fn" instead: without fn,
+// each series uses the aggregation its twin's model declares for the name (avg by default; see
+// model.DefaultAggregation) and the response has no top-level "fn". At most MaxBatchSize twins
+// and names, 1000 series, a range of 366 days and 1,000,000 points or buckets per query. Raw points are split evenly across the
+// series: each gets at most 1,000,000 / series (or limit, if lower), oldest first, and "truncated"
+// tells when there were more. A twin that doesn't exist just has empty series (the single-twin
+// reads answer 404 instead; checking every twin of a batch would cost a query each), except for
+// tag-scoped keys: they get 404 TWIN_NOT_FOUND for it and for twins outside their scope.
+func (a *API) QueryTelemetryBulk(w http.ResponseWriter, r *http.Request) {
+	var req bulkTelemetryQuery
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	twinIDs, err := uniqueNonEmpty(req.TwinIDs)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid twinIds: "+err.Error())
+		return
+	}
+	names, err := uniqueNonEmpty(req.Names)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid names: "+err.Error())
+		return
+	}
+	if !a.checkBatchSize(w, len(twinIDs), "twins") || !a.checkBatchSize(w, len(names), "names") {
+		return
+	}
+	seriesCount := len(twinIDs) * len(names)
+	if seriesCount > maxBulkSeries {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("Too many series: twinIds x names is %d, at most %d per query", seriesCount, maxBulkSeries))
+		return
+	}
+
+	end := time.Now().UTC()
+	if req.End != nil {
+		end = *req.End
+	}
+	start := end.Add(-1 * time.Hour)
+	if req.Start != nil {
+		start = *req.Start
+	}
+	if start.After(end) {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid time range: start time must be before end time")
+		return
+	}
+	if end.Sub(start) > maxBulkRange {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Time range too long: at most %d days per query", maxBulkRange/(24*time.Hour)))
+		return
+	}
+
+	var bucket time.Duration
+	fn := strings.ToLower(req.Fn)
+	if req.Bucket != "" {
+		bucket, err = parseBucket(req.Bucket)
+		if err != nil || bucket < minAggregateBucket {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid bucket: must be a duration of at least 1s (e.g., 5m, 1h, 1d, 1w)")
+			return
+		}
+		buckets := int64(end.Sub(start) / bucket)
+		if buckets > maxAggregateBuckets || buckets*int64(seriesCount) > maxBulkPoints {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Bucket too small for the time range: at most %d buckets per series and %d in total", maxAggregateBuckets, maxBulkPoints))
+			return
+		}
+		if fn != "" && !persistence.IsValidAggregate(fn) {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid fn: must be one of avg, min, max, sum, count, delta, rate")
+			return
+		}
+		if req.Limit > 0 {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "The limit field only applies to raw points, not to bucketed queries")
+			return
+		}
+	} else if fn != "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "The fn field requires a bucket")
+		return
+	}
+
+	if !a.authorizeBulkTwins(w, r, twinIDs) {
+		return
+	}
+
+	ctx := r.Context()
+	response := map[string]interface{}{
+		"start": start,
+		"end":   end,
+	}
+	if bucket > 0 {
+		fns, ok := a.bulkAggregations(w, r, twinIDs, names, fn)
+		if !ok {
+			return
+		}
+		found := make(map[persistence.TelemetrySeriesKey][]*persistence.TelemetryAggregate, seriesCount)
+		for _, group := range groupBulkAggregations(twinIDs, names, fns) {
+			groupFound, err := a.Store.QueryTelemetryAggregateBulk(ctx, group.twinIDs, group.names, start, end, bucket, group.fn, "", nil)
+			if err != nil {
+				log.Printf("ERROR: Failed to aggregate bulk telemetry for %d twins, %d names: %v", len(group.twinIDs), len(group.names), err)
+				writeStoreError(w, err, resourceTelemetry, "Failed to aggregate telemetry")
+				return
+			}
+			for key, buckets := range groupFound {
+				found[key] = buckets
+			}
+		}
+		series := make([]bulkSeriesBuckets, 0, seriesCount)
+		for _, twinID := range twinIDs {
+			for _, name := range names {
+				key := persistence.TelemetrySeriesKey{TwinID: twinID, Name: name}
+				buckets := found[key]
+				if buckets == nil {
+					buckets = make([]*persistence.TelemetryAggregate, 0)
+				}
+				series = append(series, bulkSeriesBuckets{TwinID: twinID, Name: name, Fn: fns[key], Buckets: buckets})
+			}
+		}
+		response["bucket"] = req.Bucket
+		if fn != "" {
+			response["fn"] = fn
+		}
+		response["series"] = series
+	} else {
+		limit := uint(maxBulkPoints / seriesCount)
+		if req.Limit > 0 && req.Limit < limit {
+			limit = req.Limit
+		}
+		// One extra point per series tells whether it was truncated
+		found, err := a.Store.QueryTelemetryBulk(ctx, twinIDs, names, start, end, limit+1, nil)
+		if err != nil {
+			log.Printf("ERROR: Failed to query bulk telemetry for %d twins, %d names: %v", len(twinIDs), len(names), err)
+			writeStoreError(w, err, resourceTelemetry, "Failed to retrieve telemetry")
+			return
+		}
+		series := make([]bulkSeriesPoints, 0, seriesCount)
+		for _, twinID := range twinIDs {
+			for _, name := range names {
+				s := bulkSeriesPoints{TwinID: twinID, Name: name, Points: found[persistence.TelemetrySeriesKey{TwinID: twinID, Name: name}]}
+				if uint(len(s.Points)) > limit {
+					s.Points, s.Truncated = s.Points[:limit], true
+				}
+				if s.Points == nil {
+					s.Points = make([]*persistence.TelemetryRecord, 0)
+				}
+				series = append(series, s)
+			}
+		}
+		response["series"] = series
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("ERROR: Failed to encode bulk telemetry response: %v", err)
+	}
+}
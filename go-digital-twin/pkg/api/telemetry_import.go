@@ -0,0 +1,363 @@
+// pkg/api/telemetry_import.go
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/auth"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/cardinality"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// mediaTypeCSV is the media type of CSV telemetry imports.
+const mediaTypeCSV = "text/csv"
+
+// maxImportErrors caps the row errors listed in an import response; "failed" counts them all.
+const maxImportErrors = 100
+
+// Columns of a CSV telemetry import; quality is optional
+const (
+	importColumnTimestamp = "ts"
+	importColumnName      = "name"
+	importColumnValue     = "value"
+	importColumnQuality   = "quality"
+)
+
+// importRowError is a row of a CSV import that was not written.
+type importRowError struct {
+	Line    int    `json:"line"` // 1-based line number in the file (the header is line 1)
+	Message string `json:"message"`
+}
+
+// importResponse is the response of POST /twins/{twinId}/telemetry/import.
+type importResponse struct {
+	Parsed            int              `json:"parsed"`              // Data rows read
+	Inserted          int              `json:"inserted"`            // Records written, raw copies included
+	SkippedDuplicates int              `json:"skippedDuplicates"`   // Records already stored at (twin, name, ts)
+	RawCopies         int              `json:"rawCopies,omitempty"` // Raw copies of converted values (see model.TelemetryDefinition.RawName)
+	Failed            int              `json:"failed"`              // Rows not written, see errors
+	Errors            []importRowError `json:"errors"`              // The first maxImportErrors failed rows
+}
+
+// fail records a row that was not written.
+func (resp *importResponse) fail(line int, format string, args ...interface{}) {
+	resp.Failed++
+	if len(resp.Errors) < maxImportErrors {
+		resp.Errors = append(resp.Errors, importRowError{Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// importRow is a parsed row waiting for its batch to be written.
+type importRow struct {
+	line   int
+	record *persistence.TelemetryRecord
+}
+
+// parseImportValue detects the type of a CSV value like the JSON fields of a telemetry point:
+// "true"/"false" become boolValue, numbers numValue and anything else stringValue. Names with an
+// enum always get stringValue, so enum values that look like numbers ("1", "2") stay strings.
+func parseImportValue(rec *persistence.TelemetryRecord, value string, isEnum bool) {
+	if !isEnum {
+		switch value {
+		case "true", "false":
+			b := value == "true"
+			rec.BooleanValue = &b
+			return
+		}
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			rec.NumericValue = &f
+			return
+		}
+	}
+	rec.StringValue = &value
+}
+
+// csvImportColumns maps each column of the header row to its index, rejecting unknown,
+// duplicate and missing required columns. Names are case-insensitive.
+func csvImportColumns(header []string) (map[string]int, error) {
+	columns := make(map[string]int, len(header))
+	for i, col := range header {
+		col = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(col, "\ufeff"))) // Spreadsheets often prepend a BOM
+		switch col {
+		case importColumnTimestamp, importColumnName, importColumnValue, importColumnQuality:
+		default:
+			return nil, fmt.Errorf("unknown column %q (expected ts, name, value and optionally quality)", col)
+		}
+		if _, dup := columns[col]; dup {
+			return nil, fmt.Errorf("column %q appears more than once", col)
+		}
+		columns[col] = i
+	}
+	for _, required := range []string{importColumnTimestamp, importColumnName, importColumnValue} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing required column %q", required)
+		}
+	}
+	return columns, nil
+}
+
+// ImportTelemetry handles POST requests to /twins/{twinId}/telemetry/import
+// Backfills telemetry exported from other systems as CSV (Content-Type: text/csv): a header row
+// naming the columns ts (RFC 3339), name and value, optionally quality, then one point per row.
+// The value's type is detected per row (see parseImportValue). The file is parsed as it streams
+// in and written in batches of MaxBatchSize records with the backfill writer, so neither side
+// holds it in memory and rows already stored are skipped: a failed import can be retried as a
+// whole. Rows go through the same rules as backfill (name mappings, allowlist, enums,
+// conversions, the name cap, the timestamp policy's future bound), but a bad row doesn't fail
+// the import: it is counted and listed with its line number. A malformed header is 400; a
+// store error ends the import with 500 after the batches written so far.
+func (a *API) ImportTelemetry(w http.ResponseWriter, r *http.Request) {
+	twinID := chi.URLParam(r, "twinId")
+	if twinID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
+		return
+	}
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != mediaTypeCSV {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Telemetry imports must be sent as Content-Type: text/csv")
+		return
+	}
+	defer r.Body.Close()
+
+	ctx := r.Context()
+	twin, err := a.Store.FindTwinByID(ctx, twinID)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find twin '%s' for telemetry import: %v", twinID, err)
+		writeStoreError(w, err, resourceTwin, "Failed to retrieve twin")
+		return
+	}
+	var twinModel *model.TwinModel
+	if found, err := a.models.get("model:"+twin.ModelID, func() (interface{}, error) {
+		return a.Store.FindModelByID(ctx, twin.ModelID)
+	}); err == nil {
+		twinModel = found.(*model.TwinModel)
+	}
+
+	reader := csv.NewReader(r.Body)
+	reader.FieldsPerRecord = -1 // Checked per row, so a short row is a row error
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("empty file")
+		}
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid CSV header row: "+err.Error())
+		return
+	}
+	columns, err := csvImportColumns(header)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid CSV header row: "+err.Error())
+		return
+	}
+
+	reader.ReuseRecord = true // Rows are converted before the next is read
+
+	resp := importResponse{Errors: []importRowError{}}
+	writtenBy := auth.FromContext(ctx).Actor()
+	batchSize := a.maxBatchSize()
+	batch := make([]importRow, 0, batchSize)
+	for {
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			resp.Parsed++
+			resp.fail(parseErr.StartLine, "%v", parseErr.Err)
+			continue
+		}
+		if err != nil {
+			log.Printf("WARN: Telemetry import for twin '%s' failed to read the body after %d rows: %v", twinID, resp.Parsed, err)
+			writeError(w, http.StatusBadRequest, CodeInvalidPayload, fmt.Sprintf("Failed to read CSV after %d rows: %v", resp.Parsed, err))
+			return
+		}
+		resp.Parsed++
+		line, _ := reader.FieldPos(0)
+		if len(fields) != len(columns) {
+			resp.fail(line, "expected %d fields, got %d", len(columns), len(fields))
+			continue
+		}
+
+		rec, err := a.parseImportRow(fields, columns, twinModel, writtenBy)
+		if err != nil {
+			resp.fail(line, "%v", err)
+			continue
+		}
+		rec.TwinID = twinID
+		batch = append(batch, importRow{line: line, record: rec})
+		if len(batch) == batchSize {
+			if !a.writeImportBatch(ctx, w, twin, batch, &resp) {
+				return
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 && !a.writeImportBatch(ctx, w, twin, batch, &resp) {
+		return
+	}
+
+	log.Printf("INFO: Imported telemetry for twin %s: %d rows, %d inserted, %d duplicates skipped, %d failed, written by %s",
+		twinID, resp.Parsed, resp.Inserted, resp.SkippedDuplicates, resp.Failed, describeActor(ctx))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("ERROR: Failed to encode telemetry import response: %v", err)
+	}
+}
+
+// parseImportRow converts a CSV row into a record; the row's own problems are returned as errors.
+// twinModel (nil when it can't be loaded) tells enum names apart for value detection.
+func (a *API) parseImportRow(fields []string, columns map[string]int, twinModel *model.TwinModel, writtenBy string) (*persistence.TelemetryRecord, error) {
+	name := strings.TrimSpace(fields[columns[importColumnName]])
+	if name == "" {
+		return nil, errors.New("missing name")
+	}
+	ts, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(fields[columns[importColumnTimestamp]]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ts %q: expected RFC 3339, e.g. 2024-01-31T12:00:00Z", fields[columns[importColumnTimestamp]])
+	}
+	rec := &persistence.TelemetryRecord{
+		Timestamp: ts.UTC().Truncate(telemetryTimestampPrecision),
+		Name:      name,
+		WrittenBy: writtenBy,
+	}
+
+	value := fields[columns[importColumnValue]]
+	if value == "" {
+		return nil, errors.New("missing value")
+	}
+	isEnum := false
+	if twinModel != nil {
+		canonical := name
+		if mapped, ok := twinModel.TelemetryNameMappings[name]; ok {
+			canonical = mapped
+		}
+		isEnum = len(twinModel.Telemetry[canonical].Enum) > 0
+	}
+	parseImportValue(rec, value, isEnum)
+
+	if i, ok := columns[importColumnQuality]; ok && strings.TrimSpace(fields[i]) != "" {
+		q, err := persistence.ParseQuality(strings.TrimSpace(fields[i]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid quality %q: expected good, uncertain or bad", fields[i])
+		}
+		rec.Quality = q
+	}
+
+	policy := a.TelemetryTimestamps
+	if reason := policy.outOfRange(rec.Timestamp, time.Now().UTC(), false); reason != "" {
+		if !policy.Clamp {
+			telemetryTimestampsRejected.Inc()
+			return nil, fmt.Errorf("timestamp out of range: %s", reason)
+		}
+		telemetryTimestampsClamped.Inc()
+		rec.Timestamp = time.Now().UTC().Truncate(telemetryTimestampPrecision)
+	}
+	return rec, nil
+}
+
+// writeImportBatch applies the model's telemetry rules to a batch of rows, records the rows they
+// reject in resp and backfills the rest with their raw copies. On a failure that isn't the rows'
+// own it writes the error response and returns false.
+func (a *API) writeImportBatch(ctx context.Context, w http.ResponseWriter, twin *model.TwinInstance, batch []importRow, resp *importResponse) bool {
+	names := make([]*string, len(batch))
+	for i := range batch {
+		names[i] = &batch[i].record.Name
+	}
+	if !a.normalizeTelemetryNames(ctx, w, twin, names...) {
+		return false
+	}
+
+	records := make([]*persistence.TelemetryRecord, 0, len(batch))
+	var raws []*persistence.TelemetryRecord
+	for _, row := range batch {
+		rec := row.record
+		rowErr, err := a.checkImportRecord(ctx, twin, rec)
+		if err != nil {
+			log.Printf("ERROR: Failed to check imported telemetry for twin '%s': %v", twin.ID, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to check telemetry rules")
+			return false
+		}
+		if rowErr != nil {
+			resp.fail(row.line, "%v", rowErr)
+			continue
+		}
+
+		raw, rawName, err := a.TelemetryAllowlist.Transform(ctx, twin.ModelID, rec.Name, rec.NumericValue)
+		if err != nil {
+			log.Printf("ERROR: Failed to apply telemetry conversions for twin '%s': %v", twin.ID, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to apply telemetry conversions")
+			return false
+		}
+		records = append(records, rec)
+		if rawName == "" {
+			continue
+		}
+		if err := a.NameLimit.Admit(ctx, twin.ID, rawName); err != nil && !errors.Is(err, cardinality.ErrNameLimitExceeded) {
+			log.Printf("ERROR: Failed to check telemetry name limit for twin '%s': %v", twin.ID, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to check telemetry name limit")
+			return false
+		} else if err != nil {
+			log.Printf("WARN: Not importing raw copy '%s' of twin '%s' (line %d): %v", rawName, twin.ID, row.line, err)
+			continue // The converted value is still worth keeping
+		}
+		rawRec := *rec
+		rawRec.Name = rawName
+		rawRec.NumericValue = raw
+		raws = append(raws, &rawRec)
+	}
+	records = append(records, raws...)
+	if len(records) == 0 {
+		return true
+	}
+
+	inserted, err := a.Store.BackfillTelemetry(ctx, twin.ID, records)
+	if err != nil {
+		log.Printf("ERROR: Telemetry import for twin '%s' failed after %d rows (%d inserted before): %v", twin.ID, resp.Parsed, resp.Inserted, err)
+		writeStoreError(w, err, resourceTelemetry, fmt.Sprintf("Failed to write telemetry after %d rows (%d records inserted before; retrying the import skips them)", resp.Parsed, resp.Inserted))
+		return false
+	}
+	resp.Inserted += inserted
+	resp.SkippedDuplicates += len(records) - inserted
+	resp.RawCopies += len(raws)
+	return true
+}
+
+// checkImportRecord applies the allowlist, enum and name cap of the twin's model to one record
+// (whose name is canonical). A violation is returned as rowErr; err is set when the rules
+// couldn't be checked.
+func (a *API) checkImportRecord(ctx context.Context, twin *model.TwinInstance, rec *persistence.TelemetryRecord) (rowErr, err error) {
+	if err := a.TelemetryAllowlist.Check(ctx, twin.ModelID, rec.Name); err != nil {
+		if errors.Is(err, cardinality.ErrNameNotAllowed) {
+			return err, nil
+		}
+		return nil, err
+	}
+	if err := a.TelemetryAllowlist.CheckValue(ctx, twin.ModelID, rec.Name, rec.StringValue); err != nil {
+		if errors.Is(err, cardinality.ErrValueNotInEnum) {
+			return err, nil
+		}
+		return nil, err
+	}
+	if err := a.NameLimit.Admit(ctx, twin.ID, rec.Name); err != nil {
+		if errors.Is(err, cardinality.ErrNameLimitExceeded) {
+			return err, nil
+		}
+		return nil, err
+	}
+	return nil, nil
+}
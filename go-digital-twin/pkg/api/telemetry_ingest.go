@@ -0,0 +1,584 @@
+// pkg/api/telemetry_ingest.go
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/auth"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/cardinality"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/ingest"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/go-chi/chi/v5"
+)
+
+// telemetryPoint is the wire format for an incoming telemetry record.
+// Field names mirror persistence.TelemetryRecord's JSON so clients can round-trip history responses.
+type telemetryPoint struct {
+	Timestamp    *time.Time `json:"ts"` // Pointer so we can tell "omitted" from the zero time
+	Name         string     `json:"name"`
+	NumericValue *float64   `json:"numValue"`
+	StringValue  *string    `json:"stringValue"`
+	BooleanValue *bool      `json:"boolValue"`
+
+	Quality *persistence.Quality `json:"quality"` // good (default), uncertain or bad
+}
+
+// telemetryTimestampPrecision is the resolution the stores keep telemetry timestamps at
+// (PostgreSQL timestamptz and SQLite's integer microseconds). Records are truncated to it on
+// ingestion, so write responses show the timestamp as stored and the memory store agrees.
+const telemetryTimestampPrecision = time.Microsecond
+
+// toRecord validates the point and converts it into a store record attributed to writtenBy.
+// Exactly one value field must be set. requireTimestamp rejects points without `ts`.
+func (p *telemetryPoint) toRecord(requireTimestamp bool, writtenBy string) (*persistence.TelemetryRecord, error) {
+	if p.Name == "" {
+		return nil, errors.New("missing required field: name")
+	}
+
+	valueCount := 0
+	if p.NumericValue != nil {
+		valueCount++
+	}
+	if p.StringValue != nil {
+		valueCount++
+	}
+	if p.BooleanValue != nil {
+		valueCount++
+	}
+	if valueCount != 1 {
+		return nil, fmt.Errorf("telemetry '%s' must set exactly one of numValue, stringValue, boolValue (got %d)", p.Name, valueCount)
+	}
+
+	rec := &persistence.TelemetryRecord{
+		Name:         p.Name,
+		NumericValue: p.NumericValue,
+		StringValue:  p.StringValue,
+		BooleanValue: p.BooleanValue,
+		WrittenBy:    writtenBy,
+	}
+	if p.Quality != nil {
+		rec.Quality = *p.Quality
+	}
+	if p.Timestamp != nil {
+		rec.Timestamp = p.Timestamp.UTC().Truncate(telemetryTimestampPrecision)
+	} else if requireTimestamp {
+		return nil, fmt.Errorf("telemetry '%s' is missing required field: ts", p.Name)
+	} else {
+		rec.Timestamp = time.Now().UTC().Truncate(telemetryTimestampPrecision)
+	}
+	return rec, nil
+}
+
+// normalizeTelemetryNames rewrites names in place to the canonical names of the twin's model
+// (telemetryNameMappings), writing a 500 and returning false if the model can't be loaded.
+// It runs before admitTelemetryNames so everything downstream only sees canonical names.
+func (a *API) normalizeTelemetryNames(ctx context.Context, w http.ResponseWriter, twin *model.TwinInstance, names ...*string) bool {
+	if err := a.TelemetryAllowlist.Normalize(ctx, twin.ID, twin.ModelID, names...); err != nil {
+		log.Printf("ERROR: Failed to apply telemetry name mappings for twin '%s': %v", twin.ID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to apply telemetry name mappings")
+		return false
+	}
+	return true
+}
+
+// checkTelemetryValues enforces the enums of the model's telemetry definitions, writing 422
+// VALUE_NOT_IN_ENUM and returning false when a record's value is not allowed. Names must
+// already be canonical (normalizeTelemetryNames).
+func (a *API) checkTelemetryValues(ctx context.Context, w http.ResponseWriter, twin *model.TwinInstance, records ...*persistence.TelemetryRecord) bool {
+	for _, rec := range records {
+		err := a.TelemetryAllowlist.CheckValue(ctx, twin.ModelID, rec.Name, rec.StringValue)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, cardinality.ErrValueNotInEnum) {
+			writeError(w, http.StatusUnprocessableEntity, CodeValueNotInEnum, err.Error())
+			return false
+		}
+		log.Printf("ERROR: Failed to check telemetry enums for twin '%s': %v", twin.ID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to check telemetry enums")
+		return false
+	}
+	return true
+}
+
+// transformTelemetryValues converts numValues with the scale and offset of the model's telemetry
+// definitions, returning a raw copy of each converted record whose definition keeps one under its
+// RawName. It writes a 500 and returns false if the model can't be loaded. Names must already be
+// canonical (normalizeTelemetryNames); admit the raw copies' names with the records'.
+func (a *API) transformTelemetryValues(ctx context.Context, w http.ResponseWriter, twin *model.TwinInstance, records ...*persistence.TelemetryRecord) ([]*persistence.TelemetryRecord, bool) {
+	var raws []*persistence.TelemetryRecord
+	for _, rec := range records {
+		raw, rawName, err := a.TelemetryAllowlist.Transform(ctx, twin.ModelID, rec.Name, rec.NumericValue)
+		if err != nil {
+			log.Printf("ERROR: Failed to apply telemetry conversions for twin '%s': %v", twin.ID, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to apply telemetry conversions")
+			return nil, false
+		}
+		if rawName == "" {
+			continue
+		}
+		rawRec := *rec
+		rawRec.Name = rawName
+		rawRec.NumericValue = raw
+		raws = append(raws, &rawRec)
+	}
+	return raws, true
+}
+
+// admitTelemetryNames enforces the model's telemetry allowlist and the per-twin distinct-name
+// cap before a write. It writes the error response and returns false when the write must be rejected.
+func (a *API) admitTelemetryNames(ctx context.Context, w http.ResponseWriter, twin *model.TwinInstance, names ...string) bool {
+	// Allowlist first, so disallowed names never count towards the cap
+	if err := a.TelemetryAllowlist.Check(ctx, twin.ModelID, names...); err != nil {
+		if errors.Is(err, cardinality.ErrNameNotAllowed) {
+			writeError(w, http.StatusUnprocessableEntity, CodeTelemetryNameNotAllowed, err.Error())
+			return false
+		}
+		log.Printf("ERROR: Failed to check telemetry allowlist for twin '%s': %v", twin.ID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to check telemetry allowlist")
+		return false
+	}
+
+	err := a.NameLimit.Admit(ctx, twin.ID, names...)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, cardinality.ErrNameLimitExceeded) {
+		writeError(w, http.StatusUnprocessableEntity, CodeTelemetryNameLimit, err.Error())
+		return false
+	}
+	log.Printf("ERROR: Failed to check telemetry name limit for twin '%s': %v", twin.ID, err)
+	writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to check telemetry name limit")
+	return false
+}
+
+// telemetryWriteResponse is the response of IngestTelemetry: the record as stored (canonical
+// name, converted value, timestamp at store precision) and, when its definition keeps one, the
+// raw copy stored with it. Status is only set on asynchronous writes ("accepted") and on
+// downsampled points ("buffered").
+type telemetryWriteResponse struct {
+	Status string `json:"status,omitempty"`
+	*persistence.TelemetryRecord
+	Raw *persistence.TelemetryRecord `json:"raw,omitempty"`
+}
+
+// hasPreference reports whether an RFC 7240 Prefer header of r carries preference (e.g.
+// "respond-async"), compared case-insensitively.
+func hasPreference(r *http.Request, preference string) bool {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), preference) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// prefersAsync reports whether the client asked for asynchronous processing (RFC 7240 "Prefer: respond-async").
+func prefersAsync(r *http.Request) bool {
+	return hasPreference(r, "respond-async")
+}
+
+// prefersReturnRepresentation reports whether the client asked for the stored records in the
+// response (RFC 7240 "Prefer: return=representation").
+func prefersReturnRepresentation(r *http.Request) bool {
+	return hasPreference(r, "return=representation")
+}
+
+// IngestTelemetry handles POST requests to /twins/{twinId}/telemetry
+// The body is a single record: {"name": "...", "ts": "...", "numValue"|"stringValue"|"boolValue": ..., "quality": "..."},
+// or a JSON array of them (see ingestTelemetryArray).
+// `ts` defaults to the server time when omitted, `quality` to "good". Timestamps outside the
+// server's TimestampPolicy are rejected with 422 TIMESTAMP_OUT_OF_RANGE or clamped to server time.
+// A numValue is stored converted by the scale/offset of the model's telemetry definition (the
+// response shows the stored value), along with the raw value when the definition has a rawName.
+//
+// By default the record is written synchronously and 202 is returned once it is stored, with the
+// record as persisted (see telemetryWriteResponse), so clients can cache it without reading it
+// back. With "Prefer: respond-async" (and the async pool enabled) the record is queued and 202 is
+// returned immediately with "status": "accepted" and the record as it will be written; if the
+// queue is full the request is rejected with 429 so the client can back off.
+//
+// A numValue of a downsampled name (see model.TelemetryDefinition.Downsample) is never stored
+// itself: it is buffered into its bucket, raw copy included, and 202 is returned with "status":
+// "buffered" and the record as received into the bucket, whatever the Prefer header says.
+func (a *API) IngestTelemetry(w http.ResponseWriter, r *http.Request) {
+	twinID := chi.URLParam(r, "twinId")
+	if twinID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
+		return
+	}
+
+	body := bufio.NewReader(r.Body)
+	defer r.Body.Close()
+	if startsWithArray(body) {
+		a.ingestTelemetryArray(w, r, twinID, body)
+		return
+	}
+
+	var point telemetryPoint
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&point); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload (expecting JSON telemetry record or array of records): "+err.Error())
+		return
+	}
+
+	rec, err := point.toRecord(false, auth.FromContext(r.Context()).Actor())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid telemetry record: "+err.Error())
+		return
+	}
+	if !a.checkTelemetryTimestamps(w, twinID, true, rec) {
+		return
+	}
+
+	// The twin must exist; telemetry has no FK so we check explicitly
+	ctx := r.Context()
+	twin, err := a.Store.FindTwinByID(ctx, twinID)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find twin '%s' for telemetry ingest: %v", twinID, err)
+		writeStoreError(w, err, resourceTwin, "Failed to retrieve twin")
+		return
+	}
+	rec.TwinID = twinID
+
+	if !a.normalizeTelemetryNames(ctx, w, twin, &rec.Name) {
+		return
+	}
+	if !a.checkTelemetryValues(ctx, w, twin, rec) {
+		return
+	}
+	raws, ok := a.transformTelemetryValues(ctx, w, twin, rec)
+	if !ok {
+		return
+	}
+	var raw *persistence.TelemetryRecord
+	names := []string{rec.Name}
+	if len(raws) > 0 {
+		raw = raws[0]
+		names = append(names, raw.Name)
+	}
+	if !a.admitTelemetryNames(ctx, w, twin, names...) {
+		return
+	}
+
+	// --- Downsampled path ---
+	if a.Downsampler != nil && rec.NumericValue != nil {
+		ds, ok, err := a.TelemetryAllowlist.Downsampling(ctx, twin.ModelID, rec.Name)
+		if err != nil {
+			log.Printf("ERROR: Failed to apply telemetry downsampling for twin '%s': %v", twinID, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to apply telemetry downsampling")
+			return
+		}
+		if ok {
+			err = a.Downsampler.Add(twinID, rec, ds)
+			if err == nil && raw != nil {
+				err = a.Downsampler.Add(twinID, raw, ds)
+			}
+			if err != nil {
+				log.Printf("WARN: Rejecting downsampled telemetry for twin '%s': %v", twinID, err)
+				writeError(w, http.StatusServiceUnavailable, CodeServiceUnavailable, "Ingestion is shutting down")
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			if err := json.NewEncoder(w).Encode(telemetryWriteResponse{Status: "buffered", TelemetryRecord: rec, Raw: raw}); err != nil {
+				log.Printf("ERROR: Failed to encode downsampled ingest response: %v", err)
+			}
+			return
+		}
+	}
+
+	// --- Async path ---
+	if prefersAsync(r) && a.Ingest != nil {
+		if err := a.Ingest.Submit(ingest.Job{TwinID: twinID, Record: rec, Raw: raw}); err != nil {
+			log.Printf("WARN: Rejecting async telemetry for twin '%s': %v", twinID, err)
+			if errors.Is(err, ingest.ErrQueueFull) {
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusTooManyRequests, CodeIngestQueueFull, "Ingestion queue is full, retry later")
+			} else {
+				writeError(w, http.StatusServiceUnavailable, CodeServiceUnavailable, "Ingestion is shutting down")
+			}
+			return
+		}
+
+		w.Header().Set("Preference-Applied", "respond-async")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(telemetryWriteResponse{Status: "accepted", TelemetryRecord: rec, Raw: raw}); err != nil {
+			log.Printf("ERROR: Failed to encode async ingest response: %v", err)
+		}
+		return
+	}
+
+	// --- Sync path ---
+	if raw != nil {
+		err = a.Store.WriteTelemetryCopy(ctx, []*persistence.TelemetryRecord{rec, raw})
+	} else {
+		err = a.Store.WriteTelemetry(ctx, twinID, rec)
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to write telemetry for twin '%s': %v", twinID, err)
+		writeStoreError(w, err, resourceTelemetry, "Failed to write telemetry")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(telemetryWriteResponse{TelemetryRecord: rec, Raw: raw}); err != nil {
+		log.Printf("ERROR: Failed to encode ingest telemetry response: %v", err)
+	}
+}
+
+// ingestTelemetryArray is IngestTelemetry for an array body (at most MaxBatchSize records, 400
+// BATCH_TOO_LARGE beyond). Every record gets the checks of a single one, the live TimestampPolicy
+// included, before anything is written; the records (raw copies included) are then written
+// together with WriteTelemetryCopy, so a bad record or a duplicate (409 TELEMETRY_CONFLICT)
+// stores nothing. Arrays are always written synchronously, whatever the Prefer header says.
+// Points of downsampled names are buffered once the others are stored (503 if ingestion shuts
+// down in between). 202 is returned with one telemetryWriteResponse per record, in request order.
+func (a *API) ingestTelemetryArray(w http.ResponseWriter, r *http.Request, twinID string, body io.Reader) {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	points, err := decodeTelemetryBatch(decoder, a.maxBatchSize())
+	if errors.Is(err, errBatchTooLarge) {
+		a.writeBatchTooLarge(w, "telemetry records")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload (expecting JSON array of telemetry records): "+err.Error())
+		return
+	}
+	if len(points) == 0 {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Request must contain at least one telemetry record")
+		return
+	}
+
+	records := make([]*persistence.TelemetryRecord, 0, len(points))
+	writtenBy := auth.FromContext(r.Context()).Actor()
+	for i := range points {
+		rec, err := points[i].toRecord(false, writtenBy)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("Invalid telemetry record at index %d: %v", i, err))
+			return
+		}
+		rec.TwinID = twinID
+		records = append(records, rec)
+	}
+	if !a.checkTelemetryTimestamps(w, twinID, true, records...) {
+		return
+	}
+
+	// The twin must exist; telemetry has no FK so we check explicitly
+	ctx := r.Context()
+	twin, err := a.Store.FindTwinByID(ctx, twinID)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find twin '%s' for telemetry ingest: %v", twinID, err)
+		writeStoreError(w, err, resourceTwin, "Failed to retrieve twin")
+		return
+	}
+
+	namePtrs := make([]*string, len(records))
+	for i, rec := range records {
+		namePtrs[i] = &rec.Name
+	}
+	if !a.normalizeTelemetryNames(ctx, w, twin, namePtrs...) {
+		return
+	}
+	if !a.checkTelemetryValues(ctx, w, twin, records...) {
+		return
+	}
+	// One record at a time, so each response keeps its own raw copy
+	responses := make([]telemetryWriteResponse, len(records))
+	names := make([]string, 0, len(records))
+	for i, rec := range records {
+		raws, ok := a.transformTelemetryValues(ctx, w, twin, rec)
+		if !ok {
+			return
+		}
+		responses[i].TelemetryRecord = rec
+		names = append(names, rec.Name)
+		if len(raws) > 0 {
+			responses[i].Raw = raws[0]
+			names = append(names, raws[0].Name)
+		}
+	}
+	if !a.admitTelemetryNames(ctx, w, twin, names...) {
+		return
+	}
+
+	// Downsampled points are buffered rather than written
+	var writes []*persistence.TelemetryRecord
+	var buffered []int
+	var downsampling []model.TelemetryDownsampling
+	for i := range responses {
+		resp := &responses[i]
+		if a.Downsampler != nil && resp.NumericValue != nil {
+			ds, ok, err := a.TelemetryAllowlist.Downsampling(ctx, twin.ModelID, resp.Name)
+			if err != nil {
+				log.Printf("ERROR: Failed to apply telemetry downsampling for twin '%s': %v", twinID, err)
+				writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to apply telemetry downsampling")
+				return
+			}
+			if ok {
+				resp.Status = "buffered"
+				buffered = append(buffered, i)
+				downsampling = append(downsampling, ds)
+				continue
+			}
+		}
+		writes = append(writes, resp.TelemetryRecord)
+		if resp.Raw != nil {
+			writes = append(writes, resp.Raw)
+		}
+	}
+
+	if err := a.Store.WriteTelemetryCopy(ctx, writes); err != nil {
+		log.Printf("ERROR: Failed to write telemetry for twin '%s': %v", twinID, err)
+		writeStoreError(w, err, resourceTelemetry, "Failed to write telemetry")
+		return
+	}
+	for j, i := range buffered {
+		err := a.Downsampler.Add(twinID, responses[i].TelemetryRecord, downsampling[j])
+		if err == nil && responses[i].Raw != nil {
+			err = a.Downsampler.Add(twinID, responses[i].Raw, downsampling[j])
+		}
+		if err != nil {
+			log.Printf("WARN: Rejecting downsampled telemetry for twin '%s' after writing %d records: %v", twinID, len(writes), err)
+			writeError(w, http.StatusServiceUnavailable, CodeServiceUnavailable, "Ingestion is shutting down")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(responses); err != nil {
+		log.Printf("ERROR: Failed to encode ingest telemetry response: %v", err)
+	}
+}
+
+// BackfillTelemetry handles POST requests to /twins/{twinId}/telemetry/backfill
+// It accepts a JSON array of historical records (each with an explicit `ts`) recovered
+// from a device's local buffer. Records are deduplicated on (twin, name, ts), so a client
+// can safely retry the whole batch after a timeout. Backfill deliberately bypasses any
+// freshness/stale-timestamp rules applied to live ingestion; only the TimestampPolicy's future
+// bound applies, to the whole batch. At most MaxBatchSize records per request (400
+// BATCH_TOO_LARGE beyond). numValues are converted as in IngestTelemetry; the raw copies of
+// definitions with a rawName are deduplicated like any record and, when there are any, counted
+// in "rawCopies" (so received + rawCopies = inserted + skippedDuplicates). With "Prefer:
+// return=representation" the response also lists the records in "records", raw copies last, as
+// they were written; skipped duplicates keep the point already stored, which may differ.
+func (a *API) BackfillTelemetry(w http.ResponseWriter, r *http.Request) {
+	twinID := chi.URLParam(r, "twinId")
+	if twinID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	points, err := decodeTelemetryBatch(decoder, a.maxBatchSize())
+	if errors.Is(err, errBatchTooLarge) {
+		a.writeBatchTooLarge(w, "telemetry records")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload (expecting JSON array of telemetry records): "+err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	if len(points) == 0 {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Request must contain at least one telemetry record")
+		return
+	}
+
+	// Validate every record up front so a bad record doesn't leave a half-applied batch
+	records := make([]*persistence.TelemetryRecord, 0, len(points))
+	writtenBy := auth.FromContext(r.Context()).Actor()
+	for i := range points {
+		rec, err := points[i].toRecord(true, writtenBy)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("Invalid telemetry record at index %d: %v", i, err))
+			return
+		}
+		records = append(records, rec)
+	}
+	if !a.checkTelemetryTimestamps(w, twinID, false, records...) {
+		return
+	}
+
+	// The twin must exist; telemetry has no FK so we check explicitly
+	ctx := r.Context()
+	twin, err := a.Store.FindTwinByID(ctx, twinID)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find twin '%s' for backfill: %v", twinID, err)
+		writeStoreError(w, err, resourceTwin, "Failed to retrieve twin")
+		return
+	}
+
+	namePtrs := make([]*string, len(records))
+	for i, rec := range records {
+		namePtrs[i] = &rec.Name
+	}
+	if !a.normalizeTelemetryNames(ctx, w, twin, namePtrs...) {
+		return
+	}
+	if !a.checkTelemetryValues(ctx, w, twin, records...) {
+		return
+	}
+	raws, ok := a.transformTelemetryValues(ctx, w, twin, records...)
+	if !ok {
+		return
+	}
+	records = append(records, raws...)
+
+	// The batch is all-or-nothing with respect to the name cap too
+	names := make([]string, len(records))
+	for i, rec := range records {
+		names[i] = rec.Name
+	}
+	if !a.admitTelemetryNames(ctx, w, twin, names...) {
+		return
+	}
+
+	inserted, err := a.Store.BackfillTelemetry(ctx, twinID, records)
+	if err != nil {
+		log.Printf("ERROR: Failed to backfill telemetry for twin '%s': %v", twinID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to backfill telemetry")
+		return
+	}
+
+	response := map[string]interface{}{
+		"received":          len(points),
+		"inserted":          inserted,
+		"skippedDuplicates": len(records) - inserted,
+	}
+	if len(raws) > 0 {
+		response["rawCopies"] = len(raws)
+	}
+	if prefersReturnRepresentation(r) {
+		w.Header().Set("Preference-Applied", "return=representation")
+		response["records"] = records
+	}
+
+	log.Printf("INFO: Backfilled telemetry for twin %s: %d inserted, %d duplicates skipped, written by %s", twinID, inserted, len(records)-inserted, describeActor(ctx))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("ERROR: Failed to encode backfill response: %v", err)
+	}
+}
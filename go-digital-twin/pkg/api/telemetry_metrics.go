@@ -0,0 +1,87 @@
+// pkg/api/telemetry_metrics.go
+package api
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+)
+
+// Metric families of GET /twins/{twinId}/telemetry/metrics
+const (
+	twinMetricPrefix        = "twin_telemetry_"
+	twinMetricTimestampName = "twin_telemetry_timestamp_seconds"
+)
+
+// GetTelemetryMetrics handles GET requests to /twins/{twinId}/telemetry/metrics
+// Serves the twin's latest telemetry in the Prometheus text exposition format, so Prometheus can
+// scrape a twin's current readings directly. Every name with a numeric latest value becomes a
+// gauge "twin_telemetry_<name>" (characters other than letters, digits and '_' become '_')
+// labeled twin_id and model_id; twin_telemetry_timestamp_seconds{name="..."} gives when each was
+// measured, so stale readings can be alerted on. Names whose latest value is a string or boolean
+// are skipped, as are names that collide with an earlier one once sanitized. ?name= (repeatable)
+// restricts the names, as for /telemetry/latest.
+func (a *API) GetTelemetryMetrics(w http.ResponseWriter, r *http.Request) {
+	twinID := chi.URLParam(r, "twinId")
+	if twinID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId in URL path")
+		return
+	}
+
+	ctx := r.Context()
+	twin, err := a.Store.FindTwinByID(ctx, twinID)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find twin '%s' for telemetry metrics: %v", twinID, err)
+		writeStoreError(w, err, resourceTwin, "Failed to retrieve twin")
+		return
+	}
+	latest, err := a.Store.QueryLatestTelemetry(ctx, twinID, r.URL.Query()["name"])
+	if err != nil {
+		log.Printf("ERROR: Failed to query latest telemetry for twin '%s': %v", twinID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve latest telemetry")
+		return
+	}
+
+	names := make([]string, 0, len(latest))
+	for name, rec := range latest {
+		if rec != nil && rec.NumericValue != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// Rendered into a buffer so an error above can still be answered as JSON
+	var body bytes.Buffer
+	labels := []metrics.Label{{Name: "twin_id", Value: twin.ID}, {Name: "model_id", Value: twin.ModelID}}
+	exposed := make(map[string]string, len(names)) // Metric name -> telemetry name
+	var exposedNames []string
+	for _, name := range names {
+		metricName := twinMetricPrefix + metrics.SanitizeName(name)
+		if other, dup := exposed[metricName]; dup {
+			log.Printf("DEBUG: Skipping telemetry '%s' of twin '%s' in metrics: '%s' already maps to %s", name, twinID, other, metricName)
+			continue
+		}
+		exposed[metricName] = name
+		exposedNames = append(exposedNames, name)
+		metrics.WriteHeader(&body, metricName, "Latest value of telemetry '"+name+"'.", "gauge")
+		metrics.WriteLabeledSample(&body, metricName, labels, *latest[name].NumericValue)
+	}
+	if len(exposedNames) > 0 {
+		metrics.WriteHeader(&body, twinMetricTimestampName, "When the latest value of each exposed telemetry name was measured (Unix seconds).", "gauge")
+		for _, name := range exposedNames {
+			tsLabels := []metrics.Label{labels[0], labels[1], {Name: "name", Value: name}}
+			metrics.WriteLabeledSample(&body, twinMetricTimestampName, tsLabels, float64(latest[name].Timestamp.UnixNano())/1e9)
+		}
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := body.WriteTo(w); err != nil {
+		log.Printf("ERROR: Failed to write telemetry metrics for twin '%s': %v", twinID, err)
+	}
+}
@@ -0,0 +1,420 @@
+// pkg/api/telemetry_query.go
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Embed the IANA zone database so ?tz= validation doesn't depend on the host
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// parseTimeRange reads ?start= and ?end= (RFC3339, e.g., 2023-10-27T10:00:00Z).
+// Missing or invalid values fall back to the last hour ending now.
+// Writes a 400 and returns ok=false when start is after end.
+func parseTimeRange(w http.ResponseWriter, r *http.Request) (start time.Time, end time.Time, ok bool) {
+	query := r.URL.Query()
+
+	// Default time range (e.g., last hour)
+	defaultEnd := time.Now().UTC()
+	defaultStart := defaultEnd.Add(-1 * time.Hour)
+
+	// Parse start time
+	start, err := time.Parse(time.RFC3339, query.Get("start"))
+	if err != nil || query.Get("start") == "" {
+		start = defaultStart // Use default if missing or invalid
+	}
+
+	// Parse end time
+	end, err = time.Parse(time.RFC3339, query.Get("end"))
+	if err != nil || query.Get("end") == "" {
+		end = defaultEnd // Use default if missing or invalid
+	}
+
+	// Ensure start is before end
+	if start.After(end) {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid time range: start time must be before end time")
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+// parseOrder reads ?order=asc|desc (case-insensitive). When absent, defaultDescending applies.
+// Any other value means ascending, as ?order= always has for history callers.
+func parseOrder(r *http.Request, defaultDescending bool) (descending bool) {
+	switch strings.ToLower(r.URL.Query().Get("order")) {
+	case "":
+		return defaultDescending
+	case "desc":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseQualityFilter reads ?quality=good or a comma-separated list (e.g. good,uncertain).
+// Absent means every quality. Unknown names are rejected with 400.
+func parseQualityFilter(w http.ResponseWriter, r *http.Request) (qualities []persistence.Quality, ok bool) {
+	raw := r.URL.Query().Get("quality")
+	if raw == "" {
+		return nil, true
+	}
+	qualities, err := persistence.ParseQualityList(raw)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid quality parameter: must be good, uncertain, bad or a comma-separated list of them")
+		return nil, false
+	}
+	return qualities, true
+}
+
+// checkTwinExists writes 404 TWIN_NOT_FOUND (or a 500) and returns false unless twinID exists.
+// Telemetry reads call it when they found nothing, so an unknown twin isn't answered like a twin
+// without data; points imply the twin, whose telemetry is deleted with it, so reads that found
+// some skip the query.
+func (a *API) checkTwinExists(ctx context.Context, w http.ResponseWriter, twinID string) bool {
+	exists, err := a.Store.TwinExists(ctx, twinID)
+	if err != nil {
+		log.Printf("ERROR: Failed to check existence of twin '%s': %v", twinID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twin")
+		return false
+	}
+	if !exists {
+		msg, code := resourceTwin.notFound()
+		writeError(w, http.StatusNotFound, code, msg)
+		return false
+	}
+	return true
+}
+
+// Limits for the /recent endpoint
+const (
+	defaultRecentLimit = 10
+	maxRecentLimit     = 1000
+)
+
+// GetRecentTelemetry handles GET requests to /twins/{twinId}/telemetry/{telemetryName}/recent
+// Returns the newest ?limit= points (default 10, max 1000) across all time, newest first.
+// Unlike /history, this ignores TELEMETRY_DEFAULT_ORDER: "recent" is naturally descending.
+// Pass ?order=asc to receive the same newest points in chronological order (e.g., for charting).
+// An unknown twin is 404; a twin without points gets an empty array.
+func (a *API) GetRecentTelemetry(w http.ResponseWriter, r *http.Request) {
+	twinID := chi.URLParam(r, "twinId")
+	telemetryName := chi.URLParam(r, "telemetryName")
+	if twinID == "" || telemetryName == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId or telemetryName in URL path")
+		return
+	}
+
+	limit := uint(defaultRecentLimit)
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.ParseUint(limitStr, 10, 32)
+		if err != nil || parsed == 0 || parsed > maxRecentLimit {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid limit parameter: must be between 1 and %d", maxRecentLimit))
+			return
+		}
+		limit = uint(parsed)
+	}
+
+	descending := parseOrder(r, true)
+	qualities, ok := parseQualityFilter(w, r)
+	if !ok {
+		return
+	}
+
+	// Always fetch newest-first so LIMIT keeps the most recent points
+	ctx := r.Context()
+	records, err := a.Store.QueryTelemetryHistory(ctx, twinID, telemetryName, time.Unix(0, 0).UTC(), time.Now().UTC(), true, limit, qualities)
+	if err != nil {
+		log.Printf("ERROR: Failed to query recent telemetry for twin '%s', name '%s': %v", twinID, telemetryName, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve recent telemetry")
+		return
+	}
+	if len(records) == 0 && !a.checkTwinExists(ctx, w, twinID) {
+		return
+	}
+	if records == nil {
+		records = make([]*persistence.TelemetryRecord, 0)
+	}
+	if !descending {
+		for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+			records[i], records[j] = records[j], records[i]
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		log.Printf("ERROR: Failed to encode recent telemetry response: %v", err)
+	}
+}
+
+// GetTelemetryCount handles GET requests to /twins/{twinId}/telemetry/{telemetryName}/count
+// Supports ?start=&end= (same defaults as history) and ?approximate=true for a planner estimate,
+// which avoids scanning the range on very large hypertables. An unknown twin is 404; a twin
+// without points in the range counts 0.
+func (a *API) GetTelemetryCount(w http.ResponseWriter, r *http.Request) {
+	twinID := chi.URLParam(r, "twinId")
+	telemetryName := chi.URLParam(r, "telemetryName")
+	if twinID == "" || telemetryName == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId or telemetryName in URL path")
+		return
+	}
+
+	start, end, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	approximate := false
+	if v := r.URL.Query().Get("approximate"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid approximate parameter: must be true or false")
+			return
+		}
+		approximate = parsed
+	}
+
+	ctx := r.Context()
+	count, err := a.Store.CountTelemetry(ctx, twinID, telemetryName, start, end, approximate)
+	if err != nil {
+		log.Printf("ERROR: Failed to count telemetry for twin '%s', name '%s': %v", twinID, telemetryName, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to count telemetry")
+		return
+	}
+	if count == 0 && !a.checkTwinExists(ctx, w, twinID) {
+		return
+	}
+
+	response := map[string]interface{}{
+		"twinId":      twinID,
+		"name":        telemetryName,
+		"start":       start.UTC().Format(time.RFC3339),
+		"end":         end.UTC().Format(time.RFC3339),
+		"count":       count,
+		"approximate": approximate,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("ERROR: Failed to encode telemetry count response: %v", err)
+	}
+}
+
+// minGapThreshold keeps ?threshold= from reporting the normal spacing between points as gaps.
+const minGapThreshold = time.Second
+
+// telemetryGap is one entry of the /gaps response.
+type telemetryGap struct {
+	*persistence.TelemetryGap
+	Duration        string  `json:"duration"`        // Go duration, e.g. "12m30s"
+	DurationSeconds float64 `json:"durationSeconds"` // Same, for summing into SLA reports
+}
+
+// GetTelemetryGaps handles GET requests to /twins/{twinId}/telemetry/{telemetryName}/gaps
+// Reports the intervals within ?start=&end= (same defaults as history) longer than ?threshold=
+// (a Go duration, default 5m) in which no point arrived. The range edges count, so a sensor that
+// has gone quiet shows a gap running to end.
+func (a *API) GetTelemetryGaps(w http.ResponseWriter, r *http.Request) {
+	twinID := chi.URLParam(r, "twinId")
+	telemetryName := chi.URLParam(r, "telemetryName")
+	if twinID == "" || telemetryName == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing twinId or telemetryName in URL path")
+		return
+	}
+
+	start, end, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	threshold := 5 * time.Minute
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < minGapThreshold {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid threshold parameter: must be a duration of at least 1s (e.g., 30s, 5m, 1h)")
+			return
+		}
+		threshold = parsed
+	}
+
+	// Without this a missing twin would look like one gap spanning the whole range
+	ctx := r.Context()
+	if !a.checkTwinExists(ctx, w, twinID) {
+		return
+	}
+
+	gaps, err := a.Store.QueryTelemetryGaps(ctx, twinID, telemetryName, start, end, threshold)
+	if err != nil {
+		log.Printf("ERROR: Failed to query telemetry gaps for twin '%s', name '%s': %v", twinID, telemetryName, err)
+		writeStoreError(w, err, resourceTelemetry, "Failed to retrieve telemetry gaps")
+		return
+	}
+	entries := make([]telemetryGap, 0, len(gaps))
+	for _, g := range gaps {
+		d := g.End.Sub(g.Start)
+		entries = append(entries, telemetryGap{TelemetryGap: g, Duration: d.String(), DurationSeconds: d.Seconds()})
+	}
+
+	response := map[string]interface{}{
+		"twinId":    twinID,
+		"name":      telemetryName,
+		"start":     start.UTC().Format(time.RFC3339),
+		"end":       end.UTC().Format(time.RFC3339),
+		"threshold": threshold.String(),
+		"gaps":      entries,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("ERROR: Failed to encode telemetry gaps response: %v", err)
+	}
+}
+
+// Limits for aggregated history queries
+const (
+	minAggregateBucket  = time.Second
+	maxAggregateBuckets = 10000 // Caps (end - start) / bucket so a tiny bucket can't explode the response
+)
+
+// parseBucket parses a bucket width: a Go duration ("5m", "1h") or whole days/weeks ("1d", "7d", "1w").
+// Days and weeks are calendar days in the requested time zone.
+func parseBucket(v string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(v, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count <= 0 {
+				return 0, fmt.Errorf("invalid bucket %q", v)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	return time.ParseDuration(v)
+}
+
+// aggregationModel returns the model of twinID, whose telemetry definitions pick the aggregation
+// of bucketed queries that don't name one, or nil when the twin or its model doesn't exist (the
+// twin then has no data, and defaultAggregation falls back to avg).
+func (a *API) aggregationModel(ctx context.Context, twinID string) (*model.TwinModel, error) {
+	twin, err := a.Store.FindTwinByID(ctx, twinID)
+	if errors.Is(err, persistence.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	found, err := a.models.get("model:"+twin.ModelID, func() (interface{}, error) {
+		return a.Store.FindModelByID(ctx, twin.ModelID)
+	})
+	if errors.Is(err, persistence.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return found.(*model.TwinModel), nil
+}
+
+// defaultAggregation is m's default aggregation of telemetry name (see
+// model.DefaultAggregation), avg without a model.
+func defaultAggregation(m *model.TwinModel, name string) string {
+	if m == nil {
+		return persistence.AggregateAvg
+	}
+	return m.DefaultAggregation(name)
+}
+
+// getTelemetryAggregate serves /history?bucket=... : one row per bucket, {bucket, value}, oldest first.
+//
+//	bucket  required; e.g. 5m, 1h, 1d, 1w (minimum 1s)
+//	agg     avg, min, max, sum, count, delta or rate. delta is last minus first numeric value in the
+//	        bucket; rate is that delta divided by the seconds between those two points (units per
+//	        second). Both are null when a bucket has fewer than two numeric points. The default is the
+//	        aggregation the twin's model declares for the name (model.DefaultAggregation: avg unless
+//	        the definition says otherwise, count for enum names); the X-Aggregation header tells which
+//	        one was used.
+//	tz      IANA zone (e.g. Asia/Almaty) that buckets align to; default UTC. Daily/weekly buckets then
+//	        start at local midnight, including across DST changes, and bucket timestamps carry the local offset.
+//	quality only aggregate points of these qualities (e.g. good); each bucket reports its per-quality counts
+//
+// An unknown twin is 404, a twin without points in the range gets an empty array.
+func (a *API) getTelemetryAggregate(w http.ResponseWriter, r *http.Request, twinID, telemetryName string, start, end time.Time) {
+	query := r.URL.Query()
+
+	bucket, err := parseBucket(query.Get("bucket"))
+	if err != nil || bucket < minAggregateBucket {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid bucket parameter: must be a duration of at least 1s (e.g., 5m, 1h, 1d, 1w)")
+		return
+	}
+	if end.Sub(start)/bucket > maxAggregateBuckets {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Bucket too small for the time range: at most %d buckets per query", maxAggregateBuckets))
+		return
+	}
+
+	agg := strings.ToLower(query.Get("agg"))
+	if agg != "" && !persistence.IsValidAggregate(agg) {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid agg parameter: must be one of avg, min, max, sum, count, delta, rate")
+		return
+	}
+
+	tz := query.Get("tz")
+	loc := time.UTC
+	if tz != "" {
+		loc, err = time.LoadLocation(tz)
+		if err != nil || tz == "Local" {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid tz parameter: unknown IANA time zone '%s'", tz))
+			return
+		}
+	}
+
+	qualities, ok := parseQualityFilter(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if agg == "" {
+		m, err := a.aggregationModel(ctx, twinID)
+		if err != nil {
+			log.Printf("ERROR: Failed to load the model of twin '%s' for its default aggregation: %v", twinID, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to load twin model")
+			return
+		}
+		agg = defaultAggregation(m, telemetryName)
+	}
+
+	buckets, err := a.Store.QueryTelemetryAggregate(ctx, twinID, telemetryName, start, end, bucket, agg, tz, qualities)
+	if err != nil {
+		log.Printf("ERROR: Failed to aggregate telemetry for twin '%s', name '%s': %v", twinID, telemetryName, err)
+		writeStoreError(w, err, resourceTelemetry, "Failed to aggregate telemetry history")
+		return
+	}
+	if len(buckets) == 0 && !a.checkTwinExists(ctx, w, twinID) {
+		return
+	}
+	if buckets == nil {
+		buckets = make([]*persistence.TelemetryAggregate, 0)
+	}
+	for _, b := range buckets {
+		b.Bucket = b.Bucket.In(loc) // Render local midnight as e.g. 2024-03-01T00:00:00+05:00
+	}
+
+	w.Header().Set("X-Aggregation", agg)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(buckets); err != nil {
+		log.Printf("ERROR: Failed to encode telemetry aggregate response: %v", err)
+	}
+}
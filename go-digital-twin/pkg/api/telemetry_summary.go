@@ -0,0 +1,153 @@
+// pkg/api/telemetry_summary.go
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+const (
+	// maxSummaryTwins caps the twins one model telemetry summary examines (the first by ID).
+	maxSummaryTwins = 5000
+
+	// telemetrySummaryTTL is how long a model telemetry summary is served from memory: dashboards
+	// poll it, and recomputing it reads the latest point of every name of every twin.
+	telemetrySummaryTTL = 30 * time.Second
+
+	// maxSummaryCacheEntries bounds the summary cache (one entry per model and key scope).
+	maxSummaryCacheEntries = 1024
+)
+
+// Telemetry summary cache metrics
+var (
+	telemetrySummaryHits   = metrics.NewCounter("telemetry_summary_cache_hits_total", "Model telemetry summaries answered from the in-process cache.")
+	telemetrySummaryMisses = metrics.NewCounter("telemetry_summary_cache_misses_total", "Model telemetry summaries computed from the store.")
+)
+
+// modelTelemetrySummary is the response of GET /models/{modelId}/telemetry/summary.
+type modelTelemetrySummary struct {
+	*persistence.ModelTelemetrySummary
+	Truncated  bool      `json:"truncated"`  // More twins match than were examined
+	ComputedAt time.Time `json:"computedAt"` // When the summary was computed (it is cached briefly)
+}
+
+// summaryCache holds recent model telemetry summaries, keyed by model and tag scope.
+type summaryCache struct {
+	mu      sync.Mutex
+	entries map[string]modelTelemetrySummary
+}
+
+// get returns the cached summary for key if it is younger than telemetrySummaryTTL.
+func (c *summaryCache) get(key string) (modelTelemetrySummary, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.ComputedAt) >= telemetrySummaryTTL {
+		return modelTelemetrySummary{}, false
+	}
+	return entry, true
+}
+
+// put caches summary under key, first dropping expired entries when the cache is full.
+func (c *summaryCache) put(key string, summary modelTelemetrySummary) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]modelTelemetrySummary)
+	}
+	if len(c.entries) >= maxSummaryCacheEntries {
+		for k, entry := range c.entries {
+			if time.Since(entry.ComputedAt) >= telemetrySummaryTTL {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) < maxSummaryCacheEntries {
+		c.entries[key] = summary
+	}
+}
+
+// invalidate drops the cached summaries of modelID (every scope), or all of them when modelID is "".
+func (c *summaryCache) invalidate(modelID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if modelID == "" {
+		c.entries = nil
+		return
+	}
+	for key := range c.entries {
+		if strings.HasPrefix(key, modelID+"\x00") {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// summaryCacheKey identifies a summary of modelID restricted to tags.
+func summaryCacheKey(modelID string, tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return modelID + "\x00" + strings.Join(pairs, "\x00")
+}
+
+// GetModelTelemetrySummary handles GET requests to /models/{modelId}/telemetry/summary
+// A fleet health overview of the model's twins: how many there are and report telemetry, the
+// points stored for them, and per telemetry name the average, minimum and maximum of the twins'
+// latest numeric values (see persistence.ModelTelemetrySummary). Only the first 5000 twins by ID
+// are examined ("truncated" is true when there are more; "twins" still counts them all).
+// Tag-scoped keys get a summary of their twins only. Summaries are cached for 30 seconds.
+func (a *API) GetModelTelemetrySummary(w http.ResponseWriter, r *http.Request) {
+	modelID := chi.URLParam(r, "modelId")
+	if modelID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing modelId in URL path")
+		return
+	}
+
+	ctx := r.Context()
+	if _, err := a.models.get("model:"+modelID, func() (interface{}, error) {
+		return a.Store.FindModelByID(ctx, modelID)
+	}); err != nil {
+		log.Printf("DEBUG: Failed to find model '%s': %v", modelID, err)
+		writeStoreError(w, err, resourceModel, "Failed to retrieve model")
+		return
+	}
+
+	scope, _ := scopeTagSelector(ctx, nil) // No selector of its own, so always in scope
+	key := summaryCacheKey(modelID, scope)
+	response, ok := a.summaries.get(key)
+	if ok {
+		telemetrySummaryHits.Inc()
+	} else {
+		telemetrySummaryMisses.Inc()
+		summary, err := a.Store.SummarizeModelTelemetry(ctx, modelID, scope, maxSummaryTwins)
+		if err != nil {
+			log.Printf("ERROR: Failed to summarize telemetry of model '%s': %v", modelID, err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to summarize model telemetry")
+			return
+		}
+		response = modelTelemetrySummary{
+			ModelTelemetrySummary: summary,
+			Truncated:             summary.Examined < summary.Twins,
+			ComputedAt:            time.Now().UTC(),
+		}
+		a.summaries.put(key, response)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("ERROR: Failed to encode telemetry summary of model '%s': %v", modelID, err)
+	}
+}
@@ -0,0 +1,89 @@
+// pkg/api/telemetry_timestamps.go
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+var (
+	telemetryTimestampsRejected = metrics.NewCounter("telemetry_timestamps_rejected_total", "Telemetry writes rejected with 422 TIMESTAMP_OUT_OF_RANGE because a point was dated too far from server time.")
+	telemetryTimestampsClamped  = metrics.NewCounter("telemetry_timestamps_clamped_total", "Telemetry points whose out-of-range timestamp was replaced with the server time.")
+)
+
+// TimestampPolicy bounds the timestamps telemetry writes may carry, relative to the server time,
+// so a device with a broken clock can't store points that shadow every later value in latest
+// queries (a point dated years ahead stays "latest" until then). The zero value accepts any time.
+type TimestampPolicy struct {
+	// MaxFuture is how far ahead of the server time a point may be dated; zero means no limit.
+	MaxFuture time.Duration
+
+	// MaxPast is how far behind the server time a live point may be dated; zero means no limit.
+	// Backfill is exempt: replaying old buffered data is its purpose.
+	MaxPast time.Duration
+
+	// Clamp replaces out-of-range timestamps with the server time instead of rejecting the write.
+	Clamp bool
+}
+
+// outOfRange describes why ts is outside the policy at now ("" when it is accepted).
+func (p TimestampPolicy) outOfRange(ts, now time.Time, live bool) string {
+	switch {
+	case p.MaxFuture > 0 && ts.After(now.Add(p.MaxFuture)):
+		return fmt.Sprintf("%s ahead of server time (at most %s allowed)", ts.Sub(now).Round(time.Second), model.FormatRetention(p.MaxFuture))
+	case live && p.MaxPast > 0 && ts.Before(now.Add(-p.MaxPast)):
+		return fmt.Sprintf("%s behind server time (at most %s allowed)", now.Sub(ts).Round(time.Second), model.FormatRetention(p.MaxPast))
+	}
+	return ""
+}
+
+// checkTelemetryTimestamps applies a.TelemetryTimestamps to records before a write: out-of-range
+// timestamps are clamped to the server time, or the write is rejected with 422
+// TIMESTAMP_OUT_OF_RANGE (the error response is written and false returned). live is false for
+// backfill, which only the future bound applies to. Either way the event is logged.
+func (a *API) checkTelemetryTimestamps(w http.ResponseWriter, twinID string, live bool, records ...*persistence.TelemetryRecord) bool {
+	policy := a.TelemetryTimestamps
+	if policy.MaxFuture <= 0 && (!live || policy.MaxPast <= 0) {
+		return true
+	}
+	now := time.Now().UTC()
+	for i, rec := range records {
+		reason := a.applyTimestampPolicy(twinID, live, rec, now)
+		if reason == "" {
+			continue
+		}
+		msg := fmt.Sprintf("Timestamp of telemetry '%s' is out of range: %s", rec.Name, reason)
+		if len(records) > 1 {
+			msg = fmt.Sprintf("Timestamp of telemetry record at index %d ('%s') is out of range: %s", i, rec.Name, reason)
+		}
+		writeError(w, http.StatusUnprocessableEntity, CodeTimestampOutOfRange, msg)
+		return false
+	}
+	return true
+}
+
+// applyTimestampPolicy checks one record for checkTelemetryTimestamps, clamping its timestamp when
+// the policy says so. It returns why the record is rejected, or "" when it may be written.
+func (a *API) applyTimestampPolicy(twinID string, live bool, rec *persistence.TelemetryRecord, now time.Time) string {
+	policy := a.TelemetryTimestamps
+	reason := policy.outOfRange(rec.Timestamp, now, live)
+	if reason == "" {
+		return ""
+	}
+	if policy.Clamp {
+		log.Printf("WARN: Clamping telemetry '%s' of twin '%s' dated %s to server time: %s", rec.Name, twinID, rec.Timestamp.Format(time.RFC3339Nano), reason)
+		telemetryTimestampsClamped.Inc()
+		rec.Timestamp = now.Truncate(telemetryTimestampPrecision)
+		return ""
+	}
+
+	log.Printf("WARN: Rejecting telemetry '%s' of twin '%s' dated %s: %s", rec.Name, twinID, rec.Timestamp.Format(time.RFC3339Nano), reason)
+	telemetryTimestampsRejected.Inc()
+	return reason
+}
@@ -0,0 +1,329 @@
+// pkg/api/templates.go
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// --- Template Handlers ---
+
+// validateTemplateModel checks that the template's model exists and that its desired
+// defaults pass checkDesiredProperties. Writes 422 (or 500) and returns false otherwise.
+func (a *API) validateTemplateModel(w http.ResponseWriter, r *http.Request, tmpl *model.TwinTemplate) bool {
+	modelID := tmpl.ModelID
+	m, err := a.Store.FindModelByID(r.Context(), modelID)
+	if err != nil {
+		if errors.Is(err, persistence.ErrNotFound) {
+			writeError(w, http.StatusUnprocessableEntity, CodeModelReferenceInvalid, fmt.Sprintf("Referenced modelId '%s' not found", modelID))
+		} else {
+			log.Printf("ERROR: Failed to check model existence: %v", err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to validate modelId")
+		}
+		return false
+	}
+	return a.checkDesiredProperties(w, m, tmpl.DesiredProperties)
+}
+
+// CreateTemplate handles POST requests to /templates
+func (a *API) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	var tmpl model.TwinTemplate
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&tmpl); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	if tmpl.DisplayName == "" {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Missing required field: displayName")
+		return
+	}
+	if tmpl.ModelID == "" {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Missing required field: modelId")
+		return
+	}
+	if !a.validateTemplateModel(w, r, &tmpl) {
+		return
+	}
+
+	if tmpl.ID == "" {
+		tmpl.ID = "template-" + uuid.NewString()
+	}
+	if tmpl.DesiredProperties == nil {
+		tmpl.DesiredProperties = make(map[string]interface{})
+	}
+	if tmpl.Tags == nil {
+		tmpl.Tags = make(map[string]string)
+	}
+	now := time.Now().UTC()
+	tmpl.CreatedAt = now
+	tmpl.UpdatedAt = now
+
+	if err := a.Store.CreateTemplate(r.Context(), &tmpl); err != nil {
+		log.Printf("ERROR: Failed to create template: %v", err)
+		writeStoreError(w, err, resourceTemplate, "Failed to create template")
+		return
+	}
+
+	log.Printf("INFO: Created template: ID=%s, ModelID=%s", tmpl.ID, tmpl.ModelID)
+	a.setLocation(w, "templates", tmpl.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(tmpl); err != nil {
+		log.Printf("ERROR: Failed to encode create template response: %v", err)
+	}
+}
+
+// GetTemplate handles GET requests to /templates/{templateId}
+func (a *API) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID := chi.URLParam(r, "templateId")
+	if templateID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing templateId in URL path")
+		return
+	}
+
+	tmpl, err := a.Store.FindTemplateByID(r.Context(), templateID)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find template '%s': %v", templateID, err)
+		writeStoreError(w, err, resourceTemplate, "Failed to retrieve template")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(tmpl); err != nil {
+		log.Printf("ERROR: Failed to encode get template response: %v", err)
+	}
+}
+
+// ListTemplates handles GET requests to /templates
+func (a *API) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := a.Store.ListAllTemplates(r.Context())
+	if err != nil {
+		log.Printf("ERROR: Failed to list templates: %v", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve templates")
+		return
+	}
+
+	// Ensure non-nil slice is returned even if empty
+	if templates == nil {
+		templates = make([]*model.TwinTemplate, 0)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(templates); err != nil {
+		log.Printf("ERROR: Failed to encode list templates response: %v", err)
+	}
+}
+
+// UpdateTemplate handles PUT requests to /templates/{templateId} (full replacement)
+func (a *API) UpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID := chi.URLParam(r, "templateId")
+	if templateID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing templateId in URL path")
+		return
+	}
+
+	var tmpl model.TwinTemplate
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&tmpl); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	if tmpl.ID != "" && tmpl.ID != templateID {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Template ID in payload does not match ID in URL")
+		return
+	}
+	tmpl.ID = templateID
+
+	if tmpl.DisplayName == "" {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Missing required field: displayName")
+		return
+	}
+	if tmpl.ModelID == "" {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Missing required field: modelId")
+		return
+	}
+	if !a.validateTemplateModel(w, r, &tmpl) {
+		return
+	}
+	if tmpl.DesiredProperties == nil {
+		tmpl.DesiredProperties = make(map[string]interface{})
+	}
+	if tmpl.Tags == nil {
+		tmpl.Tags = make(map[string]string)
+	}
+	tmpl.UpdatedAt = time.Now().UTC() // Only stored when the application owns updated_at (persistence.Config.UpdatedAtSource)
+
+	ctx := r.Context()
+	if err := a.Store.UpdateTemplate(ctx, &tmpl); err != nil {
+		log.Printf("DEBUG: Failed to update template '%s': %v", templateID, err)
+		writeStoreError(w, err, resourceTemplate, "Failed to update template")
+		return
+	}
+
+	// Re-fetch to return the stored state (including DB-generated timestamps)
+	updated, err := a.Store.FindTemplateByID(ctx, templateID)
+	if err != nil {
+		log.Printf("ERROR: Failed to retrieve updated template '%s' after update: %v", templateID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve template after update")
+		return
+	}
+
+	log.Printf("INFO: Updated template: ID=%s", templateID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		log.Printf("ERROR: Failed to encode update template response: %v", err)
+	}
+}
+
+// DeleteTemplate handles DELETE requests to /templates/{templateId}
+// Twins previously created from the template are not affected.
+func (a *API) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID := chi.URLParam(r, "templateId")
+	if templateID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing templateId in URL path")
+		return
+	}
+
+	if err := a.Store.DeleteTemplate(r.Context(), templateID); err != nil {
+		log.Printf("DEBUG: Failed to delete template '%s': %v", templateID, err)
+		writeStoreError(w, err, resourceTemplate, "Failed to delete template")
+		return
+	}
+
+	log.Printf("INFO: Deleted template: ID=%s", templateID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateTwinFromTemplate handles POST requests to /twins/fromTemplate/{templateId}
+// The new twin gets the template's model, desired properties and tags. The optional body
+// {"id": ..., "desiredProperties": {...}, "tags": {...}} overrides them key by key
+// (request keys win; template keys not mentioned in the request are kept). Templates carry no
+// metadata; "metadata": {...} in the body is stored on the new twin as is, and so are
+// "displayName" and "description" (the template's own name describes the template, not the twin).
+func (a *API) CreateTwinFromTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID := chi.URLParam(r, "templateId")
+	if templateID == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Missing templateId in URL path")
+		return
+	}
+
+	var reqBody struct {
+		ID           string                 `json:"id"` // Generated if empty
+		DisplayName  string                 `json:"displayName"`
+		Description  string                 `json:"description"`
+		DesiredProps map[string]interface{} `json:"desiredProperties"`
+		Tags         map[string]string      `json:"tags"`
+		Metadata     map[string]interface{} `json:"metadata"`
+		Location     *model.GeoPoint        `json:"location"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqBody); err != nil && !errors.Is(err, io.EOF) { // An empty body means "no overrides"
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer r.Body.Close()
+	if reqBody.Location != nil {
+		if err := reqBody.Location.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid location: "+err.Error())
+			return
+		}
+	}
+
+	ctx := r.Context()
+	tmpl, err := a.Store.FindTemplateByID(ctx, templateID)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find template '%s': %v", templateID, err)
+		writeStoreError(w, err, resourceTemplate, "Failed to retrieve template")
+		return
+	}
+
+	// The template doesn't hold a FK on its model, so it may have been deleted since
+	twinModel, err := a.Store.FindModelByID(ctx, tmpl.ModelID)
+	if err != nil {
+		if errors.Is(err, persistence.ErrNotFound) {
+			writeError(w, http.StatusUnprocessableEntity, CodeModelReferenceInvalid, fmt.Sprintf("Template '%s' references modelId '%s', which no longer exists", templateID, tmpl.ModelID))
+		} else {
+			log.Printf("ERROR: Failed to check model existence: %v", err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to validate template modelId")
+		}
+		return
+	}
+
+	// Template defaults first, request overrides on top
+	desired := make(map[string]interface{}, len(tmpl.DesiredProperties)+len(reqBody.DesiredProps))
+	for k, v := range tmpl.DesiredProperties {
+		desired[k] = v
+	}
+	for k, v := range reqBody.DesiredProps {
+		desired[k] = v
+	}
+	tags := make(map[string]string, len(tmpl.Tags)+len(reqBody.Tags))
+	for k, v := range tmpl.Tags {
+		tags[k] = v
+	}
+	for k, v := range reqBody.Tags {
+		tags[k] = v
+	}
+
+	// The model may have changed since the template was saved, so check the merged result
+	if !a.checkDesiredProperties(w, twinModel, desired) {
+		return
+	}
+	if !authorizeTwinTags(w, r, tags) || !checkTwinTags(w, twinModel, tags) {
+		return
+	}
+
+	twinID := reqBody.ID
+	if twinID == "" {
+		twinID = "twin-" + uuid.NewString()
+	}
+
+	now := time.Now().UTC()
+	newTwin := &model.TwinInstance{
+		ID:                 twinID,
+		ModelID:            tmpl.ModelID,
+		DisplayName:        reqBody.DisplayName,
+		Description:        reqBody.Description,
+		ReportedProperties: nil, // Unset: nothing has been reported yet
+		DesiredProperties:  desired,
+		Tags:               tags,
+		Metadata:           reqBody.Metadata,
+		Location:           reqBody.Location,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+
+	if err := a.Store.CreateTwin(ctx, newTwin); err != nil {
+		log.Printf("ERROR: Failed to create twin from template '%s': %v", templateID, err)
+		writeStoreError(w, err, resourceTwin, "Failed to create twin")
+		return
+	}
+
+	log.Printf("INFO: Created twin from template: ID=%s, TemplateID=%s, ModelID=%s", newTwin.ID, templateID, newTwin.ModelID)
+	a.setLocation(w, "twins", newTwin.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(newTwinView(newTwin, a.unsetMapsFor(w, r))); err != nil {
+		log.Printf("ERROR: Failed to encode create twin from template response: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+// pkg/api/timeout.go
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// timeoutWriteSlack is how far past the route timeout the connection's write deadline is set, so
+// the timeout response itself can still be written.
+const timeoutWriteSlack = 5 * time.Second
+
+// requestTimeout bounds the request context to d and reports an expired request as
+// 504 TIMEOUT with the JSON error envelope (chi's middleware.Timeout only sets a bare status).
+// It is route-scoped: NewRouter applies it per route group, so long-running routes (history export)
+// get a longer budget than CRUD routes. It also moves the connection's write deadline to match,
+// so the server-wide WriteTimeout doesn't cut off routes allowed to run longer.
+func requestTimeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d + timeoutWriteSlack)) // Unsupported writers keep the server default
+
+			tw := &timeoutWriter{ResponseWriter: w, ctx: ctx}
+			next.ServeHTTP(tw, r.WithContext(ctx))
+
+			// Handler gave up without responding (or never noticed the deadline)
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			if !tw.wroteHeader && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				tw.writeTimeoutLocked()
+			}
+		})
+	}
+}
+
+// timeoutWriter replaces the handler's response with the timeout envelope when the handler only
+// starts responding after the deadline (typically a 500 caused by the cancelled DB query).
+// Responses already under way when the deadline hits (e.g. a streamed export) are left alone.
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool // The timeout envelope was sent; further handler output is discarded
+}
+
+// WriteHeader sends the handler's status, or the timeout envelope if the deadline already passed.
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeaderLocked(status)
+}
+
+// writeHeaderLocked implements WriteHeader; tw.mu must be held.
+func (tw *timeoutWriter) writeHeaderLocked(status int) {
+	if tw.wroteHeader {
+		return
+	}
+	if errors.Is(tw.ctx.Err(), context.DeadlineExceeded) {
+		tw.writeTimeoutLocked()
+		return
+	}
+	tw.wroteHeader = true
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes body bytes (discarding them after a timeout response).
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeaderLocked(http.StatusOK)
+	if tw.timedOut {
+		return len(b), nil // Pretend success so the handler finishes quietly
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// writeTimeoutLocked sends the 504 envelope; tw.mu must be held.
+func (tw *timeoutWriter) writeTimeoutLocked() {
+	tw.wroteHeader = true
+	tw.timedOut = true
+	// Drop headers the handler prepared for its own response (ETag, Content-Type, ...)
+	for k := range tw.ResponseWriter.Header() {
+		tw.ResponseWriter.Header().Del(k)
+	}
+	writeError(tw.ResponseWriter, http.StatusGatewayTimeout, CodeTimeout, "Request timed out")
+}
+
+// Flush passes through to the underlying writer (needed for streamed responses).
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
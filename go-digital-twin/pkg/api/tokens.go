@@ -0,0 +1,244 @@
+// pkg/api/tokens.go
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/auth"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// DefaultDeviceTokenTTL is the lifetime of a device token whose request names none.
+const DefaultDeviceTokenTTL = 90 * 24 * time.Hour
+
+// Limits on the fields of a token request
+const (
+	maxTokenNameLength = 128
+	maxTokenIDLength   = 64
+)
+
+// deviceRoutes are the only routes device tokens may use: telemetry writes and reported
+// properties. Every other route answers devices with 403 FORBIDDEN. Paths are relative to the
+// base path; the handlers are never called, the mux only matches.
+var deviceRoutes = func() *chi.Mux {
+	mux := chi.NewRouter()
+	noop := func(http.ResponseWriter, *http.Request) {}
+	mux.Post("/api/v1/twins/{twinId}/telemetry", noop)
+	mux.Post("/api/v1/twins/{twinId}/telemetry/", noop)
+	mux.Post("/api/v1/twins/{twinId}/telemetry/backfill", noop)
+	mux.Post("/api/v1/twins/properties/reported/batch", noop)
+	mux.Post(telemetryBatchPath, noop)
+	return mux
+}()
+
+// deviceGate confines device principals to deviceRoutes; other principals pass. The routes'
+// own checks (twinPolicy, the batch's per-twin check) then keep a device to its token's scope.
+func (a *API) deviceGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := auth.FromContext(r.Context())
+		if !p.Device() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path := r.URL.RawPath
+		if path == "" {
+			path = r.URL.Path
+		}
+		path = strings.TrimPrefix(path, a.BasePath)
+		if !deviceRoutes.Match(chi.NewRouteContext(), r.Method, path) {
+			log.Printf("WARN: Device '%s' (token %s) denied %s %s", p.Name, p.TokenID, r.Method, r.URL.Path)
+			writeError(w, http.StatusForbidden, CodeForbidden, "Device tokens may only write telemetry and reported properties")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deviceTokenResponse is the response of POST /tokens. The token itself is only ever returned
+// here: the server doesn't store it.
+type deviceTokenResponse struct {
+	Token     string    `json:"token"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	TwinID    string    `json:"twinId,omitempty"`
+	ModelID   string    `json:"modelId,omitempty"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// IssueDeviceToken handles POST requests to /tokens
+// Body: {"name", "twinId" or "modelId", "ttl"}. Issues a signed device token that may only write
+// telemetry and reported properties of the twin, or of any twin of the model, until it expires
+// ("ttl" as "720h" or "90d"; default 90 days, at most the server's maximum). The referenced twin
+// or model must exist (422 otherwise). Requires an unrestricted API key.
+func (a *API) IssueDeviceToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name    string `json:"name"`
+		TwinID  string `json:"twinId"`
+		ModelID string `json:"modelId"`
+		TTL     string `json:"ttl"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	req.Name = strings.TrimSpace(req.Name)
+	switch {
+	case req.Name == "":
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Missing required field: name")
+		return
+	case len(req.Name) > maxTokenNameLength:
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("name must be at most %d characters", maxTokenNameLength))
+		return
+	case (req.TwinID == "") == (req.ModelID == ""):
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Exactly one of twinId and modelId is required")
+		return
+	}
+	ttl := DefaultDeviceTokenTTL
+	if req.TTL != "" {
+		parsed, err := model.ParseRetention(req.TTL)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("Invalid ttl %q: use a duration such as \"720h\" or \"90d\"", req.TTL))
+			return
+		}
+		ttl = parsed
+	}
+	if maxTTL := a.Tokens.MaxTTL(); ttl > maxTTL {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("ttl must be at most %s", model.FormatRetention(maxTTL)))
+		return
+	}
+
+	ctx := r.Context()
+	if req.TwinID != "" {
+		if _, err := a.Store.FindTwinByID(ctx, req.TwinID); err != nil {
+			if errors.Is(err, persistence.ErrNotFound) {
+				writeError(w, http.StatusUnprocessableEntity, CodeTwinReferenceInvalid, fmt.Sprintf("Referenced twinId '%s' not found", req.TwinID))
+			} else {
+				log.Printf("ERROR: Failed to check twin existence: %v", err)
+				writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to validate twinId")
+			}
+			return
+		}
+	} else if _, err := a.Store.FindModelByID(ctx, req.ModelID); err != nil {
+		if errors.Is(err, persistence.ErrNotFound) {
+			writeError(w, http.StatusUnprocessableEntity, CodeModelReferenceInvalid, fmt.Sprintf("Referenced modelId '%s' not found", req.ModelID))
+		} else {
+			log.Printf("ERROR: Failed to check model existence: %v", err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to validate modelId")
+		}
+		return
+	}
+
+	now := time.Now()
+	token, claims, err := a.Tokens.Issue(auth.TokenClaims{
+		Name:      req.Name,
+		TwinID:    req.TwinID,
+		ModelID:   req.ModelID,
+		ExpiresAt: now.Add(ttl).Unix(),
+	}, now)
+	if err != nil {
+		log.Printf("ERROR: Failed to issue device token '%s': %v", req.Name, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to issue device token")
+		return
+	}
+	issuer := "anonymous"
+	if p := auth.FromContext(ctx); p != nil {
+		issuer = p.Name
+	}
+	log.Printf("INFO: Principal '%s' issued device token %s ('%s', twin %q, model %q) expiring %s",
+		issuer, claims.ID, claims.Name, claims.TwinID, claims.ModelID, time.Unix(claims.ExpiresAt, 0).UTC().Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(deviceTokenResponse{
+		Token:     token,
+		ID:        claims.ID,
+		Name:      claims.Name,
+		TwinID:    claims.TwinID,
+		ModelID:   claims.ModelID,
+		IssuedAt:  time.Unix(claims.IssuedAt, 0).UTC(),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+	}); err != nil {
+		log.Printf("ERROR: Failed to encode device token response: %v", err)
+	}
+}
+
+// RevokeDeviceToken handles DELETE requests to /tokens/{tokenId}
+// Puts the token ID on the revocation list, effective at once on this replica and within the
+// revocation refresh interval on the others. Revoking an unknown or already revoked ID succeeds
+// as well (tokens aren't stored, so it can't be told apart). Requires an unrestricted API key.
+func (a *API) RevokeDeviceToken(w http.ResponseWriter, r *http.Request) {
+	tokenID := chi.URLParam(r, "tokenId")
+	if tokenID == "" || len(tokenID) > maxTokenIDLength {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("tokenId must be 1 to %d characters", maxTokenIDLength))
+		return
+	}
+
+	// No token outlives MaxTTL from now, so the entry can be pruned after that
+	now := time.Now()
+	revoked := &persistence.RevokedToken{ID: tokenID, RevokedAt: now, Until: now.Add(a.Tokens.MaxTTL())}
+	if err := a.Store.RevokeToken(r.Context(), revoked); err != nil {
+		log.Printf("ERROR: Failed to revoke device token %s: %v", tokenID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to revoke device token")
+		return
+	}
+	a.Tokens.Revoke(tokenID, revoked.Until)
+	log.Printf("INFO: Revoked device token %s", tokenID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListRevokedDeviceTokens handles GET requests to /tokens/revoked
+// Lists the revocation list ordered by token ID. Requires an unrestricted API key.
+func (a *API) ListRevokedDeviceTokens(w http.ResponseWriter, r *http.Request) {
+	revoked, err := a.Store.ListRevokedTokens(r.Context())
+	if err != nil {
+		log.Printf("ERROR: Failed to list revoked device tokens: %v", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve revoked device tokens")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(revoked); err != nil {
+		log.Printf("ERROR: Failed to encode revoked device tokens response: %v", err)
+	}
+}
+
+// RevocationSync returns a background job keeping tokens' revocation list in sync with the store:
+// it loads the revocations made through any replica and prunes the entries of expired tokens.
+// Register it with the scheduler, which runs it right away and then every interval.
+func RevocationSync(store persistence.TokenStore, tokens *auth.Tokens) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		now := time.Now()
+		if n, err := store.PruneRevokedTokens(ctx, now); err != nil {
+			return err
+		} else if n > 0 {
+			log.Printf("INFO: Pruned %d revocations of expired device tokens", n)
+		}
+		list, err := store.ListRevokedTokens(ctx)
+		if err != nil {
+			return err
+		}
+		revoked := make(map[string]time.Time, len(list))
+		for _, entry := range list {
+			revoked[entry.ID] = entry.Until
+		}
+		tokens.LoadRevoked(revoked, now)
+		return nil
+	}
+}
@@ -0,0 +1,99 @@
+// pkg/api/twin_fields.go
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// parseTwinFields reads the ?fields= projection of GET /twins: a comma-separated list of
+// persistence.TwinFields names (case-sensitive, as in the JSON). It returns nil without the
+// parameter, and writes a 400 and returns false for an unknown or empty name. "id" is always
+// included, so a projected list can still be paged and joined.
+func parseTwinFields(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	raw, ok := r.URL.Query()["fields"]
+	if !ok {
+		return nil, true
+	}
+	fields := []string{"id"}
+	seen := map[string]bool{"id": true}
+	for _, part := range strings.Split(strings.Join(raw, ","), ",") {
+		name := strings.TrimSpace(part)
+		if !isTwinField(name) {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid 'fields' query parameter: unknown field %q (allowed: %s)", name, strings.Join(persistence.TwinFields, ", ")))
+			return nil, false
+		}
+		if !seen[name] {
+			seen[name] = true
+			fields = append(fields, name)
+		}
+	}
+	return fields, true
+}
+
+// isTwinField reports whether name is one of persistence.TwinFields.
+func isTwinField(name string) bool {
+	for _, f := range persistence.TwinFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// projectTwinView keeps only fields of a rendered twin. Maps the unset-maps policy omits, an
+// absent location and empty strings stay omitted, as in the full representation.
+func projectTwinView(v twinView, fields []string) map[string]interface{} {
+	projected := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		var value interface{}
+		switch f {
+		case "id":
+			value = v.ID
+		case "modelId":
+			value = v.ModelID
+		case "displayName":
+			if v.DisplayName != "" {
+				value = v.DisplayName
+			}
+		case "description":
+			if v.Description != "" {
+				value = v.Description
+			}
+		case "reportedProperties":
+			value = v.ReportedProperties
+		case "desiredProperties":
+			value = v.DesiredProperties
+		case "tags":
+			value = v.Tags
+		case "metadata":
+			if len(v.Metadata) > 0 {
+				value = v.Metadata
+			}
+		case "location":
+			if v.Location != nil {
+				value = v.Location
+			}
+		case "createdAt":
+			value = v.CreatedAt
+		case "updatedAt":
+			value = v.UpdatedAt
+		}
+		if value != nil {
+			projected[f] = value
+		}
+	}
+	return projected
+}
+
+// projectTwinViews is projectTwinView over a list; the result is never nil.
+func projectTwinViews(views []twinView, fields []string) []map[string]interface{} {
+	projected := make([]map[string]interface{}, 0, len(views))
+	for _, v := range views {
+		projected = append(projected, projectTwinView(v, fields))
+	}
+	return projected
+}
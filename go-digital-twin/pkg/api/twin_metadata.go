@@ -0,0 +1,144 @@
+// pkg/api/twin_metadata.go
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/go-chi/chi/v5"
+)
+
+// metadataPatchAttempts bounds how often PATCH /metadata (or /attributes) re-reads a twin that
+// changed between its read and its write when the client sent no If-Match.
+const metadataPatchAttempts = 3
+
+// decodeMetadata reads a metadata object from the request body; null reads as an empty object.
+// On error it writes the response and returns false.
+func decodeMetadata(w http.ResponseWriter, r *http.Request) (map[string]interface{}, bool) {
+	var metadata map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload (expecting JSON object): "+err.Error())
+		return nil, false
+	}
+	defer r.Body.Close()
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+	}
+	return metadata, true
+}
+
+// UpdateTwinMetadata handles PUT requests to /twins/{twinId}/metadata and its alias
+// /twins/{twinId}/attributes (the twin's arbitrary attributes are its metadata field).
+// The object replaces the twin's metadata ({} clears it). Unlike properties, metadata isn't
+// synced with the device, and unlike tags it holds any JSON and isn't filterable.
+func (a *API) UpdateTwinMetadata(w http.ResponseWriter, r *http.Request) {
+	twinID := chi.URLParam(r, "twinId")
+	metadata, ok := decodeMetadata(w, r)
+	if !ok {
+		return
+	}
+
+	// Optional optimistic concurrency via If-Match (see etag.go)
+	current, ok := a.checkTwinPrecondition(w, r, twinID)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	var err error
+	if current != nil {
+		err = a.Store.UpdateMetadataIfUnmodified(ctx, twinID, metadata, current.UpdatedAt)
+	} else {
+		err = a.Store.UpdateMetadata(ctx, twinID, metadata)
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to update metadata for twin '%s': %v", twinID, err)
+		writeStoreError(w, err, resourceTwin, "Failed to update metadata")
+		return
+	}
+	a.writeTwinAfterMetadataUpdate(w, r, twinID)
+}
+
+// PatchTwinMetadata handles PATCH requests to /twins/{twinId}/metadata (or /attributes)
+// The body is a JSON merge patch (RFC 7396) of the metadata: keys set to null are removed,
+// objects merge recursively and any other value replaces the key. Keys not in the patch are kept.
+func (a *API) PatchTwinMetadata(w http.ResponseWriter, r *http.Request) {
+	twinID := chi.URLParam(r, "twinId")
+	patch, ok := decodeMetadata(w, r)
+	if !ok {
+		return
+	}
+
+	// The patch is applied to a copy read here and written back only if the twin is unchanged;
+	// with If-Match that copy is the version the client expects, otherwise a lost race is retried
+	current, ok := a.checkTwinPrecondition(w, r, twinID)
+	if !ok {
+		return
+	}
+	conditional := current != nil
+
+	ctx := r.Context()
+	for attempt := 1; ; attempt++ {
+		if current == nil {
+			var err error
+			if current, err = a.Store.FindTwinByID(ctx, twinID); err != nil {
+				log.Printf("DEBUG: Failed to find twin '%s' for metadata patch: %v", twinID, err)
+				writeStoreError(w, err, resourceTwin, "Failed to retrieve twin")
+				return
+			}
+		}
+		metadata := mergeMetadata(current.Metadata, patch)
+		err := a.Store.UpdateMetadataIfUnmodified(ctx, twinID, metadata, current.UpdatedAt)
+		if err == nil {
+			break
+		}
+		if conditional || !errors.Is(err, persistence.ErrPreconditionFailed) || attempt == metadataPatchAttempts {
+			log.Printf("ERROR: Failed to patch metadata for twin '%s': %v", twinID, err)
+			writeStoreError(w, err, resourceTwin, "Failed to update metadata")
+			return
+		}
+		current = nil
+	}
+	a.writeTwinAfterMetadataUpdate(w, r, twinID)
+}
+
+// mergeMetadata applies a merge patch to a copy of metadata (see PatchTwinMetadata).
+func mergeMetadata(metadata, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(metadata)+len(patch))
+	for key, v := range metadata {
+		merged[key] = v
+	}
+	for key, v := range patch {
+		switch pv := v.(type) {
+		case nil:
+			delete(merged, key)
+		case map[string]interface{}:
+			existing, _ := merged[key].(map[string]interface{})
+			merged[key] = mergeMetadata(existing, pv)
+		default:
+			merged[key] = v
+		}
+	}
+	return merged
+}
+
+// writeTwinAfterMetadataUpdate responds with the updated twin.
+func (a *API) writeTwinAfterMetadataUpdate(w http.ResponseWriter, r *http.Request, twinID string) {
+	updatedTwin, err := a.Store.FindTwinByID(r.Context(), twinID)
+	if err != nil {
+		log.Printf("ERROR: Failed to retrieve twin '%s' after metadata update: %v", twinID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve twin after update")
+		return
+	}
+
+	log.Printf("INFO: Updated metadata for twin: ID=%s for %s", twinID, describeActor(r.Context()))
+	setTwinETag(w, updatedTwin)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(newTwinView(updatedTwin, a.unsetMapsFor(w, r))); err != nil {
+		log.Printf("ERROR: Failed to encode update metadata response: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+// pkg/api/twin_order.go
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// Orders of GET /twins (?sort=)
+const (
+	twinSortID          = "id" // The default
+	twinSortDisplayName = "displayName"
+)
+
+// parseTwinSort reads the ?sort= order of GET /twins and reports whether it is by display name.
+// It writes a 400 and returns false for an unknown order.
+func parseTwinSort(w http.ResponseWriter, r *http.Request) (byDisplayName bool, ok bool) {
+	switch r.URL.Query().Get("sort") {
+	case "", twinSortID:
+		return false, true
+	case twinSortDisplayName:
+		return true, true
+	default:
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid 'sort' query parameter: use id or displayName")
+		return false, false
+	}
+}
+
+// twinCursor returns the nextCursor after t. Pages by ID, it is the ID itself; by display name it
+// also carries the name, as base64url-encoded JSON ["displayName", "id"], since both order.
+func twinCursor(t *model.TwinInstance, byDisplayName bool) string {
+	if !byDisplayName {
+		return t.ID
+	}
+	data, _ := json.Marshal([]string{t.DisplayName, t.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// applyTwinCursor sets the position of q from a ?cursor= of twinCursor. It writes a 400 and
+// returns false for a display name cursor that doesn't decode.
+func applyTwinCursor(w http.ResponseWriter, q *persistence.TwinPageQuery, cursor string) bool {
+	if cursor == "" || !q.ByDisplayName {
+		q.AfterID = cursor
+		return true
+	}
+	var position []string
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &position)
+	}
+	if err != nil || len(position) != 2 || position[1] == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid cursor parameter")
+		return false
+	}
+	q.AfterDisplayName, q.AfterID = position[0], position[1]
+	return true
+}
+
+// twinAfter reports whether t comes after the position of q in its order (see
+// persistence.TwinPageQuery), for lists the store doesn't page.
+func twinAfter(t *model.TwinInstance, q persistence.TwinPageQuery) bool {
+	if q.ByDisplayName && t.DisplayName != q.AfterDisplayName {
+		return t.DisplayName > q.AfterDisplayName
+	}
+	return t.ID > q.AfterID
+}
+
+// displayNameContains reports whether t's display name contains search, case-insensitively, as
+// persistence.TwinPageQuery.Search matches.
+func displayNameContains(t *model.TwinInstance, search string) bool {
+	return search == "" || strings.Contains(strings.ToLower(t.DisplayName), strings.ToLower(search))
+}
@@ -0,0 +1,93 @@
+// pkg/api/unset_maps.go
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// Unset-maps policies: how twin responses render reportedProperties, desiredProperties and tags
+// that are empty. Stores tell a map that was never set (e.g. no reported properties yet) from
+// one explicitly set to {}; tags are always set.
+const (
+	UnsetMapsOmitEmpty = "omitempty" // Empty and unset maps are both omitted (the default)
+	UnsetMapsNull      = "null"      // Unset maps are null, empty ones {}
+	UnsetMapsOmit      = "omit"      // Unset maps are omitted, empty ones {}
+)
+
+// unsetMapsPreference is the RFC 7240 preference that picks a policy per request.
+const unsetMapsPreference = "unset-maps"
+
+// isUnsetMapsPolicy reports whether v is one of the UnsetMaps* policies.
+func isUnsetMapsPolicy(v string) bool {
+	switch v {
+	case UnsetMapsOmitEmpty, UnsetMapsNull, UnsetMapsOmit:
+		return true
+	}
+	return false
+}
+
+// unsetMapsFor returns the policy for r's twin responses: the client's
+// "Prefer: unset-maps=<policy>" when it names a known one (acknowledged with Preference-Applied),
+// otherwise the server default. Unknown values are ignored, as RFC 7240 asks.
+func (a *API) unsetMapsFor(w http.ResponseWriter, r *http.Request) string {
+	w.Header().Add("Vary", "Prefer")
+	for _, v := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pref), "=")
+			if !ok || !strings.EqualFold(strings.TrimSpace(name), unsetMapsPreference) {
+				continue
+			}
+			if policy := strings.ToLower(strings.Trim(strings.TrimSpace(value), `"`)); isUnsetMapsPolicy(policy) {
+				w.Header().Add("Preference-Applied", unsetMapsPreference+"="+policy)
+				return policy
+			}
+		}
+	}
+	if a.UnsetMaps != "" {
+		return a.UnsetMaps
+	}
+	return UnsetMapsOmitEmpty
+}
+
+// twinView is a twin as written in responses. Its map fields shadow the twin's (the outer fields
+// win in encoding/json) to render them under an unset-maps policy.
+type twinView struct {
+	*model.TwinInstance
+	ReportedProperties interface{} `json:"reportedProperties,omitempty"`
+	DesiredProperties  interface{} `json:"desiredProperties,omitempty"`
+	Tags               interface{} `json:"tags,omitempty"`
+}
+
+// newTwinView renders t's maps under policy (see the UnsetMaps* constants).
+func newTwinView(t *model.TwinInstance, policy string) twinView {
+	return twinView{
+		TwinInstance:       t,
+		ReportedProperties: renderMap(t.ReportedProperties == nil, len(t.ReportedProperties), t.ReportedProperties, policy),
+		DesiredProperties:  renderMap(t.DesiredProperties == nil, len(t.DesiredProperties), t.DesiredProperties, policy),
+		Tags:               renderMap(t.Tags == nil, len(t.Tags), t.Tags, policy),
+	}
+}
+
+// newTwinViews renders a list of twins; the result is never nil.
+func newTwinViews(twins []*model.TwinInstance, policy string) []twinView {
+	views := make([]twinView, 0, len(twins))
+	for _, t := range twins {
+		views = append(views, newTwinView(t, policy))
+	}
+	return views
+}
+
+// renderMap returns what a twin map field encodes as: nil (omitted), JSON null or the map itself.
+func renderMap(unset bool, size int, m interface{}, policy string) interface{} {
+	switch {
+	case unset && policy == UnsetMapsNull:
+		return json.RawMessage("null")
+	case unset, size == 0 && policy == UnsetMapsOmitEmpty:
+		return nil
+	}
+	return m
+}
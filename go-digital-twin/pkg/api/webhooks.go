@@ -0,0 +1,284 @@
+// pkg/api/webhooks.go
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Page size limits of GET /webhooks/{webhookId}/deliveries
+const (
+	defaultDeliveryListLimit = 100
+	maxDeliveryListLimit     = 1000
+)
+
+// webhookRequest is the body of POST /webhooks and PUT /webhooks/{webhookId}.
+type webhookRequest struct {
+	ID         string   `json:"id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"eventTypes"`
+	TwinID     string   `json:"twinId"`
+	ModelID    string   `json:"modelId"`
+	Secret     *string  `json:"secret"` // On PUT, absent keeps the current secret and "" removes it
+}
+
+// webhookView is a subscription as the API returns it: the secret itself is never returned.
+type webhookView struct {
+	*persistence.Webhook
+	HasSecret bool `json:"hasSecret"` // Deliveries carry an X-Webhook-Signature
+}
+
+// deliveryPage is one page of GET /webhooks/{webhookId}/deliveries.
+type deliveryPage struct {
+	Deliveries []*persistence.WebhookDelivery `json:"deliveries"`
+	NextCursor string                         `json:"nextCursor,omitempty"` // Pass as ?cursor= for older deliveries; absent on the last page
+}
+
+// decodeWebhookRequest reads and validates a subscription body. On error it writes the response
+// and returns false.
+func decodeWebhookRequest(w http.ResponseWriter, r *http.Request) (*webhookRequest, bool) {
+	var req webhookRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload: "+err.Error())
+		return nil, false
+	}
+	defer r.Body.Close()
+
+	req.URL = strings.TrimSpace(req.URL)
+	if req.URL == "" {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Missing required field: url")
+		return nil, false
+	}
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Invalid url: must be an absolute http or https URL")
+		return nil, false
+	}
+
+	eventTypes := make([]string, 0, len(req.EventTypes))
+	seen := make(map[string]bool, len(req.EventTypes))
+	for _, t := range req.EventTypes {
+		if !isEventType(t) {
+			writeError(w, http.StatusBadRequest, CodeValidationFailed, fmt.Sprintf("Invalid eventTypes: unknown event type %q (allowed: %s)", t, strings.Join(persistence.EventTypes, ", ")))
+			return nil, false
+		}
+		if !seen[t] {
+			seen[t] = true
+			eventTypes = append(eventTypes, t)
+		}
+	}
+	req.EventTypes = eventTypes
+	req.TwinID = strings.TrimSpace(req.TwinID)
+	req.ModelID = strings.TrimSpace(req.ModelID)
+	return &req, true
+}
+
+// isEventType reports whether t is one of persistence.EventTypes.
+func isEventType(t string) bool {
+	i := sort.SearchStrings(persistence.EventTypes, t)
+	return i < len(persistence.EventTypes) && persistence.EventTypes[i] == t
+}
+
+// writeWebhook writes a subscription without its secret.
+func writeWebhook(w http.ResponseWriter, status int, hook *persistence.Webhook) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(webhookView{Webhook: hook, HasSecret: hook.Secret != ""}); err != nil {
+		log.Printf("ERROR: Failed to encode webhook response: %v", err)
+	}
+}
+
+// CreateWebhook handles POST requests to /webhooks
+// Subscribes url to outbox events: "eventTypes" (persistence.EventTypes; empty or absent for
+// all), "twinId" and "modelId" filter which ones, and every matching event is POSTed to url (see
+// pkg/webhook for the payload), retried with backoff until the endpoint answers 2xx. With a
+// "secret", each request carries X-Webhook-Signature, the HMAC-SHA256 of X-Webhook-Timestamp, a
+// dot and the body. The filters may name twins or models that don't exist (yet). Only events
+// that occur after the subscription are delivered. Requires an unrestricted API key.
+func (a *API) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.ID == "" {
+		req.ID = "webhook-" + uuid.NewString()
+	}
+	now := time.Now().UTC()
+	hook := &persistence.Webhook{
+		ID:         req.ID,
+		URL:        req.URL,
+		EventTypes: req.EventTypes,
+		TwinID:     req.TwinID,
+		ModelID:    req.ModelID,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if req.Secret != nil {
+		hook.Secret = *req.Secret
+	}
+
+	if err := a.Store.CreateWebhook(r.Context(), hook); err != nil {
+		log.Printf("ERROR: Failed to create webhook: %v", err)
+		writeStoreError(w, err, resourceWebhook, "Failed to create webhook")
+		return
+	}
+
+	log.Printf("INFO: Created webhook: ID=%s, URL=%s, EventTypes=%v for %s", hook.ID, hook.URL, hook.EventTypes, describeActor(r.Context()))
+	a.setLocation(w, "webhooks", hook.ID)
+	writeWebhook(w, http.StatusCreated, hook)
+}
+
+// GetWebhook handles GET requests to /webhooks/{webhookId}
+func (a *API) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookID := chi.URLParam(r, "webhookId")
+	hook, err := a.Store.FindWebhookByID(r.Context(), webhookID)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find webhook '%s': %v", webhookID, err)
+		writeStoreError(w, err, resourceWebhook, "Failed to retrieve webhook")
+		return
+	}
+	writeWebhook(w, http.StatusOK, hook)
+}
+
+// ListWebhooks handles GET requests to /webhooks
+func (a *API) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := a.Store.ListWebhooks(r.Context())
+	if err != nil {
+		log.Printf("ERROR: Failed to list webhooks: %v", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve webhooks")
+		return
+	}
+
+	views := make([]webhookView, 0, len(hooks))
+	for _, hook := range hooks {
+		views = append(views, webhookView{Webhook: hook, HasSecret: hook.Secret != ""})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(views); err != nil {
+		log.Printf("ERROR: Failed to encode list webhooks response: %v", err)
+	}
+}
+
+// UpdateWebhook handles PUT requests to /webhooks/{webhookId} (full replacement, except that an
+// absent "secret" keeps the current one). Queued deliveries keep going to the subscription's
+// current URL with its current secret.
+func (a *API) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookID := chi.URLParam(r, "webhookId")
+	req, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.ID != "" && req.ID != webhookID {
+		writeError(w, http.StatusBadRequest, CodeValidationFailed, "Webhook ID in payload does not match ID in URL")
+		return
+	}
+
+	ctx := r.Context()
+	hook, err := a.Store.FindWebhookByID(ctx, webhookID)
+	if err != nil {
+		log.Printf("DEBUG: Failed to find webhook '%s' for update: %v", webhookID, err)
+		writeStoreError(w, err, resourceWebhook, "Failed to retrieve webhook")
+		return
+	}
+	hook.URL = req.URL
+	hook.EventTypes = req.EventTypes
+	hook.TwinID = req.TwinID
+	hook.ModelID = req.ModelID
+	if req.Secret != nil {
+		hook.Secret = *req.Secret
+	}
+	if err := a.Store.UpdateWebhook(ctx, hook); err != nil {
+		log.Printf("DEBUG: Failed to update webhook '%s': %v", webhookID, err)
+		writeStoreError(w, err, resourceWebhook, "Failed to update webhook")
+		return
+	}
+
+	// Re-fetch to return the stored state (including the new updatedAt)
+	updated, err := a.Store.FindWebhookByID(ctx, webhookID)
+	if err != nil {
+		log.Printf("ERROR: Failed to retrieve updated webhook '%s' after update: %v", webhookID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve webhook after update")
+		return
+	}
+
+	log.Printf("INFO: Updated webhook: ID=%s for %s", webhookID, describeActor(ctx))
+	writeWebhook(w, http.StatusOK, updated)
+}
+
+// DeleteWebhook handles DELETE requests to /webhooks/{webhookId}
+// Its queued deliveries and delivery history are deleted with it.
+func (a *API) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookID := chi.URLParam(r, "webhookId")
+	if err := a.Store.DeleteWebhook(r.Context(), webhookID); err != nil {
+		log.Printf("DEBUG: Failed to delete webhook '%s': %v", webhookID, err)
+		writeStoreError(w, err, resourceWebhook, "Failed to delete webhook")
+		return
+	}
+	log.Printf("INFO: Deleted webhook: ID=%s for %s", webhookID, describeActor(r.Context()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries handles GET requests to /webhooks/{webhookId}/deliveries
+// Lists the subscription's deliveries newest first: pending ones with their attempts so far and
+// next attempt, finished ones until they are pruned. ?limit= (default 100, max 1000) per page;
+// follow nextCursor with ?cursor= for older deliveries.
+func (a *API) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhookID := chi.URLParam(r, "webhookId")
+	query := r.URL.Query()
+
+	limit := defaultDeliveryListLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDeliveryListLimit {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid limit parameter: must be between 1 and %d", maxDeliveryListLimit))
+			return
+		}
+		limit = n
+	}
+	var before int64
+	if v := query.Get("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid cursor parameter")
+			return
+		}
+		before = n
+	}
+
+	ctx := r.Context()
+	if _, err := a.Store.FindWebhookByID(ctx, webhookID); err != nil {
+		log.Printf("DEBUG: Failed to find webhook '%s': %v", webhookID, err)
+		writeStoreError(w, err, resourceWebhook, "Failed to retrieve webhook")
+		return
+	}
+	deliveries, err := a.Store.ListWebhookDeliveries(ctx, webhookID, before, limit+1)
+	if err != nil {
+		log.Printf("ERROR: Failed to list deliveries of webhook '%s': %v", webhookID, err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to retrieve webhook deliveries")
+		return
+	}
+
+	page := deliveryPage{Deliveries: deliveries}
+	if len(deliveries) > limit {
+		page.Deliveries = deliveries[:limit]
+		page.NextCursor = strconv.FormatInt(page.Deliveries[limit-1].ID, 10)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Printf("ERROR: Failed to encode webhook deliveries response: %v", err)
+	}
+}
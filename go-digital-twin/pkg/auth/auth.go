@@ -0,0 +1,219 @@
+// pkg/auth/auth.go
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Name string // Human-readable key name (for logs/audit), never the secret itself
+
+	// Tags is the principal's twin selector: it may only access twins whose tags contain every
+	// pair here (e.g. {"site": "eu-west"}). An empty selector means unrestricted access.
+	Tags map[string]string
+
+	// TokenID, TwinID and ModelID are set for devices authenticated with a device token (see
+	// Tokens): they may only write telemetry and reported properties of the token's twin, or of
+	// the twins of its model.
+	TokenID string
+	TwinID  string
+	ModelID string
+}
+
+// Device reports whether the principal authenticated with a device token.
+func (p *Principal) Device() bool {
+	return p != nil && p.TokenID != ""
+}
+
+// Actor is who the principal's writes are attributed to (model history, telemetry): the key
+// name, or for devices the token's name and ID, e.g. "sensor-7 (token 3f2a...)". Empty for nil
+// (unauthenticated requests).
+func (p *Principal) Actor() string {
+	switch {
+	case p == nil:
+		return ""
+	case p.Device():
+		return p.Name + " (token " + p.TokenID + ")"
+	default:
+		return p.Name
+	}
+}
+
+// Unrestricted reports whether the principal may access every twin and manage shared
+// resources (models, templates).
+func (p *Principal) Unrestricted() bool {
+	return p == nil || (len(p.Tags) == 0 && !p.Device())
+}
+
+// CanAccess reports whether the principal's selector matches the given twin tags. Devices are
+// scoped by twin rather than by tags, so this is false for them; use CanAccessTwin.
+func (p *Principal) CanAccess(tags map[string]string) bool {
+	if p.Unrestricted() {
+		return true
+	}
+	if p.Device() {
+		return false
+	}
+	return model.TagsContain(tags, p.Tags)
+}
+
+// CanAccessTwin reports whether the principal's scope includes twin.
+func (p *Principal) CanAccessTwin(twin *model.TwinInstance) bool {
+	if p.Device() {
+		return twin.ID == p.TwinID || (p.ModelID != "" && twin.ModelID == p.ModelID)
+	}
+	return p.CanAccess(twin.Tags)
+}
+
+// principalKey is the context key for the request's Principal.
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the request's principal, or nil when authentication is disabled.
+// A nil principal is unrestricted.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// KeyEntry is one API key in the keys file.
+type KeyEntry struct {
+	Name string            `json:"name"`
+	Key  string            `json:"key"`
+	Tags map[string]string `json:"tags,omitempty"` // Twin selector; omit for an unrestricted key
+}
+
+// Keys maps API keys (by SHA-256 digest, so lookups don't compare secrets directly) to principals.
+type Keys struct {
+	byDigest map[[sha256.Size]byte]*Principal
+}
+
+// NewKeys builds a key set. Names and keys must be non-empty and keys unique.
+func NewKeys(entries []KeyEntry) (*Keys, error) {
+	k := &Keys{byDigest: make(map[[sha256.Size]byte]*Principal, len(entries))}
+	for i, e := range entries {
+		if e.Name == "" || e.Key == "" {
+			return nil, fmt.Errorf("api key at index %d: name and key are required", i)
+		}
+		digest := sha256.Sum256([]byte(e.Key))
+		if _, dup := k.byDigest[digest]; dup {
+			return nil, fmt.Errorf("api key '%s' duplicates another key", e.Name)
+		}
+		tags := make(map[string]string, len(e.Tags))
+		for tk, tv := range e.Tags {
+			tags[tk] = tv
+		}
+		k.byDigest[digest] = &Principal{Name: e.Name, Tags: tags}
+	}
+	return k, nil
+}
+
+// LoadKeysFile reads a JSON array of KeyEntry from path, e.g.
+//
+//	[{"name": "ops", "key": "..."},
+//	 {"name": "eu-west-gateway", "key": "...", "tags": {"site": "eu-west"}}]
+func LoadKeysFile(path string) (*Keys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api keys file: %w", err)
+	}
+	var entries []KeyEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse api keys file '%s': %w", path, err)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("api keys file contains no keys")
+	}
+	return NewKeys(entries)
+}
+
+// Lookup returns the principal for an API key, or nil if the key is unknown.
+func (k *Keys) Lookup(key string) *Principal {
+	return k.byDigest[sha256.Sum256([]byte(key))]
+}
+
+// requestKey extracts the API key from "Authorization: Bearer <key>" or "X-API-Key: <key>".
+func requestKey(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if scheme, token, ok := strings.Cut(h, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// Middleware authenticates every request against keys and stores the Principal in the
+// request context. With tokens, device tokens (TokenPrefix) are accepted as well and yield a
+// device principal. Requests without a valid key or token get 401 UNAUTHORIZED.
+func Middleware(keys *Keys, tokens *Tokens) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := requestKey(r)
+			if key == "" {
+				writeUnauthorized(w, "Missing API key (use Authorization: Bearer <key> or X-API-Key)")
+				return
+			}
+			if tokens != nil && strings.HasPrefix(key, TokenPrefix) {
+				claims, err := tokens.Verify(key, time.Now())
+				if err != nil {
+					log.Printf("WARN: Rejected request with device token from %s: %v", r.RemoteAddr, err)
+					writeUnauthorized(w, tokenErrorMessage(err))
+					return
+				}
+				p := &Principal{Name: claims.Name, TokenID: claims.ID, TwinID: claims.TwinID, ModelID: claims.ModelID}
+				next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+				return
+			}
+			p := keys.Lookup(key)
+			if p == nil {
+				log.Printf("WARN: Rejected request with unknown API key from %s", r.RemoteAddr)
+				writeUnauthorized(w, "Invalid API key")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+		})
+	}
+}
+
+// tokenErrorMessage is the 401 message for a failed token verification.
+func tokenErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrTokenExpired):
+		return "Device token expired"
+	case errors.Is(err, ErrTokenRevoked):
+		return "Device token revoked"
+	default:
+		return "Invalid device token"
+	}
+}
+
+// writeUnauthorized writes the API's standard error envelope (see api.ErrorResponse).
+func writeUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("WWW-Authenticate", `Bearer realm="digital-twin"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	body := map[string]string{"code": "UNAUTHORIZED", "message": message}
+	if id := w.Header().Get("X-Request-ID"); id != "" { // Set by the API's request ID middleware
+		body["requestId"] = id
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("ERROR: Failed to encode error response: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+// pkg/auth/token.go
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenPrefix starts every device token, telling them apart from API keys.
+const TokenPrefix = "dtt."
+
+// MinTokenSecretLength is the shortest secret NewTokens accepts (bytes).
+const MinTokenSecretLength = 32
+
+// Errors returned by Tokens.Verify
+var (
+	ErrTokenInvalid = errors.New("invalid device token")
+	ErrTokenExpired = errors.New("device token expired")
+	ErrTokenRevoked = errors.New("device token revoked")
+)
+
+// TokenClaims are the contents of a device token. A token is scoped to exactly one twin or to
+// every twin of one model.
+type TokenClaims struct {
+	ID        string `json:"jti"`             // Unique token ID, what revocation refers to
+	Name      string `json:"sub"`             // Human-readable name of the device (for logs/audit)
+	TwinID    string `json:"twin,omitempty"`  // The twin the token may write to
+	ModelID   string `json:"model,omitempty"` // Or: the model whose twins the token may write to
+	IssuedAt  int64  `json:"iat"`             // Unix seconds
+	ExpiresAt int64  `json:"exp"`             // Unix seconds
+}
+
+// Tokens issues and verifies device tokens: "dtt.<claims>.<signature>", the claims being
+// base64url JSON and the signature their HMAC-SHA256 under the server's secret. Tokens are not
+// stored; a token is valid until it expires unless its ID is on the revocation list, which the
+// caller keeps in sync with the store (see LoadRevoked). Safe for concurrent use.
+type Tokens struct {
+	secret []byte
+	maxTTL time.Duration
+
+	mu      sync.RWMutex
+	revoked map[string]time.Time // Token ID -> when the revocation may be forgotten (the token expired by then)
+}
+
+// NewTokens creates the token issuer/verifier. secret must be at least MinTokenSecretLength
+// bytes; maxTTL caps the lifetime of issued tokens.
+func NewTokens(secret string, maxTTL time.Duration) (*Tokens, error) {
+	if len(secret) < MinTokenSecretLength {
+		return nil, fmt.Errorf("device token secret must be at least %d bytes (got %d)", MinTokenSecretLength, len(secret))
+	}
+	if maxTTL <= 0 {
+		return nil, fmt.Errorf("device token max TTL must be positive (got %s)", maxTTL)
+	}
+	return &Tokens{secret: []byte(secret), maxTTL: maxTTL, revoked: make(map[string]time.Time)}, nil
+}
+
+// MaxTTL is the longest lifetime of an issued token; revocations are kept that long.
+func (t *Tokens) MaxTTL() time.Duration {
+	return t.maxTTL
+}
+
+// Issue signs claims into a token. The ID is generated when empty and IssuedAt set to now;
+// exactly one of TwinID and ModelID must be set, and ExpiresAt must lie within MaxTTL of now.
+func (t *Tokens) Issue(claims TokenClaims, now time.Time) (string, TokenClaims, error) {
+	if (claims.TwinID == "") == (claims.ModelID == "") {
+		return "", claims, errors.New("a device token is scoped to exactly one of a twin or a model")
+	}
+	if claims.ID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return "", claims, fmt.Errorf("failed to generate token ID: %w", err)
+		}
+		claims.ID = hex.EncodeToString(id)
+	}
+	claims.IssuedAt = now.Unix()
+	if claims.ExpiresAt <= claims.IssuedAt || claims.ExpiresAt > now.Add(t.maxTTL).Unix() {
+		return "", claims, fmt.Errorf("token expiry must be within %s from now", t.maxTTL)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", claims, fmt.Errorf("failed to encode token claims: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return TokenPrefix + encoded + "." + base64.RawURLEncoding.EncodeToString(t.sign(encoded)), claims, nil
+}
+
+// sign is the HMAC of the encoded claims.
+func (t *Tokens) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// Verify checks token's signature, expiry (at now) and revocation, returning its claims.
+func (t *Tokens) Verify(token string, now time.Time) (*TokenClaims, error) {
+	rest, ok := strings.CutPrefix(token, TokenPrefix)
+	if !ok {
+		return nil, ErrTokenInvalid
+	}
+	encoded, sig, ok := strings.Cut(rest, ".")
+	if !ok {
+		return nil, ErrTokenInvalid
+	}
+	gotSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(gotSig, t.sign(encoded)) {
+		return nil, ErrTokenInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.ID == "" {
+		return nil, ErrTokenInvalid
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	if t.IsRevoked(claims.ID) {
+		return nil, ErrTokenRevoked
+	}
+	return &claims, nil
+}
+
+// Revoke puts a token ID on the revocation list until the given time.
+func (t *Tokens) Revoke(id string, until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until.After(t.revoked[id]) {
+		t.revoked[id] = until
+	}
+}
+
+// IsRevoked reports whether a token ID is on the revocation list.
+func (t *Tokens) IsRevoked(id string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, ok := t.revoked[id]
+	return ok
+}
+
+// LoadRevoked adds revocations read from the store (token ID -> until) to the list and forgets
+// those past their time at now. Revocations are never lifted, so merging (rather than replacing)
+// keeps one made locally while the store was being read.
+func (t *Tokens) LoadRevoked(revoked map[string]time.Time, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, until := range revoked {
+		if until.After(t.revoked[id]) {
+			t.revoked[id] = until
+		}
+	}
+	for id, until := range t.revoked {
+		if !now.Before(until) {
+			delete(t.revoked, id)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// pkg/cardinality/allowlist.go
+package cardinality
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// DefaultAllowlistTTL bounds how long a model's cached allowlist is trusted. Local model updates
+// invalidate the cache immediately; the TTL covers updates made through other replicas.
+const DefaultAllowlistTTL = 30 * time.Second
+
+// ErrNameNotAllowed is returned by Check when a name is not in the model's allowedTelemetryNames.
+var ErrNameNotAllowed = errors.New("telemetry name not allowed by model")
+
+// Allowlist metrics
+var disallowedTotal = metrics.NewCounter("telemetry_name_disallowed_total", "Telemetry writes rejected because a name is not in the model's allowedTelemetryNames.")
+
+// ModelFinder loads models (persistence.ModelStore satisfies it).
+type ModelFinder interface {
+	FindModelByID(ctx context.Context, id string) (*model.TwinModel, error)
+}
+
+// Allowlists enforces TwinModel.AllowedTelemetryNames on ingestion, applies its
+// TelemetryNameMappings (see Normalize), the enums of its telemetry definitions (see
+// CheckValue), their scale/offset conversions (see Transform) and downsampling (see
+// Downsampling), caching them per model so the hot path does not load the model for every write.
+// A nil *Allowlists allows everything and rewrites nothing.
+type Allowlists struct {
+	store ModelFinder
+	ttl   time.Duration
+
+	mu     sync.Mutex
+	models map[string]allowlistEntry // modelID -> cached allowlist and mappings
+}
+
+// allowlistEntry is one model's cached name rules; nil names/mappings means the model has none.
+type allowlistEntry struct {
+	names      map[string]struct{}
+	mappings   map[string]string                    // alias -> canonical name
+	enums      map[string][]string                  // telemetry name -> allowed stringValues
+	transforms map[string]model.TelemetryDefinition // telemetry name -> definition with scale/offset
+	downsample map[string]model.TelemetryDownsampling
+	loadedAt   time.Time
+}
+
+// NewAllowlists creates an allowlist cache whose entries are reloaded after ttl
+// (DefaultAllowlistTTL when ttl <= 0).
+func NewAllowlists(store ModelFinder, ttl time.Duration) *Allowlists {
+	if ttl <= 0 {
+		ttl = DefaultAllowlistTTL
+	}
+	return &Allowlists{
+		store:  store,
+		ttl:    ttl,
+		models: make(map[string]allowlistEntry),
+	}
+}
+
+// Check returns ErrNameNotAllowed (naming the first offending name) if the model declares an
+// allowlist and any of names is missing from it. Models without an allowlist accept every name.
+func (l *Allowlists) Check(ctx context.Context, modelID string, names ...string) error {
+	if l == nil || len(names) == 0 {
+		return nil
+	}
+
+	entry, err := l.load(ctx, modelID)
+	if err != nil {
+		return err
+	}
+	allowed := entry.names
+	if allowed == nil {
+		return nil
+	}
+	for _, name := range names {
+		if _, ok := allowed[name]; !ok {
+			disallowedTotal.Inc()
+			log.Printf("WARN: Rejecting telemetry '%s': not in allowedTelemetryNames of model '%s'", name, modelID)
+			return fmt.Errorf("%w: model '%s' does not allow telemetry name '%s'", ErrNameNotAllowed, modelID, name)
+		}
+	}
+	return nil
+}
+
+// Invalidate drops the cached allowlist, mappings, enums, conversions and downsampling for a model (after it is updated or deleted).
+func (l *Allowlists) Invalidate(modelID string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.models, modelID)
+}
+
+// InvalidateAll drops the cached rules of every model.
+func (l *Allowlists) InvalidateAll() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.models = make(map[string]allowlistEntry)
+}
+
+// load returns the model's cached name rules, reading the model when the cached entry is missing
+// or older than the TTL. The store call happens outside the lock, so a load racing with Invalidate
+// can cache the previous rules until the TTL expires.
+func (l *Allowlists) load(ctx context.Context, modelID string) (allowlistEntry, error) {
+	l.mu.Lock()
+	entry, ok := l.models[modelID]
+	l.mu.Unlock()
+	if ok && time.Since(entry.loadedAt) < l.ttl {
+		return entry, nil
+	}
+
+	m, err := l.store.FindModelByID(ctx, modelID)
+	if err != nil {
+		return allowlistEntry{}, fmt.Errorf("failed to load telemetry allowlist of model '%s': %w", modelID, err)
+	}
+	entry = allowlistEntry{loadedAt: time.Now()}
+	if len(m.AllowedTelemetryNames) > 0 {
+		entry.names = make(map[string]struct{}, len(m.AllowedTelemetryNames))
+		for _, name := range m.AllowedTelemetryNames {
+			entry.names[name] = struct{}{}
+		}
+	}
+	if len(m.TelemetryNameMappings) > 0 {
+		entry.mappings = m.TelemetryNameMappings // The model was loaded just for us; nothing else holds it
+	}
+	if downsample := m.TelemetryDownsampling(); len(downsample) > 0 {
+		entry.downsample = downsample
+	}
+	for name, def := range m.Telemetry {
+		if def.Transforms() {
+			if entry.transforms == nil {
+				entry.transforms = make(map[string]model.TelemetryDefinition)
+			}
+			entry.transforms[name] = def
+		}
+		if len(def.Enum) == 0 {
+			continue
+		}
+		if entry.enums == nil {
+			entry.enums = make(map[string][]string)
+		}
+		entry.enums[name] = def.Enum
+	}
+
+	l.mu.Lock()
+	l.models[modelID] = entry
+	l.mu.Unlock()
+	return entry, nil
+}
@@ -0,0 +1,24 @@
+// pkg/cardinality/downsample.go
+package cardinality
+
+import (
+	"context"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// Downsampling returns how live points of the model's telemetry name are downsampled (see
+// model.TelemetryDefinition.Downsample); ok is false when they are stored as sent. Pass canonical
+// names (see Normalize).
+func (l *Allowlists) Downsampling(ctx context.Context, modelID, name string) (d model.TelemetryDownsampling, ok bool, err error) {
+	if l == nil {
+		return model.TelemetryDownsampling{}, false, nil
+	}
+
+	entry, err := l.load(ctx, modelID)
+	if err != nil {
+		return model.TelemetryDownsampling{}, false, err
+	}
+	d, ok = entry.downsample[name]
+	return d, ok, nil
+}
@@ -0,0 +1,49 @@
+// pkg/cardinality/enum.go
+package cardinality
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// ErrValueNotInEnum is returned by CheckValue when a point's value is outside the enum of the
+// model's telemetry definition for its name.
+var ErrValueNotInEnum = errors.New("telemetry value not in enum")
+
+// Enum metrics
+var enumRejectedTotal = metrics.NewCounter("telemetry_enum_rejected_total", "Telemetry writes rejected because a value is outside the enum of the model's telemetry definition.")
+
+// CheckValue returns ErrValueNotInEnum (listing the allowed values) if the model defines an enum
+// for name and the point isn't a stringValue in it; stringValue is nil for numeric and boolean
+// points. Names without an enum accept every value. Pass canonical names (see Normalize).
+func (l *Allowlists) CheckValue(ctx context.Context, modelID, name string, stringValue *string) error {
+	if l == nil {
+		return nil
+	}
+
+	entry, err := l.load(ctx, modelID)
+	if err != nil {
+		return err
+	}
+	enum, ok := entry.enums[name]
+	if !ok {
+		return nil
+	}
+	if (model.TelemetryDefinition{Enum: enum}).InEnum(stringValue) {
+		return nil
+	}
+
+	enumRejectedTotal.Inc()
+	got := "a non-string value"
+	if stringValue != nil {
+		got = fmt.Sprintf("'%s'", *stringValue)
+	}
+	log.Printf("WARN: Rejecting telemetry '%s': %s is not in the enum of model '%s'", name, got, modelID)
+	return fmt.Errorf("%w: telemetry '%s' of model '%s' must be a stringValue, one of: %s (got %s)", ErrValueNotInEnum, name, modelID, strings.Join(enum, ", "), got)
+}
@@ -0,0 +1,161 @@
+// pkg/cardinality/limiter.go
+package cardinality
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+)
+
+// DefaultMaxNamesPerTwin is the cap used when none is configured (TELEMETRY_MAX_NAMES_PER_TWIN).
+const DefaultMaxNamesPerTwin = 1000
+
+// ErrNameLimitExceeded is returned by Admit when a write would push a twin past its name cap.
+var ErrNameLimitExceeded = errors.New("telemetry name limit exceeded")
+
+// Cardinality metrics
+var rejectedTotal = metrics.NewCounter("telemetry_name_limit_rejected_total", "Telemetry writes rejected because they introduced names beyond the per-twin cap.")
+
+// NameLister loads the names already stored for a twin (persistence.TimeSeriesStore satisfies it).
+type NameLister interface {
+	ListTelemetryNames(ctx context.Context, twinID string) ([]string, error)
+}
+
+// Limiter caps the number of distinct telemetry names per twin.
+// Each twin's name set is loaded from the store on first use and then maintained in memory,
+// so steady-state checks never touch the database. A nil *Limiter admits everything.
+//
+// The cache is per-process. Names are recorded when admitted, before the write happens, so a
+// write that later fails can leave a name counted that was never stored; the set is reloaded
+// on restart or after Forget. With several API replicas the cap can be overshot by at most
+// one batch per replica.
+type Limiter struct {
+	max   int
+	store NameLister
+
+	mu    sync.Mutex
+	names map[string]map[string]struct{} // twinID -> known names (nil entry = not loaded)
+}
+
+// NewLimiter creates a limiter allowing at most max distinct names per twin.
+// max <= 0 disables the cap and returns nil.
+func NewLimiter(store NameLister, max int) *Limiter {
+	if max <= 0 {
+		return nil
+	}
+	return &Limiter{
+		max:   max,
+		store: store,
+		names: make(map[string]map[string]struct{}),
+	}
+}
+
+// Max returns the configured cap (0 when the limiter is disabled).
+func (l *Limiter) Max() int {
+	if l == nil {
+		return 0
+	}
+	return l.max
+}
+
+// Admit checks whether writing the given names for twinID stays within the cap.
+// Names the twin already has are always allowed; if the new ones would exceed the cap the whole
+// call is rejected with ErrNameLimitExceeded and nothing is recorded. Otherwise the new names
+// are recorded as known.
+func (l *Limiter) Admit(ctx context.Context, twinID string, names ...string) error {
+	if l == nil || len(names) == 0 {
+		return nil
+	}
+
+	known, err := l.load(ctx, twinID)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Re-read under the lock: a concurrent Forget may have dropped the set, in which case we
+	// keep using the one we loaded
+	if current, ok := l.names[twinID]; ok {
+		known = current
+	} else {
+		l.names[twinID] = known
+	}
+
+	var fresh []string
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if _, ok := known[name]; ok {
+			continue
+		}
+		if _, dup := seen[name]; dup {
+			continue
+		}
+		seen[name] = struct{}{}
+		fresh = append(fresh, name)
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	if len(known)+len(fresh) > l.max {
+		rejectedTotal.Inc()
+		log.Printf("WARN: Rejecting telemetry for twin '%s': %d new name(s) (first: '%s') would exceed the limit of %d distinct names (has %d)",
+			twinID, len(fresh), fresh[0], l.max, len(known))
+		return fmt.Errorf("%w: twin '%s' already has %d of %d allowed telemetry names; rejecting new name '%s'",
+			ErrNameLimitExceeded, twinID, len(known), l.max, fresh[0])
+	}
+
+	for _, name := range fresh {
+		known[name] = struct{}{}
+	}
+	return nil
+}
+
+// Forget drops the cached name set for a twin (e.g., after the twin is deleted).
+func (l *Limiter) Forget(twinID string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.names, twinID)
+}
+
+// ForgetAll drops every cached name set (e.g., after telemetry was changed outside the API); each
+// twin's set is reloaded on its next write.
+func (l *Limiter) ForgetAll() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.names = make(map[string]map[string]struct{})
+}
+
+// load returns the cached name set for twinID, reading it from the store on first use.
+// The store call happens outside the lock; concurrent first loads for the same twin are
+// harmless (the first one stored wins in Admit).
+func (l *Limiter) load(ctx context.Context, twinID string) (map[string]struct{}, error) {
+	l.mu.Lock()
+	known, ok := l.names[twinID]
+	l.mu.Unlock()
+	if ok {
+		return known, nil
+	}
+
+	stored, err := l.store.ListTelemetryNames(ctx, twinID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load telemetry names for twin '%s': %w", twinID, err)
+	}
+	known = make(map[string]struct{}, len(stored))
+	for _, name := range stored {
+		known[name] = struct{}{}
+	}
+	return known, nil
+}
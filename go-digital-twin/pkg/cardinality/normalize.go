@@ -0,0 +1,49 @@
+// pkg/cardinality/normalize.go
+package cardinality
+
+import (
+	"context"
+	"log"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+)
+
+// Name mapping metrics
+var rewrittenTotal = metrics.NewCounter("telemetry_name_rewritten_total", "Telemetry names rewritten to their canonical name by the model's telemetryNameMappings.")
+
+// Normalize rewrites names in place to their canonical form using the model's
+// TelemetryNameMappings, so differently named firmware variants are stored under one name.
+// Models without mappings leave names untouched. Call it before Check and the name Limiter, so
+// the allowlist, the cap and storage only ever see canonical names. Each distinct rewrite is
+// logged once per call, with how many of names it applied to.
+func (l *Allowlists) Normalize(ctx context.Context, twinID, modelID string, names ...*string) error {
+	if l == nil || len(names) == 0 {
+		return nil
+	}
+
+	entry, err := l.load(ctx, modelID)
+	if err != nil {
+		return err
+	}
+	if entry.mappings == nil {
+		return nil
+	}
+
+	var rewrites map[string]int // alias -> names rewritten in this call
+	for _, name := range names {
+		canonical, ok := entry.mappings[*name]
+		if !ok {
+			continue
+		}
+		if rewrites == nil {
+			rewrites = make(map[string]int)
+		}
+		rewrites[*name]++
+		*name = canonical
+	}
+	for alias, n := range rewrites {
+		rewrittenTotal.Add(float64(n))
+		log.Printf("INFO: Rewrote telemetry name '%s' -> '%s' for twin '%s' (model '%s', %d records)", alias, entry.mappings[alias], twinID, modelID, n)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+// pkg/cardinality/transform.go
+package cardinality
+
+import (
+	"context"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+)
+
+// Transform metrics
+var transformedTotal = metrics.NewCounter("telemetry_values_transformed_total", "Telemetry numValues converted at ingestion by the scale/offset of the model's telemetry definition.")
+
+// Transform converts *value in place from raw device units with the scale and offset of the
+// model's telemetry definition for name (see model.TelemetryDefinition.Scale), returning the
+// raw value and the definition's RawName when it keeps one. value is nil for stringValue and
+// boolValue points, which like names without a conversion are left untouched (raw is nil then).
+// Pass canonical names (see Normalize).
+func (l *Allowlists) Transform(ctx context.Context, modelID, name string, value *float64) (raw *float64, rawName string, err error) {
+	if l == nil || value == nil {
+		return nil, "", nil
+	}
+
+	entry, err := l.load(ctx, modelID)
+	if err != nil {
+		return nil, "", err
+	}
+	def, ok := entry.transforms[name]
+	if !ok {
+		return nil, "", nil
+	}
+	original := *value
+	*value = def.Transform(original)
+	transformedTotal.Inc()
+	return &original, def.RawName, nil
+}
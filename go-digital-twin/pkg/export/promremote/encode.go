@@ -0,0 +1,151 @@
+// pkg/export/promremote/encode.go
+package promremote
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// The remote-write 1.0 wire format is a snappy-compressed (block format, not framed) protobuf
+// prometheus.WriteRequest. Only the fields written here matter to receivers:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; } // Unix milliseconds
+//
+// Both are small enough to encode by hand rather than pull in protobuf and snappy libraries.
+
+// Protobuf wire types
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireBytes  = 2
+)
+
+// label is one label of a series; a series' labels are sorted by name, as receivers require.
+type label struct {
+	name, value string
+}
+
+// series is one time series of a request with its samples in timestamp order.
+type series struct {
+	labels  []label
+	samples []sample
+}
+
+// encodeWriteRequest returns the protobuf encoding of a WriteRequest of series.
+func encodeWriteRequest(timeseries []*series) []byte {
+	var buf, ts, msg []byte
+	for _, s := range timeseries {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = appendString(msg[:0], 1, l.name)
+			msg = appendString(msg, 2, l.value)
+			ts = appendBytes(ts, 1, msg)
+		}
+		for _, smp := range s.samples {
+			msg = appendTag(msg[:0], 1, wireI64)
+			msg = binary.LittleEndian.AppendUint64(msg, math.Float64bits(smp.value))
+			msg = appendTag(msg, 2, wireVarint)
+			msg = binary.AppendUvarint(msg, uint64(smp.timestamp))
+			ts = appendBytes(ts, 2, msg)
+		}
+		buf = appendBytes(buf, 1, ts)
+	}
+	return buf
+}
+
+func appendTag(b []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendString(b []byte, field int, v string) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// Snappy block format: the uncompressed length as a varint, then literals and back-references.
+const (
+	snappyTagLiteral = 0x00
+	snappyTagCopy1   = 0x01 // 1-byte offset: lengths 4-11, offsets below 2048
+	snappyTagCopy2   = 0x02 // 2-byte offset: lengths 1-64, offsets below 65536
+
+	snappyHashBits  = 14
+	snappyMaxOffset = 1<<16 - 1
+)
+
+// snappyEncode compresses src in the snappy block format. It finds back-references with a
+// single hash table of 4-byte sequences: a plainer matcher than the reference encoder's, but
+// the label names and values repeated across series are what it needs to catch.
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+	var table [1 << snappyHashBits]int32 // Position+1 of the last sequence with that hash
+	literal := 0                         // Start of the bytes not yet emitted
+	for i := 0; i+4 <= len(src); {
+		h := snappyHash(binary.LittleEndian.Uint32(src[i:]))
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if candidate < 0 || i-candidate > snappyMaxOffset ||
+			binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+		n := 4
+		for i+n < len(src) && src[candidate+n] == src[i+n] {
+			n++
+		}
+		dst = snappyLiteral(dst, src[literal:i])
+		dst = snappyCopy(dst, i-candidate, n)
+		i += n
+		literal = i
+	}
+	return snappyLiteral(dst, src[literal:])
+}
+
+func snappyHash(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - snappyHashBits)
+}
+
+// snappyLiteral appends lit as a literal element.
+func snappyLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	switch n := len(lit) - 1; {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyTagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyTagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyTagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+// snappyCopy appends a back-reference of length bytes (at least 4) at offset.
+func snappyCopy(dst []byte, offset, length int) []byte {
+	for length >= 68 {
+		dst = append(dst, 63<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		dst = append(dst, 59<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length-1)<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+	}
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|snappyTagCopy1, byte(offset))
+}
@@ -0,0 +1,76 @@
+// pkg/export/promremote/store.go
+package promremote
+
+import (
+	"context"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// Store wraps a store so the telemetry written through it is also queued on Writer: every write
+// path (sync and async ingestion, batches, imports, backfill, downsampled buckets) ends in one of
+// the five telemetry writes. Records are forwarded once the store has written them; a failed
+// write forwards nothing. Backfilled records the store skipped as duplicates are sent again, which
+// receivers ignore, and backfilled history older than a receiver accepts is rejected by it. A
+// downsampled bucket merged into its stored point is sent again with the merged value, which
+// receivers that already have a sample at that timestamp reject as a duplicate.
+type Store struct {
+	persistence.Store
+	Writer *Writer
+}
+
+// Unwrap returns the wrapped store (see persistence.Underlying).
+func (s *Store) Unwrap() persistence.Store {
+	return s.Store
+}
+
+// WriteTelemetry writes the record and forwards it.
+func (s *Store) WriteTelemetry(ctx context.Context, twinID string, record *persistence.TelemetryRecord) error {
+	if err := s.Store.WriteTelemetry(ctx, twinID, record); err != nil {
+		return err
+	}
+	s.Writer.Add(twinID, []*persistence.TelemetryRecord{record})
+	return nil
+}
+
+// BackfillTelemetry backfills the records and forwards them.
+func (s *Store) BackfillTelemetry(ctx context.Context, twinID string, records []*persistence.TelemetryRecord) (int, error) {
+	inserted, err := s.Store.BackfillTelemetry(ctx, twinID, records)
+	if err != nil {
+		return inserted, err
+	}
+	if inserted > 0 {
+		s.Writer.Add(twinID, records)
+	}
+	return inserted, nil
+}
+
+// MergeDownsampledTelemetry merges the buckets and forwards the points as stored.
+func (s *Store) MergeDownsampledTelemetry(ctx context.Context, twinID string, buckets []*persistence.DownsampledBucket) ([]*persistence.TelemetryRecord, error) {
+	stored, err := s.Store.MergeDownsampledTelemetry(ctx, twinID, buckets)
+	if err != nil {
+		return stored, err
+	}
+	if len(stored) > 0 {
+		s.Writer.Add(twinID, stored)
+	}
+	return stored, nil
+}
+
+// WriteBatchTelemetry writes the records of twinID and forwards them.
+func (s *Store) WriteBatchTelemetry(ctx context.Context, twinID string, records []*persistence.TelemetryRecord) error {
+	if err := s.Store.WriteBatchTelemetry(ctx, twinID, records); err != nil {
+		return err
+	}
+	s.Writer.Add(twinID, records)
+	return nil
+}
+
+// WriteTelemetryCopy writes the records and forwards them, each under its own TwinID.
+func (s *Store) WriteTelemetryCopy(ctx context.Context, records []*persistence.TelemetryRecord) error {
+	if err := s.Store.WriteTelemetryCopy(ctx, records); err != nil {
+		return err
+	}
+	s.Writer.Add("", records)
+	return nil
+}
@@ -0,0 +1,287 @@
+// pkg/export/promremote/writer.go
+
+// Package promremote forwards numeric telemetry to a Prometheus remote-write endpoint (Prometheus
+// itself, Mimir, Thanos, VictoriaMetrics...) as it is stored, so dashboards built on those can
+// chart it without querying this service. Wrap the store in a Store to feed a Writer.
+package promremote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// Remote-write metrics
+var (
+	queueDepth    = metrics.NewGauge("remote_write_queue_depth", "Telemetry samples waiting to be sent to the remote-write endpoint.")
+	sentTotal     = metrics.NewCounter("remote_write_samples_sent_total", "Telemetry samples the remote-write endpoint accepted.")
+	droppedTotal  = metrics.NewCounter("remote_write_samples_dropped_total", "Telemetry samples not queued for remote write because the queue was full.")
+	rejectedTotal = metrics.NewCounter("remote_write_samples_rejected_total", "Telemetry samples the remote-write endpoint rejected (4xx) or that were still unsent at shutdown; they are not retried.")
+	retriesTotal  = metrics.NewCounter("remote_write_retries_total", "Remote-write requests retried after a network error, 429 or 5xx.")
+)
+
+// Retry backoff of a failed request: doubled per attempt up to maxBackoff.
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+// Config configures a Writer.
+type Config struct {
+	URL           string        // Remote-write endpoint, e.g. http://prometheus:9090/api/v1/write
+	BearerToken   string        // Sent as "Authorization: Bearer"; empty sends none
+	MetricPrefix  string        // Prepended to each telemetry name to form the metric name
+	QueueSize     int           // Samples queued at most; more are dropped until there is room
+	BatchSize     int           // Samples per request at most
+	FlushInterval time.Duration // A partial batch is sent once its oldest sample waited this long
+	Timeout       time.Duration // Per request
+}
+
+// sample is one queued point. Series are identified by (name, twinID).
+type sample struct {
+	name      string
+	twinID    string
+	value     float64
+	timestamp int64 // Unix milliseconds
+}
+
+// Writer queues telemetry samples and sends them to a remote-write endpoint in batches from one
+// goroutine, so samples of a series are sent in order. Like the async ingestion pool it never
+// blocks the write path: when the endpoint is slow or down the queue fills up and further
+// samples are dropped (counted in remote_write_samples_dropped_total) while the current batch is
+// retried with backoff. Samples are only in memory; Shutdown sends what is queued.
+type Writer struct {
+	cfg     Config
+	client  *http.Client
+	samples chan sample
+
+	mu     sync.RWMutex // Guards closed; Add holds RLock so Shutdown can't close samples mid-send
+	closed bool
+
+	ctx    context.Context // Cancelled when Shutdown gives up, to abandon retries
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the sender returned
+}
+
+// NewWriter starts a writer sending to cfg.URL. Non-positive sizes and durations fall back to
+// 10000 queued samples, batches of 500, a 5s flush interval and a 10s timeout.
+func NewWriter(cfg Config) *Writer {
+	if cfg.QueueSize < 1 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Writer{
+		cfg:     cfg,
+		client:  &http.Client{},
+		samples: make(chan sample, cfg.QueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	log.Printf("INFO: Forwarding numeric telemetry to remote write %s (queue %d, batches of %d)", cfg.URL, cfg.QueueSize, cfg.BatchSize)
+	return w
+}
+
+// Add queues the numeric records of twinID without blocking; records without a numValue are
+// skipped. Samples that don't fit into the queue, or arrive after Shutdown, are dropped.
+func (w *Writer) Add(twinID string, records []*persistence.TelemetryRecord) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return
+	}
+	for _, rec := range records {
+		if rec.NumericValue == nil {
+			continue
+		}
+		id := twinID
+		if id == "" {
+			id = rec.TwinID
+		}
+		select {
+		case w.samples <- sample{name: rec.Name, twinID: id, value: *rec.NumericValue, timestamp: rec.Timestamp.UnixMilli()}:
+			queueDepth.Inc()
+		default:
+			droppedTotal.Inc()
+		}
+	}
+}
+
+// Queued returns the number of samples waiting to be sent.
+func (w *Writer) Queued() int {
+	return len(w.samples)
+}
+
+// run collects batches and sends them until the queue is closed and empty.
+func (w *Writer) run() {
+	defer close(w.done)
+	batch := make([]sample, 0, w.cfg.BatchSize)
+	timer := time.NewTimer(w.cfg.FlushInterval)
+	timer.Stop()
+	for {
+		select {
+		case s, ok := <-w.samples:
+			if !ok {
+				w.send(batch)
+				return
+			}
+			queueDepth.Dec()
+			if len(batch) == 0 {
+				timer.Reset(w.cfg.FlushInterval)
+			}
+			if batch = append(batch, s); len(batch) < w.cfg.BatchSize {
+				continue
+			}
+		case <-timer.C:
+		}
+		timer.Stop()
+		w.send(batch)
+		batch = batch[:0]
+	}
+}
+
+// send posts a batch, retrying network errors, 429 and 5xx with backoff until it is accepted or
+// the writer is abandoned.
+func (w *Writer) send(batch []sample) {
+	if len(batch) == 0 {
+		return
+	}
+	body := snappyEncode(encodeWriteRequest(w.series(batch)))
+	backoff := minBackoff
+	for {
+		retry, err := w.post(body)
+		if err == nil {
+			sentTotal.Add(float64(len(batch)))
+			return
+		}
+		if !retry || w.ctx.Err() != nil {
+			rejectedTotal.Add(float64(len(batch)))
+			log.Printf("ERROR: Dropping %d remote-write samples: %v", len(batch), err)
+			return
+		}
+		retriesTotal.Inc()
+		log.Printf("WARN: Remote write failed, retrying in %s: %v", backoff, err)
+		select {
+		case <-w.ctx.Done():
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// series groups a batch by series, samples in timestamp order, series in order of first sample.
+func (w *Writer) series(batch []sample) []*series {
+	type key struct{ name, twinID string }
+	byKey := make(map[key]*series)
+	var out []*series
+	for _, s := range batch {
+		k := key{s.name, s.twinID}
+		ts, ok := byKey[k]
+		if !ok {
+			// Labels sorted by name: __name__ < twin_id
+			ts = &series{labels: []label{{"__name__", metricName(w.cfg.MetricPrefix + s.name)}, {"twin_id", s.twinID}}}
+			byKey[k] = ts
+			out = append(out, ts)
+		}
+		ts.samples = append(ts.samples, s)
+	}
+	for _, ts := range out {
+		sort.SliceStable(ts.samples, func(i, j int) bool { return ts.samples[i].timestamp < ts.samples[j].timestamp })
+	}
+	return out
+}
+
+// post sends one request and reports whether a failure is worth retrying.
+func (w *Writer) post(body []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(w.ctx, w.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to build remote-write request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "digital-twin-remote-write")
+	if w.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.cfg.BearerToken)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("remote-write request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body) // Drain so the connection is reused
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("remote-write endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	// Other 4xx (e.g. out-of-order or too old samples) fail the same way on every attempt
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// Shutdown stops accepting samples and sends those queued. Returns an error if they aren't all
+// sent before ctx expires; the rest are dropped.
+func (w *Writer) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.samples) // The sender returns once the remaining samples are sent
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		log.Println("INFO: Remote-write queue flushed.")
+		return nil
+	case <-ctx.Done():
+		unsent := w.Queued()
+		w.cancel()
+		<-w.done // The batch in flight is dropped at once; so is the rest of the queue
+		return fmt.Errorf("remote-write queue not flushed (%d samples unsent): %w", unsent, ctx.Err())
+	}
+}
+
+// metricName makes a telemetry name a valid Prometheus metric name ([a-zA-Z_:][a-zA-Z0-9_:]*),
+// replacing every other character (dots, dashes...) with an underscore.
+func metricName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
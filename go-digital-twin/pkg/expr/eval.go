@@ -0,0 +1,447 @@
+// pkg/expr/eval.go
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Eval evaluates the expression against vars. Values are those produced by encoding/json
+// (nil, bool, float64, string, []interface{}, map[string]interface{}); map[string]string is also
+// accepted so tags can be passed as is. Unknown variables and members are null. Type errors
+// (e.g. "a" * 2) and non-finite results are returned as errors.
+func (p *Program) Eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := p.root.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		return nil, fmt.Errorf("result is not a finite number")
+	}
+	return v, nil
+}
+
+type node interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type literal struct{ value interface{} }
+
+func (n *literal) eval(map[string]interface{}) (interface{}, error) { return n.value, nil }
+
+type variable struct{ name string }
+
+func (n *variable) eval(vars map[string]interface{}) (interface{}, error) {
+	return normalize(vars[n.name]), nil
+}
+
+// member is object.key or object[key].
+type member struct {
+	object node
+	key    node
+}
+
+func (n *member) eval(vars map[string]interface{}) (interface{}, error) {
+	object, err := n.object.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if object == nil || key == nil {
+		return nil, nil
+	}
+
+	switch o := object.(type) {
+	case map[string]interface{}:
+		k, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("object key must be a string, got %s", typeName(key))
+		}
+		return normalize(o[k]), nil
+	case map[string]string:
+		k, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("object key must be a string, got %s", typeName(key))
+		}
+		if v, ok := o[k]; ok {
+			return v, nil
+		}
+		return nil, nil
+	case []interface{}:
+		i, ok := key.(float64)
+		if !ok || i != math.Trunc(i) {
+			return nil, fmt.Errorf("array index must be an integer, got %s", typeName(key))
+		}
+		if i < 0 || int(i) >= len(o) {
+			return nil, nil
+		}
+		return normalize(o[int(i)]), nil
+	}
+	return nil, fmt.Errorf("cannot access a member of %s", typeName(object))
+}
+
+type unary struct {
+	op      string
+	operand node
+}
+
+func (n *unary) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator '!' needs a boolean, got %s", typeName(v))
+		}
+		return !b, nil
+	default: // "-"
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("operator '-' needs a number, got %s", typeName(v))
+		}
+		return -f, nil
+	}
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n *binary) eval(vars map[string]interface{}) (interface{}, error) {
+	if n.op == "&&" || n.op == "||" {
+		return n.evalLogical(vars)
+	}
+
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==", "!=":
+		eq, err := equal(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return eq == (n.op == "=="), nil
+	}
+
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	switch l := left.(type) {
+	case float64:
+		if r, ok := right.(float64); ok {
+			return arithmetic(n.op, l, r)
+		}
+	case string:
+		if r, ok := right.(string); ok {
+			switch n.op {
+			case "+":
+				return l + r, nil
+			case "<":
+				return l < r, nil
+			case "<=":
+				return l <= r, nil
+			case ">":
+				return l > r, nil
+			case ">=":
+				return l >= r, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("operator '%s' is not defined for %s and %s", n.op, typeName(left), typeName(right))
+}
+
+// evalLogical implements && and || with short-circuiting and null for an undecided result.
+func (n *binary) evalLogical(vars map[string]interface{}) (interface{}, error) {
+	decisive := n.op == "||" // true decides ||, false decides &&
+	left, err := evalBool(n.left, vars, n.op)
+	if err != nil {
+		return nil, err
+	}
+	if left != nil && *left == decisive {
+		return decisive, nil
+	}
+	right, err := evalBool(n.right, vars, n.op)
+	if err != nil {
+		return nil, err
+	}
+	if right != nil && *right == decisive {
+		return decisive, nil
+	}
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	return !decisive, nil
+}
+
+// evalBool evaluates n as an operand of op: a boolean, or nil for null.
+func evalBool(n node, vars map[string]interface{}, op string) (*bool, error) {
+	v, err := n.eval(vars)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("operator '%s' needs booleans, got %s", op, typeName(v))
+	}
+	return &b, nil
+}
+
+func arithmetic(op string, l, r float64) (interface{}, error) {
+	switch op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if op == "/" {
+			return l / r, nil
+		}
+		return math.Mod(l, r), nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	default: // ">="
+		return l >= r, nil
+	}
+}
+
+// equal compares scalars; null equals only null and values of different types are unequal.
+func equal(a, b interface{}) (bool, error) {
+	switch a.(type) {
+	case nil, bool, float64, string:
+	default:
+		return false, fmt.Errorf("cannot compare %s", typeName(a))
+	}
+	switch b.(type) {
+	case nil, bool, float64, string:
+	default:
+		return false, fmt.Errorf("cannot compare %s", typeName(b))
+	}
+	return a == b, nil
+}
+
+type conditional struct {
+	cond, then, otherwise node
+}
+
+func (n *conditional) eval(vars map[string]interface{}) (interface{}, error) {
+	c, err := evalBool(n.cond, vars, "?")
+	if err != nil || c == nil {
+		return nil, err // A null condition can't pick a branch
+	}
+	if *c {
+		return n.then.eval(vars)
+	}
+	return n.otherwise.eval(vars)
+}
+
+type call struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (n *call) eval(vars map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil && !n.fn.nullable {
+			return nil, nil
+		}
+		args[i] = v
+	}
+	v, err := n.fn.call(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return v, nil
+}
+
+// --- Functions ---
+
+type function struct {
+	minArgs, maxArgs int  // maxArgs < 0: variadic
+	nullable         bool // Receives null arguments instead of returning null for them
+	call             func(args []interface{}) (interface{}, error)
+}
+
+func (f function) arity() string {
+	switch {
+	case f.maxArgs < 0 && f.minArgs == 1:
+		return "at least 1 argument"
+	case f.maxArgs < 0:
+		return fmt.Sprintf("at least %d arguments", f.minArgs)
+	case f.minArgs == f.maxArgs && f.minArgs == 1:
+		return "1 argument"
+	case f.minArgs == f.maxArgs:
+		return fmt.Sprintf("%d arguments", f.minArgs)
+	}
+	return fmt.Sprintf("%d to %d arguments", f.minArgs, f.maxArgs)
+}
+
+// functions are the built-ins available to expressions.
+var functions = map[string]function{
+	"abs":   numeric(math.Abs),
+	"ceil":  numeric(math.Ceil),
+	"floor": numeric(math.Floor),
+	"round": {1, 2, false, func(args []interface{}) (interface{}, error) {
+		// round(x) to an integer, round(x, digits) to that many decimal places
+		x, err := number(args[0])
+		if err != nil {
+			return nil, err
+		}
+		digits := 0.0
+		if len(args) == 2 {
+			if digits, err = number(args[1]); err != nil {
+				return nil, err
+			}
+			if digits < 0 || digits > 15 {
+				return nil, fmt.Errorf("digits must be between 0 and 15")
+			}
+		}
+		scale := math.Pow(10, math.Trunc(digits))
+		return math.Round(x*scale) / scale, nil
+	}},
+	"min": {1, -1, false, func(args []interface{}) (interface{}, error) {
+		return fold(args, math.Min)
+	}},
+	"max": {1, -1, false, func(args []interface{}) (interface{}, error) {
+		return fold(args, math.Max)
+	}},
+	"coalesce": {1, -1, true, func(args []interface{}) (interface{}, error) {
+		// The first non-null argument
+		for _, v := range args {
+			if v != nil {
+				return v, nil
+			}
+		}
+		return nil, nil
+	}},
+	"len": {1, 1, false, func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v))), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		case map[string]string:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("needs a string, array or object, got %s", typeName(args[0]))
+	}},
+	"lower": text(strings.ToLower),
+	"upper": text(strings.ToUpper),
+	"contains": {2, 2, false, func(args []interface{}) (interface{}, error) {
+		s, ok1 := args[0].(string)
+		sub, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("needs two strings, got %s and %s", typeName(args[0]), typeName(args[1]))
+		}
+		return strings.Contains(s, sub), nil
+	}},
+}
+
+// numeric wraps a one-argument math function.
+func numeric(f func(float64) float64) function {
+	return function{1, 1, false, func(args []interface{}) (interface{}, error) {
+		x, err := number(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return f(x), nil
+	}}
+}
+
+// text wraps a one-argument string function.
+func text(f func(string) string) function {
+	return function{1, 1, false, func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("needs a string, got %s", typeName(args[0]))
+		}
+		return f(s), nil
+	}}
+}
+
+func fold(args []interface{}, f func(a, b float64) float64) (interface{}, error) {
+	acc, err := number(args[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range args[1:] {
+		x, err := number(arg)
+		if err != nil {
+			return nil, err
+		}
+		acc = f(acc, x)
+	}
+	return acc, nil
+}
+
+func number(v interface{}) (float64, error) {
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("needs a number, got %s", typeName(v))
+	}
+	return f, nil
+}
+
+// normalize converts Go numeric types a caller may pass (or that a decoder produced) to float64,
+// the only number type the evaluator works with.
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	}
+	return v
+}
+
+// typeName names a value's type for error messages.
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}, map[string]string:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,423 @@
+// pkg/expr/expr.go
+
+// Package expr implements the small expression language used by model derived properties, e.g.
+//
+//	reportedProperties.temperature > desiredProperties.threshold ? "hot" : "ok"
+//
+// Expressions are sandboxed: there are no assignments, loops or user-defined functions, and they
+// can only read the variables passed to Eval, so evaluation is bounded by the expression's size.
+//
+// Supported syntax, from lowest to highest precedence:
+//
+//	c ? a : b            conditional (c must be a boolean)
+//	||  &&               logical or / and (short-circuit)
+//	==  !=               equality; values of different types are never equal
+//	<  <=  >  >=         numbers or strings
+//	+  -                 numbers; + also concatenates strings
+//	*  /  %              numbers; division by zero is an error
+//	!x  -x               logical not, negation
+//	x.key  x["key"]  x[0]  member access on objects, index into arrays
+//
+// Literals are numbers (1, 2.5, 1e3), strings ("a" or 'a'), true, false and null. Functions are
+// listed in functions (abs, ceil, floor, round, min, max, coalesce, len, lower, upper, contains).
+//
+// Missing inputs evaluate to null rather than failing: a member that doesn't exist is null and
+// null propagates through operators and functions (null > 3 is null, not false), so an expression
+// over a property that hasn't been reported yet is simply null. Use coalesce to supply a default
+// and == null to test for absence. && and || only yield null when the result is undecided
+// (false && null is false).
+package expr
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Limits keeping compilation and evaluation cheap.
+const (
+	MaxLength = 1024 // Longest expression source accepted, in bytes
+	maxDepth  = 32   // Deepest nesting of sub-expressions
+)
+
+// Program is a compiled expression, safe for concurrent use.
+type Program struct {
+	source string
+	root   node
+	vars   []string
+}
+
+// Compile parses an expression and checks its function calls.
+func Compile(source string) (*Program, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, fmt.Errorf("expression is empty")
+	}
+	if len(source) > MaxLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", MaxLength)
+	}
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, vars: make(map[string]struct{})}
+	root, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+	}
+
+	vars := make([]string, 0, len(p.vars))
+	for name := range p.vars {
+		vars = append(vars, name)
+	}
+	sort.Strings(vars)
+	return &Program{source: source, root: root, vars: vars}, nil
+}
+
+// String returns the expression source.
+func (p *Program) String() string {
+	return p.source
+}
+
+// Variables returns the top-level variable names the expression reads, sorted, so callers can
+// reject names they will never provide.
+func (p *Program) Variables() []string {
+	return append([]string(nil), p.vars...)
+}
+
+// --- Lexer ---
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOperator // Operators and punctuation; the text is in token.text
+)
+
+type token struct {
+	kind tokenKind
+	text string // Source text (identifiers, operators) or decoded value (strings)
+	num  float64
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return "'" + t.text + "'"
+}
+
+// operators lists two-character operators before their one-character prefixes.
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "!", "<", ">", "?", ":", "(", ")", "[", "]", ".", ","}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isDigit(c):
+			j := i
+			for j < len(src) && (isDigit(src[j]) || src[j] == '.') {
+				j++
+			}
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				j++
+				if j < len(src) && (src[j] == '+' || src[j] == '-') {
+					j++
+				}
+				for j < len(src) && isDigit(src[j]) {
+					j++
+				}
+			}
+			num, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number '%s' at offset %d", src[i:j], i)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[i:j], num: num, pos: i})
+			i = j
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(src) && (isIdentStart(src[j]) || isDigit(src[j])) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[i:j], pos: i})
+			i = j
+		case c == '"' || c == '\'':
+			s, n, err := lexString(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%v at offset %d", err, i)
+			}
+			tokens = append(tokens, token{kind: tokString, text: s, pos: i})
+			i += n
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{kind: tokOperator, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// lexString decodes a quoted string at the start of src and returns it with its source length.
+// Both quote styles support the escapes \\, \", \', \n and \t.
+func lexString(src string) (string, int, error) {
+	quote := src[0]
+	var b strings.Builder
+	for i := 1; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case '\\', '"', '\'':
+				b.WriteByte(src[i])
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				return "", 0, fmt.Errorf("unknown escape '\\%c' in string", src[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isDigit(c byte) bool      { return c >= '0' && c <= '9' }
+func isIdentStart(c byte) bool { return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+
+// --- Parser ---
+
+// parser is a recursive-descent parser; each parse* method handles one precedence level.
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+	vars   map[string]struct{}
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is one of the operators ops.
+func (p *parser) accept(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokOperator {
+		return "", false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		tok := p.peek()
+		return fmt.Errorf("expected '%s' but found %s at offset %d", op, tok, tok.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseExpression() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, fmt.Errorf("expression is nested more than %d levels deep", maxDepth)
+	}
+	return p.parseConditional()
+}
+
+func (p *parser) parseConditional() (node, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return cond, nil
+	}
+	then, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	return &conditional{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+// binaryLevels are the left-associative binary operators, lowest precedence first.
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(binaryLevels[level]...)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	op, ok := p.accept("!", "-")
+	if !ok {
+		return p.parsePostfix()
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, fmt.Errorf("expression is nested more than %d levels deep", maxDepth)
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &unary{op: op, operand: operand}, nil
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("."); ok {
+			tok := p.next()
+			if tok.kind != tokIdent {
+				return nil, fmt.Errorf("expected a member name after '.' but found %s at offset %d", tok, tok.pos)
+			}
+			n = &member{object: n, key: &literal{value: tok.text}}
+			continue
+		}
+		if _, ok := p.accept("["); ok {
+			key, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &member{object: n, key: key}
+			continue
+		}
+		return n, nil
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		return &literal{value: tok.num}, nil
+	case tokString:
+		return &literal{value: tok.text}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		case "null":
+			return &literal{value: nil}, nil
+		}
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(tok)
+		}
+		p.vars[tok.text] = struct{}{}
+		return &variable{name: tok.text}, nil
+	case tokOperator:
+		if tok.text == "(" {
+			n, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+}
+
+// parseCall parses a call's arguments (the opening parenthesis is already consumed).
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function '%s' at offset %d", name.text, name.pos)
+	}
+	var args []node
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("function '%s' at offset %d takes %s", name.text, name.pos, fn.arity())
+	}
+	return &call{name: name.text, fn: fn, args: args}, nil
+}
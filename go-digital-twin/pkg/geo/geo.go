@@ -0,0 +1,93 @@
+// pkg/geo/geo.go
+
+// Package geo has the spherical geometry behind nearest-twin queries: great-circle distances
+// (Haversine) and the bounding boxes used to prefilter candidates in the store.
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// EarthRadius is the mean Earth radius in meters.
+const EarthRadius = 6371008.8
+
+// MaxRadius is the largest search radius that is meaningful: half the Earth's circumference.
+const MaxRadius = math.Pi * EarthRadius
+
+// Box is a latitude/longitude range in degrees. MinLng > MaxLng means the box crosses the
+// antimeridian (it covers MinLng..180 and -180..MaxLng).
+type Box struct {
+	MinLat, MaxLat float64
+	MinLng, MaxLng float64
+}
+
+// Contains reports whether p is inside the box.
+func (b Box) Contains(p model.GeoPoint) bool {
+	if p.Lat < b.MinLat || p.Lat > b.MaxLat {
+		return false
+	}
+	if b.MinLng <= b.MaxLng {
+		return p.Lng >= b.MinLng && p.Lng <= b.MaxLng
+	}
+	return p.Lng >= b.MinLng || p.Lng <= b.MaxLng
+}
+
+// Distance returns the great-circle distance between a and b in meters (Haversine formula).
+func Distance(a, b model.GeoPoint) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat, dLng := lat2-lat1, radians(b.Lng-a.Lng)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// BoundingBox returns the smallest box containing every point within radius meters of center.
+// Near the poles (or for huge radii) it spans all longitudes.
+func BoundingBox(center model.GeoPoint, radius float64) Box {
+	angular := radius / EarthRadius // Radians
+	box := Box{
+		MinLat: center.Lat - degrees(angular),
+		MaxLat: center.Lat + degrees(angular),
+		MinLng: -180,
+		MaxLng: 180,
+	}
+	if box.MinLat <= -90 || box.MaxLat >= 90 {
+		box.MinLat, box.MaxLat = math.Max(box.MinLat, -90), math.Min(box.MaxLat, 90)
+		return box // The circle contains a pole
+	}
+
+	dLng := degrees(math.Asin(math.Sin(angular) / math.Cos(radians(center.Lat))))
+	box.MinLng, box.MaxLng = center.Lng-dLng, center.Lng+dLng
+	if box.MinLng < -180 {
+		box.MinLng += 360
+	}
+	if box.MaxLng > 180 {
+		box.MaxLng -= 360
+	}
+	return box
+}
+
+// ParseDistance parses a distance such as "500m", "5km" or "2.5km" into meters. A bare number
+// is meters.
+func ParseDistance(v string) (float64, error) {
+	s := strings.TrimSpace(strings.ToLower(v))
+	unit := 1.0
+	switch {
+	case strings.HasSuffix(s, "km"):
+		s, unit = strings.TrimSuffix(s, "km"), 1000
+	case strings.HasSuffix(s, "m"):
+		s = strings.TrimSuffix(s, "m")
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid distance %q (e.g., 500m, 5km)", v)
+	}
+	return n * unit, nil
+}
+
+func radians(deg float64) float64 { return deg * math.Pi / 180 }
+func degrees(rad float64) float64 { return rad * 180 / math.Pi }
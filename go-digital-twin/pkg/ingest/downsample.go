@@ -0,0 +1,265 @@
+// pkg/ingest/downsample.go
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/scheduler"
+)
+
+// ErrDownsamplerClosed is returned by Add after Shutdown has started.
+var ErrDownsamplerClosed = errors.New("downsampling buffer is shut down")
+
+// Downsampling metrics
+var (
+	bufferedBuckets    = metrics.NewGauge("ingest_downsample_buckets", "Downsampling buckets buffered in memory.")
+	downsampledTotal   = metrics.NewCounter("ingest_downsampled_points_total", "Live telemetry points buffered for downsampling instead of being stored.")
+	downsampleWritten  = metrics.NewCounter("ingest_downsample_written_total", "Downsampled telemetry points written.")
+	downsampleSkipped  = metrics.NewCounter("ingest_downsample_skipped_total", "Downsampled points not written because a point not downsampled was already stored at their bucket's start.")
+	downsampleFailures = metrics.NewCounter("ingest_downsample_failures_total", "Downsampling flushes that failed (their buckets are retried on the next flush).")
+)
+
+// bucketKey identifies a downsampling bucket: one telemetry name of one twin over one interval.
+type bucketKey struct {
+	twinID string
+	name   string
+	start  int64 // Unix microseconds
+}
+
+// bucket accumulates the points of one bucketKey.
+type bucket struct {
+	interval    time.Duration
+	aggregation string
+	count       int
+	sum         float64
+	min         float64
+	max         float64
+	quality     persistence.Quality // The worst seen
+	writtenBy   string              // Empty when points came from more than one writer
+}
+
+// add accumulates one point.
+func (b *bucket) add(value float64, quality persistence.Quality, writtenBy string) {
+	if b.count == 0 {
+		b.min, b.max, b.writtenBy = value, value, writtenBy
+	} else if b.writtenBy != writtenBy {
+		b.writtenBy = ""
+	}
+	b.count++
+	b.sum += value
+	if value < b.min {
+		b.min = value
+	}
+	if value > b.max {
+		b.max = value
+	}
+	if quality > b.quality {
+		b.quality = quality
+	}
+}
+
+// merge folds o, a bucket of the same key, into b.
+func (b *bucket) merge(o *bucket) {
+	if b.writtenBy != o.writtenBy {
+		b.writtenBy = ""
+	}
+	b.count += o.count
+	b.sum += o.sum
+	if o.min < b.min {
+		b.min = o.min
+	}
+	if o.max > b.max {
+		b.max = o.max
+	}
+	if o.quality > b.quality {
+		b.quality = o.quality
+	}
+}
+
+// downsampled returns the bucket of key as the store merges it.
+func (b *bucket) downsampled(key bucketKey) *persistence.DownsampledBucket {
+	return &persistence.DownsampledBucket{
+		Name:        key.name,
+		Start:       time.UnixMicro(key.start).UTC(),
+		Aggregation: b.aggregation,
+		Count:       b.count,
+		Sum:         b.sum,
+		Min:         b.min,
+		Max:         b.max,
+		Quality:     b.quality,
+		WrittenBy:   b.writtenBy,
+	}
+}
+
+// Downsampler buffers the live points of downsampled telemetry names (see
+// model.TelemetryDefinition.Downsample) in per-twin, per-name buckets and writes one aggregated
+// point per bucket once it is over, so the raw points are never stored. Like the async pool, it
+// trades durability for write volume: buffered points are only in memory until flushed, and
+// Shutdown flushes them all. Buckets are merged into the stored point (see
+// persistence.TimeSeriesStore.MergeDownsampledTelemetry), so points that arrive after their bucket
+// was flushed, at another replica or after a restart, still count towards it.
+type Downsampler struct {
+	store persistence.TimeSeriesStore
+	grace time.Duration // How long after its end a bucket still takes late points
+	now   func() time.Time
+
+	mu      sync.Mutex // Guards buckets and closed
+	buckets map[bucketKey]*bucket
+	closed  bool
+}
+
+// NewDownsampler creates a downsampler writing to store; a bucket is written once grace has
+// passed since its end, so points that arrive a little late still count towards it.
+func NewDownsampler(store persistence.TimeSeriesStore, grace time.Duration) *Downsampler {
+	return &Downsampler{
+		store:   store,
+		grace:   grace,
+		now:     time.Now,
+		buckets: make(map[bucketKey]*bucket),
+	}
+}
+
+// Add buffers a numValue point into the bucket of d its timestamp falls in. Returns
+// ErrDownsamplerClosed after Shutdown has started.
+func (ds *Downsampler) Add(twinID string, rec *persistence.TelemetryRecord, d model.TelemetryDownsampling) error {
+	if rec.NumericValue == nil {
+		return fmt.Errorf("telemetry '%s' has no numValue to downsample", rec.Name)
+	}
+	key := bucketKey{twinID: twinID, name: rec.Name, start: rec.Timestamp.Truncate(d.Interval).UnixMicro()}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.closed {
+		return ErrDownsamplerClosed
+	}
+	b, ok := ds.buckets[key]
+	if !ok {
+		b = &bucket{interval: d.Interval, aggregation: d.Aggregation}
+		ds.buckets[key] = b
+		bufferedBuckets.Inc()
+	}
+	b.add(*rec.NumericValue, rec.Quality, rec.WrittenBy)
+	downsampledTotal.Inc()
+	return nil
+}
+
+// Buffered returns the number of buckets not yet written.
+func (ds *Downsampler) Buffered() int {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return len(ds.buckets)
+}
+
+// Flush writes the buckets whose grace has passed, or every bucket with all. Returns how many
+// points were written; buckets that fail to write are kept for the next flush.
+func (ds *Downsampler) Flush(ctx context.Context, all bool) (int, error) {
+	now := ds.now()
+	due := make(map[bucketKey]*bucket)
+	ds.mu.Lock()
+	for key, b := range ds.buckets {
+		if all || !time.UnixMicro(key.start).Add(b.interval+ds.grace).After(now) {
+			due[key] = b
+			delete(ds.buckets, key)
+		}
+	}
+	ds.mu.Unlock()
+	if len(due) == 0 {
+		return 0, nil
+	}
+
+	byTwin := make(map[string][]bucketKey)
+	for key := range due {
+		byTwin[key.twinID] = append(byTwin[key.twinID], key)
+	}
+	twinIDs := make([]string, 0, len(byTwin))
+	for twinID := range byTwin {
+		twinIDs = append(twinIDs, twinID)
+	}
+	sort.Strings(twinIDs)
+
+	written := 0
+	for i, twinID := range twinIDs {
+		keys := byTwin[twinID]
+		buckets := make([]*persistence.DownsampledBucket, 0, len(keys))
+		for _, key := range keys {
+			buckets = append(buckets, due[key].downsampled(key))
+		}
+		stored, err := ds.store.MergeDownsampledTelemetry(ctx, twinID, buckets)
+		if err != nil {
+			// Put back what wasn't written; points added since are merged in
+			ds.requeue(due, twinIDs[i:], byTwin)
+			downsampleFailures.Inc()
+			return written, fmt.Errorf("failed to write %d downsampled points for twin '%s': %w", len(buckets), twinID, err)
+		}
+		bufferedBuckets.Add(-float64(len(keys)))
+		written += len(stored)
+		downsampleWritten.Add(float64(len(stored)))
+		downsampleSkipped.Add(float64(len(buckets) - len(stored)))
+	}
+	return written, nil
+}
+
+// requeue puts the due buckets of twinIDs back into the buffer.
+func (ds *Downsampler) requeue(due map[bucketKey]*bucket, twinIDs []string, byTwin map[string][]bucketKey) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for _, twinID := range twinIDs {
+		for _, key := range byTwin[twinID] {
+			b := due[key]
+			if current, ok := ds.buckets[key]; ok {
+				b.merge(current)
+				bufferedBuckets.Dec() // Counted once for both
+			}
+			ds.buckets[key] = b
+		}
+	}
+}
+
+// Shutdown stops accepting new points and writes every buffered bucket, over or not; points of
+// an unfinished bucket that arrive later (after a restart) are merged into it. Returns an error if they can't all be written before ctx expires (the rest are lost).
+func (ds *Downsampler) Shutdown(ctx context.Context) error {
+	ds.mu.Lock()
+	ds.closed = true
+	ds.mu.Unlock()
+
+	for {
+		written, err := ds.Flush(ctx, true)
+		if err == nil {
+			log.Printf("INFO: Downsampling buffer flushed (%d points written).", written)
+			return nil
+		}
+		log.Printf("WARN: Failed to flush downsampled telemetry, retrying: %v", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("downsampling buffer not flushed (%d buckets unwritten): %w", ds.Buffered(), ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// RegisterDownsampler schedules the job "telemetry_downsample" on s, which writes the buckets whose
+// grace has passed every interval (non-positive disables it, leaving everything for Shutdown).
+func RegisterDownsampler(s *scheduler.Scheduler, ds *Downsampler, interval time.Duration) {
+	s.Register("telemetry_downsample", interval, func(ctx context.Context) error {
+		written, err := ds.Flush(ctx, false)
+		if err != nil {
+			return fmt.Errorf("wrote %d downsampled points before failing: %w", written, err)
+		}
+		if written > 0 {
+			log.Printf("DEBUG: Wrote %d downsampled telemetry points", written)
+		}
+		return nil
+	})
+	if interval > 0 {
+		log.Printf("INFO: Writing downsampled telemetry every %s (buckets are written %s after they end)", interval, ds.grace)
+	}
+}
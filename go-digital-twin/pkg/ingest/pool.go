@@ -0,0 +1,157 @@
+// pkg/ingest/pool.go
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// ErrQueueFull is returned by Submit when the bounded queue has no room (apply backpressure, e.g. 429).
+var ErrQueueFull = errors.New("ingestion queue is full")
+
+// ErrPoolClosed is returned by Submit after Shutdown has started.
+var ErrPoolClosed = errors.New("ingestion pool is shut down")
+
+// Async ingestion metrics
+var (
+	queueDepth    = metrics.NewGauge("ingest_queue_depth", "Telemetry records waiting in the async ingestion queue.")
+	rejectedTotal = metrics.NewCounter("ingest_rejected_total", "Telemetry records rejected because the async queue was full.")
+	writtenTotal  = metrics.NewCounter("ingest_written_total", "Telemetry records written by async ingestion workers.")
+	failedTotal   = metrics.NewCounter("ingest_failed_total", "Telemetry records the async ingestion workers failed to write.")
+)
+
+// Job is a single unit of asynchronous ingestion work.
+type Job struct {
+	TwinID string
+	Record *persistence.TelemetryRecord
+	Raw    *persistence.TelemetryRecord // Optional untransformed copy of Record, written with it
+}
+
+// Pool is a bounded in-memory worker pool that writes telemetry to the store asynchronously.
+// It trades synchronous durability confirmation for lower client latency: once Submit returns nil
+// the record is queued, not yet persisted. Shutdown drains everything already queued.
+type Pool struct {
+	store        persistence.TimeSeriesStore
+	jobs         chan Job
+	writeTimeout time.Duration
+
+	mu     sync.RWMutex // Guards closed; Submit holds RLock so Shutdown can't close jobs mid-send
+	closed bool
+	wg     sync.WaitGroup
+
+	outstanding atomic.Int64 // Accepted jobs not yet finished (queued or being written)
+}
+
+// NewPool starts `workers` goroutines consuming a queue of capacity `queueSize`.
+// Each write gets its own writeTimeout since the originating request context is long gone.
+func NewPool(store persistence.TimeSeriesStore, workers, queueSize int, writeTimeout time.Duration) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	p := &Pool{
+		store:        store,
+		jobs:         make(chan Job, queueSize),
+		writeTimeout: writeTimeout,
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+	log.Printf("INFO: Async ingestion pool started: workers=%d, queueSize=%d", workers, queueSize)
+	return p
+}
+
+// Submit enqueues a job without blocking. Returns ErrQueueFull when the queue is at capacity.
+func (p *Pool) Submit(job Job) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	select {
+	case p.jobs <- job:
+		p.outstanding.Add(1)
+		queueDepth.Inc()
+		return nil
+	default:
+		rejectedTotal.Inc()
+		return ErrQueueFull
+	}
+}
+
+// Pending returns the number of queued (not yet picked up) jobs.
+func (p *Pool) Pending() int {
+	return len(p.jobs)
+}
+
+// Outstanding returns the number of accepted jobs not yet finished: queued ones plus those
+// a worker is currently writing.
+func (p *Pool) Outstanding() int {
+	return int(p.outstanding.Load())
+}
+
+// worker writes queued jobs until the queue is closed and empty.
+func (p *Pool) worker() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		queueDepth.Dec()
+		ctx, cancel := context.WithTimeout(context.Background(), p.writeTimeout)
+		var err error
+		if job.Raw != nil {
+			err = p.store.WriteTelemetryCopy(ctx, []*persistence.TelemetryRecord{job.Record, job.Raw})
+		} else {
+			err = p.store.WriteTelemetry(ctx, job.TwinID, job.Record)
+		}
+		cancel()
+		p.outstanding.Add(-1)
+		if err != nil {
+			failedTotal.Inc()
+			log.Printf("ERROR: Async telemetry write failed for twin '%s', name '%s': %v", job.TwinID, job.Record.Name, err)
+			continue
+		}
+		writtenTotal.Inc()
+	}
+}
+
+// Shutdown stops accepting new jobs and waits for queued jobs to be written.
+// Returns an error if ctx expires before the queue drains (remaining jobs are lost).
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs) // Workers exit once the remaining jobs are consumed
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("INFO: Async ingestion queue drained.")
+		return nil
+	case <-ctx.Done():
+		select {
+		case <-done: // Drained just as the deadline passed
+			log.Println("INFO: Async ingestion queue drained.")
+			return nil
+		default:
+		}
+		return fmt.Errorf("ingestion queue not drained (%d jobs pending, %d unfinished): %w", p.Pending(), p.Outstanding(), ctx.Err())
+	}
+}
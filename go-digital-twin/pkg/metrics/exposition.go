@@ -0,0 +1,64 @@
+// pkg/metrics/exposition.go
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ContentType is the media type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Label is one name="value" pair of a sample.
+type Label struct {
+	Name, Value string
+}
+
+// SanitizeName turns s into a valid metric or label name: characters outside [a-zA-Z0-9_] become
+// '_' and a leading digit gets a '_' prefix.
+func SanitizeName(s string) string {
+	var b strings.Builder
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// labelValueEscaper escapes label values as the text format requires.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteHeader writes the HELP and TYPE lines of a metric family (kind is "gauge", "counter", ...).
+func WriteHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.ReplaceAll(help, "\n", " "), name, kind)
+}
+
+// WriteLabeledSample writes one sample of a family whose header was written with WriteHeader.
+func WriteLabeledSample(w io.Writer, name string, labels []Label, v float64) {
+	b := strings.Builder{}
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(l.Name)
+			b.WriteString(`="`)
+			b.WriteString(labelValueEscaper.Replace(l.Value))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(w, "%s %s\n", b.String(), formatFloat(v))
+}
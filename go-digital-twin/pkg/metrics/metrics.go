@@ -0,0 +1,198 @@
+// pkg/metrics/metrics.go
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds a set of metrics and renders them in the Prometheus text exposition format.
+// It is intentionally tiny: gauges and counters without labels cover our current needs
+// and avoid pulling in the full Prometheus client library.
+type Registry struct {
+	mu      sync.RWMutex
+	metrics map[string]metric // Keyed by metric name
+}
+
+// metric is implemented by every metric type that can be rendered by the registry.
+type metric interface {
+	write(w io.Writer)
+}
+
+// NewRegistry creates an empty metrics registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Default is the process-wide registry used by the package-level helpers.
+var Default = NewRegistry()
+
+// register adds a metric under the given name. Registering the same name twice is a programming error.
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.metrics[name]; exists {
+		panic(fmt.Sprintf("metrics: duplicate registration of %q", name))
+	}
+	r.metrics[name] = m
+}
+
+// Write renders all metrics, sorted by name, in Prometheus text format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ms := make([]metric, 0, len(names))
+	for _, name := range names {
+		ms = append(ms, r.metrics[name])
+	}
+	r.mu.RUnlock()
+
+	for _, m := range ms {
+		m.write(w)
+	}
+}
+
+// Handler returns an http.Handler serving the registry (mount at /metrics).
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		w.WriteHeader(http.StatusOK)
+		r.Write(w)
+	})
+}
+
+// Handler serves the Default registry.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// --- Gauge ---
+
+// Gauge is a metric whose value can go up and down.
+type Gauge struct {
+	name, help string
+	bits       uint64 // float64 stored as bits for atomic access
+}
+
+// NewGauge creates and registers a gauge on the registry.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	r.register(name, g)
+	return g
+}
+
+// NewGauge creates and registers a gauge on the Default registry.
+func NewGauge(name, help string) *Gauge {
+	return Default.NewGauge(name, help)
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+// Add adds delta (which may be negative) to the gauge.
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&g.bits, old, next) {
+			return
+		}
+	}
+}
+
+// Inc increments the gauge by one.
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec decrements the gauge by one.
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Value returns the current gauge value.
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+func (g *Gauge) write(w io.Writer) {
+	writeSample(w, g.name, g.help, "gauge", g.Value())
+}
+
+// --- Counter ---
+
+// Counter is a monotonically increasing metric.
+type Counter struct {
+	name, help string
+	bits       uint64
+}
+
+// NewCounter creates and registers a counter on the registry.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	r.register(name, c)
+	return c
+}
+
+// NewCounter creates and registers a counter on the Default registry.
+func NewCounter(name, help string) *Counter {
+	return Default.NewCounter(name, help)
+}
+
+// Add increases the counter by delta. Negative deltas are ignored.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		log.Printf("WARN: metrics: ignoring negative delta %f for counter %s", delta, c.name)
+		return
+	}
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&c.bits, old, next) {
+			return
+		}
+	}
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() { c.Add(1) }
+
+// Value returns the current counter value.
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+// mirror sets the counter to a cumulative value maintained elsewhere (e.g., pgxpool's own counters).
+func (c *Counter) mirror(v float64) {
+	atomic.StoreUint64(&c.bits, math.Float64bits(v))
+}
+
+func (c *Counter) write(w io.Writer) {
+	writeSample(w, c.name, c.help, "counter", c.Value())
+}
+
+// writeSample writes a single unlabeled sample with its HELP/TYPE header lines.
+func writeSample(w io.Writer, name, help, kind string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatFloat(v))
+}
+
+// formatFloat renders a value the way Prometheus expects (including +Inf/-Inf/NaN).
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return fmt.Sprintf("%g", v)
+}
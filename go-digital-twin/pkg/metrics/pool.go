@@ -0,0 +1,42 @@
+// pkg/metrics/pool.go
+package metrics
+
+import (
+	"context"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// Connection pool metrics, sampled periodically from persistence.PoolStatsProvider.
+var (
+	poolTotalConns        = NewGauge("db_pool_total_conns", "Open connections in the database pool (idle + acquired + constructing).")
+	poolIdleConns         = NewGauge("db_pool_idle_conns", "Idle connections in the database pool.")
+	poolAcquiredConns     = NewGauge("db_pool_acquired_conns", "Connections currently acquired from the database pool.")
+	poolConstructingConns = NewGauge("db_pool_constructing_conns", "Connections currently being established.")
+	poolMaxConns          = NewGauge("db_pool_max_conns", "Maximum size of the database pool.")
+	poolAcquires          = NewCounter("db_pool_acquires_total", "Cumulative successful connection acquires.")
+	poolAcquireSeconds    = NewCounter("db_pool_acquire_duration_seconds_total", "Cumulative time spent acquiring connections.")
+	poolEmptyAcquires     = NewCounter("db_pool_empty_acquires_total", "Cumulative acquires that waited because the pool was empty.")
+	poolCanceledAcquires  = NewCounter("db_pool_canceled_acquires_total", "Cumulative acquires canceled by their context.")
+)
+
+// RecordPoolStats copies a pool snapshot into the pool metrics.
+func RecordPoolStats(st persistence.PoolStats) {
+	poolTotalConns.Set(float64(st.TotalConns))
+	poolIdleConns.Set(float64(st.IdleConns))
+	poolAcquiredConns.Set(float64(st.AcquiredConns))
+	poolConstructingConns.Set(float64(st.ConstructingConns))
+	poolMaxConns.Set(float64(st.MaxConns))
+	poolAcquires.mirror(float64(st.AcquireCount))
+	poolAcquireSeconds.mirror(st.AcquireDuration.Seconds())
+	poolEmptyAcquires.mirror(float64(st.EmptyAcquireCount))
+	poolCanceledAcquires.mirror(float64(st.CanceledAcquireCount))
+}
+
+// PoolSampler returns a background job (see scheduler.Register) that records provider's pool stats.
+func PoolSampler(provider persistence.PoolStatsProvider) func(ctx context.Context) error {
+	return func(context.Context) error {
+		RecordPoolStats(provider.PoolStats())
+		return nil
+	}
+}
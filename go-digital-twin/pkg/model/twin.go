@@ -1,7 +1,21 @@
 // pkg/model/twin.go
 package model
 
-import "time" // We might need time later
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/expr"
+)
 
 // TwinModel defines the blueprint for a type of digital twin.
 // It specifies the expected properties, telemetry, commands, etc.
@@ -9,10 +23,39 @@ type TwinModel struct {
 	ID          string `json:"id" yaml:"id"`                                       // Unique identifier for the model (e.g., DTMI like "dtmi:com:example:thermostat;1")
 	DisplayName string `json:"displayName,omitempty" yaml:"displayName,omitempty"` // User-friendly name
 	Description string `json:"description,omitempty" yaml:"description,omitempty"` // Optional description
+	Category    string `json:"category,omitempty" yaml:"category,omitempty"`       // Optional grouping for catalogs (e.g., "HVAC", "Lighting")
+
+	// Properties declares the twin properties this model knows about, keyed by property name.
+	// A model without property definitions puts no constraints on twin properties.
+	Properties map[string]PropertyDefinition `json:"properties,omitempty" yaml:"properties,omitempty"`
+
+	// AllowedTelemetryNames, when non-empty, is the complete list of telemetry names twins of this
+	// model may ingest; anything else is rejected. Empty means any name is accepted.
+	AllowedTelemetryNames []string `json:"allowedTelemetryNames,omitempty" yaml:"allowedTelemetryNames,omitempty"`
+
+	// TelemetryNameMappings rewrites telemetry names on ingestion (alias -> canonical name, e.g.
+	// "temp" -> "temperature"), so firmware variants of the same metric are stored under one name.
+	// Matching is exact (case-sensitive); names without a mapping are stored as sent. Empty = no rewriting.
+	TelemetryNameMappings map[string]string `json:"telemetryNameMappings,omitempty" yaml:"telemetryNameMappings,omitempty"`
+
+	// DerivedProperties are computed from a twin's state whenever the twin is read (see
+	// ComputeProperties), keyed by name; they are never stored.
+	DerivedProperties map[string]DerivedProperty `json:"derivedProperties,omitempty" yaml:"derivedProperties,omitempty"`
+
+	// Telemetry describes telemetry names of this model, keyed by (canonical) name, e.g. with a
+	// retention that differs from the server-wide one. Names without a definition still ingest.
+	Telemetry map[string]TelemetryDefinition `json:"telemetry,omitempty" yaml:"telemetry,omitempty"`
+
+	// StrictReportedTypes rejects reported property updates that change a property's type (see
+	// ReportedTypeConflicts), so firmware bugs can't silently drift the schema of the stored state.
+	StrictReportedTypes bool `json:"strictReportedTypes,omitempty" yaml:"strictReportedTypes,omitempty"`
+
+	// TagSchema constrains the tags of this model's twins, keyed by tag key (see TagViolations):
+	// keys can be required and their values limited to a set or a pattern. Keys it doesn't
+	// declare stay free-form; an empty schema puts no constraints on tags.
+	TagSchema map[string]TagDefinition `json:"tagSchema,omitempty" yaml:"tagSchema,omitempty"`
 
 	// --- Placeholders for later ---
-	// Properties map[string]PropertyDefinition `json:"properties,omitempty" yaml:"properties,omitempty"`
-	// Telemetry  map[string]TelemetryDefinition `json:"telemetry,omitempty" yaml:"telemetry,omitempty"`
 	// Commands   map[string]CommandDefinition   `json:"commands,omitempty" yaml:"commands,omitempty"`
 	// Events     map[string]EventDefinition     `json:"events,omitempty" yaml:"events,omitempty"`
 
@@ -20,37 +63,1133 @@ type TwinModel struct {
 	UpdatedAt time.Time `json:"updatedAt" yaml:"updatedAt"` // Timestamp of last model update
 }
 
+// MaxCategoryLength is the longest category name accepted, in characters (matches the VARCHAR(100)
+// twin_models.category column).
+const MaxCategoryLength = 100
+
+// MaxModelIDLength is the longest model ID accepted, in characters (matches twin_models.id).
+const MaxModelIDLength = 255
+
+// ValidateModelID checks the format of a model ID: 1 to MaxModelIDLength characters of valid
+// UTF-8, without control characters or leading/trailing whitespace. DTMIs
+// ("dtmi:com:example:Thermostat;1") and UUIDs both fit.
+func ValidateModelID(id string) error {
+	switch {
+	case strings.TrimSpace(id) == "":
+		return fmt.Errorf("must not be empty or blank")
+	case !utf8.ValidString(id):
+		return fmt.Errorf("must be valid UTF-8")
+	case utf8.RuneCountInString(id) > MaxModelIDLength:
+		return fmt.Errorf("must be at most %d characters", MaxModelIDLength)
+	case strings.TrimSpace(id) != id:
+		return fmt.Errorf("must not start or end with whitespace")
+	case strings.IndexFunc(id, unicode.IsControl) >= 0:
+		return fmt.Errorf("must not contain control characters")
+	}
+	return nil
+}
+
 // TwinInstance represents a specific digital twin based on a TwinModel.
 // It holds the current state and identity of a real-world device/asset.
 type TwinInstance struct {
 	ID      string `json:"id" yaml:"id"`           // Unique instance ID (e.g., UUID)
 	ModelID string `json:"modelId" yaml:"modelId"` // ID of the TwinModel this instance implements
 
+	// DisplayName is a human-readable label for UIs (GET /twins can search and sort by it); it
+	// needn't be unique. Empty means the twin has none and is shown by its ID.
+	DisplayName string `json:"displayName,omitempty" yaml:"displayName,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"` // Optional free text
+
+	// A nil property map was never set (e.g. nothing reported yet), while an empty one was set to
+	// {}; stores keep the difference. Tags are always set (nil is stored as {}). The default JSON
+	// omits empty and unset maps alike; the API's unset-maps preference renders them apart.
 	ReportedProperties map[string]interface{} `json:"reportedProperties,omitempty"` // Last known state reported by the device
 	DesiredProperties  map[string]interface{} `json:"desiredProperties,omitempty"`  // Target state set by applications
 	Tags               map[string]string      `json:"tags,omitempty"`               // Metadata tags for querying/grouping
 
+	// Tags are flat strings because they are what twins are filtered, grouped and authorized by
+	// (API key selectors, ListTwinsByTags). Metadata holds arbitrary typed JSON (numbers, booleans,
+	// nested objects) that is stored and returned as is but never used for filtering.
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+
+	// Location is where the twin is, if known; it is what GET /twins/near searches by.
+	Location *GeoPoint `json:"location,omitempty"`
+
 	CreatedAt time.Time `json:"createdAt"` // Timestamp of instance creation
 	UpdatedAt time.Time `json:"updatedAt"` // Timestamp of last instance update (state change, etc.)
 }
 
-// --- Placeholder definitions for Properties, Telemetry, etc. ---
-// We'll flesh these out in later steps when we implement model validation and state management.
-/*
+// GeoPoint is a WGS 84 position in decimal degrees.
+type GeoPoint struct {
+	Lat float64 `json:"lat" yaml:"lat"` // -90 to 90
+	Lng float64 `json:"lng" yaml:"lng"` // -180 to 180
+}
+
+// Validate checks that the coordinates are finite and within range.
+func (p GeoPoint) Validate() error {
+	if math.IsNaN(p.Lat) || p.Lat < -90 || p.Lat > 90 {
+		return fmt.Errorf("lat must be between -90 and 90 (got %v)", p.Lat)
+	}
+	if math.IsNaN(p.Lng) || p.Lng < -180 || p.Lng > 180 {
+		return fmt.Errorf("lng must be between -180 and 180 (got %v)", p.Lng)
+	}
+	return nil
+}
+
+// HasTags reports whether the twin carries every key/value pair in selector.
+// An empty selector matches every twin.
+func (t *TwinInstance) HasTags(selector map[string]string) bool {
+	return TagsContain(t.Tags, selector)
+}
+
+// TagsContain reports whether tags includes every key/value pair in selector (JSONB @> semantics).
+func TagsContain(tags, selector map[string]string) bool {
+	for k, v := range selector {
+		if got, ok := tags[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// TwinTemplate is a reusable provisioning bundle: twins instantiated from it start with
+// its model reference, desired properties and tags (each overridable per request).
+type TwinTemplate struct {
+	ID          string `json:"id" yaml:"id"`                                       // Unique template ID
+	DisplayName string `json:"displayName" yaml:"displayName"`                     // User-friendly name
+	Description string `json:"description,omitempty" yaml:"description,omitempty"` // Optional description
+	ModelID     string `json:"modelId" yaml:"modelId"`                             // Model new twins will implement
+
+	DesiredProperties map[string]interface{} `json:"desiredProperties,omitempty" yaml:"desiredProperties,omitempty"` // Default desired state
+	Tags              map[string]string      `json:"tags,omitempty" yaml:"tags,omitempty"`                           // Default tags
+
+	CreatedAt time.Time `json:"createdAt" yaml:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" yaml:"updatedAt"`
+}
+
+// --- Property definitions ---
+
+// Property schema types accepted in PropertyDefinition.Schema.
+const (
+	SchemaString  = "string"
+	SchemaDouble  = "double"
+	SchemaInteger = "integer"
+	SchemaBoolean = "boolean"
+	SchemaObject  = "object"
+)
+
+// PropertyDefinition describes one property of a TwinModel.
 type PropertyDefinition struct {
-    Name        string      `json:"name" yaml:"name"`
-    Schema      string      `json:"schema" yaml:"schema"` // e.g., "string", "double", "boolean", "object"
-    Writable    bool        `json:"writable" yaml:"writable"`
-    Unit        string      `json:"unit,omitempty" yaml:"unit,omitempty"`
-    Description string      `json:"description,omitempty" yaml:"description,omitempty"`
+	Name        string `json:"name" yaml:"name"`         // Same as the map key; filled in from the key when omitted
+	Schema      string `json:"schema" yaml:"schema"`     // e.g., "string", "double", "boolean", "object"
+	Writable    bool   `json:"writable" yaml:"writable"` // Whether applications may set it via desired properties
+	Unit        string `json:"unit,omitempty" yaml:"unit,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+
+	// Enum, when non-empty, is the complete set of values the property may take (e.g. "heating",
+	// "cooling", "off"), in display order. Only string, integer and double properties can have one.
+	Enum []interface{} `json:"enum,omitempty" yaml:"enum,omitempty"`
+
+	// Default, when set, is the property's value until a device reports one (see
+	// EffectiveProperties). It must fit the schema and the enum.
+	Default interface{} `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// ValidateProperties checks the model's property definitions and fills in missing names from the
+// map keys. Enum values must fit the schema and be unique; numbers are normalized to float64
+// (YAML decodes integers as int), so they compare equal to JSON-decoded values.
+func (m *TwinModel) ValidateProperties() error {
+	for key, def := range m.Properties {
+		if key == "" {
+			return fmt.Errorf("property names must not be empty")
+		}
+		if def.Name == "" {
+			def.Name = key
+		} else if def.Name != key {
+			return fmt.Errorf("property '%s' has mismatched name '%s'", key, def.Name)
+		}
+		switch def.Schema {
+		case SchemaString, SchemaDouble, SchemaInteger, SchemaBoolean, SchemaObject:
+		default:
+			return fmt.Errorf("property '%s' has unsupported schema '%s' (expected string, double, integer, boolean or object)", key, def.Schema)
+		}
+		if err := def.normalizeEnum(); err != nil {
+			return fmt.Errorf("property '%s': %v", key, err)
+		}
+		if err := def.normalizeDefault(); err != nil {
+			return fmt.Errorf("property '%s': %v", key, err)
+		}
+		m.Properties[key] = def
+	}
+	return nil
+}
+
+// MaxTelemetryNameLength is the longest telemetry name accepted in AllowedTelemetryNames
+// (matches the telemetry.name column).
+const MaxTelemetryNameLength = 255
+
+// ValidateTelemetryNames checks AllowedTelemetryNames: names must be non-empty, at most
+// MaxTelemetryNameLength characters and unique.
+func (m *TwinModel) ValidateTelemetryNames() error {
+	seen := make(map[string]struct{}, len(m.AllowedTelemetryNames))
+	for _, name := range m.AllowedTelemetryNames {
+		if name == "" {
+			return fmt.Errorf("telemetry names must not be empty")
+		}
+		if len(name) > MaxTelemetryNameLength {
+			return fmt.Errorf("telemetry name '%s' is longer than %d characters", name, MaxTelemetryNameLength)
+		}
+		if _, dup := seen[name]; dup {
+			return fmt.Errorf("telemetry name '%s' is listed more than once", name)
+		}
+		seen[name] = struct{}{}
+	}
+	return nil
+}
+
+// ValidateTelemetryNameMappings checks TelemetryNameMappings: aliases and canonical names follow the
+// same rules as telemetry names, a name can't map to itself, and mappings don't chain (a canonical
+// name can't itself be an alias), so one lookup always yields the stored name. With an allowlist,
+// canonical names must be allowed; aliases need not be, since they are rewritten before the check.
+func (m *TwinModel) ValidateTelemetryNameMappings() error {
+	aliases := make([]string, 0, len(m.TelemetryNameMappings))
+	for alias := range m.TelemetryNameMappings {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases) // Deterministic error messages
+
+	allowed := make(map[string]struct{}, len(m.AllowedTelemetryNames))
+	for _, name := range m.AllowedTelemetryNames {
+		allowed[name] = struct{}{}
+	}
+	for _, alias := range aliases {
+		canonical := m.TelemetryNameMappings[alias]
+		for _, name := range []string{alias, canonical} {
+			if name == "" {
+				return fmt.Errorf("telemetry names must not be empty")
+			}
+			if len(name) > MaxTelemetryNameLength {
+				return fmt.Errorf("telemetry name '%s' is longer than %d characters", name, MaxTelemetryNameLength)
+			}
+		}
+		if alias == canonical {
+			return fmt.Errorf("telemetry name '%s' is mapped to itself", alias)
+		}
+		if _, chained := m.TelemetryNameMappings[canonical]; chained {
+			return fmt.Errorf("'%s' maps to '%s', which is itself mapped; map aliases directly to the canonical name", alias, canonical)
+		}
+		if _, ok := allowed[canonical]; len(allowed) > 0 && !ok {
+			return fmt.Errorf("'%s' maps to '%s', which is not in allowedTelemetryNames", alias, canonical)
+		}
+	}
+	return nil
+}
+
+// --- Derived properties ---
+
+// DerivedProperty is a read-only property computed from the twin's other state.
+type DerivedProperty struct {
+	// Expression in the pkg/expr language, e.g.
+	// reportedProperties.temperature > desiredProperties.threshold ? "hot" : "ok".
+	// It may read reportedProperties, desiredProperties, tags and metadata.
+	Expression  string `json:"expression" yaml:"expression"`
+	Unit        string `json:"unit,omitempty" yaml:"unit,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// derivedPropertyVariables are the twin sections a derived property expression can read.
+var derivedPropertyVariables = map[string]struct{}{
+	"reportedProperties": {},
+	"desiredProperties":  {},
+	"tags":               {},
+	"metadata":           {},
+}
+
+// ValidateDerivedProperties checks that every derived property has a name and an expression that
+// compiles and only reads the variables available at evaluation time.
+func (m *TwinModel) ValidateDerivedProperties() error {
+	names := make([]string, 0, len(m.DerivedProperties))
+	for name := range m.DerivedProperties {
+		names = append(names, name)
+	}
+	sort.Strings(names) // Deterministic error messages
+
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("derived property names must not be empty")
+		}
+		program, err := expr.Compile(m.DerivedProperties[name].Expression)
+		if err != nil {
+			return fmt.Errorf("derived property '%s': %v", name, err)
+		}
+		for _, v := range program.Variables() {
+			if _, ok := derivedPropertyVariables[v]; !ok {
+				return fmt.Errorf("derived property '%s' reads unknown variable '%s' (expected reportedProperties, desiredProperties, tags or metadata)", name, v)
+			}
+		}
+	}
+	return nil
+}
+
+// ComputeProperties evaluates the model's derived properties against a twin. Every derived
+// property gets an entry in values; it is null when its inputs are missing or evaluation failed,
+// and failures are also returned in errs (nil when there are none). Returns nil, nil for models
+// without derived properties.
+func (m *TwinModel) ComputeProperties(t *TwinInstance) (values map[string]interface{}, errs map[string]error) {
+	if len(m.DerivedProperties) == 0 {
+		return nil, nil
+	}
+	vars := map[string]interface{}{
+		"reportedProperties": t.ReportedProperties,
+		"desiredProperties":  t.DesiredProperties,
+		"tags":               t.Tags,
+		"metadata":           t.Metadata,
+	}
+
+	values = make(map[string]interface{}, len(m.DerivedProperties))
+	for name, def := range m.DerivedProperties {
+		program, err := expr.Compile(def.Expression)
+		if err == nil {
+			values[name], err = program.Eval(vars)
+		}
+		if err != nil {
+			values[name] = nil
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[name] = err
+		}
+	}
+	return values, errs
+}
+
+// ReadOnlyProperties returns the keys of props that the model defines as non-writable, sorted.
+// Keys the model doesn't define at all are not reported here.
+func (m *TwinModel) ReadOnlyProperties(props map[string]interface{}) []string {
+	readOnly := []string{}
+	for key := range props {
+		if def, ok := m.Properties[key]; ok && !def.Writable {
+			readOnly = append(readOnly, key)
+		}
+	}
+	sort.Strings(readOnly)
+	return readOnly
+}
+
+// Accepts reports whether v (as decoded from JSON) fits the definition's schema.
+// null is accepted for every schema: it means "no value" rather than a wrong type.
+func (d PropertyDefinition) Accepts(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch d.Schema {
+	case SchemaString:
+		_, ok := v.(string)
+		return ok
+	case SchemaDouble:
+		_, ok := v.(float64)
+		return ok
+	case SchemaInteger:
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f) && !math.IsInf(f, 0)
+	case SchemaBoolean:
+		_, ok := v.(bool)
+		return ok
+	case SchemaObject:
+		_, ok := v.(map[string]interface{})
+		return ok
+	}
+	return false
+}
+
+// normalizeEnum validates Enum against the schema and converts numbers to float64.
+func (d *PropertyDefinition) normalizeEnum() error {
+	if len(d.Enum) == 0 {
+		d.Enum = nil
+		return nil
+	}
+	switch d.Schema {
+	case SchemaString, SchemaDouble, SchemaInteger:
+	default:
+		return fmt.Errorf("enum is not supported for schema '%s' (only string, integer and double)", d.Schema)
+	}
+	for i, v := range d.Enum {
+		switch n := v.(type) {
+		case int:
+			d.Enum[i] = float64(n)
+		case int64:
+			d.Enum[i] = float64(n)
+		case uint64:
+			d.Enum[i] = float64(n)
+		}
+		if d.Enum[i] == nil || !d.Accepts(d.Enum[i]) {
+			return fmt.Errorf("enum value %v does not fit schema '%s'", v, d.Schema)
+		}
+		for _, prev := range d.Enum[:i] {
+			if prev == d.Enum[i] {
+				return fmt.Errorf("enum value %v is listed more than once", v)
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeDefault checks Default against the schema and enum and converts it to its JSON-decoded
+// form (YAML decodes integers as int, objects with int members), so it compares equal to
+// reported values.
+func (d *PropertyDefinition) normalizeDefault() error {
+	if d.Default == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(d.Default)
+	if err != nil {
+		return fmt.Errorf("default %v is not a JSON value: %v", d.Default, err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return fmt.Errorf("default %v is not a JSON value: %v", d.Default, err)
+	}
+	if !d.Accepts(decoded) {
+		return fmt.Errorf("default %v does not fit schema '%s'", d.Default, d.Schema)
+	}
+	if !d.InEnum(decoded) {
+		return fmt.Errorf("default %v is not one of the enum values", d.Default)
+	}
+	d.Default = decoded
+	return nil
+}
+
+// InEnum reports whether v is one of the definition's enum values. Definitions without an enum
+// accept every value, and null is always accepted (as in Accepts).
+func (d PropertyDefinition) InEnum(v interface{}) bool {
+	if len(d.Enum) == 0 || v == nil {
+		return true
+	}
+	for _, allowed := range d.Enum {
+		if allowed == v {
+			return true
+		}
+	}
+	return false
+}
+
+// EnumList renders the enum values for error messages, e.g. "heating, cooling, off".
+func (d PropertyDefinition) EnumList() string {
+	values := make([]string, len(d.Enum))
+	for i, v := range d.Enum {
+		values[i] = fmt.Sprint(v)
+	}
+	return strings.Join(values, ", ")
+}
+
+// EnumViolations returns the keys of props (sorted) whose value is outside the enum of the
+// model's definition of that key. Keys without a definition or enum are not reported.
+func (m *TwinModel) EnumViolations(props map[string]interface{}) []string {
+	violations := []string{}
+	for key, v := range props {
+		if def, ok := m.Properties[key]; ok && !def.InEnum(v) {
+			violations = append(violations, key)
+		}
+	}
+	sort.Strings(violations)
+	return violations
+}
+
+// UnknownProperties returns the keys of props (sorted) the model doesn't define. A model without
+// property definitions accepts any key, so nothing is reported for it (as by ValidateTwin).
+func (m *TwinModel) UnknownProperties(props map[string]interface{}) []string {
+	unknown := []string{}
+	if len(m.Properties) == 0 {
+		return unknown
+	}
+	for key := range props {
+		if _, ok := m.Properties[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// SchemaViolations returns one message per key of props (sorted by key) whose value doesn't fit
+// the schema of the model's definition of that key (see PropertyDefinition.Accepts). Keys
+// without a definition are not reported.
+func (m *TwinModel) SchemaViolations(props map[string]interface{}) []string {
+	keys := make([]string, 0, len(props))
+	for key := range props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	violations := []string{}
+	for _, key := range keys {
+		if def, ok := m.Properties[key]; ok && !def.Accepts(props[key]) {
+			violations = append(violations, fmt.Sprintf("property '%s' must be of schema '%s' (got %s)", key, def.Schema, jsonTypeName(props[key])))
+		}
+	}
+	return violations
+}
+
+// Property sections checked by ValidateTwin.
+const (
+	SectionDesired  = "desired"
+	SectionReported = "reported"
+	SectionTags     = "tags"
+)
+
+// Violation reasons reported by ValidateTwin.
+const (
+	ViolationUnknownProperty = "UNKNOWN_PROPERTY" // The model declares properties but not this one
+	ViolationTypeMismatch    = "TYPE_MISMATCH"    // The value doesn't fit the declared schema
+	ViolationNotWritable     = "NOT_WRITABLE"     // A desired value is set for a read-only property
+	ViolationNotInEnum       = "NOT_IN_ENUM"      // The value is not one of the property's enum values
+	ViolationMissingTag      = "MISSING_TAG"      // A tag the model's tag schema requires is absent
+	ViolationTagNotAllowed   = "TAG_NOT_ALLOWED"  // The tag's value is outside its allowed values or pattern
+)
+
+// PropertyViolation describes one way a twin's state disagrees with its model.
+type PropertyViolation struct {
+	Section  string `json:"section"`  // desired, reported or tags
+	Property string `json:"property"` // Property (or tag) key
+	Reason   string `json:"reason"`   // One of the Violation* codes
+	Message  string `json:"message"`  // Human-readable detail
+}
+
+// ValidateTwin checks a twin's desired and reported properties against the model's property
+// definitions, and its tags against the tag schema, and returns every violation, ordered by
+// section then key. A model without property definitions or tag schema accepts anything.
+func (m *TwinModel) ValidateTwin(t *TwinInstance) []PropertyViolation {
+	violations := []PropertyViolation{}
+	if len(m.Properties) > 0 {
+		violations = append(violations, m.validateSection(SectionDesired, t.DesiredProperties)...)
+		violations = append(violations, m.validateSection(SectionReported, t.ReportedProperties)...)
+	}
+	return append(violations, m.TagViolations(t.Tags)...)
+}
+
+// validateSection checks one property map; see ValidateTwin.
+func (m *TwinModel) validateSection(section string, props map[string]interface{}) []PropertyViolation {
+	keys := make([]string, 0, len(props))
+	for key := range props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var violations []PropertyViolation
+	for _, key := range keys {
+		def, ok := m.Properties[key]
+		switch {
+		case !ok:
+			violations = append(violations, PropertyViolation{section, key, ViolationUnknownProperty,
+				fmt.Sprintf("property '%s' is not defined by model '%s'", key, m.ID)})
+		case !def.Accepts(props[key]):
+			violations = append(violations, PropertyViolation{section, key, ViolationTypeMismatch,
+				fmt.Sprintf("property '%s' must be of schema '%s' (got %s)", key, def.Schema, jsonTypeName(props[key]))})
+		case !def.InEnum(props[key]):
+			violations = append(violations, PropertyViolation{section, key, ViolationNotInEnum,
+				fmt.Sprintf("property '%s' must be one of: %s (got %v)", key, def.EnumList(), props[key])})
+		case section == SectionDesired && !def.Writable:
+			violations = append(violations, PropertyViolation{section, key, ViolationNotWritable,
+				fmt.Sprintf("property '%s' is read-only and must not have a desired value", key)})
+		}
+	}
+	return violations
+}
+
+// ReportedTypeConflicts checks an update of reported properties (merged into current) under
+// StrictReportedTypes and returns one message per rejected key, sorted by key; nil when the model
+// doesn't enforce types or nothing conflicts. A key the model defines must fit the definition's
+// schema; any other key must keep the JSON type of its current value. null is always accepted
+// and sets no type, so a property reported as null may come back with any type.
+func (m *TwinModel) ReportedTypeConflicts(current, update map[string]interface{}) []string {
+	if !m.StrictReportedTypes {
+		return nil
+	}
+	keys := make([]string, 0, len(update))
+	for key := range update {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var conflicts []string
+	for _, key := range keys {
+		v := update[key]
+		if v == nil {
+			continue
+		}
+		if def, ok := m.Properties[key]; ok {
+			if !def.Accepts(v) {
+				conflicts = append(conflicts, fmt.Sprintf("property '%s' must be of schema '%s' (got %s)", key, def.Schema, jsonTypeName(v)))
+			}
+			continue
+		}
+		if old, ok := current[key]; ok && old != nil && jsonTypeName(old) != jsonTypeName(v) {
+			conflicts = append(conflicts, fmt.Sprintf("property '%s' was reported as %s (got %s)", key, jsonTypeName(old), jsonTypeName(v)))
+		}
+	}
+	return conflicts
+}
+
+// jsonTypeName names the JSON type of a decoded value for error messages.
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// --- JSON Schema ---
+
+// JSONSchemaDialect is the JSON Schema version of DesiredPropertiesSchema documents.
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchemaTypes maps property schemas to JSON Schema types ("integer" accepts 1.0, as Accepts does).
+var jsonSchemaTypes = map[string]string{
+	SchemaString:  "string",
+	SchemaDouble:  "number",
+	SchemaInteger: "integer",
+	SchemaBoolean: "boolean",
+	SchemaObject:  "object",
+}
+
+// DesiredPropertiesSchema describes the desired properties of the model's twins as a JSON Schema
+// document, for clients that generate edit forms and validate them before sending. It accepts
+// exactly what ValidateTwin accepts in the desired section: only writable properties, each of its
+// schema's type and, with an enum, one of its values; null is accepted for every property (it
+// means "no value"). A model without property definitions accepts any object. Units are carried
+// as the "x-unit" annotation.
+func (m *TwinModel) DesiredPropertiesSchema() map[string]interface{} {
+	properties := make(map[string]interface{})
+	for key, def := range m.Properties {
+		if def.Writable {
+			properties[key] = def.jsonSchema()
+		}
+	}
+	schema := map[string]interface{}{
+		"$schema":    JSONSchemaDialect,
+		"title":      m.ID,
+		"type":       "object",
+		"properties": properties,
+	}
+	if m.DisplayName != "" {
+		schema["title"] = m.DisplayName
+	}
+	if m.Description != "" {
+		schema["description"] = m.Description
+	}
+	if len(m.Properties) > 0 {
+		schema["additionalProperties"] = false // Undefined and read-only properties are violations
+	}
+	return schema
+}
+
+// jsonSchema is the JSON Schema of one property's values; see DesiredPropertiesSchema.
+func (d PropertyDefinition) jsonSchema() map[string]interface{} {
+	schema := map[string]interface{}{"type": []string{jsonSchemaTypes[d.Schema], "null"}}
+	if len(d.Enum) > 0 {
+		enum := make([]interface{}, 0, len(d.Enum)+1)
+		schema["enum"] = append(append(enum, d.Enum...), nil)
+	}
+	if d.Description != "" {
+		schema["description"] = d.Description
+	}
+	if d.Unit != "" {
+		schema["x-unit"] = d.Unit
+	}
+	if d.Default != nil {
+		schema["default"] = d.Default
+	}
+	return schema
 }
 
+// --- Effective properties ---
+
+// Sources of an effective property value, in increasing precedence
+const (
+	SourceDefault  = "default"  // The model's default; nothing was reported
+	SourceReported = "reported" // The device's reported value
+	SourceDesired  = "desired"  // A desired value the device hasn't reported back yet
+)
+
+// EffectiveProperty is one value of a twin's effective state and where it comes from.
+type EffectiveProperty struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"` // One of the Source* values
+}
+
+// EffectiveProperties layers the twin's property values into its current effective state: the
+// model's defaults, overridden by reported values, overridden by desired values the device hasn't
+// acknowledged (reported back equal) yet. A desired value the device has reported is "reported";
+// desired values of properties the model defines as read-only are ignored (they can only predate
+// the definition). A null reported or desired value counts as a value.
+func (m *TwinModel) EffectiveProperties(t *TwinInstance) map[string]EffectiveProperty {
+	effective := make(map[string]EffectiveProperty)
+	for key, def := range m.Properties {
+		if def.Default != nil {
+			effective[key] = EffectiveProperty{Value: def.Default, Source: SourceDefault}
+		}
+	}
+	for key, v := range t.ReportedProperties {
+		effective[key] = EffectiveProperty{Value: v, Source: SourceReported}
+	}
+	for key, v := range t.DesiredProperties {
+		if def, ok := m.Properties[key]; ok && !def.Writable {
+			continue
+		}
+		if reported, ok := t.ReportedProperties[key]; ok && reflect.DeepEqual(reported, v) {
+			continue // Acknowledged
+		}
+		effective[key] = EffectiveProperty{Value: v, Source: SourceDesired}
+	}
+	return effective
+}
+
+// --- Telemetry definitions ---
+
+// TelemetryDefinition describes one telemetry name of a model.
 type TelemetryDefinition struct {
-    Name        string      `json:"name" yaml:"name"`
-    Schema      string      `json:"schema" yaml:"schema"`
-    Unit        string      `json:"unit,omitempty" yaml:"unit,omitempty"`
-    Description string      `json:"description,omitempty" yaml:"description,omitempty"`
+	Unit        string `json:"unit,omitempty" yaml:"unit,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+
+	// Retention is how long points of this name are kept before the retention worker deletes them,
+	// e.g. "7d", "2w" or "36h" (see ParseRetention). Empty means the server-wide retention.
+	Retention string `json:"retention,omitempty" yaml:"retention,omitempty"`
+
+	// Enum, when non-empty, is the complete set of values points of this name may carry; such
+	// points must use stringValue (e.g. a "mode" of "heating", "cooling" or "off").
+	Enum []string `json:"enum,omitempty" yaml:"enum,omitempty"`
+
+	// Aggregation is the function bucketed history queries of this name use when the request
+	// names none, so generic dashboards chart it sensibly: "avg" for a temperature, "sum" for
+	// energy, "count" for door openings. One of TelemetryAggregations; see DefaultAggregation
+	// for the fallback when empty.
+	Aggregation string `json:"aggregation,omitempty" yaml:"aggregation,omitempty"`
+
+	// Scale and Offset, when either is set, convert numValue points from raw device units into
+	// engineering units at ingestion: value = raw * Scale + Offset (Scale defaults to 1, Offset to
+	// 0). Stored values, history and aggregates are then in the converted unit; stringValue and
+	// boolValue points are stored as sent. The conversion is done in float64, which represents
+	// integer counts up to 2^53 exactly but rounds the result to about 16 significant digits (0.1
+	// is not exact in binary, so a Scale of 0.1 can store 2.3000000000000003 for 23 counts); round
+	// for display, and keep the raw value (RawName) where exact counts or a later recalibration
+	// matter: changing Scale or Offset does not rewrite points already stored.
+	Scale  *float64 `json:"scale,omitempty" yaml:"scale,omitempty"`
+	Offset *float64 `json:"offset,omitempty" yaml:"offset,omitempty"`
+
+	// RawName, when set on a converted name, additionally stores the untransformed value of each
+	// numValue point under this telemetry name (e.g. "temperatureRaw"), with the point's timestamp
+	// and quality. It counts towards the twin's telemetry name cap like any other name.
+	RawName string `json:"rawName,omitempty" yaml:"rawName,omitempty"`
+
+	// Downsample, when set (a Go duration of at least MinTelemetryDownsample, e.g. "1m"), stores
+	// live numValue points of this name only downsampled: the server buffers them per twin in
+	// buckets of this length and, once a bucket is over, writes a single point at its start
+	// holding the Aggregation (avg, min, max, sum or count; see DefaultAggregation) of its values
+	// and the worst quality among them. The raw points are discarded, and raw copies (RawName) are
+	// downsampled the same way. Backfilled and imported points are stored as sent, as are
+	// stringValue and boolValue points. Buckets are flushed on shutdown and merged into the stored
+	// point, so a bucket written in parts (across a restart or by several replicas) still holds
+	// the aggregate of all its points; see TelemetryDownsampling for what isn't covered.
+	Downsample string `json:"downsample,omitempty" yaml:"downsample,omitempty"`
+}
+
+// Transforms reports whether the definition converts numValue points at ingestion.
+func (d TelemetryDefinition) Transforms() bool {
+	return d.Scale != nil || d.Offset != nil
+}
+
+// Transform converts a raw numValue into the definition's unit: raw * Scale + Offset.
+func (d TelemetryDefinition) Transform(raw float64) float64 {
+	v := raw
+	if d.Scale != nil {
+		v *= *d.Scale
+	}
+	if d.Offset != nil {
+		v += *d.Offset
+	}
+	return v
+}
+
+// TelemetryAggregations are the aggregation functions of bucketed telemetry queries (the
+// persistence.Aggregate* constants).
+var TelemetryAggregations = []string{"avg", "min", "max", "sum", "count", "delta", "rate"}
+
+// DownsampleAggregations are the TelemetryAggregations a downsampled name may use: delta and rate
+// only mean something over the raw points, which downsampling discards.
+var DownsampleAggregations = []string{"avg", "min", "max", "sum", "count"}
+
+// MinTelemetryDownsample is the shortest downsampling bucket a telemetry definition may declare.
+const MinTelemetryDownsample = time.Second
+
+// TelemetryDownsampling is how the live points of a downsampled telemetry name are stored.
+//
+// Each point written for a bucket keeps the bucket's count, sum, min and max, and a later part of
+// the same bucket is merged into it, so flushing unfinished buckets on shutdown loses nothing.
+// Limitations: points still buffered when a server dies are lost (buckets are only in memory
+// until flushed); a bucket whose start already holds a point not written by downsampling (sent
+// directly, backfilled, imported, or downsampled before sql/025 added the statistics) is skipped,
+// keeping that point; and when parts of a bucket come from different writers its writtenBy is
+// cleared.
+type TelemetryDownsampling struct {
+	Interval    time.Duration // Bucket length
+	Aggregation string        // One of DownsampleAggregations
+}
+
+// isTelemetryAggregation reports whether agg is one of TelemetryAggregations.
+func isTelemetryAggregation(agg string) bool {
+	for _, known := range TelemetryAggregations {
+		if agg == known {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultAggregation returns the aggregation function for bucketed queries of telemetry name
+// that don't specify one: the definition's Aggregation, else "count" for enum names (their
+// points are strings, which the numeric functions ignore) and "avg" for everything else.
+func (m *TwinModel) DefaultAggregation(name string) string {
+	def := m.Telemetry[name]
+	switch {
+	case def.Aggregation != "":
+		return def.Aggregation
+	case len(def.Enum) > 0:
+		return "count"
+	default:
+		return "avg"
+	}
+}
+
+// InEnum reports whether a point with the given stringValue (nil for numeric and boolean points)
+// is allowed by the definition's enum. Definitions without an enum accept every point.
+func (d TelemetryDefinition) InEnum(stringValue *string) bool {
+	if len(d.Enum) == 0 {
+		return true
+	}
+	if stringValue == nil {
+		return false
+	}
+	for _, allowed := range d.Enum {
+		if allowed == *stringValue {
+			return true
+		}
+	}
+	return false
+}
+
+// MinTelemetryRetention is the shortest retention a telemetry definition may declare.
+const MinTelemetryRetention = time.Hour
+
+// ParseRetention parses a retention period: a Go duration ("36h") or a whole number of days or
+// weeks ("7d", "2w"); there is no year unit, use days ("730d").
+func ParseRetention(v string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(v, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count <= 0 {
+				return 0, fmt.Errorf("invalid retention %q", v)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention %q", v)
+	}
+	return d, nil
+}
+
+// FormatRetention is the inverse of ParseRetention: whole days as "Nd", anything else as a Go duration.
+func FormatRetention(d time.Duration) string {
+	day := 24 * time.Hour
+	if d > 0 && d%day == 0 {
+		return strconv.FormatInt(int64(d/day), 10) + "d"
+	}
+	return d.String()
+}
+
+// ValidateTelemetry checks Telemetry: names follow the telemetry name rules, are allowed by the
+// allowlist (if any) and aren't mapping aliases (those are never stored); enum values are unique;
+// retentions parse and are at least MinTelemetryRetention; aggregations are TelemetryAggregations;
+// scales are finite and non-zero, offsets finite, and neither is combined with an enum; raw names
+// follow the name rules too and belong to one converted name whose raw values they alone hold;
+// downsampling buckets are at least MinTelemetryDownsample, on numeric names with one of
+// DownsampleAggregations.
+func (m *TwinModel) ValidateTelemetry() error {
+	names := make([]string, 0, len(m.Telemetry))
+	for name := range m.Telemetry {
+		names = append(names, name)
+	}
+	sort.Strings(names) // Deterministic error messages
+
+	allowed := make(map[string]struct{}, len(m.AllowedTelemetryNames))
+	for _, name := range m.AllowedTelemetryNames {
+		allowed[name] = struct{}{}
+	}
+	rawNames := make(map[string]string)
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("telemetry names must not be empty")
+		}
+		if len(name) > MaxTelemetryNameLength {
+			return fmt.Errorf("telemetry name '%s' is longer than %d characters", name, MaxTelemetryNameLength)
+		}
+		if _, ok := allowed[name]; len(allowed) > 0 && !ok {
+			return fmt.Errorf("telemetry '%s' is not in allowedTelemetryNames", name)
+		}
+		if canonical, alias := m.TelemetryNameMappings[name]; alias {
+			return fmt.Errorf("telemetry '%s' is an alias of '%s'; define it under the canonical name", name, canonical)
+		}
+		seen := make(map[string]struct{}, len(m.Telemetry[name].Enum))
+		for _, v := range m.Telemetry[name].Enum {
+			if _, dup := seen[v]; dup {
+				return fmt.Errorf("telemetry '%s': enum value '%s' is listed more than once", name, v)
+			}
+			seen[v] = struct{}{}
+		}
+		if retention := m.Telemetry[name].Retention; retention != "" {
+			d, err := ParseRetention(retention)
+			if err != nil {
+				return fmt.Errorf("telemetry '%s': %v (e.g., 7d, 2w, 36h)", name, err)
+			}
+			if d < MinTelemetryRetention {
+				return fmt.Errorf("telemetry '%s': retention must be at least %s", name, MinTelemetryRetention)
+			}
+		}
+		if agg := m.Telemetry[name].Aggregation; agg != "" && !isTelemetryAggregation(agg) {
+			return fmt.Errorf("telemetry '%s': aggregation must be one of %s", name, strings.Join(TelemetryAggregations, ", "))
+		}
+		if err := m.validateTelemetryTransform(name, allowed, rawNames); err != nil {
+			return err
+		}
+		if err := m.validateTelemetryDownsample(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTelemetryDownsample checks the Downsample of telemetry name.
+func (m *TwinModel) validateTelemetryDownsample(name string) error {
+	def := m.Telemetry[name]
+	if def.Downsample == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(def.Downsample)
+	if err != nil {
+		return fmt.Errorf("telemetry '%s': invalid downsample %q (e.g., 30s, 1m, 15m)", name, def.Downsample)
+	}
+	if d < MinTelemetryDownsample {
+		return fmt.Errorf("telemetry '%s': downsample must be at least %s", name, MinTelemetryDownsample)
+	}
+	if len(def.Enum) > 0 {
+		return fmt.Errorf("telemetry '%s': downsample applies to numValue points and can't be combined with an enum", name)
+	}
+	agg := m.DefaultAggregation(name)
+	for _, allowed := range DownsampleAggregations {
+		if agg == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("telemetry '%s': a downsampled name's aggregation must be one of %s", name, strings.Join(DownsampleAggregations, ", "))
+}
+
+// validateTelemetryTransform checks the Scale, Offset and RawName of telemetry name; rawNames
+// collects the raw names seen so far (raw name -> converted name).
+func (m *TwinModel) validateTelemetryTransform(name string, allowed map[string]struct{}, rawNames map[string]string) error {
+	def := m.Telemetry[name]
+	if def.Scale != nil && (*def.Scale == 0 || math.IsNaN(*def.Scale) || math.IsInf(*def.Scale, 0)) {
+		return fmt.Errorf("telemetry '%s': scale must be a finite, non-zero number", name)
+	}
+	if def.Offset != nil && (math.IsNaN(*def.Offset) || math.IsInf(*def.Offset, 0)) {
+		return fmt.Errorf("telemetry '%s': offset must be a finite number", name)
+	}
+	if def.Transforms() && len(def.Enum) > 0 {
+		return fmt.Errorf("telemetry '%s': scale and offset apply to numValue points and can't be combined with an enum", name)
+	}
+
+	raw := def.RawName
+	if raw == "" {
+		return nil
+	}
+	switch {
+	case !def.Transforms():
+		return fmt.Errorf("telemetry '%s': rawName requires a scale or offset", name)
+	case raw == name:
+		return fmt.Errorf("telemetry '%s': rawName must differ from the telemetry name", name)
+	case len(raw) > MaxTelemetryNameLength:
+		return fmt.Errorf("telemetry '%s': rawName '%s' is longer than %d characters", name, raw, MaxTelemetryNameLength)
+	}
+	if _, ok := allowed[raw]; len(allowed) > 0 && !ok {
+		return fmt.Errorf("telemetry '%s': rawName '%s' is not in allowedTelemetryNames", name, raw)
+	}
+	if canonical, alias := m.TelemetryNameMappings[raw]; alias {
+		return fmt.Errorf("telemetry '%s': rawName '%s' is an alias of '%s'", name, raw, canonical)
+	}
+	if rawDef, ok := m.Telemetry[raw]; ok && (rawDef.Transforms() || len(rawDef.Enum) > 0) {
+		return fmt.Errorf("telemetry '%s': rawName '%s' is a telemetry definition with its own scale, offset or enum", name, raw)
+	}
+	if other, dup := rawNames[raw]; dup {
+		return fmt.Errorf("telemetry '%s': rawName '%s' is already the rawName of '%s'", name, raw, other)
+	}
+	rawNames[raw] = name
+	return nil
+}
+
+// TelemetryRetentions returns the retention declared for each telemetry name that has one.
+// Invalid values (which ValidateTelemetry rejects) are skipped.
+func (m *TwinModel) TelemetryRetentions() map[string]time.Duration {
+	retentions := make(map[string]time.Duration)
+	for name, def := range m.Telemetry {
+		if def.Retention == "" {
+			continue
+		}
+		if d, err := ParseRetention(def.Retention); err == nil {
+			retentions[name] = d
+		}
+	}
+	return retentions
+}
+
+// TelemetryDownsampling returns how each downsampled telemetry name is stored (see
+// TelemetryDefinition.Downsample). Invalid values (which ValidateTelemetry rejects) are skipped.
+func (m *TwinModel) TelemetryDownsampling() map[string]TelemetryDownsampling {
+	downsampling := make(map[string]TelemetryDownsampling)
+	for name, def := range m.Telemetry {
+		if def.Downsample == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(def.Downsample); err == nil && d >= MinTelemetryDownsample {
+			downsampling[name] = TelemetryDownsampling{Interval: d, Aggregation: m.DefaultAggregation(name)}
+		}
+	}
+	return downsampling
+}
+
+// --- Tag schema ---
+
+// TagDefinition constrains one tag key of a model's twins.
+type TagDefinition struct {
+	Required    bool   `json:"required,omitempty" yaml:"required,omitempty"` // Every twin must carry the tag
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+
+	// Values, when non-empty, is the complete set of values the tag may take (e.g. "plant-a", "plant-b").
+	Values []string `json:"values,omitempty" yaml:"values,omitempty"`
+
+	// Pattern, when set, is a regular expression (RE2 syntax) the whole value must match, e.g.
+	// "[A-Z]{2}-[0-9]+". With Values too, a value must satisfy both.
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+}
+
+// matches reports whether v is allowed by the definition's values and pattern. An invalid pattern
+// (which ValidateTagSchema rejects) matches nothing.
+func (d TagDefinition) matches(v string) bool {
+	if len(d.Values) > 0 {
+		found := false
+		for _, allowed := range d.Values {
+			if allowed == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if d.Pattern != "" {
+		re, err := regexp.Compile(anchoredPattern(d.Pattern))
+		if err != nil || !re.MatchString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// anchoredPattern makes a tag pattern match whole values only.
+func anchoredPattern(pattern string) string {
+	return "^(?:" + pattern + ")$"
+}
+
+// ValidateTagSchema checks TagSchema: keys must be non-empty, allowed values non-empty and unique,
+// and patterns valid regular expressions.
+func (m *TwinModel) ValidateTagSchema() error {
+	keys := make([]string, 0, len(m.TagSchema))
+	for key := range m.TagSchema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // Deterministic error messages
+
+	for _, key := range keys {
+		if key == "" {
+			return fmt.Errorf("tag keys must not be empty")
+		}
+		def := m.TagSchema[key]
+		seen := make(map[string]struct{}, len(def.Values))
+		for _, v := range def.Values {
+			if v == "" {
+				return fmt.Errorf("tag '%s': allowed values must not be empty", key)
+			}
+			if _, dup := seen[v]; dup {
+				return fmt.Errorf("tag '%s': value '%s' is listed more than once", key, v)
+			}
+			seen[v] = struct{}{}
+		}
+		if def.Pattern != "" {
+			if _, err := regexp.Compile(def.Pattern); err != nil {
+				return fmt.Errorf("tag '%s': invalid pattern: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// TagViolations checks tags against the model's tag schema and returns one violation (section
+// tags) per missing required tag or disallowed value, sorted by key; empty when the tags comply.
+func (m *TwinModel) TagViolations(tags map[string]string) []PropertyViolation {
+	keys := make([]string, 0, len(m.TagSchema))
+	for key := range m.TagSchema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	violations := []PropertyViolation{}
+	for _, key := range keys {
+		def := m.TagSchema[key]
+		v, ok := tags[key]
+		switch {
+		case !ok && def.Required:
+			violations = append(violations, PropertyViolation{SectionTags, key, ViolationMissingTag,
+				fmt.Sprintf("tag '%s' is required by model '%s'", key, m.ID)})
+		case ok && !def.matches(v):
+			violations = append(violations, PropertyViolation{SectionTags, key, ViolationTagNotAllowed,
+				fmt.Sprintf("tag '%s' %s (got '%s')", key, def.allowedDescription(), v)})
+		}
+	}
+	return violations
+}
+
+// allowedDescription describes the values the definition allows, for violation messages.
+func (d TagDefinition) allowedDescription() string {
+	var parts []string
+	if len(d.Values) > 0 {
+		parts = append(parts, "must be one of: "+strings.Join(d.Values, ", "))
+	}
+	if d.Pattern != "" {
+		parts = append(parts, fmt.Sprintf("must match pattern '%s'", d.Pattern))
+	}
+	return strings.Join(parts, " and ")
 }
 
 // ... CommandDefinition, EventDefinition ...
-*/
@@ -0,0 +1,175 @@
+// pkg/outbox/relay.go
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/scheduler"
+)
+
+// Relay metrics
+var (
+	publishedTotal = metrics.NewCounter("outbox_events_published_total", "Outbox events delivered by the relay.")
+	failedTotal    = metrics.NewCounter("outbox_publish_failures_total", "Outbox batches the relay failed to deliver (retried after the lease).")
+	prunedTotal    = metrics.NewCounter("outbox_events_pruned_total", "Outbox events deleted after the retention.")
+)
+
+// pruneInterval is how often old outbox events are deleted.
+const pruneInterval = time.Hour
+
+// Publisher delivers a batch of outbox events downstream. A nil error means every event of the
+// batch was accepted; on error the whole batch is delivered again later, so consumers must
+// tolerate duplicates (dedupe by event ID).
+type Publisher interface {
+	Publish(ctx context.Context, events []*persistence.OutboxEvent) error
+}
+
+// Publishers publishes every batch to each publisher in turn. The first failure fails the batch,
+// which is then published to all of them again, so list the idempotent ones first.
+type Publishers []Publisher
+
+// Publish publishes the events to every publisher.
+func (p Publishers) Publish(ctx context.Context, events []*persistence.OutboxEvent) error {
+	for _, publisher := range p {
+		if err := publisher.Publish(ctx, events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Relay publishes the store's outbox events in order and marks them sent: at least once, as an
+// event is only marked once its publisher accepted it, and a relay that stops in between leaves
+// it to be claimed again when the lease expires.
+type Relay struct {
+	Store     persistence.OutboxStore
+	Publisher Publisher     // Nil: nothing is published, events are only pruned
+	BatchSize int           // Events per claim and publish
+	Lease     time.Duration // How long a claimed batch is reserved; keep it above the publisher's timeout
+	Retention time.Duration // Sent events are deleted after it (unsent ones too without a publisher)
+}
+
+// Run publishes the pending events, batch by batch, until none are left. Returns how many were
+// published.
+func (r *Relay) Run(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		events, err := r.Store.ClaimOutboxEvents(ctx, r.BatchSize, r.Lease)
+		if err != nil {
+			return published, err
+		}
+		if len(events) == 0 {
+			return published, nil
+		}
+		if err := r.Publisher.Publish(ctx, events); err != nil {
+			return published, fmt.Errorf("failed to publish %d events from %d: %w", len(events), events[0].ID, err)
+		}
+		ids := make([]int64, len(events))
+		for i, e := range events {
+			ids[i] = e.ID
+		}
+		if err := r.Store.MarkOutboxEventsSent(ctx, ids, time.Now()); err != nil {
+			return published, fmt.Errorf("published %d events but failed to mark them sent (they will be published again): %w", len(events), err)
+		}
+		published += len(events)
+		publishedTotal.Add(float64(len(events)))
+		if len(events) < r.BatchSize {
+			return published, nil
+		}
+	}
+}
+
+// Prune deletes the events sent before the retention, and without a publisher the unsent ones
+// too, since no relay will send them. Returns how many were deleted.
+func (r *Relay) Prune(ctx context.Context) (int64, error) {
+	return r.Store.PruneOutboxEvents(ctx, time.Now().Add(-r.Retention), r.Publisher == nil)
+}
+
+// Register schedules the relay on s: "outbox_relay" publishes every interval (non-positive
+// disables it) when there is a publisher, and "outbox_prune" deletes old events hourly. Every
+// replica runs both; claims are leased, so replicas never publish the same event at once.
+func Register(s *scheduler.Scheduler, r *Relay, interval time.Duration) {
+	if r.Publisher != nil {
+		s.Register("outbox_relay", interval, func(ctx context.Context) error {
+			published, err := r.Run(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					failedTotal.Inc()
+				}
+				return fmt.Errorf("published %d events before failing: %w", published, err)
+			}
+			if published > 0 {
+				log.Printf("DEBUG: Outbox relay published %d events", published)
+			}
+			return nil
+		})
+		if interval > 0 {
+			log.Printf("INFO: Publishing outbox events every %s (batches of %d); sent events are kept for %s", interval, r.BatchSize, model.FormatRetention(r.Retention))
+		}
+	} else {
+		log.Printf("INFO: No outbox publisher configured; change events are kept for %s, then deleted unsent", model.FormatRetention(r.Retention))
+	}
+
+	s.Register("outbox_prune", pruneInterval, func(ctx context.Context) error {
+		deleted, err := r.Prune(ctx)
+		prunedTotal.Add(float64(deleted))
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			log.Printf("INFO: Pruned %d outbox events", deleted)
+		}
+		return nil
+	})
+}
+
+// Webhook publishes each batch as one JSON POST, {"events": [...]}, to URL; any 2xx response
+// accepts the whole batch.
+type Webhook struct {
+	URL    string
+	Client *http.Client // Its Timeout bounds each delivery
+}
+
+// NewWebhook creates a webhook publisher with the given delivery timeout.
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	return &Webhook{URL: url, Client: &http.Client{Timeout: timeout}}
+}
+
+// webhookPayload is the body of a webhook delivery.
+type webhookPayload struct {
+	Events []*persistence.OutboxEvent `json:"events"`
+}
+
+// Publish POSTs the events to the webhook.
+func (w *Webhook) Publish(ctx context.Context, events []*persistence.OutboxEvent) error {
+	body, err := json.Marshal(webhookPayload{Events: events})
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox events: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook delivery failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // Lets the connection be reused
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
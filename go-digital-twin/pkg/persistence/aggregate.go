@@ -0,0 +1,154 @@
+// pkg/persistence/aggregate.go
+package persistence
+
+import (
+	"fmt"
+	"time"
+)
+
+// bucketOrigin is the instant buckets are aligned to. It matches TimescaleDB's default
+// time_bucket() origin (Monday 2000-01-03), so weekly buckets start on Mondays in every backend.
+var bucketOrigin = time.Date(2000, time.January, 3, 0, 0, 0, 0, time.UTC)
+
+// bucketInterval renders a bucket width as a PostgreSQL interval literal.
+// Whole days are expressed in days (not hours) so timezone-aware bucketing follows local
+// calendar days, which are 23 or 25 hours long on DST transitions.
+func bucketInterval(bucket time.Duration) string {
+	const day = 24 * time.Hour
+	if bucket%day == 0 {
+		return fmt.Sprintf("%d days", bucket/day)
+	}
+	return fmt.Sprintf("%d microseconds", bucket.Microseconds())
+}
+
+// loadBucketLocation resolves an IANA zone name for bucket alignment ("" means UTC).
+func loadBucketLocation(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil || tz == "Local" { // "Local" depends on the server, not the data
+		return nil, fmt.Errorf("%w: unknown time zone '%s'", ErrValidation, tz)
+	}
+	return loc, nil
+}
+
+// bucketStart returns the start of the bucket containing t, with buckets laid out on the wall clock
+// of loc (the same way time_bucket(bucket, ts, timezone) works: convert to local time, bucket, convert back).
+func bucketStart(t time.Time, bucket time.Duration, loc *time.Location) time.Time {
+	lt := t.In(loc)
+	wall := time.Date(lt.Year(), lt.Month(), lt.Day(), lt.Hour(), lt.Minute(), lt.Second(), lt.Nanosecond(), time.UTC)
+
+	offset := wall.Sub(bucketOrigin)
+	n := offset / bucket
+	if offset%bucket < 0 {
+		n-- // Floor, not truncate, for times before the origin
+	}
+	b := bucketOrigin.Add(n * bucket)
+	return time.Date(b.Year(), b.Month(), b.Day(), b.Hour(), b.Minute(), b.Second(), b.Nanosecond(), loc).UTC()
+}
+
+// bucketAggregator downsamples records fed in ts order into buckets the way the SQL stores'
+// GROUP BY does. Backends without time_bucket()/date_bin() (memory, sqlite) aggregate with it.
+// Since bucket starts are monotonic in ts, each bucket is a contiguous run of records.
+type bucketAggregator struct {
+	bucket time.Duration
+	agg    string
+	loc    *time.Location
+
+	buckets []*TelemetryAggregate
+	current *TelemetryAggregate
+	sum     float64
+	numeric int              // Numeric points in the current bucket
+	total   int              // All points in the current bucket
+	first   *TelemetryRecord // First/last numeric points of the current bucket (delta/rate)
+	last    *TelemetryRecord
+}
+
+// newBucketAggregator validates the aggregation parameters (see TimeSeriesStore.QueryTelemetryAggregate).
+func newBucketAggregator(bucket time.Duration, agg string, tz string) (*bucketAggregator, error) {
+	if !IsValidAggregate(agg) {
+		return nil, fmt.Errorf("%w: unsupported aggregation '%s'", ErrValidation, agg)
+	}
+	if bucket <= 0 {
+		return nil, fmt.Errorf("%w: bucket must be positive", ErrValidation)
+	}
+	loc, err := loadBucketLocation(tz)
+	if err != nil {
+		return nil, err
+	}
+	return &bucketAggregator{bucket: bucket, agg: agg, loc: loc, buckets: []*TelemetryAggregate{}}, nil
+}
+
+// add folds the next record (in ascending ts order) into its bucket. rec must not be modified afterwards.
+func (a *bucketAggregator) add(rec *TelemetryRecord) {
+	bs := bucketStart(rec.Timestamp, a.bucket, a.loc)
+	if a.current == nil || !a.current.Bucket.Equal(bs) {
+		a.flush()
+		a.current = &TelemetryAggregate{Bucket: bs}
+		a.sum, a.numeric, a.total = 0, 0, 0
+		a.first, a.last = nil, nil
+	}
+	a.total++
+	a.current.Quality.add(rec.Quality)
+	if rec.NumericValue == nil {
+		return
+	}
+	v := *rec.NumericValue
+	a.numeric++
+	a.sum += v
+	if a.first == nil {
+		a.first = rec
+	}
+	a.last = rec
+	switch a.agg {
+	case AggregateMin:
+		if a.current.Value == nil || v < *a.current.Value {
+			a.current.Value = &v
+		}
+	case AggregateMax:
+		if a.current.Value == nil || v > *a.current.Value {
+			a.current.Value = &v
+		}
+	}
+}
+
+// flush finishes the current bucket.
+func (a *bucketAggregator) flush() {
+	if a.current == nil {
+		return
+	}
+	switch a.agg {
+	case AggregateCount:
+		v := float64(a.total)
+		a.current.Value = &v
+	case AggregateSum:
+		if a.numeric > 0 {
+			v := a.sum // Copy: sum is reused by the next bucket
+			a.current.Value = &v
+		}
+	case AggregateAvg:
+		if a.numeric > 0 {
+			v := a.sum / float64(a.numeric)
+			a.current.Value = &v
+		}
+	case AggregateDelta:
+		if a.numeric > 1 {
+			v := *a.last.NumericValue - *a.first.NumericValue
+			a.current.Value = &v
+		}
+	case AggregateRate:
+		if span := a.last.Timestamp.Sub(a.first.Timestamp).Seconds(); a.numeric > 1 && span > 0 {
+			v := (*a.last.NumericValue - *a.first.NumericValue) / span
+			a.current.Value = &v
+		}
+	}
+	a.buckets = append(a.buckets, a.current)
+	a.current = nil
+}
+
+// result finishes the last bucket and returns all buckets, oldest first.
+func (a *bucketAggregator) result() []*TelemetryAggregate {
+	a.flush()
+	return a.buckets
+}
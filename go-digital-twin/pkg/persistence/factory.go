@@ -0,0 +1,114 @@
+// pkg/persistence/factory.go
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Supported values for Config.Backend (STORE_BACKEND).
+const (
+	BackendTimescale = "timescale" // PostgreSQL + TimescaleDB (hypertable, last()); the default
+	BackendPostgres  = "postgres"  // Plain PostgreSQL; same schema without the Timescale-only functions
+	BackendMemory    = "memory"    // In-process maps; data is lost on restart (dev/tests)
+	BackendSQLite    = "sqlite"    // Embedded single-file database (modernc.org/sqlite, no cgo); DSN is the file path
+)
+
+// Supported values for Config.UpdatedAtSource (UPDATED_AT_SOURCE).
+const (
+	UpdatedAtDatabase    = "database"    // The store stamps every update with the time it writes it; the default
+	UpdatedAtApplication = "application" // Full updates store the UpdatedAt their caller set
+)
+
+// Config selects and configures a Store implementation.
+type Config struct {
+	Backend string // One of the Backend* constants; empty means BackendTimescale
+	DSN     string // Connection string for the SQL backends, database file path for sqlite (ignored by memory)
+
+	// QueryLog logs slow statements and their plans (timescale and postgres only; see QueryLogConfig).
+	QueryLog QueryLogConfig
+
+	// StatementTimeout sets PostgreSQL's statement_timeout on every pooled connection, so the
+	// database aborts statements running longer whatever happens to the client (timescale and
+	// postgres only). Request contexts already cancel their statements when they expire, but that
+	// cancellation is a separate request to the server, which a crashed client or a lost
+	// connection never sends; this is the backstop. Keep it above the longest context timeout:
+	// a statement runs for as long as its rows stream, so a lower limit cuts off long exports.
+	// Background work (retention deletes, async ingestion) is bound by it as well. Zero keeps the database's (or the DSN's) setting.
+	StatementTimeout time.Duration
+
+	// UpdatedAtSource says who sets updated_at when a model, twin or template is updated; empty
+	// means UpdatedAtDatabase. With "database" the store stamps each update with the time it is
+	// written (on PostgreSQL the NOW() of the set_timestamp triggers) and ignores the caller's
+	// UpdatedAt. With "application" UpdateModel, UpdateTwin(IfUnmodified) and UpdateTemplate store
+	// the caller's UpdatedAt (now when zero), e.g. to preserve the timestamps of migrated data;
+	// updates of a single field take no timestamp and stamp the server's time. PostgreSQL needs
+	// sql/024 for the triggers to keep those values. Either way, re-read a record to get the
+	// updated_at actually stored.
+	UpdatedAtSource string
+}
+
+// updateTime is the updated_at of an update the caller stamped t: t when the application owns
+// updated_at and t is set, else now.
+func updateTime(applicationOwned bool, t time.Time) time.Time {
+	if applicationOwned && !t.IsZero() {
+		return t
+	}
+	return time.Now()
+}
+
+// NewStore creates the Store implementation selected by cfg.Backend.
+// Backend selection lives here so callers only ever depend on the Store interface.
+//
+// The postgres backend uses the same tables as timescale (sql/*.sql); skip the
+// create_hypertable() call in sql/003_create_telemetry.sql when TimescaleDB isn't installed.
+func NewStore(ctx context.Context, cfg Config) (Store, error) {
+	backend := strings.ToLower(strings.TrimSpace(cfg.Backend))
+	if backend == "" {
+		backend = BackendTimescale
+	}
+	log.Printf("INFO: Using store backend: %s", backend)
+	if cfg.QueryLog.enabled() && backend != BackendTimescale && backend != BackendPostgres {
+		log.Printf("WARN: Query logging (DB_QUERY_LOG=%s) is only supported by the %s and %s backends; ignoring it", cfg.QueryLog.Mode, BackendTimescale, BackendPostgres)
+	}
+	if cfg.StatementTimeout > 0 && backend != BackendTimescale && backend != BackendPostgres {
+		log.Printf("WARN: A statement timeout (DB_STATEMENT_TIMEOUT=%s) is only supported by the %s and %s backends; ignoring it", cfg.StatementTimeout, BackendTimescale, BackendPostgres)
+	}
+
+	var applicationOwned bool
+	switch source := strings.ToLower(strings.TrimSpace(cfg.UpdatedAtSource)); source {
+	case "", UpdatedAtDatabase:
+	case UpdatedAtApplication:
+		applicationOwned = true
+		log.Printf("INFO: updated_at is set by the application (UPDATED_AT_SOURCE=%s)", source)
+	default:
+		return nil, fmt.Errorf("unknown updated_at source %q (expected %s or %s)", cfg.UpdatedAtSource, UpdatedAtDatabase, UpdatedAtApplication)
+	}
+
+	switch backend {
+	case BackendTimescale, BackendPostgres:
+		store, err := newPostgresModelStore(ctx, cfg, applicationOwned)
+		if err != nil {
+			return nil, err
+		}
+		store.timescale = backend == BackendTimescale
+		return store, nil
+	case BackendMemory:
+		store := NewMemoryStore()
+		store.appUpdatedAt = applicationOwned
+		return store, nil
+	case BackendSQLite:
+		store, err := NewSQLiteStore(ctx, cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		store.appUpdatedAt = applicationOwned
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q (expected %s, %s, %s or %s)",
+			cfg.Backend, BackendTimescale, BackendPostgres, BackendMemory, BackendSQLite)
+	}
+}
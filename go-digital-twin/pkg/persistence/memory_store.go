@@ -0,0 +1,1948 @@
+// pkg/persistence/memory_store.go
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/geo"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// --- Ensure MemoryStore implements the combined Store interface ---
+var _ Store = (*MemoryStore)(nil) // Compile-time check
+
+// MemoryStore is an in-process Store backed by maps. Nothing is persisted across restarts,
+// so it is meant for local development, demos and tests rather than production.
+// It mirrors the SQL stores' semantics (sentinel errors, model references, telemetry dedup)
+// so handlers behave the same regardless of backend.
+type MemoryStore struct {
+	mu           sync.RWMutex
+	models       map[string]*model.TwinModel
+	versions     map[string][]*ModelVersion // modelID -> history, oldest first; kept after deletion
+	twins        map[string]*model.TwinInstance
+	templates    map[string]*model.TwinTemplate
+	revoked      map[string]*RevokedToken
+	outbox       []*outboxEntry // Ordered by ID
+	lastEvent    int64
+	webhooks     map[string]*Webhook
+	deliveries   []*WebhookDelivery // Ordered by ID
+	lastDelivery int64
+	alertRules   map[string]*AlertRule
+	alertStates  map[string]map[string]*AlertState                  // ruleID -> twinID -> state
+	telemetry    map[string]map[string][]*TelemetryRecord           // twinID -> name -> records sorted by ts ascending
+	bucketStats  map[string]map[string]map[int64]*DownsampledBucket // twinID -> name -> ts (µs) -> statistics of a downsampled point
+
+	appUpdatedAt bool // Full updates keep the caller's UpdatedAt (Config.UpdatedAtSource)
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	log.Println("WARN: Using in-memory store; data will be lost on restart.")
+	return &MemoryStore{
+		models:      make(map[string]*model.TwinModel),
+		versions:    make(map[string][]*ModelVersion),
+		twins:       make(map[string]*model.TwinInstance),
+		templates:   make(map[string]*model.TwinTemplate),
+		revoked:     make(map[string]*RevokedToken),
+		webhooks:    make(map[string]*Webhook),
+		alertRules:  make(map[string]*AlertRule),
+		alertStates: make(map[string]map[string]*AlertState),
+		telemetry:   make(map[string]map[string][]*TelemetryRecord),
+		bucketStats: make(map[string]map[string]map[int64]*DownsampledBucket),
+	}
+}
+
+// Ping always succeeds: there is no database to reach.
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op for the in-memory store.
+func (s *MemoryStore) Close() {
+	log.Println("INFO: Closing in-memory store.")
+}
+
+// dbTime normalizes a timestamp the way a TIMESTAMPTZ column would (UTC, microsecond precision),
+// so values read back compare equal to what the SQL backends return.
+func dbTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// --- Copy helpers ---
+// Everything is copied on the way in and out so callers can't mutate stored state.
+
+func copyModel(m *model.TwinModel) *model.TwinModel {
+	c := *m
+	if m.Properties != nil {
+		c.Properties = make(map[string]model.PropertyDefinition, len(m.Properties))
+		for k, v := range m.Properties {
+			if v.Enum != nil {
+				v.Enum = append([]interface{}(nil), v.Enum...) // Values are strings or float64: no deeper copy needed
+			}
+			c.Properties[k] = v
+		}
+	}
+	if len(m.AllowedTelemetryNames) > 0 {
+		c.AllowedTelemetryNames = append([]string(nil), m.AllowedTelemetryNames...)
+	} else {
+		c.AllowedTelemetryNames = nil // Like '{}' in the SQL stores
+	}
+	if len(m.TelemetryNameMappings) > 0 {
+		c.TelemetryNameMappings = copyTags(m.TelemetryNameMappings)
+	} else {
+		c.TelemetryNameMappings = nil
+	}
+	if len(m.DerivedProperties) > 0 {
+		c.DerivedProperties = make(map[string]model.DerivedProperty, len(m.DerivedProperties))
+		for name, def := range m.DerivedProperties {
+			c.DerivedProperties[name] = def
+		}
+	} else {
+		c.DerivedProperties = nil
+	}
+	if len(m.Telemetry) > 0 {
+		c.Telemetry = make(map[string]model.TelemetryDefinition, len(m.Telemetry))
+		for name, def := range m.Telemetry {
+			if def.Enum != nil {
+				def.Enum = append([]string(nil), def.Enum...)
+			}
+			c.Telemetry[name] = def
+		}
+	} else {
+		c.Telemetry = nil
+	}
+	if len(m.TagSchema) > 0 {
+		c.TagSchema = make(map[string]model.TagDefinition, len(m.TagSchema))
+		for key, def := range m.TagSchema {
+			if def.Values != nil {
+				def.Values = append([]string(nil), def.Values...)
+			}
+			c.TagSchema[key] = def
+		}
+	} else {
+		c.TagSchema = nil
+	}
+	return &c
+}
+
+// copyJSONMap deep-copies a property map via a JSON round trip, matching JSONB storage
+// (e.g., numbers come back as float64). Nil becomes an empty map, like '{}' in the SQL stores.
+func copyJSONMap(src map[string]interface{}) (map[string]interface{}, error) {
+	dst := make(map[string]interface{})
+	if src == nil {
+		return dst, nil
+	}
+	data, err := json.Marshal(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &dst); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// copyProperties deep-copies a twin property map, keeping nil (never set) maps nil.
+func copyProperties(src map[string]interface{}) (map[string]interface{}, error) {
+	if src == nil {
+		return nil, nil
+	}
+	return copyJSONMap(src)
+}
+
+func copyTags(src map[string]string) map[string]string {
+	dst := make(map[string]string, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+func copyTwin(t *model.TwinInstance) *model.TwinInstance {
+	c := *t
+	// Stored maps are already JSON-normalized, so a failed round trip can't happen here
+	c.ReportedProperties, _ = copyProperties(t.ReportedProperties)
+	c.DesiredProperties, _ = copyProperties(t.DesiredProperties)
+	c.Tags = copyTags(t.Tags)
+	c.Metadata, _ = copyMetadata(t.Metadata)
+	c.Location = copyLocation(t.Location)
+	return &c
+}
+
+func copyLocation(p *model.GeoPoint) *model.GeoPoint {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	return &c
+}
+
+// copyMetadata deep-copies twin metadata; empty metadata becomes nil, as the SQL stores read it back.
+func copyMetadata(src map[string]interface{}) (map[string]interface{}, error) {
+	if len(src) == 0 {
+		return nil, nil
+	}
+	return copyJSONMap(src)
+}
+
+func copyTemplate(t *model.TwinTemplate) *model.TwinTemplate {
+	c := *t
+	c.DesiredProperties, _ = copyJSONMap(t.DesiredProperties)
+	c.Tags = copyTags(t.Tags)
+	return &c
+}
+
+func copyRecord(r *TelemetryRecord) *TelemetryRecord {
+	c := *r
+	if r.NumericValue != nil {
+		v := *r.NumericValue
+		c.NumericValue = &v
+	}
+	if r.StringValue != nil {
+		v := *r.StringValue
+		c.StringValue = &v
+	}
+	if r.BooleanValue != nil {
+		v := *r.BooleanValue
+		c.BooleanValue = &v
+	}
+	return &c
+}
+
+// --- ModelStore Methods ---
+
+// CreateModel stores a new model.
+func (s *MemoryStore) CreateModel(ctx context.Context, m *model.TwinModel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.models[m.ID]; exists {
+		return fmt.Errorf("%w: model with ID '%s' already exists", ErrConflict, m.ID)
+	}
+	c := copyModel(m)
+	c.CreatedAt = dbTime(m.CreatedAt)
+	c.UpdatedAt = dbTime(m.UpdatedAt)
+	s.models[m.ID] = c
+	s.recordVersionLocked(ctx, c, ModelChangeCreated, c.CreatedAt)
+	s.recordEventLocked(EventModelCreated, c.ID, c.ID)
+	return nil
+}
+
+// FindModelByID retrieves a model by its ID.
+func (s *MemoryStore) FindModelByID(ctx context.Context, id string) (*model.TwinModel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.models[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: model with ID '%s' not found", ErrNotFound, id)
+	}
+	return copyModel(m), nil
+}
+
+// ListAllModels retrieves all models ordered by ID.
+func (s *MemoryStore) ListAllModels(ctx context.Context) ([]*model.TwinModel, error) {
+	return s.listModels(func(*model.TwinModel) bool { return true }), nil
+}
+
+// ListModelsByCategory retrieves models whose category matches, ignoring case.
+func (s *MemoryStore) ListModelsByCategory(ctx context.Context, category string) ([]*model.TwinModel, error) {
+	return s.listModels(func(m *model.TwinModel) bool {
+		return strings.EqualFold(m.Category, category)
+	}), nil
+}
+
+// ListModelIDs lists the model IDs without copying the models.
+func (s *MemoryStore) ListModelIDs(ctx context.Context, afterID string, limit int) ([]string, error) {
+	s.mu.RLock()
+	ids := []string{}
+	for id := range s.models {
+		if id > afterID {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+	return pageIDs(ids, limit), nil
+}
+
+// pageIDs sorts ids and keeps the first limit of them (all when limit <= 0).
+func pageIDs(ids []string, limit int) []string {
+	sort.Strings(ids)
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids
+}
+
+// StreamModels passes the models to fn one at a time; like StreamTelemetryHistory, the lock is
+// only held while they are snapshotted.
+func (s *MemoryStore) StreamModels(ctx context.Context, category string, fn func(*model.TwinModel) error) error {
+	models := s.listModels(func(m *model.TwinModel) bool {
+		return category == "" || strings.EqualFold(m.Category, category)
+	})
+	for _, m := range models {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listModels returns copies of the models matching keep, ordered by ID.
+func (s *MemoryStore) listModels(keep func(*model.TwinModel) bool) []*model.TwinModel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	models := []*model.TwinModel{}
+	for _, m := range s.models {
+		if keep(m) {
+			models = append(models, copyModel(m))
+		}
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	return models
+}
+
+// ListModelCategories returns distinct categories and their model counts.
+// Categories differing only in case are grouped together under the lexically smallest spelling.
+func (s *MemoryStore) ListModelCategories(ctx context.Context) ([]*ModelCategory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byKey := make(map[string]*ModelCategory)
+	for _, m := range s.models {
+		if m.Category == "" {
+			continue
+		}
+		key := strings.ToLower(m.Category)
+		c, ok := byKey[key]
+		if !ok {
+			c = &ModelCategory{Name: m.Category}
+			byKey[key] = c
+		} else if m.Category < c.Name {
+			c.Name = m.Category // Same as MIN(category) in the SQL stores
+		}
+		c.Count++
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	categories := make([]*ModelCategory, 0, len(keys))
+	for _, key := range keys {
+		categories = append(categories, byKey[key])
+	}
+	return categories, nil
+}
+
+// UpdateModel updates an existing model. CreatedAt is preserved and UpdatedAt is set to now, or
+// kept when the application owns it.
+func (s *MemoryStore) UpdateModel(ctx context.Context, m *model.TwinModel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.models[m.ID]
+	if !ok {
+		return fmt.Errorf("%w: model with ID '%s' not found for update", ErrNotFound, m.ID)
+	}
+	c := copyModel(m)
+	c.CreatedAt = existing.CreatedAt
+	c.UpdatedAt = dbTime(updateTime(s.appUpdatedAt, m.UpdatedAt)) // What the updated_at trigger does
+	s.models[m.ID] = c
+	s.recordVersionLocked(ctx, c, ModelChangeUpdated, c.UpdatedAt)
+	s.recordEventLocked(EventModelUpdated, c.ID, c.ID)
+	return nil
+}
+
+// DeleteModel removes a model by ID. Models still referenced by twins can't be deleted.
+func (s *MemoryStore) DeleteModel(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.models[id]
+	if !ok {
+		return fmt.Errorf("%w: model with ID '%s' not found for deletion", ErrNotFound, id)
+	}
+	for _, t := range s.twins {
+		if t.ModelID == id {
+			return fmt.Errorf("%w: model with ID '%s' is still referenced by twin instances", ErrConflict, id)
+		}
+	}
+	delete(s.models, id)
+	s.recordVersionLocked(ctx, existing, ModelChangeDeleted, dbTime(time.Now()))
+	s.recordEventLocked(EventModelDeleted, id, id)
+	return nil
+}
+
+// recordVersionLocked appends a copy of m to the model's history. Caller must hold the write lock.
+func (s *MemoryStore) recordVersionLocked(ctx context.Context, m *model.TwinModel, change string, at time.Time) {
+	history := s.versions[m.ID]
+	s.versions[m.ID] = append(history, &ModelVersion{
+		ModelID:   m.ID,
+		Version:   len(history) + 1,
+		Change:    change,
+		ChangedAt: at,
+		ChangedBy: changedBy(ctx),
+		Model:     copyModel(m),
+	})
+}
+
+// ListModelVersions lists a model's recorded versions, oldest first, without definitions.
+func (s *MemoryStore) ListModelVersions(ctx context.Context, modelID string) ([]*ModelVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	versions := make([]*ModelVersion, 0, len(s.versions[modelID]))
+	for _, v := range s.versions[modelID] {
+		c := *v
+		c.Model = nil
+		versions = append(versions, &c)
+	}
+	return versions, nil
+}
+
+// FindModelVersion retrieves one recorded version of a model with its definition.
+func (s *MemoryStore) FindModelVersion(ctx context.Context, modelID string, version int) (*ModelVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := s.versions[modelID]
+	if version < 1 || version > len(history) {
+		return nil, fmt.Errorf("%w: version %d of model '%s' not found", ErrNotFound, version, modelID)
+	}
+	c := *history[version-1]
+	c.Model = copyModel(c.Model)
+	return &c, nil
+}
+
+// --- TwinStore Methods ---
+
+// CreateTwin stores a new twin instance. The referenced model must exist.
+func (s *MemoryStore) CreateTwin(ctx context.Context, twin *model.TwinInstance) error {
+	reported, err := copyProperties(twin.ReportedProperties)
+	if err != nil {
+		return fmt.Errorf("failed to copy reported properties for twin '%s': %w", twin.ID, err)
+	}
+	desired, err := copyProperties(twin.DesiredProperties)
+	if err != nil {
+		return fmt.Errorf("failed to copy desired properties for twin '%s': %w", twin.ID, err)
+	}
+	metadata, err := copyMetadata(twin.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata for twin '%s': %w", twin.ID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.twins[twin.ID]; exists {
+		return fmt.Errorf("%w: twin instance with ID '%s' already exists", ErrConflict, twin.ID)
+	}
+	if _, ok := s.models[twin.ModelID]; !ok {
+		return fmt.Errorf("%w: model with ID '%s' not found", ErrInvalidReference, twin.ModelID)
+	}
+
+	s.twins[twin.ID] = &model.TwinInstance{
+		ID:                 twin.ID,
+		ModelID:            twin.ModelID,
+		DisplayName:        twin.DisplayName,
+		Description:        twin.Description,
+		ReportedProperties: reported,
+		DesiredProperties:  desired,
+		Tags:               copyTags(twin.Tags),
+		Metadata:           metadata,
+		Location:           copyLocation(twin.Location),
+		CreatedAt:          dbTime(twin.CreatedAt),
+		UpdatedAt:          dbTime(twin.UpdatedAt),
+	}
+	s.recordEventLocked(EventTwinCreated, twin.ID, twin.ModelID)
+	return nil
+}
+
+// FindTwinByID retrieves a twin instance by ID.
+func (s *MemoryStore) FindTwinByID(ctx context.Context, id string) (*model.TwinInstance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.twins[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: twin instance with ID '%s' not found", ErrNotFound, id)
+	}
+	return copyTwin(t), nil
+}
+
+// TwinExists reports whether the twin is stored.
+func (s *MemoryStore) TwinExists(ctx context.Context, id string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.twins[id]
+	return ok, nil
+}
+
+// FindTwinsByIDs retrieves the stored twins among ids, ordered by ID.
+func (s *MemoryStore) FindTwinsByIDs(ctx context.Context, ids []string) ([]*model.TwinInstance, error) {
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	return s.listTwins(func(t *model.TwinInstance) bool { return wanted[t.ID] }), nil
+}
+
+// ListAllTwins retrieves all twin instances ordered by ID.
+func (s *MemoryStore) ListAllTwins(ctx context.Context) ([]*model.TwinInstance, error) {
+	return s.listTwins(func(*model.TwinInstance) bool { return true }), nil
+}
+
+// ListTwinsByModel retrieves twins filtered by model ID.
+func (s *MemoryStore) ListTwinsByModel(ctx context.Context, modelID string) ([]*model.TwinInstance, error) {
+	return s.listTwins(func(t *model.TwinInstance) bool { return t.ModelID == modelID }), nil
+}
+
+// ListTwinsByModelPage lists one ID-ordered page of a model's twins after afterID.
+func (s *MemoryStore) ListTwinsByModelPage(ctx context.Context, modelID string, afterID string, limit int) ([]*model.TwinInstance, error) {
+	twins := s.listTwins(func(t *model.TwinInstance) bool { return t.ModelID == modelID && t.ID > afterID })
+	if limit > 0 && len(twins) > limit {
+		twins = twins[:limit]
+	}
+	return twins, nil
+}
+
+// ListTwinsByTags lists twins whose tags contain all the given pairs.
+func (s *MemoryStore) ListTwinsByTags(ctx context.Context, tags map[string]string, modelID string) ([]*model.TwinInstance, error) {
+	return s.listTwins(func(t *model.TwinInstance) bool {
+		return (modelID == "" || t.ModelID == modelID) && t.HasTags(tags)
+	}), nil
+}
+
+// ListTwinsInBox lists located twins inside box that match tags and modelID.
+func (s *MemoryStore) ListTwinsInBox(ctx context.Context, box geo.Box, tags map[string]string, modelID string) ([]*model.TwinInstance, error) {
+	return s.listTwins(func(t *model.TwinInstance) bool {
+		return t.Location != nil && box.Contains(*t.Location) && (modelID == "" || t.ModelID == modelID) && t.HasTags(tags)
+	}), nil
+}
+
+// ListTwinIDs lists the matching twin IDs without copying the twins.
+func (s *MemoryStore) ListTwinIDs(ctx context.Context, tags map[string]string, modelID string, afterID string, limit int) ([]string, error) {
+	s.mu.RLock()
+	ids := []string{}
+	for id, t := range s.twins {
+		if id > afterID && (modelID == "" || t.ModelID == modelID) && t.HasTags(tags) {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+	return pageIDs(ids, limit), nil
+}
+
+// ListOrphanedTwins lists twins whose model ID isn't in the store.
+func (s *MemoryStore) ListOrphanedTwins(ctx context.Context) ([]*model.TwinInstance, error) {
+	return s.listTwins(func(t *model.TwinInstance) bool { // Called with s.mu held
+		_, ok := s.models[t.ModelID]
+		return !ok
+	}), nil
+}
+
+// StreamTwins passes the matching twins to fn one at a time; the lock is only held while they
+// are snapshotted.
+func (s *MemoryStore) StreamTwins(ctx context.Context, tags map[string]string, modelID string, fn func(*model.TwinInstance) error) error {
+	twins := s.listTwins(func(t *model.TwinInstance) bool {
+		return (modelID == "" || t.ModelID == modelID) && t.HasTags(tags)
+	})
+	for _, t := range twins {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamTwinFields is StreamTwins, copying only the maps asked for.
+func (s *MemoryStore) StreamTwinFields(ctx context.Context, fields []string, tags map[string]string, modelID string, fn func(*model.TwinInstance) error) error {
+	for _, t := range s.projectTwins(TwinPageQuery{Tags: tags, ModelID: modelID, Fields: fields}) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListTwinsPage lists one page of the matching twins after the cursor.
+func (s *MemoryStore) ListTwinsPage(ctx context.Context, q TwinPageQuery) ([]*model.TwinInstance, error) {
+	return s.projectTwins(q), nil
+}
+
+// projectTwins returns the twins matching q in its order, copying only the maps in q.Fields.
+// A non-positive q.Limit returns every match.
+func (s *MemoryStore) projectTwins(q TwinPageQuery) []*model.TwinInstance {
+	wanted := make(map[string]bool, len(TwinFields))
+	for _, f := range TwinFields {
+		wanted[f] = q.Fields == nil
+	}
+	for _, f := range q.Fields {
+		wanted[f] = true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	search := strings.ToLower(q.Search)
+	matches := []*model.TwinInstance{}
+	for _, t := range s.twins {
+		after := t.ID > q.AfterID
+		if q.ByDisplayName && q.AfterID != "" {
+			after = t.DisplayName > q.AfterDisplayName || (t.DisplayName == q.AfterDisplayName && after)
+		}
+		if after && (q.ModelID == "" || t.ModelID == q.ModelID) && t.HasTags(q.Tags) &&
+			strings.Contains(strings.ToLower(t.DisplayName), search) {
+			matches = append(matches, t)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if q.ByDisplayName && matches[i].DisplayName != matches[j].DisplayName {
+			return matches[i].DisplayName < matches[j].DisplayName
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+
+	twins := make([]*model.TwinInstance, 0, len(matches))
+	for _, t := range matches {
+		c := &model.TwinInstance{ID: t.ID, ModelID: t.ModelID, DisplayName: t.DisplayName, Description: t.Description, Location: copyLocation(t.Location), CreatedAt: t.CreatedAt, UpdatedAt: t.UpdatedAt}
+		if wanted["reportedProperties"] {
+			c.ReportedProperties, _ = copyProperties(t.ReportedProperties)
+		}
+		if wanted["desiredProperties"] {
+			c.DesiredProperties, _ = copyProperties(t.DesiredProperties)
+		}
+		c.Tags = map[string]string{} // Always set, as the SQL stores read it back
+		if wanted["tags"] {
+			c.Tags = copyTags(t.Tags)
+		}
+		if wanted["metadata"] {
+			c.Metadata, _ = copyMetadata(t.Metadata)
+		}
+		twins = append(twins, c)
+	}
+	return twins
+}
+
+// listTwins returns copies of the twins matching keep, ordered by ID.
+func (s *MemoryStore) listTwins(keep func(*model.TwinInstance) bool) []*model.TwinInstance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	twins := []*model.TwinInstance{}
+	for _, t := range s.twins {
+		if keep(t) {
+			twins = append(twins, copyTwin(t))
+		}
+	}
+	sort.Slice(twins, func(i, j int) bool { return twins[i].ID < twins[j].ID })
+	return twins
+}
+
+// UpdateTwin replaces the twin's model reference, properties and tags.
+func (s *MemoryStore) UpdateTwin(ctx context.Context, twin *model.TwinInstance) error {
+	return s.updateTwin(twin, nil)
+}
+
+// UpdateTwinIfUnmodified updates the twin if UpdatedAt still matches.
+func (s *MemoryStore) UpdateTwinIfUnmodified(ctx context.Context, twin *model.TwinInstance, expectedUpdatedAt time.Time) error {
+	return s.updateTwin(twin, &expectedUpdatedAt)
+}
+
+// updateTwin replaces the twin's mutable fields, only while UpdatedAt still matches
+// expectedUpdatedAt when it is non-nil (ErrPreconditionFailed otherwise).
+func (s *MemoryStore) updateTwin(twin *model.TwinInstance, expectedUpdatedAt *time.Time) error {
+	reported, err := copyProperties(twin.ReportedProperties)
+	if err != nil {
+		return fmt.Errorf("failed to copy reported properties for twin '%s': %w", twin.ID, err)
+	}
+	desired, err := copyProperties(twin.DesiredProperties)
+	if err != nil {
+		return fmt.Errorf("failed to copy desired properties for twin '%s': %w", twin.ID, err)
+	}
+	metadata, err := copyMetadata(twin.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata for twin '%s': %w", twin.ID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.twins[twin.ID]
+	if !ok {
+		return fmt.Errorf("%w: twin instance with ID '%s' not found for update", ErrNotFound, twin.ID)
+	}
+	if expectedUpdatedAt != nil && !existing.UpdatedAt.Equal(*expectedUpdatedAt) {
+		return fmt.Errorf("%w: twin instance with ID '%s' was modified concurrently", ErrPreconditionFailed, twin.ID)
+	}
+	if _, ok := s.models[twin.ModelID]; !ok {
+		return fmt.Errorf("%w: model with ID '%s' not found", ErrInvalidReference, twin.ModelID)
+	}
+
+	before := *existing
+	existing.ModelID = twin.ModelID
+	existing.DisplayName = twin.DisplayName
+	existing.Description = twin.Description
+	existing.ReportedProperties = reported
+	existing.DesiredProperties = desired
+	existing.Tags = copyTags(twin.Tags)
+	existing.Metadata = metadata
+	existing.Location = copyLocation(twin.Location)
+	existing.UpdatedAt = dbTime(updateTime(s.appUpdatedAt, twin.UpdatedAt))
+	s.recordEventLocked(twinUpdateEvent(&before, existing), existing.ID, existing.ModelID)
+	return nil
+}
+
+// updateTwinField applies apply to the stored twin under the write lock.
+// If expectedUpdatedAt is non-nil the update only happens while UpdatedAt still matches it;
+// otherwise ErrPreconditionFailed is returned (same contract as the SQL stores).
+func (s *MemoryStore) updateTwinField(id, fieldName string, expectedUpdatedAt *time.Time, apply func(t *model.TwinInstance)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.twins[id]
+	if !ok {
+		return fmt.Errorf("%w: twin instance with ID '%s' not found for %s update", ErrNotFound, id, fieldName)
+	}
+	if expectedUpdatedAt != nil && !t.UpdatedAt.Equal(*expectedUpdatedAt) {
+		return fmt.Errorf("%w: twin instance with ID '%s' was modified concurrently", ErrPreconditionFailed, id)
+	}
+	before := *t
+	apply(t)
+	t.UpdatedAt = dbTime(time.Now())
+	s.recordEventLocked(twinUpdateEvent(&before, t), t.ID, t.ModelID)
+	return nil
+}
+
+// updateTwinProperties replaces one of the property maps.
+func (s *MemoryStore) updateTwinProperties(id string, desired bool, properties map[string]interface{}, expectedUpdatedAt *time.Time) error {
+	fieldName := "reported_properties"
+	if desired {
+		fieldName = "desired_properties"
+	}
+	props, err := copyJSONMap(properties)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s data for twin '%s': %w", fieldName, id, err)
+	}
+	return s.updateTwinField(id, fieldName, expectedUpdatedAt, func(t *model.TwinInstance) {
+		if desired {
+			t.DesiredProperties = props
+		} else {
+			t.ReportedProperties = props
+		}
+	})
+}
+
+// UpdateReportedProperties updates only the reported properties.
+func (s *MemoryStore) UpdateReportedProperties(ctx context.Context, id string, properties map[string]interface{}) error {
+	return s.updateTwinProperties(id, false, properties, nil)
+}
+
+// MergeReportedProperties merges the patches under the write lock. Every patch is copied before
+// any twin changes, so a patch that can't be encoded leaves the batch unapplied.
+func (s *MemoryStore) MergeReportedProperties(ctx context.Context, patches map[string]map[string]interface{}) (map[string]time.Time, error) {
+	copies := make(map[string]map[string]interface{}, len(patches))
+	for id, patch := range patches {
+		c, err := copyJSONMap(patch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal reported properties patch for twin '%s': %w", id, err)
+		}
+		copies[id] = c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := dbTime(time.Now())
+	updated := make(map[string]time.Time, len(copies))
+	for id, patch := range copies {
+		t, ok := s.twins[id]
+		if !ok {
+			continue
+		}
+		if t.ReportedProperties == nil {
+			t.ReportedProperties = make(map[string]interface{}, len(patch))
+		}
+		for key, v := range patch {
+			t.ReportedProperties[key] = v
+		}
+		t.UpdatedAt = now
+		updated[id] = now
+		s.recordEventLocked(EventTwinReported, id, t.ModelID)
+	}
+	return updated, nil
+}
+
+// UpdateDesiredProperties updates only the desired properties.
+func (s *MemoryStore) UpdateDesiredProperties(ctx context.Context, id string, properties map[string]interface{}) error {
+	return s.updateTwinProperties(id, true, properties, nil)
+}
+
+// UpdateTags updates only the tags.
+func (s *MemoryStore) UpdateTags(ctx context.Context, id string, tags map[string]string) error {
+	return s.updateTwinField(id, "tags", nil, func(t *model.TwinInstance) { t.Tags = copyTags(tags) })
+}
+
+// UpdateDesiredPropertiesIfUnmodified updates desired properties if UpdatedAt still matches.
+func (s *MemoryStore) UpdateDesiredPropertiesIfUnmodified(ctx context.Context, id string, properties map[string]interface{}, expectedUpdatedAt time.Time) error {
+	return s.updateTwinProperties(id, true, properties, &expectedUpdatedAt)
+}
+
+// UpdateTagsIfUnmodified updates tags if UpdatedAt still matches.
+func (s *MemoryStore) UpdateTagsIfUnmodified(ctx context.Context, id string, tags map[string]string, expectedUpdatedAt time.Time) error {
+	return s.updateTwinField(id, "tags", &expectedUpdatedAt, func(t *model.TwinInstance) { t.Tags = copyTags(tags) })
+}
+
+// UpdateMetadata updates only the metadata.
+func (s *MemoryStore) UpdateMetadata(ctx context.Context, id string, metadata map[string]interface{}) error {
+	return s.updateTwinMetadata(id, metadata, nil)
+}
+
+// UpdateMetadataIfUnmodified updates metadata if UpdatedAt still matches.
+func (s *MemoryStore) UpdateMetadataIfUnmodified(ctx context.Context, id string, metadata map[string]interface{}, expectedUpdatedAt time.Time) error {
+	return s.updateTwinMetadata(id, metadata, &expectedUpdatedAt)
+}
+
+// updateTwinMetadata replaces the metadata with a copy of metadata.
+func (s *MemoryStore) updateTwinMetadata(id string, metadata map[string]interface{}, expectedUpdatedAt *time.Time) error {
+	c, err := copyJSONMap(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata data for twin '%s': %w", id, err)
+	}
+	return s.updateTwinField(id, "metadata", expectedUpdatedAt, func(t *model.TwinInstance) { t.Metadata = c })
+}
+
+// DeleteTwin removes a twin instance by ID. Like the SQL stores, its telemetry is kept.
+func (s *MemoryStore) DeleteTwin(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.twins[id]
+	if !ok {
+		return fmt.Errorf("%w: twin instance with ID '%s' not found for deletion", ErrNotFound, id)
+	}
+	delete(s.twins, id)
+	s.recordEventLocked(EventTwinDeleted, id, existing.ModelID)
+	return nil
+}
+
+// DeleteTwinsByTags removes the matching twins and their telemetry (see TwinStore).
+func (s *MemoryStore) DeleteTwinsByTags(ctx context.Context, tags map[string]string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("%w: a tag selector is required to delete twins in bulk", ErrValidation)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []string{}
+	for id, t := range s.twins {
+		if t.HasTags(tags) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		s.recordEventLocked(EventTwinDeleted, id, s.twins[id].ModelID)
+		delete(s.twins, id)
+		delete(s.telemetry, id)
+		delete(s.bucketStats, id)
+	}
+	return ids, nil
+}
+
+// --- OutboxStore Methods ---
+
+// outboxEntry is a stored outbox event with its delivery state.
+type outboxEntry struct {
+	event        OutboxEvent
+	claimedUntil time.Time
+	sentAt       time.Time // Zero until delivered
+}
+
+// recordEventLocked appends an outbox event for a change. Caller must hold the write lock, which
+// makes it part of the change like the SQL stores' triggers.
+func (s *MemoryStore) recordEventLocked(eventType, subjectID, modelID string) {
+	s.appendEventLocked(OutboxEvent{Type: eventType, SubjectID: subjectID, ModelID: modelID})
+}
+
+// appendEventLocked appends e to the outbox with the next ID and the current time. Caller must
+// hold the write lock.
+func (s *MemoryStore) appendEventLocked(e OutboxEvent) {
+	s.lastEvent++
+	e.ID = s.lastEvent
+	e.OccurredAt = dbTime(time.Now())
+	e.Attempts = 0
+	s.outbox = append(s.outbox, &outboxEntry{event: e})
+}
+
+// twinUpdateEvent returns the outbox event type of a twin update, derived like the SQL triggers:
+// EventTwinReported when nothing but the reported properties may have changed.
+func twinUpdateEvent(before, after *model.TwinInstance) string {
+	sameTags := (len(before.Tags) == 0 && len(after.Tags) == 0) || reflect.DeepEqual(before.Tags, after.Tags)
+	if before.ModelID == after.ModelID && sameTags &&
+		before.DisplayName == after.DisplayName && before.Description == after.Description &&
+		reflect.DeepEqual(before.DesiredProperties, after.DesiredProperties) &&
+		reflect.DeepEqual(before.Metadata, after.Metadata) &&
+		reflect.DeepEqual(before.Location, after.Location) {
+		return EventTwinReported
+	}
+	return EventTwinUpdated
+}
+
+// ClaimOutboxEvents leases the oldest unsent events whose lease is free.
+func (s *MemoryStore) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	claimed := []*OutboxEvent{}
+	for _, e := range s.outbox {
+		if len(claimed) >= limit {
+			break
+		}
+		if !e.sentAt.IsZero() || e.claimedUntil.After(now) {
+			continue
+		}
+		e.claimedUntil = now.Add(lease)
+		e.event.Attempts++
+		c := e.event
+		claimed = append(claimed, &c)
+	}
+	return claimed, nil
+}
+
+// MarkOutboxEventsSent records the events as delivered.
+func (s *MemoryStore) MarkOutboxEventsSent(ctx context.Context, ids []int64, at time.Time) error {
+	sent := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		sent[id] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.outbox {
+		if sent[e.event.ID] && e.sentAt.IsZero() {
+			e.sentAt = dbTime(at)
+		}
+	}
+	return nil
+}
+
+// PruneOutboxEvents drops sent events (and with pending, unsent ones) older than before.
+func (s *MemoryStore) PruneOutboxEvents(ctx context.Context, before time.Time, pending bool) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.outbox[:0]
+	var deleted int64
+	for _, e := range s.outbox {
+		if (!e.sentAt.IsZero() && e.sentAt.Before(before)) || (pending && e.sentAt.IsZero() && e.event.OccurredAt.Before(before)) {
+			deleted++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	s.outbox = kept
+	return deleted, nil
+}
+
+// --- WebhookStore Methods ---
+
+func copyWebhook(w *Webhook) *Webhook {
+	c := *w
+	c.EventTypes = append([]string{}, w.EventTypes...)
+	return &c
+}
+
+// CreateWebhook stores a new subscription.
+func (s *MemoryStore) CreateWebhook(ctx context.Context, w *Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.webhooks[w.ID]; exists {
+		return fmt.Errorf("%w: webhook with ID '%s' already exists", ErrConflict, w.ID)
+	}
+	c := copyWebhook(w)
+	c.CreatedAt = dbTime(w.CreatedAt)
+	c.UpdatedAt = dbTime(w.UpdatedAt)
+	s.webhooks[w.ID] = c
+	return nil
+}
+
+// FindWebhookByID retrieves a subscription by ID.
+func (s *MemoryStore) FindWebhookByID(ctx context.Context, id string) (*Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	w, ok := s.webhooks[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: webhook with ID '%s' not found", ErrNotFound, id)
+	}
+	return copyWebhook(w), nil
+}
+
+// ListWebhooks lists every subscription ordered by ID.
+func (s *MemoryStore) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*Webhook, 0, len(s.webhooks))
+	for _, w := range s.webhooks {
+		list = append(list, copyWebhook(w))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// UpdateWebhook replaces a subscription's mutable fields.
+func (s *MemoryStore) UpdateWebhook(ctx context.Context, w *Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.webhooks[w.ID]
+	if !ok {
+		return fmt.Errorf("%w: webhook with ID '%s' not found for update", ErrNotFound, w.ID)
+	}
+	c := copyWebhook(w)
+	c.CreatedAt = existing.CreatedAt
+	c.UpdatedAt = dbTime(time.Now())
+	s.webhooks[w.ID] = c
+	return nil
+}
+
+// DeleteWebhook removes a subscription and its deliveries.
+func (s *MemoryStore) DeleteWebhook(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[id]; !ok {
+		return fmt.Errorf("%w: webhook with ID '%s' not found for deletion", ErrNotFound, id)
+	}
+	delete(s.webhooks, id)
+	kept := s.deliveries[:0]
+	for _, d := range s.deliveries {
+		if d.WebhookID != id {
+			kept = append(kept, d)
+		}
+	}
+	s.deliveries = kept
+	return nil
+}
+
+// EnqueueWebhookDeliveries queues the deliveries of existing webhooks not queued yet.
+func (s *MemoryStore) EnqueueWebhookDeliveries(ctx context.Context, deliveries []*WebhookDelivery) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type key struct {
+		webhookID string
+		eventID   int64
+	}
+	queued := make(map[key]bool, len(s.deliveries))
+	for _, d := range s.deliveries {
+		queued[key{d.WebhookID, d.Event.ID}] = true
+	}
+
+	now := dbTime(time.Now())
+	n := 0
+	for _, d := range deliveries {
+		k := key{d.WebhookID, d.Event.ID}
+		if _, ok := s.webhooks[d.WebhookID]; !ok || queued[k] {
+			continue
+		}
+		queued[k] = true
+		s.lastDelivery++
+		event := d.Event
+		event.Attempts = 0
+		event.OccurredAt = dbTime(event.OccurredAt)
+		s.deliveries = append(s.deliveries, &WebhookDelivery{
+			ID:            s.lastDelivery,
+			WebhookID:     d.WebhookID,
+			Event:         event,
+			Status:        DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		n++
+	}
+	return n, nil
+}
+
+// ClaimWebhookDeliveries leases the oldest due pending deliveries.
+func (s *MemoryStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	claimed := []*WebhookDelivery{}
+	for _, d := range s.deliveries {
+		if len(claimed) >= limit {
+			break
+		}
+		if d.Status != DeliveryPending || d.NextAttemptAt.After(now) {
+			continue
+		}
+		d.NextAttemptAt = dbTime(now.Add(lease))
+		d.Attempts++
+		c := *d
+		claimed = append(claimed, &c)
+	}
+	return claimed, nil
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt.
+func (s *MemoryStore) UpdateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.deliveries {
+		if d.ID == delivery.ID {
+			d.Status = delivery.Status
+			d.NextAttemptAt = dbTime(delivery.NextAttemptAt)
+			d.LastStatusCode = delivery.LastStatusCode
+			d.LastError = delivery.LastError
+			d.UpdatedAt = dbTime(time.Now())
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: webhook delivery %d not found", ErrNotFound, delivery.ID)
+}
+
+// ListWebhookDeliveries lists a webhook's deliveries newest first.
+func (s *MemoryStore) ListWebhookDeliveries(ctx context.Context, webhookID string, beforeID int64, limit int) ([]*WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []*WebhookDelivery{}
+	for i := len(s.deliveries) - 1; i >= 0 && len(list) < limit; i-- {
+		d := s.deliveries[i]
+		if d.WebhookID != webhookID || (beforeID != 0 && d.ID >= beforeID) {
+			continue
+		}
+		c := *d
+		list = append(list, &c)
+	}
+	return list, nil
+}
+
+// PruneWebhookDeliveries drops the finished deliveries last updated before before.
+func (s *MemoryStore) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.deliveries[:0]
+	var deleted int64
+	for _, d := range s.deliveries {
+		if d.Status != DeliveryPending && d.UpdatedAt.Before(before) {
+			deleted++
+			continue
+		}
+		kept = append(kept, d)
+	}
+	s.deliveries = kept
+	return deleted, nil
+}
+
+// --- AlertStore Methods ---
+
+// CreateAlertRule stores a new rule.
+func (s *MemoryStore) CreateAlertRule(ctx context.Context, rule *AlertRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.alertRules[rule.ID]; exists {
+		return fmt.Errorf("%w: alert rule with ID '%s' already exists", ErrConflict, rule.ID)
+	}
+	c := *rule
+	c.CreatedAt = dbTime(rule.CreatedAt)
+	c.UpdatedAt = dbTime(rule.UpdatedAt)
+	s.alertRules[rule.ID] = &c
+	return nil
+}
+
+// FindAlertRuleByID retrieves a rule by ID.
+func (s *MemoryStore) FindAlertRuleByID(ctx context.Context, id string) (*AlertRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rule, ok := s.alertRules[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: alert rule with ID '%s' not found", ErrNotFound, id)
+	}
+	c := *rule
+	return &c, nil
+}
+
+// ListAlertRules lists every rule ordered by ID.
+func (s *MemoryStore) ListAlertRules(ctx context.Context) ([]*AlertRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*AlertRule, 0, len(s.alertRules))
+	for _, rule := range s.alertRules {
+		c := *rule
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// UpdateAlertRule replaces a rule's mutable fields.
+func (s *MemoryStore) UpdateAlertRule(ctx context.Context, rule *AlertRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.alertRules[rule.ID]
+	if !ok {
+		return fmt.Errorf("%w: alert rule with ID '%s' not found for update", ErrNotFound, rule.ID)
+	}
+	c := *rule
+	c.CreatedAt = existing.CreatedAt
+	c.UpdatedAt = dbTime(time.Now())
+	s.alertRules[rule.ID] = &c
+	return nil
+}
+
+// DeleteAlertRule removes a rule and its states.
+func (s *MemoryStore) DeleteAlertRule(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.alertRules[id]; !ok {
+		return fmt.Errorf("%w: alert rule with ID '%s' not found for deletion", ErrNotFound, id)
+	}
+	delete(s.alertRules, id)
+	delete(s.alertStates, id)
+	return nil
+}
+
+// ListAlertStates lists a rule's states ordered by twin ID.
+func (s *MemoryStore) ListAlertStates(ctx context.Context, ruleID string) ([]*AlertState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*AlertState, 0, len(s.alertStates[ruleID]))
+	for _, state := range s.alertStates[ruleID] {
+		c := *state
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].TwinID < list[j].TwinID })
+	return list, nil
+}
+
+// SaveAlertState stores the state and its event under the write lock, if the stored state is
+// still prev.
+func (s *MemoryStore) SaveAlertState(ctx context.Context, prev, state *AlertState, event *OutboxEvent) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !sameAlertState(s.alertStates[state.RuleID][state.TwinID], prev) {
+		return false, nil // Recorded by another evaluator first
+	}
+	if _, ok := s.alertRules[state.RuleID]; !ok {
+		return false, fmt.Errorf("%w: alert rule with ID '%s' not found", ErrNotFound, state.RuleID)
+	}
+	states := s.alertStates[state.RuleID]
+	if states == nil {
+		states = make(map[string]*AlertState)
+		s.alertStates[state.RuleID] = states
+	}
+	c := *state
+	c.Since = dbTime(state.Since)
+	c.UpdatedAt = dbTime(time.Now())
+	states[state.TwinID] = &c
+	if event != nil {
+		s.appendEventLocked(*event)
+	}
+	return true, nil
+}
+
+// DeleteAlertState removes the state of (prev.RuleID, prev.TwinID), if it is still prev.
+func (s *MemoryStore) DeleteAlertState(ctx context.Context, prev *AlertState) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur := s.alertStates[prev.RuleID][prev.TwinID]; cur == nil || !sameAlertState(cur, prev) {
+		return false, nil
+	}
+	delete(s.alertStates[prev.RuleID], prev.TwinID)
+	return true, nil
+}
+
+// sameAlertState reports whether the stored state cur is the state prev was read as (both nil:
+// no state).
+func sameAlertState(cur, prev *AlertState) bool {
+	if cur == nil || prev == nil {
+		return cur == nil && prev == nil
+	}
+	return cur.State == prev.State && cur.Since.Equal(prev.Since)
+}
+
+// --- TemplateStore Methods ---
+
+// CreateTemplate stores a new template. The model reference is not checked (see TemplateStore).
+func (s *MemoryStore) CreateTemplate(ctx context.Context, t *model.TwinTemplate) error {
+	desired, err := copyJSONMap(t.DesiredProperties)
+	if err != nil {
+		return fmt.Errorf("failed to marshal desired properties for template '%s': %w", t.ID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.templates[t.ID]; exists {
+		return fmt.Errorf("%w: template with ID '%s' already exists", ErrConflict, t.ID)
+	}
+	c := *t
+	c.DesiredProperties = desired
+	c.Tags = copyTags(t.Tags)
+	c.CreatedAt = dbTime(t.CreatedAt)
+	c.UpdatedAt = dbTime(t.UpdatedAt)
+	s.templates[t.ID] = &c
+	return nil
+}
+
+// FindTemplateByID retrieves a template by ID.
+func (s *MemoryStore) FindTemplateByID(ctx context.Context, id string) (*model.TwinTemplate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.templates[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: template with ID '%s' not found", ErrNotFound, id)
+	}
+	return copyTemplate(t), nil
+}
+
+// ListAllTemplates retrieves all templates ordered by ID.
+func (s *MemoryStore) ListAllTemplates(ctx context.Context) ([]*model.TwinTemplate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	templates := make([]*model.TwinTemplate, 0, len(s.templates))
+	for _, t := range s.templates {
+		templates = append(templates, copyTemplate(t))
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].ID < templates[j].ID })
+	return templates, nil
+}
+
+// UpdateTemplate replaces a template's mutable fields. CreatedAt is preserved and UpdatedAt is set to now.
+func (s *MemoryStore) UpdateTemplate(ctx context.Context, t *model.TwinTemplate) error {
+	desired, err := copyJSONMap(t.DesiredProperties)
+	if err != nil {
+		return fmt.Errorf("failed to marshal desired properties for template '%s': %w", t.ID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.templates[t.ID]
+	if !ok {
+		return fmt.Errorf("%w: template with ID '%s' not found for update", ErrNotFound, t.ID)
+	}
+	c := *t
+	c.DesiredProperties = desired
+	c.Tags = copyTags(t.Tags)
+	c.CreatedAt = existing.CreatedAt
+	c.UpdatedAt = dbTime(updateTime(s.appUpdatedAt, t.UpdatedAt))
+	s.templates[t.ID] = &c
+	return nil
+}
+
+// DeleteTemplate removes a template by ID.
+func (s *MemoryStore) DeleteTemplate(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.templates[id]; !ok {
+		return fmt.Errorf("%w: template with ID '%s' not found for deletion", ErrNotFound, id)
+	}
+	delete(s.templates, id)
+	return nil
+}
+
+// --- TokenStore Methods ---
+
+// RevokeToken adds a token to the revocation list, keeping the first RevokedAt and the later Until.
+func (s *MemoryStore) RevokeToken(ctx context.Context, revoked *RevokedToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := &RevokedToken{ID: revoked.ID, RevokedAt: dbTime(revoked.RevokedAt), Until: dbTime(revoked.Until)}
+	if existing, ok := s.revoked[revoked.ID]; ok {
+		entry.RevokedAt = existing.RevokedAt
+		if existing.Until.After(entry.Until) {
+			entry.Until = existing.Until
+		}
+	}
+	s.revoked[revoked.ID] = entry
+	return nil
+}
+
+// ListRevokedTokens lists the revocation list ordered by token ID.
+func (s *MemoryStore) ListRevokedTokens(ctx context.Context) ([]*RevokedToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*RevokedToken, 0, len(s.revoked))
+	for _, entry := range s.revoked {
+		copied := *entry
+		list = append(list, &copied)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// PruneRevokedTokens removes the entries whose Until is not after before.
+func (s *MemoryStore) PruneRevokedTokens(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for id, entry := range s.revoked {
+		if !entry.Until.After(before) {
+			delete(s.revoked, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// --- TimeSeriesStore Methods ---
+
+// insertRecordLocked inserts a copy of record into its series, keeping it sorted by ts.
+// Returns false (and inserts nothing) if a record with the same (twin, name, ts) exists.
+// Caller must hold the write lock.
+func (s *MemoryStore) insertRecordLocked(twinID string, record *TelemetryRecord) bool {
+	rec := copyRecord(record)
+	rec.TwinID = twinID
+	rec.Timestamp = dbTime(record.Timestamp)
+
+	byName, ok := s.telemetry[twinID]
+	if !ok {
+		byName = make(map[string][]*TelemetryRecord)
+		s.telemetry[twinID] = byName
+	}
+	series := byName[rec.Name]
+
+	i := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(rec.Timestamp) })
+	if i < len(series) && series[i].Timestamp.Equal(rec.Timestamp) {
+		return false // Dedup on (twin_id, name, ts)
+	}
+	series = append(series, nil)
+	copy(series[i+1:], series[i:])
+	series[i] = rec
+	byName[rec.Name] = series
+	return true
+}
+
+// WriteTelemetry stores a single telemetry record.
+func (s *MemoryStore) WriteTelemetry(ctx context.Context, twinID string, record *TelemetryRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.insertRecordLocked(twinID, record) {
+		return fmt.Errorf("%w: telemetry '%s' for twin '%s' at %s already exists", ErrConflict, record.Name, twinID, record.Timestamp.Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// WriteBatchTelemetry sets each record's TwinID and writes them with WriteTelemetryCopy.
+func (s *MemoryStore) WriteBatchTelemetry(ctx context.Context, twinID string, records []*TelemetryRecord) error {
+	for _, rec := range records {
+		rec.TwinID = twinID
+	}
+	return s.WriteTelemetryCopy(ctx, records)
+}
+
+// WriteTelemetryCopy inserts the records under one write lock. Duplicates are looked for before
+// anything is inserted, so a conflicting batch leaves the store unchanged like the SQL stores.
+func (s *MemoryStore) WriteTelemetryCopy(ctx context.Context, records []*TelemetryRecord) error {
+	if err := checkRecordTwinIDs(records); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type recordKey struct {
+		twinID, name string
+		ts           int64
+	}
+	batch := make(map[recordKey]struct{}, len(records))
+	for _, rec := range records {
+		ts := dbTime(rec.Timestamp)
+		key := recordKey{rec.TwinID, rec.Name, ts.UnixMicro()}
+		series := s.telemetry[rec.TwinID][rec.Name]
+		i := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(ts) })
+		_, dup := batch[key]
+		if dup || (i < len(series) && series[i].Timestamp.Equal(ts)) {
+			return fmt.Errorf("%w: telemetry '%s' for twin '%s' at %s already exists", ErrConflict, rec.Name, rec.TwinID, rec.Timestamp.Format(time.RFC3339Nano))
+		}
+		batch[key] = struct{}{}
+	}
+	for _, rec := range records {
+		s.insertRecordLocked(rec.TwinID, rec)
+	}
+	return nil
+}
+
+// BackfillTelemetry inserts historical telemetry, skipping records that already exist.
+func (s *MemoryStore) BackfillTelemetry(ctx context.Context, twinID string, records []*TelemetryRecord) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inserted := 0
+	for _, rec := range records {
+		if s.insertRecordLocked(twinID, rec) {
+			inserted++
+		}
+	}
+	return inserted, nil
+}
+
+// MergeDownsampledTelemetry stores or merges the buckets' points under the write lock. The
+// statistics are kept beside the series and pruned with their points.
+func (s *MemoryStore) MergeDownsampledTelemetry(ctx context.Context, twinID string, buckets []*DownsampledBucket) ([]*TelemetryRecord, error) {
+	for _, b := range buckets {
+		switch b.Aggregation {
+		case "", AggregateAvg, AggregateMin, AggregateMax, AggregateSum, AggregateCount:
+		default:
+			return nil, fmt.Errorf("%w: unknown downsampling aggregation '%s'", ErrValidation, b.Aggregation)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := []*TelemetryRecord{}
+	for _, b := range buckets {
+		merged := *b
+		merged.Start = dbTime(b.Start)
+		series := s.telemetry[twinID][b.Name]
+		i := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(merged.Start) })
+		byTs := s.bucketStats[twinID][b.Name]
+
+		var rec *TelemetryRecord
+		if i < len(series) && series[i].Timestamp.Equal(merged.Start) {
+			prev := byTs[merged.Start.UnixMicro()]
+			if prev == nil {
+				continue // A point stored otherwise: skipped
+			}
+			rec = series[i]
+			merged.Count += prev.Count
+			merged.Sum += prev.Sum
+			merged.Min = math.Min(merged.Min, prev.Min)
+			merged.Max = math.Max(merged.Max, prev.Max)
+			if rec.Quality > merged.Quality {
+				merged.Quality = rec.Quality
+			}
+			if rec.WrittenBy != merged.WrittenBy {
+				merged.WrittenBy = ""
+			}
+		} else {
+			rec = &TelemetryRecord{Name: b.Name, Timestamp: merged.Start}
+			s.insertRecordLocked(twinID, rec)
+			rec = s.telemetry[twinID][b.Name][i] // The stored copy
+		}
+		value := merged.Value()
+		rec.NumericValue, rec.Quality, rec.WrittenBy = &value, merged.Quality, merged.WrittenBy
+
+		if byTs == nil {
+			if s.bucketStats[twinID] == nil {
+				s.bucketStats[twinID] = make(map[string]map[int64]*DownsampledBucket)
+			}
+			byTs = make(map[int64]*DownsampledBucket)
+			s.bucketStats[twinID][b.Name] = byTs
+		}
+		byTs[merged.Start.UnixMicro()] = &merged
+		stored = append(stored, copyRecord(rec))
+	}
+	return stored, nil
+}
+
+// rangeLocked returns the stored records for (twin, name) with start <= ts <= end, oldest first.
+// The slice aliases stored records; callers must copy before returning them. Caller must hold the lock.
+func (s *MemoryStore) rangeLocked(twinID, name string, start, end time.Time) []*TelemetryRecord {
+	series := s.telemetry[twinID][name]
+	lo := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(start) })
+	hi := sort.Search(len(series), func(i int) bool { return series[i].Timestamp.After(end) })
+	if lo >= hi {
+		return nil
+	}
+	return series[lo:hi]
+}
+
+// QueryTelemetryHistory retrieves historical telemetry data.
+func (s *MemoryStore) QueryTelemetryHistory(ctx context.Context, twinID string, name string, start time.Time, end time.Time, descending bool, limit uint, qualities []Quality) ([]*TelemetryRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matched := s.rangeLocked(twinID, name, start, end)
+	records := make([]*TelemetryRecord, 0, len(matched))
+	for i := range matched {
+		idx := i
+		if descending {
+			idx = len(matched) - 1 - i
+		}
+		if !matchesQuality(matched[idx].Quality, qualities) {
+			continue
+		}
+		records = append(records, copyRecord(matched[idx]))
+		if limit > 0 && uint(len(records)) >= limit {
+			break
+		}
+	}
+	return records, nil
+}
+
+// StreamTelemetryHistory passes historical telemetry to fn one record at a time.
+// The lock is only held while the matching range is snapshotted, not while fn runs.
+func (s *MemoryStore) StreamTelemetryHistory(ctx context.Context, twinID string, name string, start time.Time, end time.Time, descending bool, limit uint, qualities []Quality, fn func(*TelemetryRecord) error) error {
+	s.mu.RLock()
+	matched := append([]*TelemetryRecord(nil), s.rangeLocked(twinID, name, start, end)...)
+	s.mu.RUnlock()
+
+	var sent uint
+	for i := range matched {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		idx := i
+		if descending {
+			idx = len(matched) - 1 - i
+		}
+		if !matchesQuality(matched[idx].Quality, qualities) {
+			continue
+		}
+		if err := fn(copyRecord(matched[idx])); err != nil {
+			return err
+		}
+		sent++
+		if limit > 0 && sent >= limit {
+			break
+		}
+	}
+	return nil
+}
+
+// QueryTelemetryAggregate downsamples telemetry into time buckets (see TimeSeriesStore).
+func (s *MemoryStore) QueryTelemetryAggregate(ctx context.Context, twinID string, name string, start time.Time, end time.Time, bucket time.Duration, agg string, tz string, qualities []Quality) ([]*TelemetryAggregate, error) {
+	aggregator, err := newBucketAggregator(bucket, agg, tz)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rec := range s.rangeLocked(twinID, name, start, end) {
+		if matchesQuality(rec.Quality, qualities) {
+			aggregator.add(rec)
+		}
+	}
+	return aggregator.result(), nil
+}
+
+// QueryTelemetryBulk returns the history of every (twin, name) combination (see TimeSeriesStore).
+func (s *MemoryStore) QueryTelemetryBulk(ctx context.Context, twinIDs []string, names []string, start time.Time, end time.Time, limit uint, qualities []Quality) (map[TelemetrySeriesKey][]*TelemetryRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[TelemetrySeriesKey][]*TelemetryRecord)
+	for _, twinID := range twinIDs {
+		for _, name := range names {
+			var records []*TelemetryRecord
+			for _, rec := range s.rangeLocked(twinID, name, start, end) {
+				if limit > 0 && uint(len(records)) >= limit {
+					break
+				}
+				if matchesQuality(rec.Quality, qualities) {
+					records = append(records, copyRecord(rec))
+				}
+			}
+			if len(records) > 0 {
+				result[TelemetrySeriesKey{TwinID: twinID, Name: name}] = records
+			}
+		}
+	}
+	return result, nil
+}
+
+// QueryTelemetryAggregateBulk downsamples every (twin, name) combination (see TimeSeriesStore).
+func (s *MemoryStore) QueryTelemetryAggregateBulk(ctx context.Context, twinIDs []string, names []string, start time.Time, end time.Time, bucket time.Duration, agg string, tz string, qualities []Quality) (map[TelemetrySeriesKey][]*TelemetryAggregate, error) {
+	if _, err := newBucketAggregator(bucket, agg, tz); err != nil {
+		return nil, err // Validate once, even when no series has points
+	}
+
+	result := make(map[TelemetrySeriesKey][]*TelemetryAggregate)
+	for _, twinID := range twinIDs {
+		for _, name := range names {
+			buckets, err := s.QueryTelemetryAggregate(ctx, twinID, name, start, end, bucket, agg, tz, qualities)
+			if err != nil {
+				return nil, err
+			}
+			if len(buckets) > 0 {
+				result[TelemetrySeriesKey{TwinID: twinID, Name: name}] = buckets
+			}
+		}
+	}
+	return result, nil
+}
+
+// CountTelemetry counts telemetry points in a time range. The count is always exact here;
+// approximate is accepted for interface compatibility.
+func (s *MemoryStore) CountTelemetry(ctx context.Context, twinID string, name string, start time.Time, end time.Time, approximate bool) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.rangeLocked(twinID, name, start, end))), nil
+}
+
+// PruneTelemetry deletes expired telemetry (see TimeSeriesStore).
+func (s *MemoryStore) PruneTelemetry(ctx context.Context, rules []RetentionRule, defaultBefore time.Time) (int64, error) {
+	type ruleKey struct{ modelID, name string }
+	cutoffs := make(map[ruleKey]time.Time, len(rules))
+	for _, rule := range rules {
+		cutoffs[ruleKey{rule.ModelID, rule.Name}] = rule.Before
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for twinID, byName := range s.telemetry {
+		modelID := ""
+		if twin, ok := s.twins[twinID]; ok {
+			modelID = twin.ModelID
+		}
+		for name, series := range byName {
+			before, ok := cutoffs[ruleKey{modelID, name}]
+			if !ok || modelID == "" {
+				before = defaultBefore
+			}
+			if before.IsZero() {
+				continue
+			}
+			// Series are sorted by ts, so the expired points are a prefix
+			n := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(before) })
+			if n == 0 {
+				continue
+			}
+			deleted += int64(n)
+			for ts := range s.bucketStats[twinID][name] {
+				if ts < before.UnixMicro() {
+					delete(s.bucketStats[twinID][name], ts)
+				}
+			}
+			if n == len(series) {
+				delete(byName, name)
+			} else {
+				byName[name] = append([]*TelemetryRecord(nil), series[n:]...)
+			}
+		}
+		if len(byName) == 0 {
+			delete(s.telemetry, twinID)
+		}
+	}
+	return deleted, nil
+}
+
+// QueryTelemetryGaps returns the gaps longer than threshold between consecutive points, treating
+// start and end as boundaries (see TimeSeriesStore).
+func (s *MemoryStore) QueryTelemetryGaps(ctx context.Context, twinID string, name string, start time.Time, end time.Time, threshold time.Duration) ([]*TelemetryGap, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("%w: gap threshold must be positive", ErrValidation)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	gaps := []*TelemetryGap{}
+	prev, end := dbTime(start), dbTime(end)
+	for _, rec := range s.rangeLocked(twinID, name, start, end) {
+		if rec.Timestamp.Sub(prev) > threshold {
+			gaps = append(gaps, &TelemetryGap{Start: prev, End: rec.Timestamp})
+		}
+		prev = rec.Timestamp
+	}
+	if end.Sub(prev) > threshold {
+		gaps = append(gaps, &TelemetryGap{Start: prev, End: end})
+	}
+	return gaps, nil
+}
+
+// QueryLatestTelemetry retrieves the most recent telemetry value per name (all names when names is empty).
+func (s *MemoryStore) QueryLatestTelemetry(ctx context.Context, twinID string, names []string) (map[string]*TelemetryRecord, error) {
+	names, none := latestTelemetryNames(names)
+	if none {
+		return make(map[string]*TelemetryRecord), nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byName := s.telemetry[twinID]
+	latestValues := make(map[string]*TelemetryRecord)
+	add := func(name string) {
+		if series := byName[name]; len(series) > 0 {
+			latestValues[name] = copyRecord(series[len(series)-1])
+		}
+	}
+
+	if len(names) > 0 {
+		for _, name := range names {
+			add(name)
+		}
+	} else {
+		for name := range byName {
+			add(name)
+		}
+	}
+	return latestValues, nil
+}
+
+// SummarizeModelTelemetry aggregates the telemetry of the model's first maxTwins matching twins.
+func (s *MemoryStore) SummarizeModelTelemetry(ctx context.Context, modelID string, tags map[string]string, maxTwins int) (*ModelTelemetrySummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := []string{}
+	for id, t := range s.twins {
+		if t.ModelID == modelID && t.HasTags(tags) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	summary := &ModelTelemetrySummary{ModelID: modelID, Twins: len(ids), Metrics: []*TelemetryMetricSummary{}}
+	if len(ids) > maxTwins {
+		ids = ids[:maxTwins]
+	}
+	summary.Examined = len(ids)
+
+	metrics := make(map[string]*TelemetryMetricSummary)
+	sums := make(map[string]float64)
+	for _, id := range ids {
+		reported := false
+		for name, series := range s.telemetry[id] {
+			if len(series) == 0 {
+				continue
+			}
+			reported = true
+			summary.Points += int64(len(series))
+			latest := series[len(series)-1]
+			m, ok := metrics[name]
+			if !ok {
+				m = &TelemetryMetricSummary{Name: name}
+				metrics[name] = m
+			}
+			m.Twins++
+			if latest.Timestamp.After(m.LatestAt) {
+				m.LatestAt = latest.Timestamp
+			}
+			if latest.NumericValue != nil {
+				v := *latest.NumericValue
+				m.Numeric++
+				sums[name] += v
+				if m.Min == nil || v < *m.Min {
+					lowest := v
+					m.Min = &lowest
+				}
+				if m.Max == nil || v > *m.Max {
+					highest := v
+					m.Max = &highest
+				}
+			}
+		}
+		if reported {
+			summary.Reporting++
+		}
+	}
+	for name, m := range metrics {
+		if m.Numeric > 0 {
+			avg := sums[name] / float64(m.Numeric)
+			m.Avg = &avg
+		}
+		summary.Metrics = append(summary.Metrics, m)
+	}
+	sort.Slice(summary.Metrics, func(i, j int) bool { return summary.Metrics[i].Name < summary.Metrics[j].Name })
+	return summary, nil
+}
+
+// SummarizeModelUsage counts a model's twins and their telemetry since a time.
+func (s *MemoryStore) SummarizeModelUsage(ctx context.Context, modelID string, tags map[string]string, since time.Time) (*ModelUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usage := &ModelUsage{ModelID: modelID, TelemetrySince: since.UTC()}
+	for id, t := range s.twins {
+		if t.ModelID != modelID || !t.HasTags(tags) {
+			continue
+		}
+		usage.Twins++
+		createdAt := t.CreatedAt.UTC()
+		if usage.OldestTwinCreatedAt == nil || createdAt.Before(*usage.OldestTwinCreatedAt) {
+			oldest := createdAt
+			usage.OldestTwinCreatedAt = &oldest
+		}
+		if usage.NewestTwinCreatedAt == nil || createdAt.After(*usage.NewestTwinCreatedAt) {
+			newest := createdAt
+			usage.NewestTwinCreatedAt = &newest
+		}
+
+		var points int64
+		for _, series := range s.telemetry[id] {
+			for _, record := range series {
+				if !record.Timestamp.Before(since) {
+					points++
+				}
+			}
+		}
+		if points > 0 {
+			usage.TelemetryPoints += points
+			usage.ReportingTwins++
+		}
+	}
+	return usage, nil
+}
+
+// ListTelemetryNames returns the distinct telemetry names stored for a twin, sorted.
+func (s *MemoryStore) ListTelemetryNames(ctx context.Context, twinID string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.telemetry[twinID]))
+	for name, series := range s.telemetry[twinID] {
+		if len(series) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
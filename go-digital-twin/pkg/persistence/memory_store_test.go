@@ -0,0 +1,13 @@
+// pkg/persistence/memory_store_test.go
+package persistence_test
+
+import (
+	"testing"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.RunStoreTests(t, func() persistence.Store { return persistence.NewMemoryStore() })
+}
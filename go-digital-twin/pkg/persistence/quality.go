@@ -0,0 +1,127 @@
+// pkg/persistence/quality.go
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Quality is the data-quality code a sensor attaches to a reading (as in OPC UA / SCADA systems).
+// It is stored as a SMALLINT (sql/008_add_telemetry_quality.sql) and rendered as a string in JSON.
+// The zero value is QualityGood, so records written without a quality are good.
+type Quality int16
+
+const (
+	QualityGood      Quality = 0
+	QualityUncertain Quality = 1
+	QualityBad       Quality = 2
+)
+
+// qualityNames maps codes to their wire names.
+var qualityNames = map[Quality]string{
+	QualityGood:      "good",
+	QualityUncertain: "uncertain",
+	QualityBad:       "bad",
+}
+
+// String returns the wire name ("good", "uncertain", "bad").
+func (q Quality) String() string {
+	if name, ok := qualityNames[q]; ok {
+		return name
+	}
+	return fmt.Sprintf("Quality(%d)", int16(q))
+}
+
+// Valid reports whether q is one of the defined codes.
+func (q Quality) Valid() bool {
+	_, ok := qualityNames[q]
+	return ok
+}
+
+// ParseQuality parses a wire name (case-insensitive). Unknown names return ErrValidation.
+func ParseQuality(s string) (Quality, error) {
+	for q, name := range qualityNames {
+		if strings.EqualFold(s, name) {
+			return q, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unknown quality '%s' (expected good, uncertain or bad)", ErrValidation, s)
+}
+
+// ParseQualityList parses a comma-separated list such as "good,uncertain" (used by query filters).
+func ParseQualityList(s string) ([]Quality, error) {
+	var qualities []Quality
+	for _, part := range strings.Split(s, ",") {
+		q, err := ParseQuality(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		qualities = append(qualities, q)
+	}
+	return qualities, nil
+}
+
+// MarshalJSON renders the quality as its wire name.
+func (q Quality) MarshalJSON() ([]byte, error) {
+	if !q.Valid() {
+		return nil, fmt.Errorf("invalid telemetry quality %d", int16(q))
+	}
+	return json.Marshal(q.String())
+}
+
+// UnmarshalJSON accepts the wire name.
+func (q *Quality) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("quality must be a string (good, uncertain or bad): %w", err)
+	}
+	parsed, err := ParseQuality(s)
+	if err != nil {
+		return err
+	}
+	*q = parsed
+	return nil
+}
+
+// QualityCounts breaks a bucket's points down by quality, so clients can weight or discount
+// buckets dominated by uncertain/bad readings.
+type QualityCounts struct {
+	Good      int64 `json:"good"`
+	Uncertain int64 `json:"uncertain"`
+	Bad       int64 `json:"bad"`
+}
+
+// add counts one point of quality q.
+func (c *QualityCounts) add(q Quality) {
+	switch q {
+	case QualityGood:
+		c.Good++
+	case QualityUncertain:
+		c.Uncertain++
+	case QualityBad:
+		c.Bad++
+	}
+}
+
+// qualityCodes converts a filter to the SMALLINT codes used in SQL.
+func qualityCodes(qualities []Quality) []int16 {
+	codes := make([]int16, len(qualities))
+	for i, q := range qualities {
+		codes[i] = int16(q)
+	}
+	return codes
+}
+
+// matchesQuality reports whether q passes the filter (an empty filter matches everything).
+func matchesQuality(q Quality, qualities []Quality) bool {
+	if len(qualities) == 0 {
+		return true
+	}
+	for _, want := range qualities {
+		if q == want {
+			return true
+		}
+	}
+	return false
+}
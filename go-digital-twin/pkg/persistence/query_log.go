@@ -0,0 +1,247 @@
+// pkg/persistence/query_log.go
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Supported values for QueryLogConfig.Mode (DB_QUERY_LOG).
+const (
+	QueryLogOff     = "off"     // No query logging; the default, and the setting for production
+	QueryLogSlow    = "log"     // Log the text and duration of queries slower than MinDuration
+	QueryLogExplain = "explain" // Like log, plus the EXPLAIN plan of each slow statement
+)
+
+const (
+	// explainInterval is how long a statement's plan is not logged again after it was explained,
+	// so a hot slow query doesn't flood the log with the same plan.
+	explainInterval = 10 * time.Minute
+
+	// maxExplainedStatements bounds the statements remembered for explainInterval.
+	maxExplainedStatements = 1000
+
+	// explainTimeout bounds one EXPLAIN (it plans but doesn't run the statement, so it is quick).
+	explainTimeout = 5 * time.Second
+)
+
+// QueryLogConfig enables a debugging aid for the PostgreSQL backends: statements slower than
+// MinDuration are logged with their duration, and in explain mode with their query plan, to find
+// the queries a growing table needs an index for. The mode is meant for investigations; it costs
+// an extra round trip per slow statement and logs SQL text (never the arguments).
+type QueryLogConfig struct {
+	Mode        string        // One of the QueryLog* constants; empty means QueryLogOff
+	MinDuration time.Duration // Statements faster than this aren't logged; zero logs every statement
+}
+
+// enabled reports whether statements are logged at all.
+func (c QueryLogConfig) enabled() bool {
+	return c.Mode == QueryLogSlow || c.Mode == QueryLogExplain
+}
+
+// explainCtxKey marks the context of the tracer's own EXPLAIN statements, which aren't traced.
+type explainCtxKey struct{}
+
+// queryStartCtxKey carries the start time and statement from TraceQueryStart to TraceQueryEnd.
+type queryStartCtxKey struct{}
+
+type queryStart struct {
+	at   time.Time
+	sql  string
+	args []any
+}
+
+// queryLogger is the pgx.QueryTracer behind QueryLogConfig. TraceQueryEnd runs while the
+// connection is still busy with the statement, so plans are fetched with another pooled
+// connection in the background, one at a time: a slow-query storm is not answered with a storm
+// of EXPLAINs (plans that can't be fetched right away are dropped).
+type queryLogger struct {
+	cfg      QueryLogConfig
+	pool     *pgxpool.Pool // Set once the pool exists; nil until then (no EXPLAINs meanwhile)
+	explains chan struct{} // Semaphore of the background EXPLAIN
+
+	mu        sync.Mutex
+	explained map[string]time.Time // Statement -> when its plan was last logged
+}
+
+func newQueryLogger(cfg QueryLogConfig) *queryLogger {
+	return &queryLogger{cfg: cfg, explains: make(chan struct{}, 1), explained: make(map[string]time.Time)}
+}
+
+// TraceQueryStart records when the statement started.
+func (q *queryLogger) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if ctx.Value(explainCtxKey{}) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, queryStartCtxKey{}, queryStart{at: time.Now(), sql: data.SQL, args: data.Args})
+}
+
+// TraceQueryEnd logs the statement if it was slow, and in explain mode queues its plan.
+func (q *queryLogger) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartCtxKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start.at)
+	if elapsed < q.cfg.MinDuration {
+		return
+	}
+
+	statement := compactSQL(start.sql)
+	outcome := data.CommandTag.String()
+	if data.Err != nil {
+		outcome = "error: " + data.Err.Error()
+	}
+	log.Printf("DEBUG: Query took %s (%s, %d args): %s", elapsed.Round(time.Microsecond), outcome, len(start.args), statement)
+
+	if q.cfg.Mode == QueryLogExplain && q.pool != nil && q.shouldExplain(statement) {
+		select {
+		case q.explains <- struct{}{}:
+			go func() {
+				defer func() { <-q.explains }()
+				q.explain(statement, start.sql, start.args)
+			}()
+		default: // An EXPLAIN is already running; this plan is skipped
+		}
+	}
+}
+
+// explainable matches the statements EXPLAIN accepts.
+var explainable = regexp.MustCompile(`(?i)^\s*(WITH|SELECT|INSERT|UPDATE|DELETE)\b`)
+
+// shouldExplain reports whether statement's plan is due and, if so, notes it as explained.
+func (q *queryLogger) shouldExplain(statement string) bool {
+	if !explainable.MatchString(statement) {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	if last, ok := q.explained[statement]; ok && now.Sub(last) < explainInterval {
+		return false
+	}
+	if len(q.explained) >= maxExplainedStatements {
+		for k, last := range q.explained {
+			if now.Sub(last) >= explainInterval {
+				delete(q.explained, k)
+			}
+		}
+		if len(q.explained) >= maxExplainedStatements {
+			return false
+		}
+	}
+	q.explained[statement] = now
+	return true
+}
+
+// explain logs the plan of sql with its original arguments. Plain EXPLAIN only plans the
+// statement, so explaining writes is harmless (EXPLAIN ANALYZE would run them).
+func (q *queryLogger) explain(statement, sql string, args []any) {
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), explainCtxKey{}, true), explainTimeout)
+	defer cancel()
+
+	rows, err := q.pool.Query(ctx, "EXPLAIN "+sql, args...)
+	if err != nil {
+		log.Printf("DEBUG: Failed to explain query %s: %v", statement, err)
+		return
+	}
+	plan, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Printf("DEBUG: Failed to explain query %s: %v", statement, err)
+		return
+	}
+	log.Printf("DEBUG: Query plan of %s:\n  %s", statement, strings.Join(plan, "\n  "))
+}
+
+// compactSQL collapses the whitespace of sql to keep log lines on one line.
+func compactSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
+
+// recommendedIndex is an index the store's queries rely on as tables grow.
+type recommendedIndex struct {
+	table   string
+	columns string // Prefix of the index's column list as pg_indexes.indexdef renders it
+	using   string // Index method
+	purpose string
+	create  string // Statement creating it (in sql/*.sql)
+}
+
+// recommendedIndexes are the indexes checked by adviseIndexes at startup. The migrations in
+// sql/*.sql create all of them; they go missing when a schema is built by hand or an index is
+// dropped to speed up a bulk load and never restored:
+//
+//   - telemetry (twin_id, name, ts): latest values, history, aggregates and deduplicated writes
+//     all look up one twin's series by name and time range (sql/003, unique in sql/005).
+//   - twin_instances USING GIN (tags): tag selectors of listings, bulk operations and scoped keys
+//     (sql/002).
+//   - twin_instances (model_id): listing a model's twins, the model delete check and the model
+//     telemetry summary (sql/002).
+var recommendedIndexes = []recommendedIndex{
+	{
+		table: "telemetry", columns: "(twin_id, name, ts", using: "btree",
+		purpose: "telemetry lookups by twin, name and time",
+		create:  "CREATE INDEX idx_telemetry_twin_name_ts ON telemetry (twin_id, name, ts DESC)",
+	},
+	{
+		table: "twin_instances", columns: "(tags)", using: "gin",
+		purpose: "tag selectors",
+		create:  "CREATE INDEX idx_twin_instances_tags ON twin_instances USING GIN (tags)",
+	},
+	{
+		table: "twin_instances", columns: "(model_id", using: "btree",
+		purpose: "twin listings by model",
+		create:  "CREATE INDEX idx_twin_instances_model_id ON twin_instances (model_id)",
+	},
+}
+
+// adviseIndexes logs a warning for each recommended index the database lacks. Failing to read the
+// catalog is only logged: the advice is not worth refusing to start over.
+func (s *PostgresModelStore) adviseIndexes(ctx context.Context) {
+	rows, err := s.pool.Query(ctx, `SELECT tablename, indexdef FROM pg_indexes WHERE schemaname = ANY (current_schemas(false))`)
+	if err != nil {
+		log.Printf("WARN: Could not check the recommended indexes: %v", err)
+		return
+	}
+	defs := make(map[string][]string)
+	for rows.Next() {
+		var table, def string
+		if err := rows.Scan(&table, &def); err != nil {
+			rows.Close()
+			log.Printf("WARN: Could not check the recommended indexes: %v", err)
+			return
+		}
+		defs[table] = append(defs[table], def)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("WARN: Could not check the recommended indexes: %v", err)
+		return
+	}
+
+	for _, idx := range recommendedIndexes {
+		if !hasIndex(defs[idx.table], idx.using, idx.columns) {
+			log.Printf("WARN: Table '%s' lacks the recommended index for %s; those queries slow down as it grows. Create it with: %s",
+				idx.table, idx.purpose, idx.create)
+		}
+	}
+}
+
+// hasIndex reports whether one of defs (pg_indexes.indexdef values) indexes columns with method.
+func hasIndex(defs []string, method, columns string) bool {
+	want := fmt.Sprintf("USING %s %s", method, columns)
+	for _, def := range defs {
+		if strings.Contains(def, want) {
+			return true
+		}
+	}
+	return false
+}
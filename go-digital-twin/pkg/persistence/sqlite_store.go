@@ -0,0 +1,2668 @@
+// pkg/persistence/sqlite_store.go
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite" // Pure-Go driver (no cgo), registered as "sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/geo"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+)
+
+// --- Ensure SQLiteStore implements the combined Store interface ---
+var _ Store = (*SQLiteStore)(nil) // Compile-time check
+
+// DefaultSQLitePath is the database file used when STORE_BACKEND=sqlite has no DATABASE_DSN.
+const DefaultSQLitePath = "digital_twin.db"
+
+// SQLiteStore is a Store backed by an embedded SQLite database file, for edge deployments and
+// local development without PostgreSQL. The schema mirrors sql/*.sql with SQLite types:
+// JSONB columns are JSON TEXT, timestamps are INTEGER microseconds since the Unix epoch (UTC,
+// the same precision as TIMESTAMPTZ) and telemetry is a regular table keyed by (twin_id, name, ts).
+// There is no time_bucket()/last(): aggregation buckets rows in Go (bucketAggregator) and latest
+// values are read per name with ORDER BY ts DESC LIMIT 1.
+type SQLiteStore struct {
+	db           *sql.DB
+	appUpdatedAt bool // Full updates keep the caller's UpdatedAt (Config.UpdatedAtSource)
+}
+
+// sqliteMigrations create and upgrade the schema; PRAGMA user_version records how many ran.
+// Only ever append to this list.
+var sqliteMigrations = []string{
+	// 1: initial schema (sql/001-009 equivalent)
+	`
+    CREATE TABLE twin_models (
+        id TEXT PRIMARY KEY,
+        display_name TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        category TEXT NOT NULL DEFAULT '',
+        properties TEXT NOT NULL DEFAULT '{}',
+        allowed_telemetry_names TEXT NOT NULL DEFAULT '[]',
+        created_at INTEGER NOT NULL,
+        updated_at INTEGER NOT NULL
+    );
+
+    CREATE TABLE twin_instances (
+        id TEXT PRIMARY KEY,
+        model_id TEXT NOT NULL REFERENCES twin_models(id) ON DELETE RESTRICT,
+        reported_properties TEXT NOT NULL DEFAULT '{}',
+        desired_properties TEXT NOT NULL DEFAULT '{}',
+        tags TEXT NOT NULL DEFAULT '{}',
+        created_at INTEGER NOT NULL,
+        updated_at INTEGER NOT NULL
+    );
+    CREATE INDEX idx_twin_instances_model_id ON twin_instances(model_id);
+
+    CREATE TABLE twin_templates (
+        id TEXT PRIMARY KEY,
+        display_name TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        model_id TEXT NOT NULL,
+        desired_properties TEXT NOT NULL DEFAULT '{}',
+        tags TEXT NOT NULL DEFAULT '{}',
+        created_at INTEGER NOT NULL,
+        updated_at INTEGER NOT NULL
+    );
+
+    CREATE TABLE telemetry (
+        ts INTEGER NOT NULL,
+        twin_id TEXT NOT NULL,
+        name TEXT NOT NULL,
+        value_numeric REAL,
+        value_string TEXT,
+        value_boolean INTEGER,
+        quality INTEGER NOT NULL DEFAULT 0 CHECK (quality IN (0, 1, 2)),
+        PRIMARY KEY (twin_id, name, ts)
+    ) WITHOUT ROWID;`,
+
+	// 2: twin metadata (sql/010)
+	`ALTER TABLE twin_instances ADD COLUMN metadata TEXT NOT NULL DEFAULT '{}';`,
+
+	// 3: per-model telemetry name mappings (sql/011)
+	`ALTER TABLE twin_models ADD COLUMN telemetry_name_mappings TEXT NOT NULL DEFAULT '{}';`,
+
+	// 4: model derived properties (sql/012)
+	`ALTER TABLE twin_models ADD COLUMN derived_properties TEXT NOT NULL DEFAULT '{}';`,
+
+	// 5: model change history (sql/013)
+	`CREATE TABLE model_versions (
+        model_id TEXT NOT NULL,
+        version INTEGER NOT NULL,
+        change TEXT NOT NULL CHECK (change IN ('baseline', 'created', 'updated', 'deleted')),
+        definition TEXT NOT NULL,
+        changed_at INTEGER NOT NULL,
+        changed_by TEXT NOT NULL DEFAULT '',
+        PRIMARY KEY (model_id, version)
+    ) WITHOUT ROWID;`,
+
+	// 6: model telemetry definitions (sql/014)
+	`ALTER TABLE twin_models ADD COLUMN telemetry_definitions TEXT NOT NULL DEFAULT '{}';`,
+	// 7: twin locations (sql/015)
+	`
+    ALTER TABLE twin_instances ADD COLUMN location_lat REAL;
+    ALTER TABLE twin_instances ADD COLUMN location_lng REAL;
+    CREATE INDEX idx_twin_instances_location ON twin_instances (location_lat, location_lng) WHERE location_lat IS NOT NULL;
+    `,
+	// 8: model type stability of reported properties (sql/016)
+	`ALTER TABLE twin_models ADD COLUMN strict_reported_types INTEGER NOT NULL DEFAULT 0;`,
+	// 9: model tag schemas (sql/017)
+	`ALTER TABLE twin_models ADD COLUMN tag_schema TEXT NOT NULL DEFAULT '{}';`,
+	// 10: device token revocation list (sql/018)
+	`CREATE TABLE revoked_tokens (
+        id TEXT PRIMARY KEY,
+        revoked_at INTEGER NOT NULL,
+        until INTEGER NOT NULL
+    ) WITHOUT ROWID;`,
+	// 11: telemetry attribution (sql/019)
+	`ALTER TABLE telemetry ADD COLUMN written_by TEXT;`,
+	// 12: transactional outbox (sql/020); triggers write the events, timestamped in microseconds
+	`
+    CREATE TABLE outbox (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        event_type TEXT NOT NULL,
+        subject_id TEXT NOT NULL,
+        model_id TEXT NOT NULL DEFAULT '',
+        occurred_at INTEGER NOT NULL,
+        attempts INTEGER NOT NULL DEFAULT 0,
+        claimed_until INTEGER,
+        sent_at INTEGER
+    );
+    CREATE INDEX idx_outbox_pending ON outbox (id) WHERE sent_at IS NULL;
+    CREATE TRIGGER outbox_twin_created AFTER INSERT ON twin_instances BEGIN
+        INSERT INTO outbox (event_type, subject_id, model_id, occurred_at)
+        VALUES ('twin.created', NEW.id, NEW.model_id, CAST(unixepoch('subsec') * 1000000 AS INTEGER));
+    END;
+    CREATE TRIGGER outbox_twin_updated AFTER UPDATE ON twin_instances BEGIN
+        INSERT INTO outbox (event_type, subject_id, model_id, occurred_at)
+        VALUES ('twin.updated', NEW.id, NEW.model_id, CAST(unixepoch('subsec') * 1000000 AS INTEGER));
+    END;
+    CREATE TRIGGER outbox_twin_deleted AFTER DELETE ON twin_instances BEGIN
+        INSERT INTO outbox (event_type, subject_id, model_id, occurred_at)
+        VALUES ('twin.deleted', OLD.id, OLD.model_id, CAST(unixepoch('subsec') * 1000000 AS INTEGER));
+    END;
+    CREATE TRIGGER outbox_model_created AFTER INSERT ON twin_models BEGIN
+        INSERT INTO outbox (event_type, subject_id, model_id, occurred_at)
+        VALUES ('model.created', NEW.id, NEW.id, CAST(unixepoch('subsec') * 1000000 AS INTEGER));
+    END;
+    CREATE TRIGGER outbox_model_updated AFTER UPDATE ON twin_models BEGIN
+        INSERT INTO outbox (event_type, subject_id, model_id, occurred_at)
+        VALUES ('model.updated', NEW.id, NEW.id, CAST(unixepoch('subsec') * 1000000 AS INTEGER));
+    END;
+    CREATE TRIGGER outbox_model_deleted AFTER DELETE ON twin_models BEGIN
+        INSERT INTO outbox (event_type, subject_id, model_id, occurred_at)
+        VALUES ('model.deleted', OLD.id, OLD.id, CAST(unixepoch('subsec') * 1000000 AS INTEGER));
+    END;
+    `,
+	// 13: webhook subscriptions and deliveries (sql/021); updates touching only the reported
+	// properties become twin.reported events
+	`
+    CREATE TABLE webhooks (
+        id TEXT PRIMARY KEY,
+        url TEXT NOT NULL,
+        event_types TEXT NOT NULL DEFAULT '[]',
+        twin_id TEXT NOT NULL DEFAULT '',
+        model_id TEXT NOT NULL DEFAULT '',
+        secret TEXT NOT NULL DEFAULT '',
+        created_at INTEGER NOT NULL,
+        updated_at INTEGER NOT NULL
+    );
+    CREATE TABLE webhook_deliveries (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        webhook_id TEXT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+        event_id INTEGER NOT NULL,
+        event_type TEXT NOT NULL,
+        subject_id TEXT NOT NULL,
+        model_id TEXT NOT NULL DEFAULT '',
+        occurred_at INTEGER NOT NULL,
+        status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+        attempts INTEGER NOT NULL DEFAULT 0,
+        next_attempt_at INTEGER NOT NULL,
+        last_status_code INTEGER NOT NULL DEFAULT 0,
+        last_error TEXT NOT NULL DEFAULT '',
+        created_at INTEGER NOT NULL,
+        updated_at INTEGER NOT NULL,
+        UNIQUE (webhook_id, event_id)
+    );
+    CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+    CREATE INDEX idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id);
+    DROP TRIGGER outbox_twin_updated;
+    CREATE TRIGGER outbox_twin_updated AFTER UPDATE ON twin_instances
+    WHEN NEW.model_id IS NOT OLD.model_id OR NEW.desired_properties IS NOT OLD.desired_properties
+        OR NEW.tags IS NOT OLD.tags OR NEW.metadata IS NOT OLD.metadata
+        OR NEW.location_lat IS NOT OLD.location_lat OR NEW.location_lng IS NOT OLD.location_lng
+    BEGIN
+        INSERT INTO outbox (event_type, subject_id, model_id, occurred_at)
+        VALUES ('twin.updated', NEW.id, NEW.model_id, CAST(unixepoch('subsec') * 1000000 AS INTEGER));
+    END;
+    CREATE TRIGGER outbox_twin_reported AFTER UPDATE ON twin_instances
+    WHEN NEW.model_id IS OLD.model_id AND NEW.desired_properties IS OLD.desired_properties
+        AND NEW.tags IS OLD.tags AND NEW.metadata IS OLD.metadata
+        AND NEW.location_lat IS OLD.location_lat AND NEW.location_lng IS OLD.location_lng
+    BEGIN
+        INSERT INTO outbox (event_type, subject_id, model_id, occurred_at)
+        VALUES ('twin.reported', NEW.id, NEW.model_id, CAST(unixepoch('subsec') * 1000000 AS INTEGER));
+    END;
+    `,
+	// 14: alert rules and their per-twin states (sql/022); alert events name their rule
+	`
+    ALTER TABLE outbox ADD COLUMN rule_id TEXT NOT NULL DEFAULT '';
+    ALTER TABLE webhook_deliveries ADD COLUMN rule_id TEXT NOT NULL DEFAULT '';
+    CREATE TABLE alert_rules (
+        id TEXT PRIMARY KEY,
+        description TEXT NOT NULL DEFAULT '',
+        model_id TEXT NOT NULL DEFAULT '',
+        twin_id TEXT NOT NULL DEFAULT '',
+        metric TEXT NOT NULL,
+        operator TEXT NOT NULL CHECK (operator IN ('>', '>=', '<', '<=', '==', '!=')),
+        value REAL NOT NULL,
+        duration TEXT NOT NULL DEFAULT '',
+        enabled INTEGER NOT NULL DEFAULT 1,
+        created_at INTEGER NOT NULL,
+        updated_at INTEGER NOT NULL
+    );
+    CREATE TABLE alert_states (
+        rule_id TEXT NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+        twin_id TEXT NOT NULL,
+        state TEXT NOT NULL CHECK (state IN ('pending', 'firing', 'resolved')),
+        since INTEGER NOT NULL,
+        value REAL NOT NULL,
+        updated_at INTEGER NOT NULL,
+        PRIMARY KEY (rule_id, twin_id)
+    ) WITHOUT ROWID;
+    `,
+	// 15: twin display names and descriptions (sql/023); changing them is a twin.updated
+	`
+    ALTER TABLE twin_instances ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+    ALTER TABLE twin_instances ADD COLUMN description TEXT NOT NULL DEFAULT '';
+    CREATE INDEX idx_twin_instances_display_name ON twin_instances (display_name, id);
+    DROP TRIGGER outbox_twin_updated;
+    DROP TRIGGER outbox_twin_reported;
+    CREATE TRIGGER outbox_twin_updated AFTER UPDATE ON twin_instances
+    WHEN NEW.model_id IS NOT OLD.model_id OR NEW.desired_properties IS NOT OLD.desired_properties
+        OR NEW.tags IS NOT OLD.tags OR NEW.metadata IS NOT OLD.metadata
+        OR NEW.location_lat IS NOT OLD.location_lat OR NEW.location_lng IS NOT OLD.location_lng
+        OR NEW.display_name IS NOT OLD.display_name OR NEW.description IS NOT OLD.description
+    BEGIN
+        INSERT INTO outbox (event_type, subject_id, model_id, occurred_at)
+        VALUES ('twin.updated', NEW.id, NEW.model_id, CAST(unixepoch('subsec') * 1000000 AS INTEGER));
+    END;
+    CREATE TRIGGER outbox_twin_reported AFTER UPDATE ON twin_instances
+    WHEN NEW.model_id IS OLD.model_id AND NEW.desired_properties IS OLD.desired_properties
+        AND NEW.tags IS OLD.tags AND NEW.metadata IS OLD.metadata
+        AND NEW.location_lat IS OLD.location_lat AND NEW.location_lng IS OLD.location_lng
+        AND NEW.display_name IS OLD.display_name AND NEW.description IS OLD.description
+    BEGIN
+        INSERT INTO outbox (event_type, subject_id, model_id, occurred_at)
+        VALUES ('twin.reported', NEW.id, NEW.model_id, CAST(unixepoch('subsec') * 1000000 AS INTEGER));
+    END;
+    `,
+	// 16: downsampling bucket statistics (sql/025)
+	`
+    ALTER TABLE telemetry ADD COLUMN bucket_count INTEGER;
+    ALTER TABLE telemetry ADD COLUMN bucket_sum REAL;
+    ALTER TABLE telemetry ADD COLUMN bucket_min REAL;
+    ALTER TABLE telemetry ADD COLUMN bucket_max REAL;
+    `,
+}
+
+// NewSQLiteStore opens (creating if needed) the SQLite database at path and applies pending
+// schema migrations. path is a file name or a "file:" URI; ":memory:" gives a throwaway database.
+func NewSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	if path == "" {
+		path = DefaultSQLitePath
+	}
+	log.Printf("INFO: Opening SQLite database: %s", path)
+
+	// Foreign keys are off by default in SQLite; busy_timeout makes concurrent writers wait
+	// instead of failing with SQLITE_BUSY. Every transaction here writes, so they take the write
+	// lock up front (BEGIN IMMEDIATE): a deferred transaction that reads first can't wait for the
+	// lock when upgrading and would fail instead.
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite", path+sep+"_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("unable to open SQLite database: %w", err)
+	}
+	if strings.Contains(path, ":memory:") || strings.Contains(path, "mode=memory") {
+		db.SetMaxOpenConns(1) // Every connection would otherwise get its own empty database
+	}
+
+	s := &SQLiteStore{db: db}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Println("INFO: SQLite database ready.")
+	return s, nil
+}
+
+// migrate enables WAL (readers don't block the writer) and runs the migrations not yet applied.
+func (s *SQLiteStore) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `PRAGMA journal_mode = WAL`); err != nil {
+		return fmt.Errorf("failed to enable SQLite WAL mode: %w", err)
+	}
+
+	var version int
+	if err := s.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read SQLite schema version: %w", err)
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin SQLite migration %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply SQLite migration %d: %w", i+1, err)
+		}
+		// PRAGMA doesn't take bind parameters; i is not user input
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record SQLite migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit SQLite migration %d: %w", i+1, err)
+		}
+		log.Printf("INFO: Applied SQLite schema migration %d", i+1)
+	}
+	return nil
+}
+
+// Ping checks the database file can still be reached.
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() {
+	log.Println("INFO: Closing SQLite database.")
+	if err := s.db.Close(); err != nil {
+		log.Printf("WARN: Failed to close SQLite database: %v", err)
+	}
+}
+
+// --- Helpers ---
+
+// sqliteTime converts a timestamp to the INTEGER column representation (µs since the epoch).
+func sqliteTime(t time.Time) int64 {
+	return t.UnixMicro()
+}
+
+// fromSQLiteTime converts an INTEGER timestamp column back to a UTC time.
+func fromSQLiteTime(us int64) time.Time {
+	return time.UnixMicro(us).UTC()
+}
+
+// sqliteErrorCode returns the extended SQLite result code of err, or 0 if it isn't a SQLite error.
+func sqliteErrorCode(err error) int {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code()
+	}
+	return 0
+}
+
+// isUniqueViolation reports whether err is a PRIMARY KEY or UNIQUE constraint failure.
+func isUniqueViolation(err error) bool {
+	code := sqliteErrorCode(err)
+	return code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || code == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// isForeignKeyViolation reports whether err is a FOREIGN KEY constraint failure. ON DELETE RESTRICT
+// is enforced by an internal trigger, so those failures carry SQLITE_CONSTRAINT_TRIGGER instead.
+func isForeignKeyViolation(err error) bool {
+	switch sqliteErrorCode(err) {
+	case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		return true
+	case sqlite3.SQLITE_CONSTRAINT_TRIGGER:
+		return strings.Contains(err.Error(), "FOREIGN KEY")
+	}
+	return false
+}
+
+// jsonObjectText marshals a map for a JSON TEXT column; nil maps become '{}' like the JSONB defaults.
+func jsonObjectText(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if string(data) == "null" {
+		return "{}", nil
+	}
+	return string(data), nil
+}
+
+// jsonPropertiesText marshals a property map for a TEXT column: "null" when it is nil (unset).
+func jsonPropertiesText(props map[string]interface{}) (string, error) {
+	if props == nil {
+		return "null", nil
+	}
+	return jsonObjectText(props)
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// --- ModelStore Methods ---
+
+// sqliteModelColumns is the column list shared by all model SELECTs; keep in sync with scanSQLiteModel.
+const sqliteModelColumns = `id, display_name, description, category, properties, allowed_telemetry_names, telemetry_name_mappings, derived_properties, telemetry_definitions, strict_reported_types, tag_schema, created_at, updated_at`
+
+// scanSQLiteModel reads a twin model row.
+func scanSQLiteModel(scanner rowScanner) (*model.TwinModel, error) {
+	m := &model.TwinModel{}
+	var properties, allowedNames, mappings, derived, telemetry, tagSchema string
+	var createdAt, updatedAt int64
+	if err := scanner.Scan(&m.ID, &m.DisplayName, &m.Description, &m.Category, &properties, &allowedNames, &mappings, &derived, &telemetry, &m.StrictReportedTypes, &tagSchema, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	if properties != "" && properties != "{}" {
+		if err := json.Unmarshal([]byte(properties), &m.Properties); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal model properties: %w", err)
+		}
+	}
+	if allowedNames != "" && allowedNames != "[]" {
+		if err := json.Unmarshal([]byte(allowedNames), &m.AllowedTelemetryNames); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal model allowed telemetry names: %w", err)
+		}
+	}
+	if mappings != "" && mappings != "{}" {
+		if err := json.Unmarshal([]byte(mappings), &m.TelemetryNameMappings); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal model telemetry name mappings: %w", err)
+		}
+	}
+	if derived != "" && derived != "{}" {
+		if err := json.Unmarshal([]byte(derived), &m.DerivedProperties); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal model derived properties: %w", err)
+		}
+	}
+	if telemetry != "" && telemetry != "{}" {
+		if err := json.Unmarshal([]byte(telemetry), &m.Telemetry); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal model telemetry definitions: %w", err)
+		}
+	}
+	if tagSchema != "" && tagSchema != "{}" {
+		if err := json.Unmarshal([]byte(tagSchema), &m.TagSchema); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal model tag schema: %w", err)
+		}
+	}
+	m.CreatedAt = fromSQLiteTime(createdAt)
+	m.UpdatedAt = fromSQLiteTime(updatedAt)
+	return m, nil
+}
+
+// sqliteModelJSON holds the model's JSON columns as text.
+type sqliteModelJSON struct {
+	properties, allowedNames, mappings, derived, telemetry, tagSchema string
+}
+
+// marshalSQLiteModel marshals the model's JSON columns (properties, allowed_telemetry_names,
+// telemetry_name_mappings, derived_properties, telemetry_definitions, tag_schema).
+func marshalSQLiteModel(m *model.TwinModel) (cols sqliteModelJSON, err error) {
+	if cols.properties, err = jsonObjectText(m.Properties); err != nil {
+		return cols, fmt.Errorf("failed to marshal properties for model '%s': %w", m.ID, err)
+	}
+	data, err := json.Marshal(allowedTelemetryNames(m))
+	if err != nil {
+		return cols, fmt.Errorf("failed to marshal allowed telemetry names for model '%s': %w", m.ID, err)
+	}
+	cols.allowedNames = string(data)
+	if cols.mappings, err = jsonObjectText(m.TelemetryNameMappings); err != nil {
+		return cols, fmt.Errorf("failed to marshal telemetry name mappings for model '%s': %w", m.ID, err)
+	}
+	if cols.derived, err = jsonObjectText(m.DerivedProperties); err != nil {
+		return cols, fmt.Errorf("failed to marshal derived properties for model '%s': %w", m.ID, err)
+	}
+	if cols.telemetry, err = jsonObjectText(m.Telemetry); err != nil {
+		return cols, fmt.Errorf("failed to marshal telemetry definitions for model '%s': %w", m.ID, err)
+	}
+	if cols.tagSchema, err = jsonObjectText(m.TagSchema); err != nil {
+		return cols, fmt.Errorf("failed to marshal tag schema for model '%s': %w", m.ID, err)
+	}
+	return cols, nil
+}
+
+// CreateModel inserts a new model and records it as the model's next version.
+func (s *SQLiteStore) CreateModel(ctx context.Context, m *model.TwinModel) error {
+	cols, err := marshalSQLiteModel(m)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin model insert: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	query := `
+        INSERT INTO twin_models (` + sqliteModelColumns + `)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        RETURNING ` + sqliteModelColumns
+	created, err := scanSQLiteModel(tx.QueryRowContext(ctx, query, m.ID, m.DisplayName, m.Description, m.Category, cols.properties, cols.allowedNames, cols.mappings, cols.derived, cols.telemetry,
+		m.StrictReportedTypes, cols.tagSchema, sqliteTime(m.CreatedAt), sqliteTime(m.UpdatedAt)))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%w: model with ID '%s' already exists", ErrConflict, m.ID)
+		}
+		return fmt.Errorf("failed to insert model: %w", err)
+	}
+	if err := recordSQLiteModelVersion(ctx, tx, created, ModelChangeCreated, created.CreatedAt); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit model insert: %w", err)
+	}
+	return nil
+}
+
+// FindModelByID retrieves a model by its ID.
+func (s *SQLiteStore) FindModelByID(ctx context.Context, id string) (*model.TwinModel, error) {
+	query := `SELECT ` + sqliteModelColumns + ` FROM twin_models WHERE id = ?`
+	m, err := scanSQLiteModel(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: model with ID '%s' not found", ErrNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to find model by ID: %w", err)
+	}
+	return m, nil
+}
+
+// ListAllModels retrieves all models ordered by ID.
+func (s *SQLiteStore) ListAllModels(ctx context.Context) ([]*model.TwinModel, error) {
+	return s.queryModels(ctx, `SELECT `+sqliteModelColumns+` FROM twin_models ORDER BY id ASC`)
+}
+
+// ListModelsByCategory retrieves models whose category matches, ignoring case.
+// SQLite's LOWER() only folds ASCII letters.
+func (s *SQLiteStore) ListModelsByCategory(ctx context.Context, category string) ([]*model.TwinModel, error) {
+	return s.queryModels(ctx, `SELECT `+sqliteModelColumns+` FROM twin_models WHERE LOWER(category) = LOWER(?) ORDER BY id ASC`, category)
+}
+
+// ListModelIDs reads only the id column of twin_models, a page at a time.
+func (s *SQLiteStore) ListModelIDs(ctx context.Context, afterID string, limit int) ([]string, error) {
+	ids, err := s.queryIDs(ctx, `SELECT id FROM twin_models WHERE id > ? ORDER BY id ASC LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list model IDs: %w", err)
+	}
+	return ids, nil
+}
+
+// queryIDs runs a single-column ID SELECT and collects the rows.
+func (s *SQLiteStore) queryIDs(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// StreamModels passes the models to fn row by row as they are read.
+func (s *SQLiteStore) StreamModels(ctx context.Context, category string, fn func(*model.TwinModel) error) error {
+	if category != "" {
+		return s.eachModel(ctx, fn, `SELECT `+sqliteModelColumns+` FROM twin_models WHERE LOWER(category) = LOWER(?) ORDER BY id ASC`, category)
+	}
+	return s.eachModel(ctx, fn, `SELECT `+sqliteModelColumns+` FROM twin_models ORDER BY id ASC`)
+}
+
+// queryModels runs a model SELECT (using sqliteModelColumns) and scans every row.
+func (s *SQLiteStore) queryModels(ctx context.Context, query string, args ...interface{}) ([]*model.TwinModel, error) {
+	models := []*model.TwinModel{}
+	err := s.eachModel(ctx, func(m *model.TwinModel) error {
+		models = append(models, m)
+		return nil
+	}, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// eachModel runs a model SELECT (using sqliteModelColumns) and passes every row to fn.
+func (s *SQLiteStore) eachModel(ctx context.Context, fn func(*model.TwinModel) error, query string, args ...interface{}) error {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query models: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m, err := scanSQLiteModel(rows)
+		if err != nil {
+			log.Printf("WARN: Failed to scan model row: %v", err)
+			continue
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating model rows: %w", err)
+	}
+	return nil
+}
+
+// ListModelCategories returns distinct categories and their model counts.
+// Categories differing only in case are grouped together.
+func (s *SQLiteStore) ListModelCategories(ctx context.Context) ([]*ModelCategory, error) {
+	query := `
+        SELECT MIN(category) AS name, COUNT(*) AS model_count
+        FROM twin_models
+        WHERE category <> ''
+        GROUP BY LOWER(category)
+        ORDER BY LOWER(category) ASC`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query model categories: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []*ModelCategory{}
+	for rows.Next() {
+		c := &ModelCategory{}
+		if err := rows.Scan(&c.Name, &c.Count); err != nil {
+			log.Printf("WARN: Failed to scan model category row: %v", err)
+			continue
+		}
+		categories = append(categories, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating model category rows: %w", err)
+	}
+	return categories, nil
+}
+
+// UpdateModel updates an existing model and records the change. updated_at is set to now, or
+// kept when the application owns it (there is no trigger).
+func (s *SQLiteStore) UpdateModel(ctx context.Context, m *model.TwinModel) error {
+	cols, err := marshalSQLiteModel(m)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin model update: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	if err := ensureSQLiteModelBaseline(ctx, tx, m.ID, "update"); err != nil {
+		return err
+	}
+	query := `
+        UPDATE twin_models
+        SET display_name = ?, description = ?, category = ?, properties = ?, allowed_telemetry_names = ?,
+            telemetry_name_mappings = ?, derived_properties = ?, telemetry_definitions = ?, strict_reported_types = ?,
+            tag_schema = ?, updated_at = ?
+        WHERE id = ?
+        RETURNING ` + sqliteModelColumns
+	updated, err := scanSQLiteModel(tx.QueryRowContext(ctx, query, m.DisplayName, m.Description, m.Category, cols.properties, cols.allowedNames, cols.mappings, cols.derived, cols.telemetry,
+		m.StrictReportedTypes, cols.tagSchema, sqliteTime(updateTime(s.appUpdatedAt, m.UpdatedAt)), m.ID))
+	if err != nil {
+		return fmt.Errorf("failed to update model: %w", err)
+	}
+	if err := recordSQLiteModelVersion(ctx, tx, updated, ModelChangeUpdated, updated.UpdatedAt); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit model update: %w", err)
+	}
+	return nil
+}
+
+// DeleteModel removes a model by ID. Models still referenced by twins can't be deleted.
+// The history is kept, ending with a 'deleted' version holding the last definition.
+func (s *SQLiteStore) DeleteModel(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin model deletion: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	if err := ensureSQLiteModelBaseline(ctx, tx, id, "deletion"); err != nil {
+		return err
+	}
+	deleted, err := scanSQLiteModel(tx.QueryRowContext(ctx, `DELETE FROM twin_models WHERE id = ? RETURNING `+sqliteModelColumns, id))
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return fmt.Errorf("%w: model with ID '%s' is still referenced by twin instances", ErrConflict, id)
+		}
+		return fmt.Errorf("failed to delete model: %w", err)
+	}
+	if err := recordSQLiteModelVersion(ctx, tx, deleted, ModelChangeDeleted, time.Now()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit model deletion: %w", err)
+	}
+	return nil
+}
+
+// --- Model history ---
+
+// ensureSQLiteModelBaseline records a baseline version if the model predates its history
+// (migration 5). tx already holds the write lock, so versions can't interleave. Returns
+// ErrNotFound if the model doesn't exist; op names the change for that error.
+func ensureSQLiteModelBaseline(ctx context.Context, tx *sql.Tx, id string, op string) error {
+	current, err := scanSQLiteModel(tx.QueryRowContext(ctx, `SELECT `+sqliteModelColumns+` FROM twin_models WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: model with ID '%s' not found for %s", ErrNotFound, id, op)
+		}
+		return fmt.Errorf("failed to read model for %s: %w", op, err)
+	}
+
+	var recorded bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM model_versions WHERE model_id = ?)`, id).Scan(&recorded); err != nil {
+		return fmt.Errorf("failed to check model history: %w", err)
+	}
+	if recorded {
+		return nil
+	}
+	return recordSQLiteModelVersion(ctx, tx, current, ModelChangeBaseline, current.UpdatedAt)
+}
+
+// recordSQLiteModelVersion appends m as the model's next version, attributed to ctx's WithChangedBy name.
+func recordSQLiteModelVersion(ctx context.Context, tx *sql.Tx, m *model.TwinModel, change string, at time.Time) error {
+	definition, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal model '%s' for its history: %w", m.ID, err)
+	}
+	query := `
+        INSERT INTO model_versions (model_id, version, change, definition, changed_at, changed_by)
+        SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ?, ?, ?
+        FROM model_versions
+        WHERE model_id = ?`
+	if _, err := tx.ExecContext(ctx, query, m.ID, change, string(definition), sqliteTime(at), changedBy(ctx), m.ID); err != nil {
+		return fmt.Errorf("failed to record model version: %w", err)
+	}
+	return nil
+}
+
+// ListModelVersions lists a model's recorded versions, oldest first, without definitions.
+func (s *SQLiteStore) ListModelVersions(ctx context.Context, modelID string) ([]*ModelVersion, error) {
+	query := `
+        SELECT version, change, changed_at, changed_by
+        FROM model_versions
+        WHERE model_id = ?
+        ORDER BY version ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, modelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query model versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []*ModelVersion{}
+	for rows.Next() {
+		v := &ModelVersion{ModelID: modelID}
+		var changedAt int64
+		if err := rows.Scan(&v.Version, &v.Change, &changedAt, &v.ChangedBy); err != nil {
+			log.Printf("WARN: Failed to scan model version row: %v", err)
+			continue
+		}
+		v.ChangedAt = fromSQLiteTime(changedAt)
+		versions = append(versions, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating model version rows: %w", err)
+	}
+	return versions, nil
+}
+
+// FindModelVersion retrieves one recorded version of a model with its definition.
+func (s *SQLiteStore) FindModelVersion(ctx context.Context, modelID string, version int) (*ModelVersion, error) {
+	query := `
+        SELECT change, changed_at, changed_by, definition
+        FROM model_versions
+        WHERE model_id = ? AND version = ?`
+
+	v := &ModelVersion{ModelID: modelID, Version: version}
+	var changedAt int64
+	var definition string
+	if err := s.db.QueryRowContext(ctx, query, modelID, version).Scan(&v.Change, &changedAt, &v.ChangedBy, &definition); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: version %d of model '%s' not found", ErrNotFound, version, modelID)
+		}
+		return nil, fmt.Errorf("failed to find model version: %w", err)
+	}
+	v.ChangedAt = fromSQLiteTime(changedAt)
+	if err := json.Unmarshal([]byte(definition), &v.Model); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal model version definition: %w", err)
+	}
+	return v, nil
+}
+
+// --- TwinStore Methods ---
+
+// sqliteTwinColumns is the column list shared by all twin SELECTs; keep in sync with scanSQLiteTwin.
+const sqliteTwinColumns = `id, model_id, display_name, description, reported_properties, desired_properties, tags, metadata, location_lat, location_lng, created_at, updated_at`
+
+// scanSQLiteTwin reads a twin instance row.
+func scanSQLiteTwin(scanner rowScanner) (*model.TwinInstance, error) {
+	t := &model.TwinInstance{}
+	var reported, desired, tags, metadata string
+	var lat, lng sql.NullFloat64
+	var createdAt, updatedAt int64
+	if err := scanner.Scan(&t.ID, &t.ModelID, &t.DisplayName, &t.Description, &reported, &desired, &tags, &metadata, &lat, &lng, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	// Property maps that were never set are stored as JSON null and stay nil
+	if err := json.Unmarshal([]byte(reported), &t.ReportedProperties); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reported_properties: %w", err)
+	}
+	if err := json.Unmarshal([]byte(desired), &t.DesiredProperties); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal desired_properties: %w", err)
+	}
+	t.Tags = make(map[string]string)
+	if err := json.Unmarshal([]byte(tags), &t.Tags); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tags: %w", err)
+	}
+	if metadata != "" && metadata != "{}" { // Left nil when empty, like the other stores
+		if err := json.Unmarshal([]byte(metadata), &t.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+		}
+	}
+	if lat.Valid && lng.Valid {
+		t.Location = &model.GeoPoint{Lat: lat.Float64, Lng: lng.Float64}
+	}
+	t.CreatedAt = fromSQLiteTime(createdAt)
+	t.UpdatedAt = fromSQLiteTime(updatedAt)
+	return t, nil
+}
+
+// marshalSQLiteTwin marshals the twin's JSON columns. Nil property maps (never set) are stored as
+// JSON null, so they read back as unset rather than as an explicitly empty '{}'.
+func marshalSQLiteTwin(twin *model.TwinInstance) (reported, desired, tags, metadata string, err error) {
+	if reported, err = jsonPropertiesText(twin.ReportedProperties); err != nil {
+		return "", "", "", "", fmt.Errorf("failed to marshal reported properties for twin '%s': %w", twin.ID, err)
+	}
+	if desired, err = jsonPropertiesText(twin.DesiredProperties); err != nil {
+		return "", "", "", "", fmt.Errorf("failed to marshal desired properties for twin '%s': %w", twin.ID, err)
+	}
+	if tags, err = jsonObjectText(twin.Tags); err != nil {
+		return "", "", "", "", fmt.Errorf("failed to marshal tags for twin '%s': %w", twin.ID, err)
+	}
+	if metadata, err = jsonObjectText(twin.Metadata); err != nil {
+		return "", "", "", "", fmt.Errorf("failed to marshal metadata for twin '%s': %w", twin.ID, err)
+	}
+	return reported, desired, tags, metadata, nil
+}
+
+// CreateTwin inserts a new twin instance. The referenced model must exist.
+func (s *SQLiteStore) CreateTwin(ctx context.Context, twin *model.TwinInstance) error {
+	reported, desired, tags, metadata, err := marshalSQLiteTwin(twin)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        INSERT INTO twin_instances (` + sqliteTwinColumns + `)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	lat, lng := twinLocationArgs(twin)
+	_, err = s.db.ExecContext(ctx, query, twin.ID, twin.ModelID, twin.DisplayName, twin.Description, reported, desired, tags, metadata,
+		lat, lng, sqliteTime(twin.CreatedAt), sqliteTime(twin.UpdatedAt))
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return fmt.Errorf("%w: twin instance with ID '%s' already exists", ErrConflict, twin.ID)
+		case isForeignKeyViolation(err):
+			return fmt.Errorf("%w: model with ID '%s' not found", ErrInvalidReference, twin.ModelID)
+		}
+		return fmt.Errorf("failed to insert twin instance: %w", err)
+	}
+	return nil
+}
+
+// FindTwinByID retrieves a twin instance by ID.
+func (s *SQLiteStore) FindTwinByID(ctx context.Context, id string) (*model.TwinInstance, error) {
+	query := `SELECT ` + sqliteTwinColumns + ` FROM twin_instances WHERE id = ?`
+	twin, err := scanSQLiteTwin(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: twin instance with ID '%s' not found", ErrNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to find or scan twin instance by ID: %w", err)
+	}
+	return twin, nil
+}
+
+// TwinExists looks the ID up in the primary key without reading the row.
+func (s *SQLiteStore) TwinExists(ctx context.Context, id string) (bool, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM twin_instances WHERE id = ?)`, id).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check twin existence: %w", err)
+	}
+	return exists, nil
+}
+
+// FindTwinsByIDs retrieves the twins among ids with one IN query, ordered by ID.
+func (s *SQLiteStore) FindTwinsByIDs(ctx context.Context, ids []string) ([]*model.TwinInstance, error) {
+	if len(ids) == 0 {
+		return []*model.TwinInstance{}, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := `SELECT ` + sqliteTwinColumns + ` FROM twin_instances WHERE id IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `) ORDER BY id ASC`
+	return s.queryTwins(ctx, query, args...)
+}
+
+// ListAllTwins retrieves all twin instances ordered by ID.
+func (s *SQLiteStore) ListAllTwins(ctx context.Context) ([]*model.TwinInstance, error) {
+	return s.queryTwins(ctx, `SELECT `+sqliteTwinColumns+` FROM twin_instances ORDER BY id ASC`)
+}
+
+// ListTwinsByModel retrieves twins filtered by model ID.
+func (s *SQLiteStore) ListTwinsByModel(ctx context.Context, modelID string) ([]*model.TwinInstance, error) {
+	return s.queryTwins(ctx, `SELECT `+sqliteTwinColumns+` FROM twin_instances WHERE model_id = ? ORDER BY id ASC`, modelID)
+}
+
+// ListTwinsByModelPage lists one ID-ordered page of a model's twins after afterID.
+func (s *SQLiteStore) ListTwinsByModelPage(ctx context.Context, modelID string, afterID string, limit int) ([]*model.TwinInstance, error) {
+	query := `
+        SELECT ` + sqliteTwinColumns + `
+        FROM twin_instances
+        WHERE model_id = ? AND id > ?
+        ORDER BY id ASC
+        LIMIT ?`
+	return s.queryTwins(ctx, query, modelID, afterID, limit)
+}
+
+// ListTwinIDs reads only the id column of the matching twins, a page at a time.
+func (s *SQLiteStore) ListTwinIDs(ctx context.Context, tags map[string]string, modelID string, afterID string, limit int) ([]string, error) {
+	query, args := sqliteTwinsSelect(`id`, tags, modelID, ` AND id > ?`, afterID)
+	ids, err := s.queryIDs(ctx, query+` LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list twin IDs: %w", err)
+	}
+	return ids, nil
+}
+
+// ListTwinsByTags lists twins whose tags contain all the given pairs.
+// Each pair becomes an EXISTS over json_each(tags), the SQLite equivalent of JSONB @>.
+func (s *SQLiteStore) ListTwinsByTags(ctx context.Context, tags map[string]string, modelID string) ([]*model.TwinInstance, error) {
+	query, args := sqliteTwinsByTagsQuery(tags, modelID)
+	return s.queryTwins(ctx, query, args...)
+}
+
+// StreamTwins passes the matching twins to fn row by row as they are read.
+func (s *SQLiteStore) StreamTwins(ctx context.Context, tags map[string]string, modelID string, fn func(*model.TwinInstance) error) error {
+	query, args := sqliteTwinsByTagsQuery(tags, modelID)
+	return s.eachTwin(ctx, fn, query, args...)
+}
+
+// StreamTwinFields is StreamTwins with the JSON columns left out replaced by constants: 'null'
+// for the property maps (unset) and '{}' for tags and metadata.
+func (s *SQLiteStore) StreamTwinFields(ctx context.Context, fields []string, tags map[string]string, modelID string, fn func(*model.TwinInstance) error) error {
+	query, args := sqliteTwinsSelect(sqliteTwinFieldColumns(fields), tags, modelID, "")
+	return s.eachTwin(ctx, fn, query, args...)
+}
+
+// ListTwinsPage lists one page of the matching twins after the cursor.
+func (s *SQLiteStore) ListTwinsPage(ctx context.Context, q TwinPageQuery) ([]*model.TwinInstance, error) {
+	extra, extraArgs := "", []interface{}{}
+	if q.Search != "" {
+		extra += ` AND instr(lower(display_name), lower(?)) > 0`
+		extraArgs = append(extraArgs, q.Search)
+	}
+	order := ` ORDER BY id ASC`
+	if q.ByDisplayName {
+		order = ` ORDER BY display_name ASC, id ASC`
+		if q.AfterID != "" {
+			extra += ` AND (display_name, id) > (?, ?)`
+			extraArgs = append(extraArgs, q.AfterDisplayName, q.AfterID)
+		}
+	} else if q.AfterID != "" {
+		extra += ` AND id > ?`
+		extraArgs = append(extraArgs, q.AfterID)
+	}
+	query, args := sqliteTwinsWhere(sqliteTwinFieldColumns(q.Fields), q.Tags, q.ModelID, extra, extraArgs...)
+	return s.queryTwins(ctx, query+order+` LIMIT ?`, append(args, q.Limit)...)
+}
+
+// sqliteTwinFieldColumns projects sqliteTwinColumns to fields (see StreamTwinFields).
+func sqliteTwinFieldColumns(fields []string) string {
+	return projectTwinColumns(sqliteTwinColumns, fields, func(column string) string {
+		if column == "tags" || column == "metadata" {
+			return `'{}'`
+		}
+		return `'null'`
+	})
+}
+
+// ListOrphanedTwins lists twins whose model row is missing (possible when foreign keys were
+// off, e.g. for rows written by other tools).
+func (s *SQLiteStore) ListOrphanedTwins(ctx context.Context) ([]*model.TwinInstance, error) {
+	query := `
+        SELECT ` + sqliteTwinColumns + `
+        FROM twin_instances
+        WHERE NOT EXISTS (SELECT 1 FROM twin_models WHERE twin_models.id = twin_instances.model_id)
+        ORDER BY id ASC`
+	return s.queryTwins(ctx, query)
+}
+
+// ListTwinsInBox lists located twins inside box that match tags and modelID.
+func (s *SQLiteStore) ListTwinsInBox(ctx context.Context, box geo.Box, tags map[string]string, modelID string) ([]*model.TwinInstance, error) {
+	lngCondition := ` AND location_lng BETWEEN ? AND ?`
+	if box.MinLng > box.MaxLng { // Crosses the antimeridian
+		lngCondition = ` AND (location_lng >= ? OR location_lng <= ?)`
+	}
+	query, args := sqliteTwinsQuery(tags, modelID, ` AND location_lat BETWEEN ? AND ?`+lngCondition,
+		box.MinLat, box.MaxLat, box.MinLng, box.MaxLng)
+	return s.queryTwins(ctx, query, args...)
+}
+
+// sqliteTwinsByTagsQuery builds the twin SELECT behind ListTwinsByTags and StreamTwins.
+func sqliteTwinsByTagsQuery(tags map[string]string, modelID string) (string, []interface{}) {
+	return sqliteTwinsQuery(tags, modelID, "")
+}
+
+// sqliteTwinsQuery builds an ID-ordered twin SELECT filtered by modelID and tags, plus the extra
+// conditions (with their arguments) if any.
+func sqliteTwinsQuery(tags map[string]string, modelID string, extra string, extraArgs ...interface{}) (string, []interface{}) {
+	return sqliteTwinsSelect(sqliteTwinColumns, tags, modelID, extra, extraArgs...)
+}
+
+// sqliteTwinsSelect is sqliteTwinsQuery for the given columns.
+func sqliteTwinsSelect(columns string, tags map[string]string, modelID string, extra string, extraArgs ...interface{}) (string, []interface{}) {
+	query, args := sqliteTwinsWhere(columns, tags, modelID, extra, extraArgs...)
+	return query + ` ORDER BY id ASC`, args
+}
+
+// sqliteTwinsWhere is sqliteTwinsSelect without the ORDER BY.
+func sqliteTwinsWhere(columns string, tags map[string]string, modelID string, extra string, extraArgs ...interface{}) (string, []interface{}) {
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString(`SELECT ` + columns + ` FROM twin_instances WHERE (? = '' OR model_id = ?)` + extra)
+	args := append([]interface{}{modelID, modelID}, extraArgs...)
+	for k, v := range tags {
+		queryBuilder.WriteString(` AND EXISTS (SELECT 1 FROM json_each(twin_instances.tags) WHERE key = ? AND type = 'text' AND value = ?)`)
+		args = append(args, k, v)
+	}
+	return queryBuilder.String(), args
+}
+
+// queryTwins runs a twin SELECT (using sqliteTwinColumns) and scans every row.
+func (s *SQLiteStore) queryTwins(ctx context.Context, query string, args ...interface{}) ([]*model.TwinInstance, error) {
+	twins := []*model.TwinInstance{}
+	err := s.eachTwin(ctx, func(twin *model.TwinInstance) error {
+		twins = append(twins, twin)
+		return nil
+	}, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return twins, nil
+}
+
+// eachTwin runs a twin SELECT (using sqliteTwinColumns) and passes every row to fn.
+func (s *SQLiteStore) eachTwin(ctx context.Context, fn func(*model.TwinInstance) error, query string, args ...interface{}) error {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query twin instances: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		twin, err := scanSQLiteTwin(rows)
+		if err != nil {
+			log.Printf("WARN: Failed to scan twin instance row: %v", err)
+			continue
+		}
+		if err := fn(twin); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating twin instance rows: %w", err)
+	}
+	return nil
+}
+
+// UpdateTwin replaces the twin's model reference, properties and tags.
+func (s *SQLiteStore) UpdateTwin(ctx context.Context, twin *model.TwinInstance) error {
+	return s.updateTwin(ctx, twin, nil)
+}
+
+// UpdateTwinIfUnmodified updates the twin if updated_at still matches.
+func (s *SQLiteStore) UpdateTwinIfUnmodified(ctx context.Context, twin *model.TwinInstance, expectedUpdatedAt time.Time) error {
+	return s.updateTwin(ctx, twin, &expectedUpdatedAt)
+}
+
+// updateTwin replaces the twin's mutable fields, only while updated_at still matches
+// expectedUpdatedAt when it is non-nil (ErrPreconditionFailed otherwise).
+func (s *SQLiteStore) updateTwin(ctx context.Context, twin *model.TwinInstance, expectedUpdatedAt *time.Time) error {
+	reported, desired, tags, metadata, err := marshalSQLiteTwin(twin)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        UPDATE twin_instances
+        SET model_id = ?, display_name = ?, description = ?, reported_properties = ?, desired_properties = ?,
+            tags = ?, metadata = ?, location_lat = ?, location_lng = ?, updated_at = ?
+        WHERE id = ?`
+	lat, lng := twinLocationArgs(twin)
+	args := []interface{}{twin.ModelID, twin.DisplayName, twin.Description, reported, desired, tags, metadata, lat, lng, sqliteTime(updateTime(s.appUpdatedAt, twin.UpdatedAt)), twin.ID}
+	if expectedUpdatedAt != nil {
+		query += " AND updated_at = ?"
+		args = append(args, sqliteTime(*expectedUpdatedAt))
+	}
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return fmt.Errorf("%w: model with ID '%s' not found", ErrInvalidReference, twin.ModelID)
+		}
+		return fmt.Errorf("failed to update twin instance: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if expectedUpdatedAt != nil {
+			var exists bool
+			if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM twin_instances WHERE id = ?)`, twin.ID).Scan(&exists); err != nil {
+				return fmt.Errorf("failed to check twin instance existence: %w", err)
+			}
+			if exists {
+				return fmt.Errorf("%w: twin instance with ID '%s' was modified concurrently", ErrPreconditionFailed, twin.ID)
+			}
+		}
+		return fmt.Errorf("%w: twin instance with ID '%s' not found for update", ErrNotFound, twin.ID)
+	}
+	return nil
+}
+
+// updateTwinJSONField replaces one JSON column of a twin. If expectedUpdatedAt is non-nil the
+// update only applies while updated_at still matches it; otherwise ErrPreconditionFailed is returned.
+func (s *SQLiteStore) updateTwinJSONField(ctx context.Context, id string, fieldName string, data interface{}, expectedUpdatedAt *time.Time) error {
+	jsonData, err := jsonObjectText(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s data for twin '%s': %w", fieldName, id, err)
+	}
+
+	// fieldName is controlled internally, never user input
+	query := fmt.Sprintf(`UPDATE twin_instances SET %s = ?, updated_at = ? WHERE id = ?`, fieldName)
+	args := []interface{}{jsonData, sqliteTime(time.Now()), id}
+	if expectedUpdatedAt != nil {
+		query += " AND updated_at = ?"
+		args = append(args, sqliteTime(*expectedUpdatedAt))
+	}
+
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update twin instance %s field: %w", fieldName, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if expectedUpdatedAt != nil {
+			// Distinguish "twin is gone" from "twin changed since the client read it"
+			var exists bool
+			if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM twin_instances WHERE id = ?)`, id).Scan(&exists); err != nil {
+				return fmt.Errorf("failed to check twin instance existence: %w", err)
+			}
+			if exists {
+				return fmt.Errorf("%w: twin instance with ID '%s' was modified concurrently", ErrPreconditionFailed, id)
+			}
+		}
+		return fmt.Errorf("%w: twin instance with ID '%s' not found for %s update", ErrNotFound, id, fieldName)
+	}
+	return nil
+}
+
+// UpdateReportedProperties updates only the reported_properties field.
+func (s *SQLiteStore) UpdateReportedProperties(ctx context.Context, id string, properties map[string]interface{}) error {
+	return s.updateTwinJSONField(ctx, id, "reported_properties", properties, nil)
+}
+
+// MergeReportedProperties merges the patches in one (write-locked) transaction. SQLite's
+// json_patch follows RFC 7396 instead (null removes a key, objects merge recursively), so each
+// twin's properties are merged here like JSONB ||.
+func (s *SQLiteStore) MergeReportedProperties(ctx context.Context, patches map[string]map[string]interface{}) (map[string]time.Time, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin reported properties batch: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	now := dbTime(time.Now())
+	updated := make(map[string]time.Time, len(patches))
+	for _, id := range patchTwinIDs(patches) {
+		var current string
+		err := tx.QueryRowContext(ctx, `SELECT reported_properties FROM twin_instances WHERE id = ?`, id).Scan(&current)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read reported properties of twin '%s': %w", id, err)
+		}
+		var merged map[string]interface{}
+		if err := json.Unmarshal([]byte(current), &merged); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal reported_properties of twin '%s': %w", id, err)
+		}
+		if merged == nil {
+			merged = make(map[string]interface{}, len(patches[id]))
+		}
+		for key, v := range patches[id] {
+			merged[key] = v
+		}
+		data, err := jsonObjectText(merged)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal reported properties of twin '%s': %w", id, err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE twin_instances SET reported_properties = ?, updated_at = ? WHERE id = ?`, data, sqliteTime(now), id); err != nil {
+			return nil, fmt.Errorf("failed to merge reported properties of twin '%s': %w", id, err)
+		}
+		updated[id] = now
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit reported properties batch: %w", err)
+	}
+	return updated, nil
+}
+
+// UpdateDesiredProperties updates only the desired_properties field.
+func (s *SQLiteStore) UpdateDesiredProperties(ctx context.Context, id string, properties map[string]interface{}) error {
+	return s.updateTwinJSONField(ctx, id, "desired_properties", properties, nil)
+}
+
+// UpdateTags updates only the tags field.
+func (s *SQLiteStore) UpdateTags(ctx context.Context, id string, tags map[string]string) error {
+	return s.updateTwinJSONField(ctx, id, "tags", tags, nil)
+}
+
+// UpdateDesiredPropertiesIfUnmodified updates desired_properties if updated_at still matches.
+func (s *SQLiteStore) UpdateDesiredPropertiesIfUnmodified(ctx context.Context, id string, properties map[string]interface{}, expectedUpdatedAt time.Time) error {
+	return s.updateTwinJSONField(ctx, id, "desired_properties", properties, &expectedUpdatedAt)
+}
+
+// UpdateTagsIfUnmodified updates tags if updated_at still matches.
+func (s *SQLiteStore) UpdateTagsIfUnmodified(ctx context.Context, id string, tags map[string]string, expectedUpdatedAt time.Time) error {
+	return s.updateTwinJSONField(ctx, id, "tags", tags, &expectedUpdatedAt)
+}
+
+// UpdateMetadata updates only the metadata field.
+func (s *SQLiteStore) UpdateMetadata(ctx context.Context, id string, metadata map[string]interface{}) error {
+	return s.updateTwinJSONField(ctx, id, "metadata", metadata, nil)
+}
+
+// UpdateMetadataIfUnmodified updates metadata if updated_at still matches.
+func (s *SQLiteStore) UpdateMetadataIfUnmodified(ctx context.Context, id string, metadata map[string]interface{}, expectedUpdatedAt time.Time) error {
+	return s.updateTwinJSONField(ctx, id, "metadata", metadata, &expectedUpdatedAt)
+}
+
+// DeleteTwin removes a twin instance by ID. Like the other stores, its telemetry is kept.
+func (s *SQLiteStore) DeleteTwin(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM twin_instances WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete twin instance: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: twin instance with ID '%s' not found for deletion", ErrNotFound, id)
+	}
+	return nil
+}
+
+// DeleteTwinsByTags removes the matching twins and their telemetry in one transaction.
+func (s *SQLiteStore) DeleteTwinsByTags(ctx context.Context, tags map[string]string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("%w: a tag selector is required to delete twins in bulk", ErrValidation)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin twin bulk deletion: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString(`DELETE FROM twin_instances WHERE 1 = 1`)
+	args := []interface{}{}
+	for k, v := range tags {
+		queryBuilder.WriteString(` AND EXISTS (SELECT 1 FROM json_each(twin_instances.tags) WHERE key = ? AND type = 'text' AND value = ?)`)
+		args = append(args, k, v)
+	}
+	queryBuilder.WriteString(` RETURNING id`)
+
+	rows, err := tx.QueryContext(ctx, queryBuilder.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete twin instances by tags: %w", err)
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan deleted twin ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating deleted twin IDs: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `DELETE FROM telemetry WHERE twin_id = ?`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare telemetry deletion: %w", err)
+	}
+	defer stmt.Close()
+	for _, id := range ids {
+		if _, err := stmt.ExecContext(ctx, id); err != nil {
+			return nil, fmt.Errorf("failed to delete telemetry of twin '%s': %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit twin bulk deletion: %w", err)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// --- TemplateStore Methods ---
+
+// sqliteTemplateColumns is the column list shared by all template SELECTs; keep in sync with scanSQLiteTemplate.
+const sqliteTemplateColumns = `id, display_name, description, model_id, desired_properties, tags, created_at, updated_at`
+
+// scanSQLiteTemplate reads a twin template row.
+func scanSQLiteTemplate(scanner rowScanner) (*model.TwinTemplate, error) {
+	t := &model.TwinTemplate{}
+	var desired, tags string
+	var createdAt, updatedAt int64
+	if err := scanner.Scan(&t.ID, &t.DisplayName, &t.Description, &t.ModelID, &desired, &tags, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	t.DesiredProperties = make(map[string]interface{})
+	if err := json.Unmarshal([]byte(desired), &t.DesiredProperties); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal template desired_properties: %w", err)
+	}
+	t.Tags = make(map[string]string)
+	if err := json.Unmarshal([]byte(tags), &t.Tags); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal template tags: %w", err)
+	}
+	t.CreatedAt = fromSQLiteTime(createdAt)
+	t.UpdatedAt = fromSQLiteTime(updatedAt)
+	return t, nil
+}
+
+// CreateTemplate inserts a new twin template.
+func (s *SQLiteStore) CreateTemplate(ctx context.Context, t *model.TwinTemplate) error {
+	desired, tags, err := marshalTemplateDefaults(t)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        INSERT INTO twin_templates (` + sqliteTemplateColumns + `)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = s.db.ExecContext(ctx, query, t.ID, t.DisplayName, t.Description, t.ModelID, string(desired), string(tags),
+		sqliteTime(t.CreatedAt), sqliteTime(t.UpdatedAt))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%w: template with ID '%s' already exists", ErrConflict, t.ID)
+		}
+		return fmt.Errorf("failed to insert template: %w", err)
+	}
+	return nil
+}
+
+// FindTemplateByID retrieves a twin template by ID.
+func (s *SQLiteStore) FindTemplateByID(ctx context.Context, id string) (*model.TwinTemplate, error) {
+	query := `SELECT ` + sqliteTemplateColumns + ` FROM twin_templates WHERE id = ?`
+	t, err := scanSQLiteTemplate(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: template with ID '%s' not found", ErrNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to find template by ID: %w", err)
+	}
+	return t, nil
+}
+
+// ListAllTemplates retrieves all twin templates ordered by ID.
+func (s *SQLiteStore) ListAllTemplates(ctx context.Context) ([]*model.TwinTemplate, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sqliteTemplateColumns+` FROM twin_templates ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query templates: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []*model.TwinTemplate{}
+	for rows.Next() {
+		t, err := scanSQLiteTemplate(rows)
+		if err != nil {
+			log.Printf("WARN: Failed to scan template row: %v", err)
+			continue
+		}
+		templates = append(templates, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating template rows: %w", err)
+	}
+	return templates, nil
+}
+
+// UpdateTemplate replaces a template's mutable fields and sets updated_at to now, or keeps the
+// template's when the application owns it.
+func (s *SQLiteStore) UpdateTemplate(ctx context.Context, t *model.TwinTemplate) error {
+	desired, tags, err := marshalTemplateDefaults(t)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        UPDATE twin_templates
+        SET display_name = ?, description = ?, model_id = ?, desired_properties = ?, tags = ?, updated_at = ?
+        WHERE id = ?`
+	res, err := s.db.ExecContext(ctx, query, t.DisplayName, t.Description, t.ModelID, string(desired), string(tags),
+		sqliteTime(updateTime(s.appUpdatedAt, t.UpdatedAt)), t.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update template: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: template with ID '%s' not found for update", ErrNotFound, t.ID)
+	}
+	return nil
+}
+
+// DeleteTemplate removes a twin template by ID. Twins created from it are unaffected.
+func (s *SQLiteStore) DeleteTemplate(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM twin_templates WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: template with ID '%s' not found for deletion", ErrNotFound, id)
+	}
+	return nil
+}
+
+// --- TokenStore Methods ---
+
+// RevokeToken adds a token to the revocation list, keeping the first RevokedAt and the later Until.
+func (s *SQLiteStore) RevokeToken(ctx context.Context, revoked *RevokedToken) error {
+	_, err := s.db.ExecContext(ctx, `
+        INSERT INTO revoked_tokens (id, revoked_at, until) VALUES (?, ?, ?)
+        ON CONFLICT (id) DO UPDATE SET until = MAX(until, excluded.until)`,
+		revoked.ID, sqliteTime(revoked.RevokedAt), sqliteTime(revoked.Until))
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+// ListRevokedTokens lists the revocation list ordered by token ID.
+func (s *SQLiteStore) ListRevokedTokens(ctx context.Context) ([]*RevokedToken, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, revoked_at, until FROM revoked_tokens ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revoked tokens: %w", err)
+	}
+	defer rows.Close()
+
+	list := []*RevokedToken{}
+	for rows.Next() {
+		var entry RevokedToken
+		var revokedAt, until int64
+		if err := rows.Scan(&entry.ID, &revokedAt, &until); err != nil {
+			return nil, fmt.Errorf("failed to scan revoked token: %w", err)
+		}
+		entry.RevokedAt, entry.Until = fromSQLiteTime(revokedAt), fromSQLiteTime(until)
+		list = append(list, &entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list revoked tokens: %w", err)
+	}
+	return list, nil
+}
+
+// PruneRevokedTokens removes the entries whose Until is not after before.
+func (s *SQLiteStore) PruneRevokedTokens(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE until <= ?`, sqliteTime(before))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune revoked tokens: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// --- OutboxStore Methods ---
+
+// ClaimOutboxEvents leases the oldest unsent events in one UPDATE ... RETURNING; the write lock
+// every statement takes keeps concurrent claims from overlapping.
+func (s *SQLiteStore) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEvent, error) {
+	now := time.Now()
+	query := `
+        UPDATE outbox SET claimed_until = ?, attempts = attempts + 1
+        WHERE id IN (
+            SELECT id FROM outbox
+            WHERE sent_at IS NULL AND (claimed_until IS NULL OR claimed_until <= ?)
+            ORDER BY id LIMIT ?)
+        RETURNING id, event_type, subject_id, model_id, rule_id, occurred_at, attempts`
+	rows, err := s.db.QueryContext(ctx, query, sqliteTime(now.Add(lease)), sqliteTime(now), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []*OutboxEvent{}
+	for rows.Next() {
+		e := &OutboxEvent{}
+		var occurredAt int64
+		if err := rows.Scan(&e.ID, &e.Type, &e.SubjectID, &e.ModelID, &e.RuleID, &occurredAt, &e.Attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		e.OccurredAt = fromSQLiteTime(occurredAt)
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to claim outbox events: %w", err)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID }) // RETURNING has no order
+	return events, nil
+}
+
+// MarkOutboxEventsSent records the events as delivered.
+func (s *SQLiteStore) MarkOutboxEventsSent(ctx context.Context, ids []int64, at time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, sqliteTime(at))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	query := `UPDATE outbox SET sent_at = ? WHERE sent_at IS NULL AND id IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+	if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to mark outbox events sent: %w", err)
+	}
+	return nil
+}
+
+// PruneOutboxEvents deletes sent events (and with pending, unsent ones) older than before.
+func (s *SQLiteStore) PruneOutboxEvents(ctx context.Context, before time.Time, pending bool) (int64, error) {
+	query := `DELETE FROM outbox WHERE sent_at < ? OR (? AND sent_at IS NULL AND occurred_at < ?)`
+	res, err := s.db.ExecContext(ctx, query, sqliteTime(before), pending, sqliteTime(before))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune outbox events: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// --- WebhookStore Methods ---
+
+// sqliteWebhookColumns is the column list shared by all webhook SELECTs; keep in sync with scanSQLiteWebhook.
+const sqliteWebhookColumns = `id, url, event_types, twin_id, model_id, secret, created_at, updated_at`
+
+// scanSQLiteWebhook reads a webhook from a *sql.Row or *sql.Rows.
+func scanSQLiteWebhook(scanner interface{ Scan(...interface{}) error }) (*Webhook, error) {
+	w := &Webhook{}
+	var eventTypes string
+	var createdAt, updatedAt int64
+	if err := scanner.Scan(&w.ID, &w.URL, &eventTypes, &w.TwinID, &w.ModelID, &w.Secret, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(eventTypes), &w.EventTypes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhook event_types: %w", err)
+	}
+	w.CreatedAt = fromSQLiteTime(createdAt)
+	w.UpdatedAt = fromSQLiteTime(updatedAt)
+	return w, nil
+}
+
+// CreateWebhook inserts a new subscription.
+func (s *SQLiteStore) CreateWebhook(ctx context.Context, w *Webhook) error {
+	eventTypes, err := marshalEventTypes(w)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO webhooks (` + sqliteWebhookColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = s.db.ExecContext(ctx, query, w.ID, w.URL, string(eventTypes), w.TwinID, w.ModelID, w.Secret,
+		sqliteTime(w.CreatedAt), sqliteTime(w.UpdatedAt))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%w: webhook with ID '%s' already exists", ErrConflict, w.ID)
+		}
+		return fmt.Errorf("failed to insert webhook: %w", err)
+	}
+	return nil
+}
+
+// FindWebhookByID retrieves a subscription by ID.
+func (s *SQLiteStore) FindWebhookByID(ctx context.Context, id string) (*Webhook, error) {
+	query := `SELECT ` + sqliteWebhookColumns + ` FROM webhooks WHERE id = ?`
+	w, err := scanSQLiteWebhook(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: webhook with ID '%s' not found", ErrNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to find webhook by ID: %w", err)
+	}
+	return w, nil
+}
+
+// ListWebhooks lists every subscription ordered by ID.
+func (s *SQLiteStore) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sqliteWebhookColumns+` FROM webhooks ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	list := []*Webhook{}
+	for rows.Next() {
+		w, err := scanSQLiteWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		list = append(list, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	return list, nil
+}
+
+// UpdateWebhook replaces a subscription's mutable fields.
+func (s *SQLiteStore) UpdateWebhook(ctx context.Context, w *Webhook) error {
+	eventTypes, err := marshalEventTypes(w)
+	if err != nil {
+		return err
+	}
+	query := `
+        UPDATE webhooks SET url = ?, event_types = ?, twin_id = ?, model_id = ?, secret = ?, updated_at = ?
+        WHERE id = ?`
+	res, err := s.db.ExecContext(ctx, query, w.URL, string(eventTypes), w.TwinID, w.ModelID, w.Secret, sqliteTime(time.Now()), w.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: webhook with ID '%s' not found for update", ErrNotFound, w.ID)
+	}
+	return nil
+}
+
+// DeleteWebhook removes a subscription; its deliveries cascade.
+func (s *SQLiteStore) DeleteWebhook(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: webhook with ID '%s' not found for deletion", ErrNotFound, id)
+	}
+	return nil
+}
+
+// EnqueueWebhookDeliveries inserts the deliveries in one transaction, skipping queued pairs and
+// deleted webhooks.
+func (s *SQLiteStore) EnqueueWebhookDeliveries(ctx context.Context, deliveries []*WebhookDelivery) (int, error) {
+	if len(deliveries) == 0 {
+		return 0, nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	stmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, subject_id, model_id, rule_id, occurred_at, next_attempt_at, created_at, updated_at)
+        SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?8, ?8 WHERE EXISTS (SELECT 1 FROM webhooks WHERE id = ?1)
+        ON CONFLICT (webhook_id, event_id) DO NOTHING`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare webhook delivery insert: %w", err)
+	}
+	defer stmt.Close()
+
+	now := sqliteTime(time.Now())
+	queued := 0
+	for _, d := range deliveries {
+		res, err := stmt.ExecContext(ctx, d.WebhookID, d.Event.ID, d.Event.Type, d.Event.SubjectID, d.Event.ModelID, d.Event.RuleID, sqliteTime(d.Event.OccurredAt), now)
+		if err != nil {
+			return 0, fmt.Errorf("failed to queue webhook delivery: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		queued += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit webhook deliveries: %w", err)
+	}
+	return queued, nil
+}
+
+// sqliteDeliveryColumns is the column list shared by all delivery reads; keep in sync with scanSQLiteDelivery.
+const sqliteDeliveryColumns = `id, webhook_id, event_id, event_type, subject_id, model_id, rule_id, occurred_at, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at`
+
+// scanSQLiteDelivery reads a webhook delivery from a *sql.Rows.
+func scanSQLiteDelivery(rows *sql.Rows) (*WebhookDelivery, error) {
+	d := &WebhookDelivery{}
+	var occurredAt, nextAttemptAt, createdAt, updatedAt int64
+	err := rows.Scan(&d.ID, &d.WebhookID, &d.Event.ID, &d.Event.Type, &d.Event.SubjectID, &d.Event.ModelID, &d.Event.RuleID, &occurredAt,
+		&d.Status, &d.Attempts, &nextAttemptAt, &d.LastStatusCode, &d.LastError, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+	}
+	d.Event.OccurredAt = fromSQLiteTime(occurredAt)
+	d.NextAttemptAt = fromSQLiteTime(nextAttemptAt)
+	d.CreatedAt = fromSQLiteTime(createdAt)
+	d.UpdatedAt = fromSQLiteTime(updatedAt)
+	return d, nil
+}
+
+// ClaimWebhookDeliveries leases the oldest due pending deliveries in one UPDATE ... RETURNING.
+func (s *SQLiteStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	now := time.Now()
+	query := `
+        UPDATE webhook_deliveries SET next_attempt_at = ?, attempts = attempts + 1
+        WHERE id IN (
+            SELECT id FROM webhook_deliveries
+            WHERE status = 'pending' AND next_attempt_at <= ?
+            ORDER BY id LIMIT ?)
+        RETURNING ` + sqliteDeliveryColumns
+	rows, err := s.db.QueryContext(ctx, query, sqliteTime(now.Add(lease)), sqliteTime(now), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanSQLiteDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID }) // RETURNING has no order
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt.
+func (s *SQLiteStore) UpdateWebhookDelivery(ctx context.Context, d *WebhookDelivery) error {
+	query := `
+        UPDATE webhook_deliveries SET status = ?, next_attempt_at = ?, last_status_code = ?, last_error = ?, updated_at = ?
+        WHERE id = ?`
+	res, err := s.db.ExecContext(ctx, query, d.Status, sqliteTime(d.NextAttemptAt), d.LastStatusCode, d.LastError, sqliteTime(time.Now()), d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: webhook delivery %d not found", ErrNotFound, d.ID)
+	}
+	return nil
+}
+
+// ListWebhookDeliveries lists a webhook's deliveries newest first.
+func (s *SQLiteStore) ListWebhookDeliveries(ctx context.Context, webhookID string, beforeID int64, limit int) ([]*WebhookDelivery, error) {
+	query := `
+        SELECT ` + sqliteDeliveryColumns + ` FROM webhook_deliveries
+        WHERE webhook_id = ? AND (? = 0 OR id < ?)
+        ORDER BY id DESC LIMIT ?`
+	rows, err := s.db.QueryContext(ctx, query, webhookID, beforeID, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanSQLiteDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// PruneWebhookDeliveries deletes the finished deliveries last updated before before.
+func (s *SQLiteStore) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE status <> 'pending' AND updated_at < ?`, sqliteTime(before))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune webhook deliveries: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// --- AlertStore Methods ---
+
+// sqliteAlertRuleColumns is the column list shared by all alert rule SELECTs; keep in sync with scanSQLiteAlertRule.
+const sqliteAlertRuleColumns = `id, description, model_id, twin_id, metric, operator, value, duration, enabled, created_at, updated_at`
+
+// scanSQLiteAlertRule reads an alert rule from a *sql.Row or *sql.Rows.
+func scanSQLiteAlertRule(scanner interface{ Scan(...interface{}) error }) (*AlertRule, error) {
+	r := &AlertRule{}
+	var createdAt, updatedAt int64
+	err := scanner.Scan(&r.ID, &r.Description, &r.ModelID, &r.TwinID, &r.Metric, &r.Operator, &r.Value, &r.Duration, &r.Enabled, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	r.CreatedAt = fromSQLiteTime(createdAt)
+	r.UpdatedAt = fromSQLiteTime(updatedAt)
+	return r, nil
+}
+
+// CreateAlertRule inserts a new rule.
+func (s *SQLiteStore) CreateAlertRule(ctx context.Context, r *AlertRule) error {
+	query := `INSERT INTO alert_rules (` + sqliteAlertRuleColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, query, r.ID, r.Description, r.ModelID, r.TwinID, r.Metric, r.Operator, r.Value, r.Duration, r.Enabled,
+		sqliteTime(r.CreatedAt), sqliteTime(r.UpdatedAt))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%w: alert rule with ID '%s' already exists", ErrConflict, r.ID)
+		}
+		return fmt.Errorf("failed to insert alert rule: %w", err)
+	}
+	return nil
+}
+
+// FindAlertRuleByID retrieves a rule by ID.
+func (s *SQLiteStore) FindAlertRuleByID(ctx context.Context, id string) (*AlertRule, error) {
+	r, err := scanSQLiteAlertRule(s.db.QueryRowContext(ctx, `SELECT `+sqliteAlertRuleColumns+` FROM alert_rules WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: alert rule with ID '%s' not found", ErrNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to find alert rule by ID: %w", err)
+	}
+	return r, nil
+}
+
+// ListAlertRules lists every rule ordered by ID.
+func (s *SQLiteStore) ListAlertRules(ctx context.Context) ([]*AlertRule, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sqliteAlertRuleColumns+` FROM alert_rules ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert rules: %w", err)
+	}
+	defer rows.Close()
+
+	list := []*AlertRule{}
+	for rows.Next() {
+		r, err := scanSQLiteAlertRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan alert rule: %w", err)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list alert rules: %w", err)
+	}
+	return list, nil
+}
+
+// UpdateAlertRule replaces a rule's mutable fields.
+func (s *SQLiteStore) UpdateAlertRule(ctx context.Context, r *AlertRule) error {
+	query := `
+        UPDATE alert_rules SET description = ?, model_id = ?, twin_id = ?, metric = ?, operator = ?, value = ?, duration = ?, enabled = ?, updated_at = ?
+        WHERE id = ?`
+	res, err := s.db.ExecContext(ctx, query, r.Description, r.ModelID, r.TwinID, r.Metric, r.Operator, r.Value, r.Duration, r.Enabled, sqliteTime(time.Now()), r.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update alert rule: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: alert rule with ID '%s' not found for update", ErrNotFound, r.ID)
+	}
+	return nil
+}
+
+// DeleteAlertRule removes a rule; its states cascade.
+func (s *SQLiteStore) DeleteAlertRule(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM alert_rules WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete alert rule: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: alert rule with ID '%s' not found for deletion", ErrNotFound, id)
+	}
+	return nil
+}
+
+// ListAlertStates lists a rule's states ordered by twin ID.
+func (s *SQLiteStore) ListAlertStates(ctx context.Context, ruleID string) ([]*AlertState, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT rule_id, twin_id, state, since, value, updated_at FROM alert_states
+        WHERE rule_id = ? ORDER BY twin_id ASC`, ruleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert states: %w", err)
+	}
+	defer rows.Close()
+
+	list := []*AlertState{}
+	for rows.Next() {
+		st := &AlertState{}
+		var since, updatedAt int64
+		if err := rows.Scan(&st.RuleID, &st.TwinID, &st.State, &since, &st.Value, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan alert state: %w", err)
+		}
+		st.Since = fromSQLiteTime(since)
+		st.UpdatedAt = fromSQLiteTime(updatedAt)
+		list = append(list, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list alert states: %w", err)
+	}
+	return list, nil
+}
+
+// SaveAlertState inserts the state, or updates it where it is still prev, and writes its event in
+// the same transaction when a row changed.
+func (s *SQLiteStore) SaveAlertState(ctx context.Context, prev, st *AlertState, event *OutboxEvent) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	now := sqliteTime(time.Now())
+	var res sql.Result
+	if prev == nil {
+		res, err = tx.ExecContext(ctx, `
+        INSERT INTO alert_states (rule_id, twin_id, state, since, value, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT (rule_id, twin_id) DO NOTHING`,
+			st.RuleID, st.TwinID, st.State, sqliteTime(st.Since), st.Value, now)
+	} else {
+		res, err = tx.ExecContext(ctx, `
+        UPDATE alert_states SET state = ?, since = ?, value = ?, updated_at = ?
+        WHERE rule_id = ? AND twin_id = ? AND state = ? AND since = ?`,
+			st.State, sqliteTime(st.Since), st.Value, now, st.RuleID, st.TwinID, prev.State, sqliteTime(prev.Since))
+	}
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return false, fmt.Errorf("%w: alert rule with ID '%s' not found", ErrNotFound, st.RuleID)
+		}
+		return false, fmt.Errorf("failed to save alert state: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil // Recorded by another evaluator first
+	}
+	if event != nil {
+		_, err = tx.ExecContext(ctx, `INSERT INTO outbox (event_type, subject_id, model_id, rule_id, occurred_at) VALUES (?, ?, ?, ?, ?)`,
+			event.Type, event.SubjectID, event.ModelID, event.RuleID, now)
+		if err != nil {
+			return false, fmt.Errorf("failed to write alert event: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit alert state: %w", err)
+	}
+	return true, nil
+}
+
+// DeleteAlertState removes the state of (prev.RuleID, prev.TwinID) where it is still prev.
+func (s *SQLiteStore) DeleteAlertState(ctx context.Context, prev *AlertState) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM alert_states WHERE rule_id = ? AND twin_id = ? AND state = ? AND since = ?`,
+		prev.RuleID, prev.TwinID, prev.State, sqliteTime(prev.Since))
+	if err != nil {
+		return false, fmt.Errorf("failed to delete alert state: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// --- TimeSeriesStore Methods ---
+
+// sqliteTelemetryColumns is the column list shared by telemetry SELECTs; keep in sync with scanSQLiteTelemetry.
+const sqliteTelemetryColumns = `ts, name, value_numeric, value_string, value_boolean, quality, written_by`
+
+// scanSQLiteTelemetry reads a telemetry row.
+func scanSQLiteTelemetry(scanner rowScanner, twinID string) (*TelemetryRecord, error) {
+	rec := &TelemetryRecord{TwinID: twinID}
+	var ts int64
+	var numVal sql.NullFloat64
+	var strVal sql.NullString
+	var boolVal sql.NullBool
+	var quality int16
+	var writtenBy sql.NullString
+	if err := scanner.Scan(&ts, &rec.Name, &numVal, &strVal, &boolVal, &quality, &writtenBy); err != nil {
+		return nil, err
+	}
+	rec.Timestamp = fromSQLiteTime(ts)
+	if numVal.Valid {
+		rec.NumericValue = &numVal.Float64
+	}
+	if strVal.Valid {
+		rec.StringValue = &strVal.String
+	}
+	if boolVal.Valid {
+		rec.BooleanValue = &boolVal.Bool
+	}
+	rec.Quality = Quality(quality)
+	rec.WrittenBy = writtenBy.String
+	return rec, nil
+}
+
+// telemetryArgs returns the value columns of record for an INSERT.
+func telemetryArgs(twinID string, record *TelemetryRecord) []interface{} {
+	return []interface{}{
+		sqliteTime(record.Timestamp), twinID, record.Name,
+		record.NumericValue, record.StringValue, record.BooleanValue, // nil pointers become NULL
+		int16(record.Quality), nullString(record.WrittenBy),
+	}
+}
+
+// nullString maps "" to NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// WriteTelemetry stores a single telemetry record.
+func (s *SQLiteStore) WriteTelemetry(ctx context.Context, twinID string, record *TelemetryRecord) error {
+	query := `
+        INSERT INTO telemetry (ts, twin_id, name, value_numeric, value_string, value_boolean, quality, written_by)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	if _, err := s.db.ExecContext(ctx, query, telemetryArgs(twinID, record)...); err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%w: telemetry '%s' for twin '%s' at %s already exists", ErrConflict, record.Name, twinID, record.Timestamp.Format(time.RFC3339Nano))
+		}
+		return fmt.Errorf("failed to insert telemetry record: %w", err)
+	}
+	return nil
+}
+
+// WriteBatchTelemetry sets each record's TwinID and writes them with WriteTelemetryCopy.
+func (s *SQLiteStore) WriteBatchTelemetry(ctx context.Context, twinID string, records []*TelemetryRecord) error {
+	for _, rec := range records {
+		rec.TwinID = twinID
+	}
+	return s.WriteTelemetryCopy(ctx, records)
+}
+
+// WriteTelemetryCopy inserts the records in one transaction with a prepared statement (SQLite
+// has no COPY; rows are written locally, so batching the transaction is what saves time).
+func (s *SQLiteStore) WriteTelemetryCopy(ctx context.Context, records []*TelemetryRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if err := checkRecordTwinIDs(records); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin telemetry batch: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	stmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO telemetry (ts, twin_id, name, value_numeric, value_string, value_boolean, quality, written_by)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare telemetry batch: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, rec := range records {
+		if _, err := stmt.ExecContext(ctx, telemetryArgs(rec.TwinID, rec)...); err != nil {
+			if isUniqueViolation(err) {
+				return fmt.Errorf("%w: telemetry '%s' for twin '%s' at %s already exists", ErrConflict, rec.Name, rec.TwinID, rec.Timestamp.Format(time.RFC3339Nano))
+			}
+			return fmt.Errorf("failed to insert telemetry records: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit telemetry batch: %w", err)
+	}
+	return nil
+}
+
+// BackfillTelemetry inserts historical telemetry in one transaction, skipping records that
+// collide with existing (twin_id, name, ts) rows. Safe to retry.
+func (s *SQLiteStore) BackfillTelemetry(ctx context.Context, twinID string, records []*TelemetryRecord) (int, error) {
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin telemetry backfill: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	stmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO telemetry (ts, twin_id, name, value_numeric, value_string, value_boolean, quality, written_by)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (twin_id, name, ts) DO NOTHING`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare telemetry backfill: %w", err)
+	}
+	defer stmt.Close()
+
+	inserted := 0
+	for _, rec := range records {
+		res, err := stmt.ExecContext(ctx, telemetryArgs(twinID, rec)...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to backfill telemetry records: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		inserted += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit telemetry backfill: %w", err)
+	}
+	return inserted, nil
+}
+
+// sqliteMergedBucketValueSQL is mergedBucketValueSQL in SQLite's dialect.
+var sqliteMergedBucketValueSQL = map[string]string{
+	AggregateAvg:   "(t.bucket_sum + excluded.bucket_sum) / (t.bucket_count + excluded.bucket_count)",
+	AggregateMin:   "MIN(t.bucket_min, excluded.bucket_min)",
+	AggregateMax:   "MAX(t.bucket_max, excluded.bucket_max)",
+	AggregateSum:   "t.bucket_sum + excluded.bucket_sum",
+	AggregateCount: "t.bucket_count + excluded.bucket_count",
+}
+
+// MergeDownsampledTelemetry upserts one point per bucket in one transaction (see
+// TimeSeriesStore).
+func (s *SQLiteStore) MergeDownsampledTelemetry(ctx context.Context, twinID string, buckets []*DownsampledBucket) ([]*TelemetryRecord, error) {
+	stored := []*TelemetryRecord{}
+	if len(buckets) == 0 {
+		return stored, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin downsampled telemetry merge: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	for _, b := range buckets {
+		agg := b.Aggregation
+		if agg == "" {
+			agg = AggregateAvg
+		}
+		valueSQL, ok := sqliteMergedBucketValueSQL[agg]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown downsampling aggregation '%s'", ErrValidation, b.Aggregation)
+		}
+		// valueSQL is a constant expression
+		row := tx.QueryRowContext(ctx, `
+        INSERT INTO telemetry AS t (ts, twin_id, name, value_numeric, quality, written_by, bucket_count, bucket_sum, bucket_min, bucket_max)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (twin_id, name, ts) DO UPDATE SET
+            value_numeric = `+valueSQL+`,
+            quality = MAX(t.quality, excluded.quality),
+            written_by = CASE WHEN t.written_by IS excluded.written_by THEN t.written_by END,
+            bucket_count = t.bucket_count + excluded.bucket_count,
+            bucket_sum = t.bucket_sum + excluded.bucket_sum,
+            bucket_min = MIN(t.bucket_min, excluded.bucket_min),
+            bucket_max = MAX(t.bucket_max, excluded.bucket_max)
+        WHERE t.bucket_count IS NOT NULL
+        RETURNING value_numeric, quality, written_by`,
+			sqliteTime(b.Start), twinID, b.Name, b.Value(), int16(b.Quality), nullString(b.WrittenBy), b.Count, b.Sum, b.Min, b.Max)
+		rec := &TelemetryRecord{TwinID: twinID, Name: b.Name, Timestamp: fromSQLiteTime(sqliteTime(b.Start))}
+		var value float64
+		var quality int16
+		var writer sql.NullString
+		if err := row.Scan(&value, &quality, &writer); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue // A point stored otherwise: skipped
+			}
+			return nil, fmt.Errorf("failed to merge downsampled telemetry: %w", err)
+		}
+		rec.NumericValue = &value
+		rec.Quality = Quality(quality)
+		rec.WrittenBy = writer.String
+		stored = append(stored, rec)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit downsampled telemetry merge: %w", err)
+	}
+	return stored, nil
+}
+
+// qualityFilterSQL returns an "AND quality IN (...)" clause and its arguments ("" for no filter).
+func qualityFilterSQL(qualities []Quality) (string, []interface{}) {
+	if len(qualities) == 0 {
+		return "", nil
+	}
+	placeholders := make([]string, len(qualities))
+	args := make([]interface{}, len(qualities))
+	for i, q := range qualities {
+		placeholders[i] = "?"
+		args[i] = int16(q)
+	}
+	return " AND quality IN (" + strings.Join(placeholders, ", ") + ")", args
+}
+
+// QueryTelemetryHistory retrieves historical telemetry data.
+func (s *SQLiteStore) QueryTelemetryHistory(ctx context.Context, twinID string, name string, start time.Time, end time.Time, descending bool, limit uint, qualities []Quality) ([]*TelemetryRecord, error) {
+	records := []*TelemetryRecord{}
+	err := s.StreamTelemetryHistory(ctx, twinID, name, start, end, descending, limit, qualities, func(rec *TelemetryRecord) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// StreamTelemetryHistory passes historical telemetry to fn row by row as it is read
+// (served by the (twin_id, name, ts) primary key in either direction).
+func (s *SQLiteStore) StreamTelemetryHistory(ctx context.Context, twinID string, name string, start time.Time, end time.Time, descending bool, limit uint, qualities []Quality, fn func(*TelemetryRecord) error) error {
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString(`SELECT ` + sqliteTelemetryColumns + ` FROM telemetry WHERE twin_id = ? AND name = ? AND ts >= ? AND ts <= ?`)
+	args := []interface{}{twinID, name, sqliteTime(start), sqliteTime(end)}
+
+	filter, filterArgs := qualityFilterSQL(qualities)
+	queryBuilder.WriteString(filter)
+	args = append(args, filterArgs...)
+
+	if descending {
+		queryBuilder.WriteString(" ORDER BY ts DESC")
+	} else {
+		queryBuilder.WriteString(" ORDER BY ts ASC")
+	}
+	if limit > 0 {
+		queryBuilder.WriteString(" LIMIT ?")
+		args = append(args, int64(limit))
+	}
+
+	rows, err := s.db.QueryContext(ctx, queryBuilder.String(), args...)
+	if err != nil {
+		return fmt.Errorf("failed to query telemetry history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rec, err := scanSQLiteTelemetry(rows, twinID)
+		if err != nil {
+			log.Printf("WARN: Failed to scan telemetry row: %v", err)
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating telemetry history rows: %w", err)
+	}
+	return nil
+}
+
+// QueryTelemetryAggregate downsamples telemetry into time buckets. SQLite has no time_bucket(), so
+// rows are streamed in ts order and bucketed in Go, with the same alignment as the other stores.
+func (s *SQLiteStore) QueryTelemetryAggregate(ctx context.Context, twinID string, name string, start time.Time, end time.Time, bucket time.Duration, agg string, tz string, qualities []Quality) ([]*TelemetryAggregate, error) {
+	aggregator, err := newBucketAggregator(bucket, agg, tz)
+	if err != nil {
+		return nil, err
+	}
+	err = s.StreamTelemetryHistory(ctx, twinID, name, start, end, false, 0, qualities, func(rec *TelemetryRecord) error {
+		aggregator.add(rec)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query telemetry aggregate: %w", err)
+	}
+	return aggregator.result(), nil
+}
+
+// seriesFilterSQL returns "twin_id IN (...) AND name IN (...)" and its arguments for the bulk queries.
+func seriesFilterSQL(twinIDs, names []string) (string, []interface{}) {
+	args := make([]interface{}, 0, len(twinIDs)+len(names))
+	in := func(values []string) string {
+		placeholders := make([]string, len(values))
+		for i, v := range values {
+			placeholders[i] = "?"
+			args = append(args, v)
+		}
+		return "(" + strings.Join(placeholders, ", ") + ")"
+	}
+	filter := "twin_id IN " + in(twinIDs) + " AND name IN " + in(names) // Operands run left to right
+	return filter, args
+}
+
+// QueryTelemetryBulk reads every (twin, name) combination in one query; row_number() over each
+// series applies the per-series limit.
+func (s *SQLiteStore) QueryTelemetryBulk(ctx context.Context, twinIDs []string, names []string, start time.Time, end time.Time, limit uint, qualities []Quality) (map[TelemetrySeriesKey][]*TelemetryRecord, error) {
+	result := make(map[TelemetrySeriesKey][]*TelemetryRecord)
+	if len(twinIDs) == 0 || len(names) == 0 {
+		return result, nil // IN () is a syntax error in SQLite
+	}
+
+	series, args := seriesFilterSQL(twinIDs, names)
+	args = append(args, sqliteTime(start), sqliteTime(end))
+	filter, filterArgs := qualityFilterSQL(qualities)
+	args = append(args, filterArgs...)
+	limitFilter := ""
+	if limit > 0 {
+		limitFilter = " WHERE n <= ?"
+		args = append(args, int64(limit))
+	}
+
+	query := `
+        SELECT twin_id, ` + sqliteTelemetryColumns + `
+        FROM (
+            SELECT twin_id, ` + sqliteTelemetryColumns + `,
+                row_number() OVER (PARTITION BY twin_id, name ORDER BY ts) AS n
+            FROM telemetry
+            WHERE ` + series + ` AND ts >= ? AND ts <= ?` + filter + `
+        )` + limitFilter + `
+        ORDER BY twin_id, name, ts ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bulk telemetry: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var twinID string
+		rec, err := scanSQLiteTelemetry(prefixScanner{rows, &twinID}, "")
+		if err != nil {
+			log.Printf("WARN: Failed to scan telemetry row: %v", err)
+			continue
+		}
+		rec.TwinID = twinID
+		key := TelemetrySeriesKey{TwinID: twinID, Name: rec.Name}
+		result[key] = append(result[key], rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating bulk telemetry rows: %w", err)
+	}
+	return result, nil
+}
+
+// QueryTelemetryAggregateBulk downsamples every (twin, name) combination: the rows of all series
+// are read in one query, ordered by series and ts, and each series is bucketed in Go as in
+// QueryTelemetryAggregate.
+func (s *SQLiteStore) QueryTelemetryAggregateBulk(ctx context.Context, twinIDs []string, names []string, start time.Time, end time.Time, bucket time.Duration, agg string, tz string, qualities []Quality) (map[TelemetrySeriesKey][]*TelemetryAggregate, error) {
+	if _, err := newBucketAggregator(bucket, agg, tz); err != nil {
+		return nil, err
+	}
+	result := make(map[TelemetrySeriesKey][]*TelemetryAggregate)
+	if len(twinIDs) == 0 || len(names) == 0 {
+		return result, nil
+	}
+
+	series, args := seriesFilterSQL(twinIDs, names)
+	args = append(args, sqliteTime(start), sqliteTime(end))
+	filter, filterArgs := qualityFilterSQL(qualities)
+	args = append(args, filterArgs...)
+
+	query := `
+        SELECT twin_id, ` + sqliteTelemetryColumns + `
+        FROM telemetry
+        WHERE ` + series + ` AND ts >= ? AND ts <= ?` + filter + `
+        ORDER BY twin_id, name, ts ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query telemetry aggregate: %w", err)
+	}
+	defer rows.Close()
+
+	var key TelemetrySeriesKey
+	var aggregator *bucketAggregator
+	flush := func() {
+		if aggregator != nil {
+			result[key] = aggregator.result()
+		}
+	}
+	for rows.Next() {
+		var twinID string
+		rec, err := scanSQLiteTelemetry(prefixScanner{rows, &twinID}, "")
+		if err != nil {
+			log.Printf("WARN: Failed to scan telemetry row: %v", err)
+			continue
+		}
+		if next := (TelemetrySeriesKey{TwinID: twinID, Name: rec.Name}); aggregator == nil || next != key {
+			flush()
+			key = next
+			aggregator, _ = newBucketAggregator(bucket, agg, tz) // Validated above
+		}
+		aggregator.add(rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating telemetry aggregate rows: %w", err)
+	}
+	flush()
+	return result, nil
+}
+
+// prefixScanner scans a leading column into first before handing the remaining ones to dest,
+// so row helpers like scanSQLiteTelemetry can read rows with an extra column in front.
+type prefixScanner struct {
+	scanner rowScanner
+	first   interface{}
+}
+
+func (p prefixScanner) Scan(dest ...interface{}) error {
+	return p.scanner.Scan(append([]interface{}{p.first}, dest...)...)
+}
+
+// CountTelemetry counts telemetry points in a time range. SQLite has no cheap planner estimate,
+// so the count is always exact; approximate is accepted for interface compatibility.
+func (s *SQLiteStore) CountTelemetry(ctx context.Context, twinID string, name string, start time.Time, end time.Time, approximate bool) (int64, error) {
+	var count int64
+	query := `SELECT count(*) FROM telemetry WHERE twin_id = ? AND name = ? AND ts >= ? AND ts <= ?`
+	if err := s.db.QueryRowContext(ctx, query, twinID, name, sqliteTime(start), sqliteTime(end)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count telemetry: %w", err)
+	}
+	return count, nil
+}
+
+// PruneTelemetry deletes expired telemetry: one DELETE per rule over the model's twins (served by
+// the (twin_id, name, ts) primary key), then one for the default retention that skips the (model,
+// name) pairs covered by rules. There is no index on ts alone, so the latter scans the table.
+func (s *SQLiteStore) PruneTelemetry(ctx context.Context, rules []RetentionRule, defaultBefore time.Time) (int64, error) {
+	var deleted int64
+	for _, rule := range rules {
+		res, err := s.db.ExecContext(ctx, `
+            DELETE FROM telemetry
+            WHERE name = ? AND ts < ? AND twin_id IN (SELECT id FROM twin_instances WHERE model_id = ?)`,
+			rule.Name, sqliteTime(rule.Before), rule.ModelID)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to prune telemetry '%s' of model '%s': %w", rule.Name, rule.ModelID, err)
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+	if defaultBefore.IsZero() {
+		return deleted, nil
+	}
+
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString(`DELETE FROM telemetry WHERE ts < ?`)
+	args := []interface{}{sqliteTime(defaultBefore)}
+	if len(rules) > 0 {
+		queryBuilder.WriteString(` AND NOT EXISTS (SELECT 1 FROM twin_instances i WHERE i.id = telemetry.twin_id AND (i.model_id, telemetry.name) IN (VALUES `)
+		for i, rule := range rules {
+			if i > 0 {
+				queryBuilder.WriteString(", ")
+			}
+			queryBuilder.WriteString("(?, ?)")
+			args = append(args, rule.ModelID, rule.Name)
+		}
+		queryBuilder.WriteString("))")
+	}
+	res, err := s.db.ExecContext(ctx, queryBuilder.String(), args...)
+	if err != nil {
+		return deleted, fmt.Errorf("failed to prune telemetry past the default retention: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return deleted + n, nil
+}
+
+// QueryTelemetryGaps finds gaps with lag() over consecutive timestamps (SQLite has window functions
+// since 3.25). As in Postgres, the range edges are unioned in so leading and trailing gaps count.
+func (s *SQLiteStore) QueryTelemetryGaps(ctx context.Context, twinID string, name string, start time.Time, end time.Time, threshold time.Duration) ([]*TelemetryGap, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("%w: gap threshold must be positive", ErrValidation)
+	}
+
+	query := `
+        SELECT prev_ts, ts
+        FROM (
+            SELECT ts, lag(ts) OVER (ORDER BY ts) AS prev_ts
+            FROM (
+                SELECT ts FROM telemetry WHERE twin_id = ? AND name = ? AND ts >= ? AND ts <= ?
+                UNION ALL SELECT ?
+                UNION ALL SELECT ?
+            )
+        )
+        WHERE ts - prev_ts > ?
+        ORDER BY ts ASC`
+
+	from, to := sqliteTime(start), sqliteTime(end)
+	rows, err := s.db.QueryContext(ctx, query, twinID, name, from, to, from, to, threshold.Microseconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query telemetry gaps: %w", err)
+	}
+	defer rows.Close()
+
+	gaps := []*TelemetryGap{}
+	for rows.Next() {
+		var prev, ts int64
+		if err := rows.Scan(&prev, &ts); err != nil {
+			log.Printf("WARN: Failed to scan telemetry gap row: %v", err)
+			continue
+		}
+		gaps = append(gaps, &TelemetryGap{Start: fromSQLiteTime(prev), End: fromSQLiteTime(ts)})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating telemetry gap rows: %w", err)
+	}
+	return gaps, nil
+}
+
+// QueryLatestTelemetry retrieves the most recent telemetry value per name (all names when names is empty).
+// Without Timescale's last(), each name is an ORDER BY ts DESC LIMIT 1 lookup on the primary key,
+// which is cheap for an embedded database (no round trips).
+func (s *SQLiteStore) QueryLatestTelemetry(ctx context.Context, twinID string, names []string) (map[string]*TelemetryRecord, error) {
+	names, none := latestTelemetryNames(names)
+	if none {
+		return make(map[string]*TelemetryRecord), nil
+	}
+	if len(names) == 0 {
+		var err error
+		if names, err = s.ListTelemetryNames(ctx, twinID); err != nil {
+			return nil, err
+		}
+	}
+
+	stmt, err := s.db.PrepareContext(ctx, `
+        SELECT `+sqliteTelemetryColumns+`
+        FROM telemetry
+        WHERE twin_id = ? AND name = ?
+        ORDER BY ts DESC
+        LIMIT 1`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare latest telemetry query: %w", err)
+	}
+	defer stmt.Close()
+
+	latestValues := make(map[string]*TelemetryRecord)
+	for _, name := range names {
+		rec, err := scanSQLiteTelemetry(stmt.QueryRowContext(ctx, twinID, name), twinID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query latest telemetry: %w", err)
+		}
+		latestValues[rec.Name] = rec
+	}
+	return latestValues, nil
+}
+
+// SummarizeModelTelemetry aggregates the telemetry of the model's first maxTwins matching twins,
+// taking each twin's latest point per name with a window over the (twin_id, name, ts) key.
+func (s *SQLiteStore) SummarizeModelTelemetry(ctx context.Context, modelID string, tags map[string]string, maxTwins int) (*ModelTelemetrySummary, error) {
+	summary := &ModelTelemetrySummary{ModelID: modelID, Metrics: []*TelemetryMetricSummary{}}
+	if modelID == "" {
+		return summary, nil // sqliteTwinsSelect would match every model
+	}
+
+	twins, args := sqliteTwinsSelect(`id`, tags, modelID, ``)
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM (`+twins+`)`, args...).Scan(&summary.Twins); err != nil {
+		return nil, fmt.Errorf("failed to count twins of model: %w", err)
+	}
+
+	fleet := `WITH fleet AS (` + twins + ` LIMIT ?)`
+	args = append(args, maxTwins)
+	err := s.db.QueryRowContext(ctx, fleet+`
+        SELECT (SELECT COUNT(*) FROM fleet), COUNT(*), COALESCE(SUM(points), 0)
+        FROM (SELECT COUNT(*) AS points FROM telemetry WHERE twin_id IN (SELECT id FROM fleet) GROUP BY twin_id)`,
+		args...).Scan(&summary.Examined, &summary.Reporting, &summary.Points)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count telemetry of model: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, fleet+`
+        SELECT name, COUNT(*), COUNT(value_numeric), AVG(value_numeric), MIN(value_numeric), MAX(value_numeric), MAX(ts)
+        FROM (
+            SELECT name, value_numeric, ts, ROW_NUMBER() OVER (PARTITION BY twin_id, name ORDER BY ts DESC) AS rn
+            FROM telemetry
+            WHERE twin_id IN (SELECT id FROM fleet)
+        )
+        WHERE rn = 1
+        GROUP BY name
+        ORDER BY name`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize latest telemetry of model: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m := &TelemetryMetricSummary{}
+		var latestAt int64
+		if err := rows.Scan(&m.Name, &m.Twins, &m.Numeric, &m.Avg, &m.Min, &m.Max, &latestAt); err != nil {
+			return nil, fmt.Errorf("failed to scan telemetry summary row: %w", err)
+		}
+		m.LatestAt = fromSQLiteTime(latestAt)
+		summary.Metrics = append(summary.Metrics, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating telemetry summary rows: %w", err)
+	}
+	return summary, nil
+}
+
+// SummarizeModelUsage counts a model's twins and their telemetry since a time.
+func (s *SQLiteStore) SummarizeModelUsage(ctx context.Context, modelID string, tags map[string]string, since time.Time) (*ModelUsage, error) {
+	usage := &ModelUsage{ModelID: modelID, TelemetrySince: since.UTC()}
+	if modelID == "" {
+		return usage, nil // sqliteTwinsSelect would match every model
+	}
+
+	twins, args := sqliteTwinsSelect(`id, created_at`, tags, modelID, ``)
+	var oldest, newest sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*), MIN(created_at), MAX(created_at) FROM (`+twins+`)`, args...).
+		Scan(&usage.Twins, &oldest, &newest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count twins of model: %w", err)
+	}
+	if oldest.Valid && newest.Valid {
+		oldestAt, newestAt := fromSQLiteTime(oldest.Int64), fromSQLiteTime(newest.Int64)
+		usage.OldestTwinCreatedAt, usage.NewestTwinCreatedAt = &oldestAt, &newestAt
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+        SELECT COUNT(*), COUNT(DISTINCT twin_id) FROM telemetry
+        WHERE twin_id IN (SELECT id FROM (`+twins+`)) AND ts >= ?`,
+		append(args, sqliteTime(since))...).Scan(&usage.TelemetryPoints, &usage.ReportingTwins)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count telemetry of model: %w", err)
+	}
+	return usage, nil
+}
+
+// ListTelemetryNames returns the distinct telemetry names stored for a twin, sorted.
+func (s *SQLiteStore) ListTelemetryNames(ctx context.Context, twinID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT name FROM telemetry WHERE twin_id = ? ORDER BY name`, twinID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list telemetry names: %w", err)
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan telemetry name: %w", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating telemetry name rows: %w", err)
+	}
+	return names, nil
+}
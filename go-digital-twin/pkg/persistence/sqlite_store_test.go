@@ -0,0 +1,28 @@
+// pkg/persistence/sqlite_store_test.go
+package persistence_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence/storetest"
+)
+
+// TestSQLiteStore runs the conformance suite against database files rather than ":memory:", so
+// the store runs in WAL mode as it does in deployments.
+func TestSQLiteStore(t *testing.T) {
+	dir := t.TempDir()
+	var opened int
+	storetest.RunStoreTests(t, func() persistence.Store {
+		// A new file per subtest: the suite wants an empty store each time
+		opened++
+		s, err := persistence.NewSQLiteStore(context.Background(), filepath.Join(dir, fmt.Sprintf("store-%d.db", opened)))
+		if err != nil {
+			panic(fmt.Sprintf("open SQLite store: %v", err)) // newStore has no *testing.T to fail
+		}
+		return s
+	})
+}
@@ -3,10 +3,12 @@ package persistence
 
 import (
 	"context" // Use context for cancellation and deadlines
+	"strings"
 	// For checking specific persistence errors
 	"time" // Need time for telemetry
 
 	// Keep using standard errors or define custom ones
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/geo"
 	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model" // UPDATE THE PATH
 )
 
@@ -21,50 +23,522 @@ type ModelStore interface {
 	// ListAll lists all stored TwinModels.
 	ListAllModels(ctx context.Context) ([]*model.TwinModel, error)
 
+	// ListModelsByCategory lists models in the given category (case-insensitive match).
+	ListModelsByCategory(ctx context.Context, category string) ([]*model.TwinModel, error)
+
+	// ListModelIDs lists up to limit model IDs greater than afterID, ordered (keyset pagination; pass
+	// "" for the first page). Only the IDs are read, for clients that sync or diff the model list.
+	ListModelIDs(ctx context.Context, afterID string, limit int) ([]string, error)
+
+	// StreamModels passes the models ordered by ID to fn one at a time as they are read, without
+	// materializing the list; category filters like ListModelsByCategory ("" = all models).
+	// Returning an error from fn stops the iteration and that error is returned.
+	StreamModels(ctx context.Context, category string, fn func(*model.TwinModel) error) error
+
+	// ListModelCategories returns the distinct non-empty categories with the number of models in each.
+	ListModelCategories(ctx context.Context) ([]*ModelCategory, error)
+
 	// Update modifies an existing TwinModel. Returns model.ErrNotFound if the model doesn't exist.
 	UpdateModel(ctx context.Context, model *model.TwinModel) error
 
 	// Delete removes a TwinModel by its ID. Returns model.ErrNotFound if not found.
 	DeleteModel(ctx context.Context, id string) error
 
+	// ListModelVersions returns the recorded versions of a model, oldest first, without their
+	// definitions. Create, update and delete each record a version in the same transaction as the
+	// change, attributed to WithChangedBy's name. History outlives the model, so a deleted model's
+	// versions are still listed. Returns an empty slice for a model without history.
+	ListModelVersions(ctx context.Context, modelID string) ([]*ModelVersion, error)
+
+	// FindModelVersion returns one recorded version including its definition, or ErrNotFound.
+	FindModelVersion(ctx context.Context, modelID string, version int) (*ModelVersion, error)
+
 	// Close cleans up resources (e.g., database connections).
 	Close() // No context needed for Close usually
 }
 
+// Model changes recorded in ModelVersion.Change.
+const (
+	ModelChangeBaseline = "baseline" // State found on the first change after history started being recorded
+	ModelChangeCreated  = "created"
+	ModelChangeUpdated  = "updated"
+	ModelChangeDeleted  = "deleted"
+)
+
+// ModelVersion is one entry of a model's change history.
+type ModelVersion struct {
+	ModelID   string           `json:"modelId"`
+	Version   int              `json:"version"` // 1 for the first recorded version, incremented on every change
+	Change    string           `json:"change"`  // One of the ModelChange* values
+	ChangedAt time.Time        `json:"changedAt"`
+	ChangedBy string           `json:"changedBy,omitempty"` // Empty when the change was made without a named caller
+	Model     *model.TwinModel `json:"model,omitempty"`     // Definition after the change (before it for deletions); nil in listings
+}
+
+// changedByKey is the context key for WithChangedBy.
+type changedByKey struct{}
+
+// WithChangedBy returns a copy of ctx that attributes the model changes made with it to who
+// (e.g. the API key name), as recorded in ModelVersion.ChangedBy.
+func WithChangedBy(ctx context.Context, who string) context.Context {
+	return context.WithValue(ctx, changedByKey{}, who)
+}
+
+// changedBy returns the name set by WithChangedBy, or "".
+func changedBy(ctx context.Context) string {
+	who, _ := ctx.Value(changedByKey{}).(string)
+	return who
+}
+
+// ModelCategory is a distinct model category along with how many models use it.
+type ModelCategory struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
 // TwinStore defines the interface for persistence operations related to TwinInstances.
 type TwinStore interface {
-	// Create stores a new TwinInstance. Requires a valid ModelID.
+	// Create stores a new TwinInstance. Requires a valid ModelID: returns ErrInvalidReference when
+	// the model doesn't exist and ErrConflict when the ID is taken.
 	CreateTwin(ctx context.Context, twin *model.TwinInstance) error
 
 	// FindByID retrieves a TwinInstance by its unique ID. Returns ErrNotFound if not found.
 	FindTwinByID(ctx context.Context, id string) (*model.TwinInstance, error)
 
+	// TwinExists reports whether a twin with the ID exists, without reading it: a cheap check
+	// that tells an unknown twin apart from one without data.
+	TwinExists(ctx context.Context, id string) (bool, error)
+
+	// FindTwinsByIDs retrieves the twins with the IDs in one query, ordered by ID. IDs that don't
+	// exist are skipped rather than an error, so callers tell them apart by what's missing.
+	FindTwinsByIDs(ctx context.Context, ids []string) ([]*model.TwinInstance, error)
+
 	// ListAll lists all stored TwinInstances. Add filtering/pagination later.
 	ListAllTwins(ctx context.Context) ([]*model.TwinInstance, error)
 
 	// ListByModel lists twins associated with a specific model ID.
 	ListTwinsByModel(ctx context.Context, modelID string) ([]*model.TwinInstance, error)
 
+	// ListTwinsByModelPage lists up to limit twins of a model with IDs greater than afterID, ordered
+	// by ID (keyset pagination; pass "" for the first page).
+	ListTwinsByModelPage(ctx context.Context, modelID string, afterID string, limit int) ([]*model.TwinInstance, error)
+
+	// ListTwinsByTags lists twins whose tags contain every key/value pair in tags (JSONB containment).
+	// An empty modelID matches all models. Used to push tag-scoped authorization into the query.
+	ListTwinsByTags(ctx context.Context, tags map[string]string, modelID string) ([]*model.TwinInstance, error)
+
+	// ListTwinsInBox lists the twins with a location inside box (see geo.Box) that match tags and
+	// modelID like ListTwinsByTags, ordered by ID. The nearest-twin search prefilters with it.
+	ListTwinsInBox(ctx context.Context, box geo.Box, tags map[string]string, modelID string) ([]*model.TwinInstance, error)
+
+	// ListTwinsPage lists one page of twins ordered by ID, or by display name (see TwinPageQuery):
+	// keyset pagination on an index, so deep pages cost the same as the first.
+	ListTwinsPage(ctx context.Context, q TwinPageQuery) ([]*model.TwinInstance, error)
+
+	// ListTwinIDs lists up to limit IDs of the twins matching tags and modelID like ListTwinsByTags,
+	// greater than afterID and ordered (keyset pagination; pass "" for the first page). Only the IDs
+	// are read, without the twins' properties.
+	ListTwinIDs(ctx context.Context, tags map[string]string, modelID string, afterID string, limit int) ([]string, error)
+
+	// StreamTwins passes the twins matching tags (nil or empty = any) and modelID ("" = any model)
+	// ordered by ID to fn one at a time as they are read, without materializing the list.
+	// Returning an error from fn stops the iteration and that error is returned. Use it for exports.
+	StreamTwins(ctx context.Context, tags map[string]string, modelID string, fn func(*model.TwinInstance) error) error
+
+	// StreamTwinFields is StreamTwins that only reads the JSON fields named in fields (TwinFields
+	// names; nil = all of them) from the store, for list views that don't need the property maps.
+	// The maps left out are unset in the twins passed to fn; the other fields are always read.
+	StreamTwinFields(ctx context.Context, fields []string, tags map[string]string, modelID string, fn func(*model.TwinInstance) error) error
+
+	// ListOrphanedTwins lists the twins whose model no longer exists, ordered by ID. The foreign key
+	// prevents new ones; this finds rows left behind from before it existed or by manual edits.
+	ListOrphanedTwins(ctx context.Context) ([]*model.TwinInstance, error)
+
 	// Update modifies mutable fields of an existing TwinInstance (e.g., properties, tags).
 	// This might be split into more granular updates later (UpdateProperties, UpdateTags).
+	// Returns ErrNotFound for a missing twin and ErrInvalidReference when its ModelID doesn't exist.
 	UpdateTwin(ctx context.Context, twin *model.TwinInstance) error
 
+	// UpdateTwinIfUnmodified is UpdateTwin in one atomic write that only applies while the twin's
+	// UpdatedAt still equals expectedUpdatedAt. Returns ErrPreconditionFailed if the twin changed
+	// in between. Use it to rewrite a twin from a copy read earlier (e.g. model migrations).
+	UpdateTwinIfUnmodified(ctx context.Context, twin *model.TwinInstance, expectedUpdatedAt time.Time) error
+
 	// UpdateReportedProperties specifically updates the reported properties field.
 	UpdateReportedProperties(ctx context.Context, id string, properties map[string]interface{}) error
 
+	// MergeReportedProperties merges a patch into the reported properties of each twin in patches
+	// (keyed by twin ID) in one transaction: top-level keys of the patch replace the twin's, the
+	// others are kept (JSONB || semantics; a null value is stored as null, not removed). Returns
+	// the new UpdatedAt of every twin updated; twins that don't exist are left out of the result
+	// instead of failing the batch.
+	MergeReportedProperties(ctx context.Context, patches map[string]map[string]interface{}) (map[string]time.Time, error)
+
 	// UpdateDesiredProperties specifically updates the desired properties field.
 	UpdateDesiredProperties(ctx context.Context, id string, properties map[string]interface{}) error
 
 	// UpdateTags specifically updates the tags field.
 	UpdateTags(ctx context.Context, id string, tags map[string]string) error
 
+	// UpdateDesiredPropertiesIfUnmodified updates desired properties only if the twin's UpdatedAt
+	// still equals expectedUpdatedAt. Returns ErrPreconditionFailed if the twin changed in between.
+	UpdateDesiredPropertiesIfUnmodified(ctx context.Context, id string, properties map[string]interface{}, expectedUpdatedAt time.Time) error
+
+	// UpdateTagsIfUnmodified updates tags only if the twin's UpdatedAt still equals expectedUpdatedAt.
+	// Returns ErrPreconditionFailed if the twin changed in between.
+	UpdateTagsIfUnmodified(ctx context.Context, id string, tags map[string]string, expectedUpdatedAt time.Time) error
+
+	// UpdateMetadata specifically updates the metadata field.
+	UpdateMetadata(ctx context.Context, id string, metadata map[string]interface{}) error
+
+	// UpdateMetadataIfUnmodified updates metadata only if the twin's UpdatedAt still equals
+	// expectedUpdatedAt. Returns ErrPreconditionFailed if the twin changed in between.
+	UpdateMetadataIfUnmodified(ctx context.Context, id string, metadata map[string]interface{}, expectedUpdatedAt time.Time) error
+
 	// Delete removes a TwinInstance by its ID. Returns ErrNotFound if not found.
 	DeleteTwin(ctx context.Context, id string) error
 
+	// DeleteTwinsByTags removes every twin whose tags contain all of tags, together with its
+	// telemetry, in one transaction, and returns the deleted IDs sorted. An empty selector
+	// (which would match every twin) returns ErrValidation.
+	DeleteTwinsByTags(ctx context.Context, tags map[string]string) ([]string, error)
+
 	// Close cleans up resources (can reuse ModelStore's Close if combined).
 	// Close() // Only needed if TwinStore is a separate struct with its own resources
 }
 
+// TwinPageQuery selects a page of ListTwinsPage.
+type TwinPageQuery struct {
+	Tags    map[string]string // Twins whose tags contain all of them (nil or empty = any)
+	ModelID string            // "" = any model
+	AfterID string            // Only IDs greater than it ("" = the first page)
+	Limit   int               // Twins per page
+	Fields  []string          // Projection like StreamTwinFields (nil = every field)
+
+	// Search keeps the twins whose display name contains it, case-insensitively ("" = any; SQLite
+	// folds ASCII letters only).
+	Search string
+
+	// ByDisplayName orders by display name in byte order (upper case before lower case; twins
+	// without one first), then by ID, instead of by ID alone. Pages then resume after
+	// (AfterDisplayName, AfterID) when AfterID is set.
+	ByDisplayName    bool
+	AfterDisplayName string
+}
+
+// TwinFields are the twin fields, by JSON name, that a projection (see StreamTwinFields) can ask
+// for. Only the JSON maps are left out of the query when not asked for; the rest are small.
+var TwinFields = []string{"id", "modelId", "displayName", "description", "reportedProperties", "desiredProperties", "tags", "metadata", "location", "createdAt", "updatedAt"}
+
+// twinFieldMaps maps the projectable twin fields to their columns.
+var twinFieldMaps = map[string]string{
+	"reportedProperties": "reported_properties",
+	"desiredProperties":  "desired_properties",
+	"tags":               "tags",
+	"metadata":           "metadata",
+}
+
+// projectTwinColumns rewrites a twin column list for a StreamTwinFields projection: the JSON map
+// columns not in fields are replaced by skipped(column), a constant the row scan reads as unset,
+// so the list keeps its shape. A nil fields keeps every column.
+func projectTwinColumns(columns string, fields []string, skipped func(column string) string) string {
+	if fields == nil {
+		return columns
+	}
+	skip := make(map[string]bool, len(twinFieldMaps))
+	for _, column := range twinFieldMaps {
+		skip[column] = true
+	}
+	for _, f := range fields {
+		delete(skip, twinFieldMaps[f])
+	}
+	parts := strings.Split(columns, ", ")
+	for i, column := range parts {
+		if skip[column] {
+			parts[i] = skipped(column) + " AS " + column
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// TemplateStore defines the interface for persistence operations related to TwinTemplates.
+// Templates reference a model by ID but aren't tied to it; callers check the model when instantiating.
+type TemplateStore interface {
+	// CreateTemplate stores a new template. Returns ErrConflict if the ID already exists.
+	CreateTemplate(ctx context.Context, template *model.TwinTemplate) error
+
+	// FindTemplateByID retrieves a template by ID. Returns ErrNotFound if not found.
+	FindTemplateByID(ctx context.Context, id string) (*model.TwinTemplate, error)
+
+	// ListAllTemplates lists all stored templates ordered by ID.
+	ListAllTemplates(ctx context.Context) ([]*model.TwinTemplate, error)
+
+	// UpdateTemplate replaces a template's mutable fields. Returns ErrNotFound if it doesn't exist.
+	UpdateTemplate(ctx context.Context, template *model.TwinTemplate) error
+
+	// DeleteTemplate removes a template by ID. Returns ErrNotFound if not found.
+	DeleteTemplate(ctx context.Context, id string) error
+}
+
+// RevokedToken is an entry of the device token revocation list (see pkg/auth Tokens). Tokens
+// themselves are never stored: they are signed and verified by the server's secret.
+type RevokedToken struct {
+	ID        string    `json:"id"`
+	RevokedAt time.Time `json:"revokedAt"`
+	Until     time.Time `json:"until"` // The token has expired by then, so the entry can be pruned
+}
+
+// TokenStore holds the device token revocation list, shared by every replica.
+type TokenStore interface {
+	// RevokeToken adds a token to the revocation list. Revoking it again keeps the first
+	// RevokedAt and the later Until.
+	RevokeToken(ctx context.Context, revoked *RevokedToken) error
+
+	// ListRevokedTokens lists the revocation list ordered by token ID.
+	ListRevokedTokens(ctx context.Context) ([]*RevokedToken, error)
+
+	// PruneRevokedTokens removes the entries whose Until is not after before and returns how many.
+	PruneRevokedTokens(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Outbox event types (OutboxEvent.Type).
+const (
+	EventTwinCreated  = "twin.created"
+	EventTwinUpdated  = "twin.updated"  // Any change but to reported properties alone: desired properties, tags, metadata, location or model
+	EventTwinReported = "twin.reported" // An update that touched only the reported properties (device reports)
+	EventTwinDeleted  = "twin.deleted"
+	EventModelCreated = "model.created"
+	EventModelUpdated = "model.updated"
+	EventModelDeleted = "model.deleted"
+
+	EventAlertTriggered = "alert.triggered" // An alert rule's condition held long enough on a twin (SubjectID)
+	EventAlertResolved  = "alert.resolved"  // A firing alert's condition stopped holding, or its twin left the rule's scope
+)
+
+// EventTypes lists every outbox event type, sorted.
+var EventTypes = []string{
+	EventAlertResolved, EventAlertTriggered, EventModelCreated, EventModelDeleted, EventModelUpdated,
+	EventTwinCreated, EventTwinDeleted, EventTwinReported, EventTwinUpdated,
+}
+
+// OutboxEvent is a change notification of the transactional outbox: stores write one in the same
+// transaction as every twin and model change (the SQL stores with triggers) and alert transition,
+// so a committed change is never left unannounced, even across restarts, and a rolled-back one is
+// never announced.
+// Events carry IDs rather than state; consumers read the current state if they need it.
+type OutboxEvent struct {
+	ID         int64     `json:"id"`               // Increasing in commit order per store; consumers dedupe redeliveries by it
+	Type       string    `json:"type"`             // One of the Event* constants
+	SubjectID  string    `json:"subjectId"`        // The twin or model ID
+	ModelID    string    `json:"modelId"`          // The twin's model; the model itself for model events
+	RuleID     string    `json:"ruleId,omitempty"` // The alert rule, for alert events
+	OccurredAt time.Time `json:"occurredAt"`
+	Attempts   int       `json:"attempts,omitempty"` // Relay delivery attempts so far, including the current one
+}
+
+// OutboxStore is the relay side of the transactional outbox (see OutboxEvent).
+type OutboxStore interface {
+	// ClaimOutboxEvents leases up to limit unsent events, oldest first, for lease: claims skip
+	// them until it expires, so a relay that dies mid-delivery gets them redelivered afterwards, by
+	// it or another replica (at least once). Every claim counts as an attempt.
+	ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEvent, error)
+
+	// MarkOutboxEventsSent records the events as delivered; they are never claimed again.
+	MarkOutboxEventsSent(ctx context.Context, ids []int64, at time.Time) error
+
+	// PruneOutboxEvents deletes the events sent before before, with pending also those never sent
+	// that occurred before it, and returns how many.
+	PruneOutboxEvents(ctx context.Context, before time.Time, pending bool) (int64, error)
+}
+
+// Webhook is a subscription to outbox events: every matching event is POSTed to URL, signed with
+// Secret, by the webhook dispatcher (pkg/webhook). Filters combine: an event must match all set.
+type Webhook struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"eventTypes"`        // Event* constants; empty matches every type
+	TwinID     string    `json:"twinId,omitempty"`  // Only events about this twin (twin and alert events)
+	ModelID    string    `json:"modelId,omitempty"` // Only events about this model or its twins
+	Secret     string    `json:"-"`                 // HMAC-SHA256 key of the payload signature; empty sends unsigned payloads
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// Matches reports whether the event passes the webhook's filters.
+func (w *Webhook) Matches(e *OutboxEvent) bool {
+	if len(w.EventTypes) > 0 {
+		found := false
+		for _, t := range w.EventTypes {
+			if t == e.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if w.TwinID != "" && (strings.HasPrefix(e.Type, "model.") || e.SubjectID != w.TwinID) {
+		return false
+	}
+	return w.ModelID == "" || e.ModelID == w.ModelID
+}
+
+// Webhook delivery states (WebhookDelivery.Status).
+const (
+	DeliveryPending   = "pending"   // Not delivered yet; attempted again at NextAttemptAt
+	DeliverySucceeded = "succeeded" // The endpoint answered 2xx
+	DeliveryFailed    = "failed"    // Every attempt failed; not retried
+)
+
+// WebhookDelivery is one event queued for one webhook, with the outcome of its attempts.
+type WebhookDelivery struct {
+	ID             int64       `json:"id"`
+	WebhookID      string      `json:"webhookId"`
+	Event          OutboxEvent `json:"event"`
+	Status         string      `json:"status"` // One of the Delivery* constants
+	Attempts       int         `json:"attempts"`
+	NextAttemptAt  time.Time   `json:"nextAttemptAt"`            // Meaningful while pending
+	LastStatusCode int         `json:"lastStatusCode,omitempty"` // HTTP status of the last attempt; 0 when there was no response
+	LastError      string      `json:"lastError,omitempty"`      // Why the last attempt failed
+	CreatedAt      time.Time   `json:"createdAt"`
+	UpdatedAt      time.Time   `json:"updatedAt"`
+}
+
+// WebhookStore holds the webhook subscriptions and their delivery queue, shared by every replica.
+type WebhookStore interface {
+	// CreateWebhook stores a new subscription. Returns ErrConflict if the ID already exists.
+	CreateWebhook(ctx context.Context, webhook *Webhook) error
+
+	// FindWebhookByID retrieves a subscription by ID. Returns ErrNotFound if not found.
+	FindWebhookByID(ctx context.Context, id string) (*Webhook, error)
+
+	// ListWebhooks lists every subscription ordered by ID.
+	ListWebhooks(ctx context.Context) ([]*Webhook, error)
+
+	// UpdateWebhook replaces a subscription's URL, event types, filters and secret. Returns
+	// ErrNotFound if it doesn't exist.
+	UpdateWebhook(ctx context.Context, webhook *Webhook) error
+
+	// DeleteWebhook removes a subscription and its deliveries. Returns ErrNotFound if not found.
+	DeleteWebhook(ctx context.Context, id string) error
+
+	// EnqueueWebhookDeliveries queues deliveries (WebhookID and Event set) as pending and due
+	// now, and returns how many were queued. An event already queued for the webhook and
+	// deliveries to deleted webhooks are skipped, so a redelivered outbox batch queues nothing twice.
+	EnqueueWebhookDeliveries(ctx context.Context, deliveries []*WebhookDelivery) (int, error)
+
+	// ClaimWebhookDeliveries leases up to limit due pending deliveries, oldest first: their
+	// NextAttemptAt moves lease ahead, so a dispatcher that dies mid-delivery leaves them to be
+	// claimed again. Every claim counts as an attempt.
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error)
+
+	// UpdateWebhookDelivery records the outcome of an attempt: Status, NextAttemptAt,
+	// LastStatusCode and LastError. Returns ErrNotFound if the delivery is gone.
+	UpdateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error
+
+	// ListWebhookDeliveries lists up to limit of a webhook's deliveries newest first, only those
+	// with an ID below beforeID unless it is 0.
+	ListWebhookDeliveries(ctx context.Context, webhookID string, beforeID int64, limit int) ([]*WebhookDelivery, error)
+
+	// PruneWebhookDeliveries deletes the finished (succeeded or failed) deliveries last updated
+	// before before and returns how many.
+	PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Alert rule comparison operators (AlertRule.Operator).
+var AlertOperators = []string{">", ">=", "<", "<=", "==", "!="}
+
+// AlertRule is a threshold on a numeric telemetry name: the alert evaluator (pkg/alert) checks
+// the latest value of Metric of every twin in the rule's scope against Operator and Value, and
+// fires an alert once the condition held for Duration, resolving it when it no longer holds.
+type AlertRule struct {
+	ID          string    `json:"id"`
+	Description string    `json:"description,omitempty"`
+	ModelID     string    `json:"modelId,omitempty"` // Scope: the model's twins; with TwinID, only that twin
+	TwinID      string    `json:"twinId,omitempty"`  // Scope: one twin
+	Metric      string    `json:"metric"`            // Telemetry name; only numeric values are compared
+	Operator    string    `json:"operator"`          // One of AlertOperators: value Operator Value
+	Value       float64   `json:"value"`
+	Duration    string    `json:"duration,omitempty"` // How long the condition must hold, a Go duration ("5m"); empty fires at once
+	Enabled     bool      `json:"enabled"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Holds reports whether v satisfies the rule's condition.
+func (r *AlertRule) Holds(v float64) bool {
+	switch r.Operator {
+	case ">":
+		return v > r.Value
+	case ">=":
+		return v >= r.Value
+	case "<":
+		return v < r.Value
+	case "<=":
+		return v <= r.Value
+	case "==":
+		return v == r.Value
+	case "!=":
+		return v != r.Value
+	}
+	return false
+}
+
+// Alert states (AlertState.State).
+const (
+	AlertPending  = "pending"  // The condition holds, not for long enough yet
+	AlertFiring   = "firing"   // The condition held for the rule's duration; EventAlertTriggered was written
+	AlertResolved = "resolved" // The condition stopped holding after firing; EventAlertResolved was written
+)
+
+// AlertState is where a twin stands with an alert rule. Twins the condition doesn't hold for
+// have no state, unless they resolved an alert.
+type AlertState struct {
+	RuleID    string    `json:"ruleId"`
+	TwinID    string    `json:"twinId"`
+	State     string    `json:"state"` // One of the Alert* constants
+	Since     time.Time `json:"since"` // When the twin entered the state
+	Value     float64   `json:"value"` // The value that caused the state
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// AlertStore holds the alert rules and the evaluator's per-twin state.
+type AlertStore interface {
+	// CreateAlertRule stores a new rule. Returns ErrConflict if the ID already exists.
+	CreateAlertRule(ctx context.Context, rule *AlertRule) error
+
+	// FindAlertRuleByID retrieves a rule by ID. Returns ErrNotFound if not found.
+	FindAlertRuleByID(ctx context.Context, id string) (*AlertRule, error)
+
+	// ListAlertRules lists every rule ordered by ID.
+	ListAlertRules(ctx context.Context) ([]*AlertRule, error)
+
+	// UpdateAlertRule replaces a rule's mutable fields. Its states are kept: the evaluator
+	// re-checks them against the new condition. Returns ErrNotFound if it doesn't exist.
+	UpdateAlertRule(ctx context.Context, rule *AlertRule) error
+
+	// DeleteAlertRule removes a rule and its states, without resolving firing alerts. Returns
+	// ErrNotFound if not found.
+	DeleteAlertRule(ctx context.Context, id string) error
+
+	// ListAlertStates lists a rule's states ordered by twin ID.
+	ListAlertStates(ctx context.Context, ruleID string) ([]*AlertState, error)
+
+	// SaveAlertState stores the state of (RuleID, TwinID) over prev, the state it was read as
+	// (nil: none), and reports whether it did. When the stored state no longer matches prev (State
+	// and Since), because another evaluator recorded a transition first or the rule was deleted,
+	// nothing is written and false is returned: evaluators on several replicas record each
+	// transition once. With event, the outbox event (Type, SubjectID, ModelID and RuleID set) is
+	// written in the same transaction, so a transition is announced exactly when it is stored.
+	// Returns ErrNotFound if the rule doesn't exist.
+	SaveAlertState(ctx context.Context, prev, state *AlertState, event *OutboxEvent) (bool, error)
+
+	// DeleteAlertState removes the state prev of (prev.RuleID, prev.TwinID) and reports whether
+	// it did; as with SaveAlertState, a state that no longer matches prev is kept.
+	DeleteAlertState(ctx context.Context, prev *AlertState) (bool, error)
+}
+
 // TelemetryRecord represents a single time-series data point.
 // Using a struct makes it easier to handle multiple value types.
 type TelemetryRecord struct {
@@ -74,37 +548,311 @@ type TelemetryRecord struct {
 	NumericValue *float64  `json:"numValue,omitempty"` // Pointer to distinguish null from 0
 	StringValue  *string   `json:"stringValue,omitempty"`
 	BooleanValue *bool     `json:"boolValue,omitempty"`
+	Quality      Quality   `json:"quality"` // Sensor-reported data quality; defaults to good
+
+	// WrittenBy is who wrote the point (the API key name or device token, see auth.Principal.Actor),
+	// for tracing bad data back to its source; empty (NULL) for anonymous and system writes.
+	WrittenBy string `json:"writtenBy,omitempty"`
 	// JSONValue    interface{} `json:"jsonValue,omitempty"` // Add if using value_jsonb
 }
 
+// Aggregation functions supported by QueryTelemetryAggregate.
+const (
+	AggregateAvg   = "avg"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateSum   = "sum"
+	AggregateCount = "count" // Counts points of any value type, not just numeric ones
+
+	// Change within a bucket, from its first to its last numeric point (by ts):
+	//   delta = last.value - first.value
+	//   rate  = (last.value - first.value) / (last.ts - first.ts in seconds), i.e. units per second
+	// Both are null for buckets with fewer than two numeric points (rate also when they share a ts).
+	// Changes across bucket boundaries are not counted, and counter resets are not compensated.
+	AggregateDelta = "delta"
+	AggregateRate  = "rate"
+)
+
+// IsValidAggregate reports whether agg is a supported aggregation function.
+func IsValidAggregate(agg string) bool {
+	switch agg {
+	case AggregateAvg, AggregateMin, AggregateMax, AggregateSum, AggregateCount, AggregateDelta, AggregateRate:
+		return true
+	}
+	return false
+}
+
+// TelemetryAggregate is one time bucket returned by QueryTelemetryAggregate.
+type TelemetryAggregate struct {
+	Bucket time.Time `json:"bucket"` // Start of the bucket
+	Value  *float64  `json:"value"`  // Aggregated value; null if the bucket has no numeric points (avg/min/max/sum) or fewer than two (delta/rate)
+
+	Quality QualityCounts `json:"quality"` // Points per quality code among those aggregated
+}
+
+// TelemetryGap is an interval without telemetry returned by QueryTelemetryGaps.
+type TelemetryGap struct {
+	Start time.Time `json:"start"` // Last point before the gap, or the range start
+	End   time.Time `json:"end"`   // First point after the gap, or the range end
+}
+
+// ModelTelemetrySummary aggregates the telemetry of a model's twins (see SummarizeModelTelemetry).
+type ModelTelemetrySummary struct {
+	ModelID   string                    `json:"modelId"`
+	Twins     int                       `json:"twins"`     // Twins of the model matching the tag selector
+	Examined  int                       `json:"examined"`  // Twins summarized: the first maxTwins by ID
+	Reporting int                       `json:"reporting"` // Examined twins with at least one telemetry point
+	Points    int64                     `json:"points"`    // Telemetry points stored for the examined twins
+	Metrics   []*TelemetryMetricSummary `json:"metrics"`   // By name
+}
+
+// ModelUsage describes how much a model is in use (see SummarizeModelUsage).
+type ModelUsage struct {
+	ModelID             string     `json:"modelId"`
+	Twins               int        `json:"twins"`               // Twins of the model matching the tag selector
+	OldestTwinCreatedAt *time.Time `json:"oldestTwinCreatedAt"` // Null without twins
+	NewestTwinCreatedAt *time.Time `json:"newestTwinCreatedAt"`
+	TelemetrySince      time.Time  `json:"telemetrySince"`  // Start of the telemetry window
+	TelemetryPoints     int64      `json:"telemetryPoints"` // Points of those twins timestamped at or after TelemetrySince
+	ReportingTwins      int        `json:"reportingTwins"`  // Twins with at least one of those points
+}
+
+// TelemetryMetricSummary aggregates the latest point of one telemetry name across the examined
+// twins of a model: each twin contributes its newest point of the name.
+type TelemetryMetricSummary struct {
+	Name     string    `json:"name"`
+	Twins    int       `json:"twins"`   // Twins with a point of this name
+	Numeric  int       `json:"numeric"` // Of those, twins whose latest point is numeric (the ones Avg/Min/Max cover)
+	Avg      *float64  `json:"avg"`     // Null when no latest point is numeric
+	Min      *float64  `json:"min"`
+	Max      *float64  `json:"max"`
+	LatestAt time.Time `json:"latestAt"` // Newest point of this name across the twins
+}
+
+// TelemetrySeriesKey identifies one metric of one twin in the results of the bulk queries.
+type TelemetrySeriesKey struct {
+	TwinID string
+	Name   string
+}
+
+// RetentionRule is a model-defined retention for one telemetry name: points named Name of twins
+// of model ModelID are deleted once they are older than Before.
+type RetentionRule struct {
+	ModelID string
+	Name    string
+	Before  time.Time
+}
+
+// DownsampledBucket is the aggregate of the points one downsampler buffered for a bucket of a
+// telemetry name (see MergeDownsampledTelemetry). Count, Sum, Min and Max recombine with those
+// of the same bucket written before.
+type DownsampledBucket struct {
+	Name        string
+	Start       time.Time // The bucket's start, stored as the point's timestamp
+	Aggregation string    // AggregateAvg (also for ""), AggregateMin, AggregateMax, AggregateSum or AggregateCount
+	Count       int
+	Sum         float64
+	Min         float64
+	Max         float64
+	Quality     Quality // The worst of the points
+	WrittenBy   string  // Empty when the points came from more than one writer
+}
+
+// Value returns the bucket's point value: its Aggregation of the points.
+func (b *DownsampledBucket) Value() float64 {
+	switch b.Aggregation {
+	case AggregateMin:
+		return b.Min
+	case AggregateMax:
+		return b.Max
+	case AggregateSum:
+		return b.Sum
+	case AggregateCount:
+		return float64(b.Count)
+	default:
+		return b.Sum / float64(b.Count)
+	}
+}
+
 // TimeSeriesStore defines the interface for persistence operations for telemetry data.
 type TimeSeriesStore interface {
 	// WriteTelemetry stores a single telemetry record.
 	WriteTelemetry(ctx context.Context, twinID string, record *TelemetryRecord) error
 
-	// WriteBatchTelemetry stores multiple telemetry records efficiently. (Implement later if needed)
-	// WriteBatchTelemetry(ctx context.Context, twinID string, records []*TelemetryRecord) error
+	// BackfillTelemetry idempotently inserts historical records, skipping any that already exist
+	// for the same (twin, name, ts). Returns how many records were actually inserted.
+	BackfillTelemetry(ctx context.Context, twinID string, records []*TelemetryRecord) (int, error)
+
+	// MergeDownsampledTelemetry stores a point per bucket of twinID at its Start, keeping the
+	// bucket's statistics with it. A bucket whose point an earlier merge stored is combined with
+	// it (counts and sums add up, the extremes and the worst quality win, and the value is
+	// recomputed), so a bucket written in parts, by several replicas or across a restart, ends up
+	// as if written at once. A bucket colliding with a point stored otherwise (written, backfilled,
+	// or downsampled before the statistics were kept) is skipped, leaving that point. Returns the
+	// points as stored, skipped buckets left out.
+	MergeDownsampledTelemetry(ctx context.Context, twinID string, buckets []*DownsampledBucket) ([]*TelemetryRecord, error)
+
+	// WriteTelemetryCopy stores records of any number of twins (each record's TwinID) as fast as
+	// the backend allows: PostgreSQL streams them with COPY. All or nothing: a record that
+	// duplicates an existing (twin, name, ts), or another record of the batch, fails the whole
+	// batch with ErrConflict; a record without TwinID fails it with ErrValidation. For
+	// high-volume ingestion; use BackfillTelemetry to skip duplicates instead.
+	WriteTelemetryCopy(ctx context.Context, records []*TelemetryRecord) error
+
+	// WriteBatchTelemetry stores records of twinID like WriteTelemetryCopy, setting each record's
+	// TwinID first.
+	WriteBatchTelemetry(ctx context.Context, twinID string, records []*TelemetryRecord) error
 
 	// QueryTelemetryHistory retrieves historical telemetry for a specific twin and metric name
 	// within a given time range. Add aggregation, downsampling options later.
-	QueryTelemetryHistory(ctx context.Context, twinID string, name string, start time.Time, end time.Time, descending bool, limit uint) ([]*TelemetryRecord, error)
+	// qualities restricts the result to those quality codes (nil or empty = any quality).
+	QueryTelemetryHistory(ctx context.Context, twinID string, name string, start time.Time, end time.Time, descending bool, limit uint, qualities []Quality) ([]*TelemetryRecord, error)
+
+	// StreamTelemetryHistory is QueryTelemetryHistory without materializing the result: fn is called
+	// for each record in order as it is read. Returning an error from fn stops the iteration and
+	// that error is returned. Use it for large exports.
+	StreamTelemetryHistory(ctx context.Context, twinID string, name string, start time.Time, end time.Time, descending bool, limit uint, qualities []Quality, fn func(*TelemetryRecord) error) error
+
+	// QueryTelemetryAggregate downsamples numeric telemetry into fixed time buckets within [start, end].
+	// agg is one of the Aggregate* constants (unknown values return ErrValidation). Buckets that are whole
+	// multiples of 24h are calendar days. tz is an IANA zone name ("" = UTC) that buckets are aligned to,
+	// so daily buckets start at local midnight even across DST changes. Empty buckets are omitted and
+	// rows are ordered by bucket start ascending. qualities filters points before aggregating
+	// (nil or empty = any quality); each bucket also reports its points per quality.
+	QueryTelemetryAggregate(ctx context.Context, twinID string, name string, start time.Time, end time.Time, bucket time.Duration, agg string, tz string, qualities []Quality) ([]*TelemetryAggregate, error)
+
+	// QueryTelemetryBulk is QueryTelemetryHistory for every combination of twinIDs and names in one
+	// query: each series holds its oldest points first, at most limit of them (0 = no limit), and
+	// records carry their TwinID. Series without points in the range are absent from the map.
+	QueryTelemetryBulk(ctx context.Context, twinIDs []string, names []string, start time.Time, end time.Time, limit uint, qualities []Quality) (map[TelemetrySeriesKey][]*TelemetryRecord, error)
+
+	// QueryTelemetryAggregateBulk is QueryTelemetryAggregate for every combination of twinIDs and
+	// names in one query. Series without points in the range are absent from the map.
+	QueryTelemetryAggregateBulk(ctx context.Context, twinIDs []string, names []string, start time.Time, end time.Time, bucket time.Duration, agg string, tz string, qualities []Quality) (map[TelemetrySeriesKey][]*TelemetryAggregate, error)
+
+	// CountTelemetry counts telemetry points for a twin and metric name within [start, end].
+	// With approximate=true the count is the query planner's row estimate: much cheaper on
+	// large hypertables but only accurate to within the table statistics.
+	CountTelemetry(ctx context.Context, twinID string, name string, start time.Time, end time.Time, approximate bool) (int64, error)
+
+	// QueryTelemetryGaps returns the intervals within [start, end] longer than threshold in which no
+	// point of the metric arrived, oldest first. The range edges count as boundaries, so a series that
+	// stopped reporting (or has no points at all) yields a gap that runs to end. threshold must be
+	// positive (ErrValidation otherwise).
+	QueryTelemetryGaps(ctx context.Context, twinID string, name string, start time.Time, end time.Time, threshold time.Duration) ([]*TelemetryGap, error)
+
+	// PruneTelemetry deletes expired telemetry and returns the number of points deleted. Each rule
+	// deletes its name's points older than its Before for the twins of its model (per twin and name,
+	// along the primary key). Every other point, including telemetry kept from deleted twins, is
+	// deleted once older than defaultBefore; the zero time keeps it forever. A rule's (model, name)
+	// never falls back to defaultBefore, so a rule may also keep data longer than the default.
+	PruneTelemetry(ctx context.Context, rules []RetentionRule, defaultBefore time.Time) (int64, error)
 
 	// QueryLatest retrieves the most recent telemetry record(s) for a twin.
-	// Can filter by name or get latest for all names.
+	// Can filter by name or get latest for all names (nil or empty names). Duplicate names are
+	// queried once and blank ones ("", whitespace only) are ignored; names made only of blanks
+	// return an empty map rather than every name. Explicit names must be answered with a lookup
+	// per name (dashboards and snapshots ask for a few names of twins that have many), not by
+	// scanning the twin's telemetry and filtering.
 	QueryLatestTelemetry(ctx context.Context, twinID string, names []string) (map[string]*TelemetryRecord, error) // Map of name -> latest record
 
+	// ListTelemetryNames returns the distinct telemetry names stored for a twin, sorted.
+	// Used to seed per-twin name caches; it is not meant for per-request hot paths.
+	ListTelemetryNames(ctx context.Context, twinID string) ([]string, error)
+
+	// SummarizeModelTelemetry aggregates the telemetry of the twins of a model whose tags contain
+	// tags (nil = all): how many report, their stored points, and per name the average, minimum
+	// and maximum of each twin's latest numeric value. All matching twins are counted but only the
+	// first maxTwins by ID are summarized (see Examined), which bounds the work on huge fleets.
+	// An unknown model yields a summary of zero twins.
+	SummarizeModelTelemetry(ctx context.Context, modelID string, tags map[string]string, maxTwins int) (*ModelTelemetrySummary, error)
+
+	// SummarizeModelUsage counts the twins of a model whose tags contain tags (nil = all), with the
+	// creation times of the oldest and newest, and the telemetry points they stored at or after
+	// since. An unknown model yields zero twins.
+	SummarizeModelUsage(ctx context.Context, modelID string, tags map[string]string, since time.Time) (*ModelUsage, error)
+
 	// Close cleans up resources (can reuse ModelStore's Close if combined).
 	// Close()
 }
 
+// latestTelemetryNames cleans the names filter of QueryLatestTelemetry: duplicates and blank
+// names are dropped, keeping the first occurrence order. none is true when names were given but
+// none is left, so nothing matches (as opposed to nil names, which match every name).
+func latestTelemetryNames(names []string) (cleaned []string, none bool) {
+	if len(names) == 0 {
+		return nil, false
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		cleaned = append(cleaned, name)
+	}
+	return cleaned, len(cleaned) == 0
+}
+
 // Combined Store Interface (Optional but convenient)
 // Allows API handlers to depend on a single store object if implementation is combined.
 type Store interface {
 	ModelStore
 	TwinStore
 	TimeSeriesStore // Add the new interface
-	// Add TimeSeriesStore later
-	Close() // Single Close method
+	TemplateStore
+	TokenStore
+	OutboxStore
+	WebhookStore
+	AlertStore
+	Ping(ctx context.Context) error // Checks the database is reachable (GET /healthz)
+	Close()                         // Single Close method
+}
+
+// PoolStats is a point-in-time snapshot of a store's database connection pool.
+type PoolStats struct {
+	TotalConns           int32         `json:"totalConns"`           // Connections currently open (idle + acquired + constructing)
+	IdleConns            int32         `json:"idleConns"`            // Connections sitting idle in the pool
+	AcquiredConns        int32         `json:"acquiredConns"`        // Connections currently checked out
+	ConstructingConns    int32         `json:"constructingConns"`    // Connections being established
+	MaxConns             int32         `json:"maxConns"`             // Configured pool size cap
+	AcquireCount         int64         `json:"acquireCount"`         // Cumulative successful acquires
+	AcquireDuration      time.Duration `json:"acquireDurationNs"`    // Cumulative time spent waiting to acquire
+	EmptyAcquireCount    int64         `json:"emptyAcquireCount"`    // Cumulative acquires that had to wait for a connection
+	CanceledAcquireCount int64         `json:"canceledAcquireCount"` // Cumulative acquires canceled by their context
+}
+
+// Unwrapper is implemented by stores that decorate another store (e.g. promremote.Store). The
+// decorator only has the Store methods, so optional interfaces such as PoolStatsProvider are
+// looked up on the store underneath (see Underlying).
+type Unwrapper interface {
+	Unwrap() Store
+}
+
+// Underlying returns the store s decorates, through any number of decorators, or s itself.
+func Underlying(s Store) Store {
+	for {
+		u, ok := s.(Unwrapper)
+		if !ok {
+			return s
+		}
+		s = u.Unwrap()
+	}
+}
+
+// PoolStatsProvider is implemented by stores backed by a connection pool.
+// It is optional so that non-pooled backends don't have to fake it.
+type PoolStatsProvider interface {
+	PoolStats() PoolStats
+}
+
+// AggregateRefresher is implemented by stores with materialized telemetry aggregates (TimescaleDB
+// continuous aggregates). It is optional: the other backends compute aggregates on read.
+type AggregateRefresher interface {
+	// RefreshContinuousAggregates recomputes the continuous aggregates over the telemetry table
+	// for [start, end) (nil = unbounded) and returns their names, sorted; none when the database
+	// has none.
+	RefreshContinuousAggregates(ctx context.Context, start, end *time.Time) ([]string, error)
 }
 
 // model-802fddd7-8818-4080-9bd9-1fa7ec392d73 - B
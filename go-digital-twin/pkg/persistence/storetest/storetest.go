@@ -0,0 +1,1943 @@
+// pkg/persistence/storetest/storetest.go
+
+// Package storetest is a conformance suite for persistence.Store implementations. Every backend
+// should pass it so handlers behave the same whichever one STORE_BACKEND selects:
+//
+//	func TestMemoryStore(t *testing.T) {
+//		storetest.RunStoreTests(t, func() persistence.Store { return persistence.NewMemoryStore() })
+//	}
+//
+// newStore is called once per subtest and must return an empty store; the suite closes it.
+// For PostgresModelStore point it at a throwaway database (e.g. a TimescaleDB test container with
+// sql/*.sql applied) and truncate the tables before returning the store. pkg/persistence runs the
+// suite against every backend; TestPostgresModelStore needs TEST_DATABASE_URL.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/geo"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+)
+
+// RunStoreTests runs the conformance suite against the stores returned by newStore.
+func RunStoreTests(t *testing.T, newStore func() persistence.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, ctx context.Context, s persistence.Store)
+	}{
+		{"Models", testModels},
+		{"ModelCategories", testModelCategories},
+		{"ModelHistory", testModelHistory},
+		{"Twins", testTwins},
+		{"TwinModelReferences", testTwinModelReferences},
+		{"TwinPagination", testTwinPagination},
+		{"TwinTags", testTwinTags},
+		{"TwinDisplayNames", testTwinDisplayNames},
+		{"TwinLocations", testTwinLocations},
+		{"TwinBulkDelete", testTwinBulkDelete},
+		{"TwinFieldUpdates", testTwinFieldUpdates},
+		{"ReportedPropertiesBatch", testReportedPropertiesBatch},
+		{"TwinOptimisticConcurrency", testTwinOptimisticConcurrency},
+		{"Templates", testTemplates},
+		{"RevokedTokens", testRevokedTokens},
+		{"Outbox", testOutbox},
+		{"Webhooks", testWebhooks},
+		{"AlertRules", testAlertRules},
+		{"TelemetryWrite", testTelemetryWrite},
+		{"TelemetryBackfill", testTelemetryBackfill},
+		{"DownsampledTelemetry", testDownsampledTelemetry},
+		{"TelemetryCopy", testTelemetryCopy},
+		{"TelemetryBatch", testTelemetryBatch},
+		{"TelemetryHistory", testTelemetryHistory},
+		{"TelemetryStream", testTelemetryStream},
+		{"TelemetryAggregate", testTelemetryAggregate},
+		{"TelemetryCountLatestNames", testTelemetryCountLatestNames},
+		{"TelemetryGaps", testTelemetryGaps},
+		{"TelemetryBulk", testTelemetryBulk},
+		{"TelemetryRetention", testTelemetryRetention},
+		{"ModelTelemetrySummary", testModelTelemetrySummary},
+		{"ModelUsage", testModelUsage},
+		{"EmptyResults", testEmptyResults},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newStore()
+			t.Cleanup(s.Close)
+			tc.fn(t, context.Background(), s)
+		})
+	}
+}
+
+// --- Fixtures and assertions ---
+
+// telemetryBase is the start of the telemetry fixtures (bucket-aligned for 5m buckets).
+var telemetryBase = time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// now returns the current time at the precision every backend stores (TIMESTAMPTZ microseconds).
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func float(v float64) *float64 { return &v }
+
+func newModel(id, category string) *model.TwinModel {
+	ts := now()
+	return &model.TwinModel{ID: id, DisplayName: "Model " + id, Category: category, CreatedAt: ts, UpdatedAt: ts}
+}
+
+func newTwin(id, modelID string, tags map[string]string) *model.TwinInstance {
+	ts := now()
+	return &model.TwinInstance{
+		ID:                 id,
+		ModelID:            modelID,
+		ReportedProperties: map[string]interface{}{},
+		DesiredProperties:  map[string]interface{}{},
+		Tags:               tags,
+		CreatedAt:          ts,
+		UpdatedAt:          ts,
+	}
+}
+
+func numericRecord(name string, offset time.Duration, v float64, q persistence.Quality) *persistence.TelemetryRecord {
+	return &persistence.TelemetryRecord{Name: name, Timestamp: telemetryBase.Add(offset), NumericValue: float(v), Quality: q}
+}
+
+func mustNoError(t *testing.T, err error, what string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: unexpected error: %v", what, err)
+	}
+}
+
+func wantError(t *testing.T, err error, target error, what string) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Fatalf("%s: got error %v, want %v", what, err, target)
+	}
+}
+
+func mustCreateModel(t *testing.T, ctx context.Context, s persistence.Store, id string) {
+	t.Helper()
+	mustNoError(t, s.CreateModel(ctx, newModel(id, "")), "CreateModel "+id)
+}
+
+func mustCreateTwin(t *testing.T, ctx context.Context, s persistence.Store, twin *model.TwinInstance) {
+	t.Helper()
+	mustNoError(t, s.CreateTwin(ctx, twin), "CreateTwin "+twin.ID)
+}
+
+func twinIDs(twins []*model.TwinInstance) []string {
+	ids := make([]string, len(twins))
+	for i, twin := range twins {
+		ids[i] = twin.ID
+	}
+	return ids
+}
+
+func wantIDs(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("%s: got IDs %v, want %v", what, got, want)
+	}
+}
+
+func wantTimes(t *testing.T, what string, records []*persistence.TelemetryRecord, offsets ...time.Duration) {
+	t.Helper()
+	if len(records) != len(offsets) {
+		t.Fatalf("%s: got %d records, want %d", what, len(records), len(offsets))
+	}
+	for i, rec := range records {
+		if want := telemetryBase.Add(offsets[i]); !rec.Timestamp.Equal(want) {
+			t.Fatalf("%s: record %d has ts %s, want %s", what, i, rec.Timestamp, want)
+		}
+	}
+}
+
+func wantValue(t *testing.T, what string, got *float64, want float64) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("%s: got null, want %v", what, want)
+	}
+	if math.Abs(*got-want) > 1e-9 {
+		t.Fatalf("%s: got %v, want %v", what, *got, want)
+	}
+}
+
+// --- ModelStore ---
+
+func testModels(t *testing.T, ctx context.Context, s persistence.Store) {
+	m := newModel("m1", "HVAC")
+	m.Description = "Thermostat"
+	m.Properties = map[string]model.PropertyDefinition{
+		"setpoint": {Name: "setpoint", Schema: "double"},
+		"mode":     {Name: "mode", Schema: "string", Writable: true, Enum: []interface{}{"heating", "cooling", "off"}},
+	}
+	m.AllowedTelemetryNames = []string{"temperature", "humidity"}
+	m.TelemetryNameMappings = map[string]string{"temp": "temperature"}
+	m.DerivedProperties = map[string]model.DerivedProperty{"status": {Expression: `reportedProperties.temperature > 30 ? "hot" : "ok"`}}
+	m.Telemetry = map[string]model.TelemetryDefinition{"temperature": {Unit: "°C", Retention: "7d", Aggregation: "max"}, "humidity": {Enum: []string{"dry", "wet"}}}
+	m.StrictReportedTypes = true
+	m.TagSchema = map[string]model.TagDefinition{"site": {Required: true, Values: []string{"a", "b"}}, "asset": {Pattern: "[A-Z]{2}-[0-9]+"}}
+	mustNoError(t, s.CreateModel(ctx, m), "CreateModel")
+	wantError(t, s.CreateModel(ctx, newModel("m1", "")), persistence.ErrConflict, "CreateModel duplicate")
+
+	got, err := s.FindModelByID(ctx, "m1")
+	mustNoError(t, err, "FindModelByID")
+	if got.DisplayName != m.DisplayName || got.Description != m.Description || got.Category != m.Category {
+		t.Fatalf("FindModelByID: got %+v, want %+v", got, m)
+	}
+	if got.Properties["setpoint"].Schema != "double" || fmt.Sprint(got.Properties["mode"].Enum) != "[heating cooling off]" || len(got.Properties) != 2 {
+		t.Fatalf("FindModelByID: got properties %+v, want %+v", got.Properties, m.Properties)
+	}
+	if fmt.Sprint(got.AllowedTelemetryNames) != "[temperature humidity]" {
+		t.Fatalf("FindModelByID: got allowedTelemetryNames %v, want %v", got.AllowedTelemetryNames, m.AllowedTelemetryNames)
+	}
+	if len(got.TelemetryNameMappings) != 1 || got.TelemetryNameMappings["temp"] != "temperature" {
+		t.Fatalf("FindModelByID: got telemetryNameMappings %v, want %v", got.TelemetryNameMappings, m.TelemetryNameMappings)
+	}
+	if len(got.DerivedProperties) != 1 || got.DerivedProperties["status"] != m.DerivedProperties["status"] {
+		t.Fatalf("FindModelByID: got derivedProperties %v, want %v", got.DerivedProperties, m.DerivedProperties)
+	}
+	if !reflect.DeepEqual(got.Telemetry, m.Telemetry) {
+		t.Fatalf("FindModelByID: got telemetry %v, want %v", got.Telemetry, m.Telemetry)
+	}
+	if !got.StrictReportedTypes {
+		t.Fatalf("FindModelByID: got strictReportedTypes false, want true")
+	}
+	if !reflect.DeepEqual(got.TagSchema, m.TagSchema) {
+		t.Fatalf("FindModelByID: got tagSchema %v, want %v", got.TagSchema, m.TagSchema)
+	}
+	if !got.CreatedAt.Equal(m.CreatedAt) {
+		t.Fatalf("FindModelByID: got createdAt %s, want %s", got.CreatedAt, m.CreatedAt)
+	}
+	_, err = s.FindModelByID(ctx, "missing")
+	wantError(t, err, persistence.ErrNotFound, "FindModelByID missing")
+
+	// Updates replace every mutable field, keep createdAt and bump updatedAt
+	got.DisplayName = "Renamed"
+	got.Category = "Lighting"
+	got.Properties = nil
+	got.AllowedTelemetryNames = nil
+	got.TelemetryNameMappings = nil
+	got.DerivedProperties = nil
+	got.Telemetry = nil
+	got.StrictReportedTypes = false
+	got.TagSchema = nil
+	mustNoError(t, s.UpdateModel(ctx, got), "UpdateModel")
+	updated, err := s.FindModelByID(ctx, "m1")
+	mustNoError(t, err, "FindModelByID after update")
+	if updated.DisplayName != "Renamed" || updated.Category != "Lighting" || len(updated.Properties) != 0 || len(updated.AllowedTelemetryNames) != 0 || len(updated.TelemetryNameMappings) != 0 || len(updated.DerivedProperties) != 0 || len(updated.Telemetry) != 0 || updated.StrictReportedTypes || len(updated.TagSchema) != 0 {
+		t.Fatalf("UpdateModel: got %+v", updated)
+	}
+	if !updated.CreatedAt.Equal(m.CreatedAt) || updated.UpdatedAt.Before(m.UpdatedAt) {
+		t.Fatalf("UpdateModel: got createdAt %s / updatedAt %s, created %s", updated.CreatedAt, updated.UpdatedAt, m.CreatedAt)
+	}
+	wantError(t, s.UpdateModel(ctx, newModel("missing", "")), persistence.ErrNotFound, "UpdateModel missing")
+
+	mustCreateModel(t, ctx, s, "m0")
+	models, err := s.ListAllModels(ctx)
+	mustNoError(t, err, "ListAllModels")
+	if len(models) != 2 || models[0].ID != "m0" || models[1].ID != "m1" {
+		t.Fatalf("ListAllModels: got %d models, want m0, m1 in ID order", len(models))
+	}
+
+	mustNoError(t, s.DeleteModel(ctx, "m1"), "DeleteModel")
+	_, err = s.FindModelByID(ctx, "m1")
+	wantError(t, err, persistence.ErrNotFound, "FindModelByID after delete")
+	wantError(t, s.DeleteModel(ctx, "m1"), persistence.ErrNotFound, "DeleteModel missing")
+}
+
+func testModelHistory(t *testing.T, ctx context.Context, s persistence.Store) {
+	m := newModel("m", "")
+	m.DisplayName = "First"
+	mustNoError(t, s.CreateModel(persistence.WithChangedBy(ctx, "alice"), m), "CreateModel")
+	m.DisplayName = "Second"
+	mustNoError(t, s.UpdateModel(persistence.WithChangedBy(ctx, "bob"), m), "UpdateModel")
+	mustNoError(t, s.DeleteModel(ctx, "m"), "DeleteModel")
+	// A model recreated under the same ID continues the history
+	mustNoError(t, s.CreateModel(ctx, newModel("m", "")), "CreateModel again")
+	wantError(t, s.UpdateModel(ctx, newModel("missing", "")), persistence.ErrNotFound, "UpdateModel missing")
+
+	versions, err := s.ListModelVersions(ctx, "m")
+	mustNoError(t, err, "ListModelVersions")
+	want := []struct{ change, by string }{
+		{persistence.ModelChangeCreated, "alice"},
+		{persistence.ModelChangeUpdated, "bob"},
+		{persistence.ModelChangeDeleted, ""},
+		{persistence.ModelChangeCreated, ""},
+	}
+	if len(versions) != len(want) {
+		t.Fatalf("ListModelVersions: got %d versions, want %d", len(versions), len(want))
+	}
+	for i, v := range versions {
+		if v.Version != i+1 || v.Change != want[i].change || v.ChangedBy != want[i].by || v.ModelID != "m" {
+			t.Fatalf("ListModelVersions: version %d is %+v, want change %q by %q", i+1, v, want[i].change, want[i].by)
+		}
+		if v.Model != nil {
+			t.Fatalf("ListModelVersions: version %d includes its definition", v.Version)
+		}
+		if v.ChangedAt.IsZero() || (i > 0 && v.ChangedAt.Before(versions[i-1].ChangedAt)) {
+			t.Fatalf("ListModelVersions: version %d changed at %s", v.Version, v.ChangedAt)
+		}
+	}
+
+	for version, name := range map[int]string{1: "First", 2: "Second", 3: "Second"} {
+		v, err := s.FindModelVersion(ctx, "m", version)
+		mustNoError(t, err, fmt.Sprintf("FindModelVersion %d", version))
+		if v.Model == nil || v.Model.ID != "m" || v.Model.DisplayName != name {
+			t.Fatalf("FindModelVersion %d: got definition %+v, want displayName %q", version, v.Model, name)
+		}
+	}
+	_, err = s.FindModelVersion(ctx, "m", 5)
+	wantError(t, err, persistence.ErrNotFound, "FindModelVersion missing version")
+
+	// Failed changes record nothing
+	empty, err := s.ListModelVersions(ctx, "missing")
+	mustNoError(t, err, "ListModelVersions without history")
+	if empty == nil || len(empty) != 0 {
+		t.Fatalf("ListModelVersions without history: got %v, want an empty, non-nil result", empty)
+	}
+}
+
+func testModelCategories(t *testing.T, ctx context.Context, s persistence.Store) {
+	for id, category := range map[string]string{"a": "HVAC", "b": "hvac", "c": "Lighting", "d": ""} {
+		mustNoError(t, s.CreateModel(ctx, newModel(id, category)), "CreateModel "+id)
+	}
+
+	models, err := s.ListModelsByCategory(ctx, "Hvac")
+	mustNoError(t, err, "ListModelsByCategory")
+	if len(models) != 2 || models[0].ID != "a" || models[1].ID != "b" {
+		t.Fatalf("ListModelsByCategory: got %d models, want a, b (case-insensitive match)", len(models))
+	}
+
+	for category, want := range map[string]string{"Hvac": "[a b]", "": "[a b c d]"} {
+		var streamed []string
+		err := s.StreamModels(ctx, category, func(m *model.TwinModel) error {
+			streamed = append(streamed, m.ID)
+			return nil
+		})
+		mustNoError(t, err, "StreamModels")
+		if fmt.Sprint(streamed) != want {
+			t.Fatalf("StreamModels(%q): got %v, want %s", category, streamed, want)
+		}
+	}
+
+	categories, err := s.ListModelCategories(ctx)
+	mustNoError(t, err, "ListModelCategories")
+	got := make([]string, len(categories))
+	for i, c := range categories {
+		got[i] = fmt.Sprintf("%s=%d", c.Name, c.Count)
+	}
+	// Case variants are grouped under the lexically smallest spelling; empty categories are skipped
+	if want := "[HVAC=2 Lighting=1]"; fmt.Sprint(got) != want {
+		t.Fatalf("ListModelCategories: got %v, want %s", got, want)
+	}
+}
+
+// --- TwinStore ---
+
+func testTwins(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "m")
+	twin := newTwin("t1", "m", map[string]string{"site": "north"})
+	twin.ReportedProperties = map[string]interface{}{"temperature": 21.5, "mode": "auto"}
+	twin.DesiredProperties = map[string]interface{}{"setpoint": 22.0}
+	twin.Metadata = map[string]interface{}{"serial": 1234.0, "calibrated": true, "location": map[string]interface{}{"rack": "r1"}}
+	twin.DisplayName, twin.Description = "Boiler 1", "Basement boiler"
+	mustCreateTwin(t, ctx, s, twin)
+	wantError(t, s.CreateTwin(ctx, newTwin("t1", "m", nil)), persistence.ErrConflict, "CreateTwin duplicate")
+
+	got, err := s.FindTwinByID(ctx, "t1")
+	mustNoError(t, err, "FindTwinByID")
+	if got.ModelID != "m" || got.Tags["site"] != "north" || got.ReportedProperties["mode"] != "auto" ||
+		got.DisplayName != "Boiler 1" || got.Description != "Basement boiler" {
+		t.Fatalf("FindTwinByID: got %+v", got)
+	}
+	// Properties come back JSON-decoded, so numbers are float64 in every backend
+	if v, ok := got.ReportedProperties["temperature"].(float64); !ok || v != 21.5 {
+		t.Fatalf("FindTwinByID: got reported temperature %#v, want 21.5", got.ReportedProperties["temperature"])
+	}
+	// Metadata keeps its JSON types (unlike tags, which are strings)
+	if got.Metadata["serial"] != 1234.0 || got.Metadata["calibrated"] != true || fmt.Sprint(got.Metadata["location"]) != "map[rack:r1]" {
+		t.Fatalf("FindTwinByID: got metadata %#v", got.Metadata)
+	}
+	if !got.CreatedAt.Equal(twin.CreatedAt) || !got.UpdatedAt.Equal(twin.UpdatedAt) {
+		t.Fatalf("FindTwinByID: got timestamps %s / %s, want %s / %s", got.CreatedAt, got.UpdatedAt, twin.CreatedAt, twin.UpdatedAt)
+	}
+	for id, want := range map[string]bool{"t1": true, "missing": false} {
+		exists, err := s.TwinExists(ctx, id)
+		mustNoError(t, err, "TwinExists")
+		if exists != want {
+			t.Fatalf("TwinExists(%q): got %t, want %t", id, exists, want)
+		}
+	}
+	_, err = s.FindTwinByID(ctx, "missing")
+	wantError(t, err, persistence.ErrNotFound, "FindTwinByID missing")
+	found, err := s.FindTwinsByIDs(ctx, []string{"missing", "t1", "t1"})
+	mustNoError(t, err, "FindTwinsByIDs")
+	if len(found) != 1 || found[0].ID != "t1" || found[0].ModelID != twin.ModelID {
+		t.Fatalf("FindTwinsByIDs: got %d twins, want only t1", len(found))
+	}
+	found, err = s.FindTwinsByIDs(ctx, nil)
+	mustNoError(t, err, "FindTwinsByIDs without IDs")
+	if len(found) != 0 {
+		t.Fatalf("FindTwinsByIDs without IDs: got %d twins, want none", len(found))
+	}
+
+	got.ReportedProperties = map[string]interface{}{"temperature": 19.0}
+	got.DesiredProperties = nil
+	got.Tags = map[string]string{"site": "south"}
+	got.Metadata = nil
+	got.DisplayName, got.Description = "Boiler 2", ""
+	mustNoError(t, s.UpdateTwin(ctx, got), "UpdateTwin")
+	updated, err := s.FindTwinByID(ctx, "t1")
+	mustNoError(t, err, "FindTwinByID after update")
+	if updated.ReportedProperties["temperature"] != 19.0 || len(updated.DesiredProperties) != 0 || updated.Tags["site"] != "south" || len(updated.Metadata) != 0 ||
+		updated.DisplayName != "Boiler 2" || updated.Description != "" {
+		t.Fatalf("UpdateTwin: got %+v", updated)
+	}
+	if !updated.CreatedAt.Equal(twin.CreatedAt) || updated.UpdatedAt.Before(twin.UpdatedAt) {
+		t.Fatalf("UpdateTwin: got createdAt %s / updatedAt %s, created %s", updated.CreatedAt, updated.UpdatedAt, twin.CreatedAt)
+	}
+	wantError(t, s.UpdateTwin(ctx, newTwin("missing", "m", nil)), persistence.ErrNotFound, "UpdateTwin missing")
+
+	mustCreateTwin(t, ctx, s, newTwin("t0", "m", nil))
+	twins, err := s.ListAllTwins(ctx)
+	mustNoError(t, err, "ListAllTwins")
+	wantIDs(t, "ListAllTwins", twinIDs(twins), "t0", "t1")
+
+	// Property maps that were never set read back nil, explicitly empty ones as empty maps;
+	// tags are always set
+	unset := newTwin("unset", "m", nil)
+	unset.ReportedProperties, unset.Tags = nil, nil
+	mustCreateTwin(t, ctx, s, unset)
+	got, err = s.FindTwinByID(ctx, "unset")
+	mustNoError(t, err, "FindTwinByID unset")
+	if got.ReportedProperties != nil || got.DesiredProperties == nil || len(got.DesiredProperties) != 0 || got.Tags == nil {
+		t.Fatalf("FindTwinByID unset: got reported %#v, desired %#v, tags %#v", got.ReportedProperties, got.DesiredProperties, got.Tags)
+	}
+	got.ReportedProperties, got.DesiredProperties = map[string]interface{}{}, nil
+	mustNoError(t, s.UpdateTwin(ctx, got), "UpdateTwin unset")
+	got, err = s.FindTwinByID(ctx, "unset")
+	mustNoError(t, err, "FindTwinByID unset after update")
+	if got.ReportedProperties == nil || len(got.ReportedProperties) != 0 || got.DesiredProperties != nil {
+		t.Fatalf("UpdateTwin unset: got reported %#v, desired %#v", got.ReportedProperties, got.DesiredProperties)
+	}
+	mustNoError(t, s.UpdateDesiredProperties(ctx, "unset", nil), "UpdateDesiredProperties nil")
+	got, err = s.FindTwinByID(ctx, "unset")
+	mustNoError(t, err, "FindTwinByID after UpdateDesiredProperties")
+	if got.DesiredProperties == nil || len(got.DesiredProperties) != 0 { // Writing a field sets it
+		t.Fatalf("UpdateDesiredProperties nil: got desired %#v, want {}", got.DesiredProperties)
+	}
+
+	mustNoError(t, s.DeleteTwin(ctx, "t1"), "DeleteTwin")
+	_, err = s.FindTwinByID(ctx, "t1")
+	wantError(t, err, persistence.ErrNotFound, "FindTwinByID after delete")
+	wantError(t, s.DeleteTwin(ctx, "t1"), persistence.ErrNotFound, "DeleteTwin missing")
+}
+
+func testTwinModelReferences(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "m")
+	wantError(t, s.CreateTwin(ctx, newTwin("t", "missing", nil)), persistence.ErrInvalidReference, "CreateTwin with unknown model")
+
+	mustCreateTwin(t, ctx, s, newTwin("t", "m", nil))
+	wantError(t, s.UpdateTwin(ctx, newTwin("t", "missing", nil)), persistence.ErrInvalidReference, "UpdateTwin with unknown model")
+
+	// Models referenced by twins can't be deleted (ON DELETE RESTRICT)
+	wantError(t, s.DeleteModel(ctx, "m"), persistence.ErrConflict, "DeleteModel still referenced")
+	// ...so through the store twins never end up orphaned
+	orphans, err := s.ListOrphanedTwins(ctx)
+	mustNoError(t, err, "ListOrphanedTwins")
+	wantIDs(t, "ListOrphanedTwins", twinIDs(orphans))
+	mustNoError(t, s.DeleteTwin(ctx, "t"), "DeleteTwin")
+	mustNoError(t, s.DeleteModel(ctx, "m"), "DeleteModel after its twins are gone")
+}
+
+func testTwinPagination(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "m")
+	mustCreateModel(t, ctx, s, "other")
+	for _, id := range []string{"t3", "t1", "t4", "t2", "t5"} {
+		mustCreateTwin(t, ctx, s, newTwin(id, "m", nil))
+	}
+	mustCreateTwin(t, ctx, s, newTwin("t0", "other", nil))
+
+	byModel, err := s.ListTwinsByModel(ctx, "m")
+	mustNoError(t, err, "ListTwinsByModel")
+	wantIDs(t, "ListTwinsByModel", twinIDs(byModel), "t1", "t2", "t3", "t4", "t5")
+
+	var pages [][]string
+	after := ""
+	for {
+		page, err := s.ListTwinsByModelPage(ctx, "m", after, 2)
+		mustNoError(t, err, "ListTwinsByModelPage")
+		if len(page) == 0 {
+			break
+		}
+		pages = append(pages, twinIDs(page))
+		after = page[len(page)-1].ID
+	}
+	if want := "[[t1 t2] [t3 t4] [t5]]"; fmt.Sprint(pages) != want {
+		t.Fatalf("ListTwinsByModelPage: got pages %v, want %s", pages, want)
+	}
+
+	// So do ListTwinsPage, across models and with a projection
+	pages, after = nil, ""
+	for {
+		page, err := s.ListTwinsPage(ctx, persistence.TwinPageQuery{AfterID: after, Limit: 4, Fields: []string{"id"}})
+		mustNoError(t, err, "ListTwinsPage")
+		if len(page) == 0 {
+			break
+		}
+		if page[0].ModelID == "" || page[0].ReportedProperties != nil {
+			t.Fatalf("ListTwinsPage: got %+v, want the model but no reported properties", page[0])
+		}
+		pages = append(pages, twinIDs(page))
+		after = page[len(page)-1].ID
+	}
+	if want := "[[t0 t1 t2 t3] [t4 t5]]"; fmt.Sprint(pages) != want {
+		t.Fatalf("ListTwinsPage: got pages %v, want %s", pages, want)
+	}
+	page, err := s.ListTwinsPage(ctx, persistence.TwinPageQuery{ModelID: "m", AfterID: "t2", Limit: 2})
+	mustNoError(t, err, "ListTwinsPage by model")
+	wantIDs(t, "ListTwinsPage by model", twinIDs(page), "t3", "t4")
+	if page[0].ReportedProperties == nil {
+		t.Fatalf("ListTwinsPage without fields: got %+v, want every field", page[0])
+	}
+
+	// The ID listings page the same way
+	pages, after = nil, ""
+	for {
+		page, err := s.ListTwinIDs(ctx, nil, "m", after, 2)
+		mustNoError(t, err, "ListTwinIDs")
+		if len(page) == 0 {
+			break
+		}
+		pages = append(pages, page)
+		after = page[len(page)-1]
+	}
+	if want := "[[t1 t2] [t3 t4] [t5]]"; fmt.Sprint(pages) != want {
+		t.Fatalf("ListTwinIDs: got pages %v, want %s", pages, want)
+	}
+	all, err := s.ListTwinIDs(ctx, nil, "", "", 10)
+	mustNoError(t, err, "ListTwinIDs")
+	wantIDs(t, "ListTwinIDs without a model", all, "t0", "t1", "t2", "t3", "t4", "t5")
+
+	modelIDs, err := s.ListModelIDs(ctx, "", 1)
+	mustNoError(t, err, "ListModelIDs")
+	wantIDs(t, "ListModelIDs first page", modelIDs, "m")
+	modelIDs, err = s.ListModelIDs(ctx, "m", 10)
+	mustNoError(t, err, "ListModelIDs")
+	wantIDs(t, "ListModelIDs after 'm'", modelIDs, "other")
+}
+
+func testTwinDisplayNames(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "m")
+	for id, name := range map[string]string{"a": "pump a", "b": "Pump B", "c": "", "d": "Valve", "e": "Pump B"} {
+		twin := newTwin(id, "m", nil)
+		twin.DisplayName = name
+		mustCreateTwin(t, ctx, s, twin)
+	}
+
+	// Search is a case-insensitive substring match and keeps the ID order
+	page, err := s.ListTwinsPage(ctx, persistence.TwinPageQuery{Search: "PUMP", Limit: 10})
+	mustNoError(t, err, "ListTwinsPage search")
+	wantIDs(t, "ListTwinsPage search", twinIDs(page), "a", "b", "e")
+	page, err = s.ListTwinsPage(ctx, persistence.TwinPageQuery{Search: "pump", AfterID: "a", Limit: 10})
+	mustNoError(t, err, "ListTwinsPage search after 'a'")
+	wantIDs(t, "ListTwinsPage search after 'a'", twinIDs(page), "b", "e")
+
+	// By display name in byte order (no name first, upper before lower case), then by ID
+	var pages [][]string
+	q := persistence.TwinPageQuery{ByDisplayName: true, Limit: 2, Fields: []string{"id"}}
+	for {
+		page, err := s.ListTwinsPage(ctx, q)
+		mustNoError(t, err, "ListTwinsPage by display name")
+		if len(page) == 0 {
+			break
+		}
+		pages = append(pages, twinIDs(page))
+		last := page[len(page)-1]
+		q.AfterDisplayName, q.AfterID = last.DisplayName, last.ID
+	}
+	if want := "[[c b] [e d] [a]]"; fmt.Sprint(pages) != want {
+		t.Fatalf("ListTwinsPage by display name: got pages %v, want %s", pages, want)
+	}
+	page, err = s.ListTwinsPage(ctx, persistence.TwinPageQuery{ByDisplayName: true, Search: "pump", Limit: 10})
+	mustNoError(t, err, "ListTwinsPage search by display name")
+	wantIDs(t, "ListTwinsPage search by display name", twinIDs(page), "b", "e", "a")
+}
+
+func testTwinTags(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "m")
+	mustCreateModel(t, ctx, s, "other")
+	mustCreateTwin(t, ctx, s, newTwin("a", "m", map[string]string{"site": "north", "floor": "1"}))
+	mustCreateTwin(t, ctx, s, newTwin("b", "m", map[string]string{"site": "north", "floor": "2"}))
+	mustCreateTwin(t, ctx, s, newTwin("c", "other", map[string]string{"site": "north"}))
+	mustCreateTwin(t, ctx, s, newTwin("d", "m", nil))
+
+	for _, tc := range []struct {
+		tags    map[string]string
+		modelID string
+		want    []string
+	}{
+		{map[string]string{"site": "north"}, "", []string{"a", "b", "c"}},
+		{map[string]string{"site": "north"}, "m", []string{"a", "b"}},
+		{map[string]string{"site": "north", "floor": "2"}, "", []string{"b"}},
+		{map[string]string{"site": "south"}, "", []string{}},
+		{map[string]string{"site": "north", "missing": "x"}, "", []string{}},
+		{map[string]string{}, "m", []string{"a", "b", "d"}}, // Empty selector matches every twin
+		{nil, "", []string{"a", "b", "c", "d"}},
+	} {
+		twins, err := s.ListTwinsByTags(ctx, tc.tags, tc.modelID)
+		mustNoError(t, err, "ListTwinsByTags")
+		wantIDs(t, fmt.Sprintf("ListTwinsByTags(%v, %q)", tc.tags, tc.modelID), twinIDs(twins), tc.want...)
+
+		ids, err := s.ListTwinIDs(ctx, tc.tags, tc.modelID, "", 10)
+		mustNoError(t, err, "ListTwinIDs")
+		wantIDs(t, fmt.Sprintf("ListTwinIDs(%v, %q)", tc.tags, tc.modelID), ids, tc.want...)
+
+		page, err := s.ListTwinsPage(ctx, persistence.TwinPageQuery{Tags: tc.tags, ModelID: tc.modelID, Limit: 10})
+		mustNoError(t, err, "ListTwinsPage")
+		wantIDs(t, fmt.Sprintf("ListTwinsPage(%v, %q)", tc.tags, tc.modelID), twinIDs(page), tc.want...)
+
+		streamed := []*model.TwinInstance{}
+		err = s.StreamTwins(ctx, tc.tags, tc.modelID, func(twin *model.TwinInstance) error {
+			streamed = append(streamed, twin)
+			return nil
+		})
+		mustNoError(t, err, "StreamTwins")
+		wantIDs(t, fmt.Sprintf("StreamTwins(%v, %q)", tc.tags, tc.modelID), twinIDs(streamed), tc.want...)
+	}
+
+	// An error from fn stops the iteration and is returned as is
+	stop := errors.New("stop")
+	calls := 0
+	err := s.StreamTwins(ctx, nil, "", func(*model.TwinInstance) error {
+		calls++
+		return stop
+	})
+	wantError(t, err, stop, "StreamTwins callback error")
+	if calls != 1 {
+		t.Fatalf("StreamTwins callback error: fn called %d times, want 1", calls)
+	}
+
+	// A projection reads only the maps asked for; the others come back unset
+	mustNoError(t, s.UpdateReportedProperties(ctx, "a", map[string]interface{}{"temp": 21.5}), "UpdateReportedProperties")
+	projected := []*model.TwinInstance{}
+	err = s.StreamTwinFields(ctx, []string{"id", "tags"}, map[string]string{"site": "north"}, "m", func(twin *model.TwinInstance) error {
+		projected = append(projected, twin)
+		return nil
+	})
+	mustNoError(t, err, "StreamTwinFields")
+	wantIDs(t, "StreamTwinFields", twinIDs(projected), "a", "b")
+	if got := projected[0]; got.ModelID != "m" || got.Tags["floor"] != "1" || got.ReportedProperties != nil || got.DesiredProperties != nil || got.CreatedAt.IsZero() {
+		t.Fatalf("StreamTwinFields([id tags]): got %+v", got)
+	}
+	projected = projected[:0]
+	err = s.StreamTwinFields(ctx, []string{"reportedProperties"}, nil, "", func(twin *model.TwinInstance) error {
+		projected = append(projected, twin)
+		return nil
+	})
+	mustNoError(t, err, "StreamTwinFields")
+	if got := projected[0]; got.ReportedProperties["temp"] != 21.5 || got.Tags == nil || len(got.Tags) != 0 {
+		t.Fatalf("StreamTwinFields([reportedProperties]): got %+v", got)
+	}
+}
+
+func testTwinLocations(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "m")
+	mustCreateModel(t, ctx, s, "other")
+	located := func(id, modelID string, tags map[string]string, lat, lng float64) *model.TwinInstance {
+		twin := newTwin(id, modelID, tags)
+		twin.Location = &model.GeoPoint{Lat: lat, Lng: lng}
+		return twin
+	}
+	mustCreateTwin(t, ctx, s, located("almaty", "m", map[string]string{"site": "a"}, 43.2389, 76.8897))
+	mustCreateTwin(t, ctx, s, located("astana", "m", map[string]string{"site": "b"}, 51.1694, 71.4491))
+	mustCreateTwin(t, ctx, s, located("almaty-2", "other", map[string]string{"site": "a"}, 43.25, 76.9))
+	mustCreateTwin(t, ctx, s, located("fiji", "m", nil, -17.7, 179.9))
+	mustCreateTwin(t, ctx, s, located("samoa", "m", nil, -13.8, -172.1))
+	mustCreateTwin(t, ctx, s, newTwin("nowhere", "m", nil))
+
+	got, err := s.FindTwinByID(ctx, "almaty")
+	mustNoError(t, err, "FindTwinByID")
+	if got.Location == nil || *got.Location != (model.GeoPoint{Lat: 43.2389, Lng: 76.8897}) {
+		t.Fatalf("FindTwinByID: got location %+v", got.Location)
+	}
+	if got, err := s.FindTwinByID(ctx, "nowhere"); err != nil || got.Location != nil {
+		t.Fatalf("FindTwinByID without location: got %+v, %v", got, err)
+	}
+
+	almaty := geo.Box{MinLat: 43, MaxLat: 44, MinLng: 76, MaxLng: 77}
+	antimeridian := geo.Box{MinLat: -20, MaxLat: -10, MinLng: 179, MaxLng: -171}
+	for _, tc := range []struct {
+		name    string
+		box     geo.Box
+		tags    map[string]string
+		modelID string
+		want    []string
+	}{
+		{"box", almaty, nil, "", []string{"almaty", "almaty-2"}},
+		{"model", almaty, nil, "m", []string{"almaty"}},
+		{"tags", almaty, map[string]string{"site": "a"}, "other", []string{"almaty-2"}},
+		{"tags mismatch", almaty, map[string]string{"site": "b"}, "", []string{}},
+		{"antimeridian", antimeridian, nil, "", []string{"fiji", "samoa"}},
+		{"world", geo.Box{MinLat: -90, MaxLat: 90, MinLng: -180, MaxLng: 180}, nil, "", []string{"almaty", "almaty-2", "astana", "fiji", "samoa"}},
+	} {
+		twins, err := s.ListTwinsInBox(ctx, tc.box, tc.tags, tc.modelID)
+		mustNoError(t, err, "ListTwinsInBox "+tc.name)
+		wantIDs(t, "ListTwinsInBox "+tc.name, twinIDs(twins), tc.want...)
+	}
+
+	// UpdateTwin moves and clears locations
+	got.Location = &model.GeoPoint{Lat: 51.17, Lng: 71.45}
+	mustNoError(t, s.UpdateTwin(ctx, got), "UpdateTwin move")
+	twins, err := s.ListTwinsInBox(ctx, almaty, nil, "")
+	mustNoError(t, err, "ListTwinsInBox after move")
+	wantIDs(t, "ListTwinsInBox after move", twinIDs(twins), "almaty-2")
+	got.Location = nil
+	mustNoError(t, s.UpdateTwin(ctx, got), "UpdateTwin clear")
+	if cleared, err := s.FindTwinByID(ctx, "almaty"); err != nil || cleared.Location != nil {
+		t.Fatalf("UpdateTwin clear: got %+v, %v", cleared, err)
+	}
+}
+
+func testTwinBulkDelete(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "m")
+	mustCreateTwin(t, ctx, s, newTwin("a", "m", map[string]string{"site": "old", "floor": "1"}))
+	mustCreateTwin(t, ctx, s, newTwin("b", "m", map[string]string{"site": "old", "floor": "2"}))
+	mustCreateTwin(t, ctx, s, newTwin("c", "m", map[string]string{"site": "new"}))
+	for _, id := range []string{"a", "c"} {
+		mustNoError(t, s.WriteTelemetry(ctx, id, numericRecord("temperature", 0, 1, persistence.QualityGood)), "WriteTelemetry")
+	}
+	end := telemetryBase.Add(time.Hour)
+
+	_, err := s.DeleteTwinsByTags(ctx, map[string]string{})
+	wantError(t, err, persistence.ErrValidation, "DeleteTwinsByTags empty selector")
+
+	deleted, err := s.DeleteTwinsByTags(ctx, map[string]string{"site": "old", "floor": "2"})
+	mustNoError(t, err, "DeleteTwinsByTags")
+	wantIDs(t, "deleted by site and floor", deleted, "b")
+
+	deleted, err = s.DeleteTwinsByTags(ctx, map[string]string{"site": "old"})
+	mustNoError(t, err, "DeleteTwinsByTags")
+	wantIDs(t, "deleted by site", deleted, "a")
+	_, err = s.FindTwinByID(ctx, "a")
+	wantError(t, err, persistence.ErrNotFound, "FindTwinByID after bulk delete")
+	history, err := s.QueryTelemetryHistory(ctx, "a", "temperature", telemetryBase, end, false, 0, nil)
+	mustNoError(t, err, "QueryTelemetryHistory")
+	if len(history) != 0 {
+		t.Fatalf("bulk delete: got %d telemetry points of a deleted twin, want 0", len(history))
+	}
+
+	// Twins outside the selector and their telemetry are untouched
+	history, err = s.QueryTelemetryHistory(ctx, "c", "temperature", telemetryBase, end, false, 0, nil)
+	mustNoError(t, err, "QueryTelemetryHistory")
+	if len(history) != 1 {
+		t.Fatalf("bulk delete: got %d telemetry points of a kept twin, want 1", len(history))
+	}
+
+	deleted, err = s.DeleteTwinsByTags(ctx, map[string]string{"site": "old"})
+	mustNoError(t, err, "DeleteTwinsByTags without matches")
+	if deleted == nil || len(deleted) != 0 {
+		t.Fatalf("DeleteTwinsByTags without matches: got %v, want an empty, non-nil result", deleted)
+	}
+}
+
+func testTwinFieldUpdates(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "m")
+	twin := newTwin("t", "m", map[string]string{"site": "north"})
+	mustCreateTwin(t, ctx, s, twin)
+
+	mustNoError(t, s.UpdateReportedProperties(ctx, "t", map[string]interface{}{"temperature": 20.0}), "UpdateReportedProperties")
+	mustNoError(t, s.UpdateDesiredProperties(ctx, "t", map[string]interface{}{"setpoint": 21.0}), "UpdateDesiredProperties")
+	mustNoError(t, s.UpdateTags(ctx, "t", map[string]string{"site": "south"}), "UpdateTags")
+
+	got, err := s.FindTwinByID(ctx, "t")
+	mustNoError(t, err, "FindTwinByID")
+	if got.ReportedProperties["temperature"] != 20.0 || got.DesiredProperties["setpoint"] != 21.0 || got.Tags["site"] != "south" {
+		t.Fatalf("field updates: got %+v", got)
+	}
+	if got.UpdatedAt.Before(twin.UpdatedAt) {
+		t.Fatalf("field updates: updatedAt went backwards (%s < %s)", got.UpdatedAt, twin.UpdatedAt)
+	}
+
+	// Replacing with nil clears the field
+	mustNoError(t, s.UpdateTags(ctx, "t", nil), "UpdateTags nil")
+	got, err = s.FindTwinByID(ctx, "t")
+	mustNoError(t, err, "FindTwinByID after clearing tags")
+	if len(got.Tags) != 0 {
+		t.Fatalf("UpdateTags nil: got tags %v, want none", got.Tags)
+	}
+
+	wantError(t, s.UpdateReportedProperties(ctx, "missing", nil), persistence.ErrNotFound, "UpdateReportedProperties missing")
+	wantError(t, s.UpdateDesiredProperties(ctx, "missing", nil), persistence.ErrNotFound, "UpdateDesiredProperties missing")
+	wantError(t, s.UpdateTags(ctx, "missing", nil), persistence.ErrNotFound, "UpdateTags missing")
+}
+
+func testReportedPropertiesBatch(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "m")
+	reporting := newTwin("reporting", "m", nil)
+	reporting.ReportedProperties = map[string]interface{}{"temperature": 20.0, "firmware": "1.0"}
+	mustCreateTwin(t, ctx, s, reporting)
+	unset := newTwin("unset", "m", nil)
+	unset.ReportedProperties = nil
+	mustCreateTwin(t, ctx, s, unset)
+
+	updated, err := s.MergeReportedProperties(ctx, map[string]map[string]interface{}{
+		"reporting": {"temperature": 21.5, "door": nil},
+		"unset":     {"temperature": 18.0},
+		"missing":   {"temperature": 0.0},
+	})
+	mustNoError(t, err, "MergeReportedProperties")
+	if len(updated) != 2 {
+		t.Fatalf("MergeReportedProperties: updated %v, want reporting and unset only", updated)
+	}
+
+	got, err := s.FindTwinByID(ctx, "reporting")
+	mustNoError(t, err, "FindTwinByID reporting")
+	door, hasDoor := got.ReportedProperties["door"]
+	if len(got.ReportedProperties) != 3 || got.ReportedProperties["temperature"] != 21.5 || got.ReportedProperties["firmware"] != "1.0" || !hasDoor || door != nil {
+		t.Fatalf("merged reported properties: got %v, want temperature replaced, firmware kept and door null", got.ReportedProperties)
+	}
+	if !got.UpdatedAt.Equal(updated["reporting"]) {
+		t.Fatalf("merged twin updatedAt: got %s, batch reported %s", got.UpdatedAt, updated["reporting"])
+	}
+	got, err = s.FindTwinByID(ctx, "unset")
+	mustNoError(t, err, "FindTwinByID unset")
+	if len(got.ReportedProperties) != 1 || got.ReportedProperties["temperature"] != 18.0 {
+		t.Fatalf("merged into unset reported properties: got %v", got.ReportedProperties)
+	}
+	if _, err := s.FindTwinByID(ctx, "missing"); !errors.Is(err, persistence.ErrNotFound) {
+		t.Fatalf("MergeReportedProperties created a missing twin (err %v)", err)
+	}
+
+	empty, err := s.MergeReportedProperties(ctx, nil)
+	mustNoError(t, err, "MergeReportedProperties empty")
+	if empty == nil || len(empty) != 0 {
+		t.Fatalf("MergeReportedProperties empty: got %v, want an empty, non-nil result", empty)
+	}
+}
+
+func testTwinOptimisticConcurrency(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "m")
+	twin := newTwin("t", "m", nil)
+	twin.UpdatedAt = twin.UpdatedAt.Add(-time.Second) // So the next update produces a different updatedAt
+	mustCreateTwin(t, ctx, s, twin)
+
+	got, err := s.FindTwinByID(ctx, "t")
+	mustNoError(t, err, "FindTwinByID")
+	stale := got.UpdatedAt
+	mustNoError(t, s.UpdateDesiredPropertiesIfUnmodified(ctx, "t", map[string]interface{}{"setpoint": 1.0}, stale), "UpdateDesiredPropertiesIfUnmodified current")
+	wantError(t, s.UpdateDesiredPropertiesIfUnmodified(ctx, "t", map[string]interface{}{"setpoint": 2.0}, stale), persistence.ErrPreconditionFailed, "UpdateDesiredPropertiesIfUnmodified stale")
+	wantError(t, s.UpdateTagsIfUnmodified(ctx, "t", map[string]string{"a": "b"}, stale), persistence.ErrPreconditionFailed, "UpdateTagsIfUnmodified stale")
+	wantError(t, s.UpdateMetadataIfUnmodified(ctx, "t", map[string]interface{}{"serial": 1.0}, stale), persistence.ErrPreconditionFailed, "UpdateMetadataIfUnmodified stale")
+
+	got, err = s.FindTwinByID(ctx, "t")
+	mustNoError(t, err, "FindTwinByID after update")
+	if got.DesiredProperties["setpoint"] != 1.0 || len(got.Tags) != 0 || len(got.Metadata) != 0 {
+		t.Fatalf("stale updates were applied: got %+v", got)
+	}
+	mustNoError(t, s.UpdateTagsIfUnmodified(ctx, "t", map[string]string{"a": "b"}, got.UpdatedAt), "UpdateTagsIfUnmodified current")
+	got, err = s.FindTwinByID(ctx, "t")
+	mustNoError(t, err, "FindTwinByID after tags update")
+	metadata := map[string]interface{}{"calibration": map[string]interface{}{"offset": -0.5}, "notes": "roof"}
+	mustNoError(t, s.UpdateMetadataIfUnmodified(ctx, "t", metadata, got.UpdatedAt), "UpdateMetadataIfUnmodified current")
+	got, err = s.FindTwinByID(ctx, "t")
+	mustNoError(t, err, "FindTwinByID after metadata update")
+	if fmt.Sprint(got.Metadata) != "map[calibration:map[offset:-0.5] notes:roof]" || got.Tags["a"] != "b" {
+		t.Fatalf("UpdateMetadataIfUnmodified: got %+v", got)
+	}
+	mustNoError(t, s.UpdateMetadata(ctx, "t", nil), "UpdateMetadata clear")
+	got, err = s.FindTwinByID(ctx, "t")
+	mustNoError(t, err, "FindTwinByID after metadata clear")
+	if len(got.Metadata) != 0 {
+		t.Fatalf("UpdateMetadata clear: got metadata %v", got.Metadata)
+	}
+
+	wantError(t, s.UpdateTagsIfUnmodified(ctx, "missing", nil, stale), persistence.ErrNotFound, "UpdateTagsIfUnmodified missing")
+	wantError(t, s.UpdateMetadataIfUnmodified(ctx, "missing", nil, stale), persistence.ErrNotFound, "UpdateMetadataIfUnmodified missing")
+	wantError(t, s.UpdateMetadata(ctx, "missing", nil), persistence.ErrNotFound, "UpdateMetadata missing")
+	wantError(t, s.UpdateDesiredPropertiesIfUnmodified(ctx, "missing", nil, stale), persistence.ErrNotFound, "UpdateDesiredPropertiesIfUnmodified missing")
+
+	// Whole-twin conditional updates (model migrations)
+	mustCreateModel(t, ctx, s, "m2")
+	twin = newTwin("t2", "m", nil)
+	twin.UpdatedAt = twin.UpdatedAt.Add(-time.Second)
+	mustCreateTwin(t, ctx, s, twin)
+	got, err = s.FindTwinByID(ctx, "t2")
+	mustNoError(t, err, "FindTwinByID t2")
+	stale = got.UpdatedAt
+	got.ModelID = "m2"
+	got.ReportedProperties = map[string]interface{}{"temperature": 21.5}
+	mustNoError(t, s.UpdateTwinIfUnmodified(ctx, got, stale), "UpdateTwinIfUnmodified current")
+	got.ModelID = "m"
+	wantError(t, s.UpdateTwinIfUnmodified(ctx, got, stale), persistence.ErrPreconditionFailed, "UpdateTwinIfUnmodified stale")
+	got, err = s.FindTwinByID(ctx, "t2")
+	mustNoError(t, err, "FindTwinByID t2 after update")
+	if got.ModelID != "m2" || got.ReportedProperties["temperature"] != 21.5 {
+		t.Fatalf("UpdateTwinIfUnmodified: got %+v, want model m2 with the reported temperature", got)
+	}
+	got.ModelID = "missing"
+	wantError(t, s.UpdateTwinIfUnmodified(ctx, got, got.UpdatedAt), persistence.ErrInvalidReference, "UpdateTwinIfUnmodified with unknown model")
+	wantError(t, s.UpdateTwinIfUnmodified(ctx, newTwin("missing", "m", nil), stale), persistence.ErrNotFound, "UpdateTwinIfUnmodified missing")
+}
+
+// --- TemplateStore ---
+
+func testTemplates(t *testing.T, ctx context.Context, s persistence.Store) {
+	ts := now()
+	tmpl := &model.TwinTemplate{
+		ID:                "tpl",
+		DisplayName:       "Pump",
+		Description:       "Standard pump",
+		ModelID:           "not-checked", // Templates don't require the model to exist
+		DesiredProperties: map[string]interface{}{"rpm": 1500.0},
+		Tags:              map[string]string{"line": "a"},
+		CreatedAt:         ts,
+		UpdatedAt:         ts,
+	}
+	mustNoError(t, s.CreateTemplate(ctx, tmpl), "CreateTemplate")
+	wantError(t, s.CreateTemplate(ctx, tmpl), persistence.ErrConflict, "CreateTemplate duplicate")
+
+	got, err := s.FindTemplateByID(ctx, "tpl")
+	mustNoError(t, err, "FindTemplateByID")
+	if got.DisplayName != "Pump" || got.Description != "Standard pump" || got.ModelID != "not-checked" ||
+		got.DesiredProperties["rpm"] != 1500.0 || got.Tags["line"] != "a" || !got.CreatedAt.Equal(ts) {
+		t.Fatalf("FindTemplateByID: got %+v", got)
+	}
+	_, err = s.FindTemplateByID(ctx, "missing")
+	wantError(t, err, persistence.ErrNotFound, "FindTemplateByID missing")
+
+	got.DisplayName = "Big pump"
+	got.Tags = nil
+	mustNoError(t, s.UpdateTemplate(ctx, got), "UpdateTemplate")
+	updated, err := s.FindTemplateByID(ctx, "tpl")
+	mustNoError(t, err, "FindTemplateByID after update")
+	if updated.DisplayName != "Big pump" || len(updated.Tags) != 0 || !updated.CreatedAt.Equal(ts) {
+		t.Fatalf("UpdateTemplate: got %+v", updated)
+	}
+	wantError(t, s.UpdateTemplate(ctx, &model.TwinTemplate{ID: "missing", DisplayName: "x", ModelID: "m"}), persistence.ErrNotFound, "UpdateTemplate missing")
+
+	templates, err := s.ListAllTemplates(ctx)
+	mustNoError(t, err, "ListAllTemplates")
+	if len(templates) != 1 || templates[0].ID != "tpl" {
+		t.Fatalf("ListAllTemplates: got %d templates, want tpl", len(templates))
+	}
+
+	mustNoError(t, s.DeleteTemplate(ctx, "tpl"), "DeleteTemplate")
+	wantError(t, s.DeleteTemplate(ctx, "tpl"), persistence.ErrNotFound, "DeleteTemplate missing")
+}
+
+// --- TokenStore ---
+
+func testRevokedTokens(t *testing.T, ctx context.Context, s persistence.Store) {
+	ts := now()
+	mustNoError(t, s.RevokeToken(ctx, &persistence.RevokedToken{ID: "b", RevokedAt: ts, Until: ts.Add(time.Hour)}), "RevokeToken b")
+	mustNoError(t, s.RevokeToken(ctx, &persistence.RevokedToken{ID: "a", RevokedAt: ts, Until: ts.Add(2 * time.Hour)}), "RevokeToken a")
+	// Again: RevokedAt stays, Until only grows
+	mustNoError(t, s.RevokeToken(ctx, &persistence.RevokedToken{ID: "a", RevokedAt: ts.Add(time.Minute), Until: ts.Add(time.Hour)}), "RevokeToken a again")
+
+	list, err := s.ListRevokedTokens(ctx)
+	mustNoError(t, err, "ListRevokedTokens")
+	if len(list) != 2 || list[0].ID != "a" || list[1].ID != "b" {
+		t.Fatalf("ListRevokedTokens: got %+v, want a and b", list)
+	}
+	if !list[0].RevokedAt.Equal(ts) || !list[0].Until.Equal(ts.Add(2*time.Hour)) {
+		t.Fatalf("ListRevokedTokens: got a = %+v, want the first revocation with the later until", list[0])
+	}
+
+	n, err := s.PruneRevokedTokens(ctx, ts.Add(time.Hour))
+	mustNoError(t, err, "PruneRevokedTokens")
+	if n != 1 {
+		t.Fatalf("PruneRevokedTokens: pruned %d, want 1", n)
+	}
+	list, err = s.ListRevokedTokens(ctx)
+	mustNoError(t, err, "ListRevokedTokens after prune")
+	if len(list) != 1 || list[0].ID != "a" {
+		t.Fatalf("ListRevokedTokens after prune: got %+v, want a", list)
+	}
+}
+
+// --- OutboxStore ---
+
+func testOutbox(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "m")
+	mustCreateTwin(t, ctx, s, newTwin("t1", "m", nil))
+	if err := s.CreateTwin(ctx, newTwin("t1", "m", nil)); !errors.Is(err, persistence.ErrConflict) {
+		t.Fatalf("CreateTwin duplicate: got %v, want ErrConflict", err) // Failed changes write no event
+	}
+	mustNoError(t, s.UpdateTags(ctx, "t1", map[string]string{"site": "a"}), "UpdateTags")
+	mustNoError(t, s.UpdateReportedProperties(ctx, "t1", map[string]interface{}{"temp": 21.5}), "UpdateReportedProperties")
+	mustNoError(t, s.DeleteTwin(ctx, "t1"), "DeleteTwin")
+
+	describe := func(events []*persistence.OutboxEvent) string {
+		parts := []string{}
+		for _, e := range events {
+			parts = append(parts, fmt.Sprintf("%s:%s:%s:%d", e.Type, e.SubjectID, e.ModelID, e.Attempts))
+		}
+		return fmt.Sprint(parts)
+	}
+
+	// A zero lease expires at once, so the same events are claimed again
+	events, err := s.ClaimOutboxEvents(ctx, 10, 0)
+	mustNoError(t, err, "ClaimOutboxEvents")
+	if want := "[model.created:m:m:1 twin.created:t1:m:1 twin.updated:t1:m:1 twin.reported:t1:m:1 twin.deleted:t1:m:1]"; describe(events) != want {
+		t.Fatalf("ClaimOutboxEvents: got %s, want %s", describe(events), want)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].ID <= events[i-1].ID || events[i].OccurredAt.IsZero() {
+			t.Fatalf("ClaimOutboxEvents: got %+v after %+v, want increasing IDs with a time", events[i], events[i-1])
+		}
+	}
+	again, err := s.ClaimOutboxEvents(ctx, 2, time.Hour)
+	mustNoError(t, err, "ClaimOutboxEvents again")
+	if want := "[model.created:m:m:2 twin.created:t1:m:2]"; describe(again) != want {
+		t.Fatalf("ClaimOutboxEvents again: got %s, want %s", describe(again), want)
+	}
+	mustNoError(t, s.MarkOutboxEventsSent(ctx, []int64{again[0].ID, again[1].ID}, now()), "MarkOutboxEventsSent")
+
+	// The first two are sent, the others still free
+	rest, err := s.ClaimOutboxEvents(ctx, 10, time.Hour)
+	mustNoError(t, err, "ClaimOutboxEvents rest")
+	if want := "[twin.updated:t1:m:2 twin.reported:t1:m:2 twin.deleted:t1:m:2]"; describe(rest) != want {
+		t.Fatalf("ClaimOutboxEvents rest: got %s, want %s", describe(rest), want)
+	}
+	if leased, err := s.ClaimOutboxEvents(ctx, 10, time.Hour); err != nil || len(leased) != 0 {
+		t.Fatalf("ClaimOutboxEvents while leased: got %s, %v, want none", describe(leased), err)
+	}
+
+	n, err := s.PruneOutboxEvents(ctx, now().Add(time.Minute), false)
+	mustNoError(t, err, "PruneOutboxEvents")
+	if n != 2 {
+		t.Fatalf("PruneOutboxEvents: pruned %d, want the 2 sent events", n)
+	}
+	n, err = s.PruneOutboxEvents(ctx, now().Add(time.Minute), true)
+	mustNoError(t, err, "PruneOutboxEvents pending")
+	if n != 3 {
+		t.Fatalf("PruneOutboxEvents pending: pruned %d, want 3", n)
+	}
+}
+
+func testWebhooks(t *testing.T, ctx context.Context, s persistence.Store) {
+	ts := now()
+	hook := &persistence.Webhook{ID: "h1", URL: "http://example.test/hook", EventTypes: []string{persistence.EventTwinCreated}, ModelID: "m", Secret: "s3cret", CreatedAt: ts, UpdatedAt: ts}
+	mustNoError(t, s.CreateWebhook(ctx, hook), "CreateWebhook")
+	wantError(t, s.CreateWebhook(ctx, hook), persistence.ErrConflict, "CreateWebhook duplicate")
+	mustNoError(t, s.CreateWebhook(ctx, &persistence.Webhook{ID: "h0", URL: "http://example.test/all", CreatedAt: ts, UpdatedAt: ts}), "CreateWebhook h0")
+
+	got, err := s.FindWebhookByID(ctx, "h1")
+	mustNoError(t, err, "FindWebhookByID")
+	if got.URL != hook.URL || fmt.Sprint(got.EventTypes) != "[twin.created]" || got.ModelID != "m" || got.Secret != "s3cret" || !got.CreatedAt.Equal(ts) {
+		t.Fatalf("FindWebhookByID: got %+v", got)
+	}
+	_, err = s.FindWebhookByID(ctx, "missing")
+	wantError(t, err, persistence.ErrNotFound, "FindWebhookByID missing")
+
+	got.EventTypes = nil
+	got.TwinID = "t1"
+	got.Secret = ""
+	mustNoError(t, s.UpdateWebhook(ctx, got), "UpdateWebhook")
+	wantError(t, s.UpdateWebhook(ctx, &persistence.Webhook{ID: "missing"}), persistence.ErrNotFound, "UpdateWebhook missing")
+	list, err := s.ListWebhooks(ctx)
+	mustNoError(t, err, "ListWebhooks")
+	if len(list) != 2 || list[0].ID != "h0" || list[1].ID != "h1" || len(list[1].EventTypes) != 0 || list[1].TwinID != "t1" || list[1].Secret != "" {
+		t.Fatalf("ListWebhooks after update: got %+v", list)
+	}
+
+	// Redelivered events and deleted webhooks queue nothing
+	event := persistence.OutboxEvent{ID: 7, Type: persistence.EventTwinCreated, SubjectID: "t1", ModelID: "m", OccurredAt: ts, Attempts: 3}
+	deliveries := []*persistence.WebhookDelivery{{WebhookID: "h1", Event: event}, {WebhookID: "h0", Event: event}, {WebhookID: "gone", Event: event}}
+	n, err := s.EnqueueWebhookDeliveries(ctx, deliveries)
+	mustNoError(t, err, "EnqueueWebhookDeliveries")
+	if n != 2 {
+		t.Fatalf("EnqueueWebhookDeliveries: queued %d, want 2", n)
+	}
+	if n, err = s.EnqueueWebhookDeliveries(ctx, deliveries[:1]); err != nil || n != 0 {
+		t.Fatalf("EnqueueWebhookDeliveries again: queued %d, %v, want 0", n, err)
+	}
+
+	claimed, err := s.ClaimWebhookDeliveries(ctx, 1, time.Hour)
+	mustNoError(t, err, "ClaimWebhookDeliveries")
+	if len(claimed) != 1 || claimed[0].WebhookID != "h1" || claimed[0].Attempts != 1 || claimed[0].Status != persistence.DeliveryPending ||
+		claimed[0].Event.ID != 7 || claimed[0].Event.Type != persistence.EventTwinCreated || claimed[0].Event.SubjectID != "t1" || !claimed[0].Event.OccurredAt.Equal(ts) {
+		t.Fatalf("ClaimWebhookDeliveries: got %+v", claimed)
+	}
+	first := claimed[0]
+	claimed, err = s.ClaimWebhookDeliveries(ctx, 10, time.Hour)
+	mustNoError(t, err, "ClaimWebhookDeliveries rest")
+	if len(claimed) != 1 || claimed[0].WebhookID != "h0" {
+		t.Fatalf("ClaimWebhookDeliveries rest: got %+v, want only h0's (h1's is leased)", claimed)
+	}
+
+	// A failed attempt due now is claimed again; a succeeded one never
+	first.LastStatusCode, first.LastError, first.NextAttemptAt = 503, "webhook answered 503", ts
+	mustNoError(t, s.UpdateWebhookDelivery(ctx, first), "UpdateWebhookDelivery retry")
+	claimed[0].Status, claimed[0].LastStatusCode = persistence.DeliverySucceeded, 200
+	mustNoError(t, s.UpdateWebhookDelivery(ctx, claimed[0]), "UpdateWebhookDelivery succeeded")
+	wantError(t, s.UpdateWebhookDelivery(ctx, &persistence.WebhookDelivery{ID: 999999}), persistence.ErrNotFound, "UpdateWebhookDelivery missing")
+	retried, err := s.ClaimWebhookDeliveries(ctx, 10, time.Hour)
+	mustNoError(t, err, "ClaimWebhookDeliveries retry")
+	if len(retried) != 1 || retried[0].ID != first.ID || retried[0].Attempts != 2 || retried[0].LastStatusCode != 503 || retried[0].LastError == "" {
+		t.Fatalf("ClaimWebhookDeliveries retry: got %+v", retried)
+	}
+
+	history, err := s.ListWebhookDeliveries(ctx, "h1", 0, 10)
+	mustNoError(t, err, "ListWebhookDeliveries")
+	if len(history) != 1 || history[0].ID != first.ID {
+		t.Fatalf("ListWebhookDeliveries: got %+v", history)
+	}
+	if history, err = s.ListWebhookDeliveries(ctx, "h1", first.ID, 10); err != nil || len(history) != 0 {
+		t.Fatalf("ListWebhookDeliveries before the first: got %+v, %v, want none", history, err)
+	}
+
+	n64, err := s.PruneWebhookDeliveries(ctx, now().Add(time.Minute))
+	mustNoError(t, err, "PruneWebhookDeliveries")
+	if n64 != 1 {
+		t.Fatalf("PruneWebhookDeliveries: pruned %d, want the succeeded one (pending ones stay)", n64)
+	}
+
+	mustNoError(t, s.DeleteWebhook(ctx, "h1"), "DeleteWebhook")
+	wantError(t, s.DeleteWebhook(ctx, "h1"), persistence.ErrNotFound, "DeleteWebhook again")
+	if history, err = s.ListWebhookDeliveries(ctx, "h1", 0, 10); err != nil || len(history) != 0 {
+		t.Fatalf("ListWebhookDeliveries after delete: got %+v, %v, want none", history, err)
+	}
+}
+
+func testAlertRules(t *testing.T, ctx context.Context, s persistence.Store) {
+	ts := now()
+	rule := &persistence.AlertRule{ID: "r1", ModelID: "m", Metric: "temperature", Operator: ">", Value: 80, Duration: "5m", Enabled: true, CreatedAt: ts, UpdatedAt: ts}
+	mustNoError(t, s.CreateAlertRule(ctx, rule), "CreateAlertRule")
+	wantError(t, s.CreateAlertRule(ctx, rule), persistence.ErrConflict, "CreateAlertRule duplicate")
+	mustNoError(t, s.CreateAlertRule(ctx, &persistence.AlertRule{ID: "r0", TwinID: "t1", Metric: "level", Operator: "<=", Value: -1.5, CreatedAt: ts, UpdatedAt: ts}), "CreateAlertRule r0")
+
+	got, err := s.FindAlertRuleByID(ctx, "r1")
+	mustNoError(t, err, "FindAlertRuleByID")
+	if got.ModelID != "m" || got.Metric != "temperature" || got.Operator != ">" || got.Value != 80 || got.Duration != "5m" || !got.Enabled || !got.CreatedAt.Equal(ts) {
+		t.Fatalf("FindAlertRuleByID: got %+v", got)
+	}
+	_, err = s.FindAlertRuleByID(ctx, "missing")
+	wantError(t, err, persistence.ErrNotFound, "FindAlertRuleByID missing")
+
+	got.Description, got.Value, got.Enabled = "too hot", 90.5, false
+	mustNoError(t, s.UpdateAlertRule(ctx, got), "UpdateAlertRule")
+	wantError(t, s.UpdateAlertRule(ctx, &persistence.AlertRule{ID: "missing", Metric: "x", Operator: ">"}), persistence.ErrNotFound, "UpdateAlertRule missing")
+	list, err := s.ListAlertRules(ctx)
+	mustNoError(t, err, "ListAlertRules")
+	if len(list) != 2 || list[0].ID != "r0" || list[0].Value != -1.5 || list[1].ID != "r1" || list[1].Description != "too hot" || list[1].Value != 90.5 || list[1].Enabled ||
+		!list[1].CreatedAt.Equal(ts) {
+		t.Fatalf("ListAlertRules after update: got %+v", list)
+	}
+
+	// A state without an event writes nothing to the outbox; one with an event writes it. A
+	// state saved over a stale prev (another evaluator was first) writes neither
+	save := func(prev, st *persistence.AlertState, event *persistence.OutboxEvent, want bool, what string) {
+		t.Helper()
+		saved, err := s.SaveAlertState(ctx, prev, st, event)
+		mustNoError(t, err, what)
+		if saved != want {
+			t.Fatalf("%s: saved %t, want %t", what, saved, want)
+		}
+	}
+	pending := &persistence.AlertState{RuleID: "r1", TwinID: "t2", State: persistence.AlertPending, Since: ts, Value: 91}
+	save(nil, pending, nil, true, "SaveAlertState pending")
+	save(nil, pending, nil, false, "SaveAlertState pending again")
+	firing := &persistence.AlertState{RuleID: "r1", TwinID: "t2", State: persistence.AlertFiring, Since: ts.Add(time.Minute), Value: 95}
+	event := &persistence.OutboxEvent{Type: persistence.EventAlertTriggered, SubjectID: "t2", ModelID: "m", RuleID: "r1"}
+	save(pending, firing, event, true, "SaveAlertState firing")
+	save(pending, firing, event, false, "SaveAlertState firing again")
+	save(nil, &persistence.AlertState{RuleID: "r1", TwinID: "t1", State: persistence.AlertPending, Since: ts, Value: 85}, nil, true, "SaveAlertState t1")
+	_, err = s.SaveAlertState(ctx, nil, &persistence.AlertState{RuleID: "missing", TwinID: "t1", State: persistence.AlertFiring, Since: ts}, event)
+	wantError(t, err, persistence.ErrNotFound, "SaveAlertState missing rule")
+
+	states, err := s.ListAlertStates(ctx, "r1")
+	mustNoError(t, err, "ListAlertStates")
+	if len(states) != 2 || states[0].TwinID != "t1" || states[1].TwinID != "t2" || states[1].State != persistence.AlertFiring ||
+		!states[1].Since.Equal(ts.Add(time.Minute)) || states[1].Value != 95 {
+		t.Fatalf("ListAlertStates: got %+v", states)
+	}
+	events, err := s.ClaimOutboxEvents(ctx, 10, time.Hour)
+	mustNoError(t, err, "ClaimOutboxEvents")
+	if len(events) != 1 || events[0].Type != persistence.EventAlertTriggered || events[0].SubjectID != "t2" || events[0].ModelID != "m" || events[0].RuleID != "r1" {
+		t.Fatalf("ClaimOutboxEvents: got %+v, want the alert.triggered of the firing state only", events)
+	}
+
+	// Alert events keep their rule through webhook deliveries
+	mustNoError(t, s.CreateWebhook(ctx, &persistence.Webhook{ID: "h", URL: "http://example.test/hook", CreatedAt: ts, UpdatedAt: ts}), "CreateWebhook")
+	if _, err := s.EnqueueWebhookDeliveries(ctx, []*persistence.WebhookDelivery{{WebhookID: "h", Event: *events[0]}}); err != nil {
+		t.Fatalf("EnqueueWebhookDeliveries: %v", err)
+	}
+	deliveries, err := s.ClaimWebhookDeliveries(ctx, 10, time.Hour)
+	mustNoError(t, err, "ClaimWebhookDeliveries")
+	if len(deliveries) != 1 || deliveries[0].Event.RuleID != "r1" {
+		t.Fatalf("ClaimWebhookDeliveries: got %+v, want the alert event with its rule", deliveries)
+	}
+
+	// states[0] is t1's pending state; t2 has moved on from pending
+	for _, c := range []struct {
+		prev *persistence.AlertState
+		want bool
+		what string
+	}{{pending, false, "DeleteAlertState stale"}, {states[0], true, "DeleteAlertState"}, {states[0], false, "DeleteAlertState again"}} {
+		deleted, err := s.DeleteAlertState(ctx, c.prev)
+		mustNoError(t, err, c.what)
+		if deleted != c.want {
+			t.Fatalf("%s: deleted %t, want %t", c.what, deleted, c.want)
+		}
+	}
+	if states, err = s.ListAlertStates(ctx, "r1"); err != nil || len(states) != 1 || states[0].TwinID != "t2" {
+		t.Fatalf("ListAlertStates after DeleteAlertState: got %+v, %v", states, err)
+	}
+
+	mustNoError(t, s.DeleteAlertRule(ctx, "r1"), "DeleteAlertRule")
+	wantError(t, s.DeleteAlertRule(ctx, "r1"), persistence.ErrNotFound, "DeleteAlertRule again")
+	if states, err = s.ListAlertStates(ctx, "r1"); err != nil || len(states) != 0 {
+		t.Fatalf("ListAlertStates after DeleteAlertRule: got %+v, %v, want none", states, err)
+	}
+}
+
+// --- TimeSeriesStore ---
+
+func testTelemetryWrite(t *testing.T, ctx context.Context, s persistence.Store) {
+	str, flag := "on", true
+	records := []*persistence.TelemetryRecord{
+		numericRecord("temperature", 0, 21.5, persistence.QualityGood),
+		{Name: "state", Timestamp: telemetryBase, StringValue: &str, Quality: persistence.QualityUncertain},
+		{Name: "alarm", Timestamp: telemetryBase, BooleanValue: &flag, Quality: persistence.QualityBad, WrittenBy: "gateway"},
+	}
+	for _, rec := range records {
+		mustNoError(t, s.WriteTelemetry(ctx, "t", rec), "WriteTelemetry "+rec.Name)
+	}
+	wantError(t, s.WriteTelemetry(ctx, "t", numericRecord("temperature", 0, 99, persistence.QualityGood)), persistence.ErrConflict, "WriteTelemetry duplicate (twin, name, ts)")
+
+	latest, err := s.QueryLatestTelemetry(ctx, "t", nil)
+	mustNoError(t, err, "QueryLatestTelemetry")
+	if len(latest) != 3 {
+		t.Fatalf("QueryLatestTelemetry: got %d names, want 3", len(latest))
+	}
+	if rec := latest["temperature"]; rec.NumericValue == nil || *rec.NumericValue != 21.5 || rec.StringValue != nil || rec.BooleanValue != nil || rec.Quality != persistence.QualityGood {
+		t.Fatalf("numeric record: got %+v", rec)
+	}
+	if rec := latest["state"]; rec.StringValue == nil || *rec.StringValue != "on" || rec.NumericValue != nil || rec.Quality != persistence.QualityUncertain {
+		t.Fatalf("string record: got %+v", rec)
+	}
+	if rec := latest["alarm"]; rec.BooleanValue == nil || !*rec.BooleanValue || rec.NumericValue != nil || rec.Quality != persistence.QualityBad || rec.WrittenBy != "gateway" {
+		t.Fatalf("boolean record: got %+v", rec)
+	}
+	if latest["temperature"].WrittenBy != "" {
+		t.Fatalf("anonymous record: got writtenBy %q, want none", latest["temperature"].WrittenBy)
+	}
+	if !latest["temperature"].Timestamp.Equal(telemetryBase) {
+		t.Fatalf("numeric record: got ts %s, want %s", latest["temperature"].Timestamp, telemetryBase)
+	}
+
+	// Telemetry is independent of twin rows: deleting a twin keeps its history
+	mustCreateModel(t, ctx, s, "m")
+	mustCreateTwin(t, ctx, s, newTwin("t", "m", nil))
+	mustNoError(t, s.DeleteTwin(ctx, "t"), "DeleteTwin")
+	names, err := s.ListTelemetryNames(ctx, "t")
+	mustNoError(t, err, "ListTelemetryNames after DeleteTwin")
+	wantIDs(t, "ListTelemetryNames after DeleteTwin", names, "alarm", "state", "temperature")
+}
+
+func testTelemetryBackfill(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustNoError(t, s.WriteTelemetry(ctx, "t", numericRecord("temperature", time.Minute, 1, persistence.QualityGood)), "WriteTelemetry")
+
+	batch := []*persistence.TelemetryRecord{
+		numericRecord("temperature", 0, 0, persistence.QualityGood),
+		numericRecord("temperature", time.Minute, 99, persistence.QualityGood), // Already stored: skipped
+		numericRecord("temperature", 2*time.Minute, 2, persistence.QualityGood),
+	}
+	batch[2].WrittenBy = "importer"
+	inserted, err := s.BackfillTelemetry(ctx, "t", batch)
+	mustNoError(t, err, "BackfillTelemetry")
+	if inserted != 2 {
+		t.Fatalf("BackfillTelemetry: inserted %d, want 2", inserted)
+	}
+
+	// Retrying the same batch is a no-op
+	inserted, err = s.BackfillTelemetry(ctx, "t", batch)
+	mustNoError(t, err, "BackfillTelemetry retry")
+	if inserted != 0 {
+		t.Fatalf("BackfillTelemetry retry: inserted %d, want 0", inserted)
+	}
+
+	inserted, err = s.BackfillTelemetry(ctx, "t", nil)
+	mustNoError(t, err, "BackfillTelemetry empty")
+	if inserted != 0 {
+		t.Fatalf("BackfillTelemetry empty: inserted %d, want 0", inserted)
+	}
+
+	history, err := s.QueryTelemetryHistory(ctx, "t", "temperature", telemetryBase, telemetryBase.Add(time.Hour), false, 0, nil)
+	mustNoError(t, err, "QueryTelemetryHistory")
+	wantTimes(t, "history after backfill", history, 0, time.Minute, 2*time.Minute)
+	wantValue(t, "existing record kept", history[1].NumericValue, 1)
+	if history[0].WrittenBy != "" || history[2].WrittenBy != "importer" {
+		t.Fatalf("history after backfill: got writtenBy %q and %q, want none and importer", history[0].WrittenBy, history[2].WrittenBy)
+	}
+}
+
+func testDownsampledTelemetry(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustNoError(t, s.WriteTelemetry(ctx, "t", numericRecord("temperature", 2*time.Minute, 1, persistence.QualityGood)), "WriteTelemetry")
+
+	stored, err := s.MergeDownsampledTelemetry(ctx, "t", []*persistence.DownsampledBucket{
+		{Name: "temperature", Start: telemetryBase, Count: 2, Sum: 10, Min: 4, Max: 6, Quality: persistence.QualityGood, WrittenBy: "a"},
+		{Name: "humidity", Start: telemetryBase, Aggregation: persistence.AggregateMax, Count: 1, Sum: 50, Min: 50, Max: 50},
+		{Name: "temperature", Start: telemetryBase.Add(2 * time.Minute), Count: 1, Sum: 99, Min: 99, Max: 99}, // Stored otherwise: skipped
+	})
+	mustNoError(t, err, "MergeDownsampledTelemetry")
+	if len(stored) != 2 {
+		t.Fatalf("MergeDownsampledTelemetry: stored %d points, want 2", len(stored))
+	}
+
+	// A later part of the bucket is merged into its point
+	stored, err = s.MergeDownsampledTelemetry(ctx, "t", []*persistence.DownsampledBucket{
+		{Name: "temperature", Start: telemetryBase, Count: 1, Sum: 8, Min: 2, Max: 8, Quality: persistence.QualityUncertain, WrittenBy: "b"},
+	})
+	mustNoError(t, err, "MergeDownsampledTelemetry merge")
+	if len(stored) != 1 || stored[0].TwinID != "t" || !stored[0].Timestamp.Equal(telemetryBase) {
+		t.Fatalf("MergeDownsampledTelemetry merge: got %+v, want the temperature point of t at the bucket's start", stored)
+	}
+	wantValue(t, "merged point as stored", stored[0].NumericValue, 6)
+
+	history, err := s.QueryTelemetryHistory(ctx, "t", "temperature", telemetryBase, telemetryBase.Add(time.Hour), false, 0, nil)
+	mustNoError(t, err, "QueryTelemetryHistory")
+	wantTimes(t, "history after merges", history, 0, 2*time.Minute)
+	wantValue(t, "merged average", history[0].NumericValue, 6)
+	wantValue(t, "point stored otherwise kept", history[1].NumericValue, 1)
+	if history[0].Quality != persistence.QualityUncertain || history[0].WrittenBy != "" {
+		t.Fatalf("merged point: got quality %v and writtenBy %q, want uncertain and none", history[0].Quality, history[0].WrittenBy)
+	}
+	latest, err := s.QueryLatestTelemetry(ctx, "t", []string{"humidity"})
+	mustNoError(t, err, "QueryLatestTelemetry")
+	wantValue(t, "max bucket", latest["humidity"].NumericValue, 50)
+
+	_, err = s.MergeDownsampledTelemetry(ctx, "t", []*persistence.DownsampledBucket{
+		{Name: "temperature", Start: telemetryBase.Add(time.Minute), Aggregation: persistence.AggregateDelta, Count: 1, Sum: 1, Min: 1, Max: 1},
+	})
+	wantError(t, err, persistence.ErrValidation, "MergeDownsampledTelemetry with delta")
+	stored, err = s.MergeDownsampledTelemetry(ctx, "t", nil)
+	mustNoError(t, err, "MergeDownsampledTelemetry empty")
+	if len(stored) != 0 {
+		t.Fatalf("MergeDownsampledTelemetry empty: stored %d points, want 0", len(stored))
+	}
+}
+
+func testTelemetryCopy(t *testing.T, ctx context.Context, s persistence.Store) {
+	forTwin := func(twinID string, rec *persistence.TelemetryRecord) *persistence.TelemetryRecord {
+		rec.TwinID = twinID
+		return rec
+	}
+	on, mode := true, "auto"
+	mustNoError(t, s.WriteTelemetryCopy(ctx, []*persistence.TelemetryRecord{
+		forTwin("a", numericRecord("temperature", 0, 1, persistence.QualityGood)),
+		forTwin("a", numericRecord("temperature", time.Minute, 2, persistence.QualityUncertain)),
+		forTwin("b", numericRecord("temperature", 0, 3, persistence.QualityGood)),
+		forTwin("b", &persistence.TelemetryRecord{Name: "heating", Timestamp: telemetryBase, BooleanValue: &on}),
+		forTwin("b", &persistence.TelemetryRecord{Name: "mode", Timestamp: telemetryBase, StringValue: &mode}),
+	}), "WriteTelemetryCopy")
+
+	end := telemetryBase.Add(time.Hour)
+	history, err := s.QueryTelemetryHistory(ctx, "a", "temperature", telemetryBase, end, false, 0, nil)
+	mustNoError(t, err, "QueryTelemetryHistory a")
+	wantTimes(t, "copied history of a", history, 0, time.Minute)
+	wantValue(t, "copied value", history[1].NumericValue, 2)
+	if history[1].Quality != persistence.QualityUncertain {
+		t.Fatalf("copied quality: got %v, want uncertain", history[1].Quality)
+	}
+	latest, err := s.QueryLatestTelemetry(ctx, "b", nil)
+	mustNoError(t, err, "QueryLatestTelemetry b")
+	if len(latest) != 3 || latest["heating"].BooleanValue == nil || !*latest["heating"].BooleanValue ||
+		latest["mode"].StringValue == nil || *latest["mode"].StringValue != "auto" || latest["mode"].NumericValue != nil {
+		t.Fatalf("copied values of b: got %+v", latest)
+	}
+
+	// A duplicate, of a stored record or within the batch, fails the whole batch
+	wantError(t, s.WriteTelemetryCopy(ctx, []*persistence.TelemetryRecord{
+		forTwin("a", numericRecord("temperature", 2*time.Minute, 4, persistence.QualityGood)),
+		forTwin("a", numericRecord("temperature", time.Minute, 5, persistence.QualityGood)),
+	}), persistence.ErrConflict, "WriteTelemetryCopy duplicating a stored record")
+	wantError(t, s.WriteTelemetryCopy(ctx, []*persistence.TelemetryRecord{
+		forTwin("c", numericRecord("temperature", 0, 6, persistence.QualityGood)),
+		forTwin("c", numericRecord("temperature", 0, 7, persistence.QualityGood)),
+	}), persistence.ErrConflict, "WriteTelemetryCopy with a duplicate in the batch")
+	wantError(t, s.WriteTelemetryCopy(ctx, []*persistence.TelemetryRecord{
+		forTwin("c", numericRecord("temperature", 0, 8, persistence.QualityGood)),
+		numericRecord("temperature", 0, 9, persistence.QualityGood),
+	}), persistence.ErrValidation, "WriteTelemetryCopy without a twin ID")
+	history, err = s.QueryTelemetryHistory(ctx, "a", "temperature", telemetryBase, end, false, 0, nil)
+	mustNoError(t, err, "QueryTelemetryHistory a after conflict")
+	wantTimes(t, "history of a after a failed batch", history, 0, time.Minute)
+	history, err = s.QueryTelemetryHistory(ctx, "c", "temperature", telemetryBase, end, false, 0, nil)
+	mustNoError(t, err, "QueryTelemetryHistory c")
+	wantTimes(t, "history of c after failed batches", history)
+
+	mustNoError(t, s.WriteTelemetryCopy(ctx, nil), "WriteTelemetryCopy empty")
+}
+
+func testTelemetryBatch(t *testing.T, ctx context.Context, s persistence.Store) {
+	batch := []*persistence.TelemetryRecord{
+		numericRecord("temperature", 0, 1, persistence.QualityGood),
+		numericRecord("temperature", time.Minute, 2, persistence.QualityGood),
+	}
+	batch[1].TwinID = "other" // Overridden by the batch's twin
+	mustNoError(t, s.WriteBatchTelemetry(ctx, "t", batch), "WriteBatchTelemetry")
+	for i, rec := range batch {
+		if rec.TwinID != "t" {
+			t.Fatalf("WriteBatchTelemetry: record %d has TwinID %q, want t", i, rec.TwinID)
+		}
+	}
+
+	end := telemetryBase.Add(time.Hour)
+	history, err := s.QueryTelemetryHistory(ctx, "t", "temperature", telemetryBase, end, false, 0, nil)
+	mustNoError(t, err, "QueryTelemetryHistory")
+	wantTimes(t, "batch history", history, 0, time.Minute)
+	wantValue(t, "batch value", history[1].NumericValue, 2)
+
+	// All or nothing, like WriteTelemetryCopy
+	wantError(t, s.WriteBatchTelemetry(ctx, "t", []*persistence.TelemetryRecord{
+		numericRecord("temperature", 2*time.Minute, 3, persistence.QualityGood),
+		numericRecord("temperature", time.Minute, 4, persistence.QualityGood),
+	}), persistence.ErrConflict, "WriteBatchTelemetry duplicating a stored record")
+	history, err = s.QueryTelemetryHistory(ctx, "t", "temperature", telemetryBase, end, false, 0, nil)
+	mustNoError(t, err, "QueryTelemetryHistory after conflict")
+	wantTimes(t, "batch history after a failed batch", history, 0, time.Minute)
+
+	mustNoError(t, s.WriteBatchTelemetry(ctx, "t", nil), "WriteBatchTelemetry empty")
+}
+
+// writeSeries stores temperature points at 0s..4m (one per minute, qualities good/uncertain alternating)
+// plus a point for another name and another twin that queries must not return.
+func writeSeries(t *testing.T, ctx context.Context, s persistence.Store) {
+	t.Helper()
+	for i := 0; i < 5; i++ {
+		q := persistence.QualityGood
+		if i%2 == 1 {
+			q = persistence.QualityUncertain
+		}
+		mustNoError(t, s.WriteTelemetry(ctx, "t", numericRecord("temperature", time.Duration(i)*time.Minute, float64(i), q)), "WriteTelemetry")
+	}
+	mustNoError(t, s.WriteTelemetry(ctx, "t", numericRecord("humidity", time.Minute, 50, persistence.QualityGood)), "WriteTelemetry humidity")
+	mustNoError(t, s.WriteTelemetry(ctx, "other", numericRecord("temperature", time.Minute, 50, persistence.QualityGood)), "WriteTelemetry other twin")
+}
+
+func testTelemetryHistory(t *testing.T, ctx context.Context, s persistence.Store) {
+	writeSeries(t, ctx, s)
+	end := telemetryBase.Add(time.Hour)
+
+	asc, err := s.QueryTelemetryHistory(ctx, "t", "temperature", telemetryBase, end, false, 0, nil)
+	mustNoError(t, err, "QueryTelemetryHistory asc")
+	wantTimes(t, "ascending", asc, 0, time.Minute, 2*time.Minute, 3*time.Minute, 4*time.Minute)
+	for _, rec := range asc {
+		if rec.Name != "temperature" {
+			t.Fatalf("ascending: got record for %q", rec.Name)
+		}
+	}
+
+	desc, err := s.QueryTelemetryHistory(ctx, "t", "temperature", telemetryBase, end, true, 2, nil)
+	mustNoError(t, err, "QueryTelemetryHistory desc")
+	wantTimes(t, "descending with limit", desc, 4*time.Minute, 3*time.Minute)
+
+	// Both range ends are inclusive
+	bounded, err := s.QueryTelemetryHistory(ctx, "t", "temperature", telemetryBase.Add(time.Minute), telemetryBase.Add(3*time.Minute), false, 0, nil)
+	mustNoError(t, err, "QueryTelemetryHistory bounded")
+	wantTimes(t, "bounded", bounded, time.Minute, 2*time.Minute, 3*time.Minute)
+
+	// The limit applies after the quality filter
+	uncertain, err := s.QueryTelemetryHistory(ctx, "t", "temperature", telemetryBase, end, false, 1, []persistence.Quality{persistence.QualityUncertain})
+	mustNoError(t, err, "QueryTelemetryHistory quality filter")
+	wantTimes(t, "quality filter with limit", uncertain, time.Minute)
+
+	notBad, err := s.QueryTelemetryHistory(ctx, "t", "temperature", telemetryBase, end, false, 0, []persistence.Quality{persistence.QualityGood, persistence.QualityUncertain})
+	mustNoError(t, err, "QueryTelemetryHistory multiple qualities")
+	wantTimes(t, "multiple qualities", notBad, 0, time.Minute, 2*time.Minute, 3*time.Minute, 4*time.Minute)
+}
+
+func testTelemetryStream(t *testing.T, ctx context.Context, s persistence.Store) {
+	writeSeries(t, ctx, s)
+	end := telemetryBase.Add(time.Hour)
+
+	var streamed []*persistence.TelemetryRecord
+	err := s.StreamTelemetryHistory(ctx, "t", "temperature", telemetryBase, end, true, 0, []persistence.Quality{persistence.QualityGood}, func(rec *persistence.TelemetryRecord) error {
+		streamed = append(streamed, rec)
+		return nil
+	})
+	mustNoError(t, err, "StreamTelemetryHistory")
+	wantTimes(t, "streamed", streamed, 4*time.Minute, 2*time.Minute, 0)
+
+	// An error from fn stops the iteration and is returned as is
+	stop := errors.New("stop")
+	calls := 0
+	err = s.StreamTelemetryHistory(ctx, "t", "temperature", telemetryBase, end, false, 0, nil, func(*persistence.TelemetryRecord) error {
+		calls++
+		return stop
+	})
+	wantError(t, err, stop, "StreamTelemetryHistory callback error")
+	if calls != 1 {
+		t.Fatalf("StreamTelemetryHistory callback error: fn called %d times, want 1", calls)
+	}
+}
+
+func testTelemetryAggregate(t *testing.T, ctx context.Context, s persistence.Store) {
+	// Bucket 00:00-00:05: 10 (good), 20 (uncertain), 40 (good); bucket 00:10-00:15: 5 (good)
+	for _, rec := range []*persistence.TelemetryRecord{
+		numericRecord("temperature", 0, 10, persistence.QualityGood),
+		numericRecord("temperature", 30*time.Second, 20, persistence.QualityUncertain),
+		numericRecord("temperature", time.Minute, 40, persistence.QualityGood),
+		numericRecord("temperature", 10*time.Minute, 5, persistence.QualityGood),
+	} {
+		mustNoError(t, s.WriteTelemetry(ctx, "t", rec), "WriteTelemetry")
+	}
+	end := telemetryBase.Add(time.Hour)
+	bucket := 5 * time.Minute
+
+	for _, tc := range []struct {
+		agg   string
+		first float64
+		last  *float64 // nil: the single-point bucket has no value
+	}{
+		{persistence.AggregateAvg, 70.0 / 3, float(5)},
+		{persistence.AggregateMin, 10, float(5)},
+		{persistence.AggregateMax, 40, float(5)},
+		{persistence.AggregateSum, 70, float(5)},
+		{persistence.AggregateCount, 3, float(1)},
+		{persistence.AggregateDelta, 30, nil},
+		{persistence.AggregateRate, 0.5, nil}, // 30 over 60s
+	} {
+		buckets, err := s.QueryTelemetryAggregate(ctx, "t", "temperature", telemetryBase, end, bucket, tc.agg, "", nil)
+		mustNoError(t, err, "QueryTelemetryAggregate "+tc.agg)
+		if len(buckets) != 2 {
+			t.Fatalf("%s: got %d buckets, want 2 (empty buckets omitted)", tc.agg, len(buckets))
+		}
+		if !buckets[0].Bucket.Equal(telemetryBase) || !buckets[1].Bucket.Equal(telemetryBase.Add(10*time.Minute)) {
+			t.Fatalf("%s: got buckets starting %s and %s", tc.agg, buckets[0].Bucket, buckets[1].Bucket)
+		}
+		wantValue(t, tc.agg+" first bucket", buckets[0].Value, tc.first)
+		if tc.last == nil {
+			if buckets[1].Value != nil {
+				t.Fatalf("%s second bucket: got %v, want null", tc.agg, *buckets[1].Value)
+			}
+		} else {
+			wantValue(t, tc.agg+" second bucket", buckets[1].Value, *tc.last)
+		}
+		if q := buckets[0].Quality; q.Good != 2 || q.Uncertain != 1 || q.Bad != 0 {
+			t.Fatalf("%s: got quality counts %+v, want 2 good / 1 uncertain", tc.agg, q)
+		}
+	}
+
+	good, err := s.QueryTelemetryAggregate(ctx, "t", "temperature", telemetryBase, end, bucket, persistence.AggregateAvg, "", []persistence.Quality{persistence.QualityGood})
+	mustNoError(t, err, "QueryTelemetryAggregate quality filter")
+	if len(good) != 2 {
+		t.Fatalf("quality filter: got %d buckets, want 2", len(good))
+	}
+	wantValue(t, "quality filter avg", good[0].Value, 25)
+
+	_, err = s.QueryTelemetryAggregate(ctx, "t", "temperature", telemetryBase, end, bucket, "median", "", nil)
+	wantError(t, err, persistence.ErrValidation, "QueryTelemetryAggregate unknown aggregation")
+}
+
+func testTelemetryCountLatestNames(t *testing.T, ctx context.Context, s persistence.Store) {
+	writeSeries(t, ctx, s)
+
+	count, err := s.CountTelemetry(ctx, "t", "temperature", telemetryBase.Add(time.Minute), telemetryBase.Add(3*time.Minute), false)
+	mustNoError(t, err, "CountTelemetry")
+	if count != 3 {
+		t.Fatalf("CountTelemetry: got %d, want 3", count)
+	}
+	// Approximate counts may be estimates; only check that they are available
+	if _, err := s.CountTelemetry(ctx, "t", "temperature", telemetryBase, telemetryBase.Add(time.Hour), true); err != nil {
+		t.Fatalf("CountTelemetry approximate: unexpected error: %v", err)
+	}
+
+	latest, err := s.QueryLatestTelemetry(ctx, "t", []string{"temperature", "missing"})
+	mustNoError(t, err, "QueryLatestTelemetry")
+	if len(latest) != 1 || latest["temperature"] == nil {
+		t.Fatalf("QueryLatestTelemetry: got %d names, want only temperature (names without data are omitted)", len(latest))
+	}
+	wantTimes(t, "latest temperature", []*persistence.TelemetryRecord{latest["temperature"]}, 4*time.Minute)
+	wantValue(t, "latest temperature", latest["temperature"].NumericValue, 4)
+
+	all, err := s.QueryLatestTelemetry(ctx, "t", nil)
+	mustNoError(t, err, "QueryLatestTelemetry all names")
+	if len(all) != 2 || all["humidity"] == nil || all["temperature"] == nil {
+		t.Fatalf("QueryLatestTelemetry all names: got %d names, want humidity and temperature", len(all))
+	}
+
+	// Duplicate names are queried once and blank ones ignored; only blanks match nothing
+	for _, tc := range []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"temperature", "temperature"}, "[temperature]"},
+		{[]string{"temperature", "", "humidity", "temperature"}, "[humidity temperature]"},
+		{[]string{"  ", "humidity"}, "[humidity]"},
+		{[]string{""}, "[]"},
+		{[]string{"", " ", "\t"}, "[]"},
+		{[]string{}, "[humidity temperature]"},
+	} {
+		latest, err := s.QueryLatestTelemetry(ctx, "t", tc.names)
+		mustNoError(t, err, "QueryLatestTelemetry")
+		got := make([]string, 0, len(latest))
+		for name, rec := range latest {
+			if rec == nil || rec.Name != name {
+				t.Fatalf("QueryLatestTelemetry(%q): record under %q is %+v", tc.names, name, rec)
+			}
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if fmt.Sprint(got) != tc.want {
+			t.Fatalf("QueryLatestTelemetry(%q): got names %v, want %s", tc.names, got, tc.want)
+		}
+	}
+
+	names, err := s.ListTelemetryNames(ctx, "t")
+	mustNoError(t, err, "ListTelemetryNames")
+	wantIDs(t, "ListTelemetryNames", names, "humidity", "temperature")
+}
+
+func testTelemetryGaps(t *testing.T, ctx context.Context, s persistence.Store) {
+	// Points at 00:02, 00:03, 00:10 and 00:11; another metric fills 00:04-00:09 and must not count
+	for _, rec := range []*persistence.TelemetryRecord{
+		numericRecord("temperature", 2*time.Minute, 1, persistence.QualityGood),
+		numericRecord("temperature", 3*time.Minute, 2, persistence.QualityGood),
+		numericRecord("temperature", 10*time.Minute, 3, persistence.QualityBad),
+		numericRecord("temperature", 11*time.Minute, 4, persistence.QualityGood),
+		numericRecord("humidity", 6*time.Minute, 50, persistence.QualityGood),
+	} {
+		mustNoError(t, s.WriteTelemetry(ctx, "t", rec), "WriteTelemetry")
+	}
+	at := func(d time.Duration) time.Time { return telemetryBase.Add(d) }
+	wantGaps := func(what string, got []*persistence.TelemetryGap, want ...[2]time.Duration) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d gaps, want %d", what, len(got), len(want))
+		}
+		for i, g := range got {
+			if !g.Start.Equal(at(want[i][0])) || !g.End.Equal(at(want[i][1])) {
+				t.Fatalf("%s: gap %d is %s - %s, want %s - %s", what, i, g.Start, g.End, at(want[i][0]), at(want[i][1]))
+			}
+		}
+	}
+
+	// The range edges count as boundaries: a leading gap from start and a trailing one to end
+	gaps, err := s.QueryTelemetryGaps(ctx, "t", "temperature", telemetryBase, at(20*time.Minute), 90*time.Second)
+	mustNoError(t, err, "QueryTelemetryGaps")
+	wantGaps("with edges", gaps, [2]time.Duration{0, 2 * time.Minute}, [2]time.Duration{3 * time.Minute, 10 * time.Minute}, [2]time.Duration{11 * time.Minute, 20 * time.Minute})
+
+	// Only intervals strictly longer than the threshold are gaps
+	gaps, err = s.QueryTelemetryGaps(ctx, "t", "temperature", at(2*time.Minute), at(11*time.Minute), 7*time.Minute)
+	mustNoError(t, err, "QueryTelemetryGaps exact threshold")
+	wantGaps("exact threshold", gaps)
+
+	// No points at all: the whole range is one gap
+	gaps, err = s.QueryTelemetryGaps(ctx, "t", "pressure", telemetryBase, at(time.Hour), time.Minute)
+	mustNoError(t, err, "QueryTelemetryGaps without data")
+	wantGaps("without data", gaps, [2]time.Duration{0, time.Hour})
+
+	_, err = s.QueryTelemetryGaps(ctx, "t", "temperature", telemetryBase, at(time.Hour), 0)
+	wantError(t, err, persistence.ErrValidation, "QueryTelemetryGaps zero threshold")
+}
+
+func testTelemetryBulk(t *testing.T, ctx context.Context, s persistence.Store) {
+	// t1: three temperatures and a humidity; t2: one temperature; t3 (not queried): a temperature
+	for _, w := range []struct {
+		twinID string
+		rec    *persistence.TelemetryRecord
+	}{
+		{"t1", numericRecord("temperature", 0, 10, persistence.QualityGood)},
+		{"t1", numericRecord("temperature", time.Minute, 20, persistence.QualityBad)},
+		{"t1", numericRecord("temperature", 6*time.Minute, 30, persistence.QualityGood)},
+		{"t1", numericRecord("humidity", time.Minute, 50, persistence.QualityGood)},
+		{"t2", numericRecord("temperature", 2*time.Minute, 7, persistence.QualityGood)},
+		{"t3", numericRecord("temperature", 2*time.Minute, 99, persistence.QualityGood)},
+	} {
+		mustNoError(t, s.WriteTelemetry(ctx, w.twinID, w.rec), "WriteTelemetry")
+	}
+	end := telemetryBase.Add(time.Hour)
+	twins := []string{"t1", "t2", "missing"}
+	names := []string{"temperature", "humidity"}
+	key := func(twinID, name string) persistence.TelemetrySeriesKey {
+		return persistence.TelemetrySeriesKey{TwinID: twinID, Name: name}
+	}
+
+	series, err := s.QueryTelemetryBulk(ctx, twins, names, telemetryBase, end, 0, nil)
+	mustNoError(t, err, "QueryTelemetryBulk")
+	if len(series) != 3 {
+		t.Fatalf("QueryTelemetryBulk: got %d series, want 3 (series without points omitted)", len(series))
+	}
+	wantTimes(t, "t1 temperature", series[key("t1", "temperature")], 0, time.Minute, 6*time.Minute)
+	wantTimes(t, "t1 humidity", series[key("t1", "humidity")], time.Minute)
+	wantTimes(t, "t2 temperature", series[key("t2", "temperature")], 2*time.Minute)
+	if rec := series[key("t2", "temperature")][0]; rec.TwinID != "t2" || rec.Name != "temperature" {
+		t.Fatalf("QueryTelemetryBulk: got record of %s/%s, want t2/temperature", rec.TwinID, rec.Name)
+	}
+
+	// The limit applies per series, oldest points first; the quality filter before it
+	limited, err := s.QueryTelemetryBulk(ctx, twins, names, telemetryBase, end, 2, []persistence.Quality{persistence.QualityGood})
+	mustNoError(t, err, "QueryTelemetryBulk limit")
+	wantTimes(t, "limited t1 temperature", limited[key("t1", "temperature")], 0, 6*time.Minute)
+	wantTimes(t, "limited t2 temperature", limited[key("t2", "temperature")], 2*time.Minute)
+
+	buckets, err := s.QueryTelemetryAggregateBulk(ctx, twins, names, telemetryBase, end, 5*time.Minute, persistence.AggregateAvg, "", nil)
+	mustNoError(t, err, "QueryTelemetryAggregateBulk")
+	if len(buckets) != 3 {
+		t.Fatalf("QueryTelemetryAggregateBulk: got %d series, want 3", len(buckets))
+	}
+	t1 := buckets[key("t1", "temperature")]
+	if len(t1) != 2 || !t1[0].Bucket.Equal(telemetryBase) || !t1[1].Bucket.Equal(telemetryBase.Add(5*time.Minute)) {
+		t.Fatalf("QueryTelemetryAggregateBulk: got %d buckets for t1 temperature, want 00:00 and 00:05", len(t1))
+	}
+	wantValue(t, "t1 temperature first bucket", t1[0].Value, 15)
+	wantValue(t, "t1 temperature second bucket", t1[1].Value, 30)
+	if t2 := buckets[key("t2", "temperature")]; len(t2) != 1 {
+		t.Fatalf("QueryTelemetryAggregateBulk: got %d buckets for t2 temperature, want 1", len(t2))
+	} else {
+		wantValue(t, "t2 temperature", t2[0].Value, 7)
+	}
+
+	_, err = s.QueryTelemetryAggregateBulk(ctx, twins, names, telemetryBase, end, 5*time.Minute, "median", "", nil)
+	wantError(t, err, persistence.ErrValidation, "QueryTelemetryAggregateBulk unknown aggregation")
+}
+
+func testModelTelemetrySummary(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "pump")
+	mustCreateModel(t, ctx, s, "other")
+	// a and b report (a's older temperature doesn't count), c is silent, d is outside the selector
+	// and x belongs to another model
+	for _, twin := range []*model.TwinInstance{
+		newTwin("a", "pump", map[string]string{"site": "1"}),
+		newTwin("b", "pump", map[string]string{"site": "1"}),
+		newTwin("c", "pump", map[string]string{"site": "1"}),
+		newTwin("d", "pump", map[string]string{"site": "2"}),
+		newTwin("x", "other", map[string]string{"site": "1"}),
+	} {
+		mustCreateTwin(t, ctx, s, twin)
+	}
+	mode := "on"
+	for _, w := range []struct {
+		twinID string
+		rec    *persistence.TelemetryRecord
+	}{
+		{"a", numericRecord("temperature", 0, 100, persistence.QualityGood)},
+		{"a", numericRecord("temperature", time.Minute, 10, persistence.QualityGood)},
+		{"a", &persistence.TelemetryRecord{Name: "mode", Timestamp: telemetryBase, StringValue: &mode}},
+		{"b", numericRecord("temperature", 2*time.Minute, 30, persistence.QualityGood)},
+		{"d", numericRecord("temperature", 0, 1000, persistence.QualityGood)},
+		{"x", numericRecord("temperature", 0, 1000, persistence.QualityGood)},
+	} {
+		mustNoError(t, s.WriteTelemetry(ctx, w.twinID, w.rec), "WriteTelemetry")
+	}
+
+	summary, err := s.SummarizeModelTelemetry(ctx, "pump", map[string]string{"site": "1"}, 10)
+	mustNoError(t, err, "SummarizeModelTelemetry")
+	if summary.Twins != 3 || summary.Examined != 3 || summary.Reporting != 2 || summary.Points != 4 {
+		t.Fatalf("SummarizeModelTelemetry: got twins=%d examined=%d reporting=%d points=%d, want 3/3/2/4", summary.Twins, summary.Examined, summary.Reporting, summary.Points)
+	}
+	if len(summary.Metrics) != 2 || summary.Metrics[0].Name != "mode" || summary.Metrics[1].Name != "temperature" {
+		t.Fatalf("SummarizeModelTelemetry: got %d metrics, want mode and temperature", len(summary.Metrics))
+	}
+	if m := summary.Metrics[0]; m.Twins != 1 || m.Numeric != 0 || m.Avg != nil || m.Min != nil || m.Max != nil {
+		t.Fatalf("SummarizeModelTelemetry: got mode %+v, want one non-numeric twin", m)
+	}
+	temperature := summary.Metrics[1]
+	if temperature.Twins != 2 || temperature.Numeric != 2 || !temperature.LatestAt.Equal(telemetryBase.Add(2*time.Minute)) {
+		t.Fatalf("SummarizeModelTelemetry: got temperature %+v, want 2 numeric twins, latest at 00:02", temperature)
+	}
+	wantValue(t, "temperature avg", temperature.Avg, 20)
+	wantValue(t, "temperature min", temperature.Min, 10)
+	wantValue(t, "temperature max", temperature.Max, 30)
+
+	// maxTwins summarizes the first twins by ID but still counts all of them
+	capped, err := s.SummarizeModelTelemetry(ctx, "pump", nil, 1)
+	mustNoError(t, err, "SummarizeModelTelemetry capped")
+	if capped.Twins != 4 || capped.Examined != 1 || capped.Reporting != 1 || capped.Points != 3 || len(capped.Metrics) != 2 {
+		t.Fatalf("SummarizeModelTelemetry capped: got twins=%d examined=%d reporting=%d points=%d metrics=%d, want 4/1/1/3/2", capped.Twins, capped.Examined, capped.Reporting, capped.Points, len(capped.Metrics))
+	}
+	wantValue(t, "capped temperature max", capped.Metrics[1].Max, 10)
+}
+
+func testModelUsage(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "pump")
+	mustCreateModel(t, ctx, s, "other")
+	// a and b report within the window (a's first point is before it), c is silent, d is outside
+	// the selector and x belongs to another model
+	for _, twin := range []*model.TwinInstance{
+		newTwin("a", "pump", map[string]string{"site": "1"}),
+		newTwin("b", "pump", map[string]string{"site": "1"}),
+		newTwin("c", "pump", map[string]string{"site": "1"}),
+		newTwin("d", "pump", map[string]string{"site": "2"}),
+		newTwin("x", "other", map[string]string{"site": "1"}),
+	} {
+		mustCreateTwin(t, ctx, s, twin)
+	}
+	for _, w := range []struct {
+		twinID string
+		rec    *persistence.TelemetryRecord
+	}{
+		{"a", numericRecord("temperature", 0, 1, persistence.QualityGood)},
+		{"a", numericRecord("temperature", 2*time.Minute, 2, persistence.QualityGood)},
+		{"a", numericRecord("pressure", 3*time.Minute, 3, persistence.QualityGood)},
+		{"b", numericRecord("temperature", time.Minute, 4, persistence.QualityGood)},
+		{"d", numericRecord("temperature", 2*time.Minute, 5, persistence.QualityGood)},
+		{"x", numericRecord("temperature", 2*time.Minute, 6, persistence.QualityGood)},
+	} {
+		mustNoError(t, s.WriteTelemetry(ctx, w.twinID, w.rec), "WriteTelemetry")
+	}
+	first, err := s.FindTwinByID(ctx, "a")
+	mustNoError(t, err, "FindTwinByID a")
+	last, err := s.FindTwinByID(ctx, "c")
+	mustNoError(t, err, "FindTwinByID c")
+
+	since := telemetryBase.Add(time.Minute)
+	usage, err := s.SummarizeModelUsage(ctx, "pump", map[string]string{"site": "1"}, since)
+	mustNoError(t, err, "SummarizeModelUsage")
+	if usage.ModelID != "pump" || usage.Twins != 3 || usage.TelemetryPoints != 3 || usage.ReportingTwins != 2 || !usage.TelemetrySince.Equal(since) {
+		t.Fatalf("SummarizeModelUsage: got %+v, want 3 twins, 3 points of 2 twins since 00:01", usage)
+	}
+	if usage.OldestTwinCreatedAt == nil || !usage.OldestTwinCreatedAt.Equal(first.CreatedAt) ||
+		usage.NewestTwinCreatedAt == nil || !usage.NewestTwinCreatedAt.Equal(last.CreatedAt) {
+		t.Fatalf("SummarizeModelUsage: got created %v to %v, want %v to %v", usage.OldestTwinCreatedAt, usage.NewestTwinCreatedAt, first.CreatedAt, last.CreatedAt)
+	}
+
+	unused, err := s.SummarizeModelUsage(ctx, "none", nil, since)
+	mustNoError(t, err, "SummarizeModelUsage unused")
+	if unused.Twins != 0 || unused.TelemetryPoints != 0 || unused.ReportingTwins != 0 || unused.OldestTwinCreatedAt != nil || unused.NewestTwinCreatedAt != nil {
+		t.Fatalf("SummarizeModelUsage unused: got %+v, want zeros", unused)
+	}
+}
+
+// testEmptyResults checks that lookups matching nothing return empty (non-nil) results rather than
+// errors, so handlers encode [] / {} instead of null.
+func testTelemetryRetention(t *testing.T, ctx context.Context, s persistence.Store) {
+	mustCreateModel(t, ctx, s, "fast")
+	mustCreateModel(t, ctx, s, "slow")
+	for _, twin := range []*model.TwinInstance{newTwin("f", "fast", nil), newTwin("s", "slow", nil), newTwin("gone", "fast", nil)} {
+		mustCreateTwin(t, ctx, s, twin)
+		for _, name := range []string{"vibration", "energy"} {
+			for _, offset := range []time.Duration{0, 10 * time.Minute, 20 * time.Minute} {
+				mustNoError(t, s.WriteTelemetry(ctx, twin.ID, numericRecord(name, offset, 1, persistence.QualityGood)), "WriteTelemetry")
+			}
+		}
+	}
+	mustNoError(t, s.DeleteTwin(ctx, "gone"), "DeleteTwin") // Its telemetry stays behind
+
+	rules := []persistence.RetentionRule{
+		{ModelID: "fast", Name: "vibration", Before: telemetryBase.Add(15 * time.Minute)},
+		{ModelID: "slow", Name: "energy", Before: telemetryBase}, // Longer than the default
+	}
+	deleted, err := s.PruneTelemetry(ctx, rules, telemetryBase.Add(15*time.Minute))
+	mustNoError(t, err, "PruneTelemetry")
+	// f: both names by rule and default; s: vibration by default; gone: both by default
+	if deleted != 10 {
+		t.Fatalf("PruneTelemetry: deleted %d points, want 10", deleted)
+	}
+
+	end := telemetryBase.Add(time.Hour)
+	for _, tc := range []struct {
+		twinID, name string
+		want         []time.Duration
+	}{
+		{"f", "vibration", []time.Duration{20 * time.Minute}},
+		{"f", "energy", []time.Duration{20 * time.Minute}},
+		{"s", "vibration", []time.Duration{20 * time.Minute}},
+		{"s", "energy", []time.Duration{0, 10 * time.Minute, 20 * time.Minute}},
+		{"gone", "vibration", []time.Duration{20 * time.Minute}},
+	} {
+		history, err := s.QueryTelemetryHistory(ctx, tc.twinID, tc.name, telemetryBase, end, false, 0, nil)
+		mustNoError(t, err, "QueryTelemetryHistory")
+		wantTimes(t, "after pruning "+tc.twinID+"/"+tc.name, history, tc.want...)
+	}
+
+	// Without a default retention only the rules apply
+	deleted, err = s.PruneTelemetry(ctx, []persistence.RetentionRule{{ModelID: "slow", Name: "energy", Before: telemetryBase.Add(5 * time.Minute)}}, time.Time{})
+	mustNoError(t, err, "PruneTelemetry without default")
+	if deleted != 1 {
+		t.Fatalf("PruneTelemetry without default: deleted %d points, want 1", deleted)
+	}
+}
+
+func testEmptyResults(t *testing.T, ctx context.Context, s persistence.Store) {
+	end := telemetryBase.Add(time.Hour)
+
+	models, err := s.ListAllModels(ctx)
+	mustNoError(t, err, "ListAllModels")
+	byCategory, err := s.ListModelsByCategory(ctx, "none")
+	mustNoError(t, err, "ListModelsByCategory")
+	categories, err := s.ListModelCategories(ctx)
+	mustNoError(t, err, "ListModelCategories")
+	twins, err := s.ListAllTwins(ctx)
+	mustNoError(t, err, "ListAllTwins")
+	byModel, err := s.ListTwinsByModel(ctx, "none")
+	mustNoError(t, err, "ListTwinsByModel")
+	page, err := s.ListTwinsByModelPage(ctx, "none", "", 10)
+	mustNoError(t, err, "ListTwinsByModelPage")
+	byTags, err := s.ListTwinsByTags(ctx, map[string]string{"a": "b"}, "")
+	mustNoError(t, err, "ListTwinsByTags")
+	inBox, err := s.ListTwinsInBox(ctx, geo.Box{MinLat: -90, MaxLat: 90, MinLng: -180, MaxLng: 180}, nil, "")
+	mustNoError(t, err, "ListTwinsInBox")
+	ids, err := s.ListTwinIDs(ctx, map[string]string{"a": "b"}, "", "", 10)
+	mustNoError(t, err, "ListTwinIDs")
+	modelIDs, err := s.ListModelIDs(ctx, "", 10)
+	mustNoError(t, err, "ListModelIDs")
+	orphans, err := s.ListOrphanedTwins(ctx)
+	mustNoError(t, err, "ListOrphanedTwins")
+	templates, err := s.ListAllTemplates(ctx)
+	mustNoError(t, err, "ListAllTemplates")
+	history, err := s.QueryTelemetryHistory(ctx, "none", "temperature", telemetryBase, end, false, 0, nil)
+	mustNoError(t, err, "QueryTelemetryHistory")
+	buckets, err := s.QueryTelemetryAggregate(ctx, "none", "temperature", telemetryBase, end, time.Minute, persistence.AggregateAvg, "", nil)
+	mustNoError(t, err, "QueryTelemetryAggregate")
+	latest, err := s.QueryLatestTelemetry(ctx, "none", nil)
+	mustNoError(t, err, "QueryLatestTelemetry")
+	names, err := s.ListTelemetryNames(ctx, "none")
+	mustNoError(t, err, "ListTelemetryNames")
+	gaps, err := s.QueryTelemetryGaps(ctx, "none", "temperature", telemetryBase, telemetryBase, time.Minute)
+	mustNoError(t, err, "QueryTelemetryGaps")
+	bulk, err := s.QueryTelemetryBulk(ctx, []string{"none"}, []string{"temperature"}, telemetryBase, end, 0, nil)
+	mustNoError(t, err, "QueryTelemetryBulk")
+	bulkBuckets, err := s.QueryTelemetryAggregateBulk(ctx, []string{"none"}, []string{"temperature"}, telemetryBase, end, time.Minute, persistence.AggregateAvg, "", nil)
+	mustNoError(t, err, "QueryTelemetryAggregateBulk")
+	summary, err := s.SummarizeModelTelemetry(ctx, "none", nil, 10)
+	mustNoError(t, err, "SummarizeModelTelemetry")
+
+	for what, bad := range map[string]bool{
+		"ListAllModels":               models == nil || len(models) > 0,
+		"ListModelsByCategory":        byCategory == nil || len(byCategory) > 0,
+		"ListModelCategories":         categories == nil || len(categories) > 0,
+		"ListAllTwins":                twins == nil || len(twins) > 0,
+		"ListTwinsByModel":            byModel == nil || len(byModel) > 0,
+		"ListTwinsByModelPage":        page == nil || len(page) > 0,
+		"ListTwinsByTags":             byTags == nil || len(byTags) > 0,
+		"ListTwinsInBox":              inBox == nil || len(inBox) > 0,
+		"ListTwinIDs":                 ids == nil || len(ids) > 0,
+		"ListModelIDs":                modelIDs == nil || len(modelIDs) > 0,
+		"ListOrphanedTwins":           orphans == nil || len(orphans) > 0,
+		"ListAllTemplates":            templates == nil || len(templates) > 0,
+		"QueryTelemetryHistory":       history == nil || len(history) > 0,
+		"QueryTelemetryAggregate":     buckets == nil || len(buckets) > 0,
+		"QueryLatestTelemetry":        latest == nil || len(latest) > 0,
+		"ListTelemetryNames":          names == nil || len(names) > 0,
+		"QueryTelemetryGaps":          gaps == nil || len(gaps) > 0,
+		"QueryTelemetryBulk":          bulk == nil || len(bulk) > 0,
+		"QueryTelemetryAggregateBulk": bulkBuckets == nil || len(bulkBuckets) > 0,
+		"SummarizeModelTelemetry":     summary.Metrics == nil || len(summary.Metrics) > 0 || summary.Twins != 0,
+	} {
+		if bad {
+			t.Errorf("%s: want an empty, non-nil result", what)
+		}
+	}
+
+	count, err := s.CountTelemetry(ctx, "none", "temperature", telemetryBase, end, false)
+	mustNoError(t, err, "CountTelemetry")
+	if count != 0 {
+		t.Fatalf("CountTelemetry: got %d, want 0", count)
+	}
+}
@@ -0,0 +1,132 @@
+// pkg/presence/tracker.go
+package presence
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+)
+
+// Presence metrics
+var onlineTwins = metrics.NewGauge("presence_online_twins", "Twins with at least one open presence connection.")
+
+// Status is the connection state of a single twin.
+type Status struct {
+	TwinID         string     `json:"twinId"`
+	Online         bool       `json:"online"`                   // At least one device connection is open
+	Connections    int        `json:"connections"`              // Open connections (a device may reconnect before the old socket times out)
+	ConnectedSince *time.Time `json:"connectedSince,omitempty"` // When the twin went online (nil while offline)
+	LastSeen       *time.Time `json:"lastSeen,omitempty"`       // Last connect, keepalive or disconnect (nil if never seen)
+}
+
+// entry is the tracked state for one twin.
+type entry struct {
+	connections    int
+	connectedSince time.Time
+	lastSeen       time.Time
+}
+
+// Tracker keeps real connection state for twins in memory.
+// It is per-process: with several API replicas each one only knows about its own connections,
+// and everything resets on restart (devices reconnect and are marked online again).
+type Tracker struct {
+	mu    sync.RWMutex
+	twins map[string]*entry
+	now   func() time.Time
+}
+
+// NewTracker creates an empty presence tracker.
+func NewTracker() *Tracker {
+	return &Tracker{
+		twins: make(map[string]*entry),
+		now:   func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Connect records a new device connection for the twin and marks it online.
+// Every Connect must be paired with exactly one Disconnect.
+func (t *Tracker) Connect(twinID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	e, ok := t.twins[twinID]
+	if !ok {
+		e = &entry{}
+		t.twins[twinID] = e
+	}
+	if e.connections == 0 {
+		e.connectedSince = now
+		onlineTwins.Inc()
+	}
+	e.connections++
+	e.lastSeen = now
+}
+
+// Touch refreshes the twin's last-seen time (called on keepalives and messages).
+func (t *Tracker) Touch(twinID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.twins[twinID]; ok {
+		e.lastSeen = t.now()
+	}
+}
+
+// Disconnect closes one connection; the twin goes offline when its last connection closes.
+func (t *Tracker) Disconnect(twinID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.twins[twinID]
+	if !ok || e.connections == 0 {
+		return
+	}
+	e.connections--
+	e.lastSeen = t.now()
+	if e.connections == 0 {
+		onlineTwins.Dec()
+	}
+}
+
+// Status returns the twin's current presence. Twins never seen are reported offline.
+func (t *Tracker) Status(twinID string) Status {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	st := Status{TwinID: twinID}
+	e, ok := t.twins[twinID]
+	if !ok {
+		return st
+	}
+	lastSeen := e.lastSeen
+	st.LastSeen = &lastSeen
+	st.Connections = e.connections
+	st.Online = e.connections > 0
+	if st.Online {
+		since := e.connectedSince
+		st.ConnectedSince = &since
+	}
+	return st
+}
+
+// IsOnline reports whether the twin has at least one open connection.
+func (t *Tracker) IsOnline(twinID string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	e, ok := t.twins[twinID]
+	return ok && e.connections > 0
+}
+
+// Forget drops all state for a twin (e.g., after the twin is deleted).
+func (t *Tracker) Forget(twinID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.twins[twinID]; ok && e.connections > 0 {
+		onlineTwins.Dec()
+	}
+	delete(t.twins, twinID)
+}
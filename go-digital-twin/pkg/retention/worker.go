@@ -0,0 +1,89 @@
+// pkg/retention/worker.go
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/scheduler"
+)
+
+// Retention metrics
+var (
+	deletedTotal = metrics.NewCounter("telemetry_retention_deleted_total", "Telemetry points deleted by the retention worker.")
+	failedTotal  = metrics.NewCounter("telemetry_retention_failures_total", "Retention runs that failed.")
+)
+
+// Rules turns the telemetry retentions declared by models into store rules relative to now,
+// ordered by model and name.
+func Rules(models []*model.TwinModel, now time.Time) []persistence.RetentionRule {
+	rules := []persistence.RetentionRule{}
+	for _, m := range models {
+		for name, retention := range m.TelemetryRetentions() {
+			rules = append(rules, persistence.RetentionRule{ModelID: m.ID, Name: name, Before: now.Add(-retention)})
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].ModelID != rules[j].ModelID {
+			return rules[i].ModelID < rules[j].ModelID
+		}
+		return rules[i].Name < rules[j].Name
+	})
+	return rules
+}
+
+// Run prunes expired telemetry once: per model and name where the model declares a retention,
+// and by defaultRetention everywhere else (zero keeps that data forever). Models are re-read on
+// every run, so policy changes apply from the next one. Returns the points deleted.
+func Run(ctx context.Context, store persistence.Store, defaultRetention time.Duration) (int64, error) {
+	models, err := store.ListAllModels(ctx)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	rules := Rules(models, now)
+	var defaultBefore time.Time
+	if defaultRetention > 0 {
+		defaultBefore = now.Add(-defaultRetention)
+	}
+	if len(rules) == 0 && defaultBefore.IsZero() {
+		return 0, nil // Nothing expires
+	}
+	return store.PruneTelemetry(ctx, rules, defaultBefore)
+}
+
+// Register schedules Run on s as the job "telemetry_retention" every interval (non-positive
+// disables it). Every replica runs its own; deletes are idempotent, so concurrent runs only
+// repeat work.
+func Register(s *scheduler.Scheduler, store persistence.Store, defaultRetention, interval time.Duration) {
+	s.Register("telemetry_retention", interval, func(ctx context.Context) error {
+		started := time.Now()
+		deleted, err := Run(ctx, store, defaultRetention)
+		deletedTotal.Add(float64(deleted))
+		switch {
+		case err != nil:
+			if ctx.Err() == nil {
+				failedTotal.Inc()
+			}
+			return fmt.Errorf("deleted %d points before failing: %w", deleted, err)
+		case deleted > 0:
+			log.Printf("INFO: Telemetry retention deleted %d points in %s", deleted, time.Since(started).Round(time.Millisecond))
+		}
+		return nil
+	})
+	if interval <= 0 {
+		return
+	}
+
+	if defaultRetention > 0 {
+		log.Printf("INFO: Pruning telemetry every %s (default retention %s, model-defined retentions apply per name)", interval, model.FormatRetention(defaultRetention))
+	} else {
+		log.Printf("INFO: Pruning telemetry every %s (model-defined retentions only; other telemetry is kept)", interval)
+	}
+}
@@ -0,0 +1,186 @@
+// pkg/scheduler/scheduler.go
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+)
+
+// jobsRunning counts job runs in progress across all jobs.
+var jobsRunning = metrics.NewGauge("scheduler_jobs_running", "Background job runs currently in progress.")
+
+// Func is the work of a job. ctx is cancelled when the scheduler stops; long runs should honour it.
+type Func func(ctx context.Context) error
+
+// job is one registered job with its metrics (named scheduler_<job>_..., the registry has no labels).
+type job struct {
+	name     string
+	interval time.Duration
+	run      Func
+	running  atomic.Bool // A run is in progress; ticks that arrive meanwhile are skipped
+
+	runs        *metrics.Counter
+	failures    *metrics.Counter
+	skipped     *metrics.Counter
+	duration    *metrics.Gauge
+	lastSuccess *metrics.Gauge
+}
+
+// Scheduler runs named jobs periodically in the background: each job runs once on Start and then
+// every interval, never overlapping itself (a tick that arrives while the previous run is still
+// going is skipped and counted). A failing or panicking run is logged and counted; the job keeps
+// its schedule. Every replica runs its own scheduler, so jobs must tolerate running concurrently
+// on several instances.
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    []*job
+	names   map[string]bool
+	started bool
+	cancel  context.CancelFunc
+	loops   sync.WaitGroup // Tick loops
+	runs    sync.WaitGroup // Job runs in progress
+}
+
+// New creates a scheduler without jobs.
+func New() *Scheduler {
+	return &Scheduler{names: make(map[string]bool)}
+}
+
+// Register adds a job running every interval; a non-positive interval disables it (logged).
+// Names must be unique and jobs registered before Start: breaking either is a programming error.
+func (s *Scheduler) Register(name string, interval time.Duration, run Func) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		panic(fmt.Sprintf("scheduler: job %q registered after Start", name))
+	}
+	if s.names[name] {
+		panic(fmt.Sprintf("scheduler: duplicate job %q", name))
+	}
+	s.names[name] = true
+	if interval <= 0 {
+		log.Printf("WARN: Background job '%s' disabled (interval %s)", name, interval)
+		return
+	}
+
+	prefix := "scheduler_" + metrics.SanitizeName(name)
+	s.jobs = append(s.jobs, &job{
+		name:        name,
+		interval:    interval,
+		run:         run,
+		runs:        metrics.NewCounter(prefix+"_runs_total", fmt.Sprintf("Runs of the background job '%s'.", name)),
+		failures:    metrics.NewCounter(prefix+"_failures_total", fmt.Sprintf("Runs of the background job '%s' that returned an error or panicked.", name)),
+		skipped:     metrics.NewCounter(prefix+"_skipped_total", fmt.Sprintf("Scheduled runs of the background job '%s' skipped because the previous run was still in progress.", name)),
+		duration:    metrics.NewGauge(prefix+"_last_duration_seconds", fmt.Sprintf("Duration of the last run of the background job '%s'.", name)),
+		lastSuccess: metrics.NewGauge(prefix+"_last_success_timestamp_seconds", fmt.Sprintf("Unix time the background job '%s' last succeeded.", name)),
+	})
+	log.Printf("INFO: Scheduled background job '%s' every %s", name, interval)
+}
+
+// Start runs every registered job right away and then on its interval, until ctx is cancelled or
+// Stop is called. It returns immediately.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	for _, j := range s.jobs {
+		s.loops.Add(1)
+		go s.loop(ctx, j)
+	}
+}
+
+// Stop stops scheduling runs, cancels the context of runs in progress and waits for them to
+// return. If ctx expires first it returns ctx's error and the runs are abandoned.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.started = true // No Start after Stop
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.loops.Wait()
+		s.runs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background jobs still running (%d): %w", int(jobsRunning.Value()), ctx.Err())
+	}
+}
+
+// loop starts a run of j on every tick until ctx is cancelled.
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	defer s.loops.Done()
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		s.trigger(ctx, j)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// trigger starts a run of j unless one is still in progress.
+func (s *Scheduler) trigger(ctx context.Context, j *job) {
+	if !j.running.CompareAndSwap(false, true) {
+		j.skipped.Inc()
+		log.Printf("WARN: Background job '%s' is still running; skipping this run", j.name)
+		return
+	}
+	s.runs.Add(1)
+	go func() {
+		defer s.runs.Done()
+		defer j.running.Store(false)
+		s.execute(ctx, j)
+	}()
+}
+
+// execute runs j once, recovering a panic, and records the outcome.
+func (s *Scheduler) execute(ctx context.Context, j *job) {
+	jobsRunning.Inc()
+	defer jobsRunning.Dec()
+	started := time.Now()
+
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v\n%s", p, debug.Stack())
+			}
+		}()
+		return j.run(ctx)
+	}()
+
+	elapsed := time.Since(started)
+	j.runs.Inc()
+	j.duration.Set(elapsed.Seconds())
+	switch {
+	case err != nil && ctx.Err() != nil:
+		log.Printf("INFO: Background job '%s' interrupted by shutdown after %s: %v", j.name, elapsed.Round(time.Millisecond), err)
+	case err != nil:
+		j.failures.Inc()
+		log.Printf("ERROR: Background job '%s' failed after %s: %v", j.name, elapsed.Round(time.Millisecond), err)
+	default:
+		j.lastSuccess.Set(float64(time.Now().Unix()))
+	}
+}
@@ -0,0 +1,263 @@
+// pkg/webhook/dispatcher.go
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/metrics"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/model"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/persistence"
+	"github.com/aleka07/digital_egizz/go-digital-twin/pkg/scheduler"
+)
+
+// Dispatcher metrics
+var (
+	queuedTotal    = metrics.NewCounter("webhook_deliveries_queued_total", "Webhook deliveries queued for outbox events.")
+	succeededTotal = metrics.NewCounter("webhook_deliveries_succeeded_total", "Webhook deliveries their endpoint accepted.")
+	attemptsFailed = metrics.NewCounter("webhook_delivery_attempts_failed_total", "Webhook delivery attempts that failed (retried until the last attempt).")
+	failedTotal    = metrics.NewCounter("webhook_deliveries_failed_total", "Webhook deliveries given up after their last attempt.")
+	prunedTotal    = metrics.NewCounter("webhook_deliveries_pruned_total", "Finished webhook deliveries deleted after the retention.")
+)
+
+// Headers of a delivery request
+const (
+	HeaderWebhook   = "X-Webhook-Id"        // The subscription's ID
+	HeaderEvent     = "X-Webhook-Event"     // The event type
+	HeaderDelivery  = "X-Webhook-Delivery"  // The delivery ID; the same on every attempt, so receivers can dedupe
+	HeaderTimestamp = "X-Webhook-Timestamp" // Unix seconds of the attempt, covered by the signature
+	HeaderSignature = "X-Webhook-Signature" // "sha256=" + hex HMAC (see Sign); only with a secret
+)
+
+const (
+	maxBackoff    = time.Hour // Retry delays double up to it
+	pruneInterval = time.Hour // How often finished deliveries are deleted
+)
+
+// Fanout is the outbox publisher behind the webhooks: it queues a delivery of every event for
+// each subscription the event matches. Queuing is idempotent, so a batch the relay publishes
+// again queues nothing twice.
+type Fanout struct {
+	Store persistence.WebhookStore
+}
+
+// Publish queues the events' deliveries.
+func (f *Fanout) Publish(ctx context.Context, events []*persistence.OutboxEvent) error {
+	hooks, err := f.Store.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	deliveries := []*persistence.WebhookDelivery{}
+	for _, e := range events {
+		for _, h := range hooks {
+			if h.Matches(e) {
+				deliveries = append(deliveries, &persistence.WebhookDelivery{WebhookID: h.ID, Event: *e})
+			}
+		}
+	}
+	queued, err := f.Store.EnqueueWebhookDeliveries(ctx, deliveries)
+	if err != nil {
+		return err
+	}
+	queuedTotal.Add(float64(queued))
+	return nil
+}
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	DeliveryID int64                   `json:"deliveryId"`
+	WebhookID  string                  `json:"webhookId"`
+	Attempt    int                     `json:"attempt"` // 1 for the first attempt
+	Event      persistence.OutboxEvent `json:"event"`
+}
+
+// Sign returns the X-Webhook-Signature of a delivery: the hex HMAC-SHA256, keyed by the
+// subscription's secret, of the timestamp header, a dot and the body. Receivers recompute it and
+// reject stale timestamps to stop replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher POSTs the queued deliveries to their subscriptions: any 2xx response succeeds them,
+// anything else is retried with exponential backoff until MaxAttempts attempts failed. Every
+// attempt's outcome is recorded on the delivery.
+type Dispatcher struct {
+	Store       persistence.WebhookStore
+	Client      *http.Client  // Its Timeout bounds each attempt
+	Concurrency int           // Deliveries claimed and attempted at once
+	Lease       time.Duration // How long claimed deliveries are reserved; keep it above the client's timeout
+	MaxAttempts int
+	Backoff     time.Duration // Delay after the first failed attempt; doubles with every further one
+	Retention   time.Duration // Finished deliveries are deleted after it
+}
+
+// NewDispatcher creates a dispatcher whose attempts time out after timeout; claims are leased for
+// three times as long, so a delivery is only attempted again once its previous attempt surely ended.
+func NewDispatcher(store persistence.WebhookStore, timeout time.Duration) *Dispatcher {
+	return &Dispatcher{Store: store, Client: &http.Client{Timeout: timeout}, Lease: 3 * timeout}
+}
+
+// Run attempts the due deliveries, batch by batch, until none are left. Returns how many were
+// attempted; failed attempts are recorded and logged, only store errors are returned.
+func (d *Dispatcher) Run(ctx context.Context) (int, error) {
+	attempted := 0
+	for {
+		deliveries, err := d.Store.ClaimWebhookDeliveries(ctx, d.Concurrency, d.Lease)
+		if err != nil {
+			return attempted, err
+		}
+		if len(deliveries) == 0 {
+			return attempted, nil
+		}
+		hooks, err := d.Store.ListWebhooks(ctx)
+		if err != nil {
+			return attempted, err
+		}
+		byID := make(map[string]*persistence.Webhook, len(hooks))
+		for _, h := range hooks {
+			byID[h.ID] = h
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			hook, ok := byID[delivery.WebhookID]
+			if !ok {
+				continue // Deleted since the claim, and its deliveries with it
+			}
+			wg.Add(1)
+			go func(hook *persistence.Webhook, delivery *persistence.WebhookDelivery) {
+				defer wg.Done()
+				d.attempt(ctx, hook, delivery)
+			}(hook, delivery)
+		}
+		wg.Wait()
+
+		attempted += len(deliveries)
+		if len(deliveries) < d.Concurrency {
+			return attempted, nil
+		}
+	}
+}
+
+// attempt delivers once and records the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, hook *persistence.Webhook, delivery *persistence.WebhookDelivery) {
+	status, err := d.post(ctx, hook, delivery)
+	delivery.LastStatusCode = status
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.Status = persistence.DeliverySucceeded
+		succeededTotal.Inc()
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = persistence.DeliveryFailed
+		delivery.LastError = err.Error()
+		failedTotal.Inc()
+		log.Printf("WARN: Giving up webhook delivery %d (%s to webhook %s) after %d attempts: %v", delivery.ID, delivery.Event.Type, hook.ID, delivery.Attempts, err)
+	default:
+		delivery.Status = persistence.DeliveryPending
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = time.Now().Add(d.backoff(delivery.Attempts))
+		attemptsFailed.Inc()
+		log.Printf("DEBUG: Webhook delivery %d to webhook %s failed (attempt %d of %d, retrying at %s): %v",
+			delivery.ID, hook.ID, delivery.Attempts, d.MaxAttempts, delivery.NextAttemptAt.Format(time.RFC3339), err)
+	}
+	if err := d.Store.UpdateWebhookDelivery(ctx, delivery); err != nil {
+		// The lease runs out and the delivery is attempted again
+		log.Printf("ERROR: Failed to record the outcome of webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// backoff returns the delay after the given failed attempt.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.Backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// post sends the delivery and returns the response status (0 without a response).
+func (d *Dispatcher) post(ctx context.Context, hook *persistence.Webhook, delivery *persistence.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(Payload{DeliveryID: delivery.ID, WebhookID: hook.ID, Attempt: delivery.Attempts, Event: delivery.Event})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhook, hook.ID)
+	req.Header.Set(HeaderEvent, delivery.Event.Type)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if hook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, body))
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // Lets the connection be reused
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Prune deletes the deliveries finished before the retention. Returns how many were deleted.
+func (d *Dispatcher) Prune(ctx context.Context) (int64, error) {
+	return d.Store.PruneWebhookDeliveries(ctx, time.Now().Add(-d.Retention))
+}
+
+// Register schedules the dispatcher on s: "webhook_deliveries" attempts the due deliveries every
+// interval (non-positive disables it) and "webhook_deliveries_prune" deletes finished ones hourly.
+// Every replica runs both; claims are leased, so replicas never attempt the same delivery at once.
+func Register(s *scheduler.Scheduler, d *Dispatcher, interval time.Duration) {
+	s.Register("webhook_deliveries", interval, func(ctx context.Context) error {
+		attempted, err := d.Run(ctx)
+		if err != nil {
+			return fmt.Errorf("attempted %d webhook deliveries before failing: %w", attempted, err)
+		}
+		if attempted > 0 {
+			log.Printf("DEBUG: Attempted %d webhook deliveries", attempted)
+		}
+		return nil
+	})
+	if interval > 0 {
+		log.Printf("INFO: Delivering webhooks every %s (%d at once, %d attempts each); finished deliveries are kept for %s",
+			interval, d.Concurrency, d.MaxAttempts, model.FormatRetention(d.Retention))
+	}
+
+	s.Register("webhook_deliveries_prune", pruneInterval, func(ctx context.Context) error {
+		deleted, err := d.Prune(ctx)
+		prunedTotal.Add(float64(deleted))
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			log.Printf("INFO: Pruned %d webhook deliveries", deleted)
+		}
+		return nil
+	})
+}
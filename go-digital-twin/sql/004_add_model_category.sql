@@ -0,0 +1,9 @@
+-- sql/004_add_model_category.sql
+
+-- Optional organizational grouping for models (e.g., "HVAC", "Lighting", "Security").
+-- Empty string means "uncategorized" so existing rows need no backfill.
+ALTER TABLE twin_models
+    ADD COLUMN IF NOT EXISTS category VARCHAR(100) NOT NULL DEFAULT '';
+
+-- Category filtering is case-insensitive, so index the lowered value
+CREATE INDEX IF NOT EXISTS idx_twin_models_category_lower ON twin_models (LOWER(category));
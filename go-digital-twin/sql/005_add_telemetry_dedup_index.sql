@@ -0,0 +1,10 @@
+-- sql/005_add_telemetry_dedup_index.sql
+
+-- A unique index on (twin_id, name, ts) makes telemetry writes idempotent:
+-- backfill/replay uses INSERT ... ON CONFLICT DO NOTHING against it, so retrying
+-- the same batch never creates duplicate points.
+-- TimescaleDB requires unique indexes on a hypertable to include the time column (ts), which this does.
+-- NOTE: Creation fails if duplicates already exist. Remove them first, e.g.:
+--   DELETE FROM telemetry a USING telemetry b
+--   WHERE a.ctid < b.ctid AND a.twin_id = b.twin_id AND a.name = b.name AND a.ts = b.ts;
+CREATE UNIQUE INDEX IF NOT EXISTS uq_telemetry_twin_name_ts ON telemetry (twin_id, name, ts);
@@ -0,0 +1,24 @@
+-- sql/006_create_twin_templates.sql
+
+-- Reusable provisioning templates: a model reference plus default desired properties and tags.
+-- model_id is deliberately NOT a foreign key: deleting a model shouldn't be blocked by templates,
+-- so the API re-checks that the model exists whenever a twin is instantiated from a template.
+CREATE TABLE IF NOT EXISTS twin_templates (
+    id VARCHAR(255) PRIMARY KEY,
+    display_name VARCHAR(255) NOT NULL,
+    description TEXT,
+    model_id VARCHAR(255) NOT NULL,
+    desired_properties JSONB NOT NULL DEFAULT '{}'::jsonb, -- Defaults copied into new twins
+    tags JSONB NOT NULL DEFAULT '{}'::jsonb,               -- Defaults copied into new twins
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_twin_templates_model_id ON twin_templates(model_id);
+
+-- Reuse the updated_at trigger function from 001_create_twin_models.sql
+DROP TRIGGER IF EXISTS set_timestamp ON twin_templates;
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON twin_templates
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
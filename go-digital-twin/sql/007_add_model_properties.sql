@@ -0,0 +1,6 @@
+-- sql/007_add_model_properties.sql
+
+-- Property definitions (name -> {name, schema, writable, unit, description}) for each model.
+-- '{}' means the model declares no properties, so existing models stay unconstrained.
+ALTER TABLE twin_models
+    ADD COLUMN IF NOT EXISTS properties JSONB NOT NULL DEFAULT '{}'::jsonb;
@@ -0,0 +1,11 @@
+-- sql/008_add_telemetry_quality.sql
+
+-- Per-reading data quality: 0 = good, 1 = uncertain, 2 = bad (see persistence.Quality).
+-- Existing rows and writes that don't specify a quality are good.
+ALTER TABLE telemetry
+    ADD COLUMN IF NOT EXISTS quality SMALLINT NOT NULL DEFAULT 0;
+
+ALTER TABLE telemetry
+    DROP CONSTRAINT IF EXISTS chk_telemetry_quality;
+ALTER TABLE telemetry
+    ADD CONSTRAINT chk_telemetry_quality CHECK (quality IN (0, 1, 2));
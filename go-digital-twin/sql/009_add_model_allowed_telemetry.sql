@@ -0,0 +1,6 @@
+-- sql/009_add_model_allowed_telemetry.sql
+
+-- Optional allowlist of telemetry names for each model's twins.
+-- '{}' means no allowlist, so existing models keep accepting any telemetry name.
+ALTER TABLE twin_models
+    ADD COLUMN IF NOT EXISTS allowed_telemetry_names TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,6 @@
+-- sql/010_add_twin_metadata.sql
+
+-- Arbitrary typed JSON metadata for each twin, separate from the string tags used for filtering.
+-- Existing twins get '{}'; their tags are left as they are.
+ALTER TABLE twin_instances
+    ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}'::jsonb;
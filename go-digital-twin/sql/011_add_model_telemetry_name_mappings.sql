@@ -0,0 +1,6 @@
+-- sql/011_add_model_telemetry_name_mappings.sql
+
+-- Optional telemetry name rewrites (alias -> canonical name) applied when twins of the model ingest.
+-- '{}' means no rewriting, so existing models keep storing names as sent.
+ALTER TABLE twin_models
+    ADD COLUMN IF NOT EXISTS telemetry_name_mappings JSONB NOT NULL DEFAULT '{}'::jsonb;
@@ -0,0 +1,6 @@
+-- sql/012_add_model_derived_properties.sql
+
+-- Derived property definitions (name -> {expression, unit, description}) evaluated when twins of the
+-- model are read. Only the definitions are stored; computed values never are.
+ALTER TABLE twin_models
+    ADD COLUMN IF NOT EXISTS derived_properties JSONB NOT NULL DEFAULT '{}'::jsonb;
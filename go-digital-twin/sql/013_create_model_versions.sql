@@ -0,0 +1,15 @@
+-- sql/013_create_model_versions.sql
+
+-- Change history of twin models: one row per create/update/delete holding the definition as JSON
+-- (the API representation of model.TwinModel). Rows are written in the same transaction as the
+-- change. There is no foreign key to twin_models so the history of a deleted model is kept.
+-- Models that existed before this migration get a 'baseline' row on their next change.
+CREATE TABLE IF NOT EXISTS model_versions (
+    model_id VARCHAR(255) NOT NULL,
+    version INTEGER NOT NULL,
+    change TEXT NOT NULL CHECK (change IN ('baseline', 'created', 'updated', 'deleted')),
+    definition JSONB NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL,
+    changed_by TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (model_id, version)
+);
@@ -0,0 +1,6 @@
+-- sql/014_add_model_telemetry_definitions.sql
+
+-- Telemetry definitions (name -> {unit, description, retention}) declared by the model. The
+-- retention worker uses their retention to prune telemetry per name instead of one global period.
+ALTER TABLE twin_models
+    ADD COLUMN IF NOT EXISTS telemetry_definitions JSONB NOT NULL DEFAULT '{}'::jsonb;
@@ -0,0 +1,12 @@
+-- sql/015_add_twin_location.sql
+
+-- Optional twin position (WGS 84 decimal degrees) searched by GET /twins/near. A plain lat/lng
+-- pair keeps PostGIS optional: the store prefilters by bounding box on this index and the exact
+-- (Haversine) distance is computed by the API.
+ALTER TABLE twin_instances
+    ADD COLUMN IF NOT EXISTS location_lat DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS location_lng DOUBLE PRECISION;
+
+CREATE INDEX IF NOT EXISTS idx_twin_instances_location
+    ON twin_instances (location_lat, location_lng)
+    WHERE location_lat IS NOT NULL;
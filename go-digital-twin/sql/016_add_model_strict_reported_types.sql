@@ -0,0 +1,6 @@
+-- sql/016_add_model_strict_reported_types.sql
+
+-- Per-model type stability of reported properties: when set, reported property updates that
+-- change a property's type (or break its declared schema) are rejected instead of stored.
+ALTER TABLE twin_models
+    ADD COLUMN IF NOT EXISTS strict_reported_types BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,6 @@
+-- sql/017_add_model_tag_schema.sql
+
+-- Per-model tag schema (tag key -> {required, values, pattern}): twins of the model must carry the
+-- required tags, with values from the allowed set or matching the pattern. '{}' means free-form tags.
+ALTER TABLE twin_models
+    ADD COLUMN IF NOT EXISTS tag_schema JSONB NOT NULL DEFAULT '{}'::jsonb;
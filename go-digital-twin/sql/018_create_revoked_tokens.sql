@@ -0,0 +1,9 @@
+-- sql/018_create_revoked_tokens.sql
+
+-- Revocation list of device tokens. Tokens are signed by the server and never stored; a revoked
+-- token's ID is kept here until the token would have expired anyway (until), then pruned.
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    id VARCHAR(64) PRIMARY KEY,
+    revoked_at TIMESTAMPTZ NOT NULL,
+    until TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,7 @@
+-- sql/019_add_telemetry_written_by.sql
+
+-- Who wrote each telemetry point: the API key name or device token of the request (see
+-- auth.Principal.Actor). NULL for anonymous (unauthenticated) and system writes, and for points
+-- written before this migration.
+ALTER TABLE telemetry
+    ADD COLUMN IF NOT EXISTS written_by TEXT;
@@ -0,0 +1,57 @@
+-- sql/020_create_outbox.sql
+
+-- Transactional outbox: one row per twin or model change, written by triggers in the same
+-- transaction as the change, so committed changes are never lost and rolled-back ones never
+-- announced. The relay (pkg/outbox) claims unsent rows (claimed_until is its lease), publishes
+-- them and sets sent_at; sent rows are pruned after OUTBOX_RETENTION.
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    subject_id VARCHAR(255) NOT NULL,
+    model_id VARCHAR(255) NOT NULL DEFAULT '',
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    claimed_until TIMESTAMPTZ,
+    sent_at TIMESTAMPTZ
+);
+
+-- The relay scans pending rows in ID order
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (id) WHERE sent_at IS NULL;
+
+CREATE OR REPLACE FUNCTION outbox_record_twin_change()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    INSERT INTO outbox (event_type, subject_id, model_id) VALUES ('twin.deleted', OLD.id, OLD.model_id);
+    RETURN OLD;
+  END IF;
+  INSERT INTO outbox (event_type, subject_id, model_id)
+  VALUES (CASE TG_OP WHEN 'INSERT' THEN 'twin.created' ELSE 'twin.updated' END, NEW.id, NEW.model_id);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION outbox_record_model_change()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    INSERT INTO outbox (event_type, subject_id, model_id) VALUES ('model.deleted', OLD.id, OLD.id);
+    RETURN OLD;
+  END IF;
+  INSERT INTO outbox (event_type, subject_id, model_id)
+  VALUES (CASE TG_OP WHEN 'INSERT' THEN 'model.created' ELSE 'model.updated' END, NEW.id, NEW.id);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS outbox_change ON twin_instances;
+CREATE TRIGGER outbox_change
+AFTER INSERT OR UPDATE OR DELETE ON twin_instances
+FOR EACH ROW
+EXECUTE FUNCTION outbox_record_twin_change();
+
+DROP TRIGGER IF EXISTS outbox_change ON twin_models;
+CREATE TRIGGER outbox_change
+AFTER INSERT OR UPDATE OR DELETE ON twin_models
+FOR EACH ROW
+EXECUTE FUNCTION outbox_record_model_change();
@@ -0,0 +1,59 @@
+-- sql/021_create_webhooks.sql
+
+-- Webhook subscriptions: the outbox relay queues a delivery per matching event and webhook, and
+-- the dispatcher (pkg/webhook) POSTs them with retries. An empty event_types matches every type;
+-- empty twin_id/model_id don't filter. The secret signs payloads (HMAC-SHA256) and is never returned.
+CREATE TABLE IF NOT EXISTS webhooks (
+    id VARCHAR(255) PRIMARY KEY,
+    url TEXT NOT NULL,
+    event_types JSONB NOT NULL DEFAULT '[]',
+    twin_id VARCHAR(255) NOT NULL DEFAULT '',
+    model_id VARCHAR(255) NOT NULL DEFAULT '',
+    secret TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- One row per (webhook, outbox event): the event is copied, as outbox rows are pruned sooner.
+-- Pending rows are attempted at next_attempt_at (also the dispatcher's lease); finished ones are
+-- pruned after WEBHOOK_DELIVERY_RETENTION.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id VARCHAR(255) NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id BIGINT NOT NULL,
+    event_type TEXT NOT NULL,
+    subject_id VARCHAR(255) NOT NULL,
+    model_id VARCHAR(255) NOT NULL DEFAULT '',
+    occurred_at TIMESTAMPTZ NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_status_code INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id);
+
+-- Twin updates that touch nothing but the reported properties (device reports) are announced as
+-- twin.reported, so subscribers can tell them from twin.updated
+CREATE OR REPLACE FUNCTION outbox_record_twin_change()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    INSERT INTO outbox (event_type, subject_id, model_id) VALUES ('twin.deleted', OLD.id, OLD.model_id);
+    RETURN OLD;
+  END IF;
+  IF TG_OP = 'INSERT' THEN
+    INSERT INTO outbox (event_type, subject_id, model_id) VALUES ('twin.created', NEW.id, NEW.model_id);
+  ELSIF to_jsonb(NEW) - 'reported_properties' - 'updated_at' = to_jsonb(OLD) - 'reported_properties' - 'updated_at' THEN
+    INSERT INTO outbox (event_type, subject_id, model_id) VALUES ('twin.reported', NEW.id, NEW.model_id);
+  ELSE
+    INSERT INTO outbox (event_type, subject_id, model_id) VALUES ('twin.updated', NEW.id, NEW.model_id);
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
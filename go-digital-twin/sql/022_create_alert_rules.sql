@@ -0,0 +1,35 @@
+-- sql/022_create_alert_rules.sql
+
+-- Threshold alert rules on the latest telemetry: the evaluator (pkg/alert) checks
+-- "metric operator value" for each twin in scope (twin_id, or every twin of model_id) and fires
+-- once the condition held for duration (a Go duration; empty fires at once).
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id VARCHAR(255) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    model_id VARCHAR(255) NOT NULL DEFAULT '',
+    twin_id VARCHAR(255) NOT NULL DEFAULT '',
+    metric TEXT NOT NULL,
+    operator TEXT NOT NULL CHECK (operator IN ('>', '>=', '<', '<=', '==', '!=')),
+    value DOUBLE PRECISION NOT NULL,
+    duration TEXT NOT NULL DEFAULT '',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Per (rule, twin) state, so each transition is announced once: pending while the condition
+-- holds for less than the duration, firing after, resolved once it stopped holding. since is
+-- when the current state began; value the metric's value at the last transition.
+CREATE TABLE IF NOT EXISTS alert_states (
+    rule_id VARCHAR(255) NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+    twin_id VARCHAR(255) NOT NULL,
+    state TEXT NOT NULL CHECK (state IN ('pending', 'firing', 'resolved')),
+    since TIMESTAMPTZ NOT NULL,
+    value DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (rule_id, twin_id)
+);
+
+-- alert.triggered and alert.resolved events name their rule
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS rule_id VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS rule_id VARCHAR(255) NOT NULL DEFAULT '';
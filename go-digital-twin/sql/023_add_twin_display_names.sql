@@ -0,0 +1,10 @@
+-- sql/023_add_twin_display_names.sql
+
+-- Human-readable twin labels for UIs; existing twins get none (''). GET /twins?sort=displayName
+-- pages by (display_name, id) in byte order, which this index serves.
+ALTER TABLE twin_instances
+    ADD COLUMN IF NOT EXISTS display_name TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_twin_instances_display_name
+    ON twin_instances (display_name COLLATE "C", id);
@@ -0,0 +1,25 @@
+-- sql/024_make_updated_at_trigger_conditional.sql
+
+-- Who sets updated_at of twin_models, twin_instances and twin_templates on UPDATE is the
+-- session's choice (UPDATED_AT_SOURCE, see persistence.Config.UpdatedAtSource):
+--
+--   * By default the database owns it: the set_timestamp triggers stamp every updated row with
+--     NOW(), whatever the statement set, as before.
+--   * Sessions with digital_twin.updated_at_source = 'application' own it: the row keeps the
+--     updated_at the statement wrote, or its old value when the statement didn't set one. The
+--     server sets this on its connections when UPDATED_AT_SOURCE=application; data migrations can
+--     set it in their own session to preserve timestamps:
+--
+--         SET digital_twin.updated_at_source = 'application';
+--
+-- The triggers themselves (sql/001, 002, 006) are unchanged; they call this function.
+CREATE OR REPLACE FUNCTION trigger_set_timestamp()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF current_setting('digital_twin.updated_at_source', true) = 'application' THEN
+    RETURN NEW;
+  END IF;
+  NEW.updated_at = NOW();
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,11 @@
+-- sql/025_add_telemetry_bucket_stats.sql
+
+-- Points written by live downsampling (see model.TelemetryDefinition.Downsample) keep the
+-- statistics of their bucket, so points of the bucket that arrive after it was written (after a
+-- restart, or on another replica) are merged into it rather than dropped. Every other point, and
+-- buckets written before this migration, have none (NULL) and are never merged into.
+ALTER TABLE telemetry
+    ADD COLUMN IF NOT EXISTS bucket_count BIGINT,
+    ADD COLUMN IF NOT EXISTS bucket_sum DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS bucket_min DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS bucket_max DOUBLE PRECISION;